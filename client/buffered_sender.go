@@ -0,0 +1,247 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Returned by BufferedSender.SendMessage when the message doesn't fit in the
+// buffer. The message is dropped.
+var ErrBufferFull = errors.New("send buffer is full")
+
+// Returned by BufferedSender.SendMessage after Close has been called.
+var ErrSenderClosed = errors.New("sender is closed")
+
+const (
+	defaultMaxBufferSize = 8 * 1024 * 1024
+	defaultMaxRetryDelay = 30 * time.Second
+	defaultCloseTimeout  = 5 * time.Second
+	initialRetryDelay    = 100 * time.Millisecond
+	dialTimeout          = 5 * time.Second
+)
+
+type BufferedSenderConfig struct {
+	// Network and address to connect to, e.g. "tcp" and "localhost:5565".
+	Proto   string
+	Address string
+	// Connects with TLS if set.
+	TlsConfig *tls.Config
+	// Most bytes of messages to hold while they can't be sent, defaults to
+	// 8MiB.
+	MaxBufferSize int
+	// Longest delay between connection attempts, which back off
+	// exponentially from 100ms. Defaults to 30s.
+	MaxRetryDelay time.Duration
+	// How long Close waits for the buffered messages to be sent, defaults
+	// to 5s.
+	CloseTimeout time.Duration
+}
+
+// BufferedSender is a Sender that never blocks the caller on the network.
+// Messages are buffered in memory and written by a separate goroutine, which
+// connects lazily and reconnects with exponential back-off whenever the
+// connection fails, so a Heka restart or a network blip only costs the
+// messages that don't fit in the buffer. A message whose write failed is
+// sent again on the next connection; Heka's stream framing lets the receiver
+// skip any partially written copy.
+type BufferedSender struct {
+	// Accessed atomically.
+	droppedCount int64
+
+	config   BufferedSenderConfig
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    [][]byte
+	size     int
+	closing  bool
+	conn     net.Conn
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// Creates a BufferedSender and starts its sending goroutine. No connection
+// is made until the first message is sent.
+func NewBufferedSender(config BufferedSenderConfig) *BufferedSender {
+	if config.MaxBufferSize <= 0 {
+		config.MaxBufferSize = defaultMaxBufferSize
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = defaultMaxRetryDelay
+	}
+	if config.CloseTimeout <= 0 {
+		config.CloseTimeout = defaultCloseTimeout
+	}
+	s := &BufferedSender{
+		config:   config,
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.lock)
+	go s.run()
+	return s
+}
+
+// Queues a copy of the provided bytes for sending, returning ErrBufferFull
+// if they don't fit in the buffer.
+func (s *BufferedSender) SendMessage(outBytes []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return ErrSenderClosed
+	}
+	if s.size+len(outBytes) > s.config.MaxBufferSize {
+		atomic.AddInt64(&s.droppedCount, 1)
+		return ErrBufferFull
+	}
+	record := make([]byte, len(outBytes))
+	copy(record, outBytes)
+	s.queue = append(s.queue, record)
+	s.size += len(record)
+	s.cond.Signal()
+	return nil
+}
+
+// Returns the number of bytes waiting to be sent.
+func (s *BufferedSender) Buffered() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+// Returns the number of messages dropped because the buffer was full.
+func (s *BufferedSender) DroppedCount() int64 {
+	return atomic.LoadInt64(&s.droppedCount)
+}
+
+// Stops accepting messages and waits up to the CloseTimeout for the buffered
+// ones to be sent before closing the connection. Messages still buffered
+// after that are discarded.
+func (s *BufferedSender) Close() {
+	s.lock.Lock()
+	if s.closing {
+		s.lock.Unlock()
+		return
+	}
+	s.closing = true
+	s.cond.Signal()
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return
+	case <-time.After(s.config.CloseTimeout):
+	}
+	close(s.stopChan)
+	s.lock.Lock()
+	if s.conn != nil {
+		// Unblocks a pending write.
+		s.conn.Close()
+	}
+	s.lock.Unlock()
+	<-s.done
+}
+
+func (s *BufferedSender) dial() (net.Conn, error) {
+	if s.config.TlsConfig != nil {
+		dialer := &net.Dialer{Timeout: dialTimeout}
+		return tls.DialWithDialer(dialer, s.config.Proto, s.config.Address,
+			s.config.TlsConfig)
+	}
+	return net.DialTimeout(s.config.Proto, s.config.Address, dialTimeout)
+}
+
+func (s *BufferedSender) stopped() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *BufferedSender) setConn(conn net.Conn) {
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+}
+
+func (s *BufferedSender) run() {
+	defer close(s.done)
+	var conn net.Conn
+	delay := initialRetryDelay
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		s.lock.Lock()
+		for len(s.queue) == 0 && !s.closing {
+			s.cond.Wait()
+		}
+		if len(s.queue) == 0 {
+			// Closing and everything has been sent.
+			s.lock.Unlock()
+			return
+		}
+		record := s.queue[0]
+		s.lock.Unlock()
+
+		if s.stopped() {
+			return
+		}
+		if conn == nil {
+			var err error
+			if conn, err = s.dial(); err != nil {
+				conn = nil
+				LogError.Printf("Can't connect to %s: %s", s.config.Address, err)
+				select {
+				case <-time.After(delay):
+				case <-s.stopChan:
+					return
+				}
+				if delay *= 2; delay > s.config.MaxRetryDelay {
+					delay = s.config.MaxRetryDelay
+				}
+				continue
+			}
+			s.setConn(conn)
+			if s.stopped() {
+				return
+			}
+			delay = initialRetryDelay
+		}
+
+		if _, err := conn.Write(record); err != nil {
+			if !s.stopped() {
+				LogError.Printf("Can't send to %s: %s", s.config.Address, err)
+			}
+			conn.Close()
+			conn = nil
+			s.setConn(nil)
+			continue
+		}
+
+		s.lock.Lock()
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.size -= len(record)
+		s.lock.Unlock()
+	}
+}
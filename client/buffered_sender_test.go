@@ -0,0 +1,133 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+func readAll(t *testing.T, listener net.Listener, n int) []byte {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %s", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	return buf
+}
+
+func TestBufferedSenderSends(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer listener.Close()
+
+	sender := NewBufferedSender(BufferedSenderConfig{
+		Proto:   "tcp",
+		Address: listener.Addr().String(),
+	})
+	c := NewClient(sender, NewProtobufEncoder(nil))
+	var expected []byte
+	for _, typ := range []string{"one", "two", "three"} {
+		msg := &message.Message{}
+		msg.SetType(typ)
+		msg.SetTimestamp(1416840893000000000)
+		if err = c.SendMessage(msg); err != nil {
+			t.Fatalf("SendMessage failed: %s", err)
+		}
+		var framed []byte
+		NewProtobufEncoder(nil).EncodeMessageStream(msg, &framed)
+		expected = append(expected, framed...)
+	}
+
+	received := readAll(t, listener, len(expected))
+	if !bytes.Equal(expected, received) {
+		t.Errorf("expected: %#v received: %#v", expected, received)
+	}
+	sender.Close()
+	if sender.Buffered() != 0 {
+		t.Errorf("expected an empty buffer, %d bytes buffered", sender.Buffered())
+	}
+}
+
+func TestBufferedSenderReconnects(t *testing.T) {
+	// Reserve an address nobody's listening on yet.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sender := NewBufferedSender(BufferedSenderConfig{
+		Proto:         "tcp",
+		Address:       address,
+		MaxRetryDelay: 100 * time.Millisecond,
+	})
+	defer sender.Close()
+	if err = sender.SendMessage([]byte("buffered")); err != nil {
+		t.Fatalf("SendMessage failed: %s", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if sender.Buffered() != len("buffered") {
+		t.Errorf("expected the message to be buffered, %d bytes buffered",
+			sender.Buffered())
+	}
+
+	if listener, err = net.Listen("tcp", address); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	defer listener.Close()
+	if received := readAll(t, listener, len("buffered")); string(received) != "buffered" {
+		t.Errorf("expected 'buffered', received '%s'", received)
+	}
+}
+
+func TestBufferedSenderBufferFull(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sender := NewBufferedSender(BufferedSenderConfig{
+		Proto:         "tcp",
+		Address:       address,
+		MaxBufferSize: 10,
+		CloseTimeout:  10 * time.Millisecond,
+	})
+	if err = sender.SendMessage([]byte("12345678")); err != nil {
+		t.Fatalf("SendMessage failed: %s", err)
+	}
+	if err = sender.SendMessage([]byte("123")); err != ErrBufferFull {
+		t.Errorf("expected ErrBufferFull, got %v", err)
+	}
+	if sender.DroppedCount() != 1 {
+		t.Errorf("expected 1 dropped message, got %d", sender.DroppedCount())
+	}
+	sender.Close()
+	if err = sender.SendMessage([]byte("1")); err != ErrSenderClosed {
+		t.Errorf("expected ErrSenderClosed, got %v", err)
+	}
+}
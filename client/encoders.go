@@ -79,6 +79,9 @@ func CreateHekaStream(msgBytes []byte, outBytes *[]byte,
 			hm = hmac.New(sha1.New, []byte(msc.Key))
 			h.SetHmacHashFunction(message.Header_SHA1)
 		default:
+			if err := message.CheckHmacHash(message.Header_MD5); err != nil {
+				return err
+			}
 			hm = hmac.New(md5.New, []byte(msc.Key))
 		}
 
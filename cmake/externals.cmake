@@ -163,10 +163,16 @@ git_clone(https://github.com/thoj/go-ircevent 90dc7f966b95d133f1c65531c6959b52ef
 git_clone(https://github.com/cactus/gostrftime d329f83c5ce9c416f8983f0a0044734db54ee24d)
 
 git_clone(https://github.com/golang/snappy 723cc1e459b8eea2dea4583200fd60757d40097a)
+git_clone(https://github.com/pierrec/lz4 v2.0.5)
+git_clone(https://github.com/DataDog/zstd v1.4.8)
 git_clone(https://github.com/eapache/go-resiliency v1.0.0)
 git_clone(https://github.com/eapache/queue v1.0.2)
 git_clone_to_path(https://github.com/rafrombrc/sarama f742e1e20b15b31320e0b6ff2f995bc5f0482fed github.com/Shopify/sarama)
 git_clone(https://github.com/davecgh/go-spew 2df174808ee097f90d259e432cc04442cf60be21)
+git_clone(https://github.com/garyburd/redigo v1.0.0)
+git_clone(https://github.com/AdRoll/goamz e0af8b0b22517e9fb1d6a4438fa8269c3e834d2d)
+git_clone(https://github.com/go-sql-driver/mysql v1.2)
+git_clone(https://github.com/lib/pq v1.0.0)
 
 add_dependencies(sarama snappy)
 
@@ -180,7 +186,6 @@ endif()
 
 if (INCLUDE_MOZSVC)
     #git_clone(https://github.com/bitly/go-simplejson ec501b3f691bcc79d97caf8fdf28bcf136efdab8)
-    git_clone(https://github.com/AdRoll/goamz e0af8b0b22517e9fb1d6a4438fa8269c3e834d2d)
     git_clone(https://github.com/feyeleanor/raw 724aedf6e1a5d8971aafec384b6bde3d5608fba4)
     git_clone(https://github.com/feyeleanor/slices bb44bb2e4817fe71ba7082d351fd582e7d40e3ea)
     add_dependencies(slices raw)
@@ -0,0 +1,60 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+
+A command-line utility for verifying the integrity of FileOutput archives
+against the checksum manifests written alongside them.
+
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mozilla-services/heka/plugins/file"
+)
+
+func main() {
+	flagManifest := flag.String("manifest", "",
+		"manifest filename, defaults to the archive filename + '"+file.ManifestSuffix+"'")
+	flagQuiet := flag.Bool("quiet", false, "only report failures")
+	flag.Parse()
+
+	if flag.NArg() == 0 || (*flagManifest != "" && flag.NArg() != 1) {
+		fmt.Fprintln(os.Stderr, "usage: heka-verify [-manifest=<file>] [-quiet] <archive>...")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, path := range flag.Args() {
+		manifestPath := *flagManifest
+		if manifestPath == "" {
+			manifestPath = path + file.ManifestSuffix
+		}
+		result, err := file.VerifyArchive(path, manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: FAILED - %s\n", path, err)
+			failed++
+			continue
+		}
+		if !*flagQuiet {
+			fmt.Printf("%s: OK - %d chunks, bytes %d-%d of %d verified\n", path,
+				result.Chunks, result.Start, result.End, result.Size)
+		}
+	}
+	if failed > 0 {
+		os.Exit(2)
+	}
+}
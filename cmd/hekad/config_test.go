@@ -16,13 +16,18 @@
 package main
 
 import (
+	"github.com/mozilla-services/heka/hekad"
 	"github.com/mozilla-services/heka/pipeline"
 	"github.com/mozilla-services/heka/plugins"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
 	"testing"
 )
 
 func TestDecode(t *testing.T) {
-	_, err := LoadHekadConfig("../../pipeline/testsupport/sample-config.toml")
+	_, err := hekad.LoadHekadConfig("../../pipeline/testsupport/sample-config.toml")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -31,22 +36,26 @@ func TestDecode(t *testing.T) {
 func TestCustomHostname(t *testing.T) {
 	expected := "my.example.com"
 	configPath := "../../pipeline/testsupport/sample-hostname.toml"
-	config, err := LoadHekadConfig(configPath)
+	config, err := hekad.LoadHekadConfig(configPath)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if config.Hostname != expected {
 		t.Fatalf("HekadConfig.Hostname expected: '%s', Got: %s", expected, config.Hostname)
 	}
-	globals, _, _ := setGlobalConfigs(config)
+	p, err := hekad.NewPipeline(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals := p.Globals()
 	if globals.Hostname != expected {
 		t.Fatalf("globals.Hostname expected: '%s', Got: %s", expected, globals.Hostname)
 	}
-	pConfig := pipeline.NewPipelineConfig(globals)
+	pConfig := p.PipelineConfig()
 	if pConfig.Hostname() != expected {
 		t.Fatalf("PipelineConfig.Hostname expected: '%s', Got: %s", expected, pConfig.Hostname())
 	}
-	err = loadFullConfig(pConfig, &configPath)
+	err = hekad.LoadPipelineConfig(pConfig, configPath)
 	if err != nil {
 		t.Fatalf("Error loading full config: %s", err.Error())
 	}
@@ -64,7 +73,7 @@ func TestLoadDir(t *testing.T) {
 
 	pipeConfig := pipeline.NewPipelineConfig(nil)
 	confDirPath := "../../plugins/testsupport/config_dir"
-	err := loadFullConfig(pipeConfig, &confDirPath)
+	err := hekad.LoadPipelineConfig(pipeConfig, confDirPath)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,3 +119,50 @@ func TestLoadDir(t *testing.T) {
 		t.Fatal("`not_loaded` filter *was* loaded, shouldn't have been!")
 	}
 }
+
+func TestProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hekad-config-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "hekad.toml")
+
+	contents := "[hekad]\nprofile = \"throughput\"\npoolsize = 150\nmax_threads = 500\n"
+	if err = ioutil.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := hekad.LoadHekadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GoGC != 400 || config.ChanSize != 100 {
+		t.Fatalf("Profile defaults not applied, gogc: %d, plugin_chansize: %d",
+			config.GoGC, config.ChanSize)
+	}
+	if config.PoolSize != 150 {
+		t.Fatalf("Expected poolsize to override the profile, got %d", config.PoolSize)
+	}
+	p, err := hekad.NewPipeline(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals := p.Globals()
+	defer func() {
+		debug.SetGCPercent(100)
+		debug.SetMaxThreads(10000)
+	}()
+	if globals.Profile != "throughput" || globals.GoGC != 400 ||
+		globals.MaxThreads != 500 || globals.PluginChanSize != 100 {
+
+		t.Fatalf("Unexpected globals: %+v", globals)
+	}
+
+	contents = "[hekad]\nprofile = \"fast\"\n"
+	if err = ioutil.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = hekad.LoadHekadConfig(configPath); err == nil {
+		t.Fatal("Expected an error for an unknown profile")
+	}
+}
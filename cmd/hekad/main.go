@@ -14,8 +14,8 @@
 
 /*
 
-Main entry point for the `hekad` daemon. Loads the specified config and calls
-`pipeline.Run` to launch the PluginRunners and all additional goroutines.
+Main entry point for the `hekad` daemon. Loads the specified config and uses
+the `hekad` package to launch the PluginRunners and all additional goroutines.
 
 */
 package main
@@ -31,67 +31,44 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
-	"time"
 
-	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/hekad"
 	"github.com/mozilla-services/heka/pipeline"
 	_ "github.com/mozilla-services/heka/plugins"
 	_ "github.com/mozilla-services/heka/plugins/amqp"
+	_ "github.com/mozilla-services/heka/plugins/aws"
 	_ "github.com/mozilla-services/heka/plugins/dasher"
 	_ "github.com/mozilla-services/heka/plugins/elasticsearch"
 	_ "github.com/mozilla-services/heka/plugins/file"
+	_ "github.com/mozilla-services/heka/plugins/fluentd"
+	_ "github.com/mozilla-services/heka/plugins/gelf"
 	_ "github.com/mozilla-services/heka/plugins/graphite"
 	_ "github.com/mozilla-services/heka/plugins/http"
 	_ "github.com/mozilla-services/heka/plugins/irc"
 	_ "github.com/mozilla-services/heka/plugins/kafka"
 	_ "github.com/mozilla-services/heka/plugins/logstreamer"
+	_ "github.com/mozilla-services/heka/plugins/mqtt"
 	_ "github.com/mozilla-services/heka/plugins/nagios"
+	_ "github.com/mozilla-services/heka/plugins/parquet"
 	_ "github.com/mozilla-services/heka/plugins/payload"
 	_ "github.com/mozilla-services/heka/plugins/process"
+	_ "github.com/mozilla-services/heka/plugins/protobuf"
+	_ "github.com/mozilla-services/heka/plugins/redis"
+	_ "github.com/mozilla-services/heka/plugins/sketch"
 	_ "github.com/mozilla-services/heka/plugins/smtp"
+	_ "github.com/mozilla-services/heka/plugins/sql"
 	_ "github.com/mozilla-services/heka/plugins/statsd"
 	_ "github.com/mozilla-services/heka/plugins/tcp"
+	_ "github.com/mozilla-services/heka/plugins/traceroute"
 	_ "github.com/mozilla-services/heka/plugins/udp"
+	_ "github.com/mozilla-services/heka/plugins/useragent"
+	_ "github.com/mozilla-services/heka/plugins/zstd"
 )
 
 const (
 	VERSION = "0.11.0"
 )
 
-func setGlobalConfigs(config *HekadConfig) (*pipeline.GlobalConfigStruct, string, string) {
-	maxprocs := config.Maxprocs
-	poolSize := config.PoolSize
-	chanSize := config.ChanSize
-	cpuProfName := config.CpuProfName
-	memProfName := config.MemProfName
-	maxMsgLoops := config.MaxMsgLoops
-	maxMsgProcessInject := config.MaxMsgProcessInject
-	maxMsgProcessDuration := config.MaxMsgProcessDuration
-	maxMsgTimerInject := config.MaxMsgTimerInject
-	maxPackIdle, _ := time.ParseDuration(config.MaxPackIdle)
-
-	runtime.GOMAXPROCS(maxprocs)
-
-	globals := pipeline.DefaultGlobals()
-	globals.PoolSize = poolSize
-	globals.PluginChanSize = chanSize
-	globals.MaxMsgLoops = maxMsgLoops
-	if globals.MaxMsgLoops == 0 {
-		globals.MaxMsgLoops = 1
-	}
-	globals.MaxMsgProcessInject = maxMsgProcessInject
-	globals.MaxMsgProcessDuration = maxMsgProcessDuration
-	globals.MaxMsgTimerInject = maxMsgTimerInject
-	globals.MaxPackIdle = maxPackIdle
-	globals.BaseDir = config.BaseDir
-	globals.ShareDir = config.ShareDir
-	globals.SampleDenominator = config.SampleDenominator
-	globals.Hostname = config.Hostname
-	globals.FullBufferMaxRetries = uint(config.FullBufferMaxRetries)
-
-	return globals, cpuProfName, memProfName
-}
-
 func main() {
 	exitCode := 0
 	// `os.Exit` will skip any registered deferred functions, so to support
@@ -106,19 +83,28 @@ func main() {
 		"Config file or directory. If directory is specified then all files "+
 			"in the directory will be loaded.")
 	version := flag.Bool("version", false, "Output version and exit")
+	supervise := flag.Bool("supervise", false,
+		"Run each subdirectory of the config directory as a separate hekad "+
+			"process, restarting any that fail.")
+	testConfig := flag.Bool("test-config", false,
+		"Load the config, including running any decoder test fixtures, "+
+			"report whether it's valid and exit.")
+	oneShot := flag.Bool("oneshot", false,
+		"Process the inputs' data to completion, drain the filters and "+
+			"outputs and exit.")
 	flag.Parse()
 
-	config := &HekadConfig{}
-	var err error
-	var cpuProfName string
-	var memProfName string
-
 	if *version {
 		fmt.Println(VERSION)
 		return
 	}
 
-	config, err = LoadHekadConfig(*configPath)
+	if *supervise {
+		exitCode = runSupervisor(*configPath)
+		return
+	}
+
+	config, err := hekad.LoadHekadConfig(*configPath)
 	if err != nil {
 		pipeline.LogError.Println("Error reading config: ", err)
 		exitCode = 1
@@ -126,34 +112,36 @@ func main() {
 	}
 	pipeline.LogInfo.SetFlags(config.LogFlags)
 	pipeline.LogError.SetFlags(config.LogFlags)
-	if config.SampleDenominator <= 0 {
-		pipeline.LogError.Println("'sample_denominator' value must be greater than 0.")
-		exitCode = 1
-		return
+	if *oneShot {
+		config.OneShot = true
 	}
 
-	if _, err = time.ParseDuration(config.MaxPackIdle); err != nil {
-		pipeline.LogError.Printf("Can't parse `max_pack_idle` time duration: %s\n",
-			config.MaxPackIdle)
+	p, err := hekad.NewPipeline(config)
+	if err != nil {
+		pipeline.LogError.Println("Error:", err)
 		exitCode = 1
 		return
 	}
 
-	globals, cpuProfName, memProfName := setGlobalConfigs(config)
-
-	if err = os.MkdirAll(globals.BaseDir, 0755); err != nil {
-		pipeline.LogError.Printf("Error creating 'base_dir' %s: %s", config.BaseDir, err)
-		exitCode = 1
+	if *testConfig {
+		if err = p.LoadConfig(*configPath); err != nil {
+			pipeline.LogError.Println("Error reading config: ", err)
+			exitCode = 1
+			return
+		}
+		pipeline.LogInfo.Println("Configuration OK")
 		return
 	}
 
-	if config.MaxMessageSize > 1024 {
-		message.SetMaxMessageSize(config.MaxMessageSize)
-	} else if config.MaxMessageSize > 0 {
-		pipeline.LogError.Println("Error: 'max_message_size' setting must be greater than 1024.")
+	// Take over the listeners of the hekad we're upgrading, if any, which
+	// has shut down by the time this returns.
+	oldPid, err := p.TakeOver()
+	if err != nil {
+		pipeline.LogError.Println("Error taking over from running hekad:", err)
 		exitCode = 1
 		return
 	}
+
 	if config.PidFile != "" {
 		contents, err := ioutil.ReadFile(config.PidFile)
 		if err == nil {
@@ -164,23 +152,25 @@ func main() {
 				exitCode = 1
 				return
 			}
-
-			process, err := os.FindProcess(pid)
-
-			// on Windows, err != nil if the process cannot be found
-			if runtime.GOOS == "windows" {
-				if err == nil {
-					pipeline.LogError.Printf("Process %d is already running.", pid)
-					exitCode = 1
-					return
-				}
-			} else if process != nil {
-				// err is always nil on POSIX, so we have to send the process
-				// a signal to check whether it exists
-				if err = process.Signal(syscall.Signal(0)); err == nil {
-					pipeline.LogError.Printf("Process %d is already running.", pid)
-					exitCode = 1
-					return
+			// The process we took over from may still be exiting.
+			if pid != oldPid {
+				process, err := os.FindProcess(pid)
+
+				// on Windows, err != nil if the process cannot be found
+				if runtime.GOOS == "windows" {
+					if err == nil {
+						pipeline.LogError.Printf("Process %d is already running.", pid)
+						exitCode = 1
+						return
+					}
+				} else if process != nil {
+					// err is always nil on POSIX, so we have to send the process
+					// a signal to check whether it exists
+					if err = process.Signal(syscall.Signal(0)); err == nil {
+						pipeline.LogError.Printf("Process %d is already running.", pid)
+						exitCode = 1
+						return
+					}
 				}
 			}
 		}
@@ -192,14 +182,19 @@ func main() {
 		}
 		pipeline.LogInfo.Printf("Wrote pid to pidfile '%s'", config.PidFile)
 		defer func() {
+			// After an upgrade the pidfile belongs to the new process.
+			contents, err := ioutil.ReadFile(config.PidFile)
+			if err == nil && strings.TrimSpace(string(contents)) != strconv.Itoa(os.Getpid()) {
+				return
+			}
 			if err = os.Remove(config.PidFile); err != nil {
 				pipeline.LogError.Printf("Unable to remove pidfile '%s': %s", config.PidFile, err)
 			}
 		}()
 	}
 
-	if cpuProfName != "" {
-		profFile, err := os.Create(cpuProfName)
+	if config.CpuProfName != "" {
+		profFile, err := os.Create(config.CpuProfName)
 		if err != nil {
 			pipeline.LogError.Println(err)
 			exitCode = 1
@@ -213,9 +208,9 @@ func main() {
 		}()
 	}
 
-	if memProfName != "" {
+	if config.MemProfName != "" {
 		defer func() {
-			profFile, err := os.Create(memProfName)
+			profFile, err := os.Create(config.MemProfName)
 			if err != nil {
 				pipeline.LogError.Fatalln(err)
 			}
@@ -224,44 +219,12 @@ func main() {
 		}()
 	}
 
-	// Set up and load the pipeline configuration and start the daemon.
-	pipeconf := pipeline.NewPipelineConfig(globals)
-	if err = loadFullConfig(pipeconf, configPath); err != nil {
+	// Load the pipeline configuration and start the daemon.
+	if err = p.LoadConfig(*configPath); err != nil {
 		pipeline.LogError.Println("Error reading config: ", err)
 		exitCode = 1
 		return
 	}
-	exitCode = pipeline.Run(pipeconf)
-}
-
-func loadFullConfig(pipeconf *pipeline.PipelineConfig, configPath *string) (err error) {
-	p, err := os.Open(*configPath)
-	if err != nil {
-		return fmt.Errorf("error opening file: %s", err.Error())
-	}
-	fi, err := p.Stat()
-	if err != nil {
-		return fmt.Errorf("can't stat file: %s", err.Error())
-	}
-
-	if fi.IsDir() {
-		files, _ := ioutil.ReadDir(*configPath)
-		for _, f := range files {
-			fName := f.Name()
-			if !strings.HasSuffix(fName, ".toml") {
-				// Skip non *.toml files in a config dir.
-				continue
-			}
-			err = pipeconf.PreloadFromConfigFile(filepath.Join(*configPath, fName))
-			if err != nil {
-				break
-			}
-		}
-	} else {
-		err = pipeconf.PreloadFromConfigFile(*configPath)
-	}
-	if err == nil {
-		err = pipeconf.LoadConfig()
-	}
-	return err
+	p.HandleSignals = true
+	exitCode = p.Run()
 }
@@ -0,0 +1,310 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+// Supervisor mode, running each pipeline defined in a config tree as a
+// separate hekad process.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mozilla-services/heka/hekad"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+const (
+	// Restart delays double with each consecutive failure up to this limit.
+	maxRestartDelay = time.Minute
+	// A pipeline that ran at least this long before failing is restarted
+	// without delay accumulated from earlier failures.
+	stableRunTime = time.Minute
+)
+
+// A pipeline run as a child process by the supervisor.
+type supervisedPipeline struct {
+	name       string
+	configPath string
+	cmd        *exec.Cmd
+	started    time.Time
+	failures   uint
+	restart    *time.Timer
+}
+
+// Pipeline exit reported by the goroutine waiting on its process.
+type pipelineExit struct {
+	p   *supervisedPipeline
+	err error
+}
+
+// Finds the pipelines defined by the subdirectories of configPath, each of
+// which holds a complete hekad configuration. Pipelines may not share a
+// base_dir or pid_file, since that would break their isolation.
+func findPipelines(configPath string) ([]*supervisedPipeline, error) {
+	fi, err := os.Stat(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", configPath)
+	}
+	entries, err := ioutil.ReadDir(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var pipelines []*supervisedPipeline
+	baseDirs := make(map[string]string)
+	pidFiles := make(map[string]string)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(configPath, name)
+		config, err := hekad.LoadHekadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline '%s': %s", name, err)
+		}
+		baseDir := filepath.Clean(config.BaseDir)
+		if other, ok := baseDirs[baseDir]; ok {
+			return nil, fmt.Errorf("pipelines '%s' and '%s' share base_dir %s",
+				other, name, baseDir)
+		}
+		baseDirs[baseDir] = name
+		if config.PidFile != "" {
+			pidFile := filepath.Clean(config.PidFile)
+			if other, ok := pidFiles[pidFile]; ok {
+				return nil, fmt.Errorf("pipelines '%s' and '%s' share pid_file %s",
+					other, name, pidFile)
+			}
+			pidFiles[pidFile] = name
+		}
+		pipelines = append(pipelines, &supervisedPipeline{name: name, configPath: path})
+	}
+	if len(pipelines) == 0 {
+		return nil, fmt.Errorf("no pipeline directories found in %s", configPath)
+	}
+	return pipelines, nil
+}
+
+// Returns how long to wait before restarting a pipeline after the given
+// number of consecutive failures.
+func restartDelay(failures uint) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	delay := time.Second
+	for i := uint(1); i < failures && delay < maxRestartDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRestartDelay {
+		delay = maxRestartDelay
+	}
+	return delay
+}
+
+// Writer prefixing each line with the name of the pipeline that wrote it.
+// Incomplete lines are held back until completed or flushed.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	buf    []byte
+	lock   *sync.Mutex
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.buf = append(pw.buf, p...)
+	for {
+		i := bytes.IndexByte(pw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := pw.writeLine(pw.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		pw.buf = pw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (pw *prefixWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(pw.prefix)+len(line))
+	out = append(append(out, pw.prefix...), line...)
+	// Writers for different pipelines share the output.
+	pw.lock.Lock()
+	_, err := pw.w.Write(out)
+	pw.lock.Unlock()
+	return err
+}
+
+func (pw *prefixWriter) flush() {
+	if len(pw.buf) > 0 {
+		pw.writeLine(append(pw.buf, '\n'))
+		pw.buf = nil
+	}
+}
+
+type supervisor struct {
+	executable string
+	pipelines  []*supervisedPipeline
+	exits      chan pipelineExit
+	restarts   chan *supervisedPipeline
+	stdout     io.Writer
+	stderr     io.Writer
+	outLock    sync.Mutex
+	errLock    sync.Mutex
+	// Number of started pipelines whose exit hasn't been handled yet.
+	running int
+}
+
+func (s *supervisor) start(p *supervisedPipeline) {
+	prefix := []byte("[" + p.name + "] ")
+	stdout := &prefixWriter{w: s.stdout, prefix: prefix, lock: &s.outLock}
+	stderr := &prefixWriter{w: s.stderr, prefix: prefix, lock: &s.errLock}
+	p.cmd = exec.Command(s.executable, "-config", p.configPath)
+	p.cmd.Stdout = stdout
+	p.cmd.Stderr = stderr
+	p.started = time.Now()
+	s.running++
+	if err := p.cmd.Start(); err != nil {
+		p.cmd = nil
+		s.exits <- pipelineExit{p, err}
+		return
+	}
+	pipeline.LogInfo.Printf("Started pipeline '%s' (pid %d)", p.name, p.cmd.Process.Pid)
+	go func() {
+		err := p.cmd.Wait()
+		stdout.flush()
+		stderr.flush()
+		s.exits <- pipelineExit{p, err}
+	}()
+}
+
+func (s *supervisor) signalAll(sig os.Signal) {
+	for _, p := range s.pipelines {
+		if p.cmd == nil {
+			continue
+		}
+		if err := p.cmd.Process.Signal(sig); err != nil {
+			pipeline.LogError.Printf("Can't signal pipeline '%s': %s", p.name, err)
+			if sig == os.Interrupt {
+				// Signals aren't supported everywhere.
+				p.cmd.Process.Kill()
+			}
+		}
+	}
+}
+
+// Runs the pipelines until the supervisor is told to shut down, restarting
+// any that fail. Pipelines that exit cleanly on their own aren't restarted.
+func (s *supervisor) run() (exitCode int) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
+		pipeline.SIGUSR1, pipeline.SIGUSR2)
+	defer signal.Stop(sigChan)
+
+	for _, p := range s.pipelines {
+		s.start(p)
+	}
+	// Number of restarts scheduled but not yet received.
+	pending := 0
+	shuttingDown := false
+
+	for pending > 0 || s.running > 0 {
+		select {
+		case sig := <-sigChan:
+			switch sig {
+			case syscall.SIGINT, syscall.SIGTERM:
+				if !shuttingDown {
+					pipeline.LogInfo.Println("Shutdown initiated.")
+					shuttingDown = true
+					s.signalAll(os.Interrupt)
+					for _, p := range s.pipelines {
+						if p.restart != nil && p.restart.Stop() {
+							p.restart = nil
+							pending--
+						}
+					}
+				}
+			default:
+				s.signalAll(sig)
+			}
+		case p := <-s.restarts:
+			pending--
+			p.restart = nil
+			if !shuttingDown {
+				s.start(p)
+			}
+		case exit := <-s.exits:
+			p := exit.p
+			s.running--
+			p.cmd = nil
+			if shuttingDown {
+				pipeline.LogInfo.Printf("Pipeline '%s' stopped", p.name)
+				continue
+			}
+			if exit.err == nil {
+				pipeline.LogInfo.Printf("Pipeline '%s' exited", p.name)
+				continue
+			}
+			if time.Since(p.started) >= stableRunTime {
+				p.failures = 0
+			}
+			p.failures++
+			delay := restartDelay(p.failures)
+			pipeline.LogError.Printf("Pipeline '%s' failed: %s; restarting in %s",
+				p.name, exit.err, delay)
+			pending++
+			p.restart = time.AfterFunc(delay, func() { s.restarts <- p })
+		}
+	}
+	return 0
+}
+
+// Runs each pipeline defined in the config tree as a child hekad process.
+func runSupervisor(configPath string) int {
+	pipelines, err := findPipelines(configPath)
+	if err != nil {
+		pipeline.LogError.Println("Error reading config: ", err)
+		return 1
+	}
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		pipeline.LogError.Println("Can't find hekad executable: ", err)
+		return 1
+	}
+	if executable, err = filepath.Abs(executable); err != nil {
+		pipeline.LogError.Println("Can't find hekad executable: ", err)
+		return 1
+	}
+	s := &supervisor{
+		executable: executable,
+		pipelines:  pipelines,
+		// Each pipeline has at most one exit outstanding, so exits never
+		// block.
+		exits:    make(chan pipelineExit, len(pipelines)),
+		restarts: make(chan *supervisedPipeline),
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	}
+	return s.run()
+}
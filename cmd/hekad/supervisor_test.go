@@ -0,0 +1,175 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writePipelineConfig(t *testing.T, dir, name, contents string) {
+	pipelineDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(pipelineDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(pipelineDir, "hekad.toml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindPipelines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supervisor-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = findPipelines(dir); err == nil {
+		t.Fatal("Expected an error for a directory without pipelines")
+	}
+
+	writePipelineConfig(t, dir, "metrics", "[hekad]\nbase_dir = \"/tmp/metrics\"\n")
+	writePipelineConfig(t, dir, "logs", "[hekad]\nbase_dir = \"/tmp/logs\"\n")
+	writePipelineConfig(t, dir, ".hidden", "")
+	ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a pipeline"), 0644)
+	pipelines, err := findPipelines(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipelines) != 2 {
+		t.Fatalf("Expected 2 pipelines, got %d", len(pipelines))
+	}
+	if pipelines[0].name != "logs" || pipelines[1].name != "metrics" {
+		t.Fatalf("Unexpected pipelines: %s, %s", pipelines[0].name, pipelines[1].name)
+	}
+	if pipelines[0].configPath != filepath.Join(dir, "logs") {
+		t.Fatalf("Unexpected config path: %s", pipelines[0].configPath)
+	}
+
+	writePipelineConfig(t, dir, "traces", "[hekad]\nbase_dir = \"/tmp/logs/\"\n")
+	_, err = findPipelines(dir)
+	if err == nil || !strings.Contains(err.Error(), "share base_dir") {
+		t.Fatalf("Expected a shared base_dir error, got: %v", err)
+	}
+
+	if _, err = findPipelines(filepath.Join(dir, "README")); err == nil {
+		t.Fatal("Expected an error for a config file")
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	expected := map[uint]time.Duration{
+		0:  0,
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		7:  maxRestartDelay,
+		50: maxRestartDelay,
+	}
+	for failures, delay := range expected {
+		if actual := restartDelay(failures); actual != delay {
+			t.Errorf("restartDelay(%d): expected %s, got %s", failures, delay, actual)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	pw := &prefixWriter{w: &buf, prefix: []byte("[logs] "), lock: new(sync.Mutex)}
+	pw.Write([]byte("first line\nsecond "))
+	pw.Write([]byte("line\nunterminated"))
+	expected := "[logs] first line\n[logs] second line\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+	pw.flush()
+	expected += "[logs] unterminated\n"
+	if buf.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestSupervisorRestartsFailedPipelines(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Requires a POSIX shell")
+	}
+	dir, err := ioutil.TempDir("", "supervisor-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Stands in for hekad, failing the first time it runs each pipeline.
+	script := filepath.Join(dir, "hekad")
+	err = ioutil.WriteFile(script, []byte(`#!/bin/sh
+if [ -e "$2/ran" ]; then
+    echo "running $2"
+    exit 0
+fi
+touch "$2/ran"
+echo "failing" >&2
+exit 3
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipelines := []*supervisedPipeline{
+		{name: "a", configPath: filepath.Join(dir, "a")},
+		{name: "b", configPath: filepath.Join(dir, "b")},
+	}
+	for _, p := range pipelines {
+		os.Mkdir(p.configPath, 0755)
+	}
+	var stdout, stderr bytes.Buffer
+	s := &supervisor{
+		executable: script,
+		pipelines:  pipelines,
+		exits:      make(chan pipelineExit, len(pipelines)),
+		restarts:   make(chan *supervisedPipeline),
+		stdout:     &stdout,
+		stderr:     &stderr,
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- s.run()
+	}()
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Fatalf("Expected exit code 0, got %d", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Supervisor didn't exit")
+	}
+
+	for _, p := range pipelines {
+		expected := "[" + p.name + "] running " + p.configPath + "\n"
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("Expected %q in output, got %q", expected, stdout.String())
+		}
+		if p.failures != 1 {
+			t.Errorf("Pipeline %s: expected 1 failure, got %d", p.name, p.failures)
+		}
+	}
+	if strings.Count(stderr.String(), "failing\n") != 2 {
+		t.Errorf("Unexpected error output: %q", stderr.String())
+	}
+}
@@ -15,7 +15,7 @@
 
 // Hekad configuration.
 
-package main
+package hekad
 
 import (
 	"fmt"
@@ -23,6 +23,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/bbangert/toml"
@@ -48,9 +49,56 @@ type HekadConfig struct {
 	MaxMessageSize        uint32 `toml:"max_message_size"`
 	LogFlags              int    `toml:"log_flags"`
 	FullBufferMaxRetries  uint32 `toml:"full_buffer_max_retries"`
+	Profile               string `toml:"profile"`
+	GoGC                  int    `toml:"gogc"`
+	MaxThreads            int    `toml:"max_threads"`
+	QueueStatsInterval    uint   `toml:"queue_stats_interval"`
+	MetricsAddress        string `toml:"metrics_address"`
+	MetricsAcl            string `toml:"metrics_acl"`
+	AdminAddress          string `toml:"admin_address"`
+	AdminAcl              string `toml:"admin_acl"`
+	FipsMode              bool   `toml:"fips_mode"`
+	WarmUpTimeout         uint   `toml:"warm_up_timeout"`
+	AccountingKey         string `toml:"accounting_key"`
+	AccountingInterval    uint   `toml:"accounting_interval"`
+	ReloadOnHup           bool   `toml:"reload_on_hup"`
+	UpgradeSocket         string `toml:"upgrade_socket"`
+	UpgradeTimeout        uint   `toml:"upgrade_timeout"`
+	OneShot               bool   `toml:"oneshot"`
 }
 
-func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
+// Sets the defaults of the runtime and channel buffer settings for the named
+// workload profile. Settings that are explicitly configured still override
+// the profile's values.
+func applyProfile(config *HekadConfig, profile string) error {
+	switch profile {
+	case "":
+	case "throughput":
+		// Fewer collections and deeper buffers, at the cost of memory and
+		// of messages spending more time queued.
+		config.Maxprocs = runtime.NumCPU()
+		config.GoGC = 400
+		config.PoolSize = 200
+		config.ChanSize = 100
+	case "latency":
+		// Shorter, more frequent collections and shallow buffers so that
+		// messages don't sit in queues.
+		config.Maxprocs = runtime.NumCPU()
+		config.GoGC = 50
+		config.PoolSize = 50
+		config.ChanSize = 10
+	default:
+		return fmt.Errorf("Unknown profile '%s', must be 'throughput' or 'latency'",
+			profile)
+	}
+	config.Profile = profile
+	return nil
+}
+
+// Returns a HekadConfig populated with the default settings, i.e. the
+// settings used for anything not specified in a config file's `[hekad]`
+// section.
+func NewHekadConfig() (config *HekadConfig, err error) {
 	hostname, err := os.Hostname()
 	if err != nil {
 		return
@@ -69,11 +117,23 @@ func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
 		BaseDir:               filepath.FromSlash("/var/cache/hekad"),
 		ShareDir:              filepath.FromSlash("/usr/share/heka"),
 		SampleDenominator:     1000,
+		WarmUpTimeout:         30,
+		AccountingInterval:    60,
+		UpgradeTimeout:        60,
 		PidFile:               "",
 		Hostname:              hostname,
 		LogFlags:              log.LstdFlags,
 		FullBufferMaxRetries:  10,
 	}
+	return
+}
+
+// Loads the `[hekad]` section from a config file, or from all of the *.toml
+// files in a config directory, on top of the default settings.
+func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
+	if config, err = NewHekadConfig(); err != nil {
+		return
+	}
 
 	var configFile map[string]toml.Primitive
 	p, err := os.Open(configPath)
@@ -115,6 +175,17 @@ func LoadHekadConfig(configPath string) (config *HekadConfig, err error) {
 	empty_ignore := map[string]interface{}{}
 	parsed_config, ok := configFile[pipeline.HEKA_DAEMON]
 	if ok {
+		// The profile has to be applied first so the rest of the section
+		// can override its defaults.
+		var profile struct {
+			Profile string `toml:"profile"`
+		}
+		if err = toml.PrimitiveDecode(parsed_config, &profile); err != nil {
+			return nil, fmt.Errorf("Can't unmarshal config: %s", err)
+		}
+		if err = applyProfile(config, profile.Profile); err != nil {
+			return nil, err
+		}
 		if err = toml.PrimitiveDecodeStrict(parsed_config, config, empty_ignore); err != nil {
 			err = fmt.Errorf("Can't unmarshal config: %s", err)
 		}
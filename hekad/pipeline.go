@@ -0,0 +1,254 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+/*
+Package hekad provides the API used to load and run a Heka pipeline. The
+`hekad` daemon is a thin wrapper around it, and other Go programs can use it
+to embed a Heka pipeline as a library. Plugins are made available by
+importing their packages, exactly as the daemon does:
+
+	import (
+		"github.com/mozilla-services/heka/hekad"
+		_ "github.com/mozilla-services/heka/plugins"
+		_ "github.com/mozilla-services/heka/plugins/tcp"
+	)
+
+	config, err := hekad.LoadHekadConfig("/etc/myapp/heka.toml")
+	...
+	p, err := hekad.NewPipeline(config)
+	...
+	if err = p.LoadConfig("/etc/myapp/heka.toml"); err != nil {
+		...
+	}
+	p.Start()
+	...
+	p.Stop()
+	exitCode := p.Wait()
+
+Much of Heka's state is process wide (registered plugins, the maximum message
+size, GOMAXPROCS and the GC settings), so only one pipeline should be run per
+process.
+*/
+package hekad
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// A Pipeline is a Heka pipeline configured by a HekadConfig, with plugins
+// loaded from Heka TOML config files, running in the current process.
+type Pipeline struct {
+	// If true, Run handles SIGINT, SIGTERM, SIGHUP, SIGUSR1 and SIGUSR2 the
+	// same way the hekad daemon does. Embedding programs usually leave this
+	// unset and call Stop instead.
+	HandleSignals bool
+
+	config   *HekadConfig
+	globals  *pipeline.GlobalConfigStruct
+	pConfig  *pipeline.PipelineConfig
+	loaded   bool
+	started  bool
+	done     chan struct{}
+	exitCode int
+}
+
+// Validates the provided config, applies its process wide runtime settings
+// and returns a new Pipeline that's ready to have its plugin config loaded.
+func NewPipeline(config *HekadConfig) (*Pipeline, error) {
+	if config.SampleDenominator <= 0 {
+		return nil, errors.New("'sample_denominator' value must be greater than 0.")
+	}
+	if config.GoGC < -1 {
+		return nil, errors.New("'gogc' value must be -1 or greater.")
+	}
+	if config.MaxThreads < 0 {
+		return nil, errors.New("'max_threads' value must not be negative.")
+	}
+	if _, err := time.ParseDuration(config.MaxPackIdle); err != nil {
+		return nil, fmt.Errorf("Can't parse `max_pack_idle` time duration: %s",
+			config.MaxPackIdle)
+	}
+	if config.MaxMessageSize > 0 && config.MaxMessageSize <= 1024 {
+		return nil, errors.New("'max_message_size' setting must be greater than 1024.")
+	}
+
+	if config.MaxMessageSize > 0 {
+		message.SetMaxMessageSize(config.MaxMessageSize)
+	}
+	if config.FipsMode {
+		message.SetFipsMode(true)
+	}
+	if message.FipsMode() {
+		pipeline.LogInfo.Println("FIPS mode enabled")
+	}
+	globals := setGlobalConfigs(config)
+	return &Pipeline{
+		config:  config,
+		globals: globals,
+		pConfig: pipeline.NewPipelineConfig(globals),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+func setGlobalConfigs(config *HekadConfig) *pipeline.GlobalConfigStruct {
+	maxPackIdle, _ := time.ParseDuration(config.MaxPackIdle)
+
+	runtime.GOMAXPROCS(config.Maxprocs)
+	if config.GoGC != 0 {
+		debug.SetGCPercent(config.GoGC)
+	}
+	if config.MaxThreads > 0 {
+		debug.SetMaxThreads(config.MaxThreads)
+	}
+
+	globals := pipeline.DefaultGlobals()
+	globals.PoolSize = config.PoolSize
+	globals.PluginChanSize = config.ChanSize
+	globals.MaxMsgLoops = config.MaxMsgLoops
+	if globals.MaxMsgLoops == 0 {
+		globals.MaxMsgLoops = 1
+	}
+	globals.MaxMsgProcessInject = config.MaxMsgProcessInject
+	globals.MaxMsgProcessDuration = config.MaxMsgProcessDuration
+	globals.MaxMsgTimerInject = config.MaxMsgTimerInject
+	globals.MaxPackIdle = maxPackIdle
+	globals.BaseDir = config.BaseDir
+	globals.ShareDir = config.ShareDir
+	globals.SampleDenominator = config.SampleDenominator
+	globals.Hostname = config.Hostname
+	globals.FullBufferMaxRetries = uint(config.FullBufferMaxRetries)
+	globals.Profile = config.Profile
+	globals.GoGC = config.GoGC
+	globals.MaxThreads = config.MaxThreads
+	globals.QueueStatsInterval = time.Duration(config.QueueStatsInterval) * time.Second
+	globals.MetricsAddress = config.MetricsAddress
+	globals.MetricsAcl = config.MetricsAcl
+	globals.AdminAddress = config.AdminAddress
+	globals.AdminAcl = config.AdminAcl
+	globals.WarmUpTimeout = time.Duration(config.WarmUpTimeout) * time.Second
+	globals.AccountingKey = config.AccountingKey
+	globals.AccountingInterval = time.Duration(config.AccountingInterval) * time.Second
+	globals.ReloadOnHup = config.ReloadOnHup
+	if config.UpgradeSocket != "" {
+		globals.UpgradeSocket = globals.PrependBaseDir(config.UpgradeSocket)
+	}
+	globals.OneShot = config.OneShot
+
+	return globals
+}
+
+// Returns the config the pipeline was created with. It must not be modified.
+func (p *Pipeline) Config() *HekadConfig {
+	return p.config
+}
+
+// Returns the pipeline's global settings. They must not be modified.
+func (p *Pipeline) Globals() *pipeline.GlobalConfigStruct {
+	return p.globals
+}
+
+// Returns the pipeline's PipelineConfig, which provides access to the loaded
+// plugins.
+func (p *Pipeline) PipelineConfig() *pipeline.PipelineConfig {
+	return p.pConfig
+}
+
+// Creates the base directory and loads and initializes all of the plugins
+// configured in the specified config file, or in all of the *.toml files in
+// the specified config directory. Must be called exactly once, before the
+// pipeline is started.
+func (p *Pipeline) LoadConfig(configPath string) error {
+	if p.loaded {
+		return errors.New("pipeline config has already been loaded")
+	}
+	p.loaded = true
+	if err := os.MkdirAll(p.globals.BaseDir, 0755); err != nil {
+		return fmt.Errorf("Error creating 'base_dir' %s: %s", p.globals.BaseDir, err)
+	}
+	err := LoadPipelineConfig(p.pConfig, configPath)
+	pipeline.CloseUnclaimedListeners()
+	return err
+}
+
+// Takes over the listening sockets of the hekad serving the configured
+// `upgrade_socket`, if any, and waits for it to shut down and hand over its
+// connections and queue buffers. Must be called before LoadConfig so the
+// plugins find them. Returns the old
+// process's pid, 0 if there was no process to take over from.
+func (p *Pipeline) TakeOver() (oldPid int, err error) {
+	if p.loaded {
+		return 0, errors.New("pipeline config has already been loaded")
+	}
+	if p.globals.UpgradeSocket == "" {
+		return 0, nil
+	}
+	return pipeline.TakeOverListeners(p.globals.UpgradeSocket,
+		time.Duration(p.config.UpgradeTimeout)*time.Second)
+}
+
+// Runs the pipeline, blocking until it has shut down, and returns the exit
+// code the pipeline stopped with.
+func (p *Pipeline) Run() int {
+	if !p.loaded {
+		pipeline.LogError.Println("Pipeline config hasn't been loaded.")
+		return 1
+	}
+	if p.started {
+		return p.Wait()
+	}
+	p.started = true
+	p.globals.IgnoreSignals = !p.HandleSignals
+	p.exitCode = pipeline.Run(p.pConfig)
+	close(p.done)
+	return p.exitCode
+}
+
+// Runs the pipeline in a separate goroutine and returns immediately.
+func (p *Pipeline) Start() {
+	go p.Run()
+}
+
+// Initiates a clean shutdown of the pipeline. Returns immediately, use Wait
+// to block until the shutdown is complete.
+func (p *Pipeline) Stop() {
+	p.globals.ShutDown(0)
+}
+
+// Blocks until a started pipeline has shut down, returning its exit code.
+func (p *Pipeline) Wait() int {
+	<-p.done
+	return p.exitCode
+}
+
+// Loads and initializes all of the plugins configured in the specified config
+// file, or in all of the *.toml files in the specified config directory, into
+// the provided PipelineConfig.
+func LoadPipelineConfig(pConfig *pipeline.PipelineConfig, configPath string) (err error) {
+	files, err := pipeline.ConfigFiles(configPath)
+	if err != nil {
+		return err
+	}
+	for _, fName := range files {
+		if err = pConfig.PreloadFromConfigFile(fName); err != nil {
+			return err
+		}
+	}
+	pConfig.ConfigPath = configPath
+	return pConfig.LoadConfig()
+}
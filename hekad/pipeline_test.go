@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package hekad
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewPipelineValidation(t *testing.T) {
+	config, err := NewHekadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SampleDenominator = 0
+	if _, err = NewPipeline(config); err == nil {
+		t.Error("Expected an error for a zero sample_denominator")
+	}
+	config.SampleDenominator = 1000
+	config.MaxPackIdle = "soon"
+	if _, err = NewPipeline(config); err == nil {
+		t.Error("Expected an error for an unparseable max_pack_idle")
+	}
+	config.MaxPackIdle = "2m"
+	config.MaxMessageSize = 512
+	if _, err = NewPipeline(config); err == nil {
+		t.Error("Expected an error for a too small max_message_size")
+	}
+}
+
+func TestPipelineStartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hekad-pipeline-tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "heka.toml")
+	contents := "[CounterFilter]\nmessage_matcher = \"Type != 'heka.counter-output'\"\n"
+	if err = ioutil.WriteFile(configPath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadHekadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.BaseDir = filepath.Join(dir, "base")
+	p, err := NewPipeline(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := p.Run(); exitCode != 1 {
+		t.Fatalf("Expected running an unloaded pipeline to fail, got %d", exitCode)
+	}
+	if err = p.LoadConfig(configPath); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.LoadConfig(configPath); err == nil {
+		t.Fatal("Expected an error loading the config twice")
+	}
+	if _, ok := p.PipelineConfig().FilterRunners["CounterFilter"]; !ok {
+		t.Fatal("CounterFilter not loaded")
+	}
+	if _, err = os.Stat(config.BaseDir); err != nil {
+		t.Fatalf("base_dir not created: %s", err)
+	}
+
+	p.Start()
+	p.Stop()
+	exited := make(chan int)
+	go func() {
+		exited <- p.Wait()
+	}()
+	select {
+	case exitCode := <-exited:
+		if exitCode != 0 {
+			t.Fatalf("Expected exit code 0, got %d", exitCode)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Pipeline didn't shut down")
+	}
+}
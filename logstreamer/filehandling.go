@@ -16,6 +16,8 @@
 package logstreamer
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -390,6 +392,96 @@ func fileMatchRegexp(logRoot, fileMatch string) *regexp.Regexp {
 	return regexp.MustCompile("^" + regexp.QuoteMeta(logRoot) + fileMatch)
 }
 
+// Name of the FileMatch capture group that holds the matched path when the
+// FileMatch was generated from glob patterns.
+const GlobPathMatchName = "FilePath"
+
+// Convert a single glob pattern (relative to the log root, using "/" as the
+// separator) into a regular expression fragment. `*` and `?` never match a
+// path separator, `**` matches any number of directories, and character
+// classes are passed through as-is.
+func globToRegexp(glob string) (string, error) {
+	sep := regexp.QuoteMeta(string(os.PathSeparator))
+	notSep := "[^" + sep + "]"
+	var buf bytes.Buffer
+	glob = filepath.ToSlash(glob)
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// `**/` matches zero or more whole directories.
+					i++
+					buf.WriteString("(?:.*" + sep + ")?")
+				} else {
+					buf.WriteString(".*")
+				}
+			} else {
+				buf.WriteString(notSep + "*")
+			}
+		case '?':
+			buf.WriteString(notSep)
+		case '/':
+			buf.WriteString(sep)
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 2 {
+				return "", fmt.Errorf("Unterminated character class in glob: %s", glob)
+			}
+			class := glob[i+1 : i+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			buf.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				buf.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return buf.String(), nil
+}
+
+// GlobsToFileMatch builds a FileMatch regular expression from a list of glob
+// patterns or plain file paths relative to the log root. The matched path is
+// captured under the GlobPathMatchName sub-expression so it can be used as a
+// differentiator, yielding one logstream per matching file.
+func GlobsToFileMatch(globs []string) (string, error) {
+	if len(globs) == 0 {
+		return "", errors.New("No glob patterns provided")
+	}
+	parts := make([]string, len(globs))
+	for i, glob := range globs {
+		glob = strings.TrimLeft(filepath.ToSlash(glob), "/")
+		if glob == "" {
+			return "", errors.New("Empty glob pattern")
+		}
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = re
+	}
+	fileMatch := fmt.Sprintf("(?P<%s>%s)$", GlobPathMatchName, strings.Join(parts, "|"))
+	if _, err := regexp.Compile(fileMatch); err != nil {
+		return "", err
+	}
+	return fileMatch, nil
+}
+
+// Journal file name for a logstream. Differentiated names may contain path
+// separators (e.g. when differentiating on a matched path), which would
+// otherwise point the journal into a non-existent sub-directory.
+func journalFileName(name string) string {
+	return strings.Replace(name, string(os.PathSeparator), "%2F", -1)
+}
+
 func NewLogstreamSet(sortPattern *SortPattern, oldest time.Duration,
 	logRoot, journalRoot string, initialTail bool) (*LogstreamSet, error) {
 	// Lowercase the actual matching keys.
@@ -474,7 +566,7 @@ func (ls *LogstreamSet) ScanForLogstreams() (result []string, errors *MultipleEr
 		// New logstream files found, attempt journal path load and setup
 		// the new logstream in the map, recording its newness in result
 		if !ok {
-			journalPath := filepath.Join(ls.journalRoot, name)
+			journalPath := filepath.Join(ls.journalRoot, journalFileName(name))
 			position, err := LogstreamLocationFromFile(journalPath)
 			if err != nil {
 				errors.AddMessage(err.Error())
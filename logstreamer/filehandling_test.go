@@ -45,6 +45,38 @@ func FilehandlingSpec(c gs.Context) {
 		})
 	})
 
+	c.Specify("Glob patterns", func() {
+		c.Specify("match a single directory level", func() {
+			fileMatch, err := GlobsToFileMatch([]string{"2010/*/access.log"})
+			c.Assume(err, gs.IsNil)
+			matchRegex := fileMatchRegexp(dirPath, fileMatch)
+			logfiles := ScanDirectoryForLogfiles(dirPath, matchRegex)
+			c.Expect(len(logfiles), gs.Equals, 3)
+		})
+
+		c.Specify("match recursively and combine patterns", func() {
+			fileMatch, err := GlobsToFileMatch([]string{"**/*.log", "subdir/file.log.[12]"})
+			c.Assume(err, gs.IsNil)
+			matchRegex := fileMatchRegexp(dirPath, fileMatch)
+			logfiles := ScanDirectoryForLogfiles(dirPath, matchRegex)
+			c.Expect(len(logfiles), gs.Equals, 18)
+
+			c.Specify("yielding one logstream per file", func() {
+				err = logfiles.PopulateMatchParts(matchRegex, make(SubmatchTranslationMap))
+				c.Assume(err, gs.IsNil)
+				mfs := FilterMultipleStreamFiles(logfiles, []string{GlobPathMatchName})
+				c.Expect(len(mfs), gs.Equals, 18)
+				_, ok := mfs[filepath.FromSlash("subdir/file.log.1")]
+				c.Expect(ok, gs.IsTrue)
+			})
+		})
+
+		c.Specify("reject an unterminated character class", func() {
+			_, err := GlobsToFileMatch([]string{"*.log.[12"})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
 	c.Specify("Populating logfile with match parts", func() {
 		logfile := Logfile{}
 
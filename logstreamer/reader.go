@@ -195,12 +195,13 @@ func (l *LogstreamLocation) Save() error {
 // Determine if a newer file is available, if it is, return the filename of it.
 func (l *Logstream) NewerFileAvailable() (file string, ok bool) {
 	/* Formula for determining if a newer file is available
+		   0. Was our open file truncated in place? Then start over in it, or
+		      in the copy holding the data we haven't read yet.
 		   1. Are we the file we think we are?
-		       NO - Find out what file we are, if we're unable to locate where we
-		            are and there's logfiles, there is a newer file available
-	                (the oldest).
-	                If we can locate where we are, update our filename with our
-	                new filename and proceed to Step 2.
+		       NO - Find out what file we are by device and inode. If we were
+		            renamed within the stream, update our filename with our
+		            new filename and proceed to Step 2. Otherwise the file that
+		            replaced us (or the oldest file) is the newer file.
 		       YES - Step 2
 		   2. Is there a newer file in our list ahead of us?
 		       NO - No newer file available.
@@ -215,18 +216,41 @@ func (l *Logstream) NewerFileAvailable() (file string, ok bool) {
 		return "", false
 	}
 
-	priorWasEmpty := false
-	lastSize := currentInfo.Size()
-	newSize := fInfo.Size()
-	// 1. If our size is greater than the file at this filename, we're not the
-	// same file.
-	if lastSize > newSize {
-		ok = true
-	} else if lastSize < newSize && lastSize == 0 {
-		// We have to double-check for cases where an empty file might have
-		// been deleted out from under us.
-		priorWasEmpty = true
-		ok = true
+	// Our open file and the file at our filename are the same file (same
+	// device and inode) unless the file was renamed or deleted out from
+	// under us.
+	sameFile := os.SameFile(currentInfo, fInfo)
+	if sameFile && l.isTruncated(currentInfo) {
+		l.handleTruncation()
+		return "", false
+	}
+
+	// 1. If our filename now points to a different file we've been rotated,
+	// otherwise fall back to comparing hashes.
+	if !sameFile {
+		// First make sure our file list is current. Ignore errors, since we
+		// can't do anything about them here anyway, scanning errors should be
+		// reported during the next ticker interval rescan.
+		l.set.ScanForLogstreams()
+
+		if renamed, found := l.locateOpenFile(currentInfo); found {
+			// We were renamed but are still part of the stream, update our
+			// filename and look for a newer file below.
+			l.position.Filename = renamed
+		} else {
+			// We were rotated out of the stream or deleted. Our caller only
+			// switches files after reading the open one to EOF, so move on to
+			// the file that replaced us, or the oldest file available.
+			l.lfMutex.RLock()
+			defer l.lfMutex.RUnlock()
+			if l.logfiles.IndexOf(l.position.Filename) != -1 {
+				return l.position.Filename, true
+			}
+			if len(l.logfiles) > 0 {
+				return l.logfiles[0].FileName, true
+			}
+			return "", false
+		}
 	} else if l.FileHashMismatch() {
 		// Our file-hash didn't verify, not the same file
 		ok = true
@@ -284,16 +308,66 @@ func (l *Logstream) NewerFileAvailable() (file string, ok bool) {
 		return l.logfiles[fileIndex+1].FileName, true
 	}
 
-	// Filename didn't change, but currently opened fd is empty and file system
-	// file is bigger. No way to tell if the file system file is the same or if
-	// it was replaced by a new file before the content was appended, so we
-	// force the file to be repoened.
-	if priorWasEmpty {
-		return l.position.Filename, true
-	}
 	return file, ok
 }
 
+// Returns the name of the file in our stream that's the same file (same
+// device and inode) as the one described by info, if any.
+func (l *Logstream) locateOpenFile(info os.FileInfo) (string, bool) {
+	l.lfMutex.RLock()
+	defer l.lfMutex.RUnlock()
+	for i := len(l.logfiles) - 1; i >= 0; i-- {
+		fInfo, err := os.Stat(l.logfiles[i].FileName)
+		if err == nil && os.SameFile(info, fInfo) {
+			return l.logfiles[i].FileName, true
+		}
+	}
+	return "", false
+}
+
+// Returns true if the open file shrank below our read offset, which happens
+// when it's truncated in place (e.g. by logrotate's `copytruncate`). Gzipped
+// files are never considered truncated.
+func (l *Logstream) isTruncated(currentInfo os.FileInfo) bool {
+	if l.reader != io.Reader(l.fd) {
+		return false
+	}
+	offset, err := l.fd.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return false
+	}
+	return currentInfo.Size() < offset
+}
+
+// Recovers from the open file having been truncated. If the data we hadn't
+// read yet was copied to another file in the stream we continue reading from
+// that copy, the truncated file will be picked up as the newer file once the
+// copy is exhausted. Otherwise we start over at the beginning of the
+// truncated file, since everything in it was written after the truncation.
+func (l *Logstream) handleTruncation() {
+	l.FlushBuffer(0)
+
+	// Ignore scanning errors, they'll be reported during the next rescan.
+	l.set.ScanForLogstreams()
+	filename := l.position.Filename
+	fd, reader, err := l.LocatePriorLocation(false)
+	if err == nil {
+		l.fd.Close()
+		l.fd = fd
+		l.reader = reader
+		l.priorEOF = false
+		return
+	}
+	if fd != nil {
+		fd.Close()
+	}
+
+	l.position.Reset()
+	l.position.Filename = filename
+	l.fd.Seek(0, os.SEEK_SET)
+	l.priorEOF = false
+}
+
 // FileHashMismatch uses the file path, seek location, and hash stored in the
 // Logstream's `position` attribute. It checks to see if the hash matches the
 // contents of the stored file path at the specified position. It returns true
@@ -18,6 +18,8 @@ package logstreamer
 import (
 	"github.com/mozilla-services/heka/ringbuf"
 	gs "github.com/rafrombrc/gospec/src/gospec"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -218,4 +220,94 @@ func ReaderSpec(c gs.Context) {
 		c.Expect(ls.position.Hash, gs.Equals, "72781af95a1583690cc97548fdcf3bb0efbe3119")
 		c.Expect(ls.position.Filename[len(testDirPath):], gs.Equals, "/2013/08/error.log")
 	})
+	c.Specify("Rotated files", func() {
+		tmpDir, err := ioutil.TempDir("", "logstreamer-rotation")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+		logPath := filepath.Join(tmpDir, "app.log")
+		rotatedPath := filepath.Join(tmpDir, "app.log.1")
+		err = ioutil.WriteFile(logPath, []byte("line one\nline two\n"), 0644)
+		c.Assume(err, gs.IsNil)
+
+		newStream := func(fileMatch string) *Logstream {
+			sp := &SortPattern{
+				FileMatch:      fileMatch,
+				Translation:    make(SubmatchTranslationMap),
+				Priority:       []string{"^Seq"},
+				Differentiator: []string{"app"},
+			}
+			lss, err := NewLogstreamSet(sp, time.Hour, tmpDir, tmpDir, false)
+			c.Assume(err, gs.IsNil)
+			lss.ScanForLogstreams()
+			stream, ok := lss.GetLogstream("app")
+			c.Assume(ok, gs.IsTrue)
+			return stream
+		}
+
+		// Reads until there's nothing left, which can take several EOFs
+		// since switching files only happens after consecutive EOFs.
+		readAll := func(stream *Logstream) string {
+			var out []byte
+			b := make([]byte, 64)
+			for eofs := 0; eofs < 3; {
+				n, err := stream.Read(b)
+				out = append(out, b[:n]...)
+				if err == io.EOF {
+					eofs++
+				} else {
+					c.Assume(err, gs.IsNil)
+				}
+			}
+			stream.FlushBuffer(0)
+			return string(out)
+		}
+
+		appendTo := func(path, data string) {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			c.Assume(err, gs.IsNil)
+			f.WriteString(data)
+			f.Close()
+		}
+
+		c.Specify("finish the rotated file before moving on", func() {
+			stream := newStream(`app\.log(\.(?P<Seq>\d+))?$`)
+			c.Expect(readAll(stream), gs.Equals, "line one\nline two\n")
+			os.Rename(logPath, rotatedPath)
+			appendTo(rotatedPath, "tail line\n")
+			ioutil.WriteFile(logPath, []byte("new line\n"), 0644)
+			c.Expect(readAll(stream), gs.Equals, "tail line\nnew line\n")
+			c.Expect(stream.position.Filename, gs.Equals, logPath)
+		})
+
+		c.Specify("are detected by inode when rotated out of the stream", func() {
+			err = ioutil.WriteFile(logPath, []byte("HEADER\n"), 0644)
+			c.Assume(err, gs.IsNil)
+			stream := newStream(`app\.log$`)
+			c.Expect(readAll(stream), gs.Equals, "HEADER\n")
+			// The new file starts with the same content, which fools a purely
+			// hash based comparison.
+			os.Rename(logPath, filepath.Join(tmpDir, "app.old"))
+			ioutil.WriteFile(logPath, []byte("HEADER\nnew line\n"), 0644)
+			c.Expect(readAll(stream), gs.Equals, "HEADER\nnew line\n")
+		})
+
+		c.Specify("truncated in place start over at the beginning", func() {
+			stream := newStream(`app\.log$`)
+			c.Expect(readAll(stream), gs.Equals, "line one\nline two\n")
+			ioutil.WriteFile(logPath, []byte("new\n"), 0644)
+			c.Expect(readAll(stream), gs.Equals, "new\n")
+			c.Expect(stream.position.SeekPosition, gs.Equals, int64(4))
+		})
+
+		c.Specify("truncated after copying read the copy first", func() {
+			stream := newStream(`app\.log(\.(?P<Seq>\d+))?$`)
+			c.Expect(readAll(stream), gs.Equals, "line one\nline two\n")
+			appendTo(logPath, "unread tail\n")
+			data, err := ioutil.ReadFile(logPath)
+			c.Assume(err, gs.IsNil)
+			ioutil.WriteFile(rotatedPath, data, 0644)
+			ioutil.WriteFile(logPath, []byte("new\n"), 0644)
+			c.Expect(readAll(stream), gs.Equals, "unread tail\nnew\n")
+		})
+	})
 }
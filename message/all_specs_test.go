@@ -20,6 +20,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pborman/uuid"
 	"github.com/rafrombrc/gospec/src/gospec"
 	gs "github.com/rafrombrc/gospec/src/gospec"
@@ -29,6 +30,7 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.AddSpec(MessageFieldsSpec)
 	r.AddSpec(MessageEqualsSpec)
+	r.AddSpec(MessageAnnotationsSpec)
 	r.AddSpec(MatcherSpecificationSpec)
 	gospec.MainGoTest(r, t)
 }
@@ -225,6 +227,74 @@ func MessageEqualsSpec(c gospec.Context) {
 		foos[1].ValueString[0] = "bar2"
 		c.Expect(msg0, gs.Not(gs.Equals), msg1)
 	})
+
+	c.Specify("Messages w/ diff annotations", func() {
+		msg1 := CopyMessage(msg0)
+		msg1.SetAnnotation("trace_id", "abc")
+		c.Expect(msg0, gs.Not(gs.Equals), msg1)
+	})
+}
+
+func MessageAnnotationsSpec(c gospec.Context) {
+	msg := getTestMessage()
+
+	c.Specify("Annotations", func() {
+		c.Specify("are set, replaced and deleted", func() {
+			_, ok := msg.GetAnnotation("trace_id")
+			c.Expect(ok, gs.IsFalse)
+
+			msg.SetAnnotation("trace_id", "abc")
+			msg.SetAnnotation("relay_hops", "1")
+			msg.SetAnnotation("trace_id", "def")
+			c.Expect(len(msg.Annotations), gs.Equals, 2)
+			v, ok := msg.GetAnnotation("trace_id")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(v, gs.Equals, "def")
+
+			msg.DeleteAnnotation("trace_id")
+			_, ok = msg.GetAnnotation("trace_id")
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(len(msg.Annotations), gs.Equals, 1)
+		})
+
+		c.Specify("are kept separate from fields", func() {
+			msg.SetAnnotation("foo", "annotated")
+			v, _ := msg.GetFieldValue("foo")
+			c.Expect(v, gs.Equals, "bar")
+			c.Expect(len(msg.Fields), gs.Equals, 2)
+		})
+
+		c.Specify("are copied", func() {
+			msg.SetAnnotation("trace_id", "abc")
+			cmsg := CopyMessage(msg)
+			msg.SetAnnotation("trace_id", "def")
+			v, _ := cmsg.GetAnnotation("trace_id")
+			c.Expect(v, gs.Equals, "abc")
+		})
+
+		c.Specify("survive encoding", func() {
+			msg.SetAnnotation("trace_id", "abc")
+			msg.SetAnnotation("empty", "")
+			b, err := proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			decoded := new(Message)
+			err = proto.Unmarshal(b, decoded)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decoded, gs.Equals, msg)
+			v, ok := decoded.GetAnnotation("empty")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(v, gs.Equals, "")
+		})
+
+		c.Specify("are optional on the wire", func() {
+			b, err := proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			decoded := new(Message)
+			err = proto.Unmarshal(b, decoded)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decoded.Annotations, gs.IsNil)
+		})
+	})
 }
 
 func BenchmarkMessageCreation(b *testing.B) {
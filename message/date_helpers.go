@@ -16,6 +16,7 @@
 package message
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -52,54 +53,7 @@ func ForgivingTimeParse(timeLayout, inputTime string, loc *time.Location) (time.
 	)
 
 	if strings.HasPrefix(timeLayout, "Epoch") {
-		var (
-			parsedInt  uint64
-			multiplier int
-		)
-
-		switch timeLayout {
-		case "Epoch":
-			multiplier = 9
-		case "EpochMilli":
-			multiplier = 6
-		case "EpochMicro":
-			multiplier = 3
-		case "EpochNano":
-			multiplier = 0
-		default:
-			err := fmt.Errorf("Unrecognized `Epoch` time format: %s", timeLayout)
-			return parsedTime, err
-		}
-
-		i := strings.Index(inputTime, ".")
-		if i == -1 {
-			// Integer values are easy, we append the right number of 0s and
-			// we're done.
-			zeroes := strings.Repeat("0", multiplier)
-			inputTime = inputTime + zeroes
-			parsedInt, err = strconv.ParseUint(inputTime, 10, 64)
-		} else {
-			// Noninteger need more care, we can't use floats or we'll lose
-			// timestamp precision. First calculate the number of decimal
-			// digits.
-			decDigits := len(inputTime) - i - 1
-			if decDigits < multiplier {
-				// Pad out zeroes to nanosecond resolution.
-				zeroes := strings.Repeat("0", multiplier-decDigits)
-				inputTime = inputTime + zeroes
-			} else if decDigits > multiplier {
-				// Truncate to nanosecond resolution.
-				inputTime = inputTime[:len(inputTime)-(decDigits-multiplier)]
-			}
-			// Finally remove the decimal and parse the value as an integer.
-			intStr := fmt.Sprintf("%s%s", inputTime[:i], inputTime[i+1:])
-			parsedInt, err = strconv.ParseUint(intStr, 10, 64)
-		}
-		if err != nil {
-			err = fmt.Errorf("Error parsing %s time: %s", timeLayout, err.Error())
-			return parsedTime, err
-		}
-		return time.Unix(0, int64(parsedInt)), nil
+		return parseEpoch(timeLayout, inputTime)
 	}
 
 	if parsedTime, err = time.ParseInLocation(timeLayout, inputTime, loc); err == nil {
@@ -113,3 +67,131 @@ func ForgivingTimeParse(timeLayout, inputTime string, loc *time.Location) (time.
 	}
 	return parsedTime, err
 }
+
+// Returns the number of digits the Epoch layout's values are shifted by to
+// get nanoseconds.
+func epochMultiplier(timeLayout string) (int, error) {
+	switch timeLayout {
+	case "Epoch":
+		return 9, nil
+	case "EpochMilli":
+		return 6, nil
+	case "EpochMicro":
+		return 3, nil
+	case "EpochNano":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("Unrecognized `Epoch` time format: %s", timeLayout)
+}
+
+// Parse a time since the epoch in the unit of the Epoch layout, which may
+// have a fractional part.
+func parseEpoch(timeLayout, inputTime string) (time.Time, error) {
+	var (
+		parsedTime time.Time
+		parsedInt  uint64
+	)
+
+	multiplier, err := epochMultiplier(timeLayout)
+	if err != nil {
+		return parsedTime, err
+	}
+
+	i := strings.Index(inputTime, ".")
+	if i == -1 {
+		// Integer values are easy, we append the right number of 0s and
+		// we're done.
+		zeroes := strings.Repeat("0", multiplier)
+		inputTime = inputTime + zeroes
+		parsedInt, err = strconv.ParseUint(inputTime, 10, 64)
+	} else {
+		// Noninteger need more care, we can't use floats or we'll lose
+		// timestamp precision. First calculate the number of decimal
+		// digits.
+		decDigits := len(inputTime) - i - 1
+		if decDigits < multiplier {
+			// Pad out zeroes to nanosecond resolution.
+			zeroes := strings.Repeat("0", multiplier-decDigits)
+			inputTime = inputTime + zeroes
+		} else if decDigits > multiplier {
+			// Truncate to nanosecond resolution.
+			inputTime = inputTime[:len(inputTime)-(decDigits-multiplier)]
+		}
+		// Finally remove the decimal and parse the value as an integer.
+		intStr := fmt.Sprintf("%s%s", inputTime[:i], inputTime[i+1:])
+		parsedInt, err = strconv.ParseUint(intStr, 10, 64)
+	}
+	if err != nil {
+		err = fmt.Errorf("Error parsing %s time: %s", timeLayout, err.Error())
+		return parsedTime, err
+	}
+	return time.Unix(0, int64(parsedInt)), nil
+}
+
+// Fills in the date of a time parsed from a layout without one: the current
+// year if it has no year, the current date if it has no date at all.
+func CompleteDate(t time.Time) time.Time {
+	if t.Year() == 0 && t.Month() == 1 && t.Day() == 1 {
+		now := time.Now()
+		return t.AddDate(now.Year(), int(now.Month()-1), now.Day()-1)
+	} else if t.Year() == 0 {
+		return t.AddDate(time.Now().Year(), 0, 0)
+	}
+	return t
+}
+
+// Parses timestamps with an ordered list of layouts, returning the time
+// parsed with the first one that matches. Unlike ForgivingTimeParse it
+// doesn't fall back to other layouts, so ambiguous formats are resolved as
+// configured.
+type TimestampParser struct {
+	// Go time layouts, names of the time package's layout constants such
+	// as "RFC3339", or one of the "Epoch", "EpochMilli", "EpochMicro" and
+	// "EpochNano" layouts for times since the epoch.
+	Layouts []string
+	// Location of the timestamps that don't specify their time zone.
+	Location *time.Location
+}
+
+// Creates a TimestampParser trying the layouts in order, in the named IANA
+// time zone location, UTC if empty.
+func NewTimestampParser(layouts []string, location string) (*TimestampParser, error) {
+	if len(layouts) == 0 {
+		return nil, errors.New("no timestamp layouts")
+	}
+	loc, err := time.LoadLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timestamp location '%s': %s", location, err)
+	}
+	p := &TimestampParser{
+		Layouts:  make([]string, len(layouts)),
+		Location: loc,
+	}
+	for i, layout := range layouts {
+		if named, ok := basicTimeLayouts[layout]; ok {
+			layout = named
+		} else if strings.HasPrefix(layout, "Epoch") {
+			if _, err = epochMultiplier(layout); err != nil {
+				return nil, err
+			}
+		}
+		p.Layouts[i] = layout
+	}
+	return p, nil
+}
+
+// Parses a timestamp with the first matching layout. Dates missing from the
+// layout are filled in as by CompleteDate.
+func (p *TimestampParser) Parse(inputTime string) (time.Time, error) {
+	for _, layout := range p.Layouts {
+		if strings.HasPrefix(layout, "Epoch") {
+			if t, err := parseEpoch(layout, inputTime); err == nil {
+				return t, nil
+			}
+		} else if t, err := time.ParseInLocation(layout, inputTime, p.Location); err == nil {
+			return CompleteDate(t), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp '%s' doesn't match any layout",
+		inputTime)
+}
@@ -16,6 +16,7 @@ package message
 
 import (
 	"testing"
+	"time"
 )
 
 func TestEpochInt(t *testing.T) {
@@ -107,3 +108,56 @@ func TestEpochNanoFloat(t *testing.T) {
 		t.Errorf("Wrong EpochNano time w/ float: %d", ts.UnixNano())
 	}
 }
+
+func TestTimestampParserLayoutOrder(t *testing.T) {
+	p, err := NewTimestampParser([]string{"02/01/2006 15:04", "RFC3339",
+		"EpochMilli"}, "America/New_York")
+	if err != nil {
+		t.Fatalf("Error creating parser: %s", err)
+	}
+	ts, err := p.Parse("03/04/2016 10:30")
+	if err != nil {
+		t.Fatalf("Error parsing day first time: %s", err)
+	}
+	// Interpreted with the first layout, in the parser's location.
+	if ts.UTC().Format(time.RFC3339) != "2016-04-03T14:30:00Z" {
+		t.Errorf("Wrong day first time: %s", ts.UTC())
+	}
+	ts, err = p.Parse("2016-04-03T10:30:00+02:00")
+	if err != nil || ts.Unix() != 1459672200 {
+		t.Errorf("Wrong RFC3339 time: %s %v", ts, err)
+	}
+	ts, err = p.Parse("1459672200123")
+	if err != nil || ts.UnixNano() != 1459672200123000000 {
+		t.Errorf("Wrong EpochMilli time: %s %v", ts, err)
+	}
+	if _, err = p.Parse("yesterday"); err == nil {
+		t.Error("Expected an error for an unmatched timestamp")
+	}
+}
+
+func TestTimestampParserCompletesDate(t *testing.T) {
+	p, err := NewTimestampParser([]string{"Jan _2 15:04:05"}, "")
+	if err != nil {
+		t.Fatalf("Error creating parser: %s", err)
+	}
+	ts, err := p.Parse("Mar  4 10:30:00")
+	if err != nil {
+		t.Fatalf("Error parsing time without a year: %s", err)
+	}
+	if ts.Year() != time.Now().Year() || ts.Month() != time.March || ts.Day() != 4 {
+		t.Errorf("Wrong completed date: %s", ts)
+	}
+}
+
+func TestTimestampParserRejectsInvalidConfig(t *testing.T) {
+	if _, err := NewTimestampParser(nil, ""); err == nil {
+		t.Error("Expected an error for no layouts")
+	}
+	if _, err := NewTimestampParser([]string{"EpochCentury"}, ""); err == nil {
+		t.Error("Expected an error for an unknown Epoch layout")
+	}
+	if _, err := NewTimestampParser([]string{"RFC3339"}, "Nowhere/Special"); err == nil {
+		t.Error("Expected an error for an unknown location")
+	}
+}
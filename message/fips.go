@@ -0,0 +1,42 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import "errors"
+
+// When set, only FIPS-approved algorithms are allowed for message signing and
+// TLS. Binaries built with the `fips` build tag always run in FIPS mode.
+var fipsMode = FIPS_BUILD
+
+// Enables or disables FIPS mode. FIPS mode can't be disabled in binaries
+// built with the `fips` build tag.
+func SetFipsMode(enabled bool) error {
+	if FIPS_BUILD && !enabled {
+		return errors.New("FIPS mode can't be disabled in a FIPS build")
+	}
+	fipsMode = enabled
+	return nil
+}
+
+// Returns whether FIPS mode is on.
+func FipsMode() bool {
+	return fipsMode
+}
+
+// Returns an error if the HMAC hash function isn't allowed in the current
+// mode, MD5 isn't FIPS-approved.
+func CheckHmacHash(hash Header_HmacHashFunction) error {
+	if fipsMode && hash != Header_SHA1 {
+		return errors.New("only SHA1 message signing is allowed in FIPS mode")
+	}
+	return nil
+}
@@ -237,6 +237,17 @@ func (src *Message) Copy(dst *Message) {
 	for i, v := range src.Fields {
 		dst.Fields[i] = CopyField(v)
 	}
+	if src.Annotations != nil {
+		dst.Annotations = make([]*Annotation, len(src.Annotations))
+		for i, v := range src.Annotations {
+			dst.Annotations[i] = &Annotation{Key: proto.String(v.GetKey())}
+			if v.Value != nil {
+				dst.Annotations[i].Value = proto.String(*v.Value)
+			}
+		}
+	} else {
+		dst.Annotations = nil
+	}
 	// ignore XXX_unrecognized
 }
 
@@ -554,17 +565,60 @@ func (m *Message) Equals(other interface{}) bool {
 					return false
 				}
 			}
-		case 9: // Fields
+		case 9, 10: // Fields, Annotations
 			if !reflect.DeepEqual(sField.Interface(), oField.Interface()) {
 				return false
 			}
-		case 10: // XXX_unrecognized
+		case 11: // XXX_unrecognized
 			// ignore
 		}
 	}
 	return true
 }
 
+// Sets an annotation on the message, replacing any existing value for the
+// key. Annotations carry pipeline metadata (e.g. ingest time, relay hops,
+// trace ids) and are kept separate from the user controlled Fields.
+func (m *Message) SetAnnotation(key, value string) {
+	if m == nil {
+		return
+	}
+	for _, a := range m.Annotations {
+		if a.GetKey() == key {
+			a.Value = proto.String(value)
+			return
+		}
+	}
+	m.Annotations = append(m.Annotations, &Annotation{
+		Key:   proto.String(key),
+		Value: proto.String(value),
+	})
+}
+
+// Returns the value of the annotation with the given key, and whether it was
+// present.
+func (m *Message) GetAnnotation(key string) (value string, ok bool) {
+	for _, a := range m.GetAnnotations() {
+		if a.GetKey() == key {
+			return a.GetValue(), true
+		}
+	}
+	return
+}
+
+// Removes the annotation with the given key, if present.
+func (m *Message) DeleteAnnotation(key string) {
+	if m == nil {
+		return
+	}
+	for i, a := range m.Annotations {
+		if a.GetKey() == key {
+			m.Annotations = append(m.Annotations[:i], m.Annotations[i+1:]...)
+			return
+		}
+	}
+}
+
 func (m *Message) GetUuidString() string {
 	if m != nil {
 		if len(m.Uuid) == UUID_SIZE {
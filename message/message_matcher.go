@@ -178,6 +178,8 @@ func testExpr(msg *Message, stmt *Statement) bool {
 		return true
 	case FALSE:
 		return false
+	case SEEN_TEST:
+		return stmt.op.seen.test(msg)
 	default:
 		switch stmt.field.tokenId {
 		case VAR_UUID, VAR_TYPE, VAR_LOGGER, VAR_PAYLOAD,
@@ -77,6 +77,7 @@ var nodes []*tree
    fieldIndex  int
    arrayIndex  int
    regexp      *regexp.Regexp
+   seen        *seenTest
 }
 
 %token OP_EQ OP_NE OP_GT OP_GTE OP_LT OP_LTE OP_RE OP_NRE
@@ -86,6 +87,7 @@ var nodes []*tree
 %token VAR_FIELDS
 %token STRING_VALUE NUMERIC_VALUE REGEXP_VALUE NIL_VALUE
 %token TRUE FALSE
+%token SEEN_TEST
 
 %start spec
 %left OP_OR
@@ -186,6 +188,11 @@ expr : '(' expr ')'
          //fmt.Println("boolean", $1)
          nodes = append(nodes, &tree{stmt:&Statement{op:$1}})
       }
+   | SEEN_TEST
+      {
+         //fmt.Println("seen", $1)
+         nodes = append(nodes, &tree{stmt:&Statement{op:$1}})
+      }
 ;
 
 %%
@@ -232,6 +239,8 @@ func (m *MatcherSpecificationParser) Lex(yylval *yySymType) int {
 	var err error
 	var c, tmp rune
 	var i int
+	var negate bool
+	var args []string
 
 	yylval.tokenId = 0
 	yylval.token = ""
@@ -239,6 +248,7 @@ func (m *MatcherSpecificationParser) Lex(yylval *yySymType) int {
 	yylval.fieldIndex = 0
 	yylval.arrayIndex = 0
 	yylval.regexp = nil
+	yylval.seen = nil
 
 	c = m.peekrune
 	m.peekrune = ' '
@@ -247,6 +257,9 @@ loop:
 	if c >= 'A' && c <= 'Z' {
 		goto variable
 	}
+	if c >= 'a' && c <= 'z' {
+		goto function
+	}
 	if (c >= '0' && c <= '9') || c == '.' {
 		goto number
 	}
@@ -274,6 +287,9 @@ loop:
 		} else if c == '~' {
 			yylval.token = "!~"
 			yylval.tokenId = OP_NRE
+		} else if c >= 'a' && c <= 'z' {
+			negate = true
+			goto function
 		} else {
 			break
 		}
@@ -390,6 +406,73 @@ variable:
 	}
 	return yylval.tokenId
 
+function:
+	// The only function is `seen("filter", "key")`, which is lexed as a
+	// single token.
+	m.sym = ""
+	for rvariable(c) {
+		m.sym += string(c)
+		c = m.getrune()
+	}
+	if m.sym != "seen" {
+		return 0
+	}
+	for c == ' ' || c == '\t' {
+		c = m.getrune()
+	}
+	if c != '(' {
+		return 0
+	}
+	for {
+		c = m.getrune()
+		for c == ' ' || c == '\t' {
+			c = m.getrune()
+		}
+		if c != '"' && c != '\'' {
+			return 0
+		}
+		tmp = c
+		var arg string
+		for {
+			c = m.getrune()
+			if c == 0 {
+				return 0
+			}
+			if c == '\\' {
+				c = m.getrune()
+				if c != tmp {
+					arg += "\\"
+				}
+			} else if c == tmp {
+				break
+			}
+			arg += string(c)
+		}
+		args = append(args, arg)
+		c = m.getrune()
+		for c == ' ' || c == '\t' {
+			c = m.getrune()
+		}
+		if c == ')' {
+			break
+		}
+		if c != ',' {
+			return 0
+		}
+	}
+	if len(args) != 2 {
+		log.Printf("seen() takes a filter name and a key expression\n")
+		return 0
+	}
+	yylval.seen, err = newSeenTest(args[0], args[1], negate)
+	if err != nil {
+		log.Printf("invalid seen(): %s\n", err)
+		return 0
+	}
+	yylval.token = m.sym
+	yylval.tokenId = SEEN_TEST
+	return yylval.tokenId
+
 number:
 	m.sym = ""
 	for i = 0; ; i++ {
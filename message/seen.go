@@ -0,0 +1,167 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// A set of keys that remembers which keys it has been given, possibly only
+// approximately like a bloom filter. Seen filters are registered by name to
+// be used by `seen()` tests in message matchers.
+type SeenFilter interface {
+	// Adds the key to the set and returns whether it was already in it.
+	TestAndAdd(key []byte) bool
+}
+
+var (
+	seenFiltersLock sync.RWMutex
+	seenFilters     = make(map[string]SeenFilter)
+)
+
+// Makes the filter available to message matchers under the given name,
+// replacing any filter previously registered under it.
+func RegisterSeenFilter(name string, filter SeenFilter) {
+	seenFiltersLock.Lock()
+	seenFilters[name] = filter
+	seenFiltersLock.Unlock()
+}
+
+func UnregisterSeenFilter(name string) {
+	seenFiltersLock.Lock()
+	delete(seenFilters, name)
+	seenFiltersLock.Unlock()
+}
+
+// Returns the seen filter registered under the given name.
+func GetSeenFilter(name string) (filter SeenFilter, ok bool) {
+	seenFiltersLock.RLock()
+	filter, ok = seenFilters[name]
+	seenFiltersLock.RUnlock()
+	return
+}
+
+// A `seen("filter", "key")` test, true if the filter has seen the key
+// before, or a negated `!seen(...)` test, true if it hasn't.
+type seenTest struct {
+	filter SeenFilter
+	key    []seenKeyPart
+	negate bool
+	buf    []byte
+	lock   sync.Mutex
+}
+
+// Part of a seen key expression, either literal text or a message variable.
+type seenKeyPart struct {
+	literal  string
+	variable int
+	field    string
+}
+
+var seenKeyVarRegex = regexp.MustCompile(`%\{(\w+)(?:\[([^\]]+)\])?\}`)
+
+// Parses a key expression in which `%{Type}`, `%{Fields[name]}` etc. are
+// replaced by the message's values.
+func parseSeenKey(expr string) ([]seenKeyPart, error) {
+	var parts []seenKeyPart
+	last := 0
+	for _, loc := range seenKeyVarRegex.FindAllStringSubmatchIndex(expr, -1) {
+		if loc[0] > last {
+			parts = append(parts, seenKeyPart{literal: expr[last:loc[0]]})
+		}
+		last = loc[1]
+		name := expr[loc[2]:loc[3]]
+		part := seenKeyPart{variable: variables[name]}
+		switch part.variable {
+		case VAR_UUID, VAR_TYPE, VAR_LOGGER, VAR_PAYLOAD, VAR_ENVVERSION,
+			VAR_HOSTNAME, VAR_TIMESTAMP, VAR_SEVERITY, VAR_PID:
+			if loc[4] >= 0 {
+				return nil, fmt.Errorf("%s can't be indexed", name)
+			}
+		case VAR_FIELDS:
+			if loc[4] < 0 {
+				return nil, errors.New("Fields must name a field, e.g. %{Fields[name]}")
+			}
+			part.field = expr[loc[4]:loc[5]]
+		default:
+			return nil, fmt.Errorf("unknown variable '%s'", name)
+		}
+		parts = append(parts, part)
+	}
+	if last < len(expr) {
+		parts = append(parts, seenKeyPart{literal: expr[last:]})
+	}
+	return parts, nil
+}
+
+func newSeenTest(filterName, keyExpr string, negate bool) (*seenTest, error) {
+	filter, ok := GetSeenFilter(filterName)
+	if !ok {
+		return nil, fmt.Errorf("unknown seen filter '%s'", filterName)
+	}
+	key, err := parseSeenKey(keyExpr)
+	if err != nil {
+		return nil, err
+	}
+	return &seenTest{filter: filter, key: key, negate: negate}, nil
+}
+
+// Appends the message's value of the key part to buf.
+func appendSeenKeyPart(buf []byte, msg *Message, part seenKeyPart) []byte {
+	switch part.variable {
+	case VAR_UUID:
+		return append(buf, msg.GetUuidString()...)
+	case VAR_TYPE:
+		return append(buf, msg.GetType()...)
+	case VAR_LOGGER:
+		return append(buf, msg.GetLogger()...)
+	case VAR_PAYLOAD:
+		return append(buf, msg.GetPayload()...)
+	case VAR_ENVVERSION:
+		return append(buf, msg.GetEnvVersion()...)
+	case VAR_HOSTNAME:
+		return append(buf, msg.GetHostname()...)
+	case VAR_TIMESTAMP:
+		return strconv.AppendInt(buf, msg.GetTimestamp(), 10)
+	case VAR_SEVERITY:
+		return strconv.AppendInt(buf, int64(msg.GetSeverity()), 10)
+	case VAR_PID:
+		return strconv.AppendInt(buf, int64(msg.GetPid()), 10)
+	case VAR_FIELDS:
+		val, ok := msg.GetFieldValue(part.field)
+		if !ok {
+			return buf
+		}
+		if b, ok := val.([]byte); ok {
+			return append(buf, b...)
+		}
+		return append(buf, fmt.Sprint(val)...)
+	}
+	return append(buf, part.literal...)
+}
+
+func (t *seenTest) test(msg *Message) bool {
+	// Matchers are only evaluated from one goroutine, the lock only guards
+	// against a spec being shared.
+	t.lock.Lock()
+	t.buf = t.buf[:0]
+	for _, part := range t.key {
+		t.buf = appendSeenKeyPart(t.buf, msg, part)
+	}
+	seen := t.filter.TestAndAdd(t.buf)
+	t.lock.Unlock()
+	return seen != t.negate
+}
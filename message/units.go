@@ -0,0 +1,131 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+type unit struct {
+	dimension string
+	// Size of the unit in terms of the dimension's smallest unit. Keeping
+	// these integral keeps conversions between round values exact.
+	factor float64
+}
+
+// Units understood by ConvertUnit, keyed by the names used in field
+// representations.
+var units = map[string]unit{
+	"ns":  {"time", 1},
+	"us":  {"time", 1e3},
+	"µs":  {"time", 1e3},
+	"ms":  {"time", 1e6},
+	"s":   {"time", 1e9},
+	"min": {"time", 60e9},
+	"h":   {"time", 3600e9},
+	"d":   {"time", 86400e9},
+
+	"bit": {"data", 1},
+	"B":   {"data", 8},
+	"KB":  {"data", 8e3},
+	"MB":  {"data", 8e6},
+	"GB":  {"data", 8e9},
+	"TB":  {"data", 8e12},
+	"KiB": {"data", 8 << 10},
+	"MiB": {"data", 8 << 20},
+	"GiB": {"data", 8 << 30},
+	"TiB": {"data", 8 << 40},
+}
+
+var unitAliases = map[string]string{
+	"nanoseconds":  "ns",
+	"microseconds": "us",
+	"milliseconds": "ms",
+	"seconds":      "s",
+	"sec":          "s",
+	"minutes":      "min",
+	"hours":        "h",
+	"days":         "d",
+	"bits":         "bit",
+	"byte":         "B",
+	"bytes":        "B",
+}
+
+// lookupUnit finds a unit by name or alias. Aliases are case insensitive,
+// unit names aren't since "Mb" and "MB" mean different things.
+func lookupUnit(name string) (unit, bool) {
+	if u, ok := units[name]; ok {
+		return u, true
+	}
+	if alias, ok := unitAliases[strings.ToLower(name)]; ok {
+		return units[alias], true
+	}
+	return unit{}, false
+}
+
+// IsKnownUnit reports whether ConvertUnit understands the named unit.
+func IsKnownUnit(name string) bool {
+	_, ok := lookupUnit(name)
+	return ok
+}
+
+// ConvertUnit converts a value between two units of the same dimension, e.g.
+// from "ms" to "s" or from "B" to "MiB".
+func ConvertUnit(value float64, from, to string) (float64, error) {
+	fromUnit, ok := lookupUnit(from)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit '%s'", from)
+	}
+	toUnit, ok := lookupUnit(to)
+	if !ok {
+		return 0, fmt.Errorf("unknown unit '%s'", to)
+	}
+	if fromUnit.dimension != toUnit.dimension {
+		return 0, fmt.Errorf("can't convert %s (%s) to %s (%s)", from,
+			fromUnit.dimension, to, toUnit.dimension)
+	}
+	if fromUnit.factor == toUnit.factor {
+		return value, nil
+	}
+	return value * fromUnit.factor / toUnit.factor, nil
+}
+
+// ConvertFieldUnit returns a copy of a numeric field with all of its values
+// converted to the target unit. The new field is always of type double and
+// has the target unit as its representation. If `from` is empty the field's
+// representation is used as the source unit.
+func ConvertFieldUnit(f *Field, from, to string) (*Field, error) {
+	if from == "" {
+		from = f.GetRepresentation()
+	}
+	var values []float64
+	switch f.GetValueType() {
+	case Field_DOUBLE:
+		values = f.GetValueDouble()
+	case Field_INTEGER:
+		for _, v := range f.GetValueInteger() {
+			values = append(values, float64(v))
+		}
+	default:
+		return nil, fmt.Errorf("field '%s' isn't numeric", f.GetName())
+	}
+	converted := NewFieldInit(f.GetName(), Field_DOUBLE, to)
+	for _, v := range values {
+		v, err := ConvertUnit(v, from, to)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %s", f.GetName(), err)
+		}
+		converted.AddValue(v)
+	}
+	return converted, nil
+}
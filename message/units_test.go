@@ -0,0 +1,80 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package message
+
+import (
+	"testing"
+)
+
+func TestConvertUnit(t *testing.T) {
+	tests := []struct {
+		value    float64
+		from, to string
+		expected float64
+	}{
+		{1500, "ms", "s", 1.5},
+		{2500000, "ns", "ms", 2.5},
+		{3, "min", "s", 180},
+		{1048576, "B", "MiB", 1},
+		{1048576, "bytes", "MiB", 1},
+		{2, "GB", "MB", 2000},
+		{16, "bit", "B", 2},
+		{42, "ms", "ms", 42},
+	}
+	for _, test := range tests {
+		v, err := ConvertUnit(test.value, test.from, test.to)
+		if err != nil {
+			t.Errorf("%s -> %s: unexpected error: %s", test.from, test.to, err)
+			continue
+		}
+		if v != test.expected {
+			t.Errorf("%v %s -> %s: expected %v, got %v", test.value, test.from,
+				test.to, test.expected, v)
+		}
+	}
+}
+
+func TestConvertUnitErrors(t *testing.T) {
+	if _, err := ConvertUnit(1, "ms", "MiB"); err == nil {
+		t.Error("expected an error converting between dimensions")
+	}
+	if _, err := ConvertUnit(1, "furlong", "s"); err == nil {
+		t.Error("expected an error for an unknown unit")
+	}
+	if _, err := ConvertUnit(1, "mb", "B"); err == nil {
+		t.Error("unit names should be case sensitive")
+	}
+}
+
+func TestConvertFieldUnit(t *testing.T) {
+	f, _ := NewField("latency", 1500, "ms")
+	f.AddValue(250)
+	converted, err := ConvertFieldUnit(f, "", "s")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if converted.GetValueType() != Field_DOUBLE {
+		t.Errorf("expected a double field, got %s", converted.GetValueType())
+	}
+	if converted.GetRepresentation() != "s" {
+		t.Errorf("wrong representation: %s", converted.GetRepresentation())
+	}
+	values := converted.GetValueDouble()
+	if len(values) != 2 || values[0] != 1.5 || values[1] != 0.25 {
+		t.Errorf("wrong values: %v", values)
+	}
+
+	s, _ := NewField("name", "value", "")
+	if _, err = ConvertFieldUnit(s, "ms", "s"); err == nil {
+		t.Error("expected an error converting a string field")
+	}
+}
@@ -0,0 +1,170 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Access levels granted by an AccessControl resource. Each role includes the
+// permissions of the roles before it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// May read reports, metrics and dashboards.
+	RoleView
+	// May also change the state of running plugins, e.g. unload sandboxes.
+	RoleOperate
+	// May also deploy new code, e.g. load sandboxes.
+	RoleDeploy
+)
+
+var roleNames = []string{"none", "view", "operate", "deploy"}
+
+func (r Role) String() string {
+	if r < RoleNone || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func ParseRole(name string) (Role, error) {
+	for i, roleName := range roleNames {
+		if name == roleName {
+			return Role(i), nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role '%s', must be 'view', 'operate' or 'deploy'",
+		name)
+}
+
+type AccessControlConfig struct {
+	// Maps tokens presented to HTTP endpoints to the role they grant.
+	Tokens map[string]string `toml:"tokens"`
+	// Maps message signer names to the role granted to control messages
+	// signed by them.
+	Signers map[string]string `toml:"signers"`
+}
+
+// AccessControl is a shared resource mapping HTTP tokens and message signers
+// to roles, so a single list of credentials can protect the dashboard, the
+// metrics endpoint and the handling of control messages. Anything not listed
+// is granted RoleNone.
+type AccessControl struct {
+	tokens  map[string]Role
+	signers map[string]Role
+}
+
+func (a *AccessControl) ConfigStruct() interface{} {
+	return new(AccessControlConfig)
+}
+
+func (a *AccessControl) Init(config interface{}) (err error) {
+	conf := config.(*AccessControlConfig)
+	if len(conf.Tokens) == 0 && len(conf.Signers) == 0 {
+		return errors.New("at least one token or signer must be configured")
+	}
+	a.tokens = make(map[string]Role, len(conf.Tokens))
+	for token, roleName := range conf.Tokens {
+		if token == "" {
+			return errors.New("tokens can't be empty")
+		}
+		if a.tokens[token], err = ParseRole(roleName); err != nil {
+			return err
+		}
+	}
+	a.signers = make(map[string]Role, len(conf.Signers))
+	for signer, roleName := range conf.Signers {
+		if a.signers[signer], err = ParseRole(roleName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the role granted to an HTTP token.
+func (a *AccessControl) TokenRole(token string) Role {
+	role := RoleNone
+	// Compare against every token in constant time so response times don't
+	// reveal how much of a token was right.
+	for t, r := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			role = r
+		}
+	}
+	return role
+}
+
+// Returns the role granted to messages from the named signer.
+func (a *AccessControl) SignerRole(signer string) Role {
+	if signer == "" {
+		return RoleNone
+	}
+	return a.signers[signer]
+}
+
+// Returns the token presented with a request, either as a bearer token or as
+// the password of HTTP basic authentication, which lets browsers prompt for
+// it.
+func requestToken(req *http.Request) string {
+	if _, password, ok := req.BasicAuth(); ok {
+		return password
+	}
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return ""
+}
+
+// Wraps an HTTP handler so that it's only served to requests presenting a
+// token granting at least the required role.
+func (a *AccessControl) Handler(h http.Handler, required Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := requestToken(req)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="heka"`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if a.TokenRole(token) < required {
+			http.Error(w, fmt.Sprintf("the '%s' role is required", required),
+				http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Returns the named AccessControl resource.
+func (self *PipelineConfig) AccessControl(name string) (*AccessControl, error) {
+	resource, err := self.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	acl, ok := resource.(*AccessControl)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't an AccessControl resource", name)
+	}
+	return acl, nil
+}
+
+func init() {
+	RegisterResource("AccessControl", func() interface{} {
+		return new(AccessControl)
+	})
+}
@@ -0,0 +1,73 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AccessControlSpec(c gs.Context) {
+	c.Specify("An AccessControl resource", func() {
+		acl := new(AccessControl)
+		config := acl.ConfigStruct().(*AccessControlConfig)
+		config.Tokens = map[string]string{
+			"viewer":   "view",
+			"operator": "operate",
+		}
+		config.Signers = map[string]string{"deployer": "deploy"}
+		err := acl.Init(config)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("maps tokens and signers to roles", func() {
+			c.Expect(acl.TokenRole("viewer"), gs.Equals, RoleView)
+			c.Expect(acl.TokenRole("operator"), gs.Equals, RoleOperate)
+			c.Expect(acl.TokenRole("unknown"), gs.Equals, RoleNone)
+			c.Expect(acl.SignerRole("deployer"), gs.Equals, RoleDeploy)
+			c.Expect(acl.SignerRole(""), gs.Equals, RoleNone)
+		})
+
+		c.Specify("rejects unknown roles", func() {
+			config.Tokens["bad"] = "admin"
+			c.Expect(acl.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("protects HTTP handlers", func() {
+			handler := acl.Handler(http.HandlerFunc(
+				func(w http.ResponseWriter, req *http.Request) {
+					w.Write([]byte("ok"))
+				}), RoleOperate)
+			get := func(setAuth func(req *http.Request)) int {
+				req, _ := http.NewRequest("GET", "/", nil)
+				if setAuth != nil {
+					setAuth(req)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			c.Expect(get(nil), gs.Equals, http.StatusUnauthorized)
+			c.Expect(get(func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer viewer")
+			}), gs.Equals, http.StatusForbidden)
+			c.Expect(get(func(req *http.Request) {
+				req.Header.Set("Authorization", "Bearer operator")
+			}), gs.Equals, http.StatusOK)
+			c.Expect(get(func(req *http.Request) {
+				req.SetBasicAuth("anyone", "operator")
+			}), gs.Equals, http.StatusOK)
+		})
+	})
+}
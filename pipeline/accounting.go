@@ -0,0 +1,174 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Number of distinct accounting keys tracked per report interval. Usage of
+// further keys is attributed to overflowUsageKey, so a high cardinality key
+// field can't exhaust memory.
+const (
+	maxUsageKeys     = 10000
+	overflowUsageKey = "_other"
+)
+
+type usageCount struct {
+	messages int64
+	bytes    int64
+}
+
+// Usage of one accounting key: the messages entering the router and the
+// messages delivered to each output.
+type keyUsage struct {
+	ingested usageCount
+	outputs  map[string]*usageCount
+}
+
+// Attributes message and byte counts to the accounting key of each message,
+// the message's signer or the value of a message field, for chargeback of
+// shared infrastructure. Heka's own messages aren't counted.
+type usageAccountant struct {
+	// Message field holding the key, empty to use the signer.
+	field string
+	lock  sync.Mutex
+	usage map[string]*keyUsage
+}
+
+func newUsageAccountant(key string) *usageAccountant {
+	a := &usageAccountant{usage: make(map[string]*keyUsage)}
+	if key != "signer" {
+		a.field = key
+	}
+	return a
+}
+
+func (a *usageAccountant) key(pack *PipelinePack) string {
+	if a.field == "" {
+		return pack.Signer
+	}
+	val, ok := pack.Message.GetFieldValue(a.field)
+	if !ok {
+		return ""
+	}
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return fmt.Sprint(val)
+}
+
+// Counts the pack as ingested if output is empty, or as delivered to the
+// named output.
+func (a *usageAccountant) count(output string, pack *PipelinePack) {
+	if pack.Message.GetLogger() == HEKA_DAEMON {
+		return
+	}
+	key := a.key(pack)
+	a.lock.Lock()
+	usage, ok := a.usage[key]
+	if !ok {
+		if len(a.usage) >= maxUsageKeys {
+			key = overflowUsageKey
+			usage = a.usage[key]
+		}
+		if usage == nil {
+			usage = &keyUsage{outputs: make(map[string]*usageCount)}
+			a.usage[key] = usage
+		}
+	}
+	count := &usage.ingested
+	if output != "" {
+		if count, ok = usage.outputs[output]; !ok {
+			count = new(usageCount)
+			usage.outputs[output] = count
+		}
+	}
+	count.messages++
+	count.bytes += int64(len(pack.MsgBytes))
+	a.lock.Unlock()
+}
+
+// Returns the usage counted since the previous call.
+func (a *usageAccountant) takeUsage() map[string]*keyUsage {
+	a.lock.Lock()
+	usage := a.usage
+	a.usage = make(map[string]*keyUsage, len(usage))
+	a.lock.Unlock()
+	return usage
+}
+
+// Populates a "heka.usage-report" message with the key's usage.
+func (usage *keyUsage) report(msg *message.Message, key string) {
+	msg.SetLogger(HEKA_DAEMON)
+	msg.SetType("heka.usage-report")
+	message.NewStringField(msg, "Key", key)
+	message.NewInt64Field(msg, "MessageCount", usage.ingested.messages, "count")
+	message.NewInt64Field(msg, "ByteCount", usage.ingested.bytes, "B")
+	if len(usage.outputs) == 0 {
+		return
+	}
+	outputs := make([]string, 0, len(usage.outputs))
+	for output := range usage.outputs {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+	names := message.NewFieldInit("Output", message.Field_STRING, "")
+	messages := message.NewFieldInit("OutputMessageCount", message.Field_INTEGER, "count")
+	bytes := message.NewFieldInit("OutputByteCount", message.Field_INTEGER, "B")
+	for _, output := range outputs {
+		count := usage.outputs[output]
+		names.AddValue(output)
+		messages.AddValue(count.messages)
+		bytes.AddValue(count.bytes)
+	}
+	msg.AddField(names)
+	msg.AddField(messages)
+	msg.AddField(bytes)
+}
+
+// Injects one "heka.usage-report" message per accounting key into the router.
+func (pc *PipelineConfig) usageReportMsgs() {
+	for key, usage := range pc.accountant.takeUsage() {
+		pack, err := pc.PipelinePack(0)
+		if err != nil {
+			LogError.Println(err.Error())
+			return
+		}
+		usage.report(pack.Message, key)
+		if err = pack.EncodeMsgBytes(); err != nil {
+			LogError.Printf("encoding heka.usage-report message: %s\n", err.Error())
+			pack.recycle()
+			continue
+		}
+		pc.router.InChan() <- pack
+	}
+}
+
+// Generates usage report messages every interval until stopChan is closed.
+func (pc *PipelineConfig) usageReportLoop(interval time.Duration, stopChan chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			pc.usageReportMsgs()
+		}
+	}
+}
@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func UsageAccountingSpec(c gs.Context) {
+	pack := NewPipelinePack(make(chan *PipelinePack, 1))
+	pack.MsgBytes = make([]byte, 100)
+
+	c.Specify("A usage accountant", func() {
+		c.Specify("accounts to the signer", func() {
+			accountant := newUsageAccountant("signer")
+			pack.Signer = "ops"
+			accountant.count("", pack)
+			accountant.count("", pack)
+			accountant.count("ElasticSearchOutput", pack)
+			usage := accountant.takeUsage()
+			c.Expect(len(usage), gs.Equals, 1)
+			c.Expect(usage["ops"].ingested.messages, gs.Equals, int64(2))
+			c.Expect(usage["ops"].ingested.bytes, gs.Equals, int64(200))
+			c.Expect(usage["ops"].outputs["ElasticSearchOutput"].messages,
+				gs.Equals, int64(1))
+			c.Expect(len(accountant.takeUsage()), gs.Equals, 0)
+		})
+
+		accountant := newUsageAccountant("team")
+
+		c.Specify("accounts to a field's value", func() {
+			message.NewStringField(pack.Message, "team", "payments")
+			accountant.count("", pack)
+			pack.Message.DeleteField(pack.Message.FindFirstField("team"))
+			accountant.count("", pack)
+			usage := accountant.takeUsage()
+			c.Expect(usage["payments"].ingested.messages, gs.Equals, int64(1))
+			c.Expect(usage[""].ingested.messages, gs.Equals, int64(1))
+		})
+
+		c.Specify("doesn't count Heka's own messages", func() {
+			pack.Message.SetLogger(HEKA_DAEMON)
+			accountant.count("", pack)
+			c.Expect(len(accountant.takeUsage()), gs.Equals, 0)
+		})
+
+		c.Specify("limits the number of keys", func() {
+			field, _ := message.NewField("team", "", "")
+			pack.Message.AddField(field)
+			for i := 0; i < maxUsageKeys+10; i++ {
+				field.ValueString[0] = fmt.Sprint(i)
+				accountant.count("", pack)
+			}
+			usage := accountant.takeUsage()
+			c.Expect(len(usage), gs.Equals, maxUsageKeys+1)
+			c.Expect(usage[overflowUsageKey].ingested.messages, gs.Equals, int64(10))
+		})
+
+		c.Specify("reports the usage", func() {
+			message.NewStringField(pack.Message, "team", "payments")
+			accountant.count("", pack)
+			accountant.count("S3Output", pack)
+			accountant.count("KafkaOutput", pack)
+			accountant.count("KafkaOutput", pack)
+			msg := new(message.Message)
+			accountant.takeUsage()["payments"].report(msg, "payments")
+			c.Expect(msg.GetType(), gs.Equals, "heka.usage-report")
+			c.Expect(msg.GetLogger(), gs.Equals, HEKA_DAEMON)
+			key, _ := msg.GetFieldValue("Key")
+			c.Expect(key, gs.Equals, "payments")
+			count, _ := msg.GetFieldValue("MessageCount")
+			c.Expect(count, gs.Equals, int64(1))
+			outputs := msg.FindFirstField("Output").GetValueString()
+			c.Expect(len(outputs), gs.Equals, 2)
+			c.Expect(outputs[0], gs.Equals, "KafkaOutput")
+			counts := msg.FindFirstField("OutputMessageCount").GetValueInteger()
+			c.Expect(counts[0], gs.Equals, int64(2))
+			bytes := msg.FindFirstField("OutputByteCount").GetValueInteger()
+			c.Expect(bytes[1], gs.Equals, int64(100))
+		})
+	})
+}
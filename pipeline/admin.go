@@ -0,0 +1,64 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// Returns the handler of the admin endpoints, each restricted to a role of
+// the named AccessControl resource:
+//
+//   - `PUT /decoders/<name>` swaps the named decoder's config ("deploy").
+//   - `GET /debug/<name>` dumps the named filter or output's debug buffer
+//     ("operate").
+//   - `GET /config` returns the last good and failed config versions ("view").
+//   - `POST /config/reload` reloads the plugin config ("deploy").
+//
+// The endpoints change the running pipeline, so an AccessControl resource is
+// required.
+func (pc *PipelineConfig) adminHandler(aclName string) (http.Handler, error) {
+	if aclName == "" {
+		return nil, errors.New("the admin endpoints require an AccessControl resource")
+	}
+	acl, err := pc.AccessControl(aclName)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/decoders/", acl.Handler(http.HandlerFunc(pc.decoderConfigHandler),
+		RoleDeploy))
+	mux.Handle("/debug/", acl.Handler(http.HandlerFunc(pc.debugBufferHandler),
+		RoleOperate))
+	mux.Handle("/config", acl.Handler(http.HandlerFunc(pc.configVersionsHandler),
+		RoleView))
+	mux.Handle("/config/reload", acl.Handler(http.HandlerFunc(pc.configReloadHandler),
+		RoleDeploy))
+	return mux, nil
+}
+
+// Serves the admin endpoints on the given address, see adminHandler.
+// Returns the listener so it can be closed on shutdown.
+func (pc *PipelineConfig) serveAdmin(address, aclName string) (net.Listener, error) {
+	handler, err := pc.adminHandler(aclName)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(listener, handler)
+	return listener, nil
+}
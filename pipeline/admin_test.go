@@ -0,0 +1,63 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AdminSpec(c gs.Context) {
+	c.Specify("The admin endpoints", func() {
+		pc := NewPipelineConfig(nil)
+
+		c.Specify("require an AccessControl resource", func() {
+			_, err := pc.adminHandler("")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = pc.adminHandler("missing")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("are restricted by role", func() {
+			acl := new(AccessControl)
+			config := acl.ConfigStruct().(*AccessControlConfig)
+			config.Tokens = map[string]string{"viewer": "view"}
+			c.Assume(acl.Init(config), gs.IsNil)
+			pc.resources["admin"] = acl
+			handler, err := pc.adminHandler("admin")
+			c.Assume(err, gs.IsNil)
+
+			serve := func(method, path, token string) int {
+				req, _ := http.NewRequest(method, path, nil)
+				if token != "" {
+					req.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+				return w.Code
+			}
+
+			c.Expect(serve("GET", "/config", ""), gs.Equals, http.StatusUnauthorized)
+			c.Expect(serve("GET", "/config", "viewer"), gs.Equals, http.StatusOK)
+			c.Expect(serve("POST", "/config/reload", "viewer"), gs.Equals,
+				http.StatusForbidden)
+			c.Expect(serve("PUT", "/decoders/ApacheDecoder", "viewer"), gs.Equals,
+				http.StatusForbidden)
+			c.Expect(serve("GET", "/debug/ParserFilter", "viewer"), gs.Equals,
+				http.StatusForbidden)
+			// The queue metrics are served separately.
+			c.Expect(serve("GET", "/metrics", "viewer"), gs.Equals, http.StatusNotFound)
+		})
+	})
+}
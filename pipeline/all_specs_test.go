@@ -37,18 +37,44 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(AccessControlSpec)
+	r.AddSpec(AdminSpec)
+	r.AddSpec(BloomFilterSpec)
+	r.AddSpec(CanarySpec)
+	r.AddSpec(CompressionSpec)
+	r.AddSpec(ConfigReloadSpec)
+	r.AddSpec(CronSpec)
+	r.AddSpec(DebugBufferSpec)
+	r.AddSpec(DecoderFanOutSpec)
+	r.AddSpec(DecoderScalingSpec)
+	r.AddSpec(DecoderSwapSpec)
+	r.AddSpec(ErrorClassificationSpec)
+	r.AddSpec(HandoffSpec)
 	r.AddSpec(HekaFramingSpec)
 	r.AddSpec(InputRunnerSpec)
+	r.AddSpec(InterfaceAddressSpec)
+	r.AddSpec(LeaderElectionSpec)
+	r.AddSpec(ListenUDPSocketsSpec)
 	r.AddSpec(MessageTemplateSpec)
+	r.AddSpec(NumberFormatSpec)
+	r.AddSpec(OneShotSpec)
 	r.AddSpec(OutputRunnerSpec)
 	r.AddSpec(ProtobufDecoderSpec)
+	r.AddSpec(ProtobufEncoderSpec)
 	r.AddSpec(QueueBufferSpec)
+	r.AddSpec(QueueEncryptionSpec)
+	r.AddSpec(QueueStatsSpec)
 	r.AddSpec(PatternGroupingSpec)
+	r.AddSpec(ReadinessSpec)
 	r.AddSpec(RegexSpec)
+	r.AddSpec(SamplingSpec)
+	r.AddSpec(ServiceDiscoverySpec)
 	r.AddSpec(ReportSpec)
+	r.AddSpec(SizeGuardSpec)
 	r.AddSpec(SplitterRunnerSpec)
 	r.AddSpec(StatAccumInputSpec)
 	r.AddSpec(TokenSpec)
+	r.AddSpec(UsageAccountingSpec)
 
 	gospec.MainGoTest(r, t)
 }
@@ -0,0 +1,180 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+type BloomFilterConfig struct {
+	// Number of distinct keys the filter is sized for. Beyond it the false
+	// positive rate grows.
+	Capacity uint
+	// Rate of keys wrongly reported as seen before when the filter holds
+	// `capacity` keys.
+	FalsePositiveRate float64 `toml:"false_positive_rate"`
+	// Seconds after which keys start being forgotten, 0 to never forget
+	// them. Keys are remembered for between one and two intervals.
+	ResetInterval uint `toml:"reset_interval"`
+}
+
+// Shared resource remembering which keys it has been given, using a bloom
+// filter: keys may be wrongly reported as seen before, at the configured
+// rate, but a key that has been seen is never reported as new. The filter is
+// registered under its resource name for use by `seen()` tests in message
+// matchers and by sandboxes.
+type BloomFilter struct {
+	name      string
+	numBits   uint64
+	numHashes int
+	interval  time.Duration
+	stopChan  chan struct{}
+
+	lock sync.Mutex
+	// When forgetting keys, previous holds those added in the last interval
+	// and current those added in this one.
+	current  []uint64
+	previous []uint64
+}
+
+func (b *BloomFilter) SetName(name string) {
+	b.name = name
+}
+
+func (b *BloomFilter) ConfigStruct() interface{} {
+	return &BloomFilterConfig{
+		Capacity:          1000000,
+		FalsePositiveRate: 0.001,
+	}
+}
+
+func (b *BloomFilter) Init(config interface{}) error {
+	conf := config.(*BloomFilterConfig)
+	if b.name == "" {
+		return errors.New("BloomFilter must be named")
+	}
+	if conf.Capacity == 0 {
+		return errors.New("capacity must be greater than 0")
+	}
+	if conf.FalsePositiveRate <= 0 || conf.FalsePositiveRate >= 1 {
+		return errors.New("false_positive_rate must be between 0 and 1")
+	}
+	// The optimal sizing for n keys and false positive rate p is
+	// m = -n*ln(p)/ln(2)^2 bits and k = m/n*ln(2) hash functions.
+	n := float64(conf.Capacity)
+	m := math.Ceil(-n * math.Log(conf.FalsePositiveRate) / (math.Ln2 * math.Ln2))
+	b.numBits = uint64(m)
+	b.numHashes = int(math.Max(1, math.Floor(m/n*math.Ln2+0.5)))
+	b.current = make([]uint64, (b.numBits+63)/64)
+	if conf.ResetInterval > 0 {
+		b.interval = time.Duration(conf.ResetInterval) * time.Second
+		b.previous = make([]uint64, len(b.current))
+		b.stopChan = make(chan struct{})
+		go b.resetLoop(b.stopChan)
+	}
+	message.RegisterSeenFilter(b.name, b)
+	return nil
+}
+
+// FNV-1a hashes of the key, with different offsets, used to derive the bit
+// positions by double hashing.
+func bloomHashes(key []byte) (h1, h2 uint64) {
+	h1, h2 = 14695981039346656037, 0x9ae16a3b2f90404f
+	for _, c := range key {
+		h1 = (h1 ^ uint64(c)) * 1099511628211
+		h2 = (h2 ^ uint64(c)) * 1099511628211
+	}
+	return h1, h2 | 1
+}
+
+// Whether all of the key's bits are set in bits.
+func (b *BloomFilter) contains(bits []uint64, h1, h2 uint64) bool {
+	for i := 0; i < b.numHashes; i++ {
+		pos := (h1 + uint64(i)*h2) % b.numBits
+		if bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds the key to the filter and returns whether it was probably seen
+// before. Safe for concurrent use.
+func (b *BloomFilter) TestAndAdd(key []byte) bool {
+	h1, h2 := bloomHashes(key)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.contains(b.current, h1, h2) {
+		return true
+	}
+	for i := 0; i < b.numHashes; i++ {
+		pos := (h1 + uint64(i)*h2) % b.numBits
+		b.current[pos/64] |= 1 << (pos % 64)
+	}
+	return b.previous != nil && b.contains(b.previous, h1, h2)
+}
+
+// Forgets the keys that weren't seen in the last interval.
+func (b *BloomFilter) reset() {
+	b.lock.Lock()
+	b.previous, b.current = b.current, b.previous
+	for i := range b.current {
+		b.current[i] = 0
+	}
+	b.lock.Unlock()
+}
+
+func (b *BloomFilter) resetLoop(stopChan chan struct{}) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.reset()
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (b *BloomFilter) Stop() {
+	message.UnregisterSeenFilter(b.name)
+	if b.stopChan != nil {
+		close(b.stopChan)
+		b.stopChan = nil
+	}
+}
+
+// Returns the named BloomFilter resource.
+func (self *PipelineConfig) BloomFilter(name string) (*BloomFilter, error) {
+	resource, err := self.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	filter, ok := resource.(*BloomFilter)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a BloomFilter resource", name)
+	}
+	return filter, nil
+}
+
+func init() {
+	RegisterResource("BloomFilter", func() interface{} {
+		return new(BloomFilter)
+	})
+}
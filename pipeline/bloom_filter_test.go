@@ -0,0 +1,95 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func BloomFilterSpec(c gs.Context) {
+	c.Specify("A BloomFilter resource", func() {
+		filter := new(BloomFilter)
+		config := filter.ConfigStruct().(*BloomFilterConfig)
+		config.Capacity = 1000
+		config.FalsePositiveRate = 0.01
+
+		c.Specify("must be named", func() {
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an invalid false positive rate", func() {
+			filter.SetName("bloom")
+			config.FalsePositiveRate = 1
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("when initialized", func() {
+			filter.SetName("bloom")
+			config.ResetInterval = 3600
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer filter.Stop()
+
+			c.Specify("reports whether keys were seen before", func() {
+				c.Expect(filter.TestAndAdd([]byte("foo")), gs.IsFalse)
+				c.Expect(filter.TestAndAdd([]byte("bar")), gs.IsFalse)
+				c.Expect(filter.TestAndAdd([]byte("foo")), gs.IsTrue)
+				c.Expect(filter.TestAndAdd([]byte("bar")), gs.IsTrue)
+			})
+
+			c.Specify("stays close to the false positive rate", func() {
+				falsePositives := 0
+				for i := 0; i < 1000; i++ {
+					if filter.TestAndAdd([]byte(fmt.Sprintf("key%d", i))) {
+						falsePositives++
+					}
+				}
+				c.Expect(falsePositives < 30, gs.IsTrue)
+			})
+
+			c.Specify("remembers keys for one reset", func() {
+				filter.TestAndAdd([]byte("foo"))
+				filter.reset()
+				c.Expect(filter.TestAndAdd([]byte("foo")), gs.IsTrue)
+			})
+
+			c.Specify("forgets keys not seen for two resets", func() {
+				filter.TestAndAdd([]byte("foo"))
+				filter.reset()
+				filter.reset()
+				c.Expect(filter.TestAndAdd([]byte("foo")), gs.IsFalse)
+			})
+
+			c.Specify("is registered for seen() tests", func() {
+				registered, ok := message.GetSeenFilter("bloom")
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(registered, gs.Equals, filter)
+				matcher, err := message.CreateMatcherSpecification(
+					"!seen('bloom', '%{Type}')")
+				c.Assume(err, gs.IsNil)
+				msg := new(message.Message)
+				msg.SetType("test")
+				c.Expect(matcher.Match(msg), gs.IsTrue)
+				c.Expect(matcher.Match(msg), gs.IsFalse)
+			})
+
+			c.Specify("is unregistered when stopped", func() {
+				filter.Stop()
+				_, ok := message.GetSeenFilter("bloom")
+				c.Expect(ok, gs.IsFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,320 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/bbangert/toml"
+	"github.com/mozilla-services/heka/message"
+)
+
+// Settings for rolling out a reloaded filter as a canary: the new version
+// runs alongside the old one, receiving a share of the matched messages,
+// and replaces it only if it stays healthy.
+type CanaryConfig struct {
+	// Percentage of the matched messages the new version receives, from 1 to
+	// 99. Defaults to 10.
+	Percent uint `toml:"percent"`
+	// Seconds the new version has to stay healthy before it's promoted.
+	// Defaults to 300.
+	Duration uint `toml:"duration"`
+	// Highest acceptable ratio of errors to messages for the new version.
+	// Defaults to 0, any error rolls it back.
+	MaxErrorRate float64 `toml:"max_error_rate"`
+	// Number of messages the new version must have received before its
+	// error rate is checked ahead of the end of the rollout. Defaults to 100.
+	MinMessages int64 `toml:"min_messages"`
+}
+
+func (c *CanaryConfig) validate() error {
+	if c.Percent == 0 {
+		c.Percent = 10
+	}
+	if c.Duration == 0 {
+		c.Duration = 300
+	}
+	if c.MinMessages == 0 {
+		c.MinMessages = 100
+	}
+	if c.Percent > 99 {
+		return errors.New("canary percent must be between 1 and 99")
+	}
+	if c.MaxErrorRate < 0 || c.MaxErrorRate > 1 {
+		return errors.New("canary max_error_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// Suffix of the name a canary filter runs under.
+const canarySuffix = "-canary"
+
+// How often canaries are checked.
+var canaryCheckInterval = time.Second
+
+// Divides a filter's matched messages between its old version and the canary
+// of its new version, by message UUID so each message goes to exactly one of
+// them.
+type canarySplit struct {
+	percent uint32
+	// Number of messages given to the canary.
+	canaryCount int64
+}
+
+func (s *canarySplit) isCanary(msg *message.Message) bool {
+	// FNV-1a, inlined to avoid allocating a hash per message.
+	var h uint32 = 2166136261
+	for _, b := range msg.GetUuid() {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h%100 < s.percent
+}
+
+// One side of a canarySplit, as seen by a filter's MatchRunner.
+type splitSide struct {
+	split  *canarySplit
+	canary bool
+}
+
+// Returns whether the side's filter should receive the message.
+func (s *splitSide) keep(msg *message.Message) bool {
+	isCanary := s.split.isCanary(msg)
+	if isCanary && s.canary {
+		atomic.AddInt64(&s.split.canaryCount, 1)
+	}
+	return isCanary == s.canary
+}
+
+// Installs or, with a nil split, removes the matcher's side of a split.
+func (mr *MatchRunner) setSplit(side *splitSide) {
+	mr.split.Store(side)
+}
+
+// A reloaded filter being rolled out as a canary.
+type filterCanary struct {
+	name       string
+	config     CanaryConfig
+	old        *foRunner
+	canary     *foRunner
+	maker      PluginMaker
+	oldSection toml.Primitive
+	split      *canarySplit
+	abort      chan struct{}
+}
+
+// Returns why the canary should be rolled back, or "" if it's healthy. At
+// the end of the rollout the error rate is checked however few messages the
+// canary received.
+func (self *PipelineConfig) canaryFailure(c *filterCanary, final bool) string {
+	self.filtersLock.RLock()
+	runner := self.FilterRunners[c.canary.name]
+	self.filtersLock.RUnlock()
+	if runner != FilterRunner(c.canary) {
+		return "canary terminated"
+	}
+	var errs int64
+	for _, category := range []ErrorCategory{ErrorData, ErrorConfig, ErrorFatal} {
+		errs += c.canary.ErrorCount(category)
+	}
+	if errs == 0 {
+		return ""
+	}
+	count := atomic.LoadInt64(&c.split.canaryCount)
+	if count < c.config.MinMessages && !final {
+		return ""
+	}
+	rate := float64(errs) / float64(count)
+	if count == 0 || rate > c.config.MaxErrorRate {
+		return fmt.Sprintf("canary error rate %d/%d exceeds %g", errs, count,
+			c.config.MaxErrorRate)
+	}
+	return ""
+}
+
+// Returns the change starting a canary of the named filter's new version, or
+// nil if the filter's new config doesn't ask for one or the old version isn't
+// running.
+func (self *PipelineConfig) canaryChange(name string, maker PluginMaker,
+	oldSection toml.Primitive, runner *foRunner) *configChange {
+
+	if runner.config.Canary == nil {
+		return nil
+	}
+	self.filtersLock.RLock()
+	old, ok := self.FilterRunners[name].(*foRunner)
+	self.filtersLock.RUnlock()
+	if !ok || old.matcher == nil {
+		return nil
+	}
+
+	c := &filterCanary{
+		name:       name,
+		config:     *runner.config.Canary,
+		old:        old,
+		canary:     runner,
+		maker:      maker,
+		oldSection: oldSection,
+		split:      &canarySplit{percent: uint32(runner.config.Canary.Percent)},
+		abort:      make(chan struct{}),
+	}
+	runner.name = name + canarySuffix
+	runner.canExit = true
+	return &configChange{
+		apply: func() error {
+			runner.matcher.setSplit(&splitSide{c.split, true})
+			if err := self.AddFilterRunner(runner); err != nil {
+				self.filtersLock.Lock()
+				delete(self.FilterRunners, runner.name)
+				self.filtersLock.Unlock()
+				return err
+			}
+			old.matcher.setSplit(&splitSide{c.split, false})
+			self.canaries[name] = c
+			go self.watchCanary(c)
+			LogInfo.Printf("Filter '%s' canary started with %d%% of messages",
+				name, c.config.Percent)
+			return nil
+		},
+		undo: func() error {
+			close(c.abort)
+			delete(self.canaries, name)
+			old.matcher.setSplit(nil)
+			self.RemoveFilterRunner(runner.name)
+			return nil
+		},
+	}
+}
+
+// Checks the canary until it's promoted at the end of the rollout or rolled
+// back because it failed.
+func (self *PipelineConfig) watchCanary(c *filterCanary) {
+	ticker := time.NewTicker(canaryCheckInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(time.Duration(c.config.Duration) * time.Second)
+	for {
+		select {
+		case <-c.abort:
+			return
+		case <-ticker.C:
+		}
+		if self.Globals.IsShuttingDown() {
+			return
+		}
+		final := !time.Now().Before(deadline)
+		failure := self.canaryFailure(c, final)
+		if failure == "" && !final {
+			continue
+		}
+
+		self.reloadLock.Lock()
+		select {
+		case <-c.abort:
+		default:
+			if failure == "" {
+				self.promoteCanary(c)
+			} else {
+				self.rollbackCanary(c, failure)
+			}
+		}
+		self.reloadLock.Unlock()
+		return
+	}
+}
+
+// Replaces the old version of the canary's filter with a runner of the new
+// version receiving all of the messages. Must be called with the reloadLock
+// held.
+func (self *PipelineConfig) promoteCanary(c *filterCanary) {
+	runner, err := c.maker.MakeRunner("")
+	if err != nil {
+		self.rollbackCanary(c, fmt.Sprintf("can't promote canary: %s", err))
+		return
+	}
+	self.RemoveFilterRunner(c.canary.name)
+	self.removeFilter(c.name)
+	delete(self.canaries, c.name)
+	if err = self.addFilterRunner(c.maker, runner.(FilterRunner)); err != nil {
+		// The old version is gone already, so start it again.
+		failure := fmt.Sprintf("can't promote canary: %s", err)
+		if oldMaker, err := NewPluginMaker(c.name, self, c.oldSection); err != nil {
+			LogError.Printf("Filter '%s' can't be restarted: %s", c.name, err)
+		} else if err = self.removeFilterChange(c.name, oldMaker).undo(); err != nil {
+			LogError.Printf("Filter '%s' can't be restarted: %s", c.name, err)
+		}
+		self.canaryFailed(c, failure)
+		return
+	}
+	good := *self.lastGoodConfig
+	good.Canaries = self.canaryNames()
+	self.lastGoodConfig = &good
+	LogInfo.Printf("Filter '%s' canary promoted", c.name)
+}
+
+// Stops the canary, leaving the old version of its filter with all of the
+// messages. Must be called with the reloadLock held.
+func (self *PipelineConfig) rollbackCanary(c *filterCanary, failure string) {
+	c.old.matcher.setSplit(nil)
+	self.RemoveFilterRunner(c.canary.name)
+	delete(self.canaries, c.name)
+	self.canaryFailed(c, failure)
+}
+
+// Records the failure of the canary: the config version that started it
+// becomes the last failed version, and the config running now, with the old
+// version of the filter, a new good version.
+func (self *PipelineConfig) canaryFailed(c *filterCanary, failure string) {
+	diff := ConfigDiff{Changed: []string{c.name}}
+	failed := *self.lastGoodConfig
+	failed.Diff = diff
+	failed.Errors = []string{fmt.Sprintf("[%s]: %s", c.name, failure)}
+	failed.Canaries = nil
+
+	good := *self.lastGoodConfig
+	self.configVersions++
+	good.Version = self.configVersions
+	good.Time = time.Now()
+	good.Diff = diff
+	good.Canaries = self.canaryNames()
+	good.sections = make(ConfigFile, len(failed.sections))
+	for name, section := range failed.sections {
+		good.sections[name] = section
+	}
+	good.sections[c.name] = c.oldSection
+	// The old version of the filter doesn't run the new version of its
+	// files either.
+	good.digests = make(map[string]string, len(failed.digests))
+	for name, digest := range failed.digests {
+		good.digests[name] = digest
+	}
+	if _, ok := good.digests[c.name]; ok {
+		good.digests[c.name] = ""
+	}
+
+	self.lastFailedConfig = &failed
+	self.lastGoodConfig = &good
+	LogError.Printf("Filter '%s' canary rolled back: %s", c.name, failure)
+}
+
+// Returns the names of the filters with a canary rollout in progress.
+func (self *PipelineConfig) canaryNames() []string {
+	var names []string
+	for name := range self.canaries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
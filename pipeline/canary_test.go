@@ -0,0 +1,99 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+	"github.com/pborman/uuid"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CanarySpec(c gs.Context) {
+	c.Specify("A canary split", func() {
+		split := &canarySplit{percent: 10}
+		oldSide := &splitSide{split, false}
+		canarySide := &splitSide{split, true}
+
+		c.Specify("gives each message to exactly one side", func() {
+			var canaries int64
+			msg := new(message.Message)
+			for i := 0; i < 2000; i++ {
+				msg.SetUuid(uuid.NewRandom())
+				keepOld, keepCanary := oldSide.keep(msg), canarySide.keep(msg)
+				c.Expect(keepOld, gs.Equals, !keepCanary)
+				c.Expect(canarySide.keep(msg), gs.Equals, keepCanary)
+				if keepCanary {
+					canaries++
+				}
+			}
+			c.Expect(canaries > 100 && canaries < 300, gs.IsTrue)
+			c.Expect(split.canaryCount, gs.Equals, 2*canaries)
+		})
+	})
+
+	c.Specify("A canary config", func() {
+		config := new(CanaryConfig)
+
+		c.Specify("has defaults", func() {
+			c.Expect(config.validate(), gs.IsNil)
+			c.Expect(config.Percent, gs.Equals, uint(10))
+			c.Expect(config.Duration, gs.Equals, uint(300))
+			c.Expect(config.MinMessages, gs.Equals, int64(100))
+		})
+
+		c.Specify("rejects invalid settings", func() {
+			config.Percent = 100
+			c.Expect(config.validate(), gs.Not(gs.IsNil))
+			config.Percent = 50
+			config.MaxErrorRate = 2
+			c.Expect(config.validate(), gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A canary health check", func() {
+		pc := NewPipelineConfig(nil)
+		runner := &foRunner{pRunnerBase: pRunnerBase{name: "f" + canarySuffix}}
+		pc.FilterRunners[runner.name] = runner
+		canary := &filterCanary{
+			name:   "f",
+			config: CanaryConfig{MaxErrorRate: 0.1, MinMessages: 10},
+			canary: runner,
+			split:  &canarySplit{percent: 10},
+		}
+
+		c.Specify("passes a canary without errors", func() {
+			c.Expect(pc.canaryFailure(canary, false), gs.Equals, "")
+			c.Expect(pc.canaryFailure(canary, true), gs.Equals, "")
+		})
+
+		c.Specify("waits for enough messages before the end", func() {
+			runner.errorCounts[ErrorData] = 1
+			canary.split.canaryCount = 5
+			c.Expect(pc.canaryFailure(canary, false), gs.Equals, "")
+			c.Expect(pc.canaryFailure(canary, true), gs.Not(gs.Equals), "")
+		})
+
+		c.Specify("checks the error rate", func() {
+			canary.split.canaryCount = 100
+			runner.errorCounts[ErrorData] = 5
+			runner.errorCounts[ErrorTransient] = 50
+			c.Expect(pc.canaryFailure(canary, false), gs.Equals, "")
+			runner.errorCounts[ErrorFatal] = 10
+			c.Expect(pc.canaryFailure(canary, false), gs.Not(gs.Equals), "")
+		})
+
+		c.Specify("fails a terminated canary", func() {
+			delete(pc.FilterRunners, runner.name)
+			c.Expect(pc.canaryFailure(canary, false), gs.Equals, "canary terminated")
+		})
+	})
+}
@@ -0,0 +1,214 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4"
+)
+
+// CompressionCodec wraps readers and writers with a specific compression
+// format. Codecs are registered by name and looked up from plugin config, so
+// every plugin that compresses data supports the same set of formats. The
+// gzip, snappy and lz4 codecs are built in, codecs that need C libraries,
+// such as zstd, are registered by plugin packages.
+type CompressionCodec interface {
+	// Returns a writer that compresses everything written to it into w.
+	// Close must be called to flush any buffered data, it does *not* close w.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	// Returns a reader that decompresses data read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// MagicCodec is implemented by codecs whose output always starts with the
+// same magic number, which allows compressed data to be recognized.
+type MagicCodec interface {
+	CompressionCodec
+	Magic() []byte
+}
+
+var (
+	compressionCodecs     = make(map[string]CompressionCodec)
+	compressionCodecsLock sync.RWMutex
+)
+
+// RegisterCompressionCodec makes a codec available under the given
+// (case-insensitive) name. Registering a name twice replaces the earlier
+// codec, which allows external plugins to provide alternate implementations.
+func RegisterCompressionCodec(name string, codec CompressionCodec) {
+	compressionCodecsLock.Lock()
+	compressionCodecs[strings.ToLower(name)] = codec
+	compressionCodecsLock.Unlock()
+}
+
+// GetCompressionCodec returns the codec registered under the given name. An
+// empty name or "none" returns a nil codec and no error, meaning that data
+// should be passed through untouched.
+func GetCompressionCodec(name string) (CompressionCodec, error) {
+	name = strings.ToLower(name)
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	compressionCodecsLock.RLock()
+	codec, ok := compressionCodecs[name]
+	compressionCodecsLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec '%s', available: %s",
+			name, strings.Join(CompressionCodecNames(), ", "))
+	}
+	return codec, nil
+}
+
+// CompressionCodecNames returns the sorted names of all registered codecs.
+func CompressionCodecNames() []string {
+	compressionCodecsLock.RLock()
+	defer compressionCodecsLock.RUnlock()
+	names := make([]string, 0, len(compressionCodecs))
+	for name := range compressionCodecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasCompressionMagic returns whether data starts with the codec's magic
+// number. It's always false for codecs that don't implement MagicCodec.
+func HasCompressionMagic(codec CompressionCodec, data []byte) bool {
+	m, ok := codec.(MagicCodec)
+	return ok && bytes.HasPrefix(data, m.Magic())
+}
+
+// DetectCompressionCodec returns the name and codec of the registered codec
+// whose magic number data starts with, or "" and a nil codec if data isn't
+// recognized as compressed.
+func DetectCompressionCodec(data []byte) (string, CompressionCodec) {
+	for _, name := range CompressionCodecNames() {
+		codec, _ := GetCompressionCodec(name)
+		if HasCompressionMagic(codec, data) {
+			return name, codec
+		}
+	}
+	return "", nil
+}
+
+// Compress returns data compressed as a single, self-contained stream. The
+// formats of all built-in codecs allow such streams to be concatenated, so
+// it's safe to append the output of several calls to the same file.
+func Compress(codec CompressionCodec, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := codec.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FlushCompressed makes everything written so far to a writer returned by a
+// codec's NewWriter available to the reading end, e.g. after each record
+// sent over a network connection. Writers that don't buffer have nothing to
+// flush.
+func FlushCompressed(w io.Writer) error {
+	if f, ok := w.(interface {
+		Flush() error
+	}); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Decompress returns the decompressed contents of data.
+func Decompress(codec CompressionCodec, data []byte) ([]byte, error) {
+	r, err := codec.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type gzipCodec struct{}
+
+func (c gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (c gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (c gzipCodec) Magic() []byte {
+	return []byte{0x1f, 0x8b}
+}
+
+// Uses the snappy framing format, plain block encoding doesn't support
+// streaming.
+type snappyCodec struct{}
+
+// snappy.NewWriter is unbuffered, each Write emits complete chunks, so
+// there's nothing left to flush on Close.
+type snappyWriteCloser struct {
+	io.Writer
+}
+
+func (s snappyWriteCloser) Close() error {
+	return nil
+}
+
+func (c snappyCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return snappyWriteCloser{snappy.NewWriter(w)}, nil
+}
+
+func (c snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(snappy.NewReader(r)), nil
+}
+
+// Stream identifier chunk every framed stream starts with.
+func (c snappyCodec) Magic() []byte {
+	return []byte("\xff\x06\x00\x00sNaPpY")
+}
+
+// Uses the LZ4 frame format.
+type lz4Codec struct{}
+
+func (c lz4Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return lz4.NewWriter(w), nil
+}
+
+func (c lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+func (c lz4Codec) Magic() []byte {
+	return []byte{0x04, 0x22, 0x4d, 0x18}
+}
+
+func init() {
+	RegisterCompressionCodec("gzip", gzipCodec{})
+	RegisterCompressionCodec("snappy", snappyCodec{})
+	RegisterCompressionCodec("lz4", lz4Codec{})
+}
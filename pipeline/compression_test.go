@@ -0,0 +1,83 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CompressionSpec(c gs.Context) {
+	data := bytes.Repeat([]byte("compress me, please. "), 100)
+
+	c.Specify("The built-in compression codecs", func() {
+		for _, name := range []string{"gzip", "snappy", "lz4"} {
+			codec, err := GetCompressionCodec(name)
+			c.Assume(err, gs.IsNil)
+			c.Assume(codec, gs.Not(gs.IsNil))
+
+			compressed, err := Compress(codec, data)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(compressed) < len(data), gs.IsTrue)
+
+			// Concatenated streams must decompress to the concatenated data.
+			compressed = append(compressed, compressed...)
+			decompressed, err := Decompress(codec, compressed)
+			c.Expect(err, gs.IsNil)
+			c.Expect(bytes.Equal(decompressed, append(data, data...)), gs.IsTrue)
+		}
+	})
+
+	c.Specify("Codec detection", func() {
+		c.Specify("recognizes the built-in codecs' output", func() {
+			for _, name := range []string{"gzip", "snappy", "lz4"} {
+				codec, _ := GetCompressionCodec(name)
+				compressed, err := Compress(codec, data)
+				c.Assume(err, gs.IsNil)
+				detected, detectedCodec := DetectCompressionCodec(compressed)
+				c.Expect(detected, gs.Equals, name)
+				c.Expect(detectedCodec, gs.Equals, codec)
+			}
+		})
+
+		c.Specify("doesn't recognize uncompressed data", func() {
+			detected, codec := DetectCompressionCodec(data)
+			c.Expect(detected, gs.Equals, "")
+			c.Expect(codec, gs.IsNil)
+			detected, codec = DetectCompressionCodec(nil)
+			c.Expect(detected, gs.Equals, "")
+		})
+	})
+
+	c.Specify("Codec lookup", func() {
+		c.Specify("is case insensitive", func() {
+			codec, err := GetCompressionCodec("GZIP")
+			c.Expect(err, gs.IsNil)
+			c.Expect(codec, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("returns a nil codec for 'none'", func() {
+			codec, err := GetCompressionCodec("none")
+			c.Expect(err, gs.IsNil)
+			c.Expect(codec, gs.IsNil)
+			codec, err = GetCompressionCodec("")
+			c.Expect(err, gs.IsNil)
+			c.Expect(codec, gs.IsNil)
+		})
+
+		c.Specify("fails for unknown codecs", func() {
+			_, err := GetCompressionCodec("lzma")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -24,6 +24,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"reflect"
 	"regexp"
@@ -166,6 +167,11 @@ type PipelineConfig struct {
 	hostname string
 	// Heka process id.
 	pid int32
+	// Connection of the hekad we handed the listeners over to, closed once
+	// we've shut down.
+	upgradeConn *net.UnixConn
+	// Mutex protecting upgradeConn.
+	upgradeLock sync.Mutex
 	// Lock protecting access to the set of running inputs so they
 	// can be safely added while Heka is running.
 	inputsLock sync.RWMutex
@@ -176,6 +182,27 @@ type PipelineConfig struct {
 	outputsLock sync.RWMutex
 	// Internal reporting channel.
 	reportRecycleChan chan *PipelinePack
+	// Shared resources, by name.
+	resources map[string]interface{}
+	// Lock protecting access to the resources.
+	resourcesLock sync.RWMutex
+	// Usage accounting, if an accounting key is configured.
+	accountant *usageAccountant
+	// Counters reported at the end of a one-shot run.
+	oneShot oneShotStats
+	// Config file or directory the plugin config was loaded from, used to
+	// reload it.
+	ConfigPath string
+	// Lock serializing config reloads.
+	reloadLock sync.Mutex
+	// Number of the latest config version.
+	configVersions int
+	// Last config version that was applied, and last one that failed.
+	lastGoodConfig   *ConfigVersion
+	lastFailedConfig *ConfigVersion
+	// Filters being rolled out as canaries, by name. Protected by the
+	// reloadLock.
+	canaries map[string]*filterCanary
 
 	// The next few values are used only during the initial configuration
 	// loading process.
@@ -184,6 +211,12 @@ type PipelineConfig struct {
 	defaultConfigs map[string]bool
 	// Loaded PluginMakers sorted by category.
 	makersByCategory map[string][]PluginMaker
+	// TOML sections of the shared resources, by name.
+	resourceSections map[string]toml.Primitive
+	// Paths of the loaded config files.
+	configFiles []string
+	// All loaded TOML sections, by name.
+	configSections ConfigFile
 	// Number of config loading errors.
 	errcnt uint
 }
@@ -211,6 +244,10 @@ func NewPipelineConfig(globals *GlobalConfigStruct) (config *PipelineConfig) {
 
 	config.allEncoders = make(map[string]Encoder)
 	config.router = NewMessageRouter(globals.PluginChanSize, globals.abortChan)
+	if globals.AccountingKey != "" {
+		config.accountant = newUsageAccountant(globals.AccountingKey)
+		config.router.accountant = config.accountant
+	}
 	config.inputRecycleChan = make(chan *PipelinePack, globals.PoolSize)
 	config.injectRecycleChan = make(chan *PipelinePack, globals.PoolSize)
 	config.LogMsgs = make([]string, 0, 4)
@@ -220,6 +257,8 @@ func NewPipelineConfig(globals *GlobalConfigStruct) (config *PipelineConfig) {
 	config.hostname = globals.Hostname
 	config.pid = int32(os.Getpid())
 	config.reportRecycleChan = make(chan *PipelinePack, 1)
+	config.resources = make(map[string]interface{})
+	config.canaries = make(map[string]*filterCanary)
 
 	return config
 }
@@ -317,6 +356,7 @@ func (self *PipelineConfig) DecoderRunner(baseName, fullName string) (
 	}
 
 	dRunner = runner.(DecoderRunner)
+	setDecoderBaseName(dRunner, baseName)
 	self.allDecodersLock.Lock()
 	self.allDecoders = append(self.allDecoders, dRunner)
 	self.allDecodersLock.Unlock()
@@ -545,8 +585,16 @@ type CommonInputConfig struct {
 	SyncDecode         *bool `toml:"synchronous_decode"`
 	SendDecodeFailures *bool `toml:"send_decode_failures"`
 	LogDecodeFailures  *bool `toml:"log_decode_failures"`
-	CanExit            *bool `toml:"can_exit"`
-	Retries            RetryOptions
+	// What to do with packs that fail decoding, "drop", "pass" or "route".
+	// Defaults to "pass" if send_decode_failures is true, "drop" otherwise.
+	DecodeFailureAction string `toml:"decode_failure_action"`
+	// Name of the decoder or filter packs that fail decoding are routed to.
+	DecodeFailureTarget string `toml:"decode_failure_target"`
+	// Maximum number of decoders the input's DecoderRunners run in parallel
+	// when their backlog persists. 0 or 1 for a single decoder.
+	MaxDecoders uint  `toml:"max_decoders"`
+	CanExit     *bool `toml:"can_exit"`
+	Retries     RetryOptions
 }
 
 type CommonFOConfig struct {
@@ -559,6 +607,33 @@ type CommonFOConfig struct {
 	UseFraming   *bool              `toml:"use_framing"` // Output only.
 	UseBuffering *bool              `toml:"use_buffering"`
 	Buffering    *QueueBufferConfig `toml:"buffering"`
+	Sampling     []SamplingRule     `toml:"sampling"` // Output only.
+	// Seconds between sampling summary messages, 0 disables them. Output
+	// only.
+	SamplingSummaryInterval *uint `toml:"sampling_summary_interval"`
+	// Number of the most recent matched messages to retain for debugging, 0
+	// to retain none.
+	DebugBufferSize uint `toml:"debug_buffer_size"`
+	// Rolls the filter out as a canary when it's changed by a config reload.
+	// Filter only.
+	Canary *CanaryConfig `toml:"canary"`
+	// Name of the LeaderElection resource the plugin only runs on the leader
+	// of.
+	LeaderElection string `toml:"leader_election"`
+	// Largest encoded record in bytes, 0 for no limit. Output only.
+	MaxRecordSize uint `toml:"max_record_size"`
+	// What to do with records over max_record_size, "drop" or "truncate".
+	// Output only.
+	OversizePolicy string `toml:"oversize_policy"`
+	// Largest request in bytes for outputs that batch records, 0 for no
+	// limit. Output only.
+	MaxRequestSize uint `toml:"max_request_size"`
+	// Cron schedule of the timer events, e.g. "0 * * * *" for every full
+	// hour. Takes precedence over ticker_interval.
+	TickerCron string `toml:"ticker_cron"`
+	// Re-injects messages dropped because of data errors as
+	// "heka.dead-letter" messages instead of discarding them.
+	DeadLetter bool `toml:"dead_letter"`
 }
 
 type CommonSplitterConfig struct {
@@ -632,11 +707,26 @@ func (self *PipelineConfig) PreloadFromConfigFile(filename string) error {
 		self.defaultConfigs = makeDefaultConfigs()
 	}
 
+	if self.configSections == nil {
+		self.configSections = make(ConfigFile)
+	}
+	self.configFiles = append(self.configFiles, filename)
+	for name, conf := range configFile {
+		self.configSections[name] = conf
+	}
+
 	// Load all the plugin makers and file them by category.
 	for name, conf := range configFile {
 		if name == HEKA_DAEMON {
 			continue
 		}
+		if name == RESOURCES {
+			if err = self.preloadResources(conf); err != nil {
+				self.log(err.Error())
+				self.errcnt++
+			}
+			continue
+		}
 		if _, ok := self.defaultConfigs[name]; ok {
 			self.defaultConfigs[name] = true
 		}
@@ -683,6 +773,10 @@ func (self *PipelineConfig) LoadConfig() error {
 		return errors.New("Empty configuration, exiting.")
 	}
 
+	// Resources need to exist before any of the plugins referencing them are
+	// initialized.
+	self.loadResources()
+
 	var err error
 
 	multiDecoders := make([]multiDecoderNode, len(makersByCategory["MultiDecoder"]))
@@ -738,7 +832,20 @@ func (self *PipelineConfig) LoadConfig() error {
 				continue
 			}
 			switch category {
+			case "Decoder":
+				// Decoder runners are created on demand, but a decoder's test
+				// fixtures need to pass before anything can use it.
+				if err = self.testDecoder(maker); err != nil {
+					self.log(err.Error())
+					self.errcnt++
+				}
 			case "Input":
+				if ir, ok := runner.(*iRunner); ok {
+					if err = self.checkMaxDecoders(ir.config, ir.syncDecode); err != nil {
+						self.log(fmt.Sprintf("%s: %s", maker.Name(), err))
+						self.errcnt++
+					}
+				}
 				self.InputRunners[maker.Name()] = runner.(InputRunner)
 			case "Filter":
 				self.FilterRunners[maker.Name()] = runner.(FilterRunner)
@@ -752,6 +859,7 @@ func (self *PipelineConfig) LoadConfig() error {
 		return fmt.Errorf("%d errors loading plugins", self.errcnt)
 	}
 
+	self.setInitialConfig()
 	return nil
 }
 
@@ -765,6 +873,10 @@ func subsFromSection(section toml.Primitive) []string {
 			subs[i], _ = subUntyped.(string)
 		}
 	}
+	// The failure decoder has to be loaded first, like the subs.
+	if failureDecoder, ok := secMap["failure_decoder"].(string); ok && failureDecoder != "" {
+		subs = append(subs, failureDecoder)
+	}
 	return subs
 }
 
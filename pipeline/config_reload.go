@@ -0,0 +1,590 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bbangert/toml"
+)
+
+// Sections that differ between two plugin configs, by name.
+type ConfigDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+}
+
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d ConfigDiff) String() string {
+	var parts []string
+	if len(d.Added) > 0 {
+		parts = append(parts, "added: "+strings.Join(d.Added, ", "))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, "removed: "+strings.Join(d.Removed, ", "))
+	}
+	if len(d.Changed) > 0 {
+		parts = append(parts, "changed: "+strings.Join(d.Changed, ", "))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// A version of the plugin config, as read from the config files. Version 1
+// is the config Heka started with, each reload that changes the config gets
+// the next version whether or not it could be applied.
+type ConfigVersion struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Files   []string  `json:"files"`
+	// Changes from the last good version.
+	Diff ConfigDiff `json:"diff"`
+	// Errors that prevented this version from being applied, if any.
+	Errors []string `json:"errors,omitempty"`
+	// Filters whose new version is still being rolled out as a canary.
+	Canaries []string `json:"canaries,omitempty"`
+	sections ConfigFile
+	// Digests of the files used by the plugins implementing UsesFiles, by
+	// section name.
+	digests map[string]string
+}
+
+// Returns the paths of the config files making up the config at configPath,
+// which is either a single file or a directory whose *.toml files are used.
+func ConfigFiles(configPath string) ([]string, error) {
+	p, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %s", err.Error())
+	}
+	defer p.Close()
+	fi, err := p.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("can't stat file: %s", err.Error())
+	}
+	if !fi.IsDir() {
+		return []string{configPath}, nil
+	}
+	files, _ := ioutil.ReadDir(configPath)
+	var paths []string
+	for _, f := range files {
+		// Skip non *.toml files in a config dir.
+		if strings.HasSuffix(f.Name(), ".toml") {
+			paths = append(paths, filepath.Join(configPath, f.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// Returns the names of the sections that were added, removed or changed
+// going from the old config to the new one.
+func diffConfigs(old, new ConfigFile) (diff ConfigDiff) {
+	for name, section := range new {
+		if oldSection, ok := old[name]; !ok {
+			diff.Added = append(diff.Added, name)
+		} else if !reflect.DeepEqual(section, oldSection) {
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// Returns the last config version that was applied and the last one that
+// failed and was rolled back, if any. The last good version is nil until the
+// config has been loaded.
+func (self *PipelineConfig) ConfigVersions() (lastGood, lastFailed *ConfigVersion) {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+	return self.lastGoodConfig, self.lastFailedConfig
+}
+
+// Re-reads the config files from ConfigPath and applies the changes to the
+// running pipeline. Decoders can be added or changed, filters added, removed
+// or changed and inputs added or removed; any other change requires a
+// restart. All of the new and changed plugins are initialized before
+// anything changes, and if any of them fails, or a change can't be applied,
+// the changes already made are undone, leaving the previous plugins
+// running. The failed version is kept for reporting. Changed filters with a
+// canary config are rolled out as canaries instead of being replaced right
+// away. Must only be called while the pipeline is running.
+func (self *PipelineConfig) ReloadConfig() (*ConfigVersion, error) {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+	lastGood := self.lastGoodConfig
+	if lastGood == nil {
+		return nil, errors.New("the config hasn't been loaded")
+	}
+	if self.ConfigPath == "" {
+		return nil, errors.New("no config path to reload from")
+	}
+
+	version := &ConfigVersion{
+		Version: self.configVersions + 1,
+		Time:    time.Now(),
+	}
+	var err error
+	version.Files, version.sections, err = readConfigSections(self.ConfigPath)
+	if err != nil {
+		version.Errors = []string{err.Error()}
+	} else {
+		version.Diff = diffConfigs(lastGood.sections, version.sections)
+		version.digests = self.fileDigests(version.sections)
+		version.Diff.Changed = changedFiles(version.Diff, lastGood.digests,
+			version.digests)
+		if version.Diff.Empty() {
+			LogInfo.Println("Config reload: no changes")
+			return lastGood, nil
+		}
+		version.Errors = self.applyConfig(lastGood.sections, version.sections,
+			version.Diff)
+	}
+	self.configVersions = version.Version
+
+	if len(version.Errors) > 0 {
+		self.lastFailedConfig = version
+		err = fmt.Errorf("config version %d (%s) failed, rolled back to version %d: %s",
+			version.Version, version.Diff, lastGood.Version,
+			strings.Join(version.Errors, "; "))
+		LogError.Println(err)
+		return version, err
+	}
+	version.Canaries = self.canaryNames()
+	self.lastGoodConfig = version
+	LogInfo.Printf("Config version %d applied (%s)", version.Version, version.Diff)
+	return version, nil
+}
+
+// Reads and merges the sections of all of the config files at configPath.
+func readConfigSections(configPath string) ([]string, ConfigFile, error) {
+	files, err := ConfigFiles(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sections := make(ConfigFile)
+	for _, filename := range files {
+		contents, err := ReplaceEnvsFile(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		var configFile ConfigFile
+		if _, err = toml.Decode(contents, &configFile); err != nil {
+			return nil, nil, fmt.Errorf("Error decoding config file %s: %s",
+				filename, err)
+		}
+		for name, section := range configFile {
+			sections[name] = section
+		}
+	}
+	return files, sections, nil
+}
+
+// Records the config the pipeline was loaded with as the first version.
+func (self *PipelineConfig) setInitialConfig() {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+	self.configVersions = 1
+	self.lastGoodConfig = &ConfigVersion{
+		Version:  1,
+		Time:     time.Now(),
+		Files:    self.configFiles,
+		sections: self.configSections,
+		digests:  self.fileDigests(self.configSections),
+	}
+}
+
+// Returns the digests of the contents of the files used by the plugins of
+// the sections that implement UsesFiles. Files that can't be read are part
+// of the digest as such, the plugin's Init reports the error.
+func (self *PipelineConfig) fileDigests(sections ConfigFile) map[string]string {
+	digests := make(map[string]string)
+	for name, section := range sections {
+		if name == HEKA_DAEMON || name == RESOURCES {
+			continue
+		}
+		maker, err := NewPluginMaker(name, self, section)
+		if err != nil {
+			continue
+		}
+		usesFiles, ok := maker.(*pluginMaker).plugin.(UsesFiles)
+		if !ok {
+			continue
+		}
+		config, err := maker.PrepConfig()
+		if err != nil {
+			continue
+		}
+		h := sha1.New()
+		for _, path := range usesFiles.FilesUsed(config) {
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				contents = []byte(err.Error())
+			}
+			fmt.Fprintf(h, "%s %d\n", path, len(contents))
+			h.Write(contents)
+		}
+		digests[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+// Returns the changed sections of the diff, plus those whose config is
+// unchanged but whose files changed.
+func changedFiles(diff ConfigDiff, old, new map[string]string) []string {
+	changed := diff.Changed
+	listed := make(map[string]bool)
+	for _, name := range append(diff.Added, diff.Changed...) {
+		listed[name] = true
+	}
+	for name, digest := range new {
+		if oldDigest, ok := old[name]; ok && oldDigest != digest && !listed[name] {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// A prepared change to a running plugin, and the change undoing it.
+type configChange struct {
+	apply func() error
+	undo  func() error
+}
+
+// Validates the changes between the old and new config and applies them,
+// undoing the applied changes if one fails. Returns the errors preventing
+// the new config from being applied.
+func (self *PipelineConfig) applyConfig(old, new ConfigFile, diff ConfigDiff) []string {
+	var errs []string
+	fail := func(name string, err error) {
+		errs = append(errs, fmt.Sprintf("[%s]: %s", name, err))
+	}
+	// Inputs are stopped first and started last, so they don't use plugins
+	// that are being changed.
+	var stops, changes, starts []configChange
+
+	for _, name := range diff.Removed {
+		if name == HEKA_DAEMON || name == RESOURCES {
+			fail(name, errors.New("removing this section requires a restart"))
+			continue
+		}
+		oldMaker, err := NewPluginMaker(name, self, old[name])
+		if err != nil {
+			fail(name, err)
+			continue
+		}
+		switch oldMaker.Category() {
+		case "Input":
+			stops = append(stops, self.removeInputChange(name, oldMaker))
+		case "Filter":
+			if _, ok := self.canaries[name]; ok {
+				fail(name, errors.New("a canary rollout of this filter is in progress"))
+				continue
+			}
+			changes = append(changes, self.removeFilterChange(name, oldMaker))
+		default:
+			fail(name, fmt.Errorf("removing %s plugins requires a restart",
+				oldMaker.Category()))
+		}
+	}
+
+	for _, name := range append(diff.Added, diff.Changed...) {
+		name := name
+		if name == HEKA_DAEMON || name == RESOURCES {
+			fail(name, errors.New("changing this section requires a restart"))
+			continue
+		}
+		maker, err := NewPluginMaker(name, self, new[name])
+		if err != nil {
+			fail(name, err)
+			continue
+		}
+		var oldMaker PluginMaker
+		if _, ok := old[name]; ok {
+			if oldMaker, err = NewPluginMaker(name, self, old[name]); err != nil {
+				fail(name, err)
+				continue
+			}
+			if oldMaker.Category() != maker.Category() {
+				fail(name, errors.New("changing a plugin's category requires a restart"))
+				continue
+			}
+		}
+		switch maker.Category() {
+		case "Decoder":
+			if _, _, err = maker.Make(); err == nil {
+				err = self.testDecoder(maker)
+			}
+			if err == nil {
+				changes = append(changes, self.decoderChange(name, maker))
+			}
+		case "Filter":
+			if _, ok := self.canaries[name]; ok {
+				err = errors.New("a canary rollout of this filter is in progress")
+				break
+			}
+			var runner PluginRunner
+			if runner, err = maker.MakeRunner(""); err != nil {
+				break
+			}
+			if oldMaker != nil {
+				fr, _ := runner.(*foRunner)
+				if fr != nil {
+					if canary := self.canaryChange(name, maker, old[name], fr); canary != nil {
+						changes = append(changes, *canary)
+						break
+					}
+				}
+				changes = append(changes, self.removeFilterChange(name, oldMaker))
+			}
+			changes = append(changes, configChange{
+				apply: func() error {
+					return self.addFilterRunner(maker, runner.(FilterRunner))
+				},
+				undo: func() error {
+					self.removeFilter(name)
+					return nil
+				},
+			})
+		case "Input":
+			if oldMaker != nil {
+				err = errors.New("changing Input plugins requires a restart")
+				break
+			}
+			var runner PluginRunner
+			if runner, err = maker.MakeRunner(""); err != nil {
+				break
+			}
+			starts = append(starts, configChange{
+				apply: func() error {
+					return self.addInputRunner(maker, runner.(InputRunner))
+				},
+				undo: func() error {
+					self.removeInput(name)
+					return nil
+				},
+			})
+		default:
+			err = fmt.Errorf("changing %s plugins requires a restart", maker.Category())
+		}
+		if err != nil {
+			fail(name, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+
+	var applied []configChange
+	for _, change := range append(append(stops, changes...), starts...) {
+		if err := change.apply(); err != nil {
+			errs = append(errs, err.Error())
+			break
+		}
+		applied = append(applied, change)
+	}
+	if len(errs) > 0 {
+		for i := len(applied) - 1; i >= 0; i-- {
+			if err := applied[i].undo(); err != nil {
+				LogError.Printf("Config rollback: %s", err)
+			}
+		}
+	}
+	return errs
+}
+
+// Returns the change replacing the named decoder's config, or registering a
+// new decoder.
+func (self *PipelineConfig) decoderChange(name string, maker PluginMaker) configChange {
+	self.makersLock.RLock()
+	oldMaker, exists := self.DecoderMakers[name]
+	self.makersLock.RUnlock()
+	if !exists {
+		return configChange{
+			apply: func() error {
+				self.makersLock.Lock()
+				self.DecoderMakers[name] = maker
+				self.makersLock.Unlock()
+				return nil
+			},
+			undo: func() error {
+				self.makersLock.Lock()
+				delete(self.DecoderMakers, name)
+				self.makersLock.Unlock()
+				return nil
+			},
+		}
+	}
+	return configChange{
+		apply: func() error {
+			_, err := self.SwapDecoderConfig(name, maker.(*pluginMaker).tomlSection)
+			return err
+		},
+		undo: func() error {
+			_, err := self.SwapDecoderConfig(name, oldMaker.(*pluginMaker).tomlSection)
+			return err
+		},
+	}
+}
+
+// Returns the change stopping the named filter, whose undo starts a filter
+// made from the old config.
+func (self *PipelineConfig) removeFilterChange(name string, oldMaker PluginMaker) configChange {
+	return configChange{
+		apply: func() error {
+			self.removeFilter(name)
+			return nil
+		},
+		undo: func() error {
+			runner, err := oldMaker.MakeRunner("")
+			if err != nil {
+				return err
+			}
+			return self.addFilterRunner(oldMaker, runner.(FilterRunner))
+		},
+	}
+}
+
+// Returns the change stopping the named input, whose undo starts an input
+// made from the old config.
+func (self *PipelineConfig) removeInputChange(name string, oldMaker PluginMaker) configChange {
+	return configChange{
+		apply: func() error {
+			self.removeInput(name)
+			return nil
+		},
+		undo: func() error {
+			runner, err := oldMaker.MakeRunner("")
+			if err != nil {
+				return err
+			}
+			return self.addInputRunner(oldMaker, runner.(InputRunner))
+		},
+	}
+}
+
+func (self *PipelineConfig) addFilterRunner(maker PluginMaker, runner FilterRunner) error {
+	if err := self.AddFilterRunner(runner); err != nil {
+		self.filtersLock.Lock()
+		delete(self.FilterRunners, runner.Name())
+		self.filtersLock.Unlock()
+		return err
+	}
+	self.makersLock.Lock()
+	self.makers["Filter"][runner.Name()] = maker
+	self.makersLock.Unlock()
+	return nil
+}
+
+func (self *PipelineConfig) removeFilter(name string) {
+	self.RemoveFilterRunner(name)
+	self.makersLock.Lock()
+	delete(self.makers["Filter"], name)
+	self.makersLock.Unlock()
+}
+
+func (self *PipelineConfig) addInputRunner(maker PluginMaker, runner InputRunner) error {
+	if err := self.AddInputRunner(runner); err != nil {
+		self.inputsLock.Lock()
+		delete(self.InputRunners, runner.Name())
+		self.inputsLock.Unlock()
+		return err
+	}
+	self.makersLock.Lock()
+	self.makers["Input"][runner.Name()] = maker
+	self.makersLock.Unlock()
+	return nil
+}
+
+func (self *PipelineConfig) removeInput(name string) {
+	self.inputsLock.RLock()
+	runner, ok := self.InputRunners[name]
+	self.inputsLock.RUnlock()
+	if ok {
+		self.RemoveInputRunner(runner)
+	}
+}
+
+// Handles `GET /config` requests on the admin endpoint, returning the last
+// good and the last failed config versions as JSON.
+func (self *PipelineConfig) configVersionsHandler(w http.ResponseWriter,
+	req *http.Request) {
+
+	if req.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	lastGood, lastFailed := self.ConfigVersions()
+	writeConfigJSON(w, http.StatusOK, map[string]*ConfigVersion{
+		"last_good":   lastGood,
+		"last_failed": lastFailed,
+	})
+}
+
+// Handles `POST /config/reload` requests on the admin endpoint, reloading
+// the config and returning the resulting version as JSON. Responds with a
+// 409 status if the new version failed and was rolled back.
+func (self *PipelineConfig) configReloadHandler(w http.ResponseWriter,
+	req *http.Request) {
+
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	version, err := self.ReloadConfig()
+	if version == nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusConflict
+	}
+	writeConfigJSON(w, status, version)
+}
+
+func writeConfigJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -0,0 +1,251 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+type FileTypeDecoderConfig struct {
+	TypeFile string `toml:"type_file"`
+}
+
+// Decoder setting the message type to the contents of a file.
+type FileTypeDecoder struct {
+	SwapTestDecoder
+}
+
+func (d *FileTypeDecoder) ConfigStruct() interface{} {
+	return new(FileTypeDecoderConfig)
+}
+
+func (d *FileTypeDecoder) Init(config interface{}) error {
+	contents, err := ioutil.ReadFile(config.(*FileTypeDecoderConfig).TypeFile)
+	d.msgType = string(contents)
+	return err
+}
+
+func (d *FileTypeDecoder) FilesUsed(config interface{}) []string {
+	return []string{config.(*FileTypeDecoderConfig).TypeFile}
+}
+
+func ConfigReloadSpec(c gs.Context) {
+	RegisterPlugin("SwapTestDecoder", func() interface{} {
+		return new(SwapTestDecoder)
+	})
+	RegisterPlugin("FileTypeDecoder", func() interface{} {
+		return new(FileTypeDecoder)
+	})
+	tmpDir, err := ioutil.TempDir("", "config-reload-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	configPath := filepath.Join(tmpDir, "heka.toml")
+	writeConfig := func(config string) {
+		err := ioutil.WriteFile(configPath, []byte(config), 0644)
+		c.Assume(err, gs.IsNil)
+	}
+	writeConfig(`
+[hekad]
+maxprocs = 1
+
+[swapper]
+type = "SwapTestDecoder"
+msg_type = "v1"
+`)
+
+	pc := NewPipelineConfig(nil)
+	err = pc.PreloadFromConfigFile(configPath)
+	c.Assume(err, gs.IsNil)
+	err = pc.LoadConfig()
+	c.Assume(err, gs.IsNil)
+	pc.ConfigPath = configPath
+
+	msgType := func(name string) string {
+		decoder, ok := pc.Decoder(name)
+		if !ok {
+			return ""
+		}
+		return decoder.(*SwapTestDecoder).msgType
+	}
+
+	c.Specify("A config reload", func() {
+		lastGood, lastFailed := pc.ConfigVersions()
+		c.Assume(lastGood, gs.Not(gs.IsNil))
+		c.Expect(lastGood.Version, gs.Equals, 1)
+		c.Expect(lastFailed, gs.IsNil)
+
+		c.Specify("applies changed and added decoders", func() {
+			writeConfig(`
+[hekad]
+maxprocs = 1
+
+[swapper]
+type = "SwapTestDecoder"
+msg_type = "v2"
+
+[other]
+type = "SwapTestDecoder"
+msg_type = "other"
+`)
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.IsNil)
+			c.Expect(version.Version, gs.Equals, 2)
+			c.Expect(version.Diff.Added, gs.ContainsExactly, []string{"other"})
+			c.Expect(version.Diff.Changed, gs.ContainsExactly, []string{"swapper"})
+			c.Expect(msgType("swapper"), gs.Equals, "v2")
+			c.Expect(msgType("other"), gs.Equals, "other")
+			lastGood, _ := pc.ConfigVersions()
+			c.Expect(lastGood, gs.Equals, version)
+		})
+
+		c.Specify("replaces decoders whose files changed", func() {
+			typeFile := filepath.Join(tmpDir, "type.txt")
+			writeType := func(msgType string) {
+				err := ioutil.WriteFile(typeFile, []byte(msgType), 0644)
+				c.Assume(err, gs.IsNil)
+			}
+			fileType := func() string {
+				decoder, ok := pc.Decoder("typer")
+				if !ok {
+					return ""
+				}
+				return decoder.(*FileTypeDecoder).msgType
+			}
+			writeType("first")
+			writeConfig(`
+[hekad]
+maxprocs = 1
+
+[swapper]
+type = "SwapTestDecoder"
+msg_type = "v1"
+
+[typer]
+type = "FileTypeDecoder"
+type_file = "` + typeFile + `"
+`)
+			_, err := pc.ReloadConfig()
+			c.Assume(err, gs.IsNil)
+			c.Expect(fileType(), gs.Equals, "first")
+
+			writeType("second")
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.IsNil)
+			c.Expect(version.Version, gs.Equals, 3)
+			c.Expect(version.Diff.Added, gs.ContainsExactly, []string{})
+			c.Expect(version.Diff.Changed, gs.ContainsExactly, []string{"typer"})
+			c.Expect(fileType(), gs.Equals, "second")
+
+			same, err := pc.ReloadConfig()
+			c.Expect(err, gs.IsNil)
+			c.Expect(same, gs.Equals, version)
+		})
+
+		c.Specify("does nothing if the config is unchanged", func() {
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.IsNil)
+			c.Expect(version, gs.Equals, lastGood)
+		})
+
+		c.Specify("rolls back when a plugin fails to initialize", func() {
+			writeConfig(`
+[hekad]
+maxprocs = 1
+
+[swapper]
+type = "SwapTestDecoder"
+
+[other]
+type = "SwapTestDecoder"
+msg_type = "other"
+`)
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(version.Version, gs.Equals, 2)
+			c.Expect(version.Diff.String(), gs.Equals, "added: other; changed: swapper")
+			c.Expect(len(version.Errors), gs.Equals, 1)
+			c.Expect(msgType("swapper"), gs.Equals, "v1")
+			c.Expect(msgType("other"), gs.Equals, "")
+			good, failed := pc.ConfigVersions()
+			c.Expect(good, gs.Equals, lastGood)
+			c.Expect(failed, gs.Equals, version)
+		})
+
+		c.Specify("rolls back changes that require a restart", func() {
+			writeConfig(`
+[hekad]
+maxprocs = 2
+
+[swapper]
+type = "SwapTestDecoder"
+msg_type = "v2"
+`)
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(version.Diff.Changed, gs.ContainsExactly,
+				[]string{"hekad", "swapper"})
+			c.Expect(msgType("swapper"), gs.Equals, "v1")
+		})
+
+		c.Specify("rolls back invalid config files", func() {
+			writeConfig("[swapper\n")
+			version, err := pc.ReloadConfig()
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(version.Diff.Empty(), gs.IsTrue)
+			c.Expect(msgType("swapper"), gs.Equals, "v1")
+		})
+
+		c.Specify("is available over HTTP", func() {
+			serve := func(handler http.HandlerFunc, method, path string) *httptest.ResponseRecorder {
+				req, err := http.NewRequest(method, path, nil)
+				c.Assume(err, gs.IsNil)
+				w := httptest.NewRecorder()
+				handler(w, req)
+				return w
+			}
+			w := serve(pc.configReloadHandler, "GET", "/config/reload")
+			c.Expect(w.Code, gs.Equals, http.StatusMethodNotAllowed)
+
+			writeConfig(`
+[hekad]
+maxprocs = 1
+
+[swapper]
+type = "SwapTestDecoder"
+`)
+			w = serve(pc.configReloadHandler, "POST", "/config/reload")
+			c.Expect(w.Code, gs.Equals, http.StatusConflict)
+
+			w = serve(pc.configVersionsHandler, "GET", "/config")
+			c.Expect(w.Code, gs.Equals, http.StatusOK)
+			var versions map[string]struct {
+				Version int
+				Diff    ConfigDiff
+				Errors  []string
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &versions)
+			c.Expect(err, gs.IsNil)
+			c.Expect(versions["last_good"].Version, gs.Equals, 1)
+			c.Expect(versions["last_failed"].Version, gs.Equals, 2)
+			c.Expect(versions["last_failed"].Diff.Changed, gs.ContainsExactly,
+				[]string{"swapper"})
+			c.Expect(len(versions["last_failed"].Errors), gs.Equals, 1)
+		})
+	})
+}
@@ -0,0 +1,238 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedules the @ descriptors stand for.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul",
+		"aug", "sep", "oct", "nov", "dec"}
+	cronDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// How far ahead Next looks for a matching time before giving up, enough for
+// schedules that only match on leap days.
+const cronSearchYears = 5
+
+// A field of a cron schedule.
+type cronField struct {
+	name     string
+	min, max int
+	// Names of the values from min on, if any.
+	names []string
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, cronMonthNames},
+	// 7 is Sunday as well as 0.
+	{"day of week", 0, 7, cronDayNames},
+}
+
+// CronSchedule is a parsed crontab(5) style schedule of the minutes of the
+// day something should happen, e.g. "0 * * * *" for every full hour or
+// "*/15 9-17 * * mon-fri" for every quarter hour during office hours. Times
+// are matched in the local time zone.
+type CronSchedule struct {
+	spec string
+	// Bit sets of the matching minutes, hours, days of the month, months
+	// and days of the week, in that order.
+	bits [5]uint64
+	// Whether the day of month and day of week fields are "*". If neither
+	// is, a day matching either of them matches, as with cron.
+	domStar, dowStar bool
+}
+
+// Parses a five field cron schedule or one of the descriptors "@yearly",
+// "@annually", "@monthly", "@weekly", "@daily", "@midnight" and "@hourly".
+// Fields may contain "*", numbers, month and day of week names, ranges,
+// steps and comma separated lists.
+func ParseCronSchedule(spec string) (*CronSchedule, error) {
+	s := &CronSchedule{spec: spec}
+	expanded := strings.TrimSpace(spec)
+	if strings.HasPrefix(expanded, "@") {
+		var ok bool
+		if expanded, ok = cronDescriptors[strings.ToLower(expanded)]; !ok {
+			return nil, fmt.Errorf("unknown cron descriptor '%s'", spec)
+		}
+	}
+	fields := strings.Fields(expanded)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron schedule '%s' must have %d fields", spec,
+			len(cronFields))
+	}
+	for i, field := range fields {
+		bits, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("cron schedule '%s': %s", spec, err)
+		}
+		s.bits[i] = bits
+	}
+	// Sunday is both 0 and 7.
+	if s.bits[4]&(1<<7) != 0 {
+		s.bits[4] |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron schedule '%s' never matches", spec)
+	}
+	return s, nil
+}
+
+// Parses a comma separated list of values, ranges and steps into a bit set.
+func (f cronField) parse(field string) (bits uint64, err error) {
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			rangePart = item[:i]
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s '%s'", f.name, item)
+			}
+		}
+		var first, last int
+		switch {
+		case rangePart == "*":
+			first, last = f.min, f.max
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if first, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if last, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if last < first {
+				return 0, fmt.Errorf("invalid %s range '%s'", f.name, rangePart)
+			}
+		default:
+			if first, err = f.value(rangePart); err != nil {
+				return 0, err
+			}
+			last = first
+			if step > 1 {
+				// "a/step" runs from a to the maximum.
+				last = f.max
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// Parses a single number or name.
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.ToLower(s) == name {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s '%s', must be %d-%d", f.name, s, f.min,
+			f.max)
+	}
+	return v, nil
+}
+
+// Returns the schedule as it was specified.
+func (s *CronSchedule) String() string {
+	return s.spec
+}
+
+func (s *CronSchedule) matches(i, v int) bool {
+	return s.bits[i]&(1<<uint(v)) != 0
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.matches(2, t.Day())
+	dow := s.matches(4, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Returns the first time after t the schedule matches, or the zero time if
+// it doesn't match within the next few years.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0,
+		loc).Add(time.Minute)
+	end := t.AddDate(cronSearchYears, 0, 0)
+	for t.Before(end) {
+		var next time.Time
+		switch {
+		case !s.matches(3, int(t.Month())):
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !s.matches(1, t.Hour()):
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !s.matches(0, t.Minute()):
+			next = t.Add(time.Minute)
+		default:
+			return t
+		}
+		if !next.After(t) {
+			// time.Date may pick a time before t for a time skipped by a
+			// daylight saving change.
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// Returns a channel receiving a tick each time the schedule matches. Like a
+// time.Ticker's, ticks nobody is waiting for are dropped, and like the one
+// time.Tick returns it's never stopped.
+func cronTick(s *CronSchedule) <-chan time.Time {
+	ticks := make(chan time.Time, 1)
+	go func() {
+		for {
+			next := s.Next(time.Now())
+			if next.IsZero() {
+				return
+			}
+			// Sleep again if woken early, e.g. after the clock was set back.
+			for now := time.Now(); now.Before(next); now = time.Now() {
+				time.Sleep(next.Sub(now))
+			}
+			select {
+			case ticks <- next:
+			default:
+			}
+		}
+	}()
+	return ticks
+}
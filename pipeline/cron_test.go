@@ -0,0 +1,121 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"strings"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CronSpec(c gs.Context) {
+	// Returns the next n times the schedule matches after start.
+	next := func(s *CronSchedule, start time.Time, n int) string {
+		times := make([]string, n)
+		t := start
+		for i := range times {
+			t = s.Next(t)
+			times[i] = t.Format("Mon 2006-01-02 15:04 MST")
+		}
+		return strings.Join(times, ", ")
+	}
+	start := time.Date(2016, 3, 12, 23, 59, 30, 0, time.UTC)
+
+	c.Specify("A CronSchedule", func() {
+		c.Specify("matches every full hour", func() {
+			s, err := ParseCronSchedule("0 * * * *")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 2), gs.Equals,
+				"Sun 2016-03-13 00:00 UTC, Sun 2016-03-13 01:00 UTC")
+		})
+
+		c.Specify("supports ranges, steps and names", func() {
+			s, err := ParseCronSchedule("*/30 9-10 * * mon-fri")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 5), gs.Equals,
+				"Mon 2016-03-14 09:00 UTC, Mon 2016-03-14 09:30 UTC, "+
+					"Mon 2016-03-14 10:00 UTC, Mon 2016-03-14 10:30 UTC, "+
+					"Tue 2016-03-15 09:00 UTC")
+
+			s, err = ParseCronSchedule("5/30 0 1 jan,Jul *")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 3), gs.Equals,
+				"Fri 2016-07-01 00:05 UTC, Fri 2016-07-01 00:35 UTC, "+
+					"Sun 2017-01-01 00:05 UTC")
+		})
+
+		c.Specify("matches either restricted day field", func() {
+			s, err := ParseCronSchedule("0 0 1,15 * 5")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 3), gs.Equals,
+				"Tue 2016-03-15 00:00 UTC, Fri 2016-03-18 00:00 UTC, "+
+					"Fri 2016-03-25 00:00 UTC")
+		})
+
+		c.Specify("treats 7 as Sunday", func() {
+			s, err := ParseCronSchedule("0 0 * * 7")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 1), gs.Equals,
+				"Sun 2016-03-13 00:00 UTC")
+		})
+
+		c.Specify("supports descriptors", func() {
+			s, err := ParseCronSchedule("@monthly")
+			c.Assume(err, gs.IsNil)
+			c.Expect(next(s, start, 2), gs.Equals,
+				"Fri 2016-04-01 00:00 UTC, Sun 2016-05-01 00:00 UTC")
+			c.Expect(s.String(), gs.Equals, "@monthly")
+		})
+
+		c.Specify("skips times missing on daylight saving changes", func() {
+			loc, err := time.LoadLocation("America/New_York")
+			c.Assume(err, gs.IsNil)
+			s, err := ParseCronSchedule("30 * * * *")
+			c.Assume(err, gs.IsNil)
+			midnight := time.Date(2016, 3, 13, 0, 0, 0, 0, loc)
+			c.Expect(next(s, midnight, 3), gs.Equals,
+				"Sun 2016-03-13 00:30 EST, Sun 2016-03-13 01:30 EST, "+
+					"Sun 2016-03-13 03:30 EDT")
+		})
+
+		c.Specify("rejects invalid schedules", func() {
+			for _, spec := range []string{"* * * *", "60 * * * *", "5-1 * * * *",
+				"*/0 * * * *", "0 0 * foo *", "@often", "0 0 30 2 *"} {
+
+				_, err := ParseCronSchedule(spec)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+
+	c.Specify("A filterrunner with a ticker_cron", func() {
+		commonFO := CommonFOConfig{
+			Matcher:    "Type == 'bogus'",
+			TickerCron: "0 * * * *",
+		}
+
+		c.Specify("accepts a valid schedule", func() {
+			fRunner, err := NewFORunner("counterFilter", &CounterFilter{}, commonFO,
+				"CounterFilter", 10)
+			c.Assume(err, gs.IsNil)
+			c.Expect(fRunner.cron.String(), gs.Equals, "0 * * * *")
+		})
+
+		c.Specify("rejects an invalid schedule", func() {
+			commonFO.TickerCron = "0 * *"
+			_, err := NewFORunner("counterFilter", &CounterFilter{}, commonFO,
+				"CounterFilter", 10)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
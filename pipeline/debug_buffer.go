@@ -0,0 +1,173 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/client"
+)
+
+// Ring buffer retaining the protobuf encodings of the last messages delivered
+// to a filter or output, so the messages that made a plugin fail can be
+// retrieved. When the plugin fails the contents are frozen in a snapshot
+// that later messages don't overwrite.
+type debugBuffer struct {
+	lock    sync.Mutex
+	records [][]byte
+	next    int
+	full    bool
+	// Contents as of the last failure.
+	errRecords [][]byte
+	errMsg     string
+	errTime    time.Time
+}
+
+func newDebugBuffer(size uint) *debugBuffer {
+	return &debugBuffer{records: make([][]byte, size)}
+}
+
+// Adds the pack's message to the buffer, evicting the oldest one if it's
+// full.
+func (db *debugBuffer) add(pack *PipelinePack) {
+	msgBytes := pack.MsgBytes
+	if !pack.TrustMsgBytes {
+		var err error
+		if msgBytes, err = proto.Marshal(pack.Message); err != nil {
+			return
+		}
+	}
+	db.lock.Lock()
+	// Reuse the slot's storage.
+	db.records[db.next] = append(db.records[db.next][:0], msgBytes...)
+	db.next++
+	if db.next == len(db.records) {
+		db.next = 0
+		db.full = true
+	}
+	db.lock.Unlock()
+}
+
+// Returns copies of the buffered records, oldest first. Must be called with
+// the lock held.
+func (db *debugBuffer) contents() [][]byte {
+	var records [][]byte
+	if db.full {
+		records = append(records, db.records[db.next:]...)
+	}
+	records = append(records, db.records[:db.next]...)
+	for i, record := range records {
+		records[i] = append([]byte(nil), record...)
+	}
+	return records
+}
+
+// Freezes the current contents as the snapshot for the given failure.
+func (db *debugBuffer) markError(err error) {
+	db.lock.Lock()
+	db.errRecords = db.contents()
+	db.errMsg = err.Error()
+	db.errTime = time.Now()
+	db.lock.Unlock()
+}
+
+// Writes the records to w as a Heka framed protobuf stream.
+func writeDebugRecords(w io.Writer, records [][]byte) error {
+	var framed []byte
+	for _, record := range records {
+		if err := client.CreateHekaStream(record, &framed, nil); err != nil {
+			return err
+		}
+		if _, err := w.Write(framed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handles `GET /debug/<name>` requests on the admin endpoint, responding with
+// the messages retained by the named filter or output's debug buffer as a
+// Heka framed protobuf stream. With `?snapshot=error` the messages as of the
+// plugin's last failure are returned instead, with the failure described by
+// the X-Heka-Error and X-Heka-Error-Time headers.
+func (pc *PipelineConfig) debugBufferHandler(w http.ResponseWriter,
+	req *http.Request) {
+
+	if req.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/debug/")
+	db := pc.debugBuffer(name)
+	if db == nil {
+		http.Error(w, fmt.Sprintf("no debug buffer for '%s'", name),
+			http.StatusNotFound)
+		return
+	}
+
+	db.lock.Lock()
+	var records [][]byte
+	switch req.URL.Query().Get("snapshot") {
+	case "":
+		records = db.contents()
+	case "error":
+		if db.errMsg == "" {
+			db.lock.Unlock()
+			http.Error(w, fmt.Sprintf("'%s' hasn't failed", name), http.StatusNotFound)
+			return
+		}
+		records = db.errRecords
+		w.Header().Set("X-Heka-Error", db.errMsg)
+		w.Header().Set("X-Heka-Error-Time", db.errTime.Format(time.RFC3339))
+	default:
+		db.lock.Unlock()
+		http.Error(w, "snapshot must be 'error'", http.StatusBadRequest)
+		return
+	}
+	db.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := writeDebugRecords(w, records); err != nil {
+		LogError.Printf("Writing debug buffer of '%s': %s", name, err)
+	}
+}
+
+// Returns the debug buffer of the named filter or output, nil if there's no
+// such plugin or it has no debug buffer.
+func (pc *PipelineConfig) debugBuffer(name string) *debugBuffer {
+	var runner interface {
+		MatchRunner() *MatchRunner
+	}
+	pc.filtersLock.RLock()
+	if fRunner, ok := pc.FilterRunners[name]; ok {
+		runner = fRunner
+	}
+	pc.filtersLock.RUnlock()
+	if runner == nil {
+		pc.outputsLock.RLock()
+		if oRunner, ok := pc.OutputRunners[name]; ok {
+			runner = oRunner
+		}
+		pc.outputsLock.RUnlock()
+	}
+	if runner == nil || runner.MatchRunner() == nil {
+		return nil
+	}
+	return runner.MatchRunner().debugBuf
+}
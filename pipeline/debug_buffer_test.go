@@ -0,0 +1,143 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DebugBufferSpec(c gs.Context) {
+	newPack := func(payload string) *PipelinePack {
+		pack := NewPipelinePack(nil)
+		pack.Message.SetUuid(make([]byte, 16))
+		pack.Message.SetTimestamp(1)
+		pack.Message.SetPayload(payload)
+		return pack
+	}
+	encoded := func(payloads ...string) [][]byte {
+		records := make([][]byte, len(payloads))
+		for i, payload := range payloads {
+			records[i], _ = proto.Marshal(newPack(payload).Message)
+		}
+		return records
+	}
+	payloadsOf := func(records [][]byte) []string {
+		payloads := make([]string, len(records))
+		for i, record := range records {
+			msg := new(message.Message)
+			c.Expect(proto.Unmarshal(record, msg), gs.IsNil)
+			payloads[i] = msg.GetPayload()
+		}
+		return payloads
+	}
+
+	c.Specify("A debug buffer", func() {
+		db := newDebugBuffer(3)
+
+		c.Specify("returns its messages oldest first", func() {
+			db.add(newPack("a"))
+			db.add(newPack("b"))
+			c.Expect(len(db.contents()), gs.Equals, 2)
+			c.Expect(payloadsOf(db.contents())[0], gs.Equals, "a")
+		})
+
+		c.Specify("evicts the oldest messages when full", func() {
+			for _, payload := range []string{"a", "b", "c", "d", "e"} {
+				db.add(newPack(payload))
+			}
+			payloads := payloadsOf(db.contents())
+			c.Expect(len(payloads), gs.Equals, 3)
+			c.Expect(payloads[0], gs.Equals, "c")
+			c.Expect(payloads[1], gs.Equals, "d")
+			c.Expect(payloads[2], gs.Equals, "e")
+		})
+
+		c.Specify("uses trusted MsgBytes as they are", func() {
+			pack := newPack("ignored")
+			pack.MsgBytes = encoded("trusted")[0]
+			pack.TrustMsgBytes = true
+			db.add(pack)
+			c.Expect(payloadsOf(db.contents())[0], gs.Equals, "trusted")
+		})
+
+		c.Specify("keeps a snapshot of the last failure", func() {
+			db.add(newPack("bad"))
+			db.markError(errors.New("parse error"))
+			for _, payload := range []string{"x", "y", "z"} {
+				db.add(newPack(payload))
+			}
+			payloads := payloadsOf(db.errRecords)
+			c.Expect(len(payloads), gs.Equals, 1)
+			c.Expect(payloads[0], gs.Equals, "bad")
+			c.Expect(db.errMsg, gs.Equals, "parse error")
+		})
+	})
+
+	c.Specify("The debug endpoint", func() {
+		pc := NewPipelineConfig(nil)
+		filter := &CounterFilter{}
+		fRunner, err := NewFORunner("counter", filter, CommonFOConfig{
+			Matcher:         "TRUE",
+			DebugBufferSize: 2,
+		}, "CounterFilter", 10)
+		c.Assume(err, gs.IsNil)
+		pc.FilterRunners["counter"] = fRunner
+		db := fRunner.MatchRunner().debugBuf
+		c.Assume(db, gs.Not(gs.IsNil))
+		db.add(newPack("one"))
+
+		get := func(path string) *httptest.ResponseRecorder {
+			req, _ := http.NewRequest("GET", path, nil)
+			rec := httptest.NewRecorder()
+			pc.debugBufferHandler(rec, req)
+			return rec
+		}
+
+		c.Specify("dumps the buffer as a framed protobuf stream", func() {
+			rec := get("/debug/counter")
+			c.Expect(rec.Code, gs.Equals, http.StatusOK)
+			expected := new(bytes.Buffer)
+			writeDebugRecords(expected, encoded("one"))
+			c.Expect(bytes.Equal(rec.Body.Bytes(), expected.Bytes()), gs.IsTrue)
+		})
+
+		c.Specify("dumps the failure snapshot", func() {
+			rec := get("/debug/counter?snapshot=error")
+			c.Expect(rec.Code, gs.Equals, http.StatusNotFound)
+
+			fRunner.countError(NewDataError("bad record"))
+			db.add(newPack("two"))
+			rec = get("/debug/counter?snapshot=error")
+			c.Expect(rec.Code, gs.Equals, http.StatusOK)
+			c.Expect(rec.Header().Get("X-Heka-Error"), gs.Equals, "bad record")
+			expected := new(bytes.Buffer)
+			writeDebugRecords(expected, encoded("one"))
+			c.Expect(bytes.Equal(rec.Body.Bytes(), expected.Bytes()), gs.IsTrue)
+		})
+
+		c.Specify("doesn't freeze the buffer on transient errors", func() {
+			fRunner.countError(NewRetryMessageError("later"))
+			c.Expect(db.errMsg, gs.Equals, "")
+		})
+
+		c.Specify("returns 404 for plugins without a buffer", func() {
+			c.Expect(get("/debug/nope").Code, gs.Equals, http.StatusNotFound)
+		})
+	})
+}
@@ -0,0 +1,218 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Values of an input's `decode_failure_action` setting.
+const (
+	// Recycles packs that failed decoding.
+	DecodeFailureDrop = "drop"
+	// Tags packs that failed decoding with `decode_failure` and
+	// `decode_error` fields and hands them to the router undecoded.
+	DecodeFailurePass = "pass"
+	// Tags packs that failed decoding like DecodeFailurePass and hands them
+	// to the decoder or filter named by `decode_failure_target`.
+	DecodeFailureRoute = "route"
+)
+
+// Returned by decoders that fail after having handed the pack, or messages
+// decoded from it, to DecoderRunner.Deliver already. The failure is counted
+// and logged, but the input's `decode_failure_action` isn't applied since the
+// pack is on its way. Decoders return it without any packs.
+type PartialDecodeError struct {
+	msg string
+}
+
+func NewPartialDecodeError(msg string, subs ...interface{}) PartialDecodeError {
+	if len(subs) > 0 {
+		msg = fmt.Sprintf(msg, subs...)
+	}
+	return PartialDecodeError{msg}
+}
+
+func (err PartialDecodeError) Error() string {
+	return err.msg
+}
+
+func (err PartialDecodeError) Category() ErrorCategory {
+	return ErrorData
+}
+
+// Handles the packs one of an input's decoders fails to decode, per the
+// input's `decode_failure_action`, and counts them.
+type decodeFailureHandler struct {
+	action string
+	// Number of packs that failed decoding, accessed atomically. The
+	// handlers of a DecoderRunner's extra decoders share it.
+	count   *int64
+	pConfig *PipelineConfig
+	// Decoder instance the packs are routed to, if the target is a decoder.
+	decoder Decoder
+	// Name of the filter the packs are routed to, if the target is a filter.
+	filter   string
+	logError func(err error)
+}
+
+// Handles a pack that failed decoding with the provided error message,
+// handing any resulting packs to deliver.
+func (h *decodeFailureHandler) handle(pack *PipelinePack, errMsg string,
+	deliver func(pack *PipelinePack)) {
+
+	atomic.AddInt64(h.count, 1)
+	if h.action == DecodeFailureDrop {
+		pack.recycle()
+		return
+	}
+	if err := AddDecodeFailureFields(pack.Message, errMsg); err != nil {
+		h.logError(err)
+	}
+	pack.TrustMsgBytes = false
+	switch {
+	case h.decoder != nil:
+		h.decode(pack, deliver)
+	case h.filter != "":
+		h.sendToFilter(pack)
+	default:
+		deliver(pack)
+	}
+}
+
+// Counts a failure reported with a PartialDecodeError, leaving the pack
+// alone.
+func (h *decodeFailureHandler) handlePartial() {
+	atomic.AddInt64(h.count, 1)
+}
+
+// Runs the pack through the failure decoder. Packs the failure decoder can't
+// decode either are dropped.
+func (h *decodeFailureHandler) decode(pack *PipelinePack,
+	deliver func(pack *PipelinePack)) {
+
+	packs, err := h.decoder.Decode(pack)
+	if packs == nil {
+		if err != nil {
+			h.logError(fmt.Errorf("failure decoder: %s", err))
+		}
+		pack.recycle()
+		return
+	}
+	for _, p := range packs {
+		p.TrustMsgBytes = false
+		deliver(p)
+	}
+}
+
+// Hands the pack straight to the target filter, bypassing its matcher.
+func (h *decodeFailureHandler) sendToFilter(pack *PipelinePack) {
+	fr, ok := h.pConfig.Filter(h.filter)
+	if !ok {
+		h.logError(fmt.Errorf("failure filter '%s' isn't running", h.filter))
+		pack.recycle()
+		return
+	}
+	if err := pack.EncodeMsgBytes(); err != nil {
+		h.logError(fmt.Errorf("encoding message: %s", err))
+		pack.recycle()
+		return
+	}
+	if err := fr.MatchRunner().deliver(pack); err != nil {
+		h.logError(fmt.Errorf("can't deliver to failure filter '%s': %s",
+			h.filter, err))
+	}
+}
+
+// Returns the number of packs that failed decoding.
+func (h *decodeFailureHandler) failureCount() int64 {
+	return atomic.LoadInt64(h.count)
+}
+
+// Satisfies the WantsDecoderRunnerShutdown interface, shutting the failure
+// decoder down if it needs to be.
+func (h *decodeFailureHandler) Shutdown() {
+	if wanter, ok := h.decoder.(WantsDecoderRunnerShutdown); ok {
+		wanter.Shutdown()
+	}
+}
+
+// Checks the input's `decode_failure_action` and `decode_failure_target`
+// settings.
+func (ir *iRunner) checkDecodeFailureConfig() error {
+	switch ir.failureAction {
+	case DecodeFailureDrop, DecodeFailurePass:
+		return nil
+	case DecodeFailureRoute:
+	default:
+		return fmt.Errorf("unknown decode_failure_action '%s'",
+			ir.failureAction)
+	}
+	target := ir.config.DecodeFailureTarget
+	if target == "" {
+		return fmt.Errorf("decode_failure_action '%s' requires a decode_failure_target",
+			DecodeFailureRoute)
+	}
+	if target == ir.config.Decoder {
+		return fmt.Errorf("decode_failure_target '%s' is the input's own decoder",
+			target)
+	}
+	ir.pConfig.makersLock.RLock()
+	_, isDecoder := ir.pConfig.DecoderMakers[target]
+	_, isFilter := ir.pConfig.makers["Filter"][target]
+	ir.pConfig.makersLock.RUnlock()
+	if !isDecoder && !isFilter {
+		return fmt.Errorf("decode_failure_target '%s' isn't a registered decoder or filter",
+			target)
+	}
+	return nil
+}
+
+// Creates the handler for the packs the decoder named fullName fails to
+// decode.
+func (ir *iRunner) newDecodeFailureHandler(fullName string) *decodeFailureHandler {
+	h := &decodeFailureHandler{
+		action:   ir.failureAction,
+		count:    new(int64),
+		pConfig:  ir.pConfig,
+		logError: ir.LogError,
+	}
+	if h.action != DecodeFailureRoute {
+		return h
+	}
+
+	target := ir.config.DecodeFailureTarget
+	ir.pConfig.makersLock.RLock()
+	_, isDecoder := ir.pConfig.DecoderMakers[target]
+	ir.pConfig.makersLock.RUnlock()
+	if !isDecoder {
+		h.filter = target
+		return h
+	}
+	decoder, ok := ir.pConfig.Decoder(target)
+	if !ok {
+		ir.LogError(fmt.Errorf("can't create failure decoder '%s'", target))
+		h.action = DecodeFailureDrop
+		return h
+	}
+	if wanter, ok := decoder.(WantsDecoderRunner); ok {
+		dr := NewDecoderRunner(fmt.Sprintf("%s-%s", fullName, target), decoder,
+			0).(*dRunner)
+		dr.h = ir.h
+		dr.router = ir.pConfig.router
+		dr.globals = ir.pConfig.Globals
+		wanter.SetDecoderRunner(dr)
+	}
+	h.decoder = decoder
+	return h
+}
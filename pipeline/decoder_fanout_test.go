@@ -0,0 +1,102 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/bbangert/toml"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Decoder emitting a message for each comma separated item of the payload,
+// delivering all but the first as it goes.
+type FanOutTestDecoder struct {
+	dRunner DecoderRunner
+}
+
+func (d *FanOutTestDecoder) Init(config interface{}) error {
+	return nil
+}
+
+func (d *FanOutTestDecoder) SetDecoderRunner(dr DecoderRunner) {
+	d.dRunner = dr
+}
+
+func (d *FanOutTestDecoder) Decode(pack *PipelinePack) ([]*PipelinePack, error) {
+	items := strings.Split(pack.Message.GetPayload(), ",")
+	for _, item := range items[1:] {
+		p := d.dRunner.NewPackFrom(pack)
+		if p == nil {
+			return nil, nil
+		}
+		p.Message.SetPayload(item)
+		d.dRunner.Deliver(p)
+	}
+	pack.Message.SetPayload(items[0])
+	return []*PipelinePack{pack}, nil
+}
+
+func DecoderFanOutSpec(c gs.Context) {
+	RegisterPlugin("FanOutTestDecoder", func() interface{} {
+		return new(FanOutTestDecoder)
+	})
+	pc := NewPipelineConfig(nil)
+	// Fewer packs than the decoder generates messages from one record.
+	for i := 0; i < 2; i++ {
+		pc.inputRecycleChan <- NewPipelinePack(pc.inputRecycleChan)
+	}
+
+	var configFile ConfigFile
+	_, err := toml.Decode("[fanout]\ntype = \"FanOutTestDecoder\"", &configFile)
+	c.Assume(err, gs.IsNil)
+	maker, err := NewPluginMaker("fanout", pc, configFile["fanout"])
+	c.Assume(err, gs.IsNil)
+	pc.DecoderMakers["fanout"] = maker
+	dr, ok := pc.DecoderRunner("fanout", "input-fanout")
+	c.Assume(ok, gs.IsTrue)
+	defer pc.StopDecoderRunner(dr)
+
+	c.Specify("A decoder delivering extra packs", func() {
+		pack := <-pc.inputRecycleChan
+		pack.Message.SetType("batch")
+		pack.Message.SetHostname("web1")
+		pack.Message.SetTimestamp(42)
+		pack.Message.SetPayload("a,b,c,d,e")
+		uuid := pack.Message.GetUuid()
+		dr.InChan() <- pack
+
+		c.Specify("doesn't run the pack supply dry", func() {
+			var payloads []string
+			for i := 0; i < 5; i++ {
+				select {
+				case p := <-pc.router.InChan():
+					payloads = append(payloads, p.Message.GetPayload())
+					c.Expect(p.Message.GetType(), gs.Equals, "batch")
+					c.Expect(p.Message.GetHostname(), gs.Equals, "web1")
+					c.Expect(p.Message.GetTimestamp(), gs.Equals, int64(42))
+					if p.Message.GetPayload() != "a" {
+						c.Expect(bytes.Equal(p.Message.GetUuid(), uuid), gs.IsFalse)
+					}
+					c.Expect(p.TrustMsgBytes, gs.IsTrue)
+					p.recycle()
+				case <-time.After(time.Second):
+					c.Expect("", gs.Equals, "decoder blocked waiting for packs")
+					return
+				}
+			}
+			c.Expect(strings.Join(payloads, ","), gs.Equals, "b,c,d,e,a")
+		})
+	})
+}
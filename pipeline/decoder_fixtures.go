@@ -0,0 +1,196 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/pborman/uuid"
+)
+
+// Common config shared by all decoder plugins.
+type CommonDecoderConfig struct {
+	// Sample inputs that are run through the decoder when the config is
+	// loaded, specified as `[[<decoder_name>.test]]` sections.
+	Tests []DecoderFixture `toml:"test"`
+}
+
+// A DecoderFixture is a sample input for a decoder along with the message
+// contents the decoder is expected to produce from it. Any header or field
+// that isn't listed isn't checked.
+type DecoderFixture struct {
+	// Optional name used to identify the fixture in error messages.
+	Name string `toml:"name"`
+	// Raw input data, handed to the decoder as both the message payload and
+	// the pack's MsgBytes.
+	Input string `toml:"input"`
+	// Set to true if the decoder is expected to reject the input.
+	ExpectFailure bool `toml:"expect_failure"`
+	// Expected values of message headers, keyed by header name (i.e. "Type",
+	// "Logger", "Hostname", "Payload", "EnvVersion", "Severity" or "Pid").
+	Headers map[string]interface{} `toml:"headers"`
+	// Expected values of message fields, keyed by field name. Only the first
+	// value of each field is compared.
+	Fields map[string]interface{} `toml:"fields"`
+}
+
+func (f *DecoderFixture) label(index int) string {
+	if f.Name != "" {
+		return fmt.Sprintf("'%s'", f.Name)
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+// Runs the fixture's input through the decoder and compares the first
+// resulting message to the fixture's expectations.
+func (f *DecoderFixture) run(decoder Decoder) error {
+	pack := NewPipelinePack(make(chan *PipelinePack, 1))
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetTimestamp(time.Now().UnixNano())
+	pack.Message.SetPayload(f.Input)
+	pack.MsgBytes = append(pack.MsgBytes, f.Input...)
+
+	packs, err := decoder.Decode(pack)
+	if f.ExpectFailure {
+		if packs != nil {
+			return errors.New("input was expected to fail decoding but didn't")
+		}
+		return nil
+	}
+	if packs == nil {
+		if err == nil {
+			err = errors.New("no message produced")
+		}
+		return fmt.Errorf("decoding failed: %s", err)
+	}
+	if len(packs) == 0 {
+		return errors.New("no message produced")
+	}
+	return f.check(packs[0].Message)
+}
+
+func (f *DecoderFixture) check(msg *message.Message) error {
+	for name, expected := range f.Headers {
+		var actual interface{}
+		switch name {
+		case "Type":
+			actual = msg.GetType()
+		case "Logger":
+			actual = msg.GetLogger()
+		case "Hostname":
+			actual = msg.GetHostname()
+		case "Payload":
+			actual = msg.GetPayload()
+		case "EnvVersion":
+			actual = msg.GetEnvVersion()
+		case "Severity":
+			actual = int64(msg.GetSeverity())
+		case "Pid":
+			actual = int64(msg.GetPid())
+		default:
+			return fmt.Errorf("unsupported header '%s'", name)
+		}
+		if !fixtureValueMatches(expected, actual) {
+			return fmt.Errorf("header '%s' expected %#v, got %#v", name, expected, actual)
+		}
+	}
+	for name, expected := range f.Fields {
+		actual, ok := msg.GetFieldValue(name)
+		if !ok {
+			return fmt.Errorf("field '%s' missing", name)
+		}
+		if !fixtureValueMatches(expected, actual) {
+			return fmt.Errorf("field '%s' expected %#v, got %#v", name, expected, actual)
+		}
+	}
+	return nil
+}
+
+// Compares a TOML-decoded expected value to a message value. Integers and
+// floats compare by numeric value, since a decoder may legitimately store
+// `200` as either.
+func fixtureValueMatches(expected, actual interface{}) bool {
+	if b, ok := actual.([]byte); ok {
+		actual = string(b)
+	}
+	expectedNum, expectedIsNum := fixtureNumber(expected)
+	actualNum, actualIsNum := fixtureNumber(actual)
+	if expectedIsNum || actualIsNum {
+		return expectedIsNum && actualIsNum &&
+			math.Abs(expectedNum-actualNum) <= 1e-9*math.Max(1, math.Abs(expectedNum))
+	}
+	return expected == actual
+}
+
+func fixtureNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// Runs a decoder's test fixtures, if it has any, against a newly created
+// instance of the decoder. Returns an error describing the first fixture that
+// fails.
+func (self *PipelineConfig) testDecoder(maker PluginMaker) error {
+	commonTypedConfig, err := maker.(*pluginMaker).prepCommonTypedConfig()
+	if err != nil {
+		return err
+	}
+	commonDecoder, ok := commonTypedConfig.(CommonDecoderConfig)
+	if !ok || len(commonDecoder.Tests) == 0 {
+		return nil
+	}
+
+	plugin, _, err := maker.Make()
+	if err != nil {
+		return err
+	}
+	decoder := plugin.(Decoder)
+	dr := NewDecoderRunner(fmt.Sprintf("%s-test", maker.Name()), decoder, 0).(*dRunner)
+	dr.h = self
+	dr.router = self.router
+	dr.globals = self.Globals
+	if wanter, ok := decoder.(WantsDecoderRunner); ok {
+		wanter.SetDecoderRunner(dr)
+	}
+	if wanter, ok := decoder.(WantsDecoderRunnerShutdown); ok {
+		defer wanter.Shutdown()
+	}
+
+	// The pack pool isn't populated until the pipeline starts, so lend the
+	// pool some packs for decoders that ask their runner for extra ones and
+	// take them back out when we're done.
+	for len(self.inputRecycleChan) < cap(self.inputRecycleChan) {
+		self.inputRecycleChan <- NewPipelinePack(self.inputRecycleChan)
+	}
+	defer func() {
+		for len(self.inputRecycleChan) > 0 {
+			<-self.inputRecycleChan
+		}
+	}()
+
+	for i, fixture := range commonDecoder.Tests {
+		if err = fixture.run(decoder); err != nil {
+			return fmt.Errorf("test %s for decoder '%s' failed: %s",
+				fixture.label(i), maker.Name(), err)
+		}
+	}
+	return nil
+}
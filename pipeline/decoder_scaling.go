@@ -0,0 +1,188 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// How often a DecoderRunner checks its backlog.
+	decoderScaleInterval = time.Second
+	// Number of consecutive checks the backlog has to be at least half the
+	// channel capacity for before another decoder is started.
+	decoderScaleUpChecks = 3
+	// Number of consecutive checks the backlog has to be at most a quarter of
+	// the channel capacity for before an extra decoder is stopped.
+	decoderScaleDownChecks = 10
+)
+
+// Lets the DecoderRunner run up to max decoders in parallel, creating the
+// extra ones and their failure handlers with newDecoder, and starts checking
+// its backlog.
+func (dr *dRunner) setMaxDecoders(max int,
+	newDecoder func() (Decoder, *decodeFailureHandler, bool)) {
+
+	dr.maxDecoders = max
+	dr.newDecoder = newDecoder
+	go func() {
+		ticker := time.NewTicker(decoderScaleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case dr.scaleChan <- struct{}{}:
+				case <-dr.stopped:
+					return
+				}
+			case <-dr.stopped:
+				return
+			}
+		}
+	}()
+}
+
+// Returns the number of decoders the runner is running.
+func (dr *dRunner) DecoderCount() int32 {
+	return atomic.LoadInt32(&dr.decoderCount)
+}
+
+// Starts another decoder if the backlog has been high for long enough and
+// the maximum hasn't been reached, or stops one of the extra decoders if it
+// has been low for long enough.
+func (dr *dRunner) scale() {
+	backlog, capacity := len(dr.inChan), cap(dr.inChan)
+	switch {
+	case capacity > 0 && backlog >= capacity/2:
+		dr.busyChecks++
+		dr.idleChecks = 0
+	case backlog <= capacity/4:
+		dr.busyChecks = 0
+		dr.idleChecks++
+	default:
+		dr.busyChecks = 0
+		dr.idleChecks = 0
+	}
+
+	if dr.idleChecks >= decoderScaleDownChecks {
+		dr.idleChecks = 0
+		dr.dropDecoder()
+		return
+	}
+	if dr.busyChecks < decoderScaleUpChecks ||
+		int(dr.DecoderCount()) >= dr.maxDecoders {
+		return
+	}
+	dr.busyChecks = 0
+
+	decoder, failures, ok := dr.newDecoder()
+	if !ok {
+		dr.LogError(errors.New("can't create an extra decoder"))
+		return
+	}
+	if wanter, ok := decoder.(WantsDecoderRunner); ok {
+		wanter.SetDecoderRunner(dr)
+	}
+	atomic.AddInt32(&dr.decoderCount, 1)
+	dr.extraWg.Add(1)
+	go dr.runExtra(decoder, failures, dr.stopExtra)
+}
+
+// Asks one of the extra decoders to stop, unless none is running or one is
+// already stopping.
+func (dr *dRunner) dropDecoder() {
+	if dr.DecoderCount() <= 1 {
+		return
+	}
+	select {
+	case dr.dropExtra <- struct{}{}:
+	default:
+	}
+}
+
+// Decodes packs from the runner's channel with an extra decoder until the
+// channel is closed, the decoder is dropped or stop is closed.
+func (dr *dRunner) runExtra(decoder Decoder, failures *decodeFailureHandler,
+	stop chan struct{}) {
+
+	defer func() {
+		if wanter, ok := decoder.(WantsDecoderRunnerShutdown); ok {
+			wanter.Shutdown()
+		}
+		failures.Shutdown()
+		atomic.AddInt32(&dr.decoderCount, -1)
+		dr.extraWg.Done()
+	}()
+
+	for {
+		select {
+		case pack, ok := <-dr.inChan:
+			if !ok {
+				return
+			}
+			dr.decodeWith(decoder, failures, pack)
+		case <-dr.dropExtra:
+			return
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Stops the extra decoders and waits for them to exit.
+func (dr *dRunner) stopExtraDecoders() {
+	close(dr.stopExtra)
+	dr.extraWg.Wait()
+	dr.stopExtra = make(chan struct{})
+	select {
+	case <-dr.dropExtra:
+	default:
+	}
+}
+
+// Implemented by decoders whose output depends on the messages they decoded
+// before, e.g. because they join records spanning several messages. Spreading
+// an input's messages over several instances of such a decoder would split
+// that state between them, so inputs using one can't set `max_decoders`.
+type StatefulDecoder interface {
+	Decoder
+	// Returns true if the decoder keeps state from one message to the next.
+	IsStateful() bool
+}
+
+// Checks an input's `max_decoders` setting, which only DecoderRunners honor
+// and which decoders keeping state between messages don't support.
+func (self *PipelineConfig) checkMaxDecoders(config CommonInputConfig,
+	syncDecode bool) error {
+
+	if config.MaxDecoders <= 1 || config.Decoder == "" {
+		return nil
+	}
+	if syncDecode {
+		return errors.New("max_decoders can't be combined with synchronous_decode")
+	}
+	decoder, ok := self.Decoder(config.Decoder)
+	if !ok {
+		// Unknown decoders are reported on their own.
+		return nil
+	}
+	defer shutdownDecoder(decoder)
+	if stateful, ok := decoder.(StatefulDecoder); ok && stateful.IsStateful() {
+		return fmt.Errorf("max_decoders can't be used with decoder '%s', it keeps "+
+			"state between messages", config.Decoder)
+	}
+	return nil
+}
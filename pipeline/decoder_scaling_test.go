@@ -0,0 +1,181 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Decoder claiming to keep state between messages.
+type StatefulTestDecoder struct {
+	SwapTestDecoder
+}
+
+func (d *StatefulTestDecoder) IsStateful() bool {
+	return true
+}
+
+func DecoderScalingSpec(c gs.Context) {
+	pc := NewPipelineConfig(nil)
+	dr := NewDecoderRunner("input-scaler", &SwapTestDecoder{msgType: "main"},
+		4).(*dRunner)
+	dr.h = pc
+	dr.router = pc.router
+	dr.globals = pc.Globals
+	dr.maxDecoders = 2
+	extra := &SwapTestDecoder{msgType: "extra"}
+	dr.newDecoder = func() (Decoder, *decodeFailureHandler, bool) {
+		return extra, &decodeFailureHandler{count: dr.failures.count}, true
+	}
+	recycleChan := make(chan *PipelinePack, 2)
+	queue := func() {
+		for i := 0; i < 2; i++ {
+			dr.inChan <- NewPipelinePack(recycleChan)
+		}
+	}
+
+	c.Specify("A DecoderRunner with max_decoders", func() {
+		c.Specify("starts another decoder once the backlog persists", func() {
+			queue()
+			for i := 1; i < decoderScaleUpChecks; i++ {
+				dr.scale()
+			}
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(1))
+			dr.scale()
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(2))
+
+			for i := 0; i < 2; i++ {
+				pack := <-pc.router.inChan
+				c.Expect(pack.Message.GetType(), gs.Equals, "extra")
+			}
+			dr.stopExtraDecoders()
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(1))
+			c.Expect(extra.shutdown, gs.IsTrue)
+		})
+
+		c.Specify("only counts consecutive backlogged checks", func() {
+			queue()
+			for i := 1; i < decoderScaleUpChecks; i++ {
+				dr.scale()
+			}
+			<-dr.inChan
+			<-dr.inChan
+			dr.scale()
+			queue()
+			dr.scale()
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(1))
+			c.Expect(dr.busyChecks, gs.Equals, 1)
+		})
+
+		c.Specify("stops an extra decoder once the backlog stays low", func() {
+			queue()
+			for i := 0; i < decoderScaleUpChecks; i++ {
+				dr.scale()
+			}
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(2))
+			for i := 0; i < 2; i++ {
+				<-pc.router.inChan
+			}
+
+			for i := 1; i < decoderScaleDownChecks; i++ {
+				dr.scale()
+			}
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(2))
+			dr.scale()
+			dr.extraWg.Wait()
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(1))
+			c.Expect(extra.shutdown, gs.IsTrue)
+			c.Expect(dr.idleChecks, gs.Equals, 0)
+		})
+
+		c.Specify("doesn't go over the maximum", func() {
+			dr.maxDecoders = 1
+			queue()
+			for i := 0; i < 2*decoderScaleUpChecks; i++ {
+				dr.scale()
+			}
+			c.Expect(dr.DecoderCount(), gs.Equals, int32(1))
+		})
+	})
+	c.Specify("An input's max_decoders", func() {
+		RegisterPlugin("SwapTestDecoder", func() interface{} {
+			return new(SwapTestDecoder)
+		})
+		RegisterPlugin("StatefulTestDecoder", func() interface{} {
+			return new(StatefulTestDecoder)
+		})
+		tmpDir, err := ioutil.TempDir("", "decoder-scaling-tests")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(tmpDir)
+		configPath := filepath.Join(tmpDir, "heka.toml")
+		load := func(decoder string) (*PipelineConfig, error) {
+			config := `
+[hekad]
+maxprocs = 1
+
+[stateless]
+type = "SwapTestDecoder"
+msg_type = "stateless"
+
+[stateful]
+type = "StatefulTestDecoder"
+msg_type = "stateful"
+
+[combined]
+type = "MultiDecoder"
+subs = ["stateless", "stateful"]
+
+[StatAccumInput]
+Decoder = "` + decoder + `"
+max_decoders = 2
+`
+			err := ioutil.WriteFile(configPath, []byte(config), 0644)
+			c.Assume(err, gs.IsNil)
+			pc := NewPipelineConfig(nil)
+			err = pc.PreloadFromConfigFile(configPath)
+			c.Assume(err, gs.IsNil)
+			return pc, pc.LoadConfig()
+		}
+
+		c.Specify("is accepted for stateless decoders", func() {
+			_, err := load("stateless")
+			c.Expect(err, gs.IsNil)
+		})
+
+		c.Specify("is rejected for stateful decoders at config load", func() {
+			_, err := load("stateful")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("is rejected for MultiDecoders with a stateful subdecoder", func() {
+			_, err := load("combined")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("only restricts stateful decoders used with several decoders", func() {
+			pc, err := load("stateless")
+			c.Assume(err, gs.IsNil)
+			config := CommonInputConfig{Decoder: "stateful", MaxDecoders: 2}
+			err = pc.checkMaxDecoders(config, false)
+			c.Assume(err, gs.Not(gs.IsNil))
+			c.Expect(strings.Contains(err.Error(), "keeps state between messages"),
+				gs.IsTrue)
+			config.MaxDecoders = 1
+			c.Expect(pc.checkMaxDecoders(config, false), gs.IsNil)
+		})
+	})
+}
@@ -0,0 +1,158 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/bbangert/toml"
+)
+
+// Request for a DecoderRunner to replace its decoder.
+type decoderSwap struct {
+	decoder Decoder
+	done    chan struct{}
+}
+
+// Records the name of the decoder config a DecoderRunner was made from, so
+// the runner can be found when that config is swapped.
+func setDecoderBaseName(dr DecoderRunner, baseName string) {
+	if runner, ok := dr.(*dRunner); ok {
+		runner.baseName = baseName
+	}
+}
+
+// Replaces the configuration of the named decoder at runtime. The new config
+// is validated by initializing a decoder with it before anything changes.
+// Every running DecoderRunner for the decoder then decodes the packs already
+// queued for it with its current decoder and swaps in a freshly initialized
+// one, without dropping any packs. Decoders created later, including the
+// synchronous decoders of new connections, use the new config; existing
+// synchronous decoders keep the old one. Returns the number of DecoderRunners
+// that were swapped.
+func (self *PipelineConfig) SwapDecoderConfig(name string, tomlSection toml.Primitive) (
+	int, error) {
+
+	self.makersLock.RLock()
+	_, ok := self.DecoderMakers[name]
+	self.makersLock.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no decoder named '%s'", name)
+	}
+	maker, err := NewPluginMaker(name, self, tomlSection)
+	if err != nil {
+		return 0, err
+	}
+	if maker.Category() != "Decoder" {
+		return 0, fmt.Errorf("'%s' is not a decoder type", maker.Type())
+	}
+	// The decoder validating the config is swapped into the first runner.
+	probe, _, err := maker.Make()
+	if err != nil {
+		return 0, err
+	}
+
+	self.makersLock.Lock()
+	self.DecoderMakers[name] = maker
+	self.makersLock.Unlock()
+
+	var runners []*dRunner
+	self.allDecodersLock.RLock()
+	for _, dr := range self.allDecoders {
+		if runner, ok := dr.(*dRunner); ok && runner.baseName == name {
+			runners = append(runners, runner)
+		}
+	}
+	self.allDecodersLock.RUnlock()
+
+	swapped := 0
+	for _, runner := range runners {
+		plugin := probe
+		if plugin == nil {
+			if plugin, _, err = maker.Make(); err != nil {
+				return swapped, err
+			}
+		}
+		probe = nil
+		swap := &decoderSwap{plugin.(Decoder), make(chan struct{})}
+		select {
+		case runner.swapChan <- swap:
+			<-swap.done
+			swapped++
+			runner.LogMessage("decoder config swapped")
+		case <-runner.stopped:
+			shutdownDecoder(swap.decoder)
+		}
+	}
+	if probe != nil {
+		shutdownDecoder(probe.(Decoder))
+	}
+	return swapped, nil
+}
+
+// Shuts down a decoder that was made but never handed to a running
+// DecoderRunner, which would otherwise have done it.
+func shutdownDecoder(decoder Decoder) {
+	if wanter, ok := decoder.(WantsDecoderRunnerShutdown); ok {
+		wanter.Shutdown()
+	}
+}
+
+// Handles `PUT /decoders/<name>` requests on the admin endpoint, swapping
+// the named decoder's config for the TOML in the request body. The body
+// holds the settings of the decoder's section, without the section header.
+func (self *PipelineConfig) decoderConfigHandler(w http.ResponseWriter,
+	req *http.Request) {
+
+	if req.Method != "PUT" && req.Method != "POST" {
+		w.Header().Set("Allow", "PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/decoders/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, req)
+		return
+	}
+	self.makersLock.RLock()
+	_, ok := self.DecoderMakers[name]
+	self.makersLock.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no decoder named '%s'", name), http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Wrap the settings in a section so they decode to a primitive, the same
+	// as when loaded from a config file.
+	var sections ConfigFile
+	contents := fmt.Sprintf("[%s]\n%s", name, body)
+	if _, err = toml.Decode(contents, &sections); err != nil {
+		http.Error(w, fmt.Sprintf("can't decode config: %s", err),
+			http.StatusBadRequest)
+		return
+	}
+	swapped, err := self.SwapDecoderConfig(name, sections[name])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	LogInfo.Printf("Decoder '%s' config swapped in %d runner(s)", name, swapped)
+	fmt.Fprintf(w, "swapped %d decoder runner(s)\n", swapped)
+}
@@ -0,0 +1,182 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/bbangert/toml"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+type SwapTestDecoderConfig struct {
+	MsgType string `toml:"msg_type"`
+}
+
+// Decoder setting the message type to its configured value.
+type SwapTestDecoder struct {
+	msgType  string
+	shutdown bool
+}
+
+func (d *SwapTestDecoder) ConfigStruct() interface{} {
+	return new(SwapTestDecoderConfig)
+}
+
+func (d *SwapTestDecoder) Init(config interface{}) error {
+	d.msgType = config.(*SwapTestDecoderConfig).MsgType
+	if d.msgType == "" {
+		return errors.New("msg_type is required")
+	}
+	return nil
+}
+
+func (d *SwapTestDecoder) Decode(pack *PipelinePack) ([]*PipelinePack, error) {
+	pack.Message.SetType(d.msgType)
+	return []*PipelinePack{pack}, nil
+}
+
+func (d *SwapTestDecoder) Shutdown() {
+	d.shutdown = true
+}
+
+func DecoderSwapSpec(c gs.Context) {
+	var made []*SwapTestDecoder
+	RegisterPlugin("SwapTestDecoder", func() interface{} {
+		decoder := new(SwapTestDecoder)
+		made = append(made, decoder)
+		return decoder
+	})
+	pc := NewPipelineConfig(nil)
+	recycleChan := make(chan *PipelinePack, 1)
+	recycleChan <- NewPipelinePack(recycleChan)
+
+	section := func(settings string) toml.Primitive {
+		var configFile ConfigFile
+		_, err := toml.Decode("[swapper]\n"+settings, &configFile)
+		c.Assume(err, gs.IsNil)
+		return configFile["swapper"]
+	}
+	maker, err := NewPluginMaker("swapper", pc,
+		section("type = \"SwapTestDecoder\"\nmsg_type = \"v1\""))
+	c.Assume(err, gs.IsNil)
+	pc.DecoderMakers["swapper"] = maker
+	dr, ok := pc.DecoderRunner("swapper", "input-swapper")
+	c.Assume(ok, gs.IsTrue)
+	defer pc.StopDecoderRunner(dr)
+
+	// Decodes a pack and returns the type the router received.
+	decodedType := func() string {
+		pack := <-recycleChan
+		dr.InChan() <- pack
+		select {
+		case pack = <-pc.router.InChan():
+		case <-time.After(time.Second):
+			return "timeout"
+		}
+		msgType := pack.Message.GetType()
+		pack.recycle()
+		return msgType
+	}
+
+	c.Specify("A decoder config swap", func() {
+		c.Assume(decodedType(), gs.Equals, "v1")
+		old := dr.Decoder().(*SwapTestDecoder)
+
+		c.Specify("replaces the decoder of running runners", func() {
+			swapped, err := pc.SwapDecoderConfig("swapper",
+				section("type = \"SwapTestDecoder\"\nmsg_type = \"v2\""))
+			c.Expect(err, gs.IsNil)
+			c.Expect(swapped, gs.Equals, 1)
+			c.Expect(decodedType(), gs.Equals, "v2")
+			c.Expect(old.shutdown, gs.IsTrue)
+
+			decoder, ok := pc.Decoder("swapper")
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(decoder.(*SwapTestDecoder).msgType, gs.Equals, "v2")
+		})
+
+		c.Specify("swaps in the decoder that validated the config", func() {
+			count := len(made)
+			_, err := pc.SwapDecoderConfig("swapper",
+				section("type = \"SwapTestDecoder\"\nmsg_type = \"v2\""))
+			c.Expect(err, gs.IsNil)
+			// One decoder is made for the maker's config struct.
+			c.Expect(len(made), gs.Equals, count+2)
+			c.Expect(dr.Decoder(), gs.Equals, made[count+1])
+		})
+
+		c.Specify("shuts down the validating decoder if no runner uses it", func() {
+			idle, err := NewPluginMaker("idle", pc,
+				section("type = \"SwapTestDecoder\"\nmsg_type = \"v1\""))
+			c.Assume(err, gs.IsNil)
+			pc.DecoderMakers["idle"] = idle
+			count := len(made)
+			swapped, err := pc.SwapDecoderConfig("idle",
+				section("type = \"SwapTestDecoder\"\nmsg_type = \"v2\""))
+			c.Expect(err, gs.IsNil)
+			c.Expect(swapped, gs.Equals, 0)
+			c.Expect(len(made), gs.Equals, count+2)
+			c.Expect(made[count+1].shutdown, gs.IsTrue)
+		})
+
+		c.Specify("keeps the old config if the new one is invalid", func() {
+			_, err := pc.SwapDecoderConfig("swapper",
+				section("type = \"SwapTestDecoder\""))
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = pc.SwapDecoderConfig("swapper",
+				section("type = \"CounterFilter\""))
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(decodedType(), gs.Equals, "v1")
+			c.Expect(old.shutdown, gs.IsFalse)
+		})
+
+		c.Specify("rejects unknown decoders", func() {
+			_, err := pc.SwapDecoderConfig("nope",
+				section("type = \"SwapTestDecoder\"\nmsg_type = \"v2\""))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("is available over HTTP", func() {
+			put := func(path, body string) *httptest.ResponseRecorder {
+				req, err := http.NewRequest("PUT", path, strings.NewReader(body))
+				c.Assume(err, gs.IsNil)
+				w := httptest.NewRecorder()
+				pc.decoderConfigHandler(w, req)
+				return w
+			}
+			w := put("/decoders/swapper", "type = \"SwapTestDecoder\"\nmsg_type = \"v3\"\n")
+			c.Expect(w.Code, gs.Equals, http.StatusOK)
+			c.Expect(decodedType(), gs.Equals, "v3")
+
+			w = put("/decoders/nope", "msg_type = \"v3\"\n")
+			c.Expect(w.Code, gs.Equals, http.StatusNotFound)
+			w = put("/decoders/swapper", "msg_type = \n")
+			c.Expect(w.Code, gs.Equals, http.StatusBadRequest)
+
+			req, _ := http.NewRequest("GET", "/decoders/swapper", nil)
+			rec := httptest.NewRecorder()
+			pc.decoderConfigHandler(rec, req)
+			c.Expect(rec.Code, gs.Equals, http.StatusMethodNotAllowed)
+		})
+	})
+
+	c.Specify("A DecoderRunner started twice keeps running", func() {
+		pc.decodersWg.Add(1)
+		dr.Start(pc, &pc.decodersWg)
+		c.Expect(decodedType(), gs.Equals, "v1")
+	})
+}
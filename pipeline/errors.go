@@ -9,3 +9,109 @@ type TerminatedError string
 func (e TerminatedError) Error() string {
 	return fmt.Sprintf("Terminated. Reason: %v", string(e))
 }
+
+// ErrorCategory tells a plugin runner how to handle an error returned by the
+// plugin it's running.
+type ErrorCategory int
+
+const (
+	// The failure is temporary, e.g. a remote service being unavailable. The
+	// same message is retried after a back-off delay.
+	ErrorTransient ErrorCategory = iota
+	// The message itself can't be processed and retrying won't help, so it
+	// is dropped. This is the category of errors that aren't classified.
+	ErrorData
+	// The plugin can't continue with its current configuration. The plugin
+	// is stopped without being restarted.
+	ErrorConfig
+	// The plugin can't continue in its current state. The plugin is stopped
+	// and, if it supports it, restarted.
+	ErrorFatal
+)
+
+const numErrorCategories = 4
+
+// Type of the messages injected for the messages a filter or output with
+// `dead_letter` set drops because of data errors.
+const DeadLetterType = "heka.dead-letter"
+
+func (c ErrorCategory) String() string {
+	switch c {
+	case ErrorTransient:
+		return "transient"
+	case ErrorData:
+		return "data"
+	case ErrorConfig:
+		return "config"
+	case ErrorFatal:
+		return "fatal"
+	}
+	return "unknown"
+}
+
+// Interface for errors that carry an ErrorCategory. RetryMessageError is
+// transient and PluginExitError is fatal.
+type ClassifiedError interface {
+	error
+	Category() ErrorCategory
+}
+
+// Returns the category of the provided error, ErrorData if the error isn't a
+// ClassifiedError.
+func ErrorCategoryOf(err error) ErrorCategory {
+	if classified, ok := err.(ClassifiedError); ok {
+		return classified.Category()
+	}
+	return ErrorData
+}
+
+type classifiedError struct {
+	category ErrorCategory
+	msg      string
+}
+
+func newClassifiedError(category ErrorCategory, msg string,
+	subs ...interface{}) classifiedError {
+
+	if len(subs) > 0 {
+		msg = fmt.Sprintf(msg, subs...)
+	}
+	return classifiedError{category, msg}
+}
+
+func (err classifiedError) Error() string {
+	return err.msg
+}
+
+func (err classifiedError) Category() ErrorCategory {
+	return err.category
+}
+
+func NewTransientError(msg string, subs ...interface{}) ClassifiedError {
+	return newClassifiedError(ErrorTransient, msg, subs...)
+}
+
+func NewDataError(msg string, subs ...interface{}) ClassifiedError {
+	return newClassifiedError(ErrorData, msg, subs...)
+}
+
+func NewConfigError(msg string, subs ...interface{}) ClassifiedError {
+	return newClassifiedError(ErrorConfig, msg, subs...)
+}
+
+func NewFatalError(msg string, subs ...interface{}) ClassifiedError {
+	return newClassifiedError(ErrorFatal, msg, subs...)
+}
+
+// Prefixes the error message while keeping the error's category, so wrapping
+// doesn't turn a fatal error into a data error.
+func wrapError(err error, prefix string) error {
+	msg := fmt.Sprintf("%s: %s", prefix, err)
+	switch err.(type) {
+	case PluginExitError:
+		return PluginExitError{msg}
+	case RetryMessageError:
+		return RetryMessageError{msg}
+	}
+	return newClassifiedError(ErrorCategoryOf(err), msg)
+}
@@ -0,0 +1,178 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Processor that returns the queued errors in order, then nil.
+type ErroringProcessor struct {
+	CounterFilter
+	errs      []error
+	processed int
+}
+
+func (p *ErroringProcessor) ProcessMessage(pack *PipelinePack) error {
+	p.processed++
+	if len(p.errs) == 0 {
+		return nil
+	}
+	err := p.errs[0]
+	p.errs = p.errs[1:]
+	return err
+}
+
+func ErrorClassificationSpec(c gs.Context) {
+	c.Specify("Error categories", func() {
+		c.Specify("are assigned by the constructors", func() {
+			c.Expect(ErrorCategoryOf(NewTransientError("x")), gs.Equals, ErrorTransient)
+			c.Expect(ErrorCategoryOf(NewDataError("x")), gs.Equals, ErrorData)
+			c.Expect(ErrorCategoryOf(NewConfigError("x")), gs.Equals, ErrorConfig)
+			c.Expect(ErrorCategoryOf(NewFatalError("x %d", 1)), gs.Equals, ErrorFatal)
+			c.Expect(NewFatalError("x %d", 1).Error(), gs.Equals, "x 1")
+		})
+
+		c.Specify("cover the existing error types", func() {
+			c.Expect(ErrorCategoryOf(NewRetryMessageError("x")), gs.Equals,
+				ErrorTransient)
+			c.Expect(ErrorCategoryOf(NewPluginExitError("x")), gs.Equals, ErrorFatal)
+			c.Expect(ErrorCategoryOf(errors.New("x")), gs.Equals, ErrorData)
+		})
+
+		c.Specify("survive wrapping", func() {
+			err := wrapError(NewPluginExitError("gone"), "prefix")
+			_, ok := err.(PluginExitError)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(err.Error(), gs.Equals, "prefix: gone")
+			err = wrapError(NewConfigError("bad"), "prefix")
+			c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorConfig)
+		})
+	})
+
+	c.Specify("A filter runner", func() {
+		pConfig := NewPipelineConfig(nil)
+		processor := new(ErroringProcessor)
+		fRunner, err := NewFORunner("erroring", processor,
+			CommonFOConfig{Matcher: "TRUE"}, "CounterFilter", 10)
+		c.Assume(err, gs.IsNil)
+		fRunner.pConfig = pConfig
+
+		supply := make(chan *PipelinePack, 3)
+		send := func() {
+			pack := NewPipelinePack(supply)
+			fRunner.inChan <- pack
+		}
+
+		c.Specify("retries transient errors and drops data errors", func() {
+			processor.errs = []error{NewRetryMessageError("later"),
+				errors.New("unclassified"), NewDataError("bad data")}
+			send()
+			send()
+			send()
+			close(fRunner.inChan)
+			err = fRunner.channelLoop(processor, pConfig, nil)
+			c.Expect(err, gs.IsNil)
+			c.Expect(processor.processed, gs.Equals, 4)
+			c.Expect(len(supply), gs.Equals, 3)
+			c.Expect(fRunner.ErrorCount(ErrorTransient), gs.Equals, int64(1))
+			c.Expect(fRunner.ErrorCount(ErrorData), gs.Equals, int64(2))
+			c.Expect(fRunner.dropMessageCount, gs.Equals, int64(2))
+
+			msg := new(message.Message)
+			PopulateReportMsg(fRunner, msg)
+			val, _ := msg.GetFieldValue("DataErrorCount")
+			c.Expect(val, gs.Equals, int64(2))
+			val, _ = msg.GetFieldValue("FatalErrorCount")
+			c.Expect(val, gs.Equals, int64(0))
+		})
+
+		c.Specify("stops on config errors", func() {
+			processor.errs = []error{NewConfigError("no good")}
+			send()
+			send()
+			err = fRunner.channelLoop(processor, pConfig, nil)
+			c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorConfig)
+			c.Expect(processor.processed, gs.Equals, 1)
+			c.Expect(fRunner.ErrorCount(ErrorConfig), gs.Equals, int64(1))
+		})
+
+		c.Specify("re-injects data errors as dead letters", func() {
+			fRunner.config.DeadLetter = true
+			fRunner.h = pConfig
+			fRunner.matcher, err = NewMatchRunner("Type == 'app.log'", "", fRunner,
+				10, fRunner.inChan)
+			c.Assume(err, gs.IsNil)
+			pConfig.injectRecycleChan <- NewPipelinePack(pConfig.injectRecycleChan)
+
+			processor.errs = []error{NewDataError("bad data")}
+			pack := NewPipelinePack(supply)
+			pack.Message.SetType("app.log")
+			pack.Message.SetPayload("hello")
+			fRunner.inChan <- pack
+			close(fRunner.inChan)
+			err = fRunner.channelLoop(processor, pConfig, nil)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(supply), gs.Equals, 1)
+			c.Expect(fRunner.dropMessageCount, gs.Equals, int64(1))
+
+			dead := <-pConfig.router.inChan
+			c.Expect(dead.Message.GetType(), gs.Equals, DeadLetterType)
+			c.Expect(dead.Message.GetPayload(), gs.Equals, "hello")
+			val, _ := dead.Message.GetFieldValue("DeadLetterType")
+			c.Expect(val, gs.Equals, "app.log")
+			val, _ = dead.Message.GetFieldValue("DeadLetterPlugin")
+			c.Expect(val, gs.Equals, "erroring")
+			val, _ = dead.Message.GetFieldValue("DeadLetterError")
+			c.Expect(val, gs.Equals, "bad data")
+		})
+
+		c.Specify("sending buffered records", func() {
+			reply := make(chan error, 1)
+			go func() {
+				pack := <-fRunner.inChan
+				pack.DelivErrChan <- <-reply
+			}()
+			pack := NewPipelinePack(supply)
+			pack.BufferedPack = true
+			pack.DelivErrChan = make(chan error, 1)
+
+			c.Specify("returns transient errors for a retry", func() {
+				reply <- NewRetryMessageError("later")
+				err = fRunner.SendRecord(pack)
+				c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorTransient)
+				c.Expect(fRunner.ErrorCount(ErrorTransient), gs.Equals, int64(1))
+				c.Expect(len(supply), gs.Equals, 0)
+			})
+
+			c.Specify("drops records with data errors", func() {
+				reply <- NewDataError("bad data")
+				err = fRunner.SendRecord(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(fRunner.ErrorCount(ErrorData), gs.Equals, int64(1))
+				c.Expect(fRunner.dropMessageCount, gs.Equals, int64(1))
+				c.Expect(len(supply), gs.Equals, 1)
+			})
+
+			c.Specify("returns config errors so the plugin stops", func() {
+				reply <- NewConfigError("no good")
+				err = fRunner.SendRecord(pack)
+				c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorConfig)
+				c.Expect(fRunner.ErrorCount(ErrorConfig), gs.Equals, int64(1))
+				c.Expect(len(supply), gs.Equals, 1)
+			})
+		})
+	})
+}
@@ -69,3 +69,12 @@ func (s *notStoppable) IsStoppable() bool {
 func (s *notStoppable) Unregister(pConfig *PipelineConfig) error {
 	return nil
 }
+
+// UsesFiles is implemented by plugins whose behavior also depends on files
+// named in their config, such as sandbox scripts. A config reload replaces
+// such a plugin when the contents of those files changed, even if its config
+// didn't.
+type UsesFiles interface {
+	// Returns the paths of the files used with the provided config struct.
+	FilesUsed(config interface{}) []string
+}
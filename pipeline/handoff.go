@@ -0,0 +1,559 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listening sockets, accepted connections and queue buffers that can be
+// handed over to a new hekad process during a binary upgrade. The old process
+// serves the listening sockets on its upgrade socket, a new process started
+// with the same config takes them over before loading its plugins, so
+// connections and datagrams arriving meanwhile wait in the kernel's queues
+// instead of being refused. Once the old process has shut down it sends the
+// connections its inputs had accepted, along with the data read from them
+// that hadn't been split into records yet, and the location of its queue
+// buffers.
+type handoffRegistry struct {
+	lock sync.Mutex
+	// Sockets received from the old process that haven't been claimed by a
+	// plugin yet, by key.
+	inherited map[string]*os.File
+	// Sockets opened or claimed by this process, by key.
+	active map[string]filer
+	// Set once the listening sockets have been sent to a new process, from
+	// then on stopping inputs hand their connections over.
+	upgrading bool
+	// Connections to send to the new process, with the listener keys and
+	// pending data in `connStates`.
+	conns      []*os.File
+	connStates []connState
+	// Connections received from the old process that haven't been claimed
+	// by a plugin yet, by listener key.
+	inheritedConns map[string][]InheritedConn
+	// Queue buffer directories used by this process, and those left behind
+	// by the old process that haven't been claimed yet, by queue name.
+	spools          map[string]string
+	inheritedSpools map[string]string
+}
+
+// A connection accepted by the old process, and the data it had read from
+// the connection but not split into records yet.
+type InheritedConn struct {
+	Conn    net.Conn
+	Pending []byte
+}
+
+// State sent by the old process once it has shut down.
+type handoffState struct {
+	// One per connection, in the order of the connections' descriptors.
+	Conns  []connState
+	Spools []spoolState
+}
+
+type connState struct {
+	Listener string
+	Pending  []byte
+}
+
+type spoolState struct {
+	Queue string
+	Dir   string
+}
+
+// Implemented by *net.TCPListener and *net.UDPConn.
+type filer interface {
+	File() (*os.File, error)
+}
+
+var handoffs = newHandoffRegistry()
+
+func newHandoffRegistry() *handoffRegistry {
+	return &handoffRegistry{
+		inherited:       make(map[string]*os.File),
+		active:          make(map[string]filer),
+		inheritedConns:  make(map[string][]InheritedConn),
+		spools:          make(map[string]string),
+		inheritedSpools: make(map[string]string),
+	}
+}
+
+func handoffKey(kind, network, address string, index int) string {
+	return fmt.Sprintf("%s %s %s %d", kind, network, address, index)
+}
+
+// Returns the inherited socket for the key, if any, removing it from the
+// inherited sockets.
+func (r *handoffRegistry) claim(key string) *os.File {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	f := r.inherited[key]
+	delete(r.inherited, key)
+	return f
+}
+
+func (r *handoffRegistry) register(key string, sock filer) {
+	r.lock.Lock()
+	r.active[key] = sock
+	r.lock.Unlock()
+}
+
+// Duplicates the active sockets' descriptors for sending them to a new
+// process. Sockets that have been closed since they were registered are
+// skipped.
+func (r *handoffRegistry) files() (keys []string, files []*os.File) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, sock := range r.active {
+		f, err := sock.File()
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	return
+}
+
+// Returns the key the listener was registered with, if any.
+func (r *handoffRegistry) listenerKey(listener net.Listener) (string, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, sock := range r.active {
+		if l, ok := sock.(net.Listener); ok && l == listener {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Closes the inherited sockets and connections no plugin claimed, e.g.
+// because the new config doesn't listen on their address anymore, and
+// returns how many sockets were closed.
+func (r *handoffRegistry) closeUnclaimed() (n int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, f := range r.inherited {
+		f.Close()
+		delete(r.inherited, key)
+		n++
+	}
+	for key, conns := range r.inheritedConns {
+		for _, ic := range conns {
+			ic.Conn.Close()
+			n++
+		}
+		delete(r.inheritedConns, key)
+	}
+	return
+}
+
+// Returns the state to send to the new process once this one has shut down
+// and the descriptors of the connections it lists, clearing them from the
+// registry.
+func (r *handoffRegistry) takeState() (state *handoffState, files []*os.File) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	state = &handoffState{Conns: r.connStates}
+	for queue, dir := range r.spools {
+		state.Spools = append(state.Spools, spoolState{queue, dir})
+	}
+	files = r.conns
+	r.conns, r.connStates = nil, nil
+	return
+}
+
+// Stores the state received from the old process, the connection
+// descriptors matching the state's connections.
+func (r *handoffRegistry) inherit(state *handoffState, files []*os.File) {
+	conns := make(map[string][]InheritedConn)
+	for i, f := range files {
+		conn, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			LogError.Printf("Can't take over connection: %s", err)
+			continue
+		}
+		key := state.Conns[i].Listener
+		conns[key] = append(conns[key], InheritedConn{conn, state.Conns[i].Pending})
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key, keyConns := range conns {
+		r.inheritedConns[key] = append(r.inheritedConns[key], keyConns...)
+	}
+	for _, spool := range state.Spools {
+		r.inheritedSpools[spool.Queue] = spool.Dir
+	}
+}
+
+// ListenTCP listens like net.ListenTCP, but takes over the listener for the
+// address from the hekad process being upgraded if there is one, and makes
+// the listener available to hand over in turn.
+func ListenTCP(network string, addr *net.TCPAddr) (*net.TCPListener, error) {
+	key := handoffKey("tcp", network, addr.String(), 0)
+	var listener *net.TCPListener
+	if f := handoffs.claim(key); f != nil {
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("can't take over listener: %s", err)
+		}
+		var ok bool
+		if listener, ok = l.(*net.TCPListener); !ok {
+			l.Close()
+			return nil, fmt.Errorf("inherited listener for %s isn't TCP", addr)
+		}
+	} else {
+		var err error
+		if listener, err = net.ListenTCP(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	handoffs.register(key, listener)
+	return listener, nil
+}
+
+// HandOverConn hands a connection accepted on a listener returned by ListenTCP
+// over to the hekad process taking over from this one, along with the data
+// read from the connection that hasn't been split into records yet. Returns
+// false if no upgrade is in progress or the connection can't be handed over,
+// in which case the caller should close it as usual. Otherwise the
+// connection is duplicated and can be closed.
+func HandOverConn(listener net.Listener, conn net.Conn, pending []byte) bool {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	handoffs.lock.Lock()
+	upgrading := handoffs.upgrading
+	handoffs.lock.Unlock()
+	if !upgrading {
+		return false
+	}
+	key, ok := handoffs.listenerKey(listener)
+	if !ok {
+		return false
+	}
+	f, err := tcpConn.File()
+	if err != nil {
+		LogError.Printf("Can't hand over connection from %s: %s",
+			conn.RemoteAddr(), err)
+		return false
+	}
+	handoffs.lock.Lock()
+	handoffs.conns = append(handoffs.conns, f)
+	handoffs.connStates = append(handoffs.connStates,
+		connState{key, append([]byte(nil), pending...)})
+	handoffs.lock.Unlock()
+	return true
+}
+
+// InheritedConns returns the connections the hekad process being upgraded
+// had accepted on the listener returned by ListenTCP, removing them from the
+// inherited connections. The caller owns the connections and should feed
+// the pending data into the connection's splitter ahead of the data read
+// from the connection.
+func InheritedConns(listener net.Listener) []InheritedConn {
+	key, ok := handoffs.listenerKey(listener)
+	if !ok {
+		return nil
+	}
+	handoffs.lock.Lock()
+	defer handoffs.lock.Unlock()
+	conns := handoffs.inheritedConns[key]
+	delete(handoffs.inheritedConns, key)
+	return conns
+}
+
+// Records the directory of a queue buffer, so a new process taking over from
+// this one can find it, and takes over the queue buffer of the same name the
+// process being upgraded left behind if it's in a different directory, e.g.
+// because `base_dir` changed. The records are only moved if the queue
+// buffer in `dir` holds none, as they'd be interleaved otherwise.
+func handOverSpool(queue, dir string) error {
+	handoffs.lock.Lock()
+	handoffs.spools[queue] = dir
+	oldDir, ok := handoffs.inheritedSpools[queue]
+	delete(handoffs.inheritedSpools, queue)
+	handoffs.lock.Unlock()
+	if !ok || oldDir == dir || !fileExists(oldDir) {
+		return nil
+	}
+	if len(sortedBufferIds(dir)) > 0 {
+		return fmt.Errorf("queue buffer '%s' holds records, leaving the ones in %s",
+			dir, oldDir)
+	}
+	entries, err := ioutil.ReadDir(oldDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = os.Rename(filepath.Join(oldDir, entry.Name()),
+			filepath.Join(dir, entry.Name())); err != nil {
+			return fmt.Errorf("can't move queue buffer from %s: %s", oldDir, err)
+		}
+	}
+	os.Remove(oldDir)
+	LogInfo.Printf("Took over queue buffer '%s' from %s", queue, oldDir)
+	return nil
+}
+
+// Returns the UDP socket with the index for the address inherited from the
+// hekad process being upgraded, if there is one.
+func inheritedUDP(network string, addr *net.UDPAddr, index int) (*net.UDPConn, error) {
+	f := handoffs.claim(handoffKey("udp", network, addr.String(), index))
+	if f == nil {
+		return nil, nil
+	}
+	conn, err := net.FilePacketConn(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("can't take over UDP socket: %s", err)
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("inherited socket for %s isn't UDP", addr)
+	}
+	return udpConn, nil
+}
+
+// Message sent by the old process ahead of the descriptors: its pid and the
+// key of each descriptor, one per line.
+func encodeHandoff(pid int, keys []string) []byte {
+	return []byte(strconv.Itoa(pid) + "\n" + strings.Join(keys, "\n"))
+}
+
+func decodeHandoff(data []byte) (pid int, keys []string, err error) {
+	lines := strings.Split(string(data), "\n")
+	if pid, err = strconv.Atoi(lines[0]); err != nil {
+		return 0, nil, errors.New("malformed handoff message")
+	}
+	if len(lines) > 1 && !(len(lines) == 2 && lines[1] == "") {
+		keys = lines[1:]
+	}
+	return pid, keys, nil
+}
+
+// Largest state message we'll accept, enough for the pending data of a few
+// thousand connections.
+const maxStateMessage = 1 << 28
+
+// Sends the state, as length prefixed JSON, followed by the connections'
+// descriptors.
+func sendState(conn *net.UnixConn, state *handoffState, files []*os.File) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = binary.Write(conn, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	if _, err = conn.Write(data); err != nil {
+		return err
+	}
+	return sendFiles(conn, files)
+}
+
+// Receives the state sent by sendState. Returns a nil state if the old
+// process closed the connection without sending any.
+func receiveState(conn *net.UnixConn) (state *handoffState, files []*os.File,
+	err error) {
+
+	var size uint32
+	if err = binary.Read(conn, binary.BigEndian, &size); err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return nil, nil, err
+	}
+	if size > maxStateMessage {
+		return nil, nil, fmt.Errorf("state message of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(conn, data); err != nil {
+		return nil, nil, err
+	}
+	state = new(handoffState)
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, nil, fmt.Errorf("malformed state message: %s", err)
+	}
+	if files, err = receiveFiles(conn, len(state.Conns)); err != nil {
+		return nil, nil, err
+	}
+	return state, files, nil
+}
+
+// Takes over the listening sockets of a hekad process serving the upgrade
+// socket at `path`, if there is one. Once the sockets are received the old
+// process shuts down, and TakeOverListeners waits up to `timeout` for it to
+// finish, so that its queues and journals have been flushed before this
+// process loads its plugins, and for the connections and queue buffers it
+// hands over once it's done. Returns the old process's pid, 0 if there was
+// no process to take over from.
+func TakeOverListeners(path string, timeout time.Duration) (oldPid int, err error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		// Nobody's listening, a fresh start.
+		return 0, nil
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var (
+		keys  []string
+		files []*os.File
+	)
+	if oldPid, keys, files, err = receiveListeners(conn.(*net.UnixConn)); err != nil {
+		return 0, fmt.Errorf("can't receive listeners: %s", err)
+	}
+	handoffs.lock.Lock()
+	for i, key := range keys {
+		handoffs.inherited[key] = files[i]
+	}
+	handoffs.lock.Unlock()
+	LogInfo.Printf("Took over %d listeners from hekad process %d, waiting for it "+
+		"to shut down", len(keys), oldPid)
+
+	state, stateFiles, err := receiveState(conn.(*net.UnixConn))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return oldPid, fmt.Errorf("hekad process %d didn't shut down within %s",
+			oldPid, timeout)
+	}
+	if err != nil {
+		// The listeners are ours already, carry on without the connections.
+		LogError.Printf("Can't receive connections from hekad process %d: %s",
+			oldPid, err)
+	} else if state != nil {
+		handoffs.inherit(state, stateFiles)
+		LogInfo.Printf("Took over %d connections and %d queue buffers from "+
+			"hekad process %d", len(state.Conns), len(state.Spools), oldPid)
+	}
+
+	// The old process closes the connection when it's done.
+	buf := make([]byte, 1)
+	for {
+		if _, err = conn.Read(buf); err != nil {
+			break
+		}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return oldPid, fmt.Errorf("hekad process %d didn't shut down within %s",
+			oldPid, timeout)
+	}
+	return oldPid, nil
+}
+
+// Closes any listeners and connections taken over from the old process that
+// no plugin used.
+func CloseUnclaimedListeners() {
+	if n := handoffs.closeUnclaimed(); n > 0 {
+		LogInfo.Printf("Closed %d taken over sockets the config doesn't use", n)
+	}
+	handoffs.lock.Lock()
+	for queue, dir := range handoffs.inheritedSpools {
+		LogInfo.Printf("Queue buffer '%s' isn't used by the config, left in %s",
+			queue, dir)
+		delete(handoffs.inheritedSpools, queue)
+	}
+	handoffs.lock.Unlock()
+}
+
+// Serves the upgrade socket. When a new hekad connects it's sent the
+// listening sockets and this process shuts down; the connections and queue
+// buffers are sent by closeUpgradeConn once the shutdown is complete.
+func (self *PipelineConfig) serveUpgradeSocket(path string) (net.Listener, error) {
+	// A socket file left behind by a process that died can't be listened on.
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("another hekad is serving upgrade socket '%s'", path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !self.handOver(conn.(*net.UnixConn)) {
+				conn.Close()
+				continue
+			}
+			return
+		}
+	}()
+	return listener, nil
+}
+
+// Sends the listening sockets to a new hekad and starts shutting down,
+// returning false if the sockets couldn't be sent.
+func (self *PipelineConfig) handOver(conn *net.UnixConn) bool {
+	keys, files := handoffs.files()
+	err := sendListeners(conn, os.Getpid(), keys, files)
+	for _, f := range files {
+		f.Close()
+	}
+	if err != nil {
+		LogError.Printf("Can't hand over listeners: %s", err)
+		return false
+	}
+	LogInfo.Printf("Handed over %d listeners, shutting down for upgrade.", len(keys))
+	handoffs.lock.Lock()
+	handoffs.upgrading = true
+	handoffs.lock.Unlock()
+	self.upgradeLock.Lock()
+	self.upgradeConn = conn
+	self.upgradeLock.Unlock()
+	self.Globals.ShutDown(0)
+	return true
+}
+
+// Sends the connections handed over by the inputs and the queue buffers to
+// the new hekad, letting it know the shutdown is complete.
+func (self *PipelineConfig) closeUpgradeConn() {
+	self.upgradeLock.Lock()
+	if self.upgradeConn != nil {
+		state, files := handoffs.takeState()
+		if err := sendState(self.upgradeConn, state, files); err != nil {
+			LogError.Printf("Can't hand over connections: %s", err)
+		} else {
+			LogInfo.Printf("Handed over %d connections and %d queue buffers.",
+				len(state.Conns), len(state.Spools))
+		}
+		for _, f := range files {
+			f.Close()
+		}
+		self.upgradeConn.Close()
+		self.upgradeConn = nil
+	}
+	self.upgradeLock.Unlock()
+}
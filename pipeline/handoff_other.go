@@ -0,0 +1,47 @@
+// +build !linux,!darwin,!freebsd
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+var errHandoffUnsupported = errors.New("handing over listeners isn't supported " +
+	"on this platform")
+
+func sendListeners(conn *net.UnixConn, pid int, keys []string, files []*os.File) error {
+	return errHandoffUnsupported
+}
+
+func receiveListeners(conn *net.UnixConn) (pid int, keys []string, files []*os.File,
+	err error) {
+
+	return 0, nil, nil, errHandoffUnsupported
+}
+
+func sendFiles(conn *net.UnixConn, files []*os.File) error {
+	if len(files) == 0 {
+		return nil
+	}
+	return errHandoffUnsupported
+}
+
+func receiveFiles(conn *net.UnixConn, count int) (files []*os.File, err error) {
+	if count == 0 {
+		return nil, nil
+	}
+	return nil, errHandoffUnsupported
+}
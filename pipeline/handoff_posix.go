@@ -0,0 +1,163 @@
+// +build linux darwin freebsd
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Largest handoff message we'll read, enough for the keys of a few hundred
+// sockets.
+const maxHandoffMessage = 65536
+
+// Most descriptors the kernel passes in one message.
+const maxFdsPerMessage = 253
+
+func sendListeners(conn *net.UnixConn, pid int, keys []string, files []*os.File) error {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	// Length prefixed, so the receiver doesn't read past the message.
+	msg := encodeHandoff(pid, keys)
+	data := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(data, uint32(len(msg)))
+	copy(data[4:], msg)
+	_, _, err := conn.WriteMsgUnix(data, oob, nil)
+	return err
+}
+
+func receiveListeners(conn *net.UnixConn) (pid int, keys []string, files []*os.File,
+	err error) {
+
+	header := make([]byte, 4)
+	oob := make([]byte, syscall.CmsgSpace(maxFdsPerMessage*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(header, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	closeFds := func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}
+	if _, err = io.ReadFull(conn, header[n:]); err != nil {
+		closeFds()
+		return 0, nil, nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxHandoffMessage {
+		closeFds()
+		return 0, nil, nil, errors.New("handoff message is too large")
+	}
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		closeFds()
+		return 0, nil, nil, err
+	}
+	if pid, keys, err = decodeHandoff(buf); err != nil {
+		closeFds()
+		return 0, nil, nil, err
+	}
+	if len(keys) != len(fds) {
+		closeFds()
+		return 0, nil, nil, errors.New("descriptor count doesn't match the keys")
+	}
+	files = make([]*os.File, len(fds))
+	for i, fd := range fds {
+		files[i] = os.NewFile(uintptr(fd), keys[i])
+	}
+	return pid, keys, files, nil
+}
+
+// Returns the descriptors passed in the control messages.
+func parseRights(oob []byte) (fds []int, err error) {
+	if len(oob) == 0 {
+		return nil, nil
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+// Sends the descriptors in as many messages as needed, each carrying a
+// single byte of data so they can't be merged with the data around them.
+func sendFiles(conn *net.UnixConn, files []*os.File) error {
+	for len(files) > 0 {
+		n := len(files)
+		if n > maxFdsPerMessage {
+			n = maxFdsPerMessage
+		}
+		fds := make([]int, n)
+		for i, f := range files[:n] {
+			fds[i] = int(f.Fd())
+		}
+		if _, _, err := conn.WriteMsgUnix([]byte{byte(n)}, syscall.UnixRights(fds...),
+			nil); err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+	return nil
+}
+
+// Receives `count` descriptors sent by sendFiles.
+func receiveFiles(conn *net.UnixConn, count int) (files []*os.File, err error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(maxFdsPerMessage*4))
+	for len(files) < count {
+		_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err == nil {
+			var fds []int
+			if fds, err = parseRights(oob[:oobn]); err == nil && len(fds) == 0 {
+				err = errors.New("message carries no descriptors")
+			}
+			for _, fd := range fds {
+				files = append(files, os.NewFile(uintptr(fd), "handoff"))
+			}
+		}
+		if err == nil && len(files) > count {
+			err = errors.New("received more descriptors than expected")
+		}
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+	}
+	return files, nil
+}
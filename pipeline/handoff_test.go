@@ -0,0 +1,297 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func HandoffSpec(c gs.Context) {
+	handoffs = newHandoffRegistry()
+
+	c.Specify("The handoff message", func() {
+		c.Specify("round trips the pid and keys", func() {
+			keys := []string{"tcp tcp :5565 0", "udp udp4 127.0.0.1:514 1"}
+			pid, decoded, err := decodeHandoff(encodeHandoff(1234, keys))
+			c.Expect(err, gs.IsNil)
+			c.Expect(pid, gs.Equals, 1234)
+			c.Expect(len(decoded), gs.Equals, 2)
+			c.Expect(decoded[0], gs.Equals, keys[0])
+			c.Expect(decoded[1], gs.Equals, keys[1])
+		})
+
+		c.Specify("may have no keys", func() {
+			pid, decoded, err := decodeHandoff(encodeHandoff(1234, nil))
+			c.Expect(err, gs.IsNil)
+			c.Expect(pid, gs.Equals, 1234)
+			c.Expect(len(decoded), gs.Equals, 0)
+		})
+
+		c.Specify("is rejected without a pid", func() {
+			_, _, err := decodeHandoff([]byte("tcp tcp :5565 0"))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("ListenTCP", func() {
+		addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:0")
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("registers the listener for handing over", func() {
+			listener, err := ListenTCP("tcp4", addr)
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+			keys, files := handoffs.files()
+			c.Expect(len(keys), gs.Equals, 1)
+			c.Expect(keys[0], gs.Equals, handoffKey("tcp", "tcp4", addr.String(), 0))
+			for _, f := range files {
+				f.Close()
+			}
+		})
+
+		c.Specify("skips closed listeners when handing over", func() {
+			listener, err := ListenTCP("tcp4", addr)
+			c.Assume(err, gs.IsNil)
+			listener.Close()
+			keys, _ := handoffs.files()
+			c.Expect(len(keys), gs.Equals, 0)
+		})
+	})
+
+	c.Specify("HandOverConn", func() {
+		addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:0")
+		c.Assume(err, gs.IsNil)
+		listener, err := ListenTCP("tcp4", addr)
+		c.Assume(err, gs.IsNil)
+		defer listener.Close()
+		client, err := net.Dial("tcp4", listener.Addr().String())
+		c.Assume(err, gs.IsNil)
+		defer client.Close()
+		conn, err := listener.Accept()
+		c.Assume(err, gs.IsNil)
+		defer conn.Close()
+
+		c.Specify("doesn't hand over connections unless upgrading", func() {
+			c.Expect(HandOverConn(listener, conn, nil), gs.IsFalse)
+			state, files := handoffs.takeState()
+			c.Expect(len(state.Conns), gs.Equals, 0)
+			c.Expect(len(files), gs.Equals, 0)
+		})
+
+		c.Specify("hands over connections and their pending data", func() {
+			handoffs.upgrading = true
+			c.Expect(HandOverConn(listener, conn, []byte("pending")), gs.IsTrue)
+			state, files := handoffs.takeState()
+			c.Expect(len(files), gs.Equals, 1)
+			c.Expect(len(state.Conns), gs.Equals, 1)
+			c.Expect(state.Conns[0].Listener, gs.Equals,
+				handoffKey("tcp", "tcp4", addr.String(), 0))
+			c.Expect(string(state.Conns[0].Pending), gs.Equals, "pending")
+			for _, f := range files {
+				f.Close()
+			}
+		})
+	})
+
+	c.Specify("A queue buffer", func() {
+		dir, err := ioutil.TempDir("", "handoff")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+		oldDir := filepath.Join(dir, "old", "output_queue", "TcpOutput")
+		newDir := filepath.Join(dir, "new", "output_queue", "TcpOutput")
+		c.Assume(os.MkdirAll(oldDir, 0766), gs.IsNil)
+		c.Assume(os.MkdirAll(newDir, 0766), gs.IsNil)
+		err = ioutil.WriteFile(filepath.Join(oldDir, "1.log"), []byte("records"), 0644)
+		c.Assume(err, gs.IsNil)
+		err = ioutil.WriteFile(filepath.Join(oldDir, "checkpoint.txt"), []byte("1 3"),
+			0644)
+		c.Assume(err, gs.IsNil)
+		handoffs.inheritedSpools["output_queue/TcpOutput"] = oldDir
+
+		c.Specify("left in another directory is moved over", func() {
+			err := handOverSpool("output_queue/TcpOutput", newDir)
+			c.Expect(err, gs.IsNil)
+			data, err := ioutil.ReadFile(filepath.Join(newDir, "1.log"))
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(data), gs.Equals, "records")
+			c.Expect(fileExists(filepath.Join(newDir, "checkpoint.txt")), gs.IsTrue)
+			c.Expect(fileExists(oldDir), gs.IsFalse)
+			c.Expect(handoffs.spools["output_queue/TcpOutput"], gs.Equals, newDir)
+		})
+
+		c.Specify("isn't moved over records already queued", func() {
+			err := ioutil.WriteFile(filepath.Join(newDir, "1.log"), []byte("newer"),
+				0644)
+			c.Assume(err, gs.IsNil)
+			err = handOverSpool("output_queue/TcpOutput", newDir)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(fileExists(filepath.Join(oldDir, "1.log")), gs.IsTrue)
+		})
+	})
+
+	c.Specify("TakeOverListeners is a no-op without a serving process", func() {
+		oldPid, err := TakeOverListeners(filepath.Join(os.TempDir(),
+			"no-such-heka-upgrade.sock"), time.Second)
+		c.Expect(err, gs.IsNil)
+		c.Expect(oldPid, gs.Equals, 0)
+	})
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	c.Specify("Listeners", func() {
+		dir, err := ioutil.TempDir("", "handoff")
+		c.Assume(err, gs.IsNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "upgrade.sock")
+
+		addr, err := net.ResolveTCPAddr("tcp4", "127.0.0.1:0")
+		c.Assume(err, gs.IsNil)
+		old, err := ListenTCP("tcp4", addr)
+		c.Assume(err, gs.IsNil)
+		defer old.Close()
+
+		server, err := net.Listen("unix", path)
+		c.Assume(err, gs.IsNil)
+		defer server.Close()
+
+		c.Specify("are taken over from the serving process", func() {
+			go func() {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				keys, files := handoffs.files()
+				sendListeners(conn.(*net.UnixConn), 4321, keys, files)
+				for _, f := range files {
+					f.Close()
+				}
+				conn.Close()
+			}()
+
+			oldPid, err := TakeOverListeners(path, time.Second)
+			c.Expect(err, gs.IsNil)
+			c.Expect(oldPid, gs.Equals, 4321)
+
+			// The taken over listener accepts connections to the old
+			// listener's address.
+			listener, err := ListenTCP("tcp4", addr)
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+			c.Expect(listener.Addr().String(), gs.Equals, old.Addr().String())
+			c.Expect(len(handoffs.inherited), gs.Equals, 0)
+		})
+
+		c.Specify("are taken over with the accepted connections", func() {
+			client, err := net.Dial("tcp4", old.Addr().String())
+			c.Assume(err, gs.IsNil)
+			defer client.Close()
+			accepted, err := old.Accept()
+			c.Assume(err, gs.IsNil)
+
+			go func() {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				keys, files := handoffs.files()
+				sendListeners(conn.(*net.UnixConn), 4321, keys, files)
+				for _, f := range files {
+					f.Close()
+				}
+				// Shutting down, the input hands its connection over.
+				handoffs.upgrading = true
+				HandOverConn(old, accepted, []byte("part of a "))
+				accepted.Close()
+				handoffs.spools["output_queue/TcpOutput"] = "/var/cache/hekad/queue"
+				state, files := handoffs.takeState()
+				sendState(conn.(*net.UnixConn), state, files)
+				for _, f := range files {
+					f.Close()
+				}
+				conn.Close()
+			}()
+
+			_, err = TakeOverListeners(path, time.Second)
+			c.Expect(err, gs.IsNil)
+			handoffs.upgrading = false
+			c.Expect(handoffs.inheritedSpools["output_queue/TcpOutput"], gs.Equals,
+				"/var/cache/hekad/queue")
+
+			listener, err := ListenTCP("tcp4", addr)
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+			conns := InheritedConns(listener)
+			c.Assume(len(conns), gs.Equals, 1)
+			defer conns[0].Conn.Close()
+			c.Expect(string(conns[0].Pending), gs.Equals, "part of a ")
+
+			// The client's connection is still open, and its data goes to
+			// the taken over connection.
+			_, err = client.Write([]byte("record"))
+			c.Assume(err, gs.IsNil)
+			buf := make([]byte, 6)
+			conns[0].Conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = io.ReadFull(conns[0].Conn, buf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(buf), gs.Equals, "record")
+			c.Expect(len(InheritedConns(listener)), gs.Equals, 0)
+		})
+
+		c.Specify("time out if the serving process doesn't shut down", func() {
+			done := make(chan bool)
+			go func() {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				sendListeners(conn.(*net.UnixConn), 4321, nil, nil)
+				<-done
+				conn.Close()
+			}()
+
+			_, err := TakeOverListeners(path, 50*time.Millisecond)
+			close(done)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("are closed if not claimed", func() {
+			go func() {
+				conn, err := server.Accept()
+				if err != nil {
+					return
+				}
+				keys, files := handoffs.files()
+				sendListeners(conn.(*net.UnixConn), 4321, keys, files)
+				for _, f := range files {
+					f.Close()
+				}
+				conn.Close()
+			}()
+
+			_, err := TakeOverListeners(path, time.Second)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(handoffs.inherited), gs.Equals, 1)
+			c.Expect(handoffs.closeUnclaimed(), gs.Equals, 1)
+			c.Expect(len(handoffs.inherited), gs.Equals, 0)
+		})
+	})
+}
@@ -0,0 +1,368 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LeaderElectionConfig struct {
+	// Coordination service holding the lease, "consul" or "etcd".
+	Backend string
+	// Base URL of the coordination service's HTTP API. Defaults to the
+	// backend's local agent.
+	Address string
+	// Key of the lease, shared by all of the hekad instances in the
+	// election. Defaults to "heka/leader/<resource name>".
+	Key string
+	// Seconds the lease is held without being renewed.
+	Ttl uint
+	// Identity of this instance in the election. Defaults to the hostname.
+	NodeId string `toml:"node_id"`
+}
+
+// A lease on a key of a coordination service.
+type leaseBackend interface {
+	// Acquires or renews the lease, returning whether this node holds it.
+	acquire() (bool, error)
+	// Gives up the lease if this node holds it.
+	release() error
+}
+
+// Shared resource electing one leader among the hekad instances configured
+// with the same lease key, through a lease on a Consul or etcd key. Filters
+// and outputs referencing it with `leader_election` only receive messages and
+// timer events on the leader, so they run once fleet-wide; another instance
+// takes over within the lease TTL if the leader goes away.
+type LeaderElection struct {
+	name     string
+	pConfig  *PipelineConfig
+	conf     *LeaderElectionConfig
+	backend  leaseBackend
+	ttl      time.Duration
+	leader   int32
+	stopChan chan struct{}
+	// Last time the lease was confirmed, used only by the election loop.
+	lastRenewal time.Time
+}
+
+func (le *LeaderElection) SetName(name string) {
+	le.name = name
+}
+
+func (le *LeaderElection) SetPipelineConfig(pConfig *PipelineConfig) {
+	le.pConfig = pConfig
+}
+
+func (le *LeaderElection) ConfigStruct() interface{} {
+	return &LeaderElectionConfig{
+		Ttl: 15,
+	}
+}
+
+func (le *LeaderElection) Init(config interface{}) error {
+	le.conf = config.(*LeaderElectionConfig)
+	if le.conf.Ttl < 3 {
+		return errors.New("ttl must be at least 3 seconds")
+	}
+	le.ttl = time.Duration(le.conf.Ttl) * time.Second
+	if le.conf.Key == "" {
+		le.conf.Key = "heka/leader/" + le.name
+	}
+	if le.conf.NodeId == "" && le.pConfig != nil {
+		le.conf.NodeId = le.pConfig.hostname
+	}
+	if le.conf.NodeId == "" {
+		return errors.New("node_id must be set")
+	}
+	client := &http.Client{Timeout: le.ttl / 3}
+	switch le.conf.Backend {
+	case "consul":
+		if le.conf.Address == "" {
+			le.conf.Address = "http://127.0.0.1:8500"
+		}
+		le.backend = &consulLease{client: client, conf: le.conf}
+	case "etcd":
+		if le.conf.Address == "" {
+			le.conf.Address = "http://127.0.0.1:2379"
+		}
+		le.backend = &etcdLease{client: client, conf: le.conf}
+	default:
+		return fmt.Errorf("unknown backend '%s', must be 'consul' or 'etcd'",
+			le.conf.Backend)
+	}
+	le.conf.Address = strings.TrimRight(le.conf.Address, "/")
+	le.stopChan = make(chan struct{})
+	go le.electionLoop(le.stopChan)
+	return nil
+}
+
+// Returns whether this hekad instance is currently the leader.
+func (le *LeaderElection) IsLeader() bool {
+	return atomic.LoadInt32(&le.leader) == 1
+}
+
+func (le *LeaderElection) setLeader(leader bool) {
+	var val int32
+	if leader {
+		val = 1
+	}
+	if atomic.SwapInt32(&le.leader, val) != val {
+		if leader {
+			LogInfo.Printf("Leader election '%s': %s is now the leader", le.name,
+				le.conf.NodeId)
+		} else {
+			LogInfo.Printf("Leader election '%s': %s is no longer the leader",
+				le.name, le.conf.NodeId)
+		}
+	}
+}
+
+// Tries to acquire or renew the lease. A leader that can't reach the
+// coordination service steps down before its lease could expire, so two
+// instances never lead at once.
+func (le *LeaderElection) update() {
+	leader, err := le.backend.acquire()
+	if err != nil {
+		LogError.Printf("Leader election '%s': %s", le.name, err)
+		if le.IsLeader() && time.Since(le.lastRenewal) > le.ttl*2/3 {
+			le.setLeader(false)
+		}
+		return
+	}
+	if leader {
+		le.lastRenewal = time.Now()
+	}
+	le.setLeader(leader)
+}
+
+func (le *LeaderElection) electionLoop(stopChan chan struct{}) {
+	ticker := time.NewTicker(le.ttl / 3)
+	defer ticker.Stop()
+	for {
+		le.update()
+		select {
+		case <-ticker.C:
+		case <-stopChan:
+			le.setLeader(false)
+			if err := le.backend.release(); err != nil {
+				LogError.Printf("Leader election '%s': can't release lease: %s",
+					le.name, err)
+			}
+			return
+		}
+	}
+}
+
+func (le *LeaderElection) Stop() {
+	if le.stopChan != nil {
+		close(le.stopChan)
+		le.stopChan = nil
+	}
+}
+
+// Returns a channel relaying the ticks sent while this instance is the
+// leader. Like a time.Ticker's, ticks nobody is waiting for are dropped.
+func (le *LeaderElection) gateTicker(ticker <-chan time.Time) <-chan time.Time {
+	gated := make(chan time.Time, 1)
+	go func() {
+		for t := range ticker {
+			if !le.IsLeader() {
+				continue
+			}
+			select {
+			case gated <- t:
+			default:
+			}
+		}
+	}()
+	return gated
+}
+
+// Returns the named LeaderElection resource.
+func (self *PipelineConfig) LeaderElection(name string) (*LeaderElection, error) {
+	resource, err := self.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	election, ok := resource.(*LeaderElection)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a LeaderElection resource", name)
+	}
+	return election, nil
+}
+
+// Sends a request to a coordination service, returning the response status
+// and body.
+func leaseRequest(client *http.Client, method, url, contentType, body string) (
+	int, []byte, error) {
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// Lease held through a Consul session locking the key, the session being
+// invalidated, and the key deleted, when it isn't renewed within the TTL.
+type consulLease struct {
+	client  *http.Client
+	conf    *LeaderElectionConfig
+	session string
+}
+
+func (l *consulLease) createSession() error {
+	body, _ := json.Marshal(map[string]string{
+		"Name":      l.conf.Key,
+		"TTL":       fmt.Sprintf("%ds", l.conf.Ttl),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	status, data, err := leaseRequest(l.client, "PUT",
+		l.conf.Address+"/v1/session/create", "application/json", string(body))
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("creating session: %d %s", status, data)
+	}
+	var session struct{ ID string }
+	if err = json.Unmarshal(data, &session); err != nil || session.ID == "" {
+		return fmt.Errorf("creating session: unexpected response %q", data)
+	}
+	l.session = session.ID
+	return nil
+}
+
+func (l *consulLease) acquire() (bool, error) {
+	if l.session != "" {
+		status, data, err := leaseRequest(l.client, "PUT",
+			l.conf.Address+"/v1/session/renew/"+l.session, "", "")
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			// The session expired, start over.
+			l.session = ""
+		default:
+			return false, fmt.Errorf("renewing session: %d %s", status, data)
+		}
+	}
+	if l.session == "" {
+		if err := l.createSession(); err != nil {
+			return false, err
+		}
+	}
+	status, data, err := leaseRequest(l.client, "PUT",
+		fmt.Sprintf("%s/v1/kv/%s?acquire=%s", l.conf.Address, l.conf.Key, l.session),
+		"", l.conf.NodeId)
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("acquiring lock: %d %s", status, data)
+	}
+	return strings.TrimSpace(string(data)) == "true", nil
+}
+
+func (l *consulLease) release() error {
+	if l.session == "" {
+		return nil
+	}
+	_, _, err := leaseRequest(l.client, "PUT", l.conf.Address+"/v1/session/destroy/"+
+		l.session, "", "")
+	l.session = ""
+	return err
+}
+
+// Lease held through an etcd key with a TTL, created only if it doesn't
+// exist and refreshed only if it still holds this node's id.
+type etcdLease struct {
+	client *http.Client
+	conf   *LeaderElectionConfig
+	held   bool
+}
+
+func (l *etcdLease) acquire() (bool, error) {
+	form := url.Values{
+		"value": {l.conf.NodeId},
+		"ttl":   {fmt.Sprint(l.conf.Ttl)},
+	}
+	keyURL := fmt.Sprintf("%s/v2/keys/%s", l.conf.Address, l.conf.Key)
+	if l.held {
+		status, data, err := leaseRequest(l.client, "PUT",
+			keyURL+"?prevValue="+url.QueryEscape(l.conf.NodeId),
+			"application/x-www-form-urlencoded", form.Encode())
+		if err != nil {
+			return false, err
+		}
+		switch status {
+		case http.StatusOK, http.StatusCreated:
+			return true, nil
+		case http.StatusPreconditionFailed, http.StatusNotFound:
+			// The lease expired, try to take it again.
+			l.held = false
+		default:
+			return false, fmt.Errorf("refreshing key: %d %s", status, data)
+		}
+	}
+	status, data, err := leaseRequest(l.client, "PUT", keyURL+"?prevExist=false",
+		"application/x-www-form-urlencoded", form.Encode())
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK, http.StatusCreated:
+		l.held = true
+	case http.StatusPreconditionFailed:
+	default:
+		return false, fmt.Errorf("creating key: %d %s", status, data)
+	}
+	return l.held, nil
+}
+
+func (l *etcdLease) release() error {
+	if !l.held {
+		return nil
+	}
+	l.held = false
+	_, _, err := leaseRequest(l.client, "DELETE", fmt.Sprintf(
+		"%s/v2/keys/%s?prevValue=%s", l.conf.Address, l.conf.Key,
+		url.QueryEscape(l.conf.NodeId)), "", "")
+	return err
+}
+
+func init() {
+	RegisterResource("LeaderElection", func() interface{} {
+		return new(LeaderElection)
+	})
+}
@@ -0,0 +1,200 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Minimal etcd v2 keys API holding keys without expiring them.
+type fakeEtcd struct {
+	lock sync.Mutex
+	keys map[string]string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := strings.TrimPrefix(req.URL.Path, "/v2/keys/")
+	current, exists := f.keys[key]
+	query := req.URL.Query()
+	if prev := query.Get("prevValue"); prev != "" && (!exists || prev != current) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	switch req.Method {
+	case "PUT":
+		if query.Get("prevExist") == "false" && exists {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.keys[key] = req.FormValue("value")
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		delete(f.keys, key)
+	}
+}
+
+// Minimal Consul session and KV lock API.
+type fakeConsul struct {
+	lock     sync.Mutex
+	sessions map[string]bool
+	holders  map[string]string
+	nextId   int
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := req.URL.Path
+	switch {
+	case path == "/v1/session/create":
+		f.nextId++
+		id := fmt.Sprintf("session-%d", f.nextId)
+		f.sessions[id] = true
+		fmt.Fprintf(w, `{"ID": "%s"}`, id)
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		if !f.sessions[strings.TrimPrefix(path, "/v1/session/renew/")] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(path, "/v1/session/destroy/")
+		delete(f.sessions, id)
+		for key, holder := range f.holders {
+			if holder == id {
+				delete(f.holders, key)
+			}
+		}
+	case strings.HasPrefix(path, "/v1/kv/"):
+		ioutil.ReadAll(req.Body)
+		key := strings.TrimPrefix(path, "/v1/kv/")
+		id := req.URL.Query().Get("acquire")
+		if holder := f.holders[key]; holder == "" || holder == id {
+			f.holders[key] = id
+			fmt.Fprint(w, "true")
+		} else {
+			fmt.Fprint(w, "false")
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func waitForLeader(le *LeaderElection, leader bool) bool {
+	for i := 0; i < 40; i++ {
+		if le.IsLeader() == leader {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return false
+}
+
+func LeaderElectionSpec(c gs.Context) {
+	newElection := func(backend, address, nodeId string) (*LeaderElection, error) {
+		le := new(LeaderElection)
+		le.SetName("leader")
+		config := le.ConfigStruct().(*LeaderElectionConfig)
+		config.Backend = backend
+		config.Address = address
+		config.NodeId = nodeId
+		config.Ttl = 3
+		return le, le.Init(config)
+	}
+
+	c.Specify("A LeaderElection resource", func() {
+		c.Specify("rejects an unknown backend", func() {
+			_, err := newElection("zookeeper", "", "node1")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects a ttl too short to renew the lease", func() {
+			le := new(LeaderElection)
+			config := le.ConfigStruct().(*LeaderElectionConfig)
+			config.Backend = "etcd"
+			config.NodeId = "node1"
+			config.Ttl = 1
+			c.Expect(le.Init(config), gs.Not(gs.IsNil))
+		})
+
+		electsOneLeader := func(backend string, server http.Handler) {
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			first, err := newElection(backend, ts.URL, "node1")
+			c.Assume(err, gs.IsNil)
+			c.Expect(waitForLeader(first, true), gs.IsTrue)
+			second, err := newElection(backend, ts.URL, "node2")
+			c.Assume(err, gs.IsNil)
+			defer second.Stop()
+			time.Sleep(100 * time.Millisecond)
+			c.Expect(second.IsLeader(), gs.IsFalse)
+
+			first.Stop()
+			c.Expect(waitForLeader(first, false), gs.IsTrue)
+			c.Expect(waitForLeader(second, true), gs.IsTrue)
+		}
+
+		c.Specify("elects one leader and fails over through etcd", func() {
+			electsOneLeader("etcd", &fakeEtcd{keys: make(map[string]string)})
+		})
+
+		c.Specify("elects one leader and fails over through consul", func() {
+			electsOneLeader("consul", &fakeConsul{
+				sessions: make(map[string]bool),
+				holders:  make(map[string]string),
+			})
+		})
+
+		c.Specify("steps down when the lease can't be renewed", func() {
+			ts := httptest.NewServer(&fakeEtcd{keys: make(map[string]string)})
+			le, err := newElection("etcd", ts.URL, "node1")
+			c.Assume(err, gs.IsNil)
+			defer le.Stop()
+			c.Expect(waitForLeader(le, true), gs.IsTrue)
+			ts.Close()
+			time.Sleep(le.ttl)
+			c.Expect(le.IsLeader(), gs.IsFalse)
+		})
+
+		c.Specify("only passes ticks through on the leader", func() {
+			le := &LeaderElection{conf: &LeaderElectionConfig{NodeId: "node1"}}
+			ticker := make(chan time.Time)
+			gated := le.gateTicker(ticker)
+			ticker <- time.Now()
+			ticker <- time.Now()
+			select {
+			case <-gated:
+				c.Expect(false, gs.IsTrue)
+			default:
+			}
+			le.setLeader(true)
+			ticker <- time.Now()
+			var ticked bool
+			select {
+			case <-gated:
+				ticked = true
+			case <-time.After(time.Second):
+			}
+			c.Expect(ticked, gs.IsTrue)
+			close(ticker)
+		})
+	})
+}
@@ -167,6 +167,10 @@ type MultiDecoder struct {
 	Decoders               []Decoder
 	dRunner                DecoderRunner
 	CascStrat              int
+	FailPolicy             int
+	FailureDecoder         Decoder
+	fallbackCount          int64
+	fallbackFailures       int64
 	neverTrustEncodes      bool
 }
 
@@ -174,6 +178,11 @@ type MultiDecoderConfig struct {
 	Subs            []string
 	LogSubErrors    bool   `toml:"log_sub_errors"`
 	CascadeStrategy string `toml:"cascade_strategy"`
+	// What to do when a subdecoder fails, "continue", "stop" or "fallback".
+	FailurePolicy string `toml:"failure_policy"`
+	// Decoder the original pack is handed to when decoding fails with the
+	// "fallback" failure policy.
+	FailureDecoder string `toml:"failure_decoder"`
 }
 
 const (
@@ -183,9 +192,21 @@ const (
 
 var mdStrategies = map[string]int{"first-wins": CASC_FIRST_WINS, "all": CASC_ALL}
 
+const (
+	FAIL_CONTINUE = iota
+	FAIL_STOP
+	FAIL_FALLBACK
+)
+
+var mdFailurePolicies = map[string]int{"continue": FAIL_CONTINUE, "stop": FAIL_STOP,
+	"fallback": FAIL_FALLBACK}
+
 func (md *MultiDecoder) ConfigStruct() interface{} {
-	subs := make([]string, 0)
-	return &MultiDecoderConfig{subs, false, "first-wins"}
+	return &MultiDecoderConfig{
+		Subs:            make([]string, 0),
+		CascadeStrategy: "first-wins",
+		FailurePolicy:   "continue",
+	}
 }
 
 // Heka will call this before calling Init() to set the name of the
@@ -226,6 +247,24 @@ func (md *MultiDecoder) Init(config interface{}) (err error) {
 		md.Decoders[i] = decoder
 	}
 
+	if md.FailPolicy, ok = mdFailurePolicies[md.Config.FailurePolicy]; !ok {
+		return fmt.Errorf("Unrecognized failure policy: %s", md.Config.FailurePolicy)
+	}
+	switch {
+	case md.FailPolicy == FAIL_STOP && md.CascStrat == CASC_FIRST_WINS:
+		return errors.New("The 'stop' failure policy requires the 'all' cascade strategy.")
+	case md.FailPolicy == FAIL_FALLBACK && md.Config.FailureDecoder == "":
+		return errors.New("The 'fallback' failure policy requires a failure_decoder.")
+	case md.FailPolicy != FAIL_FALLBACK && md.Config.FailureDecoder != "":
+		return errors.New("A failure_decoder requires the 'fallback' failure policy.")
+	}
+	if md.FailPolicy == FAIL_FALLBACK {
+		name := md.Config.FailureDecoder
+		if md.FailureDecoder, ok = md.pConfig.Decoder(name); !ok {
+			return fmt.Errorf("Non-existent failure decoder: %s", name)
+		}
+	}
+
 	// We can trust the embedded decoders to leave the pack.MsgBytes and
 	// pack.TrustMsgBytes values in the right state in all cases except when
 	// cascade_strategy == "all", an earlier decoder sets the encoding, but
@@ -259,37 +298,47 @@ func (md *MultiDecoder) Init(config interface{}) (err error) {
 func (md *MultiDecoder) SetDecoderRunner(dr DecoderRunner) {
 	md.dRunner = dr
 	for i, decoder := range md.Decoders {
-		subName := md.Config.Subs[i]
-		if wanter, ok := decoder.(WantsDecoderRunner); ok {
-			// It wants a DecoderRunner, have to create one. But first we need
-			// to get our hands on a *dRunner.
-			var realDRunner *dRunner
-			if realDRunner, ok = dr.(*dRunner); !ok {
-				// It's not a *dRunner, maybe it's an *mDRunner?
-				var mdr *mDRunner
-				if mdr, ok = dr.(*mDRunner); ok {
-					// Bingo, we can grab its *dRunner.
-					realDRunner = mdr.dRunner
-				}
-			}
-			if realDRunner == nil {
-				// Couldn't get a *dRunner. Just log an error and pass the
-				// outer DecoderRunner through.
-				dr.LogError(fmt.Errorf("Can't create nested DecoderRunner for '%s'",
-					subName))
-				wanter.SetDecoderRunner(dr)
-				continue
-			}
-			// We have a *dRunner, use it to create an *mDRunner.
-			subRunner := &mDRunner{
-				realDRunner,
-				decoder,
-				fmt.Sprintf("%s-%s", realDRunner.name, subName),
-				subName,
-			}
-			wanter.SetDecoderRunner(subRunner)
+		md.setSubDecoderRunner(dr, decoder, md.Config.Subs[i])
+	}
+	if md.FailureDecoder != nil {
+		md.setSubDecoderRunner(dr, md.FailureDecoder, md.Config.FailureDecoder)
+	}
+}
+
+func (md *MultiDecoder) setSubDecoderRunner(dr DecoderRunner, decoder Decoder,
+	subName string) {
+
+	wanter, ok := decoder.(WantsDecoderRunner)
+	if !ok {
+		return
+	}
+	// It wants a DecoderRunner, have to create one. But first we need to get
+	// our hands on a *dRunner.
+	var realDRunner *dRunner
+	if realDRunner, ok = dr.(*dRunner); !ok {
+		// It's not a *dRunner, maybe it's an *mDRunner?
+		var mdr *mDRunner
+		if mdr, ok = dr.(*mDRunner); ok {
+			// Bingo, we can grab its *dRunner.
+			realDRunner = mdr.dRunner
 		}
 	}
+	if realDRunner == nil {
+		// Couldn't get a *dRunner. Just log an error and pass the outer
+		// DecoderRunner through.
+		dr.LogError(fmt.Errorf("Can't create nested DecoderRunner for '%s'",
+			subName))
+		wanter.SetDecoderRunner(dr)
+		return
+	}
+	// We have a *dRunner, use it to create an *mDRunner.
+	subRunner := &mDRunner{
+		realDRunner,
+		decoder,
+		fmt.Sprintf("%s-%s", realDRunner.name, subName),
+		subName,
+	}
+	wanter.SetDecoderRunner(subRunner)
 }
 
 // Heka will call this at DecoderRunner shutdown time, we might need to pass
@@ -300,12 +349,31 @@ func (md *MultiDecoder) Shutdown() {
 			wanter.Shutdown()
 		}
 	}
+	if wanter, ok := md.FailureDecoder.(WantsDecoderRunnerShutdown); ok {
+		wanter.Shutdown()
+	}
+}
+
+// A MultiDecoder keeps state between messages if any of its subdecoders do.
+func (md *MultiDecoder) IsStateful() bool {
+	isStateful := func(decoder Decoder) bool {
+		stateful, ok := decoder.(StatefulDecoder)
+		return ok && stateful.IsStateful()
+	}
+	for _, decoder := range md.Decoders {
+		if isStateful(decoder) {
+			return true
+		}
+	}
+	return isStateful(md.FailureDecoder)
 }
 
 // Recurses through a decoder chain, decoding the original pack and returning
-// it and any generated extra packs.
+// it and any generated extra packs. Unless the failure policy is "continue",
+// the chain ends with the first subdecoder that fails, whose name is
+// returned.
 func (md *MultiDecoder) getDecodedPacks(chain []Decoder, inPacks []*PipelinePack) (
-	packs []*PipelinePack, anyMatch bool) {
+	packs []*PipelinePack, anyMatch bool, failedSub string) {
 
 	var startTime time.Time
 
@@ -328,26 +396,48 @@ func (md *MultiDecoder) getDecodedPacks(chain []Decoder, inPacks []*PipelinePack
 			packs = append(packs, ps...)
 		} else {
 			atomic.AddInt64(&md.processMessageFailures[md.idx], 1)
+			idx := len(md.Decoders) - len(chain)
 			if err != nil && md.Config.LogSubErrors {
-				idx := len(md.Decoders) - len(chain)
 				err = fmt.Errorf("Subdecoder '%s' decode error: %s",
 					md.Config.Subs[idx], err)
 				md.dRunner.LogError(err)
 			}
 			packs = append(packs, p)
+			if md.FailPolicy != FAIL_CONTINUE {
+				failedSub = md.Config.Subs[idx]
+			}
 		}
 	}
 
-	if len(chain) > 1 {
+	if len(chain) > 1 && failedSub == "" {
 		md.idx++
 		var otherMatch bool
-		packs, otherMatch = md.getDecodedPacks(chain[1:], packs)
+		packs, otherMatch, failedSub = md.getDecodedPacks(chain[1:], packs)
 		anyMatch = anyMatch || otherMatch
 	}
 
 	return
 }
 
+// Hands the original pack to the failure decoder after the subdecoders
+// failed to decode it.
+func (md *MultiDecoder) fallback(pack *PipelinePack, subErr error) (
+	packs []*PipelinePack, err error) {
+
+	atomic.AddInt64(&md.fallbackCount, 1)
+	if packs, err = md.FailureDecoder.Decode(pack); packs == nil {
+		atomic.AddInt64(&md.fallbackFailures, 1)
+		return nil, fmt.Errorf("%s Failure decoder '%s' error: %v", subErr,
+			md.Config.FailureDecoder, err)
+	}
+	if _, ok := md.FailureDecoder.(EncodesMsgBytes); !ok {
+		for _, p := range packs {
+			p.TrustMsgBytes = false
+		}
+	}
+	return packs, nil
+}
+
 // Runs the message payload against each of the decoders.
 func (md *MultiDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
 	md.sample = (rand.Intn(md.sampleDenominator) == 0 ||
@@ -397,10 +487,24 @@ func (md *MultiDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err e
 		packs = nil
 	} else {
 		// If we get here we know cascade_strategy == "all".
-		var anyMatch bool
+		var (
+			anyMatch  bool
+			failedSub string
+		)
 		md.idx = 0
-		packs, anyMatch = md.getDecodedPacks(md.Decoders, []*PipelinePack{pack})
-		if !anyMatch {
+		packs, anyMatch, failedSub = md.getDecodedPacks(md.Decoders,
+			[]*PipelinePack{pack})
+		if failedSub != "" {
+			// Only the original pack is recycled by the DecoderRunner.
+			for _, p := range packs {
+				if p != pack {
+					p.Recycle(nil)
+				}
+			}
+			atomic.AddInt64(&md.totalMessageFailures, 1)
+			err = fmt.Errorf("Subdecoder '%s' failed.", failedSub)
+			packs = nil
+		} else if !anyMatch {
 			atomic.AddInt64(&md.totalMessageFailures, 1)
 			err = errors.New("All subdecoders failed.")
 			packs = nil
@@ -410,6 +514,9 @@ func (md *MultiDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err e
 			}
 		}
 	}
+	if packs == nil && md.FailureDecoder != nil {
+		packs, err = md.fallback(pack, err)
+	}
 	return
 }
 
@@ -452,6 +559,12 @@ func (md *MultiDecoder) ReportMsg(msg *message.Message) error {
 		tmp = md.totalMessageDuration / md.totalMessageSamples
 	}
 	message.NewInt64Field(msg, "ProcessMessageAvgDuration", tmp, "ns")
+	if md.FailureDecoder != nil {
+		message.NewInt64Field(msg, "FallbackCount",
+			atomic.LoadInt64(&md.fallbackCount), "count")
+		message.NewInt64Field(msg, "FallbackFailures",
+			atomic.LoadInt64(&md.fallbackFailures), "count")
+	}
 
 	return nil
 }
@@ -0,0 +1,126 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// InterfaceAddress returns `address` bound to the named network interface.
+// The host portion of `address` must be empty (e.g. ":5565"), it's replaced
+// with the interface's first IP address of the family selected by `network`:
+// IPv4 for networks ending in "4" (e.g. "tcp4"), IPv6 for networks ending in
+// "6" (e.g. "udp6"), otherwise IPv4 is preferred over IPv6. IPv6 link-local
+// addresses get the interface as their zone.
+func InterfaceAddress(network, ifaceName, address string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+	if host != "" {
+		return "", fmt.Errorf("address '%s' can't specify a host when binding to "+
+			"interface '%s'", address, ifaceName)
+	}
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return "", fmt.Errorf("can't find interface '%s': %s", ifaceName, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("can't get addresses of interface '%s': %s", ifaceName, err)
+	}
+
+	var v4, v6 net.IP
+	for _, addr := range addrs {
+		var ip net.IP
+		switch a := addr.(type) {
+		case *net.IPNet:
+			ip = a.IP
+		case *net.IPAddr:
+			ip = a.IP
+		}
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			if v4 == nil {
+				v4 = ip
+			}
+		} else if v6 == nil {
+			v6 = ip
+		}
+	}
+
+	var ip net.IP
+	switch {
+	case strings.HasSuffix(network, "4"):
+		ip = v4
+	case strings.HasSuffix(network, "6"):
+		ip = v6
+	case v4 != nil:
+		ip = v4
+	default:
+		ip = v6
+	}
+	if ip == nil {
+		return "", fmt.Errorf("interface '%s' has no address usable for network '%s'",
+			ifaceName, network)
+	}
+	host = ip.String()
+	if ip.To4() == nil && ip.IsLinkLocalUnicast() {
+		host += "%" + ifaceName
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// ListenUDPSockets listens on `n` UDP sockets bound to the same address, so
+// that several goroutines can read from it in parallel. A single socket is
+// an ordinary UDP listener. Multiple sockets are opened with SO_REUSEPORT,
+// which has the kernel spread incoming datagrams across them; this is only
+// supported on Linux, Darwin and FreeBSD. If `addr` has no port, all sockets
+// share the port chosen for the first. Like ListenTCP, sockets are taken
+// over from the hekad process being upgraded if it has them.
+func ListenUDPSockets(network string, addr *net.UDPAddr, n int) (
+	[]*net.UDPConn, error) {
+
+	if n <= 1 {
+		conn, err := inheritedUDP(network, addr, 0)
+		if conn == nil && err == nil {
+			conn, err = net.ListenUDP(network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		handoffs.register(handoffKey("udp", network, addr.String(), 0), conn)
+		return []*net.UDPConn{conn}, nil
+	}
+	bindAddr := *addr
+	conns := make([]*net.UDPConn, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := inheritedUDP(network, addr, i)
+		if conn == nil && err == nil {
+			conn, err = listenUDPReusePort(network, &bindAddr)
+		}
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		handoffs.register(handoffKey("udp", network, addr.String(), i), conn)
+		conns = append(conns, conn)
+		bindAddr.Port = conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return conns, nil
+}
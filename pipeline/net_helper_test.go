@@ -0,0 +1,112 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"net"
+	"runtime"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func InterfaceAddressSpec(c gs.Context) {
+	var loopback string
+	ifaces, err := net.Interfaces()
+	c.Assume(err, gs.IsNil)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+
+	c.Specify("InterfaceAddress", func() {
+		c.Specify("binds to the interface's IPv4 address", func() {
+			if loopback == "" {
+				return
+			}
+			address, err := InterfaceAddress("tcp4", loopback, ":5565")
+			c.Expect(err, gs.IsNil)
+			host, port, err := net.SplitHostPort(address)
+			c.Expect(err, gs.IsNil)
+			c.Expect(port, gs.Equals, "5565")
+			c.Expect(net.ParseIP(host).IsLoopback(), gs.IsTrue)
+			c.Expect(net.ParseIP(host).To4(), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects addresses with a host", func() {
+			_, err := InterfaceAddress("tcp", "lo", "127.0.0.1:5565")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown interfaces", func() {
+			_, err := InterfaceAddress("udp", "nosuchiface0", ":5565")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
+
+func ListenUDPSocketsSpec(c gs.Context) {
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+
+	c.Specify("ListenUDPSockets", func() {
+		c.Specify("opens a single ordinary socket", func() {
+			conns, err := ListenUDPSockets("udp4", addr, 1)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(conns), gs.Equals, 1)
+			conns[0].Close()
+		})
+
+		if runtime.GOOS != "linux" {
+			return
+		}
+
+		c.Specify("opens several sockets on the same port", func() {
+			conns, err := ListenUDPSockets("udp4", addr, 3)
+			c.Assume(err, gs.IsNil)
+			c.Assume(len(conns), gs.Equals, 3)
+			local := conns[0].LocalAddr().String()
+			for _, conn := range conns {
+				c.Expect(conn.LocalAddr().String(), gs.Equals, local)
+			}
+
+			// Datagrams are received by one of the sockets.
+			received := make(chan string, 1)
+			for _, conn := range conns {
+				go func(conn *net.UDPConn) {
+					buf := make([]byte, 16)
+					n, err := conn.Read(buf)
+					if err == nil {
+						received <- string(buf[:n])
+					}
+				}(conn)
+			}
+			sender, err := net.Dial("udp4", local)
+			c.Assume(err, gs.IsNil)
+			sender.Write([]byte("hello"))
+			sender.Close()
+			c.Expect(<-received, gs.Equals, "hello")
+			for _, conn := range conns {
+				conn.Close()
+			}
+		})
+
+		c.Specify("doesn't share a port with an ordinary socket", func() {
+			conns, err := ListenUDPSockets("udp4", addr, 1)
+			c.Assume(err, gs.IsNil)
+			defer conns[0].Close()
+			taken := *conns[0].LocalAddr().(*net.UDPAddr)
+			_, err = ListenUDPSockets("udp4", &taken, 2)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,190 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Describes how numbers are written in locale formatted text, so that values
+// such as "1.234,56" or "€ 1 234,56" can be parsed into numeric fields.
+type NumberFormat struct {
+	// Character separating the integer part from the fractional part.
+	DecimalSeparator rune
+	// Characters that may be used to group digits in the integer part.
+	GroupSeparators string
+}
+
+// Creates a NumberFormat from the decimal separator and digit grouping
+// characters specified in a plugin config. An empty decimal separator means
+// ".".
+func NewNumberFormat(decimalSeparator, groupSeparators string) (*NumberFormat, error) {
+	nf := &NumberFormat{DecimalSeparator: '.', GroupSeparators: groupSeparators}
+	if decimalSeparator != "" {
+		if utf8.RuneCountInString(decimalSeparator) != 1 {
+			return nil, fmt.Errorf("decimal separator must be a single character: '%s'",
+				decimalSeparator)
+		}
+		nf.DecimalSeparator, _ = utf8.DecodeRuneInString(decimalSeparator)
+	}
+	if unicode.IsDigit(nf.DecimalSeparator) {
+		return nil, errors.New("decimal separator can't be a digit")
+	}
+	for _, r := range groupSeparators {
+		if r == nf.DecimalSeparator {
+			return nil, errors.New("group separators can't include the decimal separator")
+		}
+		if unicode.IsDigit(r) {
+			return nil, errors.New("group separators can't include digits")
+		}
+	}
+	return nf, nil
+}
+
+// Converts s to the form expected by strconv, dropping currency symbols and
+// digit grouping and replacing the decimal separator with ".".
+func (nf *NumberFormat) normalize(s string) (string, error) {
+	trim := func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Sc, r)
+	}
+	num := strings.TrimFunc(s, trim)
+	var sign string
+	if strings.HasPrefix(num, "-") || strings.HasPrefix(num, "+") {
+		sign = num[:1]
+		num = strings.TrimFunc(num[1:], trim)
+	}
+	if num == "" {
+		return "", fmt.Errorf("invalid number: '%s'", s)
+	}
+
+	buf := make([]byte, 0, len(num)+1)
+	buf = append(buf, sign...)
+	var (
+		lastDigit bool
+		seenPoint bool
+	)
+	for i, r := range num {
+		switch {
+		case r >= '0' && r <= '9':
+			buf = append(buf, byte(r))
+			lastDigit = true
+		case r == nf.DecimalSeparator && !seenPoint:
+			buf = append(buf, '.')
+			seenPoint = true
+			lastDigit = false
+		case !seenPoint && lastDigit && strings.ContainsRune(nf.GroupSeparators, r):
+			lastDigit = false
+		case (r == 'e' || r == 'E') && lastDigit:
+			// Exponents don't use locale specific characters.
+			buf = append(buf, num[i:]...)
+			return string(buf), nil
+		default:
+			return "", fmt.Errorf("invalid number: '%s'", s)
+		}
+	}
+	if !lastDigit && !seenPoint {
+		// Trailing group separator.
+		return "", fmt.Errorf("invalid number: '%s'", s)
+	}
+	return string(buf), nil
+}
+
+// Parses a locale formatted number as a float64.
+func (nf *NumberFormat) ParseFloat(s string) (float64, error) {
+	num, err := nf.normalize(s)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: '%s'", s)
+	}
+	return val, nil
+}
+
+// Parses a locale formatted number as an int64. Numbers with a fractional
+// part are rejected.
+func (nf *NumberFormat) ParseInt(s string) (int64, error) {
+	num, err := nf.normalize(s)
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid integer: '%s'", s)
+	}
+	return val, nil
+}
+
+// Replaces the string values of the named message fields with numeric
+// values, parsed as doubles or integers respectively. Fields that aren't
+// strings are left alone. The first value that can't be parsed aborts the
+// conversion and is returned as an error.
+func (nf *NumberFormat) ConvertFields(msg *message.Message, doubleFields,
+	intFields []string) error {
+
+	if len(doubleFields) == 0 && len(intFields) == 0 {
+		return nil
+	}
+	for i, field := range msg.Fields {
+		if field.GetValueType() != message.Field_STRING {
+			continue
+		}
+		name := field.GetName()
+		var valueType message.Field_ValueType
+		switch {
+		case hasName(doubleFields, name):
+			valueType = message.Field_DOUBLE
+		case hasName(intFields, name):
+			valueType = message.Field_INTEGER
+		default:
+			continue
+		}
+
+		converted := message.NewFieldInit(name, valueType, field.GetRepresentation())
+		for _, s := range field.GetValueString() {
+			var err error
+			if valueType == message.Field_DOUBLE {
+				var val float64
+				if val, err = nf.ParseFloat(s); err == nil {
+					err = converted.AddValue(val)
+				}
+			} else {
+				var val int64
+				if val, err = nf.ParseInt(s); err == nil {
+					err = converted.AddValue(val)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("field '%s': %s", name, err)
+			}
+		}
+		msg.Fields[i] = converted
+	}
+	return nil
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
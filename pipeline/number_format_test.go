@@ -0,0 +1,98 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func NumberFormatSpec(c gs.Context) {
+	c.Specify("A NumberFormat", func() {
+		c.Specify("defaults to a decimal point", func() {
+			nf, err := NewNumberFormat("", "")
+			c.Assume(err, gs.IsNil)
+			val, err := nf.ParseFloat("1234.5")
+			c.Expect(err, gs.IsNil)
+			c.Expect(val, gs.Equals, 1234.5)
+			_, err = nf.ParseFloat("1,234.5")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects invalid separators", func() {
+			_, err := NewNumberFormat(",,", "")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = NewNumberFormat(",", ".,")
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = NewNumberFormat(".", "0")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		nf, err := NewNumberFormat(",", ". ")
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("parses European formatted numbers", func() {
+			for s, expected := range map[string]float64{
+				"1.234,56":   1234.56,
+				"1 234 567":  1234567,
+				"-0,5":       -0.5,
+				"€ 1.234,50": 1234.5,
+				"12,99 €":    12.99,
+				"1,5e3":      1500,
+			} {
+				val, err := nf.ParseFloat(s)
+				c.Expect(err, gs.IsNil)
+				c.Expect(val, gs.Equals, expected)
+			}
+		})
+
+		c.Specify("rejects malformed numbers", func() {
+			for _, s := range []string{"", "€", "1,2,3", ".123", "1.", "12a"} {
+				_, err := nf.ParseFloat(s)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+
+		c.Specify("parses integers without a fractional part", func() {
+			val, err := nf.ParseInt("1.234.567")
+			c.Expect(err, gs.IsNil)
+			c.Expect(val, gs.Equals, int64(1234567))
+			_, err = nf.ParseInt("1,5")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("converts message fields", func() {
+			msg := new(message.Message)
+			message.NewStringField(msg, "Amount", "1.234,56")
+			message.NewStringField(msg, "Count", "2.000")
+			message.NewStringField(msg, "Name", "1.234")
+			message.NewInt64Field(msg, "Other", 3, "")
+
+			err := nf.ConvertFields(msg, []string{"Amount", "Other"}, []string{"Count"})
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(msg.Fields), gs.Equals, 4)
+			val, _ := msg.GetFieldValue("Amount")
+			c.Expect(val, gs.Equals, 1234.56)
+			val, _ = msg.GetFieldValue("Count")
+			c.Expect(val, gs.Equals, int64(2000))
+			val, _ = msg.GetFieldValue("Name")
+			c.Expect(val, gs.Equals, "1.234")
+			val, _ = msg.GetFieldValue("Other")
+			c.Expect(val, gs.Equals, int64(3))
+
+			msg = new(message.Message)
+			message.NewStringField(msg, "Amount", "n/a")
+			err = nf.ConvertFields(msg, []string{"Amount"}, nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,53 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import "sync/atomic"
+
+// Exit code of a one-shot run in which every input finished, but some
+// records failed to decode.
+const ONESHOT_DECODE_FAILURES = 2
+
+// Counters summarizing a one-shot run. They're accessed atomically.
+type oneShotStats struct {
+	inputFailures  int64
+	decodeFailures int64
+}
+
+// Initiates a clean shutdown once every input has finished, so the existing
+// shutdown sequence drains the decoders, filters and outputs.
+func (self *PipelineConfig) waitForInputs() {
+	self.inputsWg.Wait()
+	LogInfo.Println("All inputs finished, draining the pipeline.")
+	self.Globals.ShutDown(0)
+}
+
+// Logs the counts of a finished one-shot run and returns the exit code it
+// should end with: the provided one if it's already non-zero, 1 if an input
+// failed, ONESHOT_DECODE_FAILURES if records failed to decode, 0 otherwise.
+func (self *PipelineConfig) oneShotReport(exitCode int) int {
+	processed := atomic.LoadInt64(&self.router.processMessageCount)
+	inputFailures := atomic.LoadInt64(&self.oneShot.inputFailures)
+	decodeFailures := atomic.LoadInt64(&self.oneShot.decodeFailures)
+	LogInfo.Printf("One-shot run complete: %d messages processed, %d decode failures, "+
+		"%d failed inputs.", processed, decodeFailures, inputFailures)
+
+	switch {
+	case exitCode != 0:
+		return exitCode
+	case inputFailures > 0:
+		return 1
+	case decodeFailures > 0:
+		return ONESHOT_DECODE_FAILURES
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"syscall"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func OneShotSpec(c gs.Context) {
+	c.Specify("A one-shot run", func() {
+		globals := DefaultGlobals()
+		globals.OneShot = true
+		pConfig := NewPipelineConfig(globals)
+
+		c.Specify("shuts down once all inputs finished", func() {
+			pConfig.inputsWg.Add(1)
+			go pConfig.waitForInputs()
+			select {
+			case <-globals.sigChan:
+				c.Expect(true, gs.IsFalse)
+			case <-time.After(10 * time.Millisecond):
+			}
+			pConfig.inputsWg.Done()
+			select {
+			case sig := <-globals.sigChan:
+				c.Expect(sig, gs.Equals, syscall.SIGINT)
+				c.Expect(globals.exitCode, gs.Equals, 0)
+			case <-time.After(time.Second):
+				c.Expect(true, gs.IsFalse)
+			}
+		})
+
+		c.Specify("exits with 0 when nothing failed", func() {
+			pConfig.router.processMessageCount = 10
+			c.Expect(pConfig.oneShotReport(0), gs.Equals, 0)
+		})
+
+		c.Specify("exits with 1 when an input failed", func() {
+			pConfig.oneShot.inputFailures = 1
+			pConfig.oneShot.decodeFailures = 1
+			c.Expect(pConfig.oneShotReport(0), gs.Equals, 1)
+		})
+
+		c.Specify("signals decode failures", func() {
+			pConfig.oneShot.decodeFailures = 3
+			c.Expect(pConfig.oneShotReport(0), gs.Equals, ONESHOT_DECODE_FAILURES)
+		})
+
+		c.Specify("keeps the exit code of a failed shutdown", func() {
+			c.Expect(pConfig.oneShotReport(1), gs.Equals, 1)
+		})
+	})
+}
@@ -60,6 +60,45 @@ type GlobalConfigStruct struct {
 	abortChan             chan struct{}
 	FullBufferMaxRetries  uint
 	exitCode              int
+	// Workload profile the runtime settings were chosen by, if any.
+	Profile string
+	// GC target percentage applied at startup; 0 leaves the Go default (or
+	// the GOGC environment variable) in place, -1 disables collection.
+	GoGC int
+	// Maximum number of OS threads; 0 leaves the Go default in place.
+	MaxThreads int
+	// If true, Run doesn't register for OS signals, so the pipeline can only
+	// be stopped by calling ShutDown. Used when Heka is embedded in another
+	// program.
+	IgnoreSignals bool
+	// How often "heka.queue-stats" messages are generated; 0 disables them.
+	QueueStatsInterval time.Duration
+	// Address on which the queue stats are served for Prometheus, if any.
+	MetricsAddress string
+	// Name of the AccessControl resource protecting the metrics endpoint, if
+	// any. Scraping requires the "view" role.
+	MetricsAcl string
+	// Address on which the admin endpoints are served, if any.
+	AdminAddress string
+	// Name of the AccessControl resource granting access to the admin
+	// endpoints, required if AdminAddress is set.
+	AdminAcl string
+	// Maximum time inputs are held back for filters and outputs implementing
+	// WarmsUp to become ready; 0 starts the inputs right away.
+	WarmUpTimeout time.Duration
+	// Message field whose value messages are accounted to, or "signer" for
+	// the message signer; empty disables usage accounting.
+	AccountingKey string
+	// How often "heka.usage-report" messages are generated.
+	AccountingInterval time.Duration
+	// If true, SIGHUP also reloads the plugin config.
+	ReloadOnHup bool
+	// Path of the unix socket on which listeners are handed over to a new
+	// hekad during a binary upgrade; empty disables handing over.
+	UpgradeSocket string
+	// If true, finite inputs exit once they've read all of their data, and
+	// Heka shuts down once every input has exited.
+	OneShot bool
 }
 
 // Creates a GlobalConfigStruct object populated w/ default values.
@@ -319,6 +358,44 @@ func Run(config *PipelineConfig) (exitCode int) {
 	go injectTracker.Run()
 	config.router.Start()
 
+	queueStatsStop := make(chan struct{})
+	if globals.QueueStatsInterval > 0 {
+		go config.queueStatsLoop(globals.QueueStatsInterval, queueStatsStop)
+	}
+	if config.accountant != nil && globals.AccountingInterval > 0 {
+		go config.usageReportLoop(globals.AccountingInterval, queueStatsStop)
+	}
+	if globals.MetricsAddress != "" {
+		listener, err := config.serveQueueMetrics(globals.MetricsAddress,
+			globals.MetricsAcl)
+		if err != nil {
+			LogError.Printf("Can't serve queue metrics on '%s': %s",
+				globals.MetricsAddress, err)
+			globals.ShutDown(1)
+		} else {
+			LogInfo.Println("Serving queue metrics on", listener.Addr())
+			defer listener.Close()
+		}
+	}
+	if globals.AdminAddress != "" {
+		listener, err := config.serveAdmin(globals.AdminAddress, globals.AdminAcl)
+		if err != nil {
+			LogError.Printf("Can't serve admin endpoints on '%s': %s",
+				globals.AdminAddress, err)
+			globals.ShutDown(1)
+		} else {
+			LogInfo.Println("Serving admin endpoints on", listener.Addr())
+			defer listener.Close()
+		}
+	}
+
+	if globals.WarmUpTimeout > 0 {
+		if notReady := config.waitForWarmUp(globals.WarmUpTimeout); len(notReady) > 0 {
+			LogError.Printf("Starting inputs before plugins finished warming up: %s",
+				strings.Join(notReady, ", "))
+		}
+	}
+
 	for name, input := range config.InputRunners {
 		config.inputsWg.Add(1)
 		if err = input.Start(config, &config.inputsWg); err != nil {
@@ -331,10 +408,31 @@ func Run(config *PipelineConfig) (exitCode int) {
 		}
 		LogInfo.Println("Input started:", name)
 	}
+	if globals.OneShot {
+		go config.waitForInputs()
+	}
+
+	if globals.UpgradeSocket != "" {
+		listener, err := config.serveUpgradeSocket(globals.UpgradeSocket)
+		if err != nil {
+			LogError.Printf("Can't serve upgrade socket '%s': %s",
+				globals.UpgradeSocket, err)
+		} else {
+			LogInfo.Println("Serving upgrade socket", globals.UpgradeSocket)
+			defer func() {
+				// Close the listener first, which removes the socket file,
+				// so it's gone by the time the new hekad serves its own.
+				listener.Close()
+				config.closeUpgradeConn()
+			}()
+		}
+	}
 
 	// wait for sigint
-	signal.Notify(globals.sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
-		SIGUSR1, SIGUSR2)
+	if !globals.IgnoreSignals {
+		signal.Notify(globals.sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP,
+			SIGUSR1, SIGUSR2)
+	}
 
 	for !globals.IsShuttingDown() {
 		select {
@@ -345,6 +443,10 @@ func Run(config *PipelineConfig) (exitCode int) {
 				if err := notify.Post(RELOAD, nil); err != nil {
 					LogError.Println("Error sending reload event: ", err)
 				}
+				if globals.ReloadOnHup {
+					// Errors are logged by ReloadConfig.
+					go config.ReloadConfig()
+				}
 			case syscall.SIGINT, syscall.SIGTERM:
 				LogInfo.Println("Shutdown initiated.")
 				globals.stop()
@@ -357,6 +459,7 @@ func Run(config *PipelineConfig) (exitCode int) {
 			}
 		}
 	}
+	close(queueStatsStop)
 
 	config.inputsLock.Lock()
 	for _, input := range config.InputRunners {
@@ -402,7 +505,11 @@ func Run(config *PipelineConfig) (exitCode int) {
 			stopper.Stop()
 		}
 	}
+	config.stopResources()
 
+	if globals.OneShot {
+		globals.exitCode = config.oneShotReport(globals.exitCode)
+	}
 	LogInfo.Println("Shutdown complete.")
 	return globals.exitCode
 }
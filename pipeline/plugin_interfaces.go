@@ -54,7 +54,9 @@ type Decoder interface {
 	// returned as the first item in the `packs` return slice. If there is an
 	// error, `packs` should be returned as nil.
 	// Returning (nil, nil) is valid in cases where the decoding failed but
-	// the error should not be logged.
+	// the error should not be logged. A decoder that fails after having
+	// handed the pack to DecoderRunner.Deliver itself returns a
+	// PartialDecodeError.
 	Decode(pack *PipelinePack) (packs []*PipelinePack, err error)
 }
 
@@ -130,6 +130,10 @@ func (m *pluginMaker) OrigPrepCommonTypedConfig() (interface{}, error) {
 		}
 		err = toml.PrimitiveDecode(m.tomlSection, &commonFO)
 		commonTypedConfig = commonFO
+	case "Decoder":
+		commonDecoder := CommonDecoderConfig{}
+		err = toml.PrimitiveDecode(m.tomlSection, &commonDecoder)
+		commonTypedConfig = commonDecoder
 	case "Splitter":
 		commonSplitter := CommonSplitterConfig{}
 		err = toml.PrimitiveDecode(m.tomlSection, &commonSplitter)
@@ -459,6 +463,18 @@ func (m *pluginMaker) MakeRunner(name string) (PluginRunner, error) {
 		}
 	}
 
-	return NewFORunner(name, plugin, commonFO, m.commonConfig.Typ,
+	fr, err := NewFORunner(name, plugin, commonFO, m.commonConfig.Typ,
 		m.pConfig.Globals.PluginChanSize)
+	if err != nil {
+		return nil, err
+	}
+	if commonFO.LeaderElection != "" {
+		if fr.leadership, err = m.pConfig.LeaderElection(
+			commonFO.LeaderElection); err != nil {
+
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+	runner = fr
+	return runner, nil
 }
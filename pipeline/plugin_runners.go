@@ -25,6 +25,7 @@ import (
 
 	"github.com/mozilla-services/heka/client"
 	"github.com/mozilla-services/heka/message"
+	"github.com/pborman/uuid"
 )
 
 var ErrUnknownPluginType = errors.New("Unable to assert this is an Output or Filter")
@@ -190,7 +191,9 @@ type InputRunner interface {
 	// and then placing it on the router's input channel, if a decoder is
 	// specified and syncDecode is true; or 3) placing the pack directly on
 	// the router's input channel, if no decoder is specified. Delegates to
-	// DeliverTo.
+	// DeliverTo. With syncDecode, concurrent calls are serialized since they
+	// share one decoder; inputs decoding in several goroutines should give
+	// each one its own Deliverer.
 	Deliver(pack *PipelinePack)
 	NewSplitterRunner(token string) SplitterRunner
 	// Tells if synchrounous decode is enabled
@@ -199,21 +202,22 @@ type InputRunner interface {
 
 type iRunner struct {
 	pRunnerBase
-	input              Input
-	config             CommonInputConfig
-	pConfig            *PipelineConfig
-	inChan             chan *PipelinePack
-	ticker             <-chan time.Time
-	transient          bool
-	syncDecode         bool
-	sendDecodeFailures bool
-	logDecodeFailures  bool
-	deliver            DeliverFunc
-	delivererOnce      sync.Once
-	delivererLock      sync.Mutex
-	canExit            bool
-	shutdownWanters    []WantsDecoderRunnerShutdown
-	shutdownLock       sync.Mutex
+	input             Input
+	config            CommonInputConfig
+	pConfig           *PipelineConfig
+	inChan            chan *PipelinePack
+	ticker            <-chan time.Time
+	transient         bool
+	syncDecode        bool
+	logDecodeFailures bool
+	failureAction     string
+	deliver           DeliverFunc
+	delivererOnce     sync.Once
+	delivererLock     sync.Mutex
+	syncDecodeLock    sync.Mutex
+	canExit           bool
+	shutdownWanters   []WantsDecoderRunnerShutdown
+	shutdownLock      sync.Mutex
 }
 
 func (ir *iRunner) Ticker() (ticker <-chan time.Time) {
@@ -248,8 +252,12 @@ func NewInputRunner(name string, input Input, config CommonInputConfig) (ir Inpu
 	if config.SyncDecode != nil {
 		runner.syncDecode = *config.SyncDecode
 	}
-	if config.SendDecodeFailures != nil {
-		runner.sendDecodeFailures = *config.SendDecodeFailures
+	runner.failureAction = config.DecodeFailureAction
+	if runner.failureAction == "" {
+		runner.failureAction = DecodeFailureDrop
+		if config.SendDecodeFailures != nil && *config.SendDecodeFailures {
+			runner.failureAction = DecodeFailurePass
+		}
 	}
 	if config.LogDecodeFailures != nil {
 		runner.logDecodeFailures = *config.LogDecodeFailures
@@ -301,6 +309,12 @@ func (ir *iRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error) {
 		if !ok {
 			return fmt.Errorf("no registered '%s' decoder", ir.config.Decoder)
 		}
+		if err = ir.checkDecodeFailureConfig(); err != nil {
+			return fmt.Errorf("%s: %s", ir.name, err)
+		}
+		if err = ir.pConfig.checkMaxDecoders(ir.config, ir.syncDecode); err != nil {
+			return fmt.Errorf("%s: %s", ir.name, err)
+		}
 	}
 	go ir.Starter(h, wg)
 	return
@@ -319,10 +333,12 @@ func (ir *iRunner) Starter(h PluginHelper, wg *sync.WaitGroup) {
 		return
 	}
 
+	var failed bool
 	for !globals.IsShuttingDown() {
 
 		// ir.Input().Run() shouldn't return unless error or shutdown.
 		err := ir.input.Run(ir, h)
+		failed = err != nil
 		registered, ok := ir.pConfig.InputRunners[ir.name]
 
 		if !ok || registered != ir || globals.IsShuttingDown() {
@@ -384,6 +400,15 @@ func (ir *iRunner) Starter(h PluginHelper, wg *sync.WaitGroup) {
 
 	ir.Unregister(ir.pConfig)
 
+	// In one-shot mode inputs are expected to finish, Heka shuts down once
+	// they all have.
+	if globals.OneShot {
+		if failed {
+			atomic.AddInt64(&ir.pConfig.oneShot.inputFailures, 1)
+		}
+		return
+	}
+
 	// If we're not a stoppable input, trigger Heka shutdown.
 	if !ir.IsStoppable() {
 		globals.ShutDown(1)
@@ -450,7 +475,28 @@ func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Dec
 	// its inChan.
 	if !ir.syncDecode {
 		dr, _ := ir.pConfig.DecoderRunner(decoderName, fullName)
-		dr.SetFailureHandling(ir.logDecodeFailures, ir.sendDecodeFailures)
+		dr.SetFailureHandling(ir.logDecodeFailures,
+			ir.failureAction != DecodeFailureDrop)
+		if runner, ok := dr.(*dRunner); ok {
+			failures := ir.newDecodeFailureHandler(fullName)
+			failures.logError = runner.LogError
+			runner.setDecodeFailureHandler(failures)
+			if ir.config.MaxDecoders > 1 {
+				// Each extra decoder gets a failure handler of its own, so
+				// they don't share a failure decoder.
+				runner.setMaxDecoders(int(ir.config.MaxDecoders),
+					func() (Decoder, *decodeFailureHandler, bool) {
+						decoder, ok := ir.pConfig.Decoder(decoderName)
+						if !ok {
+							return nil, nil, false
+						}
+						extraFailures := ir.newDecodeFailureHandler(fullName)
+						extraFailures.logError = runner.LogError
+						extraFailures.count = failures.count
+						return decoder, extraFailures, true
+					})
+			}
+		}
 		inChan := dr.InChan()
 		deliver = func(pack *PipelinePack) {
 			inChan <- pack
@@ -465,18 +511,26 @@ func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Dec
 		dr := NewDecoderRunner(fullName, decoder, 0).(*dRunner)
 		dr.h = ir.h
 		dr.router = ir.pConfig.router
+		dr.globals = ir.pConfig.Globals
 		wanter.SetDecoderRunner(dr)
 	}
+	failures := ir.newDecodeFailureHandler(fullName)
 	if wanter, ok := decoder.(WantsDecoderRunnerShutdown); ok {
 		ir.shutdownLock.Lock()
 		ir.shutdownWanters = append(ir.shutdownWanters, wanter)
 		ir.shutdownLock.Unlock()
 	}
+	if failures.decoder != nil {
+		ir.shutdownLock.Lock()
+		ir.shutdownWanters = append(ir.shutdownWanters, failures)
+		ir.shutdownLock.Unlock()
+	}
 
 	ir.pConfig.allSyncDecodersLock.Lock()
 	ir.pConfig.allSyncDecoders = append(ir.pConfig.allSyncDecoders, ReportingDecoder{
-		name:    fullName,
-		decoder: decoder,
+		name:     fullName,
+		decoder:  decoder,
+		failures: failures,
 	})
 	ir.pConfig.allSyncDecodersLock.Unlock()
 	// See if the decoder sets TrustMsgBytes for us.
@@ -484,20 +538,24 @@ func (ir *iRunner) getDeliverFunc(token string) (DeliverFunc, DecoderRunner, Dec
 	deliver = func(pack *PipelinePack) {
 		packs, err := decoder.Decode(pack)
 		if err != nil {
+			atomic.AddInt64(&ir.pConfig.oneShot.decodeFailures, 1)
 			errMsg := err.Error()
 			e := fmt.Errorf("decoding: %s", errMsg)
 			if ir.logDecodeFailures {
 				ir.LogError(e)
 			}
-			if !ir.sendDecodeFailures {
-				pack.recycle()
+			if _, ok := err.(PartialDecodeError); ok {
+				failures.handlePartial()
 				return
 			}
-			if err = AddDecodeFailureFields(pack.Message, errMsg); err != nil {
-				ir.LogError(err)
-			}
-			pack.TrustMsgBytes = false
-			ir.Inject(pack)
+			failures.handle(pack, errMsg, func(pack *PipelinePack) {
+				ir.Inject(pack)
+			})
+			return
+		}
+		if packs == nil {
+			// Nothing to deliver, e.g. the decoder is holding the data back.
+			pack.recycle()
 			return
 		}
 		for _, p := range packs {
@@ -531,6 +589,15 @@ func (ir *iRunner) Deliver(pack *PipelinePack) {
 		})
 		ir.delivererLock.Unlock()
 	}
+	if ir.syncDecode {
+		// Inputs calling Deliver from several goroutines share one decoder,
+		// which mustn't decode more than one pack at a time. Goroutines that
+		// each want their own decoder should use NewDeliverer instead.
+		ir.syncDecodeLock.Lock()
+		ir.deliver(pack)
+		ir.syncDecodeLock.Unlock()
+		return
+	}
 	ir.deliver(pack)
 }
 
@@ -576,11 +643,23 @@ type DecoderRunner interface {
 	Router() MessageRouter
 	// Fetches a new pack from the input supply and returns it to the caller,
 	// for decoders that generate multiple messages from a single input
-	// message.
+	// message. Returns nil if Heka is shutting down.
 	NewPack() *PipelinePack
+	// Fetches a new pack like NewPack, for another message decoded from the
+	// provided pack's record. The new message gets a fresh UUID and the
+	// provided message's timestamp, type, logger, severity, hostname, pid
+	// and env version, but no payload or fields.
+	NewPackFrom(pack *PipelinePack) *PipelinePack
+	// Hands a pack obtained from NewPack or NewPackFrom to the router right
+	// away. Every pack a decoder holds on to is one fewer in the input
+	// supply, so decoders that may generate more messages from one record
+	// than the supply holds (see `poolsize`) must deliver them as they go
+	// rather than return them all from Decode, which would block forever.
+	Deliver(pack *PipelinePack)
 	// SetFailureHandling allows the InputRunner to specify whether or not
 	// messages that fail decoding should still be tagged and given to the
-	// router.
+	// router. Inputs set up with a `decode_failure_action` of "route"
+	// additionally hand them to their `decode_failure_target`.
 	SetFailureHandling(printFailure, sendFailure bool)
 }
 
@@ -588,12 +667,27 @@ type dRunner struct {
 	pRunnerBase
 	decoder      Decoder
 	inChan       chan *PipelinePack
+	swapChan     chan *decoderSwap
+	stopped      chan struct{}
+	started      int32
+	decoderLock  sync.RWMutex
+	baseName     string
 	router       *messageRouter
 	h            PluginHelper
 	printFailure bool
-	sendFailure  bool
+	failures     *decodeFailureHandler
 	encodes      bool
 	globals      *GlobalConfigStruct
+	// Decoder autoscaling, see decoder_scaling.go.
+	maxDecoders  int
+	newDecoder   func() (Decoder, *decodeFailureHandler, bool)
+	decoderCount int32
+	busyChecks   int
+	idleChecks   int
+	scaleChan    chan struct{}
+	dropExtra    chan struct{}
+	stopExtra    chan struct{}
+	extraWg      sync.WaitGroup
 }
 
 // Creates and returns a new (but not yet started) DecoderRunner for the
@@ -604,18 +698,42 @@ func NewDecoderRunner(name string, decoder Decoder, chanSize int) DecoderRunner
 			name:   name,
 			plugin: decoder.(Plugin),
 		},
-		decoder: decoder,
-		inChan:  make(chan *PipelinePack, chanSize),
+		decoder:      decoder,
+		inChan:       make(chan *PipelinePack, chanSize),
+		swapChan:     make(chan *decoderSwap),
+		stopped:      make(chan struct{}),
+		decoderCount: 1,
+		scaleChan:    make(chan struct{}),
+		dropExtra:    make(chan struct{}, 1),
+		stopExtra:    make(chan struct{}),
+	}
+	dr.failures = &decodeFailureHandler{
+		action:   DecodeFailureDrop,
+		count:    new(int64),
+		logError: dr.LogError,
 	}
 	_, dr.encodes = decoder.(EncodesMsgBytes)
 	return dr
 }
 
 func (dr *dRunner) Decoder() Decoder {
+	dr.decoderLock.RLock()
+	defer dr.decoderLock.RUnlock()
 	return dr.decoder
 }
 
+func (dr *dRunner) Plugin() Plugin {
+	dr.decoderLock.RLock()
+	defer dr.decoderLock.RUnlock()
+	return dr.plugin
+}
+
 func (dr *dRunner) Start(h PluginHelper, wg *sync.WaitGroup) {
+	if !atomic.CompareAndSwapInt32(&dr.started, 0, 1) {
+		dr.LogError(errors.New("already started"))
+		wg.Done()
+		return
+	}
 	dr.h = h
 	pConfig := h.PipelineConfig()
 	dr.router = pConfig.router
@@ -627,39 +745,84 @@ func (dr *dRunner) Start(h PluginHelper, wg *sync.WaitGroup) {
 }
 
 func (dr *dRunner) start(h PluginHelper, wg *sync.WaitGroup) {
-	var (
-		pack  *PipelinePack
-		packs []*PipelinePack
-		err   error
-	)
-	for pack = range dr.inChan {
-		if packs, err = dr.decoder.Decode(pack); packs != nil {
-			for _, p := range packs {
-				dr.deliver(p)
-			}
-		} else {
-			if err != nil {
-				if dr.printFailure {
-					dr.LogError(err)
-				}
-				if dr.sendFailure {
-					if err = AddDecodeFailureFields(pack.Message, err.Error()); err != nil {
-						dr.LogError(err)
-					}
-					pack.TrustMsgBytes = false
-					dr.deliver(pack)
-					continue
+	defer close(dr.stopped)
+	for {
+		select {
+		case pack, ok := <-dr.inChan:
+			if !ok {
+				dr.stopExtraDecoders()
+				if wanter, ok := dr.decoder.(WantsDecoderRunnerShutdown); ok {
+					wanter.Shutdown()
 				}
+				dr.failures.Shutdown()
+				dr.LogMessage("stopped")
+				wg.Done()
+				return
 			}
-			pack.recycle()
-			continue
+			dr.decode(pack)
+		case swap := <-dr.swapChan:
+			// Extra decoders are started again with the new decoder if the
+			// backlog persists.
+			dr.stopExtraDecoders()
+			dr.swap(swap)
+		case <-dr.scaleChan:
+			dr.scale()
 		}
 	}
-	if wanter, ok := dr.decoder.(WantsDecoderRunnerShutdown); ok {
+}
+
+func (dr *dRunner) decode(pack *PipelinePack) {
+	dr.decodeWith(dr.decoder, dr.failures, pack)
+}
+
+func (dr *dRunner) decodeWith(decoder Decoder, failures *decodeFailureHandler,
+	pack *PipelinePack) {
+
+	packs, err := decoder.Decode(pack)
+	if packs != nil {
+		for _, p := range packs {
+			dr.deliver(p)
+		}
+		return
+	}
+	if err != nil {
+		if dr.h != nil {
+			atomic.AddInt64(&dr.h.PipelineConfig().oneShot.decodeFailures, 1)
+		}
+		if dr.printFailure {
+			dr.LogError(err)
+		}
+		if _, ok := err.(PartialDecodeError); ok {
+			failures.handlePartial()
+			return
+		}
+		failures.handle(pack, err.Error(), dr.deliver)
+		return
+	}
+	pack.recycle()
+}
+
+// Decodes the packs already queued with the current decoder, then replaces
+// it with the swap's decoder and shuts the old one down.
+func (dr *dRunner) swap(swap *decoderSwap) {
+	for i := len(dr.inChan); i > 0; i-- {
+		if pack, ok := <-dr.inChan; ok {
+			dr.decode(pack)
+		}
+	}
+	old := dr.decoder
+	dr.decoderLock.Lock()
+	dr.decoder = swap.decoder
+	dr.plugin = swap.decoder.(Plugin)
+	dr.decoderLock.Unlock()
+	_, dr.encodes = swap.decoder.(EncodesMsgBytes)
+	if wanter, ok := swap.decoder.(WantsDecoderRunner); ok {
+		wanter.SetDecoderRunner(dr)
+	}
+	if wanter, ok := old.(WantsDecoderRunnerShutdown); ok {
 		wanter.Shutdown()
 	}
-	dr.LogMessage("stopped")
-	wg.Done()
+	close(swap.done)
 }
 
 func (dr *dRunner) deliver(pack *PipelinePack) {
@@ -692,6 +855,27 @@ func (dr *dRunner) NewPack() *PipelinePack {
 	return pack // Might be nil if we're aborting.
 }
 
+func (dr *dRunner) NewPackFrom(pack *PipelinePack) *PipelinePack {
+	newPack := dr.NewPack()
+	if newPack == nil {
+		return nil
+	}
+	src, dst := pack.Message, newPack.Message
+	dst.SetUuid(uuid.NewRandom())
+	dst.SetTimestamp(src.GetTimestamp())
+	dst.SetType(src.GetType())
+	dst.SetLogger(src.GetLogger())
+	dst.SetSeverity(src.GetSeverity())
+	dst.SetHostname(src.GetHostname())
+	dst.SetPid(src.GetPid())
+	dst.SetEnvVersion(src.GetEnvVersion())
+	return newPack
+}
+
+func (dr *dRunner) Deliver(pack *PipelinePack) {
+	dr.deliver(pack)
+}
+
 func (dr *dRunner) LogError(err error) {
 	LogError.Printf("Decoder '%s' error: %s", dr.name, err)
 }
@@ -702,7 +886,21 @@ func (dr *dRunner) LogMessage(msg string) {
 
 func (dr *dRunner) SetFailureHandling(printFailure, sendFailure bool) {
 	dr.printFailure = printFailure
-	dr.sendFailure = sendFailure
+	dr.failures.action = DecodeFailureDrop
+	if sendFailure {
+		dr.failures.action = DecodeFailurePass
+	}
+}
+
+// Replaces the handler of the packs the decoder fails to decode. Must be
+// called before any packs are delivered to the runner.
+func (dr *dRunner) setDecodeFailureHandler(failures *decodeFailureHandler) {
+	dr.failures = failures
+}
+
+// Returns the number of packs the decoder failed to decode.
+func (dr *dRunner) DecodeFailureCount() int64 {
+	return dr.failures.failureCount()
 }
 
 // Any decoder that needs access to its DecoderRunner can implement this
@@ -794,8 +992,15 @@ type OutputRunner interface {
 	Encoder() Encoder
 	// Uses the output's Encoder to encode the message attached to the
 	// provided PipelinePack. Will prepend a Heka stream framing header if
-	// use_framing was set to true in the output configuration.
+	// use_framing was set to true in the output configuration. Encoded
+	// records over the output's max_record_size are truncated or dropped
+	// with a data error, depending on its oversize_policy.
 	Encode(pack *PipelinePack) (output []byte, err error)
+	// Returns whether a record of recordSize bytes can be added to a request
+	// of requestSize bytes without going over the output's
+	// max_request_size. Outputs that batch records should send the request
+	// before adding the record when it doesn't fit.
+	FitsRequest(requestSize, recordSize int) bool
 	// Returns whether or not use_framing was set to true in the output's
 	// configuration, i.e. whether or not Heka stream framing will be applied
 	// to the results of calls to the Encode method.
@@ -837,12 +1042,14 @@ func (kind foRunnerKind) String() string {
 type foRunner struct {
 	processMessageCount int64
 	dropMessageCount    int64
+	errorCounts         [numErrorCategories]int64
 	capacity            int
 	pRunnerBase
 	pluginType   string
 	config       CommonFOConfig
 	matcher      *MatchRunner
 	ticker       <-chan time.Time
+	cron         *CronSchedule
 	inChan       chan *PipelinePack
 	backChan     chan *PipelinePack
 	h            PluginHelper
@@ -857,6 +1064,8 @@ type foRunner struct {
 	lastErr      error
 	bufReader    *BufferReader
 	stopChan     chan bool
+	leadership   *LeaderElection
+	sizeGuard    *sizeGuard // output only
 }
 
 const pluginPoolSize = 2
@@ -879,7 +1088,15 @@ func NewFORunner(name string, plugin Plugin, config CommonFOConfig,
 		return nil, fmt.Errorf("'%s' missing message matcher", name)
 	}
 
-	if config.Ticker != 0 {
+	if config.TickerCron != "" {
+		cron, err := ParseCronSchedule(config.TickerCron)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' invalid ticker_cron: %s", name, err)
+		}
+		runner.cron = cron
+	}
+
+	if config.Ticker != 0 || runner.cron != nil {
 		canTick := false
 		// Check to make sure we know what to do with the ticker interval.
 		if _, ok := plugin.(OldFilter); ok {
@@ -890,7 +1107,8 @@ func NewFORunner(name string, plugin Plugin, config CommonFOConfig,
 			canTick = true
 		}
 		if !canTick {
-			return nil, fmt.Errorf("'%s' can't support a ticker_interval setting", name)
+			return nil, fmt.Errorf("'%s' can't support a ticker_interval or ticker_cron setting",
+				name)
 		}
 	}
 
@@ -953,6 +1171,38 @@ func NewFORunner(name string, plugin Plugin, config CommonFOConfig,
 		return nil, err
 	}
 
+	if len(config.Sampling) > 0 {
+		if runner.kind != foOutput {
+			return nil, fmt.Errorf("'%s' can't use sampling rules, only outputs can", name)
+		}
+		if matcher.sampler, err = newOutputSampler(config.Sampling); err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+
+	if config.DebugBufferSize > 0 {
+		matcher.debugBuf = newDebugBuffer(config.DebugBufferSize)
+	}
+
+	if config.MaxRecordSize > 0 || config.MaxRequestSize > 0 || config.OversizePolicy != "" {
+		if runner.kind != foOutput {
+			return nil, fmt.Errorf("'%s' can't use size limits, only outputs can", name)
+		}
+		if runner.sizeGuard, err = newSizeGuard(config); err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+
+	if config.Canary != nil {
+		if runner.kind != foFilter {
+			return nil, fmt.Errorf("'%s' can't be rolled out as a canary, only filters can",
+				name)
+		}
+		if err = config.Canary.validate(); err != nil {
+			return nil, fmt.Errorf("'%s': %s", name, err)
+		}
+	}
+
 	return runner, nil
 }
 
@@ -1013,10 +1263,15 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 		}
 	}
 
-	if foRunner.config.Ticker != 0 {
+	if foRunner.cron != nil {
+		foRunner.ticker = cronTick(foRunner.cron)
+	} else if foRunner.config.Ticker != 0 {
 		tickLength := time.Duration(foRunner.config.Ticker) * time.Second
 		foRunner.ticker = time.Tick(tickLength)
 	}
+	if foRunner.ticker != nil && foRunner.leadership != nil {
+		foRunner.ticker = foRunner.leadership.gateTicker(foRunner.ticker)
+	}
 
 	if foRunner.config.Encoder != "" {
 		fullName := fmt.Sprintf("%s-%s", foRunner.name, foRunner.config.Encoder)
@@ -1043,11 +1298,23 @@ func (foRunner *foRunner) Start(h PluginHelper, wg *sync.WaitGroup) (err error)
 		foRunner.matcher.bufFeeder = bufFeeder
 		foRunner.matcher.globals = foRunner.pConfig.Globals
 		foRunner.matcher.stopChan = foRunner.stopChan
+		foRunner.matcher.leadership = foRunner.leadership
 		switch foRunner.kind {
 		case foFilter:
 			foRunner.pConfig.router.fMatcherMap[foRunner.name] = foRunner.matcher
 		case foOutput:
 			foRunner.pConfig.router.oMatcherMap[foRunner.name] = foRunner.matcher
+			foRunner.matcher.accountant = foRunner.pConfig.accountant
+		}
+		if sampler := foRunner.matcher.sampler; sampler != nil {
+			var interval uint = 60
+			if foRunner.config.SamplingSummaryInterval != nil {
+				interval = *foRunner.config.SamplingSummaryInterval
+			}
+			if interval > 0 {
+				go foRunner.samplingSummaryLoop(sampler,
+					time.Duration(interval)*time.Second, foRunner.stopChan)
+			}
 		}
 	}
 
@@ -1126,18 +1393,17 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 					pack.recycle()
 					break RetryLoop // Bumps us back to the outer loop.
 				}
-				switch err.(type) {
-				case PluginExitError:
+				switch foRunner.countError(err) {
+				case ErrorFatal, ErrorConfig:
 					pack.recycle()
 					return err
-				case RetryMessageError:
+				case ErrorTransient:
 					foRunner.LogError(err)
 					rh.Wait()
 					resetNeeded = true
 					continue // Try the same one again.
 				default:
-					foRunner.LogError(err)
-					pack.recycle()
+					foRunner.dropMessage(pack, err)
 					break RetryLoop
 				}
 			}
@@ -1148,11 +1414,13 @@ func (foRunner *foRunner) channelLoop(plugin MessageProcessor, h PluginHelper,
 			}
 			err := tickReceiver.TimerEvent()
 			if err != nil {
-				err = fmt.Errorf("Error running TimerEvent for %s: %s",
-					foRunner.name, err.Error())
-				if _, isFatal := err.(PluginExitError); isFatal {
+				err = wrapError(err, fmt.Sprintf("Error running TimerEvent for %s",
+					foRunner.name))
+				switch foRunner.countError(err) {
+				case ErrorFatal, ErrorConfig:
 					return err
 				}
+				foRunner.LogError(err)
 			}
 		}
 	}
@@ -1257,6 +1525,7 @@ func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
 			// Keep track of all the errors for later.
 			foRunner.lastErr = err
 			foRunner.LogError(err)
+			foRunner.markDebugError(err)
 		}
 
 		foRunner.LogMessage("stopped")
@@ -1266,6 +1535,11 @@ func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
 			break
 		}
 
+		// Restarting with the same config would fail the same way.
+		if err != nil && ErrorCategoryOf(err) == ErrorConfig {
+			break
+		}
+
 		// We stop and let this quit if its not a restarting plugin.
 		recon, ok := foRunner.plugin.(Restarting)
 		if !ok {
@@ -1321,6 +1595,57 @@ func (foRunner *foRunner) Starter(plugin MessageProcessor, h PluginHelper,
 	}
 }
 
+// Bumps the counter for the error's category and returns the category.
+func (foRunner *foRunner) countError(err error) ErrorCategory {
+	category := ErrorCategoryOf(err)
+	if category < 0 || category >= numErrorCategories {
+		category = ErrorData
+	}
+	atomic.AddInt64(&foRunner.errorCounts[category], 1)
+	if category != ErrorTransient {
+		foRunner.markDebugError(err)
+	}
+	return category
+}
+
+// Drops a pack the plugin failed to process because of a data error. With
+// `dead_letter` set, a copy of the message is injected as a dead letter
+// message first, so it can be routed to an output of its own.
+func (foRunner *foRunner) dropMessage(pack *PipelinePack, err error) {
+	atomic.AddInt64(&foRunner.dropMessageCount, 1)
+	if !foRunner.config.DeadLetter {
+		foRunner.LogError(fmt.Errorf("dropping message: %s", err))
+		pack.recycle()
+		return
+	}
+	foRunner.LogError(fmt.Errorf("dead lettering message: %s", err))
+	dead, e := foRunner.pConfig.PipelinePack(pack.MsgLoopCount)
+	if e != nil {
+		foRunner.LogError(fmt.Errorf("can't dead letter message: %s", e))
+		pack.recycle()
+		return
+	}
+	pack.Message.Copy(dead.Message)
+	pack.recycle()
+	message.NewStringField(dead.Message, "DeadLetterType", dead.Message.GetType())
+	message.NewStringField(dead.Message, "DeadLetterPlugin", foRunner.name)
+	message.NewStringField(dead.Message, "DeadLetterError", err.Error())
+	dead.Message.SetType(DeadLetterType)
+	foRunner.Inject(dead)
+}
+
+// Freezes the debug buffer's contents, if there is one, for the failure.
+func (foRunner *foRunner) markDebugError(err error) {
+	if foRunner.matcher != nil && foRunner.matcher.debugBuf != nil {
+		foRunner.matcher.debugBuf.markError(err)
+	}
+}
+
+// Returns the number of errors of the given category the plugin returned.
+func (foRunner *foRunner) ErrorCount(category ErrorCategory) int64 {
+	return atomic.LoadInt64(&foRunner.errorCounts[category])
+}
+
 func (foRunner *foRunner) IsStoppable() bool {
 	return foRunner.canExit
 }
@@ -1457,9 +1782,9 @@ func (foRunner *foRunner) runBoth(h PluginHelper) error {
 		err = fmt.Errorf("plugin error: %s\nbuffer reader error: %s", pluginErr,
 			bufErr)
 	} else if pluginErr != nil {
-		err = fmt.Errorf("plugin error: %s", pluginErr)
+		err = wrapError(pluginErr, "plugin error")
 	} else if bufErr != nil {
-		err = fmt.Errorf("buffer reader error: %s", bufErr)
+		err = wrapError(bufErr, "buffer reader error")
 	}
 	return err
 }
@@ -1509,6 +1834,7 @@ func (foRunner *foRunner) OldStarter(helper PluginHelper, wg *sync.WaitGroup) {
 			// Keep track of all the errors for later
 			foRunner.lastErr = err
 			foRunner.LogError(err)
+			foRunner.markDebugError(err)
 		}
 
 		foRunner.LogMessage("stopped")
@@ -1518,6 +1844,11 @@ func (foRunner *foRunner) OldStarter(helper PluginHelper, wg *sync.WaitGroup) {
 			break
 		}
 
+		// Restarting with the same config would fail the same way.
+		if err != nil && ErrorCategoryOf(err) == ErrorConfig {
+			break
+		}
+
 		// We stop and let this quit if its not a restarting plugin.
 		recon, ok := foRunner.plugin.(Restarting)
 		if !ok {
@@ -1561,7 +1892,9 @@ func (foRunner *foRunner) OldStarter(helper PluginHelper, wg *sync.WaitGroup) {
 }
 
 // Message sending function for buffered plugins using the old-style API.
-func (foRunner foRunner) SendRecord(pack *PipelinePack) error {
+// Transient errors are returned so the record is retried, as are config and
+// fatal errors, which make the buffer reader exit.
+func (foRunner *foRunner) SendRecord(pack *PipelinePack) error {
 	select {
 	case foRunner.inChan <- pack:
 		// Wait until pack is delivered.
@@ -1570,13 +1903,16 @@ func (foRunner foRunner) SendRecord(pack *PipelinePack) error {
 			if err == nil {
 				atomic.AddInt64(&foRunner.processMessageCount, 1)
 				pack.recycle()
-			} else {
-				if _, ok := err.(RetryMessageError); !ok {
-					foRunner.LogError(fmt.Errorf("can't send record: %s", err))
-					atomic.AddInt64(&foRunner.dropMessageCount, 1)
-					pack.recycle()
-					err = nil // Swallow the error so there's no retry.
-				}
+				return nil
+			}
+			switch foRunner.countError(err) {
+			case ErrorTransient:
+			case ErrorFatal, ErrorConfig:
+				atomic.AddInt64(&foRunner.dropMessageCount, 1)
+				pack.recycle()
+			default:
+				foRunner.dropMessage(pack, err)
+				err = nil // Swallow the error so there's no retry.
 			}
 			return err
 		case <-foRunner.stopChan:
@@ -1696,6 +2032,11 @@ func (foRunner *foRunner) Encode(pack *PipelinePack) (output []byte, err error)
 	if encoded, err = foRunner.encoder.Encode(pack); err != nil || encoded == nil {
 		return
 	}
+	if foRunner.sizeGuard != nil {
+		if encoded, err = foRunner.sizeGuard.checkRecord(encoded); err != nil {
+			return
+		}
+	}
 	if foRunner.useFraming {
 		client.CreateHekaStream(encoded, &output, nil)
 	} else {
@@ -1704,6 +2045,13 @@ func (foRunner *foRunner) Encode(pack *PipelinePack) (output []byte, err error)
 	return
 }
 
+func (foRunner *foRunner) FitsRequest(requestSize, recordSize int) bool {
+	if foRunner.sizeGuard == nil {
+		return true
+	}
+	return foRunner.sizeGuard.fitsRequest(requestSize, recordSize)
+}
+
 func (foRunner *foRunner) UsesFraming() bool {
 	return foRunner.useFraming
 }
@@ -1731,6 +2079,10 @@ func (err PluginExitError) Error() string {
 	return err.msg
 }
 
+func (err PluginExitError) Category() ErrorCategory {
+	return ErrorFatal
+}
+
 type RetryMessageError struct {
 	msg string
 }
@@ -1745,3 +2097,7 @@ func NewRetryMessageError(msg string, subs ...interface{}) RetryMessageError {
 func (err RetryMessageError) Error() string {
 	return err.msg
 }
+
+func (err RetryMessageError) Category() ErrorCategory {
+	return ErrorTransient
+}
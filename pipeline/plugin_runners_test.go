@@ -79,6 +79,19 @@ func InputRunnerSpec(c gs.Context) {
 	}
 	pConfig.DecoderMakers["FooDecoder"] = decoderMaker
 
+	failureMaker := &pluginMaker{
+		name:     "FailureDecoder",
+		category: "Decoder",
+		pConfig:  pConfig,
+	}
+	failureMaker.constructor = func() interface{} {
+		return &_typeDecoder{msgType: "undecoded"}
+	}
+	failureMaker.prepConfig = func() (interface{}, error) {
+		return make(map[string]interface{}), nil
+	}
+	pConfig.DecoderMakers["FailureDecoder"] = failureMaker
+
 	c.Specify("An InputRunner", func() {
 
 		var commonInput CommonInputConfig
@@ -274,9 +287,70 @@ func InputRunnerSpec(c gs.Context) {
 					wg.Wait()
 				})
 
+				c.Specify("and routes failures to the failure decoder", func() {
+					decoder.fail = true
+					runner.failureAction = DecodeFailureRoute
+					runner.config.DecodeFailureTarget = "FailureDecoder"
+					c.Expect(runner.checkDecodeFailureConfig(), gs.IsNil)
+					d = runner.NewDeliverer("").(*deliverer)
+					runner.deliver = d.deliver
+					runner.Deliver(pack)
+					recd := <-pConfig.router.inChan
+					c.Expect(recd, gs.Equals, pack)
+					c.Expect(pack.Message.GetType(), gs.Equals, "undecoded")
+					f := pack.Message.FindFirstField("decode_error")
+					c.Expect(f, gs.Not(gs.IsNil))
+					c.Expect(f.GetValue().(string), gs.Equals, "DECODE ERROR")
+					reporting := pConfig.allSyncDecoders[len(pConfig.allSyncDecoders)-1]
+					c.Expect(reporting.failures.failureCount(), gs.Equals, int64(1))
+					pack.Recycle(nil)
+					input.Stop()
+					wg.Wait()
+				})
+
+				c.Specify("and only counts failures after a partial delivery", func() {
+					decoder.partial = true
+					d = runner.NewDeliverer("").(*deliverer)
+					runner.deliver = d.deliver
+					runner.Deliver(pack)
+					select {
+					case <-pConfig.router.inChan:
+						c.Expect("", gs.Equals, "pack should NOT be delivered again")
+					default:
+					}
+					reporting := pConfig.allSyncDecoders[len(pConfig.allSyncDecoders)-1]
+					c.Expect(reporting.failures.failureCount(), gs.Equals, int64(1))
+					pack.Recycle(nil)
+					input.Stop()
+					wg.Wait()
+				})
+
+				c.Specify("and rejects unknown failure targets", func() {
+					runner.failureAction = DecodeFailureRoute
+					runner.config.DecodeFailureTarget = "NoSuchDecoder"
+					err := runner.checkDecodeFailureConfig()
+					c.Expect(err.Error(), gs.Equals, "decode_failure_target "+
+						"'NoSuchDecoder' isn't a registered decoder or filter")
+					pack.Recycle(nil)
+					input.Stop()
+					wg.Wait()
+				})
+
+				c.Specify("and rejects max_decoders", func() {
+					runner.config.MaxDecoders = 4
+					err := pConfig.checkMaxDecoders(runner.config, runner.syncDecode)
+					c.Expect(err.Error(), gs.Equals,
+						"max_decoders can't be combined with synchronous_decode")
+					pack.Recycle(nil)
+					input.Stop()
+					wg.Wait()
+				})
+
 				c.Specify("unless sendDecodeFailure is false", func() {
 					decoder.fail = true
-					runner.sendDecodeFailures = false
+					runner.failureAction = DecodeFailureDrop
+					d = runner.NewDeliverer("").(*deliverer)
+					runner.deliver = d.deliver
 					runner.Deliver(pack)
 					var (
 						recd *PipelinePack
@@ -353,7 +427,8 @@ func (s *StoppingOutput) Stop() {
 }
 
 type _fooDecoder struct {
-	fail bool
+	fail    bool
+	partial bool
 }
 
 func (d *_fooDecoder) Init(config interface{}) error {
@@ -364,10 +439,26 @@ func (d *_fooDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err err
 	if d.fail {
 		return nil, errors.New("DECODE ERROR")
 	}
+	if d.partial {
+		return nil, NewPartialDecodeError("DECODE ERROR")
+	}
 	pack.Message.SetPayload("FOO")
 	return []*PipelinePack{pack}, nil
 }
 
+type _typeDecoder struct {
+	msgType string
+}
+
+func (d *_typeDecoder) Init(config interface{}) error {
+	return nil
+}
+
+func (d *_typeDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	pack.Message.SetType(d.msgType)
+	return []*PipelinePack{pack}, nil
+}
+
 type _payloadEncoder struct{}
 
 func (enc *_payloadEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
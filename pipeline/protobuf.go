@@ -16,7 +16,9 @@
 package pipeline
 
 import (
+	"fmt"
 	"math/rand"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -101,6 +103,15 @@ func (p *ProtobufDecoder) ReportMsg(msg *message.Message) error {
 	return nil
 }
 
+// Annotation keys used to track messages relayed between hekad instances.
+const (
+	// Hostname of the first hekad that recorded a hop for the message.
+	AnnotationOriginHost = "heka.origin_host"
+	// Comma separated list of "hostname@unix_nanos" entries, one for each
+	// hekad that encoded the message for relaying, oldest first.
+	AnnotationHops = "heka.hops"
+)
+
 // Encoder for converting Message objects into Protocol Buffer data.
 type ProtobufEncoder struct {
 	processMessageCount    int64
@@ -111,6 +122,18 @@ type ProtobufEncoder struct {
 	reportLock             sync.Mutex
 	sample                 bool
 	sampleDenominator      int
+	recordHops             bool
+	maxHops                int
+}
+
+type ProtobufEncoderConfig struct {
+	// Set to true to record this hekad as a relay hop in the annotations of
+	// each encoded message.
+	RecordHops bool `toml:"record_hops"`
+	// Messages that have already been relayed this many times are refused,
+	// so relay loops can't circulate forever. 0 means no limit. Only used
+	// when RecordHops is set.
+	MaxHops int `toml:"max_hops"`
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -119,12 +142,55 @@ func (p *ProtobufEncoder) SetPipelineConfig(pConfig *PipelineConfig) {
 	p.pConfig = pConfig
 }
 
+func (p *ProtobufEncoder) ConfigStruct() interface{} {
+	return new(ProtobufEncoderConfig)
+}
+
 func (p *ProtobufEncoder) Init(config interface{}) error {
+	conf, ok := config.(*ProtobufEncoderConfig)
+	if !ok || conf == nil {
+		conf = new(ProtobufEncoderConfig)
+	}
+	if conf.MaxHops < 0 {
+		return fmt.Errorf("max_hops must not be negative: %d", conf.MaxHops)
+	}
+	p.recordHops = conf.RecordHops
+	p.maxHops = conf.MaxHops
 	p.sample = true
 	p.sampleDenominator = p.pConfig.Globals.SampleDenominator
 	return nil
 }
 
+// Returns the number of relay hops recorded in the message's annotations.
+func RelayHopCount(msg *message.Message) int {
+	hops, _ := msg.GetAnnotation(AnnotationHops)
+	if hops == "" {
+		return 0
+	}
+	return strings.Count(hops, ",") + 1
+}
+
+// Appends a hop for this hekad to the message's annotations, setting the
+// origin host if no earlier hop did.
+func (p *ProtobufEncoder) recordHop(msg *message.Message, now time.Time) error {
+	count := RelayHopCount(msg)
+	if p.maxHops > 0 && count >= p.maxHops {
+		hops, _ := msg.GetAnnotation(AnnotationHops)
+		return fmt.Errorf("message exceeded max_hops (%d): %s", p.maxHops, hops)
+	}
+	hostname := p.pConfig.Hostname()
+	if _, ok := msg.GetAnnotation(AnnotationOriginHost); !ok {
+		msg.SetAnnotation(AnnotationOriginHost, hostname)
+	}
+	hop := fmt.Sprintf("%s@%d", hostname, now.UnixNano())
+	if count > 0 {
+		hops, _ := msg.GetAnnotation(AnnotationHops)
+		hop = hops + "," + hop
+	}
+	msg.SetAnnotation(AnnotationHops, hop)
+	return nil
+}
+
 func (p *ProtobufEncoder) Encode(pack *PipelinePack) (output []byte, err error) {
 	atomic.AddInt64(&p.processMessageCount, 1)
 	var startTime time.Time
@@ -132,12 +198,25 @@ func (p *ProtobufEncoder) Encode(pack *PipelinePack) (output []byte, err error)
 		startTime = time.Now()
 	}
 
-	// Once the reimplementation of the output API is finished we should be
-	// able to just return pack.MsgBytes directly, but for now we need to copy
-	// the data to prevent problems in case the pack is zeroed and/or reused
-	// (overwriting the pack.MsgBytes memory) before we're done with it.
-	output = make([]byte, len(pack.MsgBytes))
-	copy(output, pack.MsgBytes)
+	if p.recordHops {
+		// The pack is shared with other outputs, so annotate a copy.
+		msg := message.CopyMessage(pack.Message)
+		if err = p.recordHop(msg, time.Now()); err == nil {
+			output, err = proto.Marshal(msg)
+		}
+		if err != nil {
+			atomic.AddInt64(&p.processMessageFailures, 1)
+			return nil, err
+		}
+	} else {
+		// Once the reimplementation of the output API is finished we should
+		// be able to just return pack.MsgBytes directly, but for now we need
+		// to copy the data to prevent problems in case the pack is zeroed
+		// and/or reused (overwriting the pack.MsgBytes memory) before we're
+		// done with it.
+		output = make([]byte, len(pack.MsgBytes))
+		copy(output, pack.MsgBytes)
+	}
 
 	if p.sample {
 		duration := time.Since(startTime).Nanoseconds()
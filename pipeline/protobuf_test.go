@@ -17,9 +17,11 @@
 package pipeline
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
 	ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
 	"github.com/rafrombrc/gospec/src/gospec"
@@ -65,6 +67,79 @@ func ProtobufDecoderSpec(c gospec.Context) {
 	})
 }
 
+func ProtobufEncoderSpec(c gospec.Context) {
+	msg := ts.GetTestMessage()
+	config := NewPipelineConfig(nil)
+	config.hostname = "relay1.example.com"
+
+	c.Specify("A ProtobufEncoder", func() {
+		encoder := new(ProtobufEncoder)
+		encoder.SetPipelineConfig(config)
+		conf := encoder.ConfigStruct().(*ProtobufEncoderConfig)
+		pack := NewPipelinePack(config.inputRecycleChan)
+		msg.Copy(pack.Message)
+		pack.EncodeMsgBytes()
+		decoded := new(message.Message)
+
+		c.Specify("passes message bytes through by default", func() {
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			output, err := encoder.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals, string(pack.MsgBytes))
+		})
+
+		c.Specify("rejects a negative max_hops", func() {
+			conf.MaxHops = -1
+			err := encoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("recording hops", func() {
+			conf.RecordHops = true
+			conf.MaxHops = 2
+			err := encoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("sets the origin and first hop", func() {
+				output, err := encoder.Encode(pack)
+				c.Expect(err, gs.IsNil)
+				err = proto.Unmarshal(output, decoded)
+				c.Assume(err, gs.IsNil)
+				origin, _ := decoded.GetAnnotation(AnnotationOriginHost)
+				c.Expect(origin, gs.Equals, "relay1.example.com")
+				hops, _ := decoded.GetAnnotation(AnnotationHops)
+				c.Expect(strings.HasPrefix(hops, "relay1.example.com@"), gs.IsTrue)
+				c.Expect(RelayHopCount(decoded), gs.Equals, 1)
+				// The shared pack isn't modified.
+				c.Expect(len(pack.Message.Annotations), gs.Equals, 0)
+			})
+
+			c.Specify("appends to existing hops and keeps the origin", func() {
+				pack.Message.SetAnnotation(AnnotationOriginHost, "edge.example.com")
+				pack.Message.SetAnnotation(AnnotationHops, "edge.example.com@1")
+				output, err := encoder.Encode(pack)
+				c.Expect(err, gs.IsNil)
+				err = proto.Unmarshal(output, decoded)
+				c.Assume(err, gs.IsNil)
+				origin, _ := decoded.GetAnnotation(AnnotationOriginHost)
+				c.Expect(origin, gs.Equals, "edge.example.com")
+				hops, _ := decoded.GetAnnotation(AnnotationHops)
+				c.Expect(strings.HasPrefix(hops, "edge.example.com@1,relay1.example.com@"),
+					gs.IsTrue)
+				c.Expect(RelayHopCount(decoded), gs.Equals, 2)
+			})
+
+			c.Specify("refuses messages that reached max_hops", func() {
+				pack.Message.SetAnnotation(AnnotationHops, "a@1,b@2")
+				output, err := encoder.Encode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(output, gs.IsNil)
+			})
+		})
+	})
+}
+
 func BenchmarkEncodeProtobuf(b *testing.B) {
 	b.StopTimer()
 	msg := ts.GetTestMessage()
@@ -64,6 +64,13 @@ type QueueBufferConfig struct {
 	MaxBufferSize     uint64 `toml:"max_buffer_size"`
 	FullAction        string `toml:"full_action"`
 	CursorUpdateCount uint   `toml:"cursor_update_count"`
+	// Key used to encrypt and authenticate the queued records, or a file
+	// holding it. Records are stored in the clear if neither is set.
+	EncryptionKey     string `toml:"encryption_key"`
+	EncryptionKeyFile string `toml:"encryption_key_file"`
+	// Name of the compression codec applied to the queued records, "none"
+	// by default.
+	Compression string `toml:"compression"`
 }
 
 const DefaultBufferMaxFileSize uint64 = uint64(512 * 1024 * 1024)
@@ -89,6 +96,9 @@ func NewBufferSet(queueDir, queueName string, config *QueueBufferConfig,
 			return nil, nil, fmt.Errorf("can't make queue directory: %s", err)
 		}
 	}
+	if err := handOverSpool(filepath.Join(queueDir, queueName), queue); err != nil {
+		LogError.Printf("Can't take over queue buffer: %s", err)
+	}
 
 	queueSize := &BufferSize{
 		size: getQueueBufferSize(queue),
@@ -107,15 +117,39 @@ func NewBufferSet(queueDir, queueName string, config *QueueBufferConfig,
 		return nil, nil, err
 	}
 
+	var qc *queueCipher
+	key, err := loadQueueKey(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't load encryption key: %s", err)
+	}
+	if key != nil {
+		if qc, err = newQueueCipher(key, queueName); err != nil {
+			return nil, nil, fmt.Errorf("can't set up encryption: %s", err)
+		}
+	}
+
+	codec, err := GetCompressionCodec(config.Compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, ok := codec.(MagicCodec); codec != nil && !ok {
+		return nil, nil, fmt.Errorf(
+			"compression codec '%s' can't be used for buffering, it has no magic number",
+			config.Compression)
+	}
+
 	bf, err := NewBufferFeeder(queue, config, queueSize)
 	if err != nil {
 		return nil, nil, fmt.Errorf("can't create BufferFeeder: %s", err)
 	}
+	bf.cipher = qc
+	bf.codec = codec
 
 	br, err := NewBufferReader(queue, config, queueSize, runner, pConfig)
 	if err != nil {
 		return nil, nil, fmt.Errorf("can't create BufferReader: %s", err)
 	}
+	br.cipher = qc
 
 	return bf, br, nil
 }
@@ -127,6 +161,11 @@ type BufferFeeder struct {
 	queue         string
 	queueSize     *BufferSize
 	Config        *QueueBufferConfig
+	// Seals the records written to the queue, if encryption is enabled.
+	cipher *queueCipher
+	// Compresses the records written to the queue, if compression is
+	// enabled.
+	codec CompressionCodec
 }
 
 func NewBufferFeeder(queue string, config *QueueBufferConfig, queueSize *BufferSize) (
@@ -178,19 +217,33 @@ func (bf *BufferFeeder) RollQueue() (err error) {
 // that QueueRecord is *not* thread safe, it should only ever be called by one
 // goroutine at a time.
 func (bf *BufferFeeder) QueueRecord(pack *PipelinePack) error {
+	var err error
+	record := pack.MsgBytes
+	// Records are compressed before they're encrypted, ciphertext doesn't
+	// compress.
+	if bf.codec != nil {
+		if record, err = Compress(bf.codec, record); err != nil {
+			return fmt.Errorf("can't compress record: %s", err)
+		}
+	}
+	if bf.cipher != nil {
+		if record, err = bf.cipher.seal(record); err != nil {
+			return fmt.Errorf("can't encrypt record: %s", err)
+		}
+	}
 	maxQueueSize := bf.Config.MaxBufferSize
-	if maxQueueSize > 0 && (bf.queueSize.Get()+uint64(len(pack.MsgBytes)) > maxQueueSize) {
+	if maxQueueSize > 0 && (bf.queueSize.Get()+uint64(len(record)) > maxQueueSize) {
 		return QueueIsFull
 	}
 	maxQueueFileSize := bf.Config.MaxFileSize
-	if bf.writeFileSize+uint64(len(pack.MsgBytes)) > maxQueueFileSize {
+	if bf.writeFileSize+uint64(len(record)) > maxQueueFileSize {
 		if err := bf.RollQueue(); err != nil {
 			return fmt.Errorf("queue file rotation error: %s", err)
 		}
 	}
 
 	var outBytes []byte
-	err := client.CreateHekaStream(pack.MsgBytes, &outBytes, nil)
+	err = client.CreateHekaStream(record, &outBytes, nil)
 	if err != nil {
 		return fmt.Errorf("message framing error: %s", err)
 	}
@@ -231,6 +284,8 @@ type BufferReader struct {
 	checkpointFile     *os.File
 	queue              string
 	queueSize          *BufferSize
+	// Opens the records read from the queue, if encryption is enabled.
+	cipher *queueCipher
 }
 
 type BufferSender interface {
@@ -419,8 +474,10 @@ func (br *BufferReader) runTimerEvent(tickerPlugin TickerPlugin) error {
 	err := tickerPlugin.TimerEvent()
 	if err != nil {
 		br.runner.LogError(fmt.Errorf("running TimerEvent: %s", err.Error()))
-		if _, ok := err.(PluginExitError); !ok {
-			err = nil // Only return the error if it's fatal.
+		switch br.runner.countError(err) {
+		case ErrorFatal, ErrorConfig:
+		default:
+			err = nil // Only return the error if the plugin has to stop.
 		}
 	}
 	return err
@@ -509,6 +566,11 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 				rh.Wait()
 				continue
 			}
+			if err == QueueUnauthenticatedRecord {
+				br.runner.LogError(fmt.Errorf("skipping queue record: %s", err))
+				atomic.AddInt64(&br.runner.dropMessageCount, 1)
+				continue
+			}
 			return fmt.Errorf("can't get record: %s", err)
 		}
 
@@ -521,17 +583,16 @@ func (br *BufferReader) NewStreamOutput(sender MessageProcessor, packSupply chan
 		for {
 			err = sender.ProcessMessage(pack)
 			if err != nil {
-				switch err.(type) {
-				case PluginExitError:
+				switch br.runner.countError(err) {
+				case ErrorFatal, ErrorConfig:
 					atomic.AddInt64(&br.runner.dropMessageCount, 1)
 					pack.recycle()
 					return err
-				case RetryMessageError:
+				case ErrorTransient:
 					br.runner.LogError(fmt.Errorf("can't send record: %s", err))
 					// Falls through to a retry wait below.
 				default:
-					atomic.AddInt64(&br.runner.dropMessageCount, 1)
-					pack.recycle()
+					br.runner.dropMessage(pack, err)
 					break sendLoop
 				}
 			} else {
@@ -636,6 +697,11 @@ func (br *BufferReader) StreamOutput(sender BufferSender,
 				rh.Wait()
 				continue
 			}
+			if err == QueueUnauthenticatedRecord {
+				br.runner.LogError(fmt.Errorf("skipping queue record: %s", err))
+				atomic.AddInt64(&br.runner.dropMessageCount, 1)
+				continue
+			}
 			return fmt.Errorf("can't get record: %s", err)
 		}
 
@@ -651,6 +717,11 @@ func (br *BufferReader) StreamOutput(sender BufferSender,
 				}
 				break
 			}
+			switch ErrorCategoryOf(err) {
+			case ErrorFatal, ErrorConfig:
+				// The sender has recycled the pack.
+				return err
+			}
 			select {
 			case <-stopChan:
 				pack.recycle()
@@ -712,13 +783,31 @@ func (br *BufferReader) NextRecord(pack *PipelinePack) error {
 	if recordLen < headerLen {
 		return QueueInvalidRecord
 	}
-	msgLen := len(record) - headerLen
-	if cap(pack.MsgBytes) < msgLen {
-		pack.MsgBytes = make([]byte, msgLen)
+	if br.cipher != nil {
+		msgBytes, err := br.cipher.open(pack.MsgBytes, record[headerLen:])
+		if err != nil {
+			return err
+		}
+		pack.MsgBytes = msgBytes
 	} else {
-		pack.MsgBytes = pack.MsgBytes[:msgLen]
+		msgLen := len(record) - headerLen
+		if cap(pack.MsgBytes) < msgLen {
+			pack.MsgBytes = make([]byte, msgLen)
+		} else {
+			pack.MsgBytes = pack.MsgBytes[:msgLen]
+		}
+		copy(pack.MsgBytes, record[headerLen:])
+	}
+	// Encoded messages start with the protobuf tag of the Uuid field, which
+	// no codec's magic number does, so records are recognized whatever the
+	// compression setting was when they were queued.
+	if _, codec := DetectCompressionCodec(pack.MsgBytes); codec != nil {
+		msgBytes, err := Decompress(codec, pack.MsgBytes)
+		if err != nil {
+			return fmt.Errorf("can't decompress record: %s", err)
+		}
+		pack.MsgBytes = msgBytes
 	}
-	copy(pack.MsgBytes, record[headerLen:])
 	pack.TrustMsgBytes = true
 	err = proto.Unmarshal(pack.MsgBytes, pack.Message)
 	if err != nil {
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/bbangert/toml"
 	"github.com/gogo/protobuf/proto"
@@ -301,6 +302,79 @@ func QueueBufferSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("encrypts records", func() {
+			qc, err := newQueueCipher([]byte("secret"), "test")
+			c.Assume(err, gs.IsNil)
+			feeder.cipher = qc
+			reader.cipher = qc
+			payload := "Keep me from prying eyes"
+			msg.SetPayload(payload)
+			pack := NewPipelinePack(nil)
+			pack.MsgBytes, err = proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+
+			err = feeder.RollQueue()
+			c.Assume(err, gs.IsNil)
+			err = feeder.QueueRecord(pack)
+			c.Expect(err, gs.IsNil)
+			feeder.writeFile.Close()
+			fName := getQueueFilename(feeder.queue, feeder.writeId)
+			contents, err := ioutil.ReadFile(fName)
+			c.Assume(err, gs.IsNil)
+			c.Expect(strings.Contains(string(contents), payload), gs.IsFalse)
+
+			reader.readFile, err = os.Open(fName)
+			c.Assume(err, gs.IsNil)
+			reader.readId = feeder.writeId
+			outPack := NewPipelinePack(nil)
+
+			c.Specify("and reads them back", func() {
+				err = reader.NextRecord(outPack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(outPack.Message.GetPayload(), gs.Equals, payload)
+			})
+
+			c.Specify("rejects them without the right key", func() {
+				reader.cipher, _ = newQueueCipher([]byte("other"), "test")
+				err = reader.NextRecord(outPack)
+				c.Expect(err, gs.Equals, QueueUnauthenticatedRecord)
+			})
+			reader.readFile.Close()
+		})
+
+		c.Specify("compresses records", func() {
+			feeder.codec, err = GetCompressionCodec("gzip")
+			c.Assume(err, gs.IsNil)
+			payload := strings.Repeat("Squeeze me ", 50)
+			msg.SetPayload(payload)
+			pack := NewPipelinePack(nil)
+			pack.MsgBytes, err = proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+
+			err = feeder.RollQueue()
+			c.Assume(err, gs.IsNil)
+			err = feeder.QueueRecord(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(feeder.writeFileSize < uint64(len(pack.MsgBytes)), gs.IsTrue)
+			// Records queued before compression was enabled stay readable.
+			feeder.codec = nil
+			err = feeder.QueueRecord(pack)
+			c.Expect(err, gs.IsNil)
+			feeder.writeFile.Close()
+
+			fName := getQueueFilename(feeder.queue, feeder.writeId)
+			reader.readFile, err = os.Open(fName)
+			c.Assume(err, gs.IsNil)
+			defer reader.readFile.Close()
+			reader.readId = feeder.writeId
+			for i := 0; i < 2; i++ {
+				outPack := NewPipelinePack(nil)
+				err = reader.NextRecord(outPack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(outPack.Message.GetPayload(), gs.Equals, payload)
+			}
+		})
+
 		c.Specify("getQueueBufferSize", func() {
 			c.Expect(getQueueBufferSize(tmpDir), gs.Equals, uint64(0))
 
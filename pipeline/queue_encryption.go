@@ -0,0 +1,126 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var QueueUnauthenticatedRecord = errors.New("Record failed authentication")
+
+const queueRecordVersion byte = 1
+
+// Encrypts queue buffer records with AES-256 in CTR mode and authenticates
+// them with an HMAC-SHA256 of the version, IV, ciphertext and queue name, so
+// records can neither be read nor altered, or moved between queues, without
+// the key. A sealed record is laid out as:
+//
+//	version (1 byte) | IV (16 bytes) | ciphertext | HMAC (32 bytes)
+type queueCipher struct {
+	block  cipher.Block
+	macKey []byte
+	queue  []byte
+}
+
+// Derives separate encryption and authentication keys from the configured
+// key.
+func newQueueCipher(key []byte, queue string) (*queueCipher, error) {
+	if len(key) == 0 {
+		return nil, errors.New("empty encryption key")
+	}
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(purpose))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("heka queue encryption"))
+	if err != nil {
+		return nil, err
+	}
+	return &queueCipher{
+		block:  block,
+		macKey: derive("heka queue authentication"),
+		queue:  []byte(queue),
+	}, nil
+}
+
+// Reads the encryption key from the buffering config, either inline or from a
+// file such as a mounted secret. Returns nil if encryption isn't enabled.
+func loadQueueKey(config *QueueBufferConfig) ([]byte, error) {
+	if config.EncryptionKey != "" && config.EncryptionKeyFile != "" {
+		return nil, errors.New("only one of `encryption_key` and `encryption_key_file` can be set")
+	}
+	if config.EncryptionKeyFile != "" {
+		key, err := ioutil.ReadFile(config.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = []byte(strings.TrimSpace(string(key)))
+		if len(key) == 0 {
+			return nil, errors.New("encryption key file is empty")
+		}
+		return key, nil
+	}
+	if config.EncryptionKey != "" {
+		return []byte(config.EncryptionKey), nil
+	}
+	return nil, nil
+}
+
+func (qc *queueCipher) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, qc.macKey)
+	mac.Write(data)
+	mac.Write(qc.queue)
+	return mac.Sum(nil)
+}
+
+// Returns the encrypted and authenticated form of the record.
+func (qc *queueCipher) seal(record []byte) ([]byte, error) {
+	ivEnd := 1 + aes.BlockSize
+	sealed := make([]byte, ivEnd+len(record), ivEnd+len(record)+sha256.Size)
+	sealed[0] = queueRecordVersion
+	iv := sealed[1:ivEnd]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(qc.block, iv).XORKeyStream(sealed[ivEnd:], record)
+	return append(sealed, qc.mac(sealed)...), nil
+}
+
+// Authenticates and decrypts a sealed record into dst, which is grown as
+// needed, and returns the result.
+func (qc *queueCipher) open(dst, sealed []byte) ([]byte, error) {
+	ivEnd := 1 + aes.BlockSize
+	if len(sealed) < ivEnd+sha256.Size || sealed[0] != queueRecordVersion {
+		return nil, QueueUnauthenticatedRecord
+	}
+	macStart := len(sealed) - sha256.Size
+	if !hmac.Equal(sealed[macStart:], qc.mac(sealed[:macStart])) {
+		return nil, QueueUnauthenticatedRecord
+	}
+	ciphertext := sealed[ivEnd:macStart]
+	if cap(dst) < len(ciphertext) {
+		dst = make([]byte, len(ciphertext))
+	} else {
+		dst = dst[:len(ciphertext)]
+	}
+	cipher.NewCTR(qc.block, sealed[1:ivEnd]).XORKeyStream(dst, ciphertext)
+	return dst, nil
+}
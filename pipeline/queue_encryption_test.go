@@ -0,0 +1,91 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func QueueEncryptionSpec(c gs.Context) {
+	record := []byte("a queued message")
+
+	c.Specify("A queue cipher", func() {
+		qc, err := newQueueCipher([]byte("secret"), "TcpOutput")
+		c.Assume(err, gs.IsNil)
+		sealed, err := qc.seal(record)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("round trips records", func() {
+			c.Expect(len(sealed), gs.Equals, len(record)+49)
+			opened, err := qc.open(nil, sealed)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(opened), gs.Equals, string(record))
+		})
+
+		c.Specify("uses a fresh IV for each record", func() {
+			again, err := qc.seal(record)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(again) == string(sealed), gs.IsFalse)
+		})
+
+		c.Specify("rejects altered records", func() {
+			sealed[20] ^= 1
+			_, err := qc.open(nil, sealed)
+			c.Expect(err, gs.Equals, QueueUnauthenticatedRecord)
+		})
+
+		c.Specify("rejects truncated records", func() {
+			_, err := qc.open(nil, sealed[:40])
+			c.Expect(err, gs.Equals, QueueUnauthenticatedRecord)
+		})
+
+		c.Specify("rejects records from another queue", func() {
+			other, err := newQueueCipher([]byte("secret"), "KafkaOutput")
+			c.Assume(err, gs.IsNil)
+			_, err = other.open(nil, sealed)
+			c.Expect(err, gs.Equals, QueueUnauthenticatedRecord)
+		})
+	})
+
+	c.Specify("The encryption key", func() {
+		config := defaultQueueBufferConfig()
+
+		c.Specify("isn't required", func() {
+			key, err := loadQueueKey(config)
+			c.Expect(err, gs.IsNil)
+			c.Expect(key, gs.IsNil)
+		})
+
+		c.Specify("can be read from a file", func() {
+			tmpDir, err := ioutil.TempDir("", "queue-encryption-tests")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(tmpDir)
+			config.EncryptionKeyFile = filepath.Join(tmpDir, "key")
+			err = ioutil.WriteFile(config.EncryptionKeyFile, []byte("secret\n"), 0600)
+			c.Assume(err, gs.IsNil)
+			key, err := loadQueueKey(config)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(key), gs.Equals, "secret")
+		})
+
+		c.Specify("can't be set twice", func() {
+			config.EncryptionKey = "secret"
+			config.EncryptionKeyFile = "/etc/heka/queue.key"
+			_, err := loadQueueKey(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,197 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// QueueStat is a point-in-time measurement of one of Heka's internal queues.
+// Kind, Name and Queue together identify the queue and stay the same from
+// one measurement to the next, so they can be used as metric dimensions.
+type QueueStat struct {
+	// "pool", "router", "decoder", "filter" or "output".
+	Kind string
+	// Pool name ("input" or "inject"), "Router", or the plugin name.
+	Name string
+	// "recycle" for pools, "in" for a plugin's input channel, "match" for a
+	// filter or output's message matcher channel.
+	Queue    string
+	Capacity int
+	// Packs currently taken from a pool, or messages waiting in a queue.
+	Used int
+}
+
+// Utilization returns the fraction of the queue's capacity in use.
+func (s QueueStat) Utilization() float64 {
+	if s.Capacity == 0 {
+		return 0
+	}
+	return float64(s.Used) / float64(s.Capacity)
+}
+
+// QueueStats returns the current utilization of the pack pools, the router
+// and the queues in front of every decoder, filter and output.
+func (pc *PipelineConfig) QueueStats() []QueueStat {
+	// An idle pool's recycle channel is full, so the packs in use are the
+	// ones missing from it.
+	stats := []QueueStat{
+		{"pool", "input", "recycle", cap(pc.inputRecycleChan),
+			cap(pc.inputRecycleChan) - len(pc.inputRecycleChan)},
+		{"pool", "inject", "recycle", cap(pc.injectRecycleChan),
+			cap(pc.injectRecycleChan) - len(pc.injectRecycleChan)},
+		{"router", "Router", "in", cap(pc.router.InChan()), len(pc.router.InChan())},
+	}
+
+	pc.allDecodersLock.RLock()
+	for _, dRunner := range pc.allDecoders {
+		stats = append(stats, QueueStat{"decoder", dRunner.Name(), "in",
+			cap(dRunner.InChan()), len(dRunner.InChan())})
+	}
+	pc.allDecodersLock.RUnlock()
+
+	foStats := func(kind, name string, inChan chan *PipelinePack, mr *MatchRunner) {
+		stats = append(stats,
+			QueueStat{kind, name, "in", cap(inChan), len(inChan)},
+			QueueStat{kind, name, "match", cap(mr.inChan), len(mr.inChan)})
+	}
+	pc.filtersLock.Lock()
+	for name, fRunner := range pc.FilterRunners {
+		foStats("filter", name, fRunner.InChan(), fRunner.MatchRunner())
+	}
+	pc.filtersLock.Unlock()
+	for name, oRunner := range pc.OutputRunners {
+		foStats("output", name, oRunner.InChan(), oRunner.MatchRunner())
+	}
+	return stats
+}
+
+// Injects one "heka.queue-stats" message per queue into the router.
+func (pc *PipelineConfig) queueStatsMsgs() {
+	for _, stat := range pc.QueueStats() {
+		pack, err := pc.PipelinePack(0)
+		if err != nil {
+			LogError.Println(err.Error())
+			return
+		}
+		msg := pack.Message
+		msg.SetLogger(HEKA_DAEMON)
+		msg.SetType("heka.queue-stats")
+		message.NewStringField(msg, "Kind", stat.Kind)
+		message.NewStringField(msg, "Name", stat.Name)
+		message.NewStringField(msg, "Queue", stat.Queue)
+		message.NewIntField(msg, "Capacity", stat.Capacity, "count")
+		message.NewIntField(msg, "Used", stat.Used, "count")
+		if f, err := message.NewField("Utilization", stat.Utilization(), "ratio"); err == nil {
+			msg.AddField(f)
+		}
+		if err = pack.EncodeMsgBytes(); err != nil {
+			LogError.Printf("encoding heka.queue-stats message: %s\n", err.Error())
+			pack.recycle()
+			continue
+		}
+		pc.router.InChan() <- pack
+	}
+}
+
+// Generates queue stats messages every interval until stopChan is closed.
+func (pc *PipelineConfig) queueStatsLoop(interval time.Duration, stopChan chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+			pc.queueStatsMsgs()
+		}
+	}
+}
+
+// Writes the queue stats in the Prometheus text exposition format.
+func writeQueueMetrics(w io.Writer, stats []QueueStat) {
+	sort.Sort(queueStatsByLabels(stats))
+	gauges := []struct {
+		name, help string
+		value      func(QueueStat) string
+	}{
+		{"heka_queue_capacity", "Capacity of the queue or pack pool.",
+			func(s QueueStat) string { return fmt.Sprint(s.Capacity) }},
+		{"heka_queue_used", "Packs in use from a pool, or messages waiting in a queue.",
+			func(s QueueStat) string { return fmt.Sprint(s.Used) }},
+		{"heka_queue_utilization", "Fraction of the queue or pack pool's capacity in use.",
+			func(s QueueStat) string { return fmt.Sprint(s.Utilization()) }},
+	}
+	for _, g := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{kind=%s,name=%s,queue=%s} %s\n", g.name,
+				promLabel(s.Kind), promLabel(s.Name), promLabel(s.Queue), g.value(s))
+		}
+	}
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabel(value string) string {
+	return `"` + promLabelEscaper.Replace(value) + `"`
+}
+
+type queueStatsByLabels []QueueStat
+
+func (s queueStatsByLabels) Len() int      { return len(s) }
+func (s queueStatsByLabels) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s queueStatsByLabels) Less(i, j int) bool {
+	if s[i].Kind != s[j].Kind {
+		return s[i].Kind < s[j].Kind
+	}
+	if s[i].Name != s[j].Name {
+		return s[i].Name < s[j].Name
+	}
+	return s[i].Queue < s[j].Queue
+}
+
+// Serves the queue stats for Prometheus to scrape at `/metrics` on the given
+// address, restricted to the "view" role if an AccessControl resource is
+// named. Returns the listener so it can be closed on shutdown.
+func (pc *PipelineConfig) serveQueueMetrics(address, aclName string) (
+	net.Listener, error) {
+
+	var handler http.Handler = http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+			writeQueueMetrics(w, pc.QueueStats())
+		})
+	if aclName != "" {
+		acl, err := pc.AccessControl(aclName)
+		if err != nil {
+			return nil, err
+		}
+		handler = acl.Handler(handler, RoleView)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	go http.Serve(listener, mux)
+	return listener, nil
+}
@@ -0,0 +1,92 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"bytes"
+	"strings"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func QueueStatsSpec(c gs.Context) {
+	pc := NewPipelineConfig(nil)
+	poolSize := pc.Globals.PoolSize
+	chanSize := pc.Globals.PluginChanSize
+	for i := 0; i < poolSize; i++ {
+		pc.inputRecycleChan <- NewPipelinePack(pc.inputRecycleChan)
+		pc.injectRecycleChan <- NewPipelinePack(pc.injectRecycleChan)
+	}
+
+	fRunner, err := NewFORunner("counter", new(CounterFilter),
+		CommonFOConfig{Matcher: "TRUE"}, "CounterFilter", chanSize)
+	c.Assume(err, gs.IsNil)
+	pc.FilterRunners["counter"] = fRunner
+
+	find := func(stats []QueueStat, kind, name, queue string) (QueueStat, bool) {
+		for _, stat := range stats {
+			if stat.Kind == kind && stat.Name == name && stat.Queue == queue {
+				return stat, true
+			}
+		}
+		return QueueStat{}, false
+	}
+
+	c.Specify("QueueStats", func() {
+		c.Specify("counts the packs taken from a pool", func() {
+			pack := <-pc.inputRecycleChan
+			defer pack.recycle()
+			stat, ok := find(pc.QueueStats(), "pool", "input", "recycle")
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(stat.Capacity, gs.Equals, poolSize)
+			c.Expect(stat.Used, gs.Equals, 1)
+
+			stat, ok = find(pc.QueueStats(), "pool", "inject", "recycle")
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(stat.Used, gs.Equals, 0)
+		})
+
+		c.Specify("counts the messages waiting for a filter", func() {
+			fRunner.inChan <- NewPipelinePack(nil)
+			fRunner.inChan <- NewPipelinePack(nil)
+			stats := pc.QueueStats()
+			<-fRunner.inChan
+			<-fRunner.inChan
+
+			stat, ok := find(stats, "filter", "counter", "in")
+			c.Assume(ok, gs.IsTrue)
+			c.Expect(stat.Capacity, gs.Equals, chanSize)
+			c.Expect(stat.Used, gs.Equals, 2)
+			c.Expect(stat.Utilization(), gs.Equals, 2/float64(chanSize))
+
+			_, ok = find(stats, "filter", "counter", "match")
+			c.Expect(ok, gs.IsTrue)
+			_, ok = find(stats, "router", "Router", "in")
+			c.Expect(ok, gs.IsTrue)
+		})
+	})
+
+	c.Specify("Prometheus metrics", func() {
+		var buf bytes.Buffer
+		writeQueueMetrics(&buf, []QueueStat{
+			{"filter", `odd"name`, "in", 4, 1},
+			{"pool", "input", "recycle", 100, 25},
+		})
+		out := buf.String()
+		c.Expect(strings.Contains(out, "# TYPE heka_queue_used gauge\n"), gs.IsTrue)
+		c.Expect(strings.Contains(out,
+			`heka_queue_used{kind="pool",name="input",queue="recycle"} 25`+"\n"), gs.IsTrue)
+		c.Expect(strings.Contains(out,
+			`heka_queue_utilization{kind="filter",name="odd\"name",queue="in"} 0.25`+"\n"),
+			gs.IsTrue)
+	})
+}
@@ -0,0 +1,104 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Filters and outputs that need to warm up before they can usefully process
+// messages, e.g. by connecting to a server, loading lookup tables or
+// restoring state, can implement WarmsUp. Heka holds off starting its inputs
+// until every such plugin is ready or the `warm_up_timeout` has passed, so
+// the messages read at startup don't all fail.
+type WarmsUp interface {
+	// Returns a channel that's closed once the plugin is ready. Called
+	// after the plugin has been started.
+	Ready() <-chan struct{}
+}
+
+// ReadyFlag implements WarmsUp for embedding in plugin structs. The zero
+// value isn't ready yet; SetReady may be called any number of times.
+type ReadyFlag struct {
+	lock  sync.Mutex
+	once  sync.Once
+	ready chan struct{}
+}
+
+func (f *ReadyFlag) channel() chan struct{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.ready == nil {
+		f.ready = make(chan struct{})
+	}
+	return f.ready
+}
+
+func (f *ReadyFlag) Ready() <-chan struct{} {
+	return f.channel()
+}
+
+// Marks the plugin as ready.
+func (f *ReadyFlag) SetReady() {
+	ready := f.channel()
+	f.once.Do(func() {
+		close(ready)
+	})
+}
+
+// Waits for the started filters and outputs implementing WarmsUp to become
+// ready, for at most the given timeout. Returns the names of the plugins that
+// weren't ready in time, sorted.
+func (pc *PipelineConfig) waitForWarmUp(timeout time.Duration) []string {
+	waiting := make(map[string]<-chan struct{})
+	pc.filtersLock.RLock()
+	for name, runner := range pc.FilterRunners {
+		if w, ok := runner.Plugin().(WarmsUp); ok {
+			waiting[name] = w.Ready()
+		}
+	}
+	pc.filtersLock.RUnlock()
+	pc.outputsLock.RLock()
+	for name, runner := range pc.OutputRunners {
+		if w, ok := runner.Plugin().(WarmsUp); ok {
+			waiting[name] = w.Ready()
+		}
+	}
+	pc.outputsLock.RUnlock()
+	if len(waiting) == 0 {
+		return nil
+	}
+
+	LogInfo.Printf("Waiting for %d plugin(s) to warm up", len(waiting))
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for name, ready := range waiting {
+		select {
+		case <-ready:
+			delete(waiting, name)
+		case <-deadline.C:
+			var notReady []string
+			for name, ready := range waiting {
+				select {
+				case <-ready:
+				default:
+					notReady = append(notReady, name)
+				}
+			}
+			sort.Strings(notReady)
+			return notReady
+		}
+	}
+	return nil
+}
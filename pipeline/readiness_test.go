@@ -0,0 +1,85 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Filter that warms up when told to.
+type WarmingFilter struct {
+	CounterFilter
+	ReadyFlag
+}
+
+func ReadinessSpec(c gs.Context) {
+	pc := NewPipelineConfig(nil)
+	chanSize := pc.Globals.PluginChanSize
+
+	addFilter := func(name string, filter Plugin) {
+		fRunner, err := NewFORunner(name, filter, CommonFOConfig{Matcher: "TRUE"},
+			"CounterFilter", chanSize)
+		c.Assume(err, gs.IsNil)
+		pc.FilterRunners[name] = fRunner
+	}
+
+	c.Specify("A ReadyFlag", func() {
+		flag := new(ReadyFlag)
+
+		c.Specify("isn't ready initially", func() {
+			select {
+			case <-flag.Ready():
+				c.Expect("", gs.Equals, "flag should NOT be ready yet")
+			default:
+			}
+		})
+
+		c.Specify("can be set more than once", func() {
+			flag.SetReady()
+			flag.SetReady()
+			select {
+			case <-flag.Ready():
+			default:
+				c.Expect("", gs.Equals, "flag should be ready")
+			}
+		})
+	})
+
+	c.Specify("Waiting for warm up", func() {
+		slow := new(WarmingFilter)
+		fast := new(WarmingFilter)
+		addFilter("plain", new(CounterFilter))
+		addFilter("slow", slow)
+		addFilter("fast", fast)
+
+		c.Specify("returns once every plugin is ready", func() {
+			fast.SetReady()
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				slow.SetReady()
+			}()
+			notReady := pc.waitForWarmUp(time.Second)
+			c.Expect(len(notReady), gs.Equals, 0)
+		})
+
+		c.Specify("gives up after the timeout", func() {
+			fast.SetReady()
+			start := time.Now()
+			notReady := pc.waitForWarmUp(20 * time.Millisecond)
+			c.Expect(time.Since(start) >= 20*time.Millisecond, gs.IsTrue)
+			c.Expect(len(notReady), gs.Equals, 1)
+			c.Expect(notReady[0], gs.Equals, "slow")
+		})
+	})
+}
@@ -37,9 +37,16 @@ type ReportingPlugin interface {
 	ReportMsg(msg *message.Message) (err error)
 }
 
+// Implemented by the filter and output runners, which count the errors their
+// plugins return by category.
+type errorCounter interface {
+	ErrorCount(category ErrorCategory) int64
+}
+
 type ReportingDecoder struct {
-	name    string
-	decoder Decoder
+	name     string
+	decoder  Decoder
+	failures *decodeFailureHandler
 }
 
 // Given a PluginRunner and a Message struct, this function will populate the
@@ -66,9 +73,33 @@ func PopulateReportMsg(pr PluginRunner, msg *message.Message) (err error) {
 		}
 		fRunner.MatchRunner().reportLock.Unlock()
 		message.NewInt64Field(msg, "MatchAvgDuration", tmp, "ns")
-	} else if dRunner, ok := pr.(DecoderRunner); ok {
-		message.NewIntField(msg, "InChanCapacity", cap(dRunner.InChan()), "count")
-		message.NewIntField(msg, "InChanLength", len(dRunner.InChan()), "count")
+		if sampler := fRunner.MatchRunner().sampler; sampler != nil {
+			message.NewInt64Field(msg, "SampledOutCount", sampler.droppedCount(), "count")
+		}
+		if oRunner, ok := pr.(*foRunner); ok && oRunner.sizeGuard != nil {
+			guard := oRunner.sizeGuard
+			message.NewInt64Field(msg, "TruncatedRecordCount",
+				atomic.LoadInt64(&guard.truncatedCount), "count")
+			message.NewInt64Field(msg, "DroppedOversizeCount",
+				atomic.LoadInt64(&guard.droppedCount), "count")
+			message.NewInt64Field(msg, "SplitRequestCount",
+				atomic.LoadInt64(&guard.splitCount), "count")
+		}
+	} else if dr, ok := pr.(DecoderRunner); ok {
+		message.NewIntField(msg, "InChanCapacity", cap(dr.InChan()), "count")
+		message.NewIntField(msg, "InChanLength", len(dr.InChan()), "count")
+		if counter, ok := dr.(*dRunner); ok {
+			message.NewInt64Field(msg, "DecodeFailureCount",
+				counter.DecodeFailureCount(), "count")
+			message.NewIntField(msg, "DecoderCount",
+				int(counter.DecoderCount()), "count")
+		}
+	}
+	if counter, ok := pr.(errorCounter); ok {
+		for c := ErrorCategory(0); c < numErrorCategories; c++ {
+			name := strings.Title(c.String()) + "ErrorCount"
+			message.NewInt64Field(msg, name, counter.ErrorCount(c), "count")
+		}
 	}
 	msg.SetType("heka.plugin-report")
 	return
@@ -156,6 +187,10 @@ func (pc *PipelineConfig) reports(reportChan chan *PipelinePack) {
 		pack = <-pc.reportRecycleChan
 		message.NewStringField(pack.Message, "name", reportingDecoder.name)
 		message.NewStringField(pack.Message, "key", "decoders")
+		if reportingDecoder.failures != nil {
+			message.NewInt64Field(pack.Message, "DecodeFailureCount",
+				reportingDecoder.failures.failureCount(), "count")
+		}
 		pack.Message.SetLogger(HEKA_DAEMON)
 		pack.Message.SetType("heka.plugin-report")
 
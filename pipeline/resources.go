@@ -0,0 +1,154 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bbangert/toml"
+)
+
+// Name of the config section in which shared resources are defined, one
+// subsection per resource.
+const RESOURCES = "resources"
+
+// Resource types that can be referenced from a `[resources]` subsection, by
+// type name.
+var AvailableResources = make(map[string]func() interface{})
+
+// Adds a resource type to the set of types that can be defined in the
+// `[resources]` config section. Resources are objects that many plugins can
+// share by name, such as client connections or credentials. They are
+// configured like plugins: the value returned by the factory must implement
+// `Init` and can implement `HasConfigStruct`. Resources are created before any
+// plugin is initialized and, if they implement `NeedsStopping`, are stopped
+// after all plugins have shut down.
+func RegisterResource(name string, factory func() interface{}) {
+	AvailableResources[name] = factory
+}
+
+// Stores the TOML sections of all of the resources found in a config file's
+// `[resources]` section, they're initialized by `LoadConfig`.
+func (self *PipelineConfig) preloadResources(conf toml.Primitive) error {
+	sections, ok := conf.(map[string]interface{})
+	if !ok {
+		return errors.New("[resources] must only contain resource sections")
+	}
+	if self.resourceSections == nil {
+		self.resourceSections = make(map[string]toml.Primitive)
+	}
+	for name, section := range sections {
+		if _, ok := section.(map[string]interface{}); !ok {
+			return fmt.Errorf("resource '%s' must be a config section", name)
+		}
+		if _, ok := self.resourceSections[name]; ok {
+			return fmt.Errorf("resource '%s' is defined more than once", name)
+		}
+		self.resourceSections[name] = section
+	}
+	return nil
+}
+
+// Creates and initializes all of the preloaded resources.
+func (self *PipelineConfig) loadResources() {
+	names := make([]string, 0, len(self.resourceSections))
+	for name := range self.resourceSections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		LogInfo.Printf("Loading: [%s.%s]\n", RESOURCES, name)
+		resource, err := self.makeResource(name, self.resourceSections[name])
+		if err != nil {
+			self.log(fmt.Sprintf("Error loading resource '%s': %s", name, err))
+			self.errcnt++
+			continue
+		}
+		self.resources[name] = resource
+	}
+}
+
+func (self *PipelineConfig) makeResource(name string, section toml.Primitive) (
+	interface{}, error) {
+
+	// The resource type defaults to the resource name, as it does for plugins.
+	typ := name
+	if typVal, ok := section.(map[string]interface{})["type"]; ok {
+		if typ, ok = typVal.(string); !ok {
+			return nil, errors.New("`type` must be a string")
+		}
+	}
+	factory, ok := AvailableResources[typ]
+	if !ok {
+		return nil, fmt.Errorf("no registered resource type '%s'", typ)
+	}
+	resource := factory()
+	plugin, ok := resource.(Plugin)
+	if !ok {
+		return nil, fmt.Errorf("resource type '%s' doesn't implement Init", typ)
+	}
+	if wantsName, ok := resource.(WantsName); ok {
+		wantsName.SetName(name)
+	}
+	if wantsPConfig, ok := resource.(WantsPipelineConfig); ok {
+		wantsPConfig.SetPipelineConfig(self)
+	}
+
+	var config interface{} = PluginConfig{}
+	if hasConfigStruct, ok := resource.(HasConfigStruct); ok {
+		config = hasConfigStruct.ConfigStruct()
+		ignore := map[string]interface{}{"type": true}
+		if err := toml.PrimitiveDecodeStrict(section, config, ignore); err != nil {
+			matches := unknownOptionRegex.FindStringSubmatch(err.Error())
+			if len(matches) == 2 {
+				err = fmt.Errorf("unknown config setting: %s", matches[1])
+			}
+			return nil, err
+		}
+	} else if err := toml.PrimitiveDecode(section, config); err != nil {
+		return nil, err
+	}
+
+	if err := plugin.Init(config); err != nil {
+		return nil, fmt.Errorf("initialization failed: %s", err)
+	}
+	return resource, nil
+}
+
+// Returns the shared resource defined in the `[resources]` config section
+// under the given name. Plugins are expected to type assert the returned value
+// to the resource type they support.
+func (self *PipelineConfig) Resource(name string) (resource interface{}, err error) {
+	self.resourcesLock.RLock()
+	defer self.resourcesLock.RUnlock()
+	resource, ok := self.resources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown resource '%s'", name)
+	}
+	return resource, nil
+}
+
+// Stops all of the resources that need to be, called at shutdown.
+func (self *PipelineConfig) stopResources() {
+	self.resourcesLock.Lock()
+	defer self.resourcesLock.Unlock()
+	for name, resource := range self.resources {
+		if stopper, ok := resource.(NeedsStopping); ok {
+			LogInfo.Printf("Stopping resource '%s'", name)
+			stopper.Stop()
+		}
+	}
+	self.resources = make(map[string]interface{})
+}
@@ -0,0 +1,103 @@
+// +build linux darwin freebsd
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+func udpSockaddr(family int, addr *net.UDPAddr) (syscall.Sockaddr, error) {
+	if family == syscall.AF_INET {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		if addr.IP != nil {
+			copy(sa.Addr[:], addr.IP.To4())
+		}
+		return sa, nil
+	}
+	sa := &syscall.SockaddrInet6{Port: addr.Port}
+	if addr.IP != nil {
+		copy(sa.Addr[:], addr.IP.To16())
+	}
+	if addr.Zone != "" {
+		ifi, err := net.InterfaceByName(addr.Zone)
+		if err != nil {
+			return nil, err
+		}
+		sa.ZoneId = uint32(ifi.Index)
+	}
+	return sa, nil
+}
+
+// listenUDPReusePort creates a UDP socket with SO_REUSEPORT set. The net
+// package has no way to set socket options before binding, so the socket is
+// set up by hand and then handed over with net.FilePacketConn.
+func listenUDPReusePort(network string, addr *net.UDPAddr) (*net.UDPConn, error) {
+	var family int
+	switch {
+	case network == "udp4":
+		family = syscall.AF_INET
+	case network == "udp6":
+		family = syscall.AF_INET6
+	case network != "udp":
+		return nil, net.UnknownNetworkError(network)
+	case addr.IP != nil && addr.IP.To4() != nil && !addr.IP.IsUnspecified():
+		family = syscall.AF_INET
+	default:
+		family = syscall.AF_INET6
+	}
+
+	fd, err := syscall.Socket(family, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil && family == syscall.AF_INET6 && network == "udp" {
+		// No IPv6 support, fall back to IPv4 like the net package does.
+		family = syscall.AF_INET
+		fd, err = syscall.Socket(family, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	}
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "udp-reuseport")
+	defer f.Close()
+
+	err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1)
+	if err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if family == syscall.AF_INET6 {
+		// Only "udp6" is restricted to IPv6, "udp" accepts both.
+		v6only := 0
+		if network == "udp6" {
+			v6only = 1
+		}
+		err = syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_V6ONLY,
+			v6only)
+		if err != nil {
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	sa, err := udpSockaddr(family, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Bind(fd, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+	conn, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
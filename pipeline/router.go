@@ -68,6 +68,7 @@ type messageRouter struct {
 	fMatcherMap map[string]*MatchRunner
 	oMatcherMap map[string]*MatchRunner
 	abortChan   chan struct{}
+	accountant  *usageAccountant
 }
 
 // Creates and returns a (not yet started) Heka message router.
@@ -181,6 +182,9 @@ func (self *messageRouter) Start() {
 				}
 				pack.diagnostics.Reset()
 				atomic.AddInt64(&self.processMessageCount, 1)
+				if self.accountant != nil {
+					self.accountant.count("", pack)
+				}
 				for _, matcher = range self.fMatchers {
 					if matcher != nil {
 						atomic.AddInt32(&pack.RefCount, 1)
@@ -225,6 +229,14 @@ type MatchRunner struct {
 	bufFeeder     *BufferFeeder
 	globals       *GlobalConfigStruct
 	retry         *RetryHelper
+	sampler       *outputSampler
+	debugBuf      *debugBuffer
+	accountant    *usageAccountant
+	// The *splitSide dividing matched messages with a canary, if any.
+	split atomic.Value
+	// Messages are only delivered while this instance leads the election,
+	// if set.
+	leadership *LeaderElection
 }
 
 // Creates and returns a new MatchRunner if possible, or a relevant error if
@@ -333,7 +345,26 @@ func (mr *MatchRunner) run(sampleDenom int) {
 			counter++
 		}
 
+		if match && mr.leadership != nil && !mr.leadership.IsLeader() {
+			match = false
+		}
+		if match && mr.sampler != nil && !mr.sampler.keep(pack.Message) {
+			match = false
+		}
 		if match {
+			if side, _ := mr.split.Load().(*splitSide); side != nil &&
+				!side.keep(pack.Message) {
+				match = false
+			}
+		}
+
+		if match {
+			if mr.debugBuf != nil {
+				mr.debugBuf.add(pack)
+			}
+			if mr.accountant != nil {
+				mr.accountant.count(mr.pluginRunner.Name(), pack)
+			}
 			pack.diagnostics.AddStamp(mr.pluginRunner)
 			err := mr.deliver(pack)
 			if err != nil {
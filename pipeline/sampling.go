@@ -0,0 +1,179 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// A sampling rule for an output. Messages that match an output's
+// message_matcher are tested against its rules in order, and the first rule
+// that matches decides which fraction of them is passed on to the output.
+// Messages matching none of the rules are always passed on.
+type SamplingRule struct {
+	Matcher string `toml:"message_matcher"`
+	// Fraction of the matching messages to keep, from 0 (drop all) to 1
+	// (keep all).
+	Rate float64
+}
+
+type samplingRule struct {
+	// Accessed atomically.
+	kept    int64
+	dropped int64
+
+	matcher string
+	spec    *message.MatcherSpecification
+	rate    float64
+	// Accumulates `rate` per message, a message is kept each time it
+	// reaches 1. This spreads the kept messages evenly instead of relying on
+	// chance.
+	credit float64
+}
+
+// Applies an output's sampling rules. keep is only called from the output's
+// MatchRunner goroutine, the counters can be read from anywhere.
+type outputSampler struct {
+	rules []*samplingRule
+	// Counts as of the last summary message.
+	lastKept    []int64
+	lastDropped []int64
+}
+
+func newOutputSampler(rules []SamplingRule) (*outputSampler, error) {
+	sampler := &outputSampler{
+		rules:       make([]*samplingRule, len(rules)),
+		lastKept:    make([]int64, len(rules)),
+		lastDropped: make([]int64, len(rules)),
+	}
+	for i, rule := range rules {
+		if rule.Matcher == "" {
+			return nil, fmt.Errorf("sampling rule %d is missing a message_matcher", i)
+		}
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return nil, fmt.Errorf("sampling rule %d: rate must be between 0 and 1", i)
+		}
+		spec, err := message.CreateMatcherSpecification(rule.Matcher)
+		if err != nil {
+			return nil, fmt.Errorf("sampling rule %d: %s", i, err)
+		}
+		sampler.rules[i] = &samplingRule{
+			matcher: rule.Matcher,
+			spec:    spec,
+			rate:    rule.Rate,
+		}
+	}
+	return sampler, nil
+}
+
+// Returns whether the message should be passed on to the output.
+func (s *outputSampler) keep(msg *message.Message) bool {
+	for _, rule := range s.rules {
+		if !rule.spec.Match(msg) {
+			continue
+		}
+		rule.credit += rule.rate
+		if rule.credit >= 1 {
+			rule.credit--
+			atomic.AddInt64(&rule.kept, 1)
+			return true
+		}
+		atomic.AddInt64(&rule.dropped, 1)
+		return false
+	}
+	return true
+}
+
+// Returns the total number of messages dropped by the sampling rules.
+func (s *outputSampler) droppedCount() (total int64) {
+	for _, rule := range s.rules {
+		total += atomic.LoadInt64(&rule.dropped)
+	}
+	return
+}
+
+// Populates a "heka.sampling-summary" message with the number of messages
+// each rule kept and dropped since the previous summary. Returns false if
+// there's nothing to report.
+func (s *outputSampler) summary(msg *message.Message) bool {
+	kept := make([]int64, len(s.rules))
+	dropped := make([]int64, len(s.rules))
+	active := false
+	for i, rule := range s.rules {
+		k := atomic.LoadInt64(&rule.kept)
+		d := atomic.LoadInt64(&rule.dropped)
+		kept[i], dropped[i] = k-s.lastKept[i], d-s.lastDropped[i]
+		s.lastKept[i], s.lastDropped[i] = k, d
+		if kept[i] != 0 || dropped[i] != 0 {
+			active = true
+		}
+	}
+	if !active {
+		return false
+	}
+	msg.SetType("heka.sampling-summary")
+	matchers := message.NewFieldInit("RuleMatcher", message.Field_STRING, "")
+	keptField := message.NewFieldInit("KeptCount", message.Field_INTEGER, "count")
+	droppedField := message.NewFieldInit("DroppedCount", message.Field_INTEGER, "count")
+	for i, rule := range s.rules {
+		matchers.AddValue(rule.matcher)
+		keptField.AddValue(kept[i])
+		droppedField.AddValue(dropped[i])
+	}
+	msg.AddField(matchers)
+	msg.AddField(keptField)
+	msg.AddField(droppedField)
+	return true
+}
+
+// Injects a sampling summary message for the output every interval until
+// stopChan is closed.
+func (foRunner *foRunner) samplingSummaryLoop(sampler *outputSampler,
+	interval time.Duration, stopChan chan bool) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+		pack, err := foRunner.pConfig.PipelinePack(0)
+		if err != nil {
+			foRunner.LogError(err)
+			continue
+		}
+		if !sampler.summary(pack.Message) {
+			pack.recycle()
+			continue
+		}
+		pack.Message.SetLogger(HEKA_DAEMON)
+		message.NewStringField(pack.Message, "Output", foRunner.name)
+		if err = pack.EncodeMsgBytes(); err != nil {
+			foRunner.LogError(fmt.Errorf("encoding heka.sampling-summary message: %s",
+				err))
+			pack.recycle()
+			continue
+		}
+		select {
+		case foRunner.pConfig.router.InChan() <- pack:
+		case <-stopChan:
+			pack.recycle()
+			return
+		}
+	}
+}
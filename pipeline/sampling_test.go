@@ -0,0 +1,98 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"github.com/mozilla-services/heka/message"
+	ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SamplingSpec(c gs.Context) {
+	rules := []SamplingRule{
+		{Matcher: "Severity <= 3", Rate: 1},
+		{Matcher: "Severity == 7", Rate: 0.01},
+		{Matcher: "Type == 'noise'", Rate: 0},
+	}
+	msg := ts.GetTestMessage()
+
+	keepCount := func(sampler *outputSampler, n int) (kept int) {
+		for i := 0; i < n; i++ {
+			if sampler.keep(msg) {
+				kept++
+			}
+		}
+		return
+	}
+
+	c.Specify("An output sampler", func() {
+		sampler, err := newOutputSampler(rules)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("keeps everything matching a rate 1 rule", func() {
+			msg.SetSeverity(3)
+			c.Expect(keepCount(sampler, 100), gs.Equals, 100)
+		})
+
+		c.Specify("keeps the configured fraction", func() {
+			msg.SetSeverity(7)
+			c.Expect(keepCount(sampler, 1000), gs.Equals, 10)
+			c.Expect(sampler.droppedCount(), gs.Equals, int64(990))
+		})
+
+		c.Specify("drops everything matching a rate 0 rule", func() {
+			msg.SetSeverity(6)
+			msg.SetType("noise")
+			c.Expect(keepCount(sampler, 10), gs.Equals, 0)
+		})
+
+		c.Specify("keeps messages matching no rule", func() {
+			msg.SetSeverity(6)
+			c.Expect(keepCount(sampler, 10), gs.Equals, 10)
+			c.Expect(sampler.droppedCount(), gs.Equals, int64(0))
+		})
+
+		c.Specify("summarizes the counts since the last summary", func() {
+			msg.SetSeverity(7)
+			keepCount(sampler, 200)
+			summary := new(message.Message)
+			c.Expect(sampler.summary(summary), gs.IsTrue)
+			c.Expect(summary.GetType(), gs.Equals, "heka.sampling-summary")
+			dropped := summary.FindFirstField("DroppedCount").GetValueInteger()
+			c.Expect(len(dropped), gs.Equals, 3)
+			c.Expect(dropped[1], gs.Equals, int64(198))
+			kept := summary.FindFirstField("KeptCount").GetValueInteger()
+			c.Expect(kept[1], gs.Equals, int64(2))
+
+			c.Expect(sampler.summary(new(message.Message)), gs.IsFalse)
+		})
+	})
+
+	c.Specify("Invalid sampling rules are rejected", func() {
+		_, err := newOutputSampler([]SamplingRule{{Matcher: "TRUE", Rate: 1.5}})
+		c.Expect(err, gs.Not(gs.IsNil))
+		_, err = newOutputSampler([]SamplingRule{{Rate: 0.5}})
+		c.Expect(err, gs.Not(gs.IsNil))
+		_, err = newOutputSampler([]SamplingRule{{Matcher: "Bogus ==", Rate: 0.5}})
+		c.Expect(err, gs.Not(gs.IsNil))
+	})
+
+	c.Specify("Sampling rules are only accepted by outputs", func() {
+		config := CommonFOConfig{Matcher: "TRUE", Sampling: rules}
+		_, err := NewFORunner("counter", new(CounterFilter), config, "CounterFilter", 10)
+		c.Expect(err, gs.Not(gs.IsNil))
+		oRunner, err := NewFORunner("stopping", new(StoppingOutput), config,
+			"StoppingOutput", 10)
+		c.Expect(err, gs.IsNil)
+		c.Expect(oRunner.matcher.sampler, gs.Not(gs.IsNil))
+	})
+}
@@ -0,0 +1,517 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ServiceDiscoveryConfig struct {
+	// Where instances are registered and discovered: "consul", "etcd", or
+	// "dns" to discover them through DNS SRV records.
+	Backend string
+	// Base URL of the Consul or etcd HTTP API. Defaults to the backend's
+	// local agent.
+	Address string
+	// Name the instances are registered under, or the SRV record name for
+	// DNS discovery.
+	Service string
+	// Whether this hekad instance registers itself.
+	Register bool
+	// Role this instance is registered with, e.g. "agent" or "aggregator".
+	// With DNS discovery, the role of the discovered instances.
+	Role string
+	// Endpoints this instance advertises, as name to "host:port" address,
+	// e.g. "tcp" to the address of a TcpInput.
+	Endpoints map[string]string
+	// Seconds the registration stays valid without being refreshed.
+	Ttl uint
+	// Seconds between refreshes of the registration and the discovered
+	// instances.
+	RefreshInterval uint `toml:"refresh_interval"`
+	// Identity of this instance. Defaults to the hostname.
+	NodeId string `toml:"node_id"`
+}
+
+// A registered hekad instance.
+type ServicePeer struct {
+	Node          string            `json:"node"`
+	Role          string            `json:"role,omitempty"`
+	ConfigVersion int               `json:"config_version,omitempty"`
+	Endpoints     map[string]string `json:"endpoints"`
+}
+
+// Registry of hekad instances kept by a service discovery backend.
+type discoveryBackend interface {
+	// Registers or refreshes the registration of this instance.
+	register(self *ServicePeer) error
+	// Removes the registration of this instance.
+	deregister(self *ServicePeer) error
+	// Returns the registered instances.
+	peers() ([]*ServicePeer, error)
+}
+
+// Shared resource registering this hekad instance with its role, endpoints
+// and config version in Consul or etcd, and discovering the registered
+// instances, e.g. so agents' TcpOutputs can find the aggregators to send to.
+type ServiceDiscovery struct {
+	name     string
+	pConfig  *PipelineConfig
+	conf     *ServiceDiscoveryConfig
+	backend  discoveryBackend
+	stopChan chan struct{}
+	lock     sync.RWMutex
+	peerList []*ServicePeer
+	// Incremented each time the discovered instances change.
+	generation uint64
+}
+
+func (sd *ServiceDiscovery) SetName(name string) {
+	sd.name = name
+}
+
+func (sd *ServiceDiscovery) SetPipelineConfig(pConfig *PipelineConfig) {
+	sd.pConfig = pConfig
+}
+
+func (sd *ServiceDiscovery) ConfigStruct() interface{} {
+	return &ServiceDiscoveryConfig{
+		Service:         "heka",
+		Ttl:             30,
+		RefreshInterval: 10,
+	}
+}
+
+func (sd *ServiceDiscovery) Init(config interface{}) error {
+	sd.conf = config.(*ServiceDiscoveryConfig)
+	if sd.conf.Service == "" {
+		return errors.New("service must be set")
+	}
+	if sd.conf.RefreshInterval == 0 {
+		return errors.New("refresh_interval must be greater than 0")
+	}
+	if sd.conf.Register && sd.conf.Ttl <= sd.conf.RefreshInterval {
+		return errors.New("ttl must be longer than refresh_interval")
+	}
+	if sd.conf.NodeId == "" && sd.pConfig != nil {
+		sd.conf.NodeId = sd.pConfig.hostname
+	}
+	if sd.conf.Register && sd.conf.NodeId == "" {
+		return errors.New("node_id must be set")
+	}
+	for name, addr := range sd.conf.Endpoints {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("endpoint '%s': %s", name, err)
+		}
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	switch sd.conf.Backend {
+	case "consul":
+		if sd.conf.Address == "" {
+			sd.conf.Address = "http://127.0.0.1:8500"
+		}
+		sd.backend = &consulRegistry{client: client, conf: sd.conf}
+	case "etcd":
+		if sd.conf.Address == "" {
+			sd.conf.Address = "http://127.0.0.1:2379"
+		}
+		sd.backend = &etcdRegistry{client: client, conf: sd.conf}
+	case "dns":
+		if sd.conf.Register {
+			return errors.New("instances can't register themselves through DNS")
+		}
+		sd.backend = &dnsRegistry{conf: sd.conf}
+	default:
+		return fmt.Errorf("unknown backend '%s', must be 'consul', 'etcd' or 'dns'",
+			sd.conf.Backend)
+	}
+	sd.conf.Address = strings.TrimRight(sd.conf.Address, "/")
+
+	sd.refresh()
+	sd.stopChan = make(chan struct{})
+	go sd.refreshLoop(sd.stopChan)
+	return nil
+}
+
+// Returns the description this instance registers.
+func (sd *ServiceDiscovery) self() *ServicePeer {
+	peer := &ServicePeer{
+		Node:      sd.conf.NodeId,
+		Role:      sd.conf.Role,
+		Endpoints: sd.conf.Endpoints,
+	}
+	if sd.pConfig != nil {
+		if lastGood, _ := sd.pConfig.ConfigVersions(); lastGood != nil {
+			peer.ConfigVersion = lastGood.Version
+		}
+	}
+	return peer
+}
+
+// Refreshes the registration and the discovered instances.
+func (sd *ServiceDiscovery) refresh() {
+	if sd.conf.Register {
+		if err := sd.backend.register(sd.self()); err != nil {
+			LogError.Printf("Service discovery '%s': can't register: %s", sd.name, err)
+		}
+	}
+	peers, err := sd.backend.peers()
+	if err != nil {
+		// Keep the last known instances rather than dropping all of them.
+		LogError.Printf("Service discovery '%s': %s", sd.name, err)
+		return
+	}
+	sort.Sort(peersByNode(peers))
+	sd.lock.Lock()
+	if !samePeers(sd.peerList, peers) {
+		sd.peerList = peers
+		sd.generation++
+	}
+	sd.lock.Unlock()
+}
+
+func (sd *ServiceDiscovery) refreshLoop(stopChan chan struct{}) {
+	ticker := time.NewTicker(time.Duration(sd.conf.RefreshInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sd.refresh()
+		case <-stopChan:
+			if sd.conf.Register {
+				if err := sd.backend.deregister(sd.self()); err != nil {
+					LogError.Printf("Service discovery '%s': can't deregister: %s",
+						sd.name, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+func (sd *ServiceDiscovery) Stop() {
+	if sd.stopChan != nil {
+		close(sd.stopChan)
+		sd.stopChan = nil
+	}
+}
+
+// Returns the discovered instances with the given role, all of them if the
+// role is empty.
+func (sd *ServiceDiscovery) Peers(role string) []*ServicePeer {
+	sd.lock.RLock()
+	defer sd.lock.RUnlock()
+	peers := make([]*ServicePeer, 0, len(sd.peerList))
+	for _, peer := range sd.peerList {
+		if role == "" || peer.Role == role {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// Returns the generation of the discovered instances, which changes whenever
+// they do.
+func (sd *ServiceDiscovery) Generation() uint64 {
+	sd.lock.RLock()
+	defer sd.lock.RUnlock()
+	return sd.generation
+}
+
+// Returns the sorted addresses of the named endpoint of the discovered
+// instances with the given role, and the generation of the discovered
+// instances, which changes whenever they do.
+func (sd *ServiceDiscovery) Addresses(role, endpoint string) ([]string, uint64) {
+	sd.lock.RLock()
+	defer sd.lock.RUnlock()
+	var addrs []string
+	for _, peer := range sd.peerList {
+		if role != "" && peer.Role != role {
+			continue
+		}
+		if addr, ok := peer.Endpoints[endpoint]; ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs, sd.generation
+}
+
+// Returns the index of the address a node should start with, spreading the
+// nodes over the addresses.
+func PreferredAddress(node string, addrs []string) int {
+	if len(addrs) == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(node))
+	return int(h.Sum32() % uint32(len(addrs)))
+}
+
+type peersByNode []*ServicePeer
+
+func (p peersByNode) Len() int           { return len(p) }
+func (p peersByNode) Less(i, j int) bool { return p[i].Node < p[j].Node }
+func (p peersByNode) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func samePeers(a, b []*ServicePeer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Node != b[i].Node || a[i].Role != b[i].Role ||
+			a[i].ConfigVersion != b[i].ConfigVersion ||
+			len(a[i].Endpoints) != len(b[i].Endpoints) {
+			return false
+		}
+		for name, addr := range a[i].Endpoints {
+			if b[i].Endpoints[name] != addr {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the named ServiceDiscovery resource.
+func (self *PipelineConfig) ServiceDiscovery(name string) (*ServiceDiscovery, error) {
+	resource, err := self.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	discovery, ok := resource.(*ServiceDiscovery)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a ServiceDiscovery resource", name)
+	}
+	return discovery, nil
+}
+
+// Registers instances as Consul services with a TTL health check, the role,
+// config version and endpoints being stored as tags, e.g.
+// "endpoint:tcp=10.0.0.1:5565".
+type consulRegistry struct {
+	client *http.Client
+	conf   *ServiceDiscoveryConfig
+}
+
+func (r *consulRegistry) serviceId(self *ServicePeer) string {
+	return r.conf.Service + "-" + self.Node
+}
+
+func (r *consulRegistry) register(self *ServicePeer) error {
+	tags := []string{"node:" + self.Node}
+	if self.Role != "" {
+		tags = append(tags, "role:"+self.Role)
+	}
+	if self.ConfigVersion != 0 {
+		tags = append(tags, "config:"+strconv.Itoa(self.ConfigVersion))
+	}
+	for name, addr := range self.Endpoints {
+		tags = append(tags, "endpoint:"+name+"="+addr)
+	}
+	sort.Strings(tags)
+	body, _ := json.Marshal(map[string]interface{}{
+		"ID":    r.serviceId(self),
+		"Name":  r.conf.Service,
+		"Tags":  tags,
+		"Check": map[string]string{"TTL": fmt.Sprintf("%ds", r.conf.Ttl)},
+	})
+	status, data, err := leaseRequest(r.client, "PUT",
+		r.conf.Address+"/v1/agent/service/register", "application/json", string(body))
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("registering service: %d %s", status, data)
+	}
+	status, data, err = leaseRequest(r.client, "PUT", r.conf.Address+
+		"/v1/agent/check/pass/service:"+r.serviceId(self), "", "")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("passing health check: %d %s", status, data)
+	}
+	return nil
+}
+
+func (r *consulRegistry) deregister(self *ServicePeer) error {
+	_, _, err := leaseRequest(r.client, "PUT", r.conf.Address+
+		"/v1/agent/service/deregister/"+r.serviceId(self), "", "")
+	return err
+}
+
+func (r *consulRegistry) peers() ([]*ServicePeer, error) {
+	status, data, err := leaseRequest(r.client, "GET", fmt.Sprintf(
+		"%s/v1/health/service/%s?passing", r.conf.Address,
+		url.QueryEscape(r.conf.Service)), "", "")
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("listing services: %d %s", status, data)
+	}
+	var entries []struct {
+		Service struct {
+			ID   string
+			Tags []string
+		}
+	}
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("listing services: %s", err)
+	}
+	peers := make([]*ServicePeer, 0, len(entries))
+	for _, entry := range entries {
+		peer := &ServicePeer{
+			Node:      entry.Service.ID,
+			Endpoints: make(map[string]string),
+		}
+		for _, tag := range entry.Service.Tags {
+			switch {
+			case strings.HasPrefix(tag, "node:"):
+				peer.Node = tag[len("node:"):]
+			case strings.HasPrefix(tag, "role:"):
+				peer.Role = tag[len("role:"):]
+			case strings.HasPrefix(tag, "config:"):
+				peer.ConfigVersion, _ = strconv.Atoi(tag[len("config:"):])
+			case strings.HasPrefix(tag, "endpoint:"):
+				if i := strings.Index(tag, "="); i > 0 {
+					peer.Endpoints[tag[len("endpoint:"):i]] = tag[i+1:]
+				}
+			}
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Registers instances as JSON values of the keys in an etcd directory named
+// after the service, expiring after the TTL.
+type etcdRegistry struct {
+	client *http.Client
+	conf   *ServiceDiscoveryConfig
+}
+
+func (r *etcdRegistry) dirURL() string {
+	return fmt.Sprintf("%s/v2/keys/heka/services/%s", r.conf.Address,
+		url.QueryEscape(r.conf.Service))
+}
+
+func (r *etcdRegistry) register(self *ServicePeer) error {
+	value, _ := json.Marshal(self)
+	form := url.Values{
+		"value": {string(value)},
+		"ttl":   {fmt.Sprint(r.conf.Ttl)},
+	}
+	status, data, err := leaseRequest(r.client, "PUT", r.dirURL()+"/"+
+		url.QueryEscape(self.Node), "application/x-www-form-urlencoded", form.Encode())
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated {
+		return fmt.Errorf("registering: %d %s", status, data)
+	}
+	return nil
+}
+
+func (r *etcdRegistry) deregister(self *ServicePeer) error {
+	_, _, err := leaseRequest(r.client, "DELETE", r.dirURL()+"/"+
+		url.QueryEscape(self.Node), "", "")
+	return err
+}
+
+func (r *etcdRegistry) peers() ([]*ServicePeer, error) {
+	status, data, err := leaseRequest(r.client, "GET", r.dirURL(), "", "")
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		// Nobody registered yet.
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("listing instances: %d %s", status, data)
+	}
+	var resp struct {
+		Node struct {
+			Nodes []struct {
+				Key   string
+				Value string
+			}
+		}
+	}
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("listing instances: %s", err)
+	}
+	peers := make([]*ServicePeer, 0, len(resp.Node.Nodes))
+	for _, node := range resp.Node.Nodes {
+		peer := new(ServicePeer)
+		if err = json.Unmarshal([]byte(node.Value), peer); err != nil {
+			LogError.Printf("Service discovery: invalid instance %s: %s", node.Key, err)
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers, nil
+}
+
+// Discovers instances through the SRV records of the service name, e.g.
+// "_heka._tcp.example.com", each target becoming an instance with a "tcp"
+// endpoint. DNS doesn't carry roles, so use one name per role, the
+// instances being given the configured role.
+type dnsRegistry struct {
+	conf *ServiceDiscoveryConfig
+}
+
+func (r *dnsRegistry) register(self *ServicePeer) error {
+	return errors.New("not supported")
+}
+
+func (r *dnsRegistry) deregister(self *ServicePeer) error {
+	return nil
+}
+
+func (r *dnsRegistry) peers() ([]*ServicePeer, error) {
+	_, records, err := net.LookupSRV("", "", r.conf.Service)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]*ServicePeer, len(records))
+	for i, record := range records {
+		target := strings.TrimSuffix(record.Target, ".")
+		addr := net.JoinHostPort(target, strconv.Itoa(int(record.Port)))
+		peers[i] = &ServicePeer{
+			Node:      addr,
+			Role:      r.conf.Role,
+			Endpoints: map[string]string{"tcp": addr},
+		}
+	}
+	return peers, nil
+}
+
+func init() {
+	RegisterResource("ServiceDiscovery", func() interface{} {
+		return new(ServiceDiscovery)
+	})
+}
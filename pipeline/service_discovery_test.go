@@ -0,0 +1,193 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Minimal etcd v2 keys API for one directory, without expiry.
+type fakeEtcdDir struct {
+	lock   sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeEtcdDir) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	const dir = "/v2/keys/heka/services/heka"
+	switch req.Method {
+	case "PUT":
+		f.values[strings.TrimPrefix(req.URL.Path, dir+"/")] = req.FormValue("value")
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		delete(f.values, strings.TrimPrefix(req.URL.Path, dir+"/"))
+	case "GET":
+		if len(f.values) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		type node struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		}
+		var resp struct {
+			Node struct {
+				Nodes []node `json:"nodes"`
+			} `json:"node"`
+		}
+		for key, value := range f.values {
+			resp.Node.Nodes = append(resp.Node.Nodes, node{dir + "/" + key, value})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// Minimal Consul agent service API, every registered service passing.
+type fakeConsulServices struct {
+	lock     sync.Mutex
+	services map[string][]string
+	passed   map[string]bool
+}
+
+func (f *fakeConsulServices) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	path := req.URL.Path
+	switch {
+	case path == "/v1/agent/service/register":
+		var service struct {
+			ID   string
+			Tags []string
+		}
+		json.NewDecoder(req.Body).Decode(&service)
+		f.services[service.ID] = service.Tags
+	case strings.HasPrefix(path, "/v1/agent/check/pass/service:"):
+		f.passed[strings.TrimPrefix(path, "/v1/agent/check/pass/service:")] = true
+	case strings.HasPrefix(path, "/v1/agent/service/deregister/"):
+		delete(f.services, strings.TrimPrefix(path, "/v1/agent/service/deregister/"))
+	case path == "/v1/health/service/heka":
+		type service struct {
+			ID   string
+			Tags []string
+		}
+		var entries []struct{ Service service }
+		for id, tags := range f.services {
+			entries = append(entries, struct{ Service service }{service{id, tags}})
+		}
+		json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func ServiceDiscoverySpec(c gs.Context) {
+	newDiscovery := func(backend, address, nodeId, role string,
+		endpoints map[string]string) (*ServiceDiscovery, error) {
+
+		sd := new(ServiceDiscovery)
+		sd.SetName("discovery")
+		config := sd.ConfigStruct().(*ServiceDiscoveryConfig)
+		config.Backend = backend
+		config.Address = address
+		config.NodeId = nodeId
+		config.Role = role
+		config.Endpoints = endpoints
+		config.Register = endpoints != nil
+		return sd, sd.Init(config)
+	}
+
+	c.Specify("A ServiceDiscovery resource", func() {
+		c.Specify("rejects an unknown backend", func() {
+			_, err := newDiscovery("zookeeper", "", "node1", "", nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects registration through DNS", func() {
+			_, err := newDiscovery("dns", "", "node1", "agent",
+				map[string]string{"tcp": "10.0.0.1:5565"})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects invalid endpoint addresses", func() {
+			_, err := newDiscovery("etcd", "", "node1", "agent",
+				map[string]string{"tcp": "10.0.0.1"})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		registersAndDiscovers := func(backend string, server http.Handler) {
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			agg1, err := newDiscovery(backend, ts.URL, "agg1", "aggregator",
+				map[string]string{"tcp": "10.0.0.1:5565", "http": "10.0.0.1:8080"})
+			c.Assume(err, gs.IsNil)
+			defer agg1.Stop()
+			agg2, err := newDiscovery(backend, ts.URL, "agg2", "aggregator",
+				map[string]string{"tcp": "10.0.0.2:5565"})
+			c.Assume(err, gs.IsNil)
+			agent, err := newDiscovery(backend, ts.URL, "agent1", "agent",
+				map[string]string{})
+			c.Assume(err, gs.IsNil)
+			defer agent.Stop()
+
+			c.Expect(len(agent.Peers("")), gs.Equals, 3)
+			peers := agent.Peers("aggregator")
+			c.Assume(len(peers), gs.Equals, 2)
+			c.Expect(peers[0].Node, gs.Equals, "agg1")
+			c.Expect(peers[0].Role, gs.Equals, "aggregator")
+			c.Expect(peers[0].Endpoints["http"], gs.Equals, "10.0.0.1:8080")
+			addrs, gen := agent.Addresses("aggregator", "tcp")
+			c.Expect(strings.Join(addrs, ","), gs.Equals, "10.0.0.1:5565,10.0.0.2:5565")
+
+			// The instances are only re-read on refresh.
+			agent.refresh()
+			c.Expect(agent.Generation(), gs.Equals, gen)
+			agg2.Stop()
+			agg2.backend.deregister(agg2.self())
+			agent.refresh()
+			c.Expect(agent.Generation() != gen, gs.IsTrue)
+			addrs, _ = agent.Addresses("aggregator", "tcp")
+			c.Expect(strings.Join(addrs, ","), gs.Equals, "10.0.0.1:5565")
+		}
+
+		c.Specify("registers and discovers instances through etcd", func() {
+			registersAndDiscovers("etcd", &fakeEtcdDir{values: make(map[string]string)})
+		})
+
+		c.Specify("registers and discovers instances through consul", func() {
+			fake := &fakeConsulServices{
+				services: make(map[string][]string),
+				passed:   make(map[string]bool),
+			}
+			registersAndDiscovers("consul", fake)
+			c.Expect(fake.passed["heka-agg1"], gs.IsTrue)
+		})
+	})
+
+	c.Specify("PreferredAddress spreads nodes over the addresses", func() {
+		addrs := []string{"a", "b", "c"}
+		seen := make(map[int]bool)
+		for _, node := range []string{"n1", "n2", "n3", "n4", "n5", "n6", "n7"} {
+			i := PreferredAddress(node, addrs)
+			c.Expect(i, gs.Equals, PreferredAddress(node, addrs))
+			seen[i] = true
+		}
+		c.Expect(len(seen) > 1, gs.IsTrue)
+	})
+}
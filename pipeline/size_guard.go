@@ -0,0 +1,78 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Enforces an output's max_record_size and max_request_size, for sinks that
+// reject records or requests over a hard limit. The counters are accessed
+// atomically.
+type sizeGuard struct {
+	truncatedCount int64
+	droppedCount   int64
+	splitCount     int64
+
+	maxRecordSize  int
+	maxRequestSize int
+	truncate       bool
+}
+
+func newSizeGuard(config CommonFOConfig) (*sizeGuard, error) {
+	guard := &sizeGuard{
+		maxRecordSize:  int(config.MaxRecordSize),
+		maxRequestSize: int(config.MaxRequestSize),
+	}
+	switch config.OversizePolicy {
+	case "", "drop":
+	case "truncate":
+		guard.truncate = true
+	default:
+		return nil, fmt.Errorf("oversize_policy must be 'drop' or 'truncate', got '%s'",
+			config.OversizePolicy)
+	}
+	if guard.maxRequestSize > 0 && guard.maxRecordSize > guard.maxRequestSize {
+		return nil, fmt.Errorf("max_record_size %d is larger than max_request_size %d",
+			guard.maxRecordSize, guard.maxRequestSize)
+	}
+	return guard, nil
+}
+
+// Returns the record, truncated to the max_record_size if it's larger and
+// the policy is to truncate. Records that are dropped return a data error.
+func (g *sizeGuard) checkRecord(record []byte) ([]byte, error) {
+	if g.maxRecordSize == 0 || len(record) <= g.maxRecordSize {
+		return record, nil
+	}
+	if g.truncate {
+		atomic.AddInt64(&g.truncatedCount, 1)
+		return record[:g.maxRecordSize], nil
+	}
+	atomic.AddInt64(&g.droppedCount, 1)
+	return nil, NewDataError("record of %d bytes exceeds max_record_size %d",
+		len(record), g.maxRecordSize)
+}
+
+// Returns whether a record can be added to a request without the request
+// going over max_request_size. If it can't, the output is expected to send
+// the request and start a new one with the record, which is counted as a
+// split. A record always fits in an empty request.
+func (g *sizeGuard) fitsRequest(requestSize, recordSize int) bool {
+	if g.maxRequestSize == 0 || requestSize == 0 ||
+		requestSize+recordSize <= g.maxRequestSize {
+		return true
+	}
+	atomic.AddInt64(&g.splitCount, 1)
+	return false
+}
@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package pipeline
+
+import (
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SizeGuardSpec(c gs.Context) {
+	c.Specify("A size guard", func() {
+		config := CommonFOConfig{MaxRecordSize: 4}
+
+		c.Specify("passes records within the limit", func() {
+			guard, err := newSizeGuard(config)
+			c.Assume(err, gs.IsNil)
+			record, err := guard.checkRecord([]byte("1234"))
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, "1234")
+		})
+
+		c.Specify("drops oversize records by default", func() {
+			guard, err := newSizeGuard(config)
+			c.Assume(err, gs.IsNil)
+			record, err := guard.checkRecord([]byte("12345"))
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorData)
+			c.Expect(record, gs.IsNil)
+			c.Expect(guard.droppedCount, gs.Equals, int64(1))
+		})
+
+		c.Specify("truncates oversize records", func() {
+			config.OversizePolicy = "truncate"
+			guard, err := newSizeGuard(config)
+			c.Assume(err, gs.IsNil)
+			record, err := guard.checkRecord([]byte("12345"))
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(record), gs.Equals, "1234")
+			c.Expect(guard.truncatedCount, gs.Equals, int64(1))
+		})
+
+		c.Specify("rejects unknown policies", func() {
+			config.OversizePolicy = "chunk"
+			_, err := newSizeGuard(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects records larger than requests", func() {
+			config.MaxRequestSize = 3
+			_, err := newSizeGuard(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("splits requests", func() {
+			config.MaxRequestSize = 10
+			guard, err := newSizeGuard(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(guard.fitsRequest(0, 4), gs.IsTrue)
+			c.Expect(guard.fitsRequest(6, 4), gs.IsTrue)
+			c.Expect(guard.fitsRequest(7, 4), gs.IsFalse)
+			c.Expect(guard.splitCount, gs.Equals, int64(1))
+		})
+	})
+
+	c.Specify("An output runner", func() {
+		config := CommonFOConfig{
+			Matcher:       "TRUE",
+			MaxRecordSize: 3,
+		}
+		pack := NewPipelinePack(nil)
+		pack.Message.SetPayload("12345")
+
+		c.Specify("enforces the max_record_size when encoding", func() {
+			oRunner, err := NewFORunner("output", new(StoppingOutput), config,
+				"StoppingOutput", 10)
+			c.Assume(err, gs.IsNil)
+			oRunner.encoder = new(_payloadEncoder)
+			_, err = oRunner.Encode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			oRunner.sizeGuard.truncate = true
+			output, err := oRunner.Encode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(output), gs.Equals, "123")
+		})
+
+		c.Specify("doesn't limit requests without a max_request_size", func() {
+			oRunner, err := NewFORunner("output", new(StoppingOutput), config,
+				"StoppingOutput", 10)
+			c.Assume(err, gs.IsNil)
+			c.Expect(oRunner.FitsRequest(1<<20, 1<<20), gs.IsTrue)
+		})
+
+		c.Specify("is the only runner with size limits", func() {
+			_, err := NewFORunner("counter", new(CounterFilter), config,
+				"CounterFilter", 10)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
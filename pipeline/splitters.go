@@ -254,6 +254,9 @@ func authenticateMessage(signers map[string]Signer, header *message.Header,
 		} else {
 			return false
 		}
+		if message.CheckHmacHash(header.GetHmacHashFunction()) != nil {
+			return false
+		}
 
 		var hm hash.Hash
 		switch header.GetHmacHashFunction() {
@@ -22,6 +22,7 @@ import (
 	"math"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mozilla-services/heka/message"
@@ -94,6 +95,20 @@ type StatAccumInputConfig struct {
 	// Don't emit values for inactive stats instead of sending 0 or in the case
 	// of gauges, sending the previous value. Defaults to false
 	DeleteIdleStats bool `toml:"delete_idle_stats"`
+
+	// Histogram bins to count timer values in. A timer uses the bins of the
+	// first entry whose Metric is a substring of its name.
+	Histogram []StatHistogram
+}
+
+// Histogram bins for the timers matching Metric.
+type StatHistogram struct {
+	// Substring of the timer names the bins apply to, empty matches all
+	// timers.
+	Metric string
+	// Ascending exclusive upper bounds of the bins, as in statsd. Values at
+	// or above the last bound are counted in an implicit "inf" bin.
+	Bins []float64
 }
 
 func (sm *StatAccumInput) ConfigStruct() interface{} {
@@ -136,9 +151,36 @@ func (sm *StatAccumInput) Init(config interface{}) error {
 			"One of either `EmitInPayload` or `EmitInFields` must be set to true.",
 		)
 	}
+	for _, h := range sm.config.Histogram {
+		if len(h.Bins) == 0 {
+			return fmt.Errorf("histogram for '%s' has no bins", h.Metric)
+		}
+		for i := 1; i < len(h.Bins); i++ {
+			if h.Bins[i] <= h.Bins[i-1] {
+				return fmt.Errorf("histogram bins for '%s' aren't ascending",
+					h.Metric)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the histogram bins for the named timer, or nil if it has none.
+func (sm *StatAccumInput) histogramBins(key string) []float64 {
+	for _, h := range sm.config.Histogram {
+		if strings.Contains(key, h.Metric) {
+			return h.Bins
+		}
+	}
 	return nil
 }
 
+// Returns the name of a histogram bin's metric, e.g. "bin_0_5".
+func histogramBinName(bound float64) string {
+	bin := strconv.FormatFloat(bound, 'f', -1, 64)
+	return "bin_" + strings.Replace(bin, ".", "_", -1)
+}
+
 // Listens on the Stat channel for stats generated internally by Heka.
 func (sm *StatAccumInput) Run(ir InputRunner, h PluginHelper) (err error) {
 	var (
@@ -263,17 +305,23 @@ func (sm *StatAccumInput) Flush() {
 	countPercentThreshold := len(sm.config.PercentThreshold)
 	for key, timings := range sm.timers {
 		timerNs := globalNs.Namespace(sm.config.TimerPrefix).Namespace(key)
-		var min, max, sum, mean, rate, thresholdBoundary float64
+		var min, max, sum, sumSquares, mean, median, stdDev, rate,
+			thresholdBoundary float64
 		meanPercentile := make([]float64, countPercentThreshold)
 		upperPercentile := make([]float64, countPercentThreshold)
+		sumPercentile := make([]float64, countPercentThreshold)
+		sumSquaresPercentile := make([]float64, countPercentThreshold)
 		count := len(timings)
 		if count > 0 {
 			sort.Float64s(timings)
 
 			cumulativeValues := make([]float64, count)
+			cumulativeSquares := make([]float64, count)
 			cumulativeValues[0] = timings[0]
+			cumulativeSquares[0] = timings[0] * timings[0]
 			for i := 1; i < count; i++ {
 				cumulativeValues[i] = timings[i] + cumulativeValues[i-1]
+				cumulativeSquares[i] = timings[i]*timings[i] + cumulativeSquares[i-1]
 			}
 
 			rate = float64(count) / float64(sm.config.TickerInterval)
@@ -288,6 +336,8 @@ func (sm *StatAccumInput) Flush() {
 				if numInThreshold > 0 {
 					mean = cumulativeValues[numInThreshold-1] / float64(numInThreshold)
 					thresholdBoundary = timings[numInThreshold-1]
+					sumPercentile[i] = cumulativeValues[numInThreshold-1]
+					sumSquaresPercentile[i] = cumulativeSquares[numInThreshold-1]
 				} else {
 					mean = min
 					thresholdBoundary = max
@@ -297,7 +347,21 @@ func (sm *StatAccumInput) Flush() {
 			}
 
 			sum = cumulativeValues[len(cumulativeValues)-1]
+			sumSquares = cumulativeSquares[count-1]
 			mean = sum / float64(count)
+
+			mid := count / 2
+			if count%2 == 1 {
+				median = timings[mid]
+			} else {
+				median = (timings[mid-1] + timings[mid]) / 2
+			}
+
+			var sumOfDiffs float64
+			for _, t := range timings {
+				sumOfDiffs += (t - mean) * (t - mean)
+			}
+			stdDev = math.Sqrt(sumOfDiffs / float64(count))
 		} else {
 			rate = 0.
 			min = 0.
@@ -311,10 +375,32 @@ func (sm *StatAccumInput) Flush() {
 		timerNs.Emit("lower", min)
 		timerNs.Emit("upper", max)
 		timerNs.Emit("sum", sum)
+		timerNs.Emit("sum_squares", sumSquares)
 		timerNs.Emit("mean", mean)
+		timerNs.Emit("median", median)
+		timerNs.Emit("std", stdDev)
 		for i := 0; i < countPercentThreshold; i++ {
-			timerNs.Emit(fmt.Sprintf("mean_%d", sm.config.PercentThreshold[i]), meanPercentile[i])
-			timerNs.Emit(fmt.Sprintf("upper_%d", sm.config.PercentThreshold[i]), upperPercentile[i])
+			threshold := sm.config.PercentThreshold[i]
+			timerNs.Emit(fmt.Sprintf("mean_%d", threshold), meanPercentile[i])
+			timerNs.Emit(fmt.Sprintf("upper_%d", threshold), upperPercentile[i])
+			timerNs.Emit(fmt.Sprintf("sum_%d", threshold), sumPercentile[i])
+			timerNs.Emit(fmt.Sprintf("sum_squares_%d", threshold),
+				sumSquaresPercentile[i])
+		}
+
+		if bins := sm.histogramBins(key); bins != nil {
+			// The timings are sorted, so each bin's values follow the
+			// previous bin's.
+			histogramNs := timerNs.Namespace("histogram")
+			i := 0
+			for _, bound := range bins {
+				binCount := 0
+				for ; i < count && timings[i] < bound; i++ {
+					binCount++
+				}
+				histogramNs.Emit(histogramBinName(bound), binCount)
+			}
+			histogramNs.Emit("bin_inf", count-i)
 		}
 
 		if sm.config.DeleteIdleStats {
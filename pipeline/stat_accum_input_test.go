@@ -16,6 +16,7 @@ package pipeline
 
 import (
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
@@ -58,6 +59,12 @@ func StatAccumInputSpec(c gs.Context) {
 			c.Expect(err.Error(), gs.Equals, expected)
 		})
 
+		c.Specify("requires ascending histogram bins", func() {
+			config.Histogram = []StatHistogram{{Metric: "timer", Bins: []float64{10, 5}}}
+			err := statAccumInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("validates that data is emitted", func() {
 			config.EmitInPayload = false
 			err := statAccumInput.Init(config)
@@ -444,11 +451,82 @@ func StatAccumInputSpec(c gs.Context) {
 					c.Expect(getVal("mean"), gs.Equals, 70.0)
 					c.Expect(getVal("upper_90"), gs.Equals, 100.0)
 					c.Expect(getVal("mean_90"), gs.Equals, 55.0)
+					c.Expect(getVal("sum_90"), gs.Equals, 550.0)
+					c.Expect(getVal("sum_squares"), gs.Equals, 86900.0)
+					c.Expect(getVal("sum_squares_90"), gs.Equals, 38500.0)
+					c.Expect(getVal("median"), gs.Equals, 60.0)
+					c.Expect(getVal("std"), gs.Equals, math.Sqrt(3000))
 					tmp, ok := msg.GetFieldValue("stats.timers.sample.timer.count")
 					c.Expect(ok, gs.IsTrue)
 					intTmp, ok := tmp.(int64)
 					c.Expect(ok, gs.IsTrue)
 					c.Expect(intTmp, gs.Equals, int64(11))
+					_, ok = msg.GetFieldValue("stats.timers.sample.timer.histogram.bin_inf")
+					c.Expect(ok, gs.IsFalse)
+				})
+
+				c.Specify("counts timers in histogram bins", func() {
+					config.EmitInFields = true
+					config.Histogram = []StatHistogram{
+						{Metric: "other", Bins: []float64{1}},
+						{Metric: "timer", Bins: []float64{25, 50.5, 100}},
+					}
+					err := statAccumInput.Init(config)
+					c.Assume(err, gs.IsNil)
+					startInput()
+
+					for _, v := range []int{220, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100} {
+						statAccumInput.statChan <- Stat{"sample.timer", strconv.Itoa(v),
+							"ms", float32(1)}
+					}
+
+					close(statAccumInput.statChan)
+					err = <-runErrChan
+					c.Expect(err, gs.IsNil)
+
+					msg := ith.Pack.Message
+					bins := map[string]int64{
+						"bin_25":   2,
+						"bin_50_5": 3,
+						"bin_100":  4,
+						"bin_inf":  2,
+					}
+					for bin, expected := range bins {
+						tmp, ok := msg.GetFieldValue("stats.timers.sample.timer.histogram." + bin)
+						c.Expect(ok, gs.IsTrue)
+						c.Expect(tmp, gs.Equals, expected)
+					}
+				})
+
+				c.Specify("counts timers on a bin bound in the next bin", func() {
+					config.EmitInFields = true
+					config.Histogram = []StatHistogram{
+						{Metric: "timer", Bins: []float64{25, 50}},
+					}
+					err := statAccumInput.Init(config)
+					c.Assume(err, gs.IsNil)
+					startInput()
+
+					for _, v := range []int{24, 25, 50} {
+						statAccumInput.statChan <- Stat{"sample.timer", strconv.Itoa(v),
+							"ms", float32(1)}
+					}
+
+					close(statAccumInput.statChan)
+					err = <-runErrChan
+					c.Expect(err, gs.IsNil)
+
+					msg := ith.Pack.Message
+					bins := map[string]int64{
+						"bin_25":  1,
+						"bin_50":  1,
+						"bin_inf": 1,
+					}
+					for bin, expected := range bins {
+						tmp, ok := msg.GetFieldValue("stats.timers.sample.timer.histogram." + bin)
+						c.Expect(ok, gs.IsTrue)
+						c.Expect(tmp, gs.Equals, expected)
+					}
 				})
 			})
 		})
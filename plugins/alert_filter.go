@@ -0,0 +1,254 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AlertFilterConfig struct {
+	// Message variables making up the key thresholds are evaluated per.
+	// Defaults to evaluating all messages together.
+	KeyFields []string `toml:"key_fields"`
+	// What's compared to the threshold: "count", "rate" (messages per
+	// second), or the "sum", "avg", "min" or "max" of ValueField. Defaults
+	// to "count".
+	Aggregation string
+	// Name of the numeric field aggregated by "sum", "avg", "min" and "max".
+	ValueField string `toml:"value_field"`
+	// Comparison operator, one of ">", ">=", "<" and "<=". Defaults to ">".
+	Operator string
+	// Value the aggregation is compared to.
+	Threshold float64
+	// Length in seconds of the window aggregations are computed over. Must be
+	// a multiple of ticker_interval. Defaults to 60.
+	Window uint
+	// Minimum number of seconds between repeated alerts for a key that keeps
+	// breaching the threshold. Defaults to 300, 0 disables repeats.
+	Throttle uint
+	// Interval in seconds at which thresholds are evaluated and the window
+	// advances. Defaults to 10.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+type alertBucket struct {
+	count int64
+	// Aggregates of the messages with a numeric value field.
+	numericStats
+}
+
+// Window and alerting state for one key.
+type alertKey struct {
+	buckets   []alertBucket
+	firing    bool
+	lastAlert time.Time
+}
+
+// AlertFilter evaluates a threshold on the count, rate or a value aggregate
+// of the messages it receives over a rolling window, per key, and emits
+// `heka.alert` messages when it's breached and when it recovers.
+type AlertFilter struct {
+	conf    *AlertFilterConfig
+	key     *messageKey
+	compare func(value, threshold float64) bool
+	keys    map[string]*alertKey
+	current int
+	now     func() time.Time
+	fr      FilterRunner
+	h       PluginHelper
+}
+
+func (f *AlertFilter) ConfigStruct() interface{} {
+	return &AlertFilterConfig{
+		Aggregation:    "count",
+		Operator:       ">",
+		Window:         60,
+		Throttle:       300,
+		TickerInterval: 10,
+	}
+}
+
+func (f *AlertFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*AlertFilterConfig)
+	switch f.conf.Aggregation {
+	case "count", "rate":
+	case "sum", "avg", "min", "max":
+		if f.conf.ValueField == "" {
+			return fmt.Errorf("aggregation '%s' requires a 'value_field'",
+				f.conf.Aggregation)
+		}
+	default:
+		return fmt.Errorf("unknown aggregation '%s'", f.conf.Aggregation)
+	}
+	switch f.conf.Operator {
+	case ">":
+		f.compare = func(v, t float64) bool { return v > t }
+	case ">=":
+		f.compare = func(v, t float64) bool { return v >= t }
+	case "<":
+		f.compare = func(v, t float64) bool { return v < t }
+	case "<=":
+		f.compare = func(v, t float64) bool { return v <= t }
+	default:
+		return fmt.Errorf("unknown operator '%s'", f.conf.Operator)
+	}
+	tick := f.conf.TickerInterval
+	if tick == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	if f.conf.Window == 0 || f.conf.Window%tick != 0 {
+		return fmt.Errorf("'window' must be a non-zero multiple of ticker_interval (%d)",
+			tick)
+	}
+	if f.key, err = newMessageKey(f.conf.KeyFields); err != nil {
+		return fmt.Errorf("key_fields: %s", err)
+	}
+	f.keys = make(map[string]*alertKey)
+	f.now = time.Now
+	return nil
+}
+
+func (f *AlertFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *AlertFilter) ProcessMessage(pack *PipelinePack) error {
+	name := f.key.key(pack.Message)
+	k, ok := f.keys[name]
+	if !ok {
+		k = &alertKey{buckets: make([]alertBucket, f.conf.Window/f.conf.TickerInterval)}
+		f.keys[name] = k
+	}
+	b := &k.buckets[f.current]
+	b.count++
+	if f.conf.ValueField == "" {
+		return nil
+	}
+	switch v := getNumericField(pack.Message, f.conf.ValueField).(type) {
+	case int64:
+		b.add(float64(v))
+	case float64:
+		b.add(v)
+	}
+	return nil
+}
+
+// Aggregates the key's window. ok is false if there's no value to compare.
+func (f *AlertFilter) aggregate(k *alertKey) (value float64, ok bool) {
+	var total alertBucket
+	for _, b := range k.buckets {
+		total.count += b.count
+		total.merge(b.numericStats)
+	}
+	switch f.conf.Aggregation {
+	case "count":
+		return float64(total.count), true
+	case "rate":
+		return float64(total.count) / float64(f.conf.Window), true
+	}
+	if total.values == 0 {
+		return 0, false
+	}
+	switch f.conf.Aggregation {
+	case "sum":
+		value = total.sum
+	case "avg":
+		value = total.avg()
+	case "min":
+		value = total.min
+	case "max":
+		value = total.max
+	}
+	return value, true
+}
+
+func (f *AlertFilter) TimerEvent() error {
+	now := f.now()
+	throttle := time.Duration(f.conf.Throttle) * time.Second
+	for name, k := range f.keys {
+		value, ok := f.aggregate(k)
+		if ok {
+			breached := f.compare(value, f.conf.Threshold)
+			repeat := breached && k.firing && f.conf.Throttle > 0 &&
+				now.Sub(k.lastAlert) >= throttle
+			if breached != k.firing || repeat {
+				k.firing = breached
+				k.lastAlert = now
+				if err := f.injectAlert(name, breached, value); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Start a new bucket, dropping the oldest one, and forget keys that
+		// have been quiet for a whole window.
+		next := (f.current + 1) % len(k.buckets)
+		k.buckets[next] = alertBucket{}
+		if !k.firing {
+			quiet := true
+			for _, b := range k.buckets {
+				if b.count > 0 {
+					quiet = false
+					break
+				}
+			}
+			if quiet {
+				delete(f.keys, name)
+			}
+		}
+	}
+	f.current = (f.current + 1) % int(f.conf.Window/f.conf.TickerInterval)
+	return nil
+}
+
+func (f *AlertFilter) injectAlert(name string, firing bool, value float64) error {
+	state, severity := "resolved", int32(6)
+	if firing {
+		state, severity = "firing", 1
+	}
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetType("heka.alert")
+	msg.SetLogger(f.fr.Name())
+	msg.SetSeverity(severity)
+	msg.SetPayload(fmt.Sprintf("%s: alert %s for key '%s', %s over %ds is %g (threshold %s %g)",
+		f.fr.Name(), state, name, f.conf.Aggregation, f.conf.Window, value,
+		f.conf.Operator, f.conf.Threshold))
+	message.NewStringField(msg, "Alert", f.fr.Name())
+	message.NewStringField(msg, "Key", name)
+	message.NewStringField(msg, "AlertState", state)
+	message.NewStringField(msg, "Aggregation", f.conf.Aggregation)
+	addDoubleField(msg, "Value", value, "")
+	message.NewStringField(msg, "Operator", f.conf.Operator)
+	addDoubleField(msg, "Threshold", f.conf.Threshold, "")
+	message.NewInt64Field(msg, "Window", int64(f.conf.Window), "s")
+	f.fr.Inject(pack)
+	return nil
+}
+
+func (f *AlertFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("AlertFilter", func() interface{} {
+		return new(AlertFilter)
+	})
+}
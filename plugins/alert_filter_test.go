@@ -0,0 +1,153 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"math"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AlertFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("An AlertFilter", func() {
+		filter := new(AlertFilter)
+		config := filter.ConfigStruct().(*AlertFilterConfig)
+		config.KeyFields = []string{"Hostname"}
+		config.Window = 20
+		config.Threshold = 5
+
+		c.Specify("requires a value_field for value aggregations", func() {
+			config.Aggregation = "avg"
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an unknown operator", func() {
+			config.Operator = "=="
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires the window to be a multiple of the ticker interval", func() {
+			config.Window = 25
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("alerts", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			fr.EXPECT().Name().Return("errors").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			now := time.Unix(1e9, 0)
+			send := func(host string, n int, latency float64) {
+				pack := NewPipelinePack(recycleChan)
+				for i := 0; i < n; i++ {
+					pack.Message = new(message.Message)
+					pack.Message.SetHostname(host)
+					addDoubleField(pack.Message, "latency", latency, "s")
+					filter.ProcessMessage(pack)
+				}
+			}
+			tick := func() {
+				now = now.Add(10 * time.Second)
+				err := filter.TimerEvent()
+				c.Expect(err, gs.IsNil)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.now = func() time.Time { return now }
+			}
+
+			c.Specify("on count breaches and recoveries", func() {
+				config.Throttle = 20
+				start()
+				send("a", 4, 0)
+				send("b", 1, 0)
+				tick()
+				c.Expect(len(injected), gs.Equals, 0)
+
+				// The window now holds six messages from "a".
+				send("a", 2, 0)
+				tick()
+				c.Assume(len(injected), gs.Equals, 1)
+				alert := injected[0]
+				c.Expect(alert.GetType(), gs.Equals, "heka.alert")
+				c.Expect(alert.GetSeverity(), gs.Equals, int32(1))
+				c.Expect(value(alert, "Key"), gs.Equals, "a")
+				c.Expect(value(alert, "AlertState"), gs.Equals, "firing")
+				c.Expect(value(alert, "Value"), gs.Equals, 6.0)
+
+				// Still breaching, but throttled.
+				injected = nil
+				send("a", 6, 0)
+				tick()
+				c.Expect(len(injected), gs.Equals, 0)
+
+				// Still breaching once the throttle has passed.
+				send("a", 6, 0)
+				tick()
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "AlertState"), gs.Equals, "firing")
+
+				injected = nil
+				tick()
+				c.Expect(len(injected), gs.Equals, 0)
+				tick()
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "AlertState"), gs.Equals, "resolved")
+				c.Expect(injected[0].GetSeverity(), gs.Equals, int32(6))
+
+				// Quiet keys are forgotten.
+				tick()
+				c.Expect(len(filter.keys), gs.Equals, 0)
+			})
+
+			c.Specify("on value aggregates", func() {
+				config.Aggregation = "avg"
+				config.ValueField = "latency"
+				config.Threshold = 0.5
+				start()
+				send("a", 3, 0.2)
+				send("a", 1, 1.7)
+				send("b", 2, 0.4)
+				tick()
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "Key"), gs.Equals, "a")
+				avg := value(injected[0], "Value").(float64)
+				c.Expect(math.Abs(avg-0.575) < 1e-9, gs.IsTrue)
+			})
+		})
+	})
+}
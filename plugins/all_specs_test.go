@@ -23,10 +23,23 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(AlertFilterSpec)
+	r.AddSpec(CorrelateFilterSpec)
+	r.AddSpec(DedupeFilterSpec)
+	r.AddSpec(EnrichFilterSpec)
+	r.AddSpec(HeartbeatInputSpec)
 	r.AddSpec(LoadFromConfigSpec)
+	r.AddSpec(SampleFilterSpec)
+	r.AddSpec(SchemaDriftFilterSpec)
 	r.AddSpec(ScribbleDecoderSpec)
 	r.AddSpec(PayloadEncoderSpec)
+	r.AddSpec(RateFilterSpec)
+	r.AddSpec(RateLimitFilterSpec)
+	r.AddSpec(RollupFilterSpec)
 	r.AddSpec(RstEncoderSpec)
+	r.AddSpec(SloFilterSpec)
+	r.AddSpec(TransformFilterSpec)
+	r.AddSpec(UnitFilterSpec)
 
 	gospec.MainGoTest(r, t)
 }
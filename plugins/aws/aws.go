@@ -0,0 +1,216 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// awsCredentials looks up the named region and resolves the credentials to
+// use with it. Empty keys fall back to the environment or the EC2 instance
+// role.
+func awsCredentials(regionName, accessKeyId, secretAccessKey string) (
+	auth aws.Auth, region aws.Region, err error) {
+
+	region, ok := aws.Regions[regionName]
+	if !ok {
+		return auth, region, fmt.Errorf("unknown AWS region: '%s'", regionName)
+	}
+	auth, err = aws.GetAuth(accessKeyId, secretAccessKey, "", time.Time{})
+	if err != nil {
+		return auth, region, fmt.Errorf("can't get AWS credentials: %s", err)
+	}
+	return auth, region, nil
+}
+
+type AwsSessionConfig struct {
+	// AWS region. Defaults to "us-east-1".
+	Region string `toml:"aws_region"`
+	// Credentials to use. If left empty the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY environment variables or the EC2 instance role
+	// will be used instead.
+	AccessKeyId     string `toml:"aws_access_key_id"`
+	SecretAccessKey string `toml:"aws_secret_access_key"`
+}
+
+// Shared AWS region and credentials, defined once in the `[resources]` config
+// section with a type of "AwsSession" and referenced by name from the AWS
+// plugins' `session_resource` setting.
+type AwsSession struct {
+	auth   aws.Auth
+	region aws.Region
+}
+
+func (a *AwsSession) ConfigStruct() interface{} {
+	return &AwsSessionConfig{
+		Region: "us-east-1",
+	}
+}
+
+func (a *AwsSession) Init(config interface{}) (err error) {
+	conf := config.(*AwsSessionConfig)
+	a.auth, a.region, err = awsCredentials(conf.Region, conf.AccessKeyId,
+		conf.SecretAccessKey)
+	return err
+}
+
+// pluginCredentials resolves a plugin's region and credentials, either from
+// the named AwsSession resource or, if no resource is given, from the
+// plugin's own settings.
+func pluginCredentials(pConfig *pipeline.PipelineConfig, sessionResource,
+	regionName, accessKeyId, secretAccessKey string) (auth aws.Auth,
+	region aws.Region, err error) {
+
+	if sessionResource == "" {
+		return awsCredentials(regionName, accessKeyId, secretAccessKey)
+	}
+	if accessKeyId != "" || secretAccessKey != "" {
+		return auth, region, errors.New(
+			"credentials can't be combined with 'session_resource'")
+	}
+	resource, err := pConfig.Resource(sessionResource)
+	if err != nil {
+		return auth, region, err
+	}
+	session, ok := resource.(*AwsSession)
+	if !ok {
+		return auth, region, fmt.Errorf("resource '%s' isn't an AwsSession resource",
+			sessionResource)
+	}
+	return session.auth, session.region, nil
+}
+
+// awsEndpoint returns the default HTTPS endpoint of an AWS service in the
+// given region.
+func awsEndpoint(service string, region aws.Region) string {
+	domain := "amazonaws.com"
+	if strings.HasPrefix(region.Name, "cn-") {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://%s.%s.%s", service, region.Name, domain)
+}
+
+// awsJsonError is an error response from a JSON protocol AWS service.
+type awsJsonError struct {
+	StatusCode int
+	// Exception name, e.g. "ResourceNotFoundException".
+	Type    string
+	Message string
+}
+
+func (e *awsJsonError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Type, e.StatusCode, e.Message)
+}
+
+// isAwsError returns true if err is an AWS error response of the given type.
+func isAwsError(err error, typ string) bool {
+	awsErr, ok := err.(*awsJsonError)
+	return ok && awsErr.Type == typ
+}
+
+// awsJsonClient makes signed requests to AWS services that use the JSON
+// protocol, such as Kinesis and DynamoDB, which goamz doesn't fully cover.
+type awsJsonClient struct {
+	endpoint    string
+	target      string
+	contentType string
+	signer      *aws.V4Signer
+	httpClient  *http.Client
+}
+
+// newAwsJsonClient creates a client for the named service. An empty endpoint
+// means the service's default endpoint for the region.
+func newAwsJsonClient(service, endpoint, target, jsonVersion string, auth aws.Auth,
+	region aws.Region) *awsJsonClient {
+
+	if endpoint == "" {
+		endpoint = awsEndpoint(service, region)
+	}
+	return &awsJsonClient{
+		endpoint:    strings.TrimRight(endpoint, "/"),
+		target:      target,
+		contentType: "application/x-amz-json-" + jsonVersion,
+		signer:      aws.NewV4Signer(auth, service, region),
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// call invokes an API action, encoding request and decoding the result into
+// response, which may be nil if the result isn't needed.
+func (c *awsJsonClient) call(action string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", c.contentType)
+	req.Header.Set("X-Amz-Target", c.target+"."+action)
+	c.signer.Sign(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Type         string `json:"__type"`
+			Message      string `json:"message"`
+			MessageUpper string `json:"Message"`
+		}
+		json.Unmarshal(data, &errResp)
+		awsErr := &awsJsonError{
+			StatusCode: resp.StatusCode,
+			Type:       errResp.Type,
+			Message:    errResp.Message,
+		}
+		// Types are sometimes namespaced, e.g.
+		// "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException".
+		if i := strings.LastIndex(awsErr.Type, "#"); i != -1 {
+			awsErr.Type = awsErr.Type[i+1:]
+		}
+		if awsErr.Message == "" {
+			awsErr.Message = errResp.MessageUpper
+		}
+		if awsErr.Message == "" {
+			awsErr.Message = string(data)
+		}
+		return awsErr
+	}
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(data, response)
+}
+
+func init() {
+	pipeline.RegisterResource("AwsSession", func() interface{} {
+		return new(AwsSession)
+	})
+}
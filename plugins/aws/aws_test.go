@@ -0,0 +1,63 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AwsSessionSpec(c gs.Context) {
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("An AwsSession", func() {
+		session := new(AwsSession)
+		config := session.ConfigStruct().(*AwsSessionConfig)
+		config.AccessKeyId = "key"
+		config.SecretAccessKey = "secret"
+
+		c.Specify("resolves the region and credentials", func() {
+			config.Region = "eu-west-1"
+			err := session.Init(config)
+			c.Expect(err, gs.IsNil)
+			c.Expect(session.region.Name, gs.Equals, "eu-west-1")
+			c.Expect(session.auth.AccessKey, gs.Equals, "key")
+		})
+
+		c.Specify("fails w/ an unknown region", func() {
+			config.Region = "moon-1"
+			err := session.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("Plugin credentials", func() {
+		c.Specify("come from the plugin's settings w/o a resource", func() {
+			auth, region, err := pluginCredentials(pConfig, "", "us-west-2", "key",
+				"secret")
+			c.Expect(err, gs.IsNil)
+			c.Expect(region.Name, gs.Equals, "us-west-2")
+			c.Expect(auth.SecretKey, gs.Equals, "secret")
+		})
+
+		c.Specify("can't be combined w/ a resource", func() {
+			_, _, err := pluginCredentials(pConfig, "shared", "us-east-1", "key",
+				"secret")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fail for unknown resources", func() {
+			_, _, err := pluginCredentials(pConfig, "shared", "us-east-1", "", "")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
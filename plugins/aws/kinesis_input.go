@@ -0,0 +1,668 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type kinesisShard struct {
+	ShardId               string
+	ParentShardId         string
+	AdjacentParentShardId string
+}
+
+type kinesisRecord struct {
+	Data           []byte
+	PartitionKey   string
+	SequenceNumber string
+	// Seconds since the epoch.
+	ApproximateArrivalTimestamp float64
+}
+
+type kinesisRecords struct {
+	Records []kinesisRecord
+	// Empty once a closed shard has been read to its end.
+	NextShardIterator string
+}
+
+// kinesisStream is the subset of the Kinesis API used by KinesisInput, split
+// out so tests can substitute an in-memory stream.
+type kinesisStream interface {
+	ListShards() ([]kinesisShard, error)
+	GetShardIterator(shardId, iteratorType, sequenceNumber string) (string, error)
+	GetRecords(iterator string, limit int) (*kinesisRecords, error)
+}
+
+// kinesisClient implements kinesisStream using the Kinesis JSON API.
+type kinesisClient struct {
+	*awsJsonClient
+	stream string
+}
+
+func (k *kinesisClient) ListShards() (shards []kinesisShard, err error) {
+	var request struct {
+		StreamName            string
+		ExclusiveStartShardId string `json:",omitempty"`
+	}
+	request.StreamName = k.stream
+	for {
+		var response struct {
+			StreamDescription struct {
+				Shards        []kinesisShard
+				HasMoreShards bool
+			}
+		}
+		if err = k.call("DescribeStream", request, &response); err != nil {
+			return nil, err
+		}
+		desc := response.StreamDescription
+		shards = append(shards, desc.Shards...)
+		if !desc.HasMoreShards || len(desc.Shards) == 0 {
+			return shards, nil
+		}
+		request.ExclusiveStartShardId = desc.Shards[len(desc.Shards)-1].ShardId
+	}
+}
+
+func (k *kinesisClient) GetShardIterator(shardId, iteratorType,
+	sequenceNumber string) (string, error) {
+
+	request := struct {
+		StreamName             string
+		ShardId                string
+		ShardIteratorType      string
+		StartingSequenceNumber string `json:",omitempty"`
+	}{k.stream, shardId, iteratorType, sequenceNumber}
+	var response struct {
+		ShardIterator string
+	}
+	err := k.call("GetShardIterator", request, &response)
+	return response.ShardIterator, err
+}
+
+func (k *kinesisClient) GetRecords(iterator string, limit int) (*kinesisRecords, error) {
+	request := struct {
+		ShardIterator string
+		Limit         int
+	}{iterator, limit}
+	response := new(kinesisRecords)
+	if err := k.call("GetRecords", request, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// kinesisCheckpoint records how far a shard has been read.
+type kinesisCheckpoint struct {
+	// Sequence number of the last record delivered.
+	SequenceNumber string `json:",omitempty"`
+	// Set once a closed shard has been read to its end.
+	Closed bool `json:",omitempty"`
+}
+
+type kinesisCheckpointer interface {
+	// Returns the shard's checkpoint, and whether one was found.
+	Get(shardId string) (kinesisCheckpoint, bool, error)
+	Set(shardId string, ck kinesisCheckpoint) error
+}
+
+// fileCheckpointer stores the checkpoints of all shards in a local JSON file.
+type fileCheckpointer struct {
+	path   string
+	lock   sync.Mutex
+	shards map[string]kinesisCheckpoint
+}
+
+func newFileCheckpointer(path string) (*fileCheckpointer, error) {
+	f := &fileCheckpointer{path: path, shards: make(map[string]kinesisCheckpoint)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &f.shards); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fileCheckpointer) Get(shardId string) (kinesisCheckpoint, bool, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	ck, ok := f.shards[shardId]
+	return ck, ok, nil
+}
+
+// Set replaces the checkpoint file atomically so a crash can't leave a
+// truncated checkpoint behind.
+func (f *fileCheckpointer) Set(shardId string, ck kinesisCheckpoint) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.shards[shardId] = ck
+	data, err := json.Marshal(f.shards)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, f.path)
+}
+
+// dynamoCheckpointer stores one item per shard in a DynamoDB table with a
+// string hash key named "Id", so that checkpoints survive the loss of the
+// host. The item ids are "<application name>/<stream>/<shard id>".
+type dynamoCheckpointer struct {
+	client *awsJsonClient
+	table  string
+	prefix string
+}
+
+type dynamoValue struct {
+	S    string `json:",omitempty"`
+	BOOL bool   `json:",omitempty"`
+}
+
+func (d *dynamoCheckpointer) key(shardId string) map[string]dynamoValue {
+	return map[string]dynamoValue{"Id": {S: d.prefix + shardId}}
+}
+
+func (d *dynamoCheckpointer) Get(shardId string) (ck kinesisCheckpoint, ok bool,
+	err error) {
+
+	request := struct {
+		TableName      string
+		Key            map[string]dynamoValue
+		ConsistentRead bool
+	}{d.table, d.key(shardId), true}
+	var response struct {
+		Item map[string]dynamoValue
+	}
+	if err = d.client.call("GetItem", request, &response); err != nil {
+		return ck, false, err
+	}
+	if response.Item == nil {
+		return ck, false, nil
+	}
+	ck.SequenceNumber = response.Item["SequenceNumber"].S
+	ck.Closed = response.Item["Closed"].BOOL
+	return ck, true, nil
+}
+
+func (d *dynamoCheckpointer) Set(shardId string, ck kinesisCheckpoint) error {
+	item := d.key(shardId)
+	// DynamoDB doesn't allow empty string attributes.
+	if ck.SequenceNumber != "" {
+		item["SequenceNumber"] = dynamoValue{S: ck.SequenceNumber}
+	}
+	if ck.Closed {
+		item["Closed"] = dynamoValue{BOOL: true}
+	}
+	request := struct {
+		TableName string
+		Item      map[string]dynamoValue
+	}{d.table, item}
+	return d.client.call("PutItem", request, nil)
+}
+
+type KinesisInputConfig struct {
+	// AWS region the stream lives in. Defaults to "us-east-1".
+	Region string `toml:"aws_region"`
+	// Credentials to use. If left empty the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY environment variables or the EC2 instance role
+	// will be used instead.
+	AccessKeyId     string `toml:"aws_access_key_id"`
+	SecretAccessKey string `toml:"aws_secret_access_key"`
+	// Name of a shared AwsSession resource providing the region and
+	// credentials instead.
+	SessionResource string `toml:"session_resource"`
+	// Name of the stream to consume.
+	Stream string
+	// Where to start reading shards that have no checkpoint, either
+	// "TRIM_HORIZON" (the oldest available record) or "LATEST". Defaults to
+	// "TRIM_HORIZON".
+	ShardIteratorType string `toml:"shard_iterator_type"`
+	// Maximum number of records requested per GetRecords call. Defaults to
+	// 1000.
+	RecordsLimit int `toml:"records_limit"`
+	// Milliseconds to wait before reading a shard again after a read that
+	// didn't fill `records_limit`. Defaults to 1000.
+	PollInterval uint `toml:"poll_interval"`
+	// How often, in seconds, the stream's shard list is refreshed to pick up
+	// resharding. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Checkpoint storage, either "file" or "dynamodb". Defaults to "file".
+	Checkpoint string
+	// Path of the checkpoint file. Defaults to "kinesis/<plugin
+	// name>.checkpoint" under base_dir.
+	CheckpointFile string `toml:"checkpoint_file"`
+	// DynamoDB table used for checkpoints when `checkpoint` is "dynamodb".
+	DynamoDbTable string `toml:"dynamodb_table"`
+	// Namespace for the DynamoDB checkpoints, so several consumers of the
+	// same stream can share a table. Defaults to the plugin name.
+	ApplicationName string `toml:"application_name"`
+	// Endpoints overriding the default regional endpoints, for testing.
+	KinesisEndpoint  string `toml:"kinesis_endpoint"`
+	DynamoDbEndpoint string `toml:"dynamodb_endpoint"`
+	// So we can default to NullSplitter.
+	Splitter string
+}
+
+type KinesisInput struct {
+	processRecordCount    int64
+	processRecordFailures int64
+	getRecordsFailures    int64
+	activeShards          int64
+
+	config      *KinesisInputConfig
+	name        string
+	pConfig     *pipeline.PipelineConfig
+	stream      kinesisStream
+	checkpoints kinesisCheckpointer
+	ir          pipeline.InputRunner
+	hostname    string
+	stopChan    chan bool
+	refreshChan chan bool
+	wg          sync.WaitGroup
+
+	shardsLock sync.Mutex
+	// Shards currently being consumed.
+	running map[string]bool
+	// Shards that have been read to their end.
+	finished map[string]bool
+	// Shards that have ever been checkpointed, i.e. that this consumer has
+	// read from.
+	consumed map[string]bool
+}
+
+func (k *KinesisInput) SetName(name string) {
+	k.name = name
+}
+
+func (k *KinesisInput) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	k.pConfig = pConfig
+}
+
+func (k *KinesisInput) ConfigStruct() interface{} {
+	return &KinesisInputConfig{
+		Region:            "us-east-1",
+		ShardIteratorType: "TRIM_HORIZON",
+		RecordsLimit:      1000,
+		PollInterval:      1000,
+		TickerInterval:    60,
+		Checkpoint:        "file",
+		Splitter:          "NullSplitter",
+	}
+}
+
+func (k *KinesisInput) Init(config interface{}) (err error) {
+	k.config = config.(*KinesisInputConfig)
+	if k.config.Stream == "" {
+		return errors.New("'stream' must be specified")
+	}
+	switch k.config.ShardIteratorType {
+	case "TRIM_HORIZON", "LATEST":
+	default:
+		return fmt.Errorf("invalid shard_iterator_type '%s', must be 'TRIM_HORIZON' or 'LATEST'",
+			k.config.ShardIteratorType)
+	}
+	if k.config.RecordsLimit <= 0 || k.config.RecordsLimit > 10000 {
+		return errors.New("'records_limit' must be between 1 and 10000")
+	}
+
+	auth, region, err := pluginCredentials(k.pConfig, k.config.SessionResource,
+		k.config.Region, k.config.AccessKeyId, k.config.SecretAccessKey)
+	if err != nil {
+		return err
+	}
+	k.stream = &kinesisClient{
+		awsJsonClient: newAwsJsonClient("kinesis", k.config.KinesisEndpoint,
+			"Kinesis_20131202", "1.1", auth, region),
+		stream: k.config.Stream,
+	}
+
+	switch k.config.Checkpoint {
+	case "file":
+		ckPath := k.config.CheckpointFile
+		if ckPath == "" {
+			ckPath = filepath.Join("kinesis", k.name+".checkpoint")
+		}
+		ckPath = k.pConfig.Globals.PrependBaseDir(ckPath)
+		if err = os.MkdirAll(filepath.Dir(ckPath), 0766); err != nil {
+			return err
+		}
+		if k.checkpoints, err = newFileCheckpointer(ckPath); err != nil {
+			return fmt.Errorf("can't read checkpoint file: %s", err)
+		}
+	case "dynamodb":
+		k.checkpoints, err = k.newDynamoCheckpointer(auth, region)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid checkpoint '%s', must be 'file' or 'dynamodb'",
+			k.config.Checkpoint)
+	}
+
+	k.stopChan = make(chan bool)
+	k.refreshChan = make(chan bool, 1)
+	k.running = make(map[string]bool)
+	k.finished = make(map[string]bool)
+	k.consumed = make(map[string]bool)
+	return nil
+}
+
+func (k *KinesisInput) newDynamoCheckpointer(auth aws.Auth, region aws.Region) (
+	*dynamoCheckpointer, error) {
+
+	if k.config.DynamoDbTable == "" {
+		return nil, errors.New("'dynamodb_table' must be specified for dynamodb checkpoints")
+	}
+	appName := k.config.ApplicationName
+	if appName == "" {
+		appName = k.name
+	}
+	return &dynamoCheckpointer{
+		client: newAwsJsonClient("dynamodb", k.config.DynamoDbEndpoint,
+			"DynamoDB_20120810", "1.0", auth, region),
+		table:  k.config.DynamoDbTable,
+		prefix: fmt.Sprintf("%s/%s/", appName, k.config.Stream),
+	}, nil
+}
+
+func (k *KinesisInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) error {
+	k.ir = ir
+	k.hostname = h.Hostname()
+
+	tickChan := ir.Ticker()
+	for {
+		if err := k.refreshShards(); err != nil {
+			ir.LogError(fmt.Errorf("refreshing shards of stream '%s': %s",
+				k.config.Stream, err))
+		}
+		select {
+		case <-k.stopChan:
+			k.wg.Wait()
+			return nil
+		case <-tickChan:
+		case <-k.refreshChan:
+		}
+	}
+}
+
+func (k *KinesisInput) isStopping() bool {
+	select {
+	case <-k.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+// sleep waits for the given duration, returning false if the input is
+// stopped in the meantime.
+func (k *KinesisInput) sleep(d time.Duration) bool {
+	select {
+	case <-k.stopChan:
+		return false
+	case <-time.After(d):
+	}
+	return true
+}
+
+// shardStart describes where a newly started shard consumer should begin.
+type shardStart struct {
+	shardId      string
+	iteratorType string
+	checkpoint   kinesisCheckpoint
+}
+
+func (k *KinesisInput) refreshShards() error {
+	shards, err := k.stream.ListShards()
+	if err != nil {
+		return err
+	}
+	starts, err := k.shardsToStart(shards)
+	if err != nil {
+		return err
+	}
+	for _, start := range starts {
+		k.wg.Add(1)
+		atomic.AddInt64(&k.activeShards, 1)
+		go k.consumeShard(start.shardId, start.iteratorType, start.checkpoint)
+	}
+	return nil
+}
+
+// shardsToStart returns every listed shard that isn't being consumed yet,
+// hasn't been read to its end, and whose parents, if any, have been read to
+// their end, marking them as running. Holding children back until their
+// parents are done keeps records with the same partition key in order across
+// resharding.
+func (k *KinesisInput) shardsToStart(shards []kinesisShard) ([]shardStart, error) {
+	k.shardsLock.Lock()
+	defer k.shardsLock.Unlock()
+
+	listed := make(map[string]bool, len(shards))
+	checkpoints := make(map[string]kinesisCheckpoint)
+	for _, shard := range shards {
+		listed[shard.ShardId] = true
+		if k.running[shard.ShardId] || k.finished[shard.ShardId] {
+			continue
+		}
+		ck, ok, err := k.checkpoints.Get(shard.ShardId)
+		if err != nil {
+			return nil, fmt.Errorf("reading checkpoint for shard '%s': %s",
+				shard.ShardId, err)
+		}
+		if ok {
+			k.consumed[shard.ShardId] = true
+		}
+		if ck.Closed {
+			k.finished[shard.ShardId] = true
+			continue
+		}
+		checkpoints[shard.ShardId] = ck
+	}
+
+	// Parents that are no longer listed have aged out of the stream, so
+	// there's nothing left to wait for.
+	parentDone := func(id string) bool {
+		return id == "" || !listed[id] || k.finished[id]
+	}
+	var starts []shardStart
+	for _, shard := range shards {
+		ck, ok := checkpoints[shard.ShardId]
+		if !ok || !parentDone(shard.ParentShardId) ||
+			!parentDone(shard.AdjacentParentShardId) {
+			continue
+		}
+		iteratorType := k.config.ShardIteratorType
+		// A shard created by resharding a shard we were reading has to be
+		// read from the start or records would be lost.
+		if k.consumed[shard.ParentShardId] || k.consumed[shard.AdjacentParentShardId] {
+			iteratorType = "TRIM_HORIZON"
+		}
+		if ck.SequenceNumber != "" {
+			iteratorType = "AFTER_SEQUENCE_NUMBER"
+		}
+		k.running[shard.ShardId] = true
+		starts = append(starts, shardStart{shard.ShardId, iteratorType, ck})
+	}
+	return starts, nil
+}
+
+func (k *KinesisInput) consumeShard(shardId, iteratorType string, ck kinesisCheckpoint) {
+	sRunner := k.ir.NewSplitterRunner(shardId)
+	defer func() {
+		sRunner.Done()
+		atomic.AddInt64(&k.activeShards, -1)
+		k.shardsLock.Lock()
+		delete(k.running, shardId)
+		k.shardsLock.Unlock()
+		k.wg.Done()
+	}()
+
+	pollInterval := time.Duration(k.config.PollInterval) * time.Millisecond
+	iterator := ""
+	for !k.isStopping() {
+		var err error
+		if iterator == "" {
+			iterator, err = k.stream.GetShardIterator(shardId, iteratorType,
+				ck.SequenceNumber)
+			if err != nil {
+				k.ir.LogError(fmt.Errorf("getting iterator for shard '%s': %s",
+					shardId, err))
+				if !k.sleep(pollInterval) {
+					return
+				}
+				continue
+			}
+		}
+		var n int
+		var done bool
+		iterator, n, done, err = k.readShard(sRunner, shardId, iterator, &ck)
+		if err != nil {
+			atomic.AddInt64(&k.getRecordsFailures, 1)
+			if isAwsError(err, "ExpiredIteratorException") {
+				// Start over from the checkpoint.
+				iterator = ""
+				if ck.SequenceNumber != "" {
+					iteratorType = "AFTER_SEQUENCE_NUMBER"
+				}
+				continue
+			}
+			if !isAwsError(err, "ProvisionedThroughputExceededException") {
+				k.ir.LogError(fmt.Errorf("reading shard '%s': %s", shardId, err))
+			}
+		}
+		if done {
+			k.ir.LogMessage(fmt.Sprintf("finished reading closed shard '%s'", shardId))
+			// Let any child shards start.
+			select {
+			case k.refreshChan <- true:
+			default:
+			}
+			return
+		}
+		if n == k.config.RecordsLimit {
+			// There's probably more waiting.
+			continue
+		}
+		if !k.sleep(pollInterval) {
+			return
+		}
+	}
+}
+
+// readShard makes a single GetRecords call, delivers the records returned
+// and checkpoints the shard. Returns the iterator for the next call, the
+// number of records read and whether the shard has been read to its end.
+func (k *KinesisInput) readShard(sRunner pipeline.SplitterRunner, shardId,
+	iterator string, ck *kinesisCheckpoint) (string, int, bool, error) {
+
+	records, err := k.stream.GetRecords(iterator, k.config.RecordsLimit)
+	if err != nil {
+		return iterator, 0, false, err
+	}
+	for _, record := range records.Records {
+		k.deliverRecord(sRunner, shardId, record)
+	}
+	changed := false
+	if n := len(records.Records); n > 0 {
+		ck.SequenceNumber = records.Records[n-1].SequenceNumber
+		changed = true
+	}
+	done := records.NextShardIterator == ""
+	if done {
+		ck.Closed = true
+		changed = true
+		k.shardsLock.Lock()
+		k.finished[shardId] = true
+		k.shardsLock.Unlock()
+	}
+	if changed {
+		k.shardsLock.Lock()
+		k.consumed[shardId] = true
+		k.shardsLock.Unlock()
+		if err = k.checkpoints.Set(shardId, *ck); err != nil {
+			k.ir.LogError(fmt.Errorf("writing checkpoint for shard '%s': %s",
+				shardId, err))
+		}
+	}
+	return records.NextShardIterator, len(records.Records), done, nil
+}
+
+func (k *KinesisInput) deliverRecord(sRunner pipeline.SplitterRunner, shardId string,
+	record kinesisRecord) {
+
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(func(pack *pipeline.PipelinePack) {
+			pack.Message.SetType("heka.kinesis")
+			pack.Message.SetLogger(k.name)
+			pack.Message.SetHostname(k.hostname)
+			if record.ApproximateArrivalTimestamp > 0 {
+				pack.Message.SetTimestamp(int64(record.ApproximateArrivalTimestamp * 1e9))
+			}
+			message.NewStringField(pack.Message, "KinesisStream", k.config.Stream)
+			message.NewStringField(pack.Message, "KinesisShardId", shardId)
+			message.NewStringField(pack.Message, "KinesisPartitionKey", record.PartitionKey)
+			message.NewStringField(pack.Message, "KinesisSequenceNumber",
+				record.SequenceNumber)
+		})
+	}
+	if _, err := sRunner.SplitBytes(record.Data, nil); err != nil {
+		atomic.AddInt64(&k.processRecordFailures, 1)
+		k.ir.LogError(fmt.Errorf("processing record %s from shard '%s': %s",
+			record.SequenceNumber, shardId, err))
+		return
+	}
+	atomic.AddInt64(&k.processRecordCount, 1)
+}
+
+func (k *KinesisInput) Stop() {
+	close(k.stopChan)
+}
+
+func (k *KinesisInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessRecordCount",
+		atomic.LoadInt64(&k.processRecordCount), "count")
+	message.NewInt64Field(msg, "ProcessRecordFailures",
+		atomic.LoadInt64(&k.processRecordFailures), "count")
+	message.NewInt64Field(msg, "GetRecordsFailures",
+		atomic.LoadInt64(&k.getRecordsFailures), "count")
+	message.NewInt64Field(msg, "ActiveShards",
+		atomic.LoadInt64(&k.activeShards), "count")
+	return nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("KinesisInput", func() interface{} {
+		return new(KinesisInput)
+	})
+}
@@ -0,0 +1,222 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// memStream is an in-memory kinesisStream. Iterators are "<shard id>:<index
+// of the next record>".
+type memStream struct {
+	shards  []kinesisShard
+	records map[string][]kinesisRecord
+	closed  map[string]bool
+}
+
+func (m *memStream) put(shardId string, data ...string) {
+	for _, d := range data {
+		seq := strconv.Itoa(len(m.records[shardId]) + 1)
+		m.records[shardId] = append(m.records[shardId], kinesisRecord{
+			Data:           []byte(d),
+			PartitionKey:   "key",
+			SequenceNumber: seq,
+		})
+	}
+}
+
+func (m *memStream) ListShards() ([]kinesisShard, error) {
+	return m.shards, nil
+}
+
+func (m *memStream) GetShardIterator(shardId, iteratorType,
+	sequenceNumber string) (string, error) {
+
+	next := 0
+	switch iteratorType {
+	case "LATEST":
+		next = len(m.records[shardId])
+	case "AFTER_SEQUENCE_NUMBER":
+		next, _ = strconv.Atoi(sequenceNumber)
+	}
+	return fmt.Sprintf("%s:%d", shardId, next), nil
+}
+
+func (m *memStream) GetRecords(iterator string, limit int) (*kinesisRecords, error) {
+	var shardId string
+	var next int
+	for i := len(iterator) - 1; i >= 0; i-- {
+		if iterator[i] == ':' {
+			shardId = iterator[:i]
+			next, _ = strconv.Atoi(iterator[i+1:])
+			break
+		}
+	}
+	records := m.records[shardId][next:]
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	next += len(records)
+	resp := &kinesisRecords{Records: records}
+	if !m.closed[shardId] || next < len(m.records[shardId]) {
+		resp.NextShardIterator = fmt.Sprintf("%s:%d", shardId, next)
+	}
+	return resp, nil
+}
+
+func KinesisInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "kinesisinput-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	ckPath := filepath.Join(tmpDir, "KinesisInput.checkpoint")
+
+	c.Specify("A KinesisInput", func() {
+		stream := &memStream{
+			records: make(map[string][]kinesisRecord),
+			closed:  make(map[string]bool),
+		}
+		input := &KinesisInput{
+			config: &KinesisInputConfig{
+				Stream:            "events",
+				ShardIteratorType: "LATEST",
+				RecordsLimit:      2,
+			},
+			name:        "KinesisInput",
+			stream:      stream,
+			stopChan:    make(chan bool),
+			refreshChan: make(chan bool, 1),
+			running:     make(map[string]bool),
+			finished:    make(map[string]bool),
+			consumed:    make(map[string]bool),
+		}
+		input.checkpoints, err = newFileCheckpointer(ckPath)
+		c.Assume(err, gs.IsNil)
+
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		input.ir = ir
+
+		var processed []string
+		sRunner.EXPECT().UseMsgBytes().Return(false).AnyTimes()
+		sRunner.EXPECT().SetPackDecorator(gomock.Any()).AnyTimes()
+		sRunner.EXPECT().SplitBytes(gomock.Any(), nil).Return(0, nil).AnyTimes().Do(
+			func(data []byte, del Deliverer) {
+				processed = append(processed, string(data))
+			})
+
+		// Reads a started shard until it runs out of records.
+		drain := func(start shardStart) (done bool) {
+			iterator, _ := stream.GetShardIterator(start.shardId, start.iteratorType,
+				start.checkpoint.SequenceNumber)
+			ck := start.checkpoint
+			for {
+				var n int
+				iterator, n, done, err = input.readShard(sRunner, start.shardId,
+					iterator, &ck)
+				c.Assume(err, gs.IsNil)
+				if done || n < input.config.RecordsLimit {
+					input.shardsLock.Lock()
+					delete(input.running, start.shardId)
+					input.shardsLock.Unlock()
+					return done
+				}
+			}
+		}
+
+		stream.shards = []kinesisShard{{ShardId: "shard-0"}}
+		stream.put("shard-0", "old")
+
+		c.Specify("starts new shards at the configured position", func() {
+			starts, err := input.shardsToStart(stream.shards)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(starts), gs.Equals, 1)
+			c.Expect(starts[0].iteratorType, gs.Equals, "LATEST")
+
+			// A running shard isn't started twice.
+			starts, err = input.shardsToStart(stream.shards)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(starts), gs.Equals, 0)
+		})
+
+		c.Specify("resumes from the checkpoint file", func() {
+			starts, _ := input.shardsToStart(stream.shards)
+			stream.put("shard-0", "a", "b", "c")
+			drain(starts[0])
+			c.Expect(len(processed), gs.Equals, 3)
+			c.Expect(processed[0], gs.Equals, "a")
+
+			stream.put("shard-0", "d")
+			processed = processed[:0]
+			input.checkpoints, err = newFileCheckpointer(ckPath)
+			c.Assume(err, gs.IsNil)
+			input.running = make(map[string]bool)
+			starts, _ = input.shardsToStart(stream.shards)
+			c.Expect(starts[0].iteratorType, gs.Equals, "AFTER_SEQUENCE_NUMBER")
+			c.Expect(starts[0].checkpoint.SequenceNumber, gs.Equals, "4")
+			drain(starts[0])
+			c.Expect(len(processed), gs.Equals, 1)
+			c.Expect(processed[0], gs.Equals, "d")
+		})
+
+		c.Specify("reads a split shard's children after the parent", func() {
+			starts, _ := input.shardsToStart(stream.shards)
+			stream.put("shard-0", "a")
+			drain(starts[0])
+
+			stream.shards = append(stream.shards,
+				kinesisShard{ShardId: "shard-1", ParentShardId: "shard-0"},
+				kinesisShard{ShardId: "shard-2", ParentShardId: "shard-0"})
+			stream.put("shard-0", "b")
+			stream.put("shard-1", "c")
+			stream.put("shard-2", "d")
+			stream.closed["shard-0"] = true
+
+			// The children wait until the parent has been read to its end.
+			starts, _ = input.shardsToStart(stream.shards)
+			c.Expect(len(starts), gs.Equals, 1)
+			c.Expect(starts[0].shardId, gs.Equals, "shard-0")
+			c.Expect(drain(starts[0]), gs.IsTrue)
+
+			starts, _ = input.shardsToStart(stream.shards)
+			c.Expect(len(starts), gs.Equals, 2)
+			for _, start := range starts {
+				c.Expect(start.iteratorType, gs.Equals, "TRIM_HORIZON")
+				drain(start)
+			}
+			c.Expect(len(processed), gs.Equals, 4)
+			c.Expect(processed[1], gs.Equals, "b")
+
+			// The closed parent isn't read again after a restart.
+			input.checkpoints, err = newFileCheckpointer(ckPath)
+			c.Assume(err, gs.IsNil)
+			input.finished = make(map[string]bool)
+			starts, _ = input.shardsToStart(stream.shards[:1])
+			c.Expect(len(starts), gs.Equals, 0)
+		})
+
+		os.Remove(ckPath)
+	})
+}
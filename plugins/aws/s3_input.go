@@ -0,0 +1,344 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/AdRoll/goamz/s3"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// s3Bucket is the subset of the goamz bucket API used by S3Input, split out
+// so tests can substitute an in-memory bucket.
+type s3Bucket interface {
+	List(prefix, delim, marker string, max int) (*s3.ListResp, error)
+	GetReader(path string) (io.ReadCloser, error)
+}
+
+type S3InputConfig struct {
+	// AWS region the bucket lives in. Defaults to "us-east-1".
+	Region string `toml:"aws_region"`
+	// Credentials to use. If left empty the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY environment variables or the EC2 instance role
+	// will be used instead.
+	AccessKeyId     string `toml:"aws_access_key_id"`
+	SecretAccessKey string `toml:"aws_secret_access_key"`
+	// Name of a shared AwsSession resource providing the region and
+	// credentials instead.
+	SessionResource string `toml:"session_resource"`
+	// Bucket to poll.
+	Bucket string `toml:"s3_bucket"`
+	// Only objects whose keys begin with this prefix will be processed.
+	Prefix string `toml:"s3_prefix"`
+	// Number of keys requested per bucket listing call. Defaults to 1000.
+	ListBatchSize int `toml:"list_batch_size"`
+	// How often, in seconds, the bucket is polled for new objects. Defaults
+	// to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Path of the file recording which objects have already been
+	// processed. Defaults to "s3/<plugin name>.checkpoint" under base_dir.
+	CheckpointFile string `toml:"checkpoint_file"`
+	// So we can default to TokenSplitter.
+	Splitter string
+}
+
+type S3Input struct {
+	processObjectCount    int64
+	processObjectFailures int64
+	processBytes          int64
+
+	config     *S3InputConfig
+	name       string
+	pConfig    *pipeline.PipelineConfig
+	bucket     s3Bucket
+	checkpoint *s3Checkpoint
+	ir         pipeline.InputRunner
+	hostname   string
+	stopChan   chan bool
+}
+
+func (s *S3Input) SetName(name string) {
+	s.name = name
+}
+
+func (s *S3Input) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	s.pConfig = pConfig
+}
+
+func (s *S3Input) ConfigStruct() interface{} {
+	return &S3InputConfig{
+		Region:         "us-east-1",
+		ListBatchSize:  1000,
+		TickerInterval: 60,
+		Splitter:       "TokenSplitter",
+	}
+}
+
+func (s *S3Input) Init(config interface{}) (err error) {
+	s.config = config.(*S3InputConfig)
+	if s.config.Bucket == "" {
+		return errors.New("'s3_bucket' must be specified")
+	}
+	if s.config.ListBatchSize <= 0 {
+		return errors.New("'list_batch_size' must be greater than zero")
+	}
+	auth, region, err := pluginCredentials(s.pConfig, s.config.SessionResource,
+		s.config.Region, s.config.AccessKeyId, s.config.SecretAccessKey)
+	if err != nil {
+		return err
+	}
+	s.bucket = s3.New(auth, region).Bucket(s.config.Bucket)
+
+	ckPath := s.config.CheckpointFile
+	if ckPath == "" {
+		ckPath = filepath.Join("s3", s.name+".checkpoint")
+	}
+	ckPath = s.pConfig.Globals.PrependBaseDir(ckPath)
+	if err = os.MkdirAll(filepath.Dir(ckPath), 0766); err != nil {
+		return err
+	}
+	if s.checkpoint, err = readS3Checkpoint(ckPath); err != nil {
+		return fmt.Errorf("can't read checkpoint file: %s", err)
+	}
+	s.stopChan = make(chan bool)
+	return nil
+}
+
+func (s *S3Input) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) error {
+	s.ir = ir
+	s.hostname = h.Hostname()
+	sRunner := ir.NewSplitterRunner("")
+	defer sRunner.Done()
+
+	tickChan := ir.Ticker()
+	oneShot := s.pConfig.Globals.OneShot
+	for {
+		s.poll(sRunner)
+		if oneShot {
+			// The objects listed so far are all we process.
+			return nil
+		}
+		select {
+		case <-s.stopChan:
+			return nil
+		case <-tickChan:
+		}
+	}
+}
+
+func (s *S3Input) isStopping() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+// poll walks the full bucket listing, processing every object that hasn't
+// been checkpointed yet or whose ETag has changed since it was processed.
+func (s *S3Input) poll(sRunner pipeline.SplitterRunner) {
+	var (
+		marker string
+		listed = make(map[string]bool)
+	)
+	for {
+		resp, err := s.bucket.List(s.config.Prefix, "", marker, s.config.ListBatchSize)
+		if err != nil {
+			s.ir.LogError(fmt.Errorf("listing bucket '%s': %s", s.config.Bucket, err))
+			return
+		}
+		for _, key := range resp.Contents {
+			listed[key.Key] = true
+			marker = key.Key
+			if strings.HasSuffix(key.Key, "/") || s.checkpoint.Keys[key.Key] == key.ETag {
+				continue
+			}
+			if s.isStopping() {
+				return
+			}
+			if err = s.processObject(sRunner, key); err != nil {
+				atomic.AddInt64(&s.processObjectFailures, 1)
+				s.ir.LogError(fmt.Errorf("processing object '%s': %s", key.Key, err))
+				continue
+			}
+			atomic.AddInt64(&s.processObjectCount, 1)
+			s.checkpoint.Keys[key.Key] = key.ETag
+			if err = s.checkpoint.write(); err != nil {
+				s.ir.LogError(fmt.Errorf("writing checkpoint: %s", err))
+			}
+		}
+		if !resp.IsTruncated {
+			break
+		}
+		if resp.NextMarker != "" {
+			marker = resp.NextMarker
+		}
+	}
+
+	// Forget about objects that have been removed from the bucket so the
+	// checkpoint doesn't grow without bound.
+	pruned := false
+	for key := range s.checkpoint.Keys {
+		if !listed[key] {
+			delete(s.checkpoint.Keys, key)
+			pruned = true
+		}
+	}
+	if pruned {
+		if err := s.checkpoint.write(); err != nil {
+			s.ir.LogError(fmt.Errorf("writing checkpoint: %s", err))
+		}
+	}
+}
+
+func (s *S3Input) processObject(sRunner pipeline.SplitterRunner, key s3.Key) error {
+	n, err := splitObject(sRunner, s.bucket, key.Key, func(pack *pipeline.PipelinePack) {
+		pack.Message.SetType("heka.s3")
+		pack.Message.SetLogger(s.name)
+		pack.Message.SetHostname(s.hostname)
+		message.NewStringField(pack.Message, "S3Bucket", s.config.Bucket)
+		message.NewStringField(pack.Message, "S3Key", key.Key)
+	})
+	atomic.AddInt64(&s.processBytes, n)
+	return err
+}
+
+// splitObject downloads an object and hands its contents to the splitter,
+// returning the number of (uncompressed) bytes read.
+func splitObject(sRunner pipeline.SplitterRunner, bucket s3Bucket, key string,
+	decorator func(*pipeline.PipelinePack)) (int64, error) {
+
+	body, err := bucket.GetReader(key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	reader, err := objectReader(body)
+	if err != nil {
+		return 0, err
+	}
+	counter := &countingReader{r: reader}
+
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(decorator)
+	}
+	for err == nil {
+		err = sRunner.SplitStream(counter, nil)
+	}
+	// Objects are complete, so any trailing data without a final delimiter
+	// is still a record.
+	if record := sRunner.GetRemainingData(); len(record) > 0 {
+		sRunner.DeliverRecord(record, nil)
+	}
+	if err != io.EOF {
+		return counter.n, err
+	}
+	return counter.n, nil
+}
+
+// objectReader transparently decompresses objects compressed with any of
+// the registered compression codecs, most AWS services deliver their logs
+// gzipped. Detection is based on the codecs' magic numbers rather than the
+// key name.
+func objectReader(body io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(body)
+	// Short objects return what's there along with an error.
+	magic, _ := buffered.Peek(maxCompressionMagic)
+	if _, codec := pipeline.DetectCompressionCodec(magic); codec != nil {
+		return codec.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// Number of bytes peeked at to detect compressed objects, enough for the
+// longest magic number of the built-in codecs.
+const maxCompressionMagic = 16
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (n int, err error) {
+	n, err = c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (s *S3Input) Stop() {
+	close(s.stopChan)
+}
+
+func (s *S3Input) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessObjectCount",
+		atomic.LoadInt64(&s.processObjectCount), "count")
+	message.NewInt64Field(msg, "ProcessObjectFailures",
+		atomic.LoadInt64(&s.processObjectFailures), "count")
+	message.NewInt64Field(msg, "ProcessBytes",
+		atomic.LoadInt64(&s.processBytes), "B")
+	return nil
+}
+
+// s3Checkpoint records the ETag of every object that has been processed,
+// keyed by object key.
+type s3Checkpoint struct {
+	path string
+	Keys map[string]string
+}
+
+func readS3Checkpoint(path string) (*s3Checkpoint, error) {
+	ck := &s3Checkpoint{path: path, Keys: make(map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ck, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &ck.Keys); err != nil {
+		return nil, err
+	}
+	return ck, nil
+}
+
+// write replaces the checkpoint file atomically so a crash can't leave a
+// truncated checkpoint behind.
+func (ck *s3Checkpoint) write() error {
+	data, err := json.Marshal(ck.Keys)
+	if err != nil {
+		return err
+	}
+	tmpPath := ck.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ck.path)
+}
+
+func init() {
+	pipeline.RegisterPlugin("S3Input", func() interface{} {
+		return new(S3Input)
+	})
+}
@@ -0,0 +1,192 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdRoll/goamz/s3"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// memBucket is an in-memory s3Bucket that pages its listing two keys at a
+// time to exercise marker handling.
+type memBucket struct {
+	keys    []s3.Key
+	objects map[string][]byte
+}
+
+func (b *memBucket) put(key, etag string, data []byte) {
+	for i, k := range b.keys {
+		if k.Key == key {
+			b.keys[i].ETag = etag
+			b.objects[key] = data
+			return
+		}
+	}
+	b.keys = append(b.keys, s3.Key{Key: key, ETag: etag, Size: int64(len(data))})
+	b.objects[key] = data
+}
+
+func (b *memBucket) remove(key string) {
+	for i, k := range b.keys {
+		if k.Key == key {
+			b.keys = append(b.keys[:i], b.keys[i+1:]...)
+			break
+		}
+	}
+	delete(b.objects, key)
+}
+
+func (b *memBucket) List(prefix, delim, marker string, max int) (*s3.ListResp, error) {
+	resp := new(s3.ListResp)
+	for _, k := range b.keys {
+		if k.Key <= marker || !strings.HasPrefix(k.Key, prefix) {
+			continue
+		}
+		if len(resp.Contents) == 2 {
+			resp.IsTruncated = true
+			break
+		}
+		resp.Contents = append(resp.Contents, k)
+	}
+	return resp, nil
+}
+
+func (b *memBucket) GetReader(path string) (io.ReadCloser, error) {
+	data, ok := b.objects[path]
+	if !ok {
+		return nil, errors.New("NoSuchKey")
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func S3InputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "s3input-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	ckPath := filepath.Join(tmpDir, "s3", "S3Input.checkpoint")
+
+	c.Specify("An S3Input", func() {
+		bucket := &memBucket{objects: make(map[string][]byte)}
+		input := &S3Input{
+			config:   &S3InputConfig{Bucket: "logs", ListBatchSize: 2},
+			name:     "S3Input",
+			bucket:   bucket,
+			stopChan: make(chan bool),
+		}
+		os.MkdirAll(filepath.Dir(ckPath), 0755)
+		input.checkpoint, err = readS3Checkpoint(ckPath)
+		c.Assume(err, gs.IsNil)
+
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		input.ir = ir
+
+		var processed []string
+		sRunner.EXPECT().UseMsgBytes().Return(false).AnyTimes()
+		sRunner.EXPECT().SetPackDecorator(gomock.Any()).AnyTimes()
+		sRunner.EXPECT().GetRemainingData().Return(nil).AnyTimes()
+		sRunner.EXPECT().SplitStream(gomock.Any(), nil).Return(io.EOF).AnyTimes().Do(
+			func(r io.Reader, del Deliverer) {
+				data, _ := ioutil.ReadAll(r)
+				processed = append(processed, string(data))
+			})
+
+		bucket.put("a.log", "1", []byte("first"))
+		bucket.put("b.log", "1", []byte("second"))
+		bucket.put("c.log", "1", []byte("third"))
+
+		c.Specify("processes every object across listing pages", func() {
+			input.poll(sRunner)
+			c.Expect(len(processed), gs.Equals, 3)
+			c.Expect(processed[2], gs.Equals, "third")
+			c.Expect(len(input.checkpoint.Keys), gs.Equals, 3)
+		})
+
+		c.Specify("only processes new or changed objects", func() {
+			input.poll(sRunner)
+			processed = processed[:0]
+			bucket.put("b.log", "2", []byte("second, again"))
+			bucket.put("d.log", "1", []byte("fourth"))
+			input.poll(sRunner)
+			c.Expect(len(processed), gs.Equals, 2)
+			c.Expect(processed[0], gs.Equals, "second, again")
+			c.Expect(processed[1], gs.Equals, "fourth")
+		})
+
+		c.Specify("resumes from the checkpoint file", func() {
+			input.poll(sRunner)
+			processed = processed[:0]
+			input.checkpoint, err = readS3Checkpoint(ckPath)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(input.checkpoint.Keys), gs.Equals, 3)
+			input.poll(sRunner)
+			c.Expect(len(processed), gs.Equals, 0)
+		})
+
+		c.Specify("forgets objects removed from the bucket", func() {
+			input.poll(sRunner)
+			bucket.remove("a.log")
+			input.poll(sRunner)
+			_, ok := input.checkpoint.Keys["a.log"]
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(len(input.checkpoint.Keys), gs.Equals, 2)
+		})
+
+		c.Specify("decompresses gzipped objects", func() {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte("zipped"))
+			gz.Close()
+			bucket.put("e.log.gz", "1", buf.Bytes())
+			input.poll(sRunner)
+			c.Expect(processed[3], gs.Equals, "zipped")
+		})
+
+		c.Specify("decompresses objects of other registered codecs", func() {
+			codec, err := GetCompressionCodec("snappy")
+			c.Assume(err, gs.IsNil)
+			data, err := Compress(codec, []byte("snapped"))
+			c.Assume(err, gs.IsNil)
+			bucket.put("e.log.sz", "1", data)
+			input.poll(sRunner)
+			c.Expect(processed[3], gs.Equals, "snapped")
+		})
+
+		c.Specify("retries objects that failed to download", func() {
+			bucket.keys = append(bucket.keys, s3.Key{Key: "missing.log", ETag: "1"})
+			ir.EXPECT().LogError(gomock.Any())
+			input.poll(sRunner)
+			_, ok := input.checkpoint.Keys["missing.log"]
+			c.Expect(ok, gs.IsFalse)
+			c.Expect(input.processObjectFailures, gs.Equals, int64(1))
+		})
+
+		os.Remove(ckPath)
+	})
+}
@@ -0,0 +1,331 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	"github.com/AdRoll/goamz/s3"
+	"github.com/AdRoll/goamz/sqs"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// sqsQueue is the subset of the goamz queue API used by SqsInput.
+type sqsQueue interface {
+	ReceiveMessageWithParameters(p map[string]string) (*sqs.ReceiveMessageResponse, error)
+	DeleteMessage(m *sqs.Message) (*sqs.DeleteMessageResponse, error)
+	ChangeMessageVisibility(m *sqs.Message, timeout int) (
+		*sqs.ChangeMessageVisibilityResponse, error)
+}
+
+type SqsInputConfig struct {
+	// AWS region the queue lives in. Defaults to "us-east-1".
+	Region string `toml:"aws_region"`
+	// Credentials to use. If left empty the AWS_ACCESS_KEY_ID and
+	// AWS_SECRET_ACCESS_KEY environment variables or the EC2 instance role
+	// will be used instead.
+	AccessKeyId     string `toml:"aws_access_key_id"`
+	SecretAccessKey string `toml:"aws_secret_access_key"`
+	// Name of a shared AwsSession resource providing the region and
+	// credentials instead.
+	SessionResource string `toml:"session_resource"`
+	// Name of the queue to consume.
+	Queue string
+	// Seconds each receive call will wait for messages to arrive. Defaults
+	// to 20, the maximum SQS allows.
+	WaitTime uint `toml:"wait_time"`
+	// Maximum number of messages fetched per receive call, 1 to 10. Defaults
+	// to 10.
+	MaxMessages uint `toml:"max_messages"`
+	// Seconds received messages are hidden from other consumers. Defaults to
+	// 300.
+	VisibilityTimeout uint `toml:"visibility_timeout"`
+	// Seconds before a message that failed to process becomes visible again.
+	// Defaults to 30.
+	RetryDelay uint `toml:"retry_delay"`
+	// If true message bodies are treated as S3 event notifications, possibly
+	// delivered via SNS, and the referenced objects are processed instead of
+	// the bodies themselves.
+	S3Notifications bool `toml:"s3_notifications"`
+}
+
+type SqsInput struct {
+	processMessageCount    int64
+	processMessageFailures int64
+	processBytes           int64
+
+	config    *SqsInputConfig
+	name      string
+	pConfig   *pipeline.PipelineConfig
+	auth      aws.Auth
+	region    aws.Region
+	queue     sqsQueue
+	buckets   map[string]s3Bucket
+	newBucket func(region aws.Region, name string) s3Bucket
+	ir        pipeline.InputRunner
+	hostname  string
+	stopChan  chan bool
+}
+
+func (s *SqsInput) SetName(name string) {
+	s.name = name
+}
+
+func (s *SqsInput) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	s.pConfig = pConfig
+}
+
+func (s *SqsInput) ConfigStruct() interface{} {
+	return &SqsInputConfig{
+		Region:            "us-east-1",
+		WaitTime:          20,
+		MaxMessages:       10,
+		VisibilityTimeout: 300,
+		RetryDelay:        30,
+	}
+}
+
+func (s *SqsInput) Init(config interface{}) (err error) {
+	s.config = config.(*SqsInputConfig)
+	if s.config.Queue == "" {
+		return errors.New("'queue' must be specified")
+	}
+	if s.config.WaitTime > 20 {
+		return errors.New("'wait_time' can't be greater than 20")
+	}
+	if s.config.MaxMessages < 1 || s.config.MaxMessages > 10 {
+		return errors.New("'max_messages' must be between 1 and 10")
+	}
+	if s.config.VisibilityTimeout == 0 {
+		return errors.New("'visibility_timeout' must be greater than zero")
+	}
+
+	if s.auth, s.region, err = pluginCredentials(s.pConfig, s.config.SessionResource,
+		s.config.Region, s.config.AccessKeyId, s.config.SecretAccessKey); err != nil {
+		return err
+	}
+	if s.queue, err = sqs.New(s.auth, s.region).GetQueue(s.config.Queue); err != nil {
+		return fmt.Errorf("can't get queue '%s': %s", s.config.Queue, err)
+	}
+	s.buckets = make(map[string]s3Bucket)
+	s.newBucket = func(region aws.Region, name string) s3Bucket {
+		return s3.New(s.auth, region).Bucket(name)
+	}
+	s.stopChan = make(chan bool)
+	return nil
+}
+
+func (s *SqsInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) error {
+	s.ir = ir
+	s.hostname = h.Hostname()
+	sRunner := ir.NewSplitterRunner("")
+	defer sRunner.Done()
+
+	params := map[string]string{
+		"MaxNumberOfMessages": strconv.Itoa(int(s.config.MaxMessages)),
+		"VisibilityTimeout":   strconv.Itoa(int(s.config.VisibilityTimeout)),
+		"WaitTimeSeconds":     strconv.Itoa(int(s.config.WaitTime)),
+	}
+	for !s.isStopping() {
+		resp, err := s.queue.ReceiveMessageWithParameters(params)
+		if err != nil {
+			ir.LogError(fmt.Errorf("receiving from queue '%s': %s", s.config.Queue, err))
+			select {
+			case <-s.stopChan:
+			case <-time.After(time.Duration(s.config.RetryDelay) * time.Second):
+			}
+			continue
+		}
+		for i := range resp.Messages {
+			s.handleMessage(sRunner, &resp.Messages[i], time.Now())
+		}
+	}
+	return nil
+}
+
+func (s *SqsInput) isStopping() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+// handleMessage processes a single queue message. It's only deleted from the
+// queue once all of its data has been handed to the pipeline, otherwise it's
+// made visible again after `retry_delay` seconds.
+func (s *SqsInput) handleMessage(sRunner pipeline.SplitterRunner, m *sqs.Message,
+	received time.Time) {
+
+	var err error
+	if s.config.S3Notifications {
+		err = s.processNotification(sRunner, m, received)
+	} else {
+		s.deliverBody(sRunner, m)
+	}
+	if err != nil {
+		atomic.AddInt64(&s.processMessageFailures, 1)
+		s.ir.LogError(fmt.Errorf("processing message '%s': %s", m.MessageId, err))
+		if _, err = s.queue.ChangeMessageVisibility(m, int(s.config.RetryDelay)); err != nil {
+			s.ir.LogError(fmt.Errorf("changing visibility of message '%s': %s",
+				m.MessageId, err))
+		}
+		return
+	}
+	atomic.AddInt64(&s.processMessageCount, 1)
+	if _, err = s.queue.DeleteMessage(m); err != nil {
+		s.ir.LogError(fmt.Errorf("deleting message '%s': %s", m.MessageId, err))
+	}
+}
+
+func (s *SqsInput) decorator(m *sqs.Message, fields ...string) func(*pipeline.PipelinePack) {
+	return func(pack *pipeline.PipelinePack) {
+		pack.Message.SetType("heka.sqs")
+		pack.Message.SetLogger(s.name)
+		pack.Message.SetHostname(s.hostname)
+		message.NewStringField(pack.Message, "SqsQueue", s.config.Queue)
+		message.NewStringField(pack.Message, "SqsMessageId", m.MessageId)
+		for i := 0; i+1 < len(fields); i += 2 {
+			message.NewStringField(pack.Message, fields[i], fields[i+1])
+		}
+	}
+}
+
+func (s *SqsInput) deliverBody(sRunner pipeline.SplitterRunner, m *sqs.Message) {
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(s.decorator(m))
+	}
+	body := []byte(m.Body)
+	atomic.AddInt64(&s.processBytes, int64(len(body)))
+	// The body is complete, so anything left over after splitting is still a
+	// record.
+	n, _ := sRunner.SplitBytes(body, nil)
+	if n < len(body) {
+		sRunner.DeliverRecord(body[n:], nil)
+	}
+}
+
+type s3EventRecord struct {
+	EventName string `json:"eventName"`
+	AwsRegion string `json:"awsRegion"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// parseS3Notification extracts the object records from an S3 event
+// notification, unwrapping it from an SNS envelope if necessary.
+func parseS3Notification(body string) ([]s3EventRecord, error) {
+	var doc struct {
+		Type    string
+		Message string
+		Records []s3EventRecord
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return nil, fmt.Errorf("invalid S3 notification: %s", err)
+	}
+	if doc.Type == "Notification" && doc.Message != "" {
+		return parseS3Notification(doc.Message)
+	}
+	records := doc.Records[:0]
+	for _, record := range doc.Records {
+		// Only creation events reference an object we can fetch; test events
+		// and deletions are acknowledged and dropped.
+		if strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (s *SqsInput) processNotification(sRunner pipeline.SplitterRunner, m *sqs.Message,
+	received time.Time) error {
+
+	records, err := parseS3Notification(m.Body)
+	if err != nil {
+		return err
+	}
+	visibility := time.Duration(s.config.VisibilityTimeout) * time.Second
+	for _, record := range records {
+		// Large objects can take a while, keep the message hidden from other
+		// consumers until we're done with it.
+		if time.Since(received) > visibility/2 {
+			if _, err = s.queue.ChangeMessageVisibility(m,
+				int(s.config.VisibilityTimeout)); err != nil {
+				return fmt.Errorf("extending visibility timeout: %s", err)
+			}
+			received = time.Now()
+		}
+		// Keys in event notifications are URL encoded.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return fmt.Errorf("invalid object key '%s': %s", record.S3.Object.Key, err)
+		}
+		bucketName := record.S3.Bucket.Name
+		n, err := splitObject(sRunner, s.bucket(record.AwsRegion, bucketName), key,
+			s.decorator(m, "S3Bucket", bucketName, "S3Key", key))
+		atomic.AddInt64(&s.processBytes, n)
+		if err != nil {
+			return fmt.Errorf("processing object '%s/%s': %s", bucketName, key, err)
+		}
+	}
+	return nil
+}
+
+func (s *SqsInput) bucket(regionName, name string) s3Bucket {
+	region, ok := aws.Regions[regionName]
+	if !ok {
+		region = s.region
+	}
+	cacheKey := region.Name + "/" + name
+	bucket, ok := s.buckets[cacheKey]
+	if !ok {
+		bucket = s.newBucket(region, name)
+		s.buckets[cacheKey] = bucket
+	}
+	return bucket
+}
+
+func (s *SqsInput) Stop() {
+	close(s.stopChan)
+}
+
+func (s *SqsInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&s.processMessageCount), "count")
+	message.NewInt64Field(msg, "ProcessMessageFailures",
+		atomic.LoadInt64(&s.processMessageFailures), "count")
+	message.NewInt64Field(msg, "ProcessBytes",
+		atomic.LoadInt64(&s.processBytes), "B")
+	return nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("SqsInput", func() interface{} {
+		return new(SqsInput)
+	})
+}
@@ -0,0 +1,175 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package aws
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/AdRoll/goamz/aws"
+	"github.com/AdRoll/goamz/sqs"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+type memQueue struct {
+	deleted    []string
+	visibility map[string]int
+}
+
+func (q *memQueue) ReceiveMessageWithParameters(p map[string]string) (
+	*sqs.ReceiveMessageResponse, error) {
+
+	return nil, errors.New("not implemented")
+}
+
+func (q *memQueue) DeleteMessage(m *sqs.Message) (*sqs.DeleteMessageResponse, error) {
+	q.deleted = append(q.deleted, m.MessageId)
+	return new(sqs.DeleteMessageResponse), nil
+}
+
+func (q *memQueue) ChangeMessageVisibility(m *sqs.Message, timeout int) (
+	*sqs.ChangeMessageVisibilityResponse, error) {
+
+	q.visibility[m.MessageId] = timeout
+	return new(sqs.ChangeMessageVisibilityResponse), nil
+}
+
+const s3Event = `{"Records": [
+	{"eventName": "ObjectCreated:Put", "awsRegion": "us-west-2",
+	 "s3": {"bucket": {"name": "logs"}, "object": {"key": "a+b.log"}}},
+	{"eventName": "ObjectRemoved:Delete", "awsRegion": "us-west-2",
+	 "s3": {"bucket": {"name": "logs"}, "object": {"key": "gone.log"}}}
+]}`
+
+func SqsInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("An SqsInput", func() {
+		queue := &memQueue{visibility: make(map[string]int)}
+		bucket := &memBucket{objects: make(map[string][]byte)}
+		var bucketRegion string
+		input := &SqsInput{
+			config: &SqsInputConfig{
+				Queue:             "logs",
+				VisibilityTimeout: 300,
+				RetryDelay:        30,
+			},
+			name:    "SqsInput",
+			queue:   queue,
+			region:  aws.USEast,
+			buckets: make(map[string]s3Bucket),
+			newBucket: func(region aws.Region, name string) s3Bucket {
+				bucketRegion = region.Name
+				return bucket
+			},
+		}
+
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sRunner := pipelinemock.NewMockSplitterRunner(ctrl)
+		input.ir = ir
+
+		var delivered []string
+		sRunner.EXPECT().UseMsgBytes().Return(false).AnyTimes()
+		sRunner.EXPECT().SetPackDecorator(gomock.Any()).AnyTimes()
+		sRunner.EXPECT().GetRemainingData().Return(nil).AnyTimes()
+
+		c.Specify("delivers the message body and deletes the message", func() {
+			m := &sqs.Message{MessageId: "1", Body: "hello"}
+			sRunner.EXPECT().SplitBytes([]byte("hello"), nil).Return(5, nil)
+			input.handleMessage(sRunner, m, time.Now())
+			c.Expect(len(queue.deleted), gs.Equals, 1)
+			c.Expect(queue.deleted[0], gs.Equals, "1")
+		})
+
+		c.Specify("delivers trailing data the splitter didn't consume", func() {
+			m := &sqs.Message{MessageId: "1", Body: "one\ntwo"}
+			sRunner.EXPECT().SplitBytes([]byte("one\ntwo"), nil).Return(4, nil)
+			sRunner.EXPECT().DeliverRecord([]byte("two"), nil)
+			input.handleMessage(sRunner, m, time.Now())
+			c.Expect(len(queue.deleted), gs.Equals, 1)
+		})
+
+		c.Specify("with S3 notifications", func() {
+			input.config.S3Notifications = true
+			sRunner.EXPECT().SplitStream(gomock.Any(), nil).Return(io.EOF).AnyTimes().Do(
+				func(r io.Reader, del Deliverer) {
+					data, _ := ioutil.ReadAll(r)
+					delivered = append(delivered, string(data))
+				})
+
+			c.Specify("processes the referenced objects", func() {
+				bucket.put("a b.log", "1", []byte("object data"))
+				m := &sqs.Message{MessageId: "2", Body: s3Event}
+				input.handleMessage(sRunner, m, time.Now())
+				c.Expect(len(delivered), gs.Equals, 1)
+				c.Expect(delivered[0], gs.Equals, "object data")
+				c.Expect(bucketRegion, gs.Equals, "us-west-2")
+				c.Expect(len(queue.deleted), gs.Equals, 1)
+			})
+
+			c.Specify("retries the message if an object can't be fetched", func() {
+				m := &sqs.Message{MessageId: "3", Body: s3Event}
+				ir.EXPECT().LogError(gomock.Any())
+				input.handleMessage(sRunner, m, time.Now())
+				c.Expect(len(queue.deleted), gs.Equals, 0)
+				c.Expect(queue.visibility["3"], gs.Equals, 30)
+			})
+
+			c.Specify("extends the visibility timeout for slow messages", func() {
+				bucket.put("a b.log", "1", []byte("object data"))
+				m := &sqs.Message{MessageId: "4", Body: s3Event}
+				input.handleMessage(sRunner, m, time.Now().Add(-200*time.Second))
+				c.Expect(queue.visibility["4"], gs.Equals, 300)
+				c.Expect(len(queue.deleted), gs.Equals, 1)
+			})
+		})
+	})
+
+	c.Specify("S3 notification parsing", func() {
+		c.Specify("ignores events that don't create objects", func() {
+			records, err := parseS3Notification(s3Event)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0].S3.Object.Key, gs.Equals, "a+b.log")
+		})
+
+		c.Specify("unwraps SNS envelopes", func() {
+			body := `{"Type": "Notification", "Message": "{\"Records\": [{\"eventName\": ` +
+				`\"ObjectCreated:Put\", \"s3\": {\"bucket\": {\"name\": \"logs\"}, ` +
+				`\"object\": {\"key\": \"x.log\"}}}]}"}`
+			records, err := parseS3Notification(body)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(records), gs.Equals, 1)
+			c.Expect(records[0].S3.Bucket.Name, gs.Equals, "logs")
+		})
+
+		c.Specify("accepts S3 test events", func() {
+			records, err := parseS3Notification(
+				`{"Service": "Amazon S3", "Event": "s3:TestEvent"}`)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(records), gs.Equals, 0)
+		})
+
+		c.Specify("rejects malformed bodies", func() {
+			_, err := parseS3Notification("not json")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -56,6 +56,23 @@ func (o *DefaultsTestOutput) Run(fr FilterRunner, h PluginHelper) (err error) {
 	return
 }
 
+type TestResource struct {
+	Value string
+}
+
+type TestResourceConfig struct {
+	Value string
+}
+
+func (r *TestResource) ConfigStruct() interface{} {
+	return new(TestResourceConfig)
+}
+
+func (r *TestResource) Init(config interface{}) error {
+	r.Value = config.(*TestResourceConfig).Value
+	return nil
+}
+
 func LoadFromConfigSpec(c gs.Context) {
 	origAvailablePlugins := make(map[string]func() interface{})
 	for k, v := range AvailablePlugins {
@@ -252,6 +269,58 @@ func LoadFromConfigSpec(c gs.Context) {
 			c.Expect(matcher, gs.Equals, messageMatchStr)
 		})
 
+		c.Specify("works w/ shared resources", func() {
+			RegisterPlugin("DefaultsTestOutput", func() interface{} {
+				return new(DefaultsTestOutput)
+			})
+			RegisterResource("TestResource", func() interface{} {
+				return new(TestResource)
+			})
+			defer delete(AvailableResources, "TestResource")
+			err := pipeConfig.PreloadFromConfigFile("./testsupport/config_test_resources.toml")
+			c.Assume(err, gs.IsNil)
+			err = pipeConfig.LoadConfig()
+			c.Assume(err, gs.IsNil)
+
+			resource, err := pipeConfig.Resource("shared")
+			c.Expect(err, gs.IsNil)
+			testResource, ok := resource.(*TestResource)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(testResource.Value, gs.Equals, "shared value")
+
+			_, err = pipeConfig.Resource("missing")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("errors correctly w/ unknown resource types", func() {
+			RegisterPlugin("DefaultsTestOutput", func() interface{} {
+				return new(DefaultsTestOutput)
+			})
+			err := pipeConfig.PreloadFromConfigFile("./testsupport/config_test_resources.toml")
+			c.Assume(err, gs.IsNil)
+			err = pipeConfig.LoadConfig()
+			c.Assume(err, gs.Not(gs.IsNil))
+			c.Expect(pipeConfig.LogMsgs[0], ts.StringContains,
+				"no registered resource type 'TestResource'")
+		})
+
+		c.Specify("runs decoder test fixtures", func() {
+			err := pipeConfig.PreloadFromConfigFile("./testsupport/config_test_decoder_fixtures.toml")
+			c.Assume(err, gs.IsNil)
+			err = pipeConfig.LoadConfig()
+			c.Expect(err, gs.IsNil)
+		})
+
+		c.Specify("errors correctly w/ failing decoder test fixtures", func() {
+			err := pipeConfig.PreloadFromConfigFile("./testsupport/config_bad_decoder_fixtures.toml")
+			c.Assume(err, gs.IsNil)
+			err = pipeConfig.LoadConfig()
+			c.Assume(err, gs.Not(gs.IsNil))
+			c.Expect(pipeConfig.LogMsgs[0], ts.StringContains,
+				"test 'simple get' for decoder 'access_decoder' failed")
+			c.Expect(pipeConfig.LogMsgs[0], ts.StringContains, "field 'StatusCode'")
+		})
+
 		c.Specify("can render JSON reports as pipe delimited data", func() {
 			RegisterPlugin("DefaultsTestOutput", func() interface{} {
 				return new(DefaultsTestOutput)
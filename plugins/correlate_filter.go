@@ -0,0 +1,273 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type CorrelateFilterConfig struct {
+	// Message variable holding the id shared by a request and its response,
+	// e.g. "Fields[request_id]".
+	CorrelationField string `toml:"correlation_field"`
+	// Matcher for request messages, all other messages are responses.
+	RequestMatcher string `toml:"request_matcher"`
+	// Number of seconds a message waits for its counterpart before it's
+	// flagged as unmatched. Defaults to 30.
+	Timeout uint
+	// Maximum number of messages waiting for their counterpart. The oldest
+	// one is flagged as unmatched to make room. Defaults to 10000.
+	MaxPending uint `toml:"max_pending"`
+	// Prepended to the names of the response fields added to the merged
+	// messages. Defaults to "response_".
+	ResponsePrefix string `toml:"response_prefix"`
+	// Type of the merged messages, unmatched ones get ".unmatched" appended.
+	// Defaults to "heka.correlated".
+	MessageType string `toml:"message_type"`
+	// Interval in seconds at which timed out messages are flagged. Defaults
+	// to 5.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// A message waiting for its counterpart.
+type correlatePending struct {
+	id        string
+	msg       *message.Message
+	request   bool
+	arrived   time.Time
+	loopCount uint
+	// Position in the arrival ordered list.
+	elem *list.Element
+}
+
+// CorrelateFilter joins request and response messages sharing a correlation
+// id into a single message with the latency between them, and flags requests
+// and responses whose counterpart doesn't arrive within a timeout.
+type CorrelateFilter struct {
+	conf    *CorrelateFilterConfig
+	id      *messageKey
+	request *message.MatcherSpecification
+	timeout time.Duration
+	// Pending requests and responses, keyed by correlation id.
+	requests  map[string]*correlatePending
+	responses map[string]*correlatePending
+	// All pending messages, oldest first.
+	arrivals *list.List
+	// Counters accessed atomically.
+	matched   int64
+	unmatched int64
+	pending   int64
+	now       func() time.Time
+	fr        FilterRunner
+	h         PluginHelper
+}
+
+func (f *CorrelateFilter) ConfigStruct() interface{} {
+	return &CorrelateFilterConfig{
+		Timeout:        30,
+		MaxPending:     10000,
+		ResponsePrefix: "response_",
+		MessageType:    "heka.correlated",
+		TickerInterval: 5,
+	}
+}
+
+func (f *CorrelateFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*CorrelateFilterConfig)
+	if f.conf.CorrelationField == "" {
+		return errors.New("'correlation_field' must be specified")
+	}
+	if f.id, err = newMessageKey([]string{f.conf.CorrelationField}); err != nil {
+		return fmt.Errorf("correlation_field: %s", err)
+	}
+	if f.conf.RequestMatcher == "" {
+		return errors.New("'request_matcher' must be specified")
+	}
+	if f.request, err = message.CreateMatcherSpecification(f.conf.RequestMatcher); err != nil {
+		return fmt.Errorf("invalid request_matcher: %s", err)
+	}
+	if f.conf.Timeout == 0 {
+		return errors.New("'timeout' must be greater than 0")
+	}
+	if f.conf.MaxPending == 0 {
+		return errors.New("'max_pending' must be greater than 0")
+	}
+	if f.conf.MessageType == "" {
+		return errors.New("'message_type' must not be empty")
+	}
+	if f.conf.TickerInterval == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	f.timeout = time.Duration(f.conf.Timeout) * time.Second
+	f.requests = make(map[string]*correlatePending)
+	f.responses = make(map[string]*correlatePending)
+	f.arrivals = list.New()
+	f.now = time.Now
+	return nil
+}
+
+func (f *CorrelateFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Returns the pending messages of the given kind.
+func (f *CorrelateFilter) pendingOf(request bool) map[string]*correlatePending {
+	if request {
+		return f.requests
+	}
+	return f.responses
+}
+
+// Stops waiting for a message's counterpart.
+func (f *CorrelateFilter) remove(p *correlatePending) {
+	delete(f.pendingOf(p.request), p.id)
+	f.arrivals.Remove(p.elem)
+	atomic.AddInt64(&f.pending, -1)
+}
+
+func (f *CorrelateFilter) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	id := f.id.key(msg)
+	if id == "" {
+		return nil
+	}
+	isRequest := f.request.Match(msg)
+	if other, ok := f.pendingOf(!isRequest)[id]; ok {
+		f.remove(other)
+		if isRequest {
+			return f.injectMatch(id, msg, other.msg, pack.MsgLoopCount)
+		}
+		return f.injectMatch(id, other.msg, msg, pack.MsgLoopCount)
+	}
+
+	// A repeated request or response replaces the one already waiting.
+	if old, ok := f.pendingOf(isRequest)[id]; ok {
+		f.remove(old)
+		if err := f.injectUnmatched(old); err != nil {
+			return err
+		}
+	}
+	if f.arrivals.Len() >= int(f.conf.MaxPending) {
+		oldest := f.arrivals.Front().Value.(*correlatePending)
+		f.remove(oldest)
+		if err := f.injectUnmatched(oldest); err != nil {
+			return err
+		}
+	}
+	p := &correlatePending{
+		id:        id,
+		msg:       message.CopyMessage(msg),
+		request:   isRequest,
+		arrived:   f.now(),
+		loopCount: pack.MsgLoopCount,
+	}
+	p.elem = f.arrivals.PushBack(p)
+	f.pendingOf(isRequest)[id] = p
+	atomic.AddInt64(&f.pending, 1)
+	return nil
+}
+
+// Injects a copy of the request with the response's fields and the latency
+// between them added.
+func (f *CorrelateFilter) injectMatch(id string, req, resp *message.Message,
+	loopCount uint) error {
+
+	pack, err := f.h.PipelinePack(loopCount)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	uuid := msg.GetUuid()
+	req.Copy(msg)
+	msg.SetUuid(uuid)
+	msg.SetType(f.conf.MessageType)
+	for _, field := range resp.Fields {
+		copied := message.CopyField(field)
+		name := f.conf.ResponsePrefix + field.GetName()
+		copied.Name = &name
+		msg.AddField(copied)
+	}
+	message.NewStringField(msg, "CorrelationId", id)
+	message.NewStringField(msg, "RequestType", req.GetType())
+	message.NewStringField(msg, "ResponseType", resp.GetType())
+	latency := float64(resp.GetTimestamp()-req.GetTimestamp()) / float64(time.Millisecond)
+	addDoubleField(msg, "Latency", latency, "ms")
+	atomic.AddInt64(&f.matched, 1)
+	f.fr.Inject(pack)
+	return nil
+}
+
+// Injects a copy of a message whose counterpart never arrived.
+func (f *CorrelateFilter) injectUnmatched(p *correlatePending) error {
+	pack, err := f.h.PipelinePack(p.loopCount)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	uuid := msg.GetUuid()
+	p.msg.Copy(msg)
+	msg.SetUuid(uuid)
+	msg.SetType(f.conf.MessageType + ".unmatched")
+	message.NewStringField(msg, "CorrelationId", p.id)
+	message.NewStringField(msg, "OriginalType", p.msg.GetType())
+	if p.request {
+		message.NewStringField(msg, "Unmatched", "request")
+	} else {
+		message.NewStringField(msg, "Unmatched", "response")
+	}
+	atomic.AddInt64(&f.unmatched, 1)
+	f.fr.Inject(pack)
+	return nil
+}
+
+// Flags the messages that waited for longer than the timeout.
+func (f *CorrelateFilter) TimerEvent() error {
+	now := f.now()
+	for e := f.arrivals.Front(); e != nil; e = f.arrivals.Front() {
+		p := e.Value.(*correlatePending)
+		if now.Sub(p.arrived) < f.timeout {
+			break
+		}
+		f.remove(p)
+		if err := f.injectUnmatched(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReportMsg provides the number of matched, unmatched and pending messages to
+// Heka's report and dashboard.
+func (f *CorrelateFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Matched", atomic.LoadInt64(&f.matched), "count")
+	message.NewInt64Field(msg, "Unmatched", atomic.LoadInt64(&f.unmatched),
+		"count")
+	message.NewInt64Field(msg, "Pending", atomic.LoadInt64(&f.pending), "count")
+	return nil
+}
+
+func (f *CorrelateFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("CorrelateFilter", func() interface{} {
+		return new(CorrelateFilter)
+	})
+}
@@ -0,0 +1,177 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CorrelateFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A CorrelateFilter", func() {
+		filter := new(CorrelateFilter)
+		config := filter.ConfigStruct().(*CorrelateFilterConfig)
+		config.CorrelationField = "Fields[request_id]"
+		config.RequestMatcher = "Type == 'api.request'"
+
+		c.Specify("requires a correlation field", func() {
+			config.CorrelationField = ""
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an invalid request matcher", func() {
+			config.RequestMatcher = "Type =="
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("when running", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			now := time.Unix(1e9, 0)
+			send := func(typ, id string, status int64) {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message = new(message.Message)
+				pack.Message.SetType(typ)
+				pack.Message.SetTimestamp(now.UnixNano())
+				message.NewStringField(pack.Message, "request_id", id)
+				if typ == "api.request" {
+					message.NewStringField(pack.Message, "path", "/users")
+				} else {
+					message.NewInt64Field(pack.Message, "status", status, "")
+				}
+				c.Expect(filter.ProcessMessage(pack), gs.IsNil)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.now = func() time.Time { return now }
+			}
+
+			c.Specify("merges a request with its response", func() {
+				start()
+				send("api.request", "r1", 0)
+				now = now.Add(250 * time.Millisecond)
+				send("api.response", "r1", 200)
+
+				c.Assume(len(injected), gs.Equals, 1)
+				msg := injected[0]
+				c.Expect(msg.GetType(), gs.Equals, "heka.correlated")
+				c.Expect(value(msg, "CorrelationId"), gs.Equals, "r1")
+				c.Expect(value(msg, "path"), gs.Equals, "/users")
+				c.Expect(value(msg, "response_status"), gs.Equals, int64(200))
+				c.Expect(value(msg, "RequestType"), gs.Equals, "api.request")
+				c.Expect(value(msg, "ResponseType"), gs.Equals, "api.response")
+				c.Expect(value(msg, "Latency"), gs.Equals, 250.0)
+				c.Expect(filter.arrivals.Len(), gs.Equals, 0)
+			})
+
+			c.Specify("merges a response arriving first", func() {
+				start()
+				now = now.Add(time.Second)
+				send("api.response", "r1", 500)
+				now = now.Add(-time.Second)
+				send("api.request", "r1", 0)
+
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "path"), gs.Equals, "/users")
+				c.Expect(value(injected[0], "Latency"), gs.Equals, 1000.0)
+			})
+
+			c.Specify("flags messages whose counterpart times out", func() {
+				config.Timeout = 10
+				start()
+				send("api.request", "r1", 0)
+				now = now.Add(5 * time.Second)
+				send("api.response", "r2", 404)
+
+				now = now.Add(6 * time.Second)
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				msg := injected[0]
+				c.Expect(msg.GetType(), gs.Equals, "heka.correlated.unmatched")
+				c.Expect(value(msg, "CorrelationId"), gs.Equals, "r1")
+				c.Expect(value(msg, "Unmatched"), gs.Equals, "request")
+				c.Expect(value(msg, "OriginalType"), gs.Equals, "api.request")
+
+				now = now.Add(5 * time.Second)
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 2)
+				c.Expect(value(injected[1], "Unmatched"), gs.Equals, "response")
+				c.Expect(value(injected[1], "status"), gs.Equals, int64(404))
+
+				// A late counterpart waits for a match of its own.
+				send("api.response", "r1", 200)
+				c.Expect(len(injected), gs.Equals, 2)
+			})
+
+			c.Specify("flags a request replaced by a repeated one", func() {
+				start()
+				send("api.request", "r1", 0)
+				send("api.request", "r1", 0)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "Unmatched"), gs.Equals, "request")
+				send("api.response", "r1", 200)
+				c.Expect(len(injected), gs.Equals, 2)
+				c.Expect(injected[1].GetType(), gs.Equals, "heka.correlated")
+			})
+
+			c.Specify("flags the oldest message when too many are pending", func() {
+				config.MaxPending = 2
+				start()
+				send("api.request", "r1", 0)
+				send("api.request", "r2", 0)
+				send("api.request", "r3", 0)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "CorrelationId"), gs.Equals, "r1")
+
+				msg := new(message.Message)
+				c.Expect(filter.ReportMsg(msg), gs.IsNil)
+				c.Expect(value(msg, "Unmatched"), gs.Equals, int64(1))
+				c.Expect(value(msg, "Pending"), gs.Equals, int64(2))
+			})
+
+			c.Specify("ignores messages without a correlation id", func() {
+				start()
+				pack := NewPipelinePack(recycleChan)
+				pack.Message = new(message.Message)
+				pack.Message.SetType("api.request")
+				c.Expect(filter.ProcessMessage(pack), gs.IsNil)
+				c.Expect(filter.arrivals.Len(), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -53,6 +53,9 @@ type DashboardOutputConfig struct {
 	MessageMatcher string
 	// Custom http headers
 	Headers http.Header
+	// Name of an AccessControl resource; if set the dashboard is only
+	// served to requests presenting a token with the "view" role.
+	AclResource string `toml:"acl_resource"`
 }
 
 func (self *DashboardOutput) ConfigStruct() interface{} {
@@ -154,9 +157,17 @@ func (self *DashboardOutput) Init(config interface{}) (err error) {
 		}
 		self.handler = http.FileServer(http.Dir(self.workingDirectory))
 	}
+	handler := self.handler
+	if conf.AclResource != "" {
+		acl, err := self.pConfig.AccessControl(conf.AclResource)
+		if err != nil {
+			return fmt.Errorf("DashboardOutput: %s", err)
+		}
+		handler = acl.Handler(handler, RoleView)
+	}
 	self.server = &http.Server{
 		Addr:         conf.Address,
-		Handler:      httpPlugin.CustomHeadersHandler(self.handler, conf.Headers),
+		Handler:      httpPlugin.CustomHeadersHandler(handler, conf.Headers),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
@@ -0,0 +1,197 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Length of the payload excerpt included in duplicate reports when messages
+// are keyed by their payload.
+const dedupeExcerptLength = 80
+
+type DedupeFilterConfig struct {
+	// Message variables making up the key messages are compared by, e.g.
+	// ["Hostname", "Fields[error]"]. Defaults to a hash of the payload.
+	KeyFields []string `toml:"key_fields"`
+	// Number of seconds a key has to go unseen before a message with it is
+	// let through again. Defaults to 300.
+	Window uint
+	// Whether to emit the number of duplicates suppressed per key every
+	// ticker_interval. Defaults to false.
+	ReportDuplicates bool `toml:"report_duplicates"`
+	// Appended to the Type of the injected messages. Defaults to ".deduped".
+	TypeSuffix string `toml:"type_suffix"`
+	// Interval in seconds at which duplicate reports are emitted and expired
+	// keys are forgotten. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+type dedupeEntry struct {
+	last       time.Time
+	duplicates int64
+	// Start of the payload of the first message, if keyed by payload.
+	excerpt string
+}
+
+// DedupeFilter injects copies of the messages it receives, except for those
+// whose key was already seen within the window.
+type DedupeFilter struct {
+	conf    *DedupeFilterConfig
+	key     *messageKey
+	window  time.Duration
+	entries map[string]*dedupeEntry
+	// Total number of duplicates suppressed, accessed atomically.
+	duplicates int64
+	now        func() time.Time
+	fr         FilterRunner
+	h          PluginHelper
+}
+
+func (f *DedupeFilter) ConfigStruct() interface{} {
+	return &DedupeFilterConfig{
+		Window:         300,
+		TypeSuffix:     ".deduped",
+		TickerInterval: 60,
+	}
+}
+
+func (f *DedupeFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*DedupeFilterConfig)
+	if f.conf.Window == 0 {
+		return errors.New("'window' must be greater than 0")
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	if f.conf.TickerInterval == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	if len(f.conf.KeyFields) > 0 {
+		if f.key, err = newMessageKey(f.conf.KeyFields); err != nil {
+			return fmt.Errorf("key_fields: %s", err)
+		}
+	}
+	f.window = time.Duration(f.conf.Window) * time.Second
+	f.entries = make(map[string]*dedupeEntry)
+	f.now = time.Now
+	return nil
+}
+
+func (f *DedupeFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Returns the message's key and, if it's keyed by payload, a payload excerpt.
+func (f *DedupeFilter) keyOf(msg *message.Message) (key, excerpt string) {
+	if f.key != nil {
+		return f.key.key(msg), ""
+	}
+	payload := msg.GetPayload()
+	hash := fnv.New64a()
+	hash.Write([]byte(payload))
+	if len(payload) > dedupeExcerptLength {
+		payload = payload[:dedupeExcerptLength]
+	}
+	return fmt.Sprintf("%016x", hash.Sum64()), payload
+}
+
+func (f *DedupeFilter) ProcessMessage(pack *PipelinePack) error {
+	now := f.now()
+	key, excerpt := f.keyOf(pack.Message)
+	entry, ok := f.entries[key]
+	if ok && now.Sub(entry.last) < f.window {
+		// The window slides with every duplicate.
+		entry.last = now
+		entry.duplicates++
+		atomic.AddInt64(&f.duplicates, 1)
+		return nil
+	}
+	if !ok {
+		entry = &dedupeEntry{excerpt: excerpt}
+		f.entries[key] = entry
+	}
+	entry.last = now
+
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *DedupeFilter) TimerEvent() error {
+	now := f.now()
+	for key, entry := range f.entries {
+		if entry.duplicates > 0 && f.conf.ReportDuplicates {
+			if err := f.injectReport(key, entry); err != nil {
+				return err
+			}
+		}
+		entry.duplicates = 0
+		if now.Sub(entry.last) >= f.window {
+			delete(f.entries, key)
+		}
+	}
+	return nil
+}
+
+func (f *DedupeFilter) injectReport(key string, entry *dedupeEntry) error {
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetType("heka.dedupe.duplicates")
+	msg.SetLogger(f.fr.Name())
+	msg.SetSeverity(6)
+	msg.SetPayload(fmt.Sprintf("%d duplicates of key '%s' suppressed in the last %ds",
+		entry.duplicates, key, f.conf.TickerInterval))
+	message.NewStringField(msg, "Dedupe", f.fr.Name())
+	message.NewStringField(msg, "Key", key)
+	if entry.excerpt != "" {
+		message.NewStringField(msg, "Excerpt", entry.excerpt)
+	}
+	message.NewInt64Field(msg, "Duplicates", entry.duplicates, "count")
+	f.fr.Inject(pack)
+	return nil
+}
+
+// ReportMsg provides the number of duplicates suppressed to Heka's report and
+// dashboard.
+func (f *DedupeFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Duplicates", atomic.LoadInt64(&f.duplicates),
+		"count")
+	return nil
+}
+
+func (f *DedupeFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("DedupeFilter", func() interface{} {
+		return new(DedupeFilter)
+	})
+}
@@ -0,0 +1,138 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DedupeFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A DedupeFilter", func() {
+		filter := new(DedupeFilter)
+		config := filter.ConfigStruct().(*DedupeFilterConfig)
+		config.Window = 60
+
+		c.Specify("requires a window", func() {
+			config.Window = 0
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown key fields", func() {
+			config.KeyFields = []string{"Fields[error"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("suppresses duplicates", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			fr.EXPECT().Name().Return("dedupe").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			now := time.Unix(1e9, 0)
+			send := func(payload, host string) {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message = new(message.Message)
+				pack.Message.SetType("app.error")
+				pack.Message.SetHostname(host)
+				pack.Message.SetPayload(payload)
+				filter.ProcessMessage(pack)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.now = func() time.Time { return now }
+			}
+
+			c.Specify("by payload", func() {
+				start()
+				send("disk full", "a")
+				send("disk full", "b")
+				send("out of memory", "a")
+				c.Assume(len(injected), gs.Equals, 2)
+				c.Expect(injected[0].GetType(), gs.Equals, "app.error.deduped")
+				c.Expect(injected[1].GetPayload(), gs.Equals, "out of memory")
+
+				// Each duplicate slides the window.
+				injected = nil
+				now = now.Add(45 * time.Second)
+				send("disk full", "a")
+				now = now.Add(45 * time.Second)
+				send("disk full", "a")
+				c.Expect(len(injected), gs.Equals, 0)
+				now = now.Add(60 * time.Second)
+				send("disk full", "a")
+				c.Expect(len(injected), gs.Equals, 1)
+
+				msg := new(message.Message)
+				filter.ReportMsg(msg)
+				c.Expect(value(msg, "Duplicates"), gs.Equals, int64(3))
+			})
+
+			c.Specify("by key fields", func() {
+				config.KeyFields = []string{"Hostname"}
+				start()
+				send("disk full", "a")
+				send("out of memory", "a")
+				send("disk full", "b")
+				c.Expect(len(injected), gs.Equals, 2)
+			})
+
+			c.Specify("reporting duplicates", func() {
+				config.ReportDuplicates = true
+				start()
+				send("disk full", "a")
+				send("disk full", "a")
+				send("disk full", "a")
+				send("out of memory", "a")
+				injected = nil
+				err := filter.TimerEvent()
+				c.Expect(err, gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				report := injected[0]
+				c.Expect(report.GetType(), gs.Equals, "heka.dedupe.duplicates")
+				c.Expect(value(report, "Duplicates"), gs.Equals, int64(2))
+				c.Expect(value(report, "Excerpt"), gs.Equals, "disk full")
+
+				// Expired keys are forgotten.
+				injected = nil
+				now = now.Add(time.Minute)
+				filter.TimerEvent()
+				c.Expect(len(injected), gs.Equals, 0)
+				c.Expect(len(filter.entries), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -204,6 +204,10 @@ func (o *ElasticSearchOutput) batchSender() {
 			ok = false
 			continue
 		case pack := <-o.recvChan:
+			// Keep the bulk request within the output's max_request_size.
+			if !o.or.FitsRequest(len(o.outBatch), len(pack.bytes)) {
+				o.sendBatch()
+			}
 			o.outBatch = append(o.outBatch, pack.bytes...)
 			o.queueCursor = pack.queueCursor
 			o.count++
@@ -0,0 +1,266 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type EnrichFilterConfig struct {
+	// Path of the lookup file, relative to the share_dir unless absolute.
+	LookupFile string `toml:"lookup_file"`
+	// "csv" or "json". Defaults to the lookup file's extension.
+	Format string
+	// Message variable looked up, e.g. "Hostname" or "Fields[host]".
+	KeyField string `toml:"key_field"`
+	// CSV column holding the keys. Defaults to the first column.
+	KeyColumn string `toml:"key_column"`
+	// Columns added as fields. Defaults to all columns but the key column.
+	Columns []string
+	// Prepended to the names of the added fields.
+	FieldPrefix string `toml:"field_prefix"`
+	// Seconds between checks for a changed lookup file, 0 disables them.
+	// Defaults to 60.
+	ReloadInterval uint `toml:"reload_interval"`
+	// Appended to the Type of the injected messages. Defaults to
+	// ".enriched".
+	TypeSuffix string `toml:"type_suffix"`
+}
+
+// Column values keyed by column name.
+type enrichRow map[string]interface{}
+
+// EnrichFilter injects copies of the messages it receives with fields added
+// from the row of a CSV or JSON lookup file matching one of their headers or
+// fields. The lookup file is reloaded when it changes.
+type EnrichFilter struct {
+	conf      *EnrichFilterConfig
+	pConfig   *PipelineConfig
+	path      string
+	key       *messageKey
+	table     map[string]enrichRow
+	reload    time.Duration
+	lastCheck time.Time
+	modTime   time.Time
+	size      int64
+	now       func() time.Time
+	fr        FilterRunner
+	h         PluginHelper
+}
+
+func (f *EnrichFilter) SetPipelineConfig(pConfig *PipelineConfig) {
+	f.pConfig = pConfig
+}
+
+func (f *EnrichFilter) ConfigStruct() interface{} {
+	return &EnrichFilterConfig{
+		ReloadInterval: 60,
+		TypeSuffix:     ".enriched",
+	}
+}
+
+func (f *EnrichFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*EnrichFilterConfig)
+	if f.conf.LookupFile == "" {
+		return errors.New("'lookup_file' must be specified")
+	}
+	f.path = f.pConfig.Globals.PrependShareDir(f.conf.LookupFile)
+	if f.conf.Format == "" {
+		f.conf.Format = strings.TrimPrefix(filepath.Ext(f.path), ".")
+	}
+	if f.conf.Format != "csv" && f.conf.Format != "json" {
+		return fmt.Errorf("unknown format '%s', must be 'csv' or 'json'",
+			f.conf.Format)
+	}
+	if f.conf.KeyField == "" {
+		return errors.New("'key_field' must be specified")
+	}
+	if f.key, err = newMessageKey([]string{f.conf.KeyField}); err != nil {
+		return fmt.Errorf("key_field: %s", err)
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	f.reload = time.Duration(f.conf.ReloadInterval) * time.Second
+	f.now = time.Now
+	if err = f.load(); err != nil {
+		return fmt.Errorf("can't load lookup file: %s", err)
+	}
+	f.lastCheck = f.now()
+	return nil
+}
+
+// Satisfies the UsesFiles interface, so a config reload picks up a changed
+// lookup file even if the reload interval is 0.
+func (f *EnrichFilter) FilesUsed(config interface{}) []string {
+	conf := config.(*EnrichFilterConfig)
+	return []string{f.pConfig.Globals.PrependShareDir(conf.LookupFile)}
+}
+
+// Loads the lookup file, replacing the table in use on success.
+func (f *EnrichFilter) load() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	var table map[string]enrichRow
+	if f.conf.Format == "csv" {
+		table, err = f.readCsv(file)
+	} else {
+		table, err = f.readJson(file)
+	}
+	if err != nil {
+		return err
+	}
+	f.table = table
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	return nil
+}
+
+// Reads a CSV file whose first row holds the column names.
+func (f *EnrichFilter) readCsv(r io.Reader) (map[string]enrichRow, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("missing header row")
+	}
+	header := records[0]
+	keyCol := 0
+	if f.conf.KeyColumn != "" {
+		keyCol = -1
+		for i, name := range header {
+			if name == f.conf.KeyColumn {
+				keyCol = i
+				break
+			}
+		}
+		if keyCol < 0 {
+			return nil, fmt.Errorf("no '%s' column", f.conf.KeyColumn)
+		}
+	}
+	table := make(map[string]enrichRow, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(enrichRow, len(record)-1)
+		for i, value := range record {
+			if i != keyCol {
+				row[header[i]] = value
+			}
+		}
+		table[record[keyCol]] = row
+	}
+	return table, nil
+}
+
+// Reads a JSON object mapping keys to objects of column values.
+func (f *EnrichFilter) readJson(r io.Reader) (map[string]enrichRow, error) {
+	var table map[string]enrichRow
+	if err := json.NewDecoder(r).Decode(&table); err != nil {
+		return nil, err
+	}
+	return table, nil
+}
+
+// Reloads the lookup file if it changed since it was loaded, at most once per
+// reload interval. Replace the file by renaming a new one over it, so it's
+// never read half written.
+func (f *EnrichFilter) checkFile() {
+	now := f.now()
+	if f.reload == 0 || now.Sub(f.lastCheck) < f.reload {
+		return
+	}
+	f.lastCheck = now
+	fi, err := os.Stat(f.path)
+	if err != nil || (fi.ModTime().Equal(f.modTime) && fi.Size() == f.size) {
+		return
+	}
+	if err = f.load(); err != nil {
+		f.fr.LogError(fmt.Errorf("can't reload lookup file: %s", err))
+		return
+	}
+	f.fr.LogMessage("reloaded lookup file")
+}
+
+func (f *EnrichFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Adds the row's columns to the message.
+func (f *EnrichFilter) enrich(msg *message.Message, row enrichRow) {
+	add := func(name string, value interface{}) {
+		switch value.(type) {
+		case nil:
+			return
+		case map[string]interface{}, []interface{}:
+			// Nested JSON values are added in their JSON encoding.
+			encoded, _ := json.Marshal(value)
+			value = string(encoded)
+		}
+		if field, err := message.NewField(f.conf.FieldPrefix+name, value, ""); err == nil {
+			msg.AddField(field)
+		}
+	}
+	if len(f.conf.Columns) == 0 {
+		for name, value := range row {
+			add(name, value)
+		}
+		return
+	}
+	for _, name := range f.conf.Columns {
+		add(name, row[name])
+	}
+}
+
+func (f *EnrichFilter) ProcessMessage(pack *PipelinePack) error {
+	f.checkFile()
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	if row, ok := f.table[f.key.key(pack.Message)]; ok {
+		f.enrich(newPack.Message, row)
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *EnrichFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("EnrichFilter", func() interface{} {
+		return new(EnrichFilter)
+	})
+}
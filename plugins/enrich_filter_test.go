@@ -0,0 +1,143 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func EnrichFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "enrich-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	c.Specify("An EnrichFilter", func() {
+		filter := new(EnrichFilter)
+		filter.SetPipelineConfig(NewPipelineConfig(nil))
+		config := filter.ConfigStruct().(*EnrichFilterConfig)
+		config.KeyField = "Hostname"
+
+		csvPath := filepath.Join(tmpDir, "hosts.csv")
+		err := ioutil.WriteFile(csvPath, []byte("rack,host,owner\nr1,web1,ops\nr2,db1,dba\n"),
+			0644)
+		c.Assume(err, gs.IsNil)
+		jsonPath := filepath.Join(tmpDir, "hosts.json")
+		err = ioutil.WriteFile(jsonPath,
+			[]byte(`{"web1": {"rack": "r1", "weight": 2, "tags": ["a", "b"]}}`), 0644)
+		c.Assume(err, gs.IsNil)
+
+		c.Specify("requires a readable lookup file", func() {
+			config.LookupFile = filepath.Join(tmpDir, "missing.csv")
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown formats", func() {
+			config.LookupFile = filepath.Join(tmpDir, "hosts.txt")
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects a missing key column", func() {
+			config.LookupFile = csvPath
+			config.KeyColumn = "hostname"
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("enriches messages", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			send := func(host string) *message.Message {
+				injected = nil
+				pack := NewPipelinePack(recycleChan)
+				pack.Message.SetType("app.log")
+				pack.Message.SetHostname(host)
+				err := filter.ProcessMessage(pack)
+				c.Expect(err, gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				return injected[0]
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+
+			c.Specify("from a CSV file", func() {
+				config.LookupFile = csvPath
+				config.KeyColumn = "host"
+				config.FieldPrefix = "host_"
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				now := time.Now()
+				filter.now = func() time.Time { return now }
+
+				msg := send("db1")
+				c.Expect(msg.GetType(), gs.Equals, "app.log.enriched")
+				c.Expect(value(msg, "host_rack"), gs.Equals, "r2")
+				c.Expect(value(msg, "host_owner"), gs.Equals, "dba")
+				c.Expect(msg.FindFirstField("host_host"), gs.IsNil)
+
+				msg = send("cache1")
+				c.Expect(len(msg.Fields), gs.Equals, 0)
+
+				// A changed file is reloaded once the reload interval passed.
+				newPath := csvPath + ".new"
+				err = ioutil.WriteFile(newPath,
+					[]byte("rack,host,owner\nr3,cache1,ops\n"), 0644)
+				c.Assume(err, gs.IsNil)
+				c.Assume(os.Rename(newPath, csvPath), gs.IsNil)
+				msg = send("cache1")
+				c.Expect(len(msg.Fields), gs.Equals, 0)
+
+				fr.EXPECT().LogMessage("reloaded lookup file")
+				now = now.Add(time.Minute)
+				msg = send("cache1")
+				c.Expect(value(msg, "host_rack"), gs.Equals, "r3")
+			})
+
+			c.Specify("from a JSON file", func() {
+				config.LookupFile = jsonPath
+				config.Columns = []string{"weight", "tags", "missing"}
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+
+				msg := send("web1")
+				c.Expect(len(msg.Fields), gs.Equals, 2)
+				c.Expect(value(msg, "weight"), gs.Equals, 2.0)
+				c.Expect(value(msg, "tags"), gs.Equals, `["a","b"]`)
+			})
+		})
+	})
+}
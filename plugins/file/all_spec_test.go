@@ -23,8 +23,13 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(DropBoxInputSpec)
 	r.AddSpec(FileOutputSpec)
 	r.AddSpec(FilePollingInputSpec)
+	r.AddSpec(ManifestSpec)
+	r.AddSpec(ProtobufReplayInputSpec)
+	r.AddSpec(RetentionInputSpec)
+	r.AddSpec(StdinInputSpec)
 
 	gospec.MainGoTest(r, t)
 }
@@ -0,0 +1,271 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type DropBoxInputConfig struct {
+	// Directory that files are dropped into, relative paths are relative to
+	// Heka's base_dir.
+	SpoolDir string `toml:"spool_dir"`
+	// Number of seconds between scans of the spool directory. Defaults to 5.
+	TickerInterval uint `toml:"ticker_interval"`
+	// What to do with a file once it has been processed, "archive" to move
+	// it to `archive_dir` or "delete" to remove it. Defaults to "archive".
+	Action string
+	// Directory processed files are moved to, required for the "archive"
+	// action.
+	ArchiveDir string `toml:"archive_dir"`
+	// Directory files that couldn't be processed are moved to. If unset
+	// they are left in the spool directory and skipped until Heka restarts.
+	ErrorDir string `toml:"error_dir"`
+	// Regular expression matched against the file names, only matching files
+	// are processed. Defaults to all files.
+	FileMatch string `toml:"file_match"`
+	// Files modified more recently than this duration are considered to
+	// still be in the process of being written. Defaults to "10s".
+	SettleTime string `toml:"settle_time"`
+}
+
+// Input plugin that processes each complete file dropped into a spool
+// directory, splitting it into records, and then archives or deletes it.
+// Hidden files are never processed so that writers can upload to a dot file
+// and rename it once complete.
+type DropBoxInput struct {
+	processedFiles int64
+	failedFiles    int64
+
+	conf       *DropBoxInputConfig
+	spoolDir   string
+	archiveDir string
+	errorDir   string
+	match      *regexp.Regexp
+	settleTime time.Duration
+	// Files that failed and couldn't be moved out of the way.
+	failed   map[string]bool
+	fileName string
+	hostname string
+	stopChan chan bool
+	ir       InputRunner
+	pConfig  *PipelineConfig
+}
+
+func (d *DropBoxInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	d.pConfig = pConfig
+}
+
+func (d *DropBoxInput) ConfigStruct() interface{} {
+	return &DropBoxInputConfig{
+		TickerInterval: 5,
+		Action:         "archive",
+		SettleTime:     "10s",
+	}
+}
+
+func (d *DropBoxInput) Init(config interface{}) (err error) {
+	d.conf = config.(*DropBoxInputConfig)
+	if d.conf.SpoolDir == "" {
+		return errors.New("DropBoxInput: `spool_dir` setting is required")
+	}
+	globals := d.pConfig.Globals
+	d.spoolDir = filepath.Clean(globals.PrependBaseDir(d.conf.SpoolDir))
+
+	switch d.conf.Action {
+	case "archive":
+		if d.conf.ArchiveDir == "" {
+			return errors.New("DropBoxInput: `archive_dir` is required to archive files")
+		}
+		d.archiveDir = filepath.Clean(globals.PrependBaseDir(d.conf.ArchiveDir))
+		if err = os.MkdirAll(d.archiveDir, 0755); err != nil {
+			return fmt.Errorf("DropBoxInput: can't create `archive_dir`: %s", err)
+		}
+	case "delete":
+	default:
+		return fmt.Errorf("DropBoxInput: unknown action '%s', must be 'archive' or 'delete'",
+			d.conf.Action)
+	}
+
+	if d.conf.ErrorDir != "" {
+		d.errorDir = filepath.Clean(globals.PrependBaseDir(d.conf.ErrorDir))
+		if err = os.MkdirAll(d.errorDir, 0755); err != nil {
+			return fmt.Errorf("DropBoxInput: can't create `error_dir`: %s", err)
+		}
+	}
+	if d.conf.FileMatch != "" {
+		if d.match, err = regexp.Compile(d.conf.FileMatch); err != nil {
+			return fmt.Errorf("DropBoxInput: invalid `file_match`: %s", err)
+		}
+	}
+	if d.settleTime, err = time.ParseDuration(d.conf.SettleTime); err != nil {
+		return fmt.Errorf("DropBoxInput: invalid `settle_time`: %s", err)
+	}
+	d.failed = make(map[string]bool)
+	d.stopChan = make(chan bool)
+	return nil
+}
+
+func (d *DropBoxInput) packDecorator(pack *PipelinePack) {
+	pack.Message.SetType("heka.dropbox")
+	pack.Message.SetLogger(d.ir.Name())
+	pack.Message.SetHostname(d.hostname)
+	message.NewStringField(pack.Message, "FileName", d.fileName)
+}
+
+func (d *DropBoxInput) Run(ir InputRunner, h PluginHelper) error {
+	d.ir = ir
+	d.hostname = h.PipelineConfig().Hostname()
+	sRunner := ir.NewSplitterRunner("")
+	defer sRunner.Done()
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(d.packDecorator)
+	}
+
+	ticker := ir.Ticker()
+	for {
+		d.scan(sRunner, time.Now())
+		select {
+		case <-ticker:
+		case <-d.stopChan:
+			return nil
+		}
+	}
+}
+
+// Processes every complete file in the spool directory, oldest first.
+func (d *DropBoxInput) scan(sRunner SplitterRunner, now time.Time) {
+	infos, err := ioutil.ReadDir(d.spoolDir)
+	if err != nil {
+		d.ir.LogError(fmt.Errorf("reading spool directory: %s", err))
+		return
+	}
+	files := make([]retentionFile, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || d.failed[name] {
+			continue
+		}
+		if d.match != nil && !d.match.MatchString(name) {
+			continue
+		}
+		if now.Sub(info.ModTime()) < d.settleTime {
+			continue
+		}
+		files = append(files, retentionFile{filepath.Join(d.spoolDir, name), info})
+	}
+	sort.Sort(byModTime(files))
+
+	for _, f := range files {
+		select {
+		case <-d.stopChan:
+			return
+		default:
+		}
+		d.process(sRunner, f.path)
+	}
+}
+
+func (d *DropBoxInput) process(sRunner SplitterRunner, path string) {
+	d.fileName = filepath.Base(path)
+	if err := d.splitFile(sRunner, path); err != nil {
+		atomic.AddInt64(&d.failedFiles, 1)
+		d.ir.LogError(fmt.Errorf("processing '%s': %s", d.fileName, err))
+		if d.errorDir == "" {
+			d.failed[d.fileName] = true
+		} else if err = moveFile(path, d.errorDir); err != nil {
+			d.ir.LogError(fmt.Errorf("moving '%s' to `error_dir`: %s", d.fileName, err))
+			d.failed[d.fileName] = true
+		}
+		return
+	}
+	atomic.AddInt64(&d.processedFiles, 1)
+
+	var err error
+	if d.archiveDir != "" {
+		err = moveFile(path, d.archiveDir)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		// Make sure the file isn't processed a second time.
+		d.ir.LogError(fmt.Errorf("can't %s '%s': %s", d.conf.Action, d.fileName, err))
+		d.failed[d.fileName] = true
+	}
+}
+
+func (d *DropBoxInput) splitFile(sRunner SplitterRunner, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for err == nil {
+		err = sRunner.SplitStream(f, nil)
+	}
+	// The file is complete, so any trailing data without a final delimiter
+	// is still a record.
+	if record := sRunner.GetRemainingData(); len(record) > 0 {
+		sRunner.DeliverRecord(record, nil)
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// Moves a file into the given directory, adding a numeric suffix to the name
+// rather than overwriting an existing file. Both directories need to be on
+// the same file system.
+func moveFile(path, dir string) error {
+	name := filepath.Base(path)
+	target := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(target); err != nil {
+			break
+		}
+		target = filepath.Join(dir, name+"."+strconv.Itoa(i))
+	}
+	return os.Rename(path, target)
+}
+
+func (d *DropBoxInput) Stop() {
+	close(d.stopChan)
+}
+
+func (d *DropBoxInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessedFiles", atomic.LoadInt64(&d.processedFiles),
+		"count")
+	message.NewInt64Field(msg, "FailedFiles", atomic.LoadInt64(&d.failedFiles), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("DropBoxInput", func() interface{} {
+		return new(DropBoxInput)
+	})
+}
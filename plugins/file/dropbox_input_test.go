@@ -0,0 +1,164 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func DropBoxInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "dropbox-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	spoolDir := filepath.Join(tmpDir, "spool")
+	archiveDir := filepath.Join(tmpDir, "archive")
+	err = os.MkdirAll(spoolDir, 0755)
+	c.Assume(err, gs.IsNil)
+
+	now := time.Now()
+	// Drops a file into the spool directory, last modified `age` ago.
+	dropFile := func(name, contents string, age time.Duration) string {
+		path := filepath.Join(spoolDir, name)
+		err := ioutil.WriteFile(path, []byte(contents), 0644)
+		c.Assume(err, gs.IsNil)
+		mtime := now.Add(-age)
+		err = os.Chtimes(path, mtime, mtime)
+		c.Assume(err, gs.IsNil)
+		return path
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	pConfig := NewPipelineConfig(nil)
+	input := new(DropBoxInput)
+	input.SetPipelineConfig(pConfig)
+	config := input.ConfigStruct().(*DropBoxInputConfig)
+	config.SpoolDir = spoolDir
+	config.ArchiveDir = archiveDir
+
+	mockIR := pipelinemock.NewMockInputRunner(ctrl)
+	mockSR := pipelinemock.NewMockSplitterRunner(ctrl)
+	input.ir = mockIR
+
+	// Collects the contents of each file handed to the splitter.
+	var splitContents []string
+	mockSR.EXPECT().GetRemainingData().AnyTimes()
+	splitCall := mockSR.EXPECT().SplitStream(gomock.Any(), nil).AnyTimes()
+	splitCall.Do(func(r io.Reader, del Deliverer) {
+		contents, _ := ioutil.ReadAll(r)
+		splitContents = append(splitContents, string(contents))
+	})
+	splitCall.Return(io.EOF)
+
+	c.Specify("A DropBoxInput", func() {
+		c.Specify("requires a spool_dir", func() {
+			config.SpoolDir = ""
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires an archive_dir to archive files", func() {
+			config.ArchiveDir = ""
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown actions", func() {
+			config.Action = "copy"
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("processes complete files and archives them", func() {
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			second := dropFile("second.log", "two\n", time.Minute)
+			first := dropFile("first.log", "one\n", time.Hour)
+			hidden := dropFile(".upload.log", "hidden\n", time.Hour)
+			active := dropFile("active.log", "active\n", time.Second)
+
+			input.scan(mockSR, now)
+			c.Expect(len(splitContents), gs.Equals, 2)
+			c.Expect(splitContents[0], gs.Equals, "one\n")
+			c.Expect(splitContents[1], gs.Equals, "two\n")
+			c.Expect(exists(first), gs.IsFalse)
+			c.Expect(exists(second), gs.IsFalse)
+			c.Expect(exists(filepath.Join(archiveDir, "first.log")), gs.IsTrue)
+			c.Expect(exists(filepath.Join(archiveDir, "second.log")), gs.IsTrue)
+			c.Expect(exists(hidden), gs.IsTrue)
+			c.Expect(exists(active), gs.IsTrue)
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			val, _ := msg.GetFieldValue("ProcessedFiles")
+			c.Expect(val, gs.Equals, int64(2))
+
+			c.Specify("without overwriting archived files", func() {
+				dropFile("first.log", "again\n", time.Hour)
+				input.scan(mockSR, now)
+				c.Expect(len(splitContents), gs.Equals, 3)
+				c.Expect(exists(filepath.Join(archiveDir, "first.log.1")), gs.IsTrue)
+			})
+		})
+
+		c.Specify("deletes processed files", func() {
+			config.Action = "delete"
+			config.ArchiveDir = ""
+			config.FileMatch = `\.log$`
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			logFile := dropFile("batch.log", "data\n", time.Hour)
+			other := dropFile("batch.csv", "data\n", time.Hour)
+
+			input.scan(mockSR, now)
+			c.Expect(len(splitContents), gs.Equals, 1)
+			c.Expect(exists(logFile), gs.IsFalse)
+			c.Expect(exists(other), gs.IsTrue)
+		})
+
+		c.Specify("moves failed files to the error_dir", func() {
+			config.ErrorDir = filepath.Join(tmpDir, "errors")
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			bad := dropFile("bad.log", "data\n", time.Hour)
+			failingSR := pipelinemock.NewMockSplitterRunner(ctrl)
+			failingSR.EXPECT().SplitStream(gomock.Any(), nil).Return(errors.New("boom"))
+			failingSR.EXPECT().GetRemainingData()
+			mockIR.EXPECT().LogError(gomock.Any())
+
+			input.scan(failingSR, now)
+			c.Expect(exists(bad), gs.IsFalse)
+			c.Expect(exists(filepath.Join(config.ErrorDir, "bad.log")), gs.IsTrue)
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			val, _ := msg.GetFieldValue("FailedFiles")
+			c.Expect(val, gs.Equals, int64(1))
+		})
+	})
+}
@@ -54,6 +54,8 @@ type FileOutput struct {
 	timerChan  <-chan time.Time
 	rotateChan chan time.Time
 	closing    chan struct{}
+	codec      CompressionCodec
+	manifest   *manifestWriter
 }
 
 // ConfigStruct for FileOutput plugin.
@@ -96,6 +98,16 @@ type FileOutputConfig struct {
 	// false otherwise.
 	UseFraming *bool `toml:"use_framing"`
 
+	// Name of the compression codec to apply to the written data, e.g.
+	// "gzip" or "snappy" (default "none"). Each flushed batch is written as a
+	// separate compressed stream.
+	Compression string
+
+	// Checksum algorithm used to record a checksum for every flushed chunk in
+	// a manifest file next to the output file ("md5", "sha1", "sha256" or
+	// "sha512"). Empty (the default) disables the manifest.
+	Checksum string
+
 	BufferConfig *QueueBufferConfig `toml:"buffering"`
 }
 
@@ -149,6 +161,16 @@ func (o *FileOutput) Init(config interface{}) (err error) {
 		return err
 	}
 
+	if o.codec, err = GetCompressionCodec(conf.Compression); err != nil {
+		return fmt.Errorf("FileOutput '%s': %s", o.Path, err)
+	}
+
+	if conf.Checksum != "" {
+		if o.manifest, err = newManifestWriter(conf.Checksum); err != nil {
+			return fmt.Errorf("FileOutput '%s': %s", o.Path, err)
+		}
+	}
+
 	o.closing = make(chan struct{})
 	switch conf.RotationInterval {
 	case 0:
@@ -209,9 +231,21 @@ func (o *FileOutput) openFile() (err error) {
 		return
 	}
 	o.file, err = os.OpenFile(o.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, o.perm)
+	if err == nil && o.manifest != nil {
+		if err = o.manifest.open(o.file, o.path, o.perm); err != nil {
+			o.file.Close()
+		}
+	}
 	return
 }
 
+func (o *FileOutput) closeFile() {
+	o.file.Close()
+	if o.manifest != nil {
+		o.manifest.close()
+	}
+}
+
 func (o *FileOutput) Run(or OutputRunner, h PluginHelper) error {
 	enc := or.Encoder()
 	if enc == nil {
@@ -332,24 +366,43 @@ func (o *FileOutput) committer(or OutputRunner, errChan chan error) {
 		case out, ok = <-o.batchChan:
 			if !ok {
 				// Channel is closed => we're shutting down, exit cleanly.
-				o.file.Close()
+				o.closeFile()
 				close(o.closing)
 				break
 			}
-			n, err := o.file.Write(out.data)
+			data := out.data
+			if o.codec != nil {
+				if data, err = Compress(o.codec, data); err != nil {
+					or.LogError(fmt.Errorf("Can't compress output for %s: %s", o.path, err))
+					out.data = out.data[:0]
+					o.backChan <- out
+					continue
+				}
+			}
+			n, err := o.file.Write(data)
+			if o.manifest != nil && (err != nil || n != len(data)) {
+				// Keep offsets in line with the file, the unrecorded partial
+				// write will show up as a gap when verifying.
+				o.manifest.offset += int64(n)
+			}
 			if err != nil {
 				or.LogError(fmt.Errorf("Can't write to %s: %s", o.path, err))
-			} else if n != len(out.data) {
+			} else if n != len(data) {
 				or.LogError(fmt.Errorf("data loss - truncated output for %s", o.path))
 				or.UpdateCursor(out.cursor)
 			} else {
 				o.file.Sync()
+				if o.manifest != nil {
+					if err = o.manifest.record(data); err != nil {
+						or.LogError(fmt.Errorf("Can't write manifest for %s: %s", o.path, err))
+					}
+				}
 				or.UpdateCursor(out.cursor)
 			}
 			out.data = out.data[:0]
 			o.backChan <- out
 		case <-hupChan:
-			o.file.Close()
+			o.closeFile()
 			if err = o.openFile(); err != nil {
 				close(o.closing)
 				err = fmt.Errorf("unable to reopen file '%s': %s", o.path, err)
@@ -358,7 +411,7 @@ func (o *FileOutput) committer(or OutputRunner, errChan chan error) {
 				break
 			}
 		case rotateTime := <-o.rotateChan:
-			o.file.Close()
+			o.closeFile()
 			o.path = gostrftime.Strftime(o.FileOutputConfig.Path, rotateTime)
 			if err = o.openFile(); err != nil {
 				close(o.closing)
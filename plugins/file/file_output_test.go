@@ -107,6 +107,12 @@ func FileOutputSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("rejects an unknown compression codec", func() {
+			config.Compression = "bogus"
+			err := fileOutput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("rotates files correctly", func() {
 			config.Path = "%Y-%m-%d"
 			config.RotationInterval = 24
@@ -195,6 +201,28 @@ func FileOutputSpec(c gs.Context) {
 				c.Expect(string(contents), gs.Equals, outStr)
 			})
 
+			c.Specify("with gzip compression", func() {
+				config.Compression = "gzip"
+				err := fileOutput.Init(config)
+				c.Assume(err, gs.IsNil)
+
+				go fileOutput.committer(oth.MockOutputRunner, errChan)
+				go func() {
+					fileOutput.batchChan <- batch
+					_ = <-fileOutput.backChan // clear backChan to prevent blocking.
+					close(fileOutput.batchChan)
+				}()
+				<-fileOutput.closing
+
+				contents, err := ioutil.ReadFile(tmpFilePath)
+				c.Assume(err, gs.IsNil)
+				codec, err := GetCompressionCodec("gzip")
+				c.Assume(err, gs.IsNil)
+				decompressed, err := Decompress(codec, contents)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(decompressed), gs.Equals, outStr)
+			})
+
 			c.Specify("with different Perm settings", func() {
 				config.Perm = "600"
 				err := fileOutput.Init(config)
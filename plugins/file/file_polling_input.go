@@ -77,7 +77,10 @@ func (input *FilePollingInput) Run(runner pipeline.InputRunner,
 	helper pipeline.PluginHelper) error {
 
 	input.runner = runner
-	input.hostname = helper.PipelineConfig().Hostname()
+	pConfig := helper.PipelineConfig()
+	input.hostname = pConfig.Hostname()
+	// In one-shot mode the file is read once, right away.
+	oneShot := pConfig.Globals.OneShot
 	tickChan := runner.Ticker()
 	sRunner := runner.NewSplitterRunner("")
 	if !sRunner.UseMsgBytes() {
@@ -86,14 +89,19 @@ func (input *FilePollingInput) Run(runner pipeline.InputRunner,
 	defer sRunner.Done()
 
 	for {
-		select {
-		case <-input.stop:
-			return nil
-		case <-tickChan:
+		if !oneShot {
+			select {
+			case <-input.stop:
+				return nil
+			case <-tickChan:
+			}
 		}
 
 		f, err := os.Open(input.FilePath)
 		if err != nil {
+			if oneShot {
+				return fmt.Errorf("Error opening file: %s", err.Error())
+			}
 			runner.LogError(fmt.Errorf("Error opening file: %s", err.Error()))
 			continue
 		}
@@ -103,6 +111,10 @@ func (input *FilePollingInput) Run(runner pipeline.InputRunner,
 				runner.LogError(fmt.Errorf("Error reading file: %s", err.Error()))
 			}
 		}
+		if oneShot {
+			f.Close()
+			return nil
+		}
 	}
 }
 
@@ -0,0 +1,204 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Suffix appended to an output file's path to get its manifest's path.
+const ManifestSuffix = ".manifest"
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Records a checksum for every chunk of data appended to an output file. Each
+// manifest line has the form `<offset> <length> <algorithm>:<hex digest>`,
+// where offset and length describe the chunk's byte range in the output file.
+type manifestWriter struct {
+	file    *os.File
+	algo    string
+	newHash func() hash.Hash
+	offset  int64
+}
+
+func newManifestWriter(algo string) (*manifestWriter, error) {
+	newHash, ok := checksumAlgorithms[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm '%s'", algo)
+	}
+	return &manifestWriter{algo: algo, newHash: newHash}, nil
+}
+
+// Opens the manifest belonging to the output file, starting the chunk offsets
+// at the output file's current size.
+func (m *manifestWriter) open(outFile *os.File, path string, perm os.FileMode) (err error) {
+	var info os.FileInfo
+	if info, err = outFile.Stat(); err != nil {
+		return
+	}
+	m.offset = info.Size()
+	m.file, err = os.OpenFile(path+ManifestSuffix, os.O_WRONLY|os.O_APPEND|os.O_CREATE,
+		perm)
+	return
+}
+
+// Appends the checksum for a chunk that was just written to the output file.
+// The offset always advances with the output file. If the manifest can't be
+// written it's closed and left as is until the next `open`, since further
+// lines could end up behind a partially written one; verification then
+// reports the unrecorded chunks.
+func (m *manifestWriter) record(data []byte) (err error) {
+	offset := m.offset
+	m.offset += int64(len(data))
+	if m.file == nil {
+		return
+	}
+	h := m.newHash()
+	h.Write(data)
+	line := fmt.Sprintf("%d %d %s:%s\n", offset, len(data), m.algo,
+		hex.EncodeToString(h.Sum(nil)))
+	if _, err = m.file.WriteString(line); err != nil {
+		m.close()
+		return
+	}
+	return m.file.Sync()
+}
+
+func (m *manifestWriter) close() {
+	if m.file != nil {
+		m.file.Close()
+		m.file = nil
+	}
+}
+
+// Outcome of a successful archive verification.
+type VerifyResult struct {
+	Chunks int   // Number of verified chunks.
+	Start  int64 // Offset of the first verified byte.
+	End    int64 // Offset just past the last verified byte.
+	Size   int64 // Size of the archive file.
+}
+
+// VerifyArchive checks every chunk listed in the manifest against the archive
+// file's contents. It fails if a checksum doesn't match, if the chunks aren't
+// contiguous, or if the archive is shorter or longer than the manifest
+// describes. Data preceding the first chunk (i.e. written before checksums
+// were enabled) is reported through the result's Start value.
+func VerifyArchive(path, manifestPath string) (result *VerifyResult, err error) {
+	var archive, manifest *os.File
+	if archive, err = os.Open(path); err != nil {
+		return
+	}
+	defer archive.Close()
+	if manifest, err = os.Open(manifestPath); err != nil {
+		return
+	}
+	defer manifest.Close()
+
+	var info os.FileInfo
+	if info, err = archive.Stat(); err != nil {
+		return
+	}
+	result = &VerifyResult{Size: info.Size()}
+
+	scanner := bufio.NewScanner(manifest)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var (
+			offset, length int64
+			algo, digest   string
+		)
+		if offset, length, algo, digest, err = parseManifestLine(line); err != nil {
+			return result, fmt.Errorf("%s line %d: %s", manifestPath, lineNum, err)
+		}
+		if result.Chunks == 0 {
+			result.Start, result.End = offset, offset
+		} else if offset != result.End {
+			return result, fmt.Errorf("%s line %d: chunk at offset %d, expected %d",
+				manifestPath, lineNum, offset, result.End)
+		}
+		newHash, ok := checksumAlgorithms[algo]
+		if !ok {
+			return result, fmt.Errorf("%s line %d: unsupported checksum algorithm '%s'",
+				manifestPath, lineNum, algo)
+		}
+		if offset+length > result.Size {
+			return result, fmt.Errorf("chunk at offset %d extends past end of %s (%d bytes)",
+				offset, path, result.Size)
+		}
+		h := newHash()
+		if _, err = io.Copy(h, io.NewSectionReader(archive, offset, length)); err != nil {
+			return
+		}
+		if hex.EncodeToString(h.Sum(nil)) != digest {
+			return result, fmt.Errorf("checksum mismatch for chunk at offset %d (%d bytes)",
+				offset, length)
+		}
+		result.Chunks++
+		result.End = offset + length
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	if result.Chunks == 0 {
+		return result, errors.New("manifest contains no chunks")
+	}
+	if result.End != result.Size {
+		return result, fmt.Errorf("%d bytes of unverified data at end of %s",
+			result.Size-result.End, path)
+	}
+	return result, nil
+}
+
+func parseManifestLine(line string) (offset, length int64, algo, digest string,
+	err error) {
+
+	parts := strings.Fields(line)
+	if len(parts) != 3 {
+		err = errors.New("malformed manifest entry")
+		return
+	}
+	if offset, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return
+	}
+	if length, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return
+	}
+	sum := strings.SplitN(parts[2], ":", 2)
+	if len(sum) != 2 {
+		err = errors.New("malformed checksum")
+		return
+	}
+	return offset, length, sum[0], strings.ToLower(sum[1]), nil
+}
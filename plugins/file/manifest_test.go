@@ -0,0 +1,149 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ManifestSpec(c gs.Context) {
+	tmpDir, err := ioutil.TempDir("", "manifest-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "archive.log")
+	manifestPath := path + ManifestSuffix
+
+	writeChunks := func(preamble string, chunks ...string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		c.Assume(err, gs.IsNil)
+		defer f.Close()
+		if preamble != "" {
+			f.WriteString(preamble)
+		}
+		m, err := newManifestWriter("sha256")
+		c.Assume(err, gs.IsNil)
+		err = m.open(f, path, 0644)
+		c.Assume(err, gs.IsNil)
+		defer m.close()
+		for _, chunk := range chunks {
+			f.WriteString(chunk)
+			err = m.record([]byte(chunk))
+			c.Assume(err, gs.IsNil)
+		}
+	}
+
+	c.Specify("A checksum manifest", func() {
+		c.Specify("rejects unknown algorithms", func() {
+			_, err := newManifestWriter("crc8")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("verifies an intact archive", func() {
+			writeChunks("", "first chunk\n", "second chunk\n")
+			// Reopening continues at the current end of the file.
+			writeChunks("", "third chunk\n")
+			result, err := VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.IsNil)
+			c.Expect(result.Chunks, gs.Equals, 3)
+			c.Expect(result.Start, gs.Equals, int64(0))
+			c.Expect(result.End, gs.Equals, result.Size)
+		})
+
+		c.Specify("reports data written before the first chunk", func() {
+			writeChunks("unverified\n", "chunk\n")
+			result, err := VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.IsNil)
+			c.Expect(result.Start, gs.Equals, int64(len("unverified\n")))
+		})
+
+		c.Specify("detects modified data", func() {
+			writeChunks("", "first chunk\n", "second chunk\n")
+			f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+			c.Assume(err, gs.IsNil)
+			f.WriteAt([]byte("F"), 0)
+			f.Close()
+			_, err = VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("detects truncation", func() {
+			writeChunks("", "first chunk\n", "second chunk\n")
+			err := os.Truncate(path, 15)
+			c.Assume(err, gs.IsNil)
+			_, err = VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("detects unverified trailing data", func() {
+			writeChunks("", "first chunk\n")
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			c.Assume(err, gs.IsNil)
+			f.WriteString("sneaky\n")
+			f.Close()
+			_, err = VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("keeps the offset in line when the manifest can't be written", func() {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+			c.Assume(err, gs.IsNil)
+			defer f.Close()
+			m, err := newManifestWriter("sha256")
+			c.Assume(err, gs.IsNil)
+			err = m.open(f, path, 0644)
+			c.Assume(err, gs.IsNil)
+			defer m.close()
+
+			chunks := []string{"first chunk\n", "second chunk\n", "third chunk\n"}
+			f.WriteString(chunks[0])
+			err = m.record([]byte(chunks[0]))
+			c.Assume(err, gs.IsNil)
+
+			// Pull the manifest file out from under the writer.
+			m.file.Close()
+			f.WriteString(chunks[1])
+			err = m.record([]byte(chunks[1]))
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(m.file, gs.IsNil)
+			c.Expect(m.offset, gs.Equals, int64(len(chunks[0])+len(chunks[1])))
+
+			// Later chunks aren't recorded but still move the offset.
+			f.WriteString(chunks[2])
+			err = m.record([]byte(chunks[2]))
+			c.Expect(err, gs.IsNil)
+			c.Expect(m.offset, gs.Equals, int64(len(chunks[0])+len(chunks[1])+
+				len(chunks[2])))
+
+			_, err = VerifyArchive(path, manifestPath)
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			c.Specify("and resumes at the right offset once reopened", func() {
+				m.close()
+				err = m.open(f, path, 0644)
+				c.Assume(err, gs.IsNil)
+				f.WriteString("fourth chunk\n")
+				err = m.record([]byte("fourth chunk\n"))
+				c.Expect(err, gs.IsNil)
+				m.close()
+
+				// The unrecorded chunks show up as a gap.
+				_, err = VerifyArchive(path, manifestPath)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(err.Error(), gs.Contains, "chunk at offset 37, expected 12")
+			})
+		})
+	})
+}
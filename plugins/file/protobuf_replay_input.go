@@ -0,0 +1,225 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type ProtobufReplayInputConfig struct {
+	// Glob matching the Heka protobuf stream files to replay, relative paths
+	// are relative to Heka's base_dir. Matching files are replayed in
+	// lexical order.
+	Path string
+	// Either "original" to keep each message's timestamp as it was written,
+	// or "rewrite" to stamp it with the time of the replay. Defaults to
+	// "original".
+	Timestamps string
+	// Maximum number of messages per second to inject, 0 means unlimited.
+	RateLimit uint `toml:"rate_limit"`
+	// Number of seconds between checks for newly matching files. Defaults
+	// to 0, which replays the files present at startup only once.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// Input plugin that replays the messages stored in Heka framed protobuf
+// stream files, such as those written by a FileOutput using the
+// ProtobufEncoder, back into the router. Every file is replayed only once
+// per Heka run.
+type ProtobufReplayInput struct {
+	processMessageCount    int64
+	processMessageFailures int64
+	processedFiles         int64
+
+	conf     *ProtobufReplayInputConfig
+	glob     string
+	rewrite  bool
+	interval time.Duration
+	replayed map[string]bool
+	stopChan chan bool
+	ir       InputRunner
+	pConfig  *PipelineConfig
+}
+
+func (r *ProtobufReplayInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	r.pConfig = pConfig
+}
+
+func (r *ProtobufReplayInput) ConfigStruct() interface{} {
+	return &ProtobufReplayInputConfig{
+		Timestamps: "original",
+	}
+}
+
+func (r *ProtobufReplayInput) Init(config interface{}) (err error) {
+	r.conf = config.(*ProtobufReplayInputConfig)
+	if r.conf.Path == "" {
+		return errors.New("ProtobufReplayInput: `path` setting is required")
+	}
+	r.glob = filepath.Clean(r.pConfig.Globals.PrependBaseDir(r.conf.Path))
+	if _, err = filepath.Match(r.glob, ""); err != nil {
+		return fmt.Errorf("ProtobufReplayInput: invalid `path`: %s", err)
+	}
+
+	switch r.conf.Timestamps {
+	case "original":
+	case "rewrite":
+		r.rewrite = true
+	default:
+		return fmt.Errorf("ProtobufReplayInput: unknown timestamps '%s', must be "+
+			"'original' or 'rewrite'", r.conf.Timestamps)
+	}
+	if r.conf.RateLimit > 0 {
+		r.interval = time.Second / time.Duration(r.conf.RateLimit)
+	}
+	r.replayed = make(map[string]bool)
+	r.stopChan = make(chan bool)
+	return nil
+}
+
+func (r *ProtobufReplayInput) Run(ir InputRunner, h PluginHelper) error {
+	r.ir = ir
+	ticker := ir.Ticker()
+	oneShot := r.pConfig.Globals.OneShot
+	for {
+		if !r.scan() || oneShot {
+			return nil
+		}
+		select {
+		case <-ticker:
+		case <-r.stopChan:
+			return nil
+		}
+	}
+}
+
+// Replays every matching file that hasn't already been replayed. Returns
+// false if the input was stopped part way through.
+func (r *ProtobufReplayInput) scan() bool {
+	paths, err := filepath.Glob(r.glob)
+	if err != nil {
+		r.ir.LogError(fmt.Errorf("matching `path`: %s", err))
+		return true
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if r.replayed[path] {
+			continue
+		}
+		r.replayed[path] = true
+		if err = r.replayFile(path); err != nil {
+			if err == errReplayStopped {
+				return false
+			}
+			r.ir.LogError(fmt.Errorf("replaying '%s': %s", path, err))
+			continue
+		}
+		atomic.AddInt64(&r.processedFiles, 1)
+	}
+	return true
+}
+
+var errReplayStopped = errors.New("replay stopped")
+
+func (r *ProtobufReplayInput) replayFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	splitter := &HekaFramingSplitter{}
+	if err = splitter.Init(splitter.ConfigStruct()); err != nil {
+		return err
+	}
+	sRunner := NewSplitterRunner("HekaFramingSplitter", splitter, CommonSplitterConfig{})
+
+	var (
+		limiter <-chan time.Time
+		record  []byte
+	)
+	if r.interval > 0 {
+		t := time.NewTicker(r.interval)
+		defer t.Stop()
+		limiter = t.C
+	}
+	for {
+		if _, record, err = sRunner.GetRecordFromStream(f); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if len(record) == 0 {
+			continue
+		}
+		if limiter != nil {
+			select {
+			case <-limiter:
+			case <-r.stopChan:
+				return errReplayStopped
+			}
+		}
+
+		var pack *PipelinePack
+		select {
+		case pack = <-r.ir.InChan():
+		case <-r.stopChan:
+			return errReplayStopped
+		}
+		headerLen := int(record[1]) + message.HEADER_FRAMING_SIZE
+		if err = proto.Unmarshal(record[headerLen:], pack.Message); err != nil {
+			atomic.AddInt64(&r.processMessageFailures, 1)
+			r.ir.LogError(fmt.Errorf("unmarshalling message in '%s': %s", path, err))
+			pack.Recycle(nil)
+			continue
+		}
+		if r.rewrite {
+			message.NewInt64Field(pack.Message, "OriginalTimestamp",
+				pack.Message.GetTimestamp(), "ns")
+			pack.Message.SetTimestamp(time.Now().UnixNano())
+		}
+		atomic.AddInt64(&r.processMessageCount, 1)
+		r.ir.Inject(pack)
+	}
+}
+
+func (r *ProtobufReplayInput) Stop() {
+	close(r.stopChan)
+}
+
+func (r *ProtobufReplayInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&r.processMessageCount), "count")
+	message.NewInt64Field(msg, "ProcessMessageFailures",
+		atomic.LoadInt64(&r.processMessageFailures), "count")
+	message.NewInt64Field(msg, "ProcessedFiles",
+		atomic.LoadInt64(&r.processedFiles), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("ProtobufReplayInput", func() interface{} {
+		return new(ProtobufReplayInput)
+	})
+}
@@ -0,0 +1,135 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ProtobufReplayInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "replay-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	origTime := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC).UnixNano()
+	// Writes a Heka framed protobuf stream file holding one message for
+	// each of the given payloads.
+	writeStream := func(name string, payloads ...string) {
+		var contents, framed []byte
+		for _, payload := range payloads {
+			msg := new(message.Message)
+			msg.SetUuid([]byte("0123456789abcdef"))
+			msg.SetTimestamp(origTime)
+			msg.SetType("replay.test")
+			msg.SetPayload(payload)
+			msgBytes, err := proto.Marshal(msg)
+			c.Assume(err, gs.IsNil)
+			err = client.CreateHekaStream(msgBytes, &framed, nil)
+			c.Assume(err, gs.IsNil)
+			contents = append(contents, framed...)
+		}
+		err := ioutil.WriteFile(filepath.Join(tmpDir, name), contents, 0644)
+		c.Assume(err, gs.IsNil)
+	}
+
+	pConfig := NewPipelineConfig(nil)
+	input := new(ProtobufReplayInput)
+	input.SetPipelineConfig(pConfig)
+	config := input.ConfigStruct().(*ProtobufReplayInputConfig)
+	config.Path = filepath.Join(tmpDir, "*.log")
+
+	mockIR := pipelinemock.NewMockInputRunner(ctrl)
+	input.ir = mockIR
+	recycleChan := make(chan *PipelinePack, 2)
+	mockIR.EXPECT().InChan().Return(recycleChan).AnyTimes()
+	var injected []*message.Message
+	injectCall := mockIR.EXPECT().Inject(gomock.Any()).AnyTimes()
+	injectCall.Do(func(pack *PipelinePack) {
+		injected = append(injected, message.CopyMessage(pack.Message))
+		pack.Recycle(nil)
+	})
+	injectCall.Return(nil)
+	for i := 0; i < 2; i++ {
+		recycleChan <- NewPipelinePack(recycleChan)
+	}
+
+	c.Specify("A ProtobufReplayInput", func() {
+		c.Specify("requires a path", func() {
+			config.Path = ""
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown timestamp handling", func() {
+			config.Timestamps = "shift"
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("replays each file once, in order", func() {
+			writeStream("b.log", "three")
+			writeStream("a.log", "one", "two")
+			writeStream("c.txt", "ignored")
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			c.Expect(input.scan(), gs.IsTrue)
+			c.Expect(len(injected), gs.Equals, 3)
+			c.Expect(injected[0].GetPayload(), gs.Equals, "one")
+			c.Expect(injected[1].GetPayload(), gs.Equals, "two")
+			c.Expect(injected[2].GetPayload(), gs.Equals, "three")
+			c.Expect(injected[0].GetTimestamp(), gs.Equals, origTime)
+			c.Expect(injected[0].FindFirstField("OriginalTimestamp"), gs.IsNil)
+
+			input.scan()
+			c.Expect(len(injected), gs.Equals, 3)
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			val, _ := msg.GetFieldValue("ProcessedFiles")
+			c.Expect(val, gs.Equals, int64(2))
+			val, _ = msg.GetFieldValue("ProcessMessageCount")
+			c.Expect(val, gs.Equals, int64(3))
+		})
+
+		c.Specify("rewrites timestamps and honors the rate limit", func() {
+			writeStream("a.log", "one", "two", "three")
+			config.Timestamps = "rewrite"
+			config.RateLimit = 50
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			start := time.Now()
+			input.scan()
+			c.Expect(time.Since(start) >= 40*time.Millisecond, gs.IsTrue)
+			c.Expect(len(injected), gs.Equals, 3)
+			c.Expect(injected[0].GetTimestamp() >= start.UnixNano(), gs.IsTrue)
+			val, _ := injected[0].GetFieldValue("OriginalTimestamp")
+			c.Expect(val, gs.Equals, origTime)
+		})
+	})
+}
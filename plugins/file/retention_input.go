@@ -0,0 +1,282 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Cleanup policy for a single directory.
+type RetentionPolicy struct {
+	// Directory to clean up, relative paths are relative to Heka's base_dir.
+	Path string
+	// Regular expression matched against the path of each file relative to
+	// `Path`, only matching files are considered. Defaults to all files.
+	FileMatch string `toml:"file_match"`
+	// Files last modified longer ago than this duration are removed. Empty
+	// (the default) disables age based cleanup.
+	MaxAge string `toml:"max_age"`
+	// Maximum total size of the matching files in bytes. When exceeded the
+	// oldest files are removed until the directory is back under quota. 0
+	// (the default) disables the quota.
+	MaxSize uint64 `toml:"max_size"`
+	// Files modified more recently than this duration are never removed, to
+	// protect files that are still being written. Defaults to "10m".
+	MinAge string `toml:"min_age"`
+}
+
+type RetentionInputConfig struct {
+	// Number of seconds between cleanup runs. Defaults to 300.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Only log which files would be removed, without removing them.
+	DryRun bool `toml:"dry_run"`
+	// Cleanup policies, keyed by a name used in log messages and reports.
+	Directories map[string]*RetentionPolicy
+}
+
+type retentionDir struct {
+	name    string
+	path    string
+	match   *regexp.Regexp
+	maxAge  time.Duration
+	minAge  time.Duration
+	maxSize int64
+
+	// Usage as of the last run and cumulative cleanup totals.
+	files        int64
+	bytes        int64
+	deletedFiles int64
+	deletedBytes int64
+}
+
+type retentionFile struct {
+	path string
+	info os.FileInfo
+}
+
+type byModTime []retentionFile
+
+func (b byModTime) Len() int      { return len(b) }
+func (b byModTime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byModTime) Less(i, j int) bool {
+	return b[i].info.ModTime().Before(b[j].info.ModTime())
+}
+
+// Input plugin that enforces disk quotas and age based cleanup on local
+// directories, such as queue buffers, dashboard output, sandbox preservation
+// data, or rotated FileOutput files. It doesn't generate any messages, its
+// usage and cleanup metrics are available through Heka's report.
+type RetentionInput struct {
+	dirs     []*retentionDir
+	dryRun   bool
+	stopChan chan bool
+	pConfig  *PipelineConfig
+}
+
+func (r *RetentionInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	r.pConfig = pConfig
+}
+
+func (r *RetentionInput) ConfigStruct() interface{} {
+	return &RetentionInputConfig{
+		TickerInterval: 300,
+	}
+}
+
+func (r *RetentionInput) Init(config interface{}) (err error) {
+	conf := config.(*RetentionInputConfig)
+	if len(conf.Directories) == 0 {
+		return errors.New("RetentionInput: at least one directory is required")
+	}
+	r.dryRun = conf.DryRun
+
+	names := make([]string, 0, len(conf.Directories))
+	for name := range conf.Directories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r.dirs = make([]*retentionDir, 0, len(names))
+	for _, name := range names {
+		var dir *retentionDir
+		if dir, err = r.newRetentionDir(name, conf.Directories[name]); err != nil {
+			return fmt.Errorf("RetentionInput directory '%s': %s", name, err)
+		}
+		r.dirs = append(r.dirs, dir)
+	}
+	r.stopChan = make(chan bool)
+	return nil
+}
+
+func (r *RetentionInput) newRetentionDir(name string, policy *RetentionPolicy) (
+	dir *retentionDir, err error) {
+
+	if policy.Path == "" {
+		return nil, errors.New("`path` setting is required")
+	}
+	if policy.MaxAge == "" && policy.MaxSize == 0 {
+		return nil, errors.New("one of `max_age` or `max_size` is required")
+	}
+	dir = &retentionDir{
+		name:    name,
+		path:    filepath.Clean(r.pConfig.Globals.PrependBaseDir(policy.Path)),
+		maxSize: int64(policy.MaxSize),
+	}
+	if policy.FileMatch != "" {
+		if dir.match, err = regexp.Compile(policy.FileMatch); err != nil {
+			return nil, fmt.Errorf("invalid `file_match`: %s", err)
+		}
+	}
+	if policy.MaxAge != "" {
+		if dir.maxAge, err = time.ParseDuration(policy.MaxAge); err != nil {
+			return nil, fmt.Errorf("invalid `max_age`: %s", err)
+		}
+	}
+	if policy.MinAge == "" {
+		policy.MinAge = "10m"
+	}
+	if dir.minAge, err = time.ParseDuration(policy.MinAge); err != nil {
+		return nil, fmt.Errorf("invalid `min_age`: %s", err)
+	}
+	return dir, nil
+}
+
+func (r *RetentionInput) Run(ir InputRunner, h PluginHelper) error {
+	r.enforceAll(ir)
+	ticker := ir.Ticker()
+	for {
+		select {
+		case <-ticker:
+			r.enforceAll(ir)
+		case <-r.stopChan:
+			return nil
+		}
+	}
+}
+
+func (r *RetentionInput) enforceAll(ir InputRunner) {
+	now := time.Now()
+	for _, dir := range r.dirs {
+		removed, err := dir.enforce(now, r.dryRun)
+		for _, path := range removed {
+			if r.dryRun {
+				ir.LogMessage(fmt.Sprintf("%s: would remove %s", dir.name, path))
+			} else {
+				ir.LogMessage(fmt.Sprintf("%s: removed %s", dir.name, path))
+			}
+		}
+		if err != nil {
+			ir.LogError(fmt.Errorf("%s: %s", dir.name, err))
+		}
+	}
+}
+
+// Applies the directory's policy, returning the paths of the files that were
+// removed (or would have been, in dry run mode).
+func (d *retentionDir) enforce(now time.Time, dryRun bool) (removed []string,
+	err error) {
+
+	var files []retentionFile
+	var total int64
+	walkErr := filepath.Walk(d.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == d.path {
+				// Nothing to clean up (yet).
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if d.match != nil {
+			rel, err := filepath.Rel(d.path, path)
+			if err != nil || !d.match.MatchString(filepath.ToSlash(rel)) {
+				return nil
+			}
+		}
+		files = append(files, retentionFile{path, info})
+		total += info.Size()
+		return nil
+	})
+	if walkErr != nil {
+		err = walkErr
+	}
+	sort.Sort(byModTime(files))
+
+	remaining := int64(len(files))
+	for _, f := range files {
+		age := now.Sub(f.info.ModTime())
+		if age < d.minAge {
+			// Everything from here on is newer still.
+			break
+		}
+		expired := d.maxAge > 0 && age > d.maxAge
+		overQuota := d.maxSize > 0 && total > d.maxSize
+		if !expired && !overQuota {
+			continue
+		}
+		if !dryRun {
+			if e := os.Remove(f.path); e != nil {
+				err = e
+				continue
+			}
+			atomic.AddInt64(&d.deletedFiles, 1)
+			atomic.AddInt64(&d.deletedBytes, f.info.Size())
+		}
+		removed = append(removed, f.path)
+		total -= f.info.Size()
+		remaining--
+	}
+	if err == nil && d.maxSize > 0 && total > d.maxSize {
+		err = fmt.Errorf("%d bytes used, over quota of %d bytes, but remaining "+
+			"files are newer than min_age", total, d.maxSize)
+	}
+	atomic.StoreInt64(&d.files, remaining)
+	atomic.StoreInt64(&d.bytes, total)
+	return removed, err
+}
+
+func (r *RetentionInput) Stop() {
+	close(r.stopChan)
+}
+
+// ReportMsg provides per directory usage and cleanup totals to Heka's report
+// and dashboard.
+func (r *RetentionInput) ReportMsg(msg *message.Message) error {
+	for _, dir := range r.dirs {
+		message.NewInt64Field(msg, dir.name+"-files", atomic.LoadInt64(&dir.files),
+			"count")
+		message.NewInt64Field(msg, dir.name+"-bytes", atomic.LoadInt64(&dir.bytes), "B")
+		message.NewInt64Field(msg, dir.name+"-deleted_files",
+			atomic.LoadInt64(&dir.deletedFiles), "count")
+		message.NewInt64Field(msg, dir.name+"-deleted_bytes",
+			atomic.LoadInt64(&dir.deletedBytes), "B")
+	}
+	return nil
+}
+
+func init() {
+	RegisterPlugin("RetentionInput", func() interface{} {
+		return new(RetentionInput)
+	})
+}
@@ -0,0 +1,134 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RetentionInputSpec(c gs.Context) {
+	tmpDir, err := ioutil.TempDir("", "retention-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Now()
+	// Creates a file of the given size, last modified `age` ago.
+	createFile := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(tmpDir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		c.Assume(err, gs.IsNil)
+		err = ioutil.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644)
+		c.Assume(err, gs.IsNil)
+		mtime := now.Add(-age)
+		err = os.Chtimes(path, mtime, mtime)
+		c.Assume(err, gs.IsNil)
+		return path
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	pConfig := NewPipelineConfig(nil)
+	input := new(RetentionInput)
+	input.SetPipelineConfig(pConfig)
+	config := input.ConfigStruct().(*RetentionInputConfig)
+	policy := &RetentionPolicy{Path: tmpDir}
+	config.Directories = map[string]*RetentionPolicy{"archive": policy}
+
+	c.Specify("A RetentionInput", func() {
+		oldest := createFile("a.log.3", 100, 72*time.Hour)
+		older := createFile("a.log.2", 100, 48*time.Hour)
+		old := createFile("sub/a.log.1", 100, 24*time.Hour)
+		active := createFile("a.log", 100, time.Minute)
+
+		c.Specify("requires a limit", func() {
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("removes files older than max_age", func() {
+			policy.MaxAge = "36h"
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			removed, err := input.dirs[0].enforce(now, false)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(removed), gs.Equals, 2)
+			c.Expect(exists(oldest), gs.IsFalse)
+			c.Expect(exists(older), gs.IsFalse)
+			c.Expect(exists(old), gs.IsTrue)
+			c.Expect(exists(active), gs.IsTrue)
+
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			val, ok := msg.GetFieldValue("archive-deleted_files")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(val, gs.Equals, int64(2))
+			val, _ = msg.GetFieldValue("archive-bytes")
+			c.Expect(val, gs.Equals, int64(200))
+		})
+
+		c.Specify("removes the oldest files to enforce max_size", func() {
+			policy.MaxSize = 250
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			removed, err := input.dirs[0].enforce(now, false)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(removed), gs.Equals, 2)
+			c.Expect(exists(oldest), gs.IsFalse)
+			c.Expect(exists(older), gs.IsFalse)
+			c.Expect(exists(old), gs.IsTrue)
+		})
+
+		c.Specify("never removes files newer than min_age", func() {
+			policy.MaxSize = 50
+			policy.MinAge = "1h"
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			removed, err := input.dirs[0].enforce(now, false)
+			c.Expect(err, gs.Not(gs.IsNil)) // Still over quota.
+			c.Expect(len(removed), gs.Equals, 3)
+			c.Expect(exists(active), gs.IsTrue)
+		})
+
+		c.Specify("only considers matching files", func() {
+			policy.MaxAge = "1h"
+			policy.FileMatch = `^sub/`
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			removed, err := input.dirs[0].enforce(now, false)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(removed), gs.Equals, 1)
+			c.Expect(exists(old), gs.IsFalse)
+			c.Expect(exists(oldest), gs.IsTrue)
+		})
+
+		c.Specify("doesn't remove anything in dry run mode", func() {
+			policy.MaxAge = "1h"
+			config.DryRun = true
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			removed, err := input.dirs[0].enforce(now, input.dryRun)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(removed), gs.Equals, 3)
+			c.Expect(exists(oldest), gs.IsTrue)
+		})
+	})
+}
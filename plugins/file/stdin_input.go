@@ -0,0 +1,105 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type StdinInput struct {
+	*StdinInputConfig
+	stdin    io.Reader
+	stop     chan bool
+	hostname string
+}
+
+type StdinInputConfig struct {
+	// Whether to shut Heka down once stdin is closed. Defaults to true.
+	ShutdownOnEof bool `toml:"shutdown_on_eof"`
+	// So we can default to using TokenSplitter.
+	Splitter string
+}
+
+func (input *StdinInput) ConfigStruct() interface{} {
+	return &StdinInputConfig{
+		ShutdownOnEof: true,
+		Splitter:      "TokenSplitter",
+	}
+}
+
+func (input *StdinInput) Init(config interface{}) error {
+	input.StdinInputConfig = config.(*StdinInputConfig)
+	input.stdin = os.Stdin
+	input.stop = make(chan bool)
+	return nil
+}
+
+func (input *StdinInput) Stop() {
+	close(input.stop)
+}
+
+func (input *StdinInput) packDecorator(pack *pipeline.PipelinePack) {
+	pack.Message.SetType("heka.stdin")
+	pack.Message.SetHostname(input.hostname)
+}
+
+func (input *StdinInput) Run(runner pipeline.InputRunner,
+	helper pipeline.PluginHelper) error {
+
+	input.hostname = helper.PipelineConfig().Hostname()
+	sRunner := runner.NewSplitterRunner("")
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(input.packDecorator)
+	}
+
+	// Reads from stdin can't be interrupted, so they happen in a separate
+	// goroutine that's abandoned if we're stopped first.
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		for err == nil {
+			err = sRunner.SplitStream(input.stdin, nil)
+		}
+		sRunner.Done()
+		done <- err
+	}()
+
+	select {
+	case <-input.stop:
+		return nil
+	case err := <-done:
+		if err != io.EOF {
+			return fmt.Errorf("Error reading stdin: %s", err.Error())
+		}
+	}
+
+	if helper.PipelineConfig().Globals.OneShot {
+		// Heka shuts down once all of the inputs are done.
+		return nil
+	}
+	if input.ShutdownOnEof {
+		runner.LogMessage("stdin closed, shutting down")
+		helper.PipelineConfig().Globals.ShutDown(0)
+	}
+	<-input.stop
+	return nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("StdinInput", func() interface{} {
+		return new(StdinInput)
+	})
+}
@@ -0,0 +1,88 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package file
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"syscall"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	plugins_ts "github.com/mozilla-services/heka/plugins/testsupport"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func StdinInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A StdinInput", func() {
+		input := new(StdinInput)
+		config := input.ConfigStruct().(*StdinInputConfig)
+		c.Expect(config.Splitter, gs.Equals, "TokenSplitter")
+
+		ith := new(plugins_ts.InputTestHelper)
+		ith.MockHelper = pipelinemock.NewMockPluginHelper(ctrl)
+		ith.MockInputRunner = pipelinemock.NewMockInputRunner(ctrl)
+		ith.MockSplitterRunner = pipelinemock.NewMockSplitterRunner(ctrl)
+		pConfig := NewPipelineConfig(nil)
+		ith.MockHelper.EXPECT().PipelineConfig().Return(pConfig).AnyTimes()
+
+		ith.MockInputRunner.EXPECT().NewSplitterRunner("").Return(ith.MockSplitterRunner)
+		ith.MockSplitterRunner.EXPECT().UseMsgBytes().Return(false)
+		ith.MockSplitterRunner.EXPECT().SetPackDecorator(gomock.Any())
+		ith.MockSplitterRunner.EXPECT().Done()
+
+		bytesChan := make(chan []byte, 1)
+		splitCall := ith.MockSplitterRunner.EXPECT().SplitStream(gomock.Any(), nil)
+		splitCall.Return(io.EOF)
+		splitCall.Do(func(r io.Reader, del Deliverer) {
+			b, _ := ioutil.ReadAll(r)
+			bytesChan <- b
+		})
+
+		err := input.Init(config)
+		c.Assume(err, gs.IsNil)
+		input.stdin = strings.NewReader("line 1\nline 2\n")
+		errChan := make(chan error, 1)
+
+		c.Specify("shuts Heka down once stdin is closed", func() {
+			ith.MockInputRunner.EXPECT().LogMessage(gomock.Any())
+			go func() {
+				errChan <- input.Run(ith.MockInputRunner, ith.MockHelper)
+			}()
+			c.Expect(string(<-bytesChan), gs.Equals, "line 1\nline 2\n")
+			sig := <-pConfig.Globals.SigChan()
+			c.Expect(sig, gs.Equals, syscall.SIGINT)
+
+			input.Stop()
+			c.Expect(<-errChan, gs.IsNil)
+		})
+
+		c.Specify("keeps running once stdin is closed if configured to", func() {
+			config.ShutdownOnEof = false
+			go func() {
+				errChan <- input.Run(ith.MockInputRunner, ith.MockHelper)
+			}()
+			c.Expect(string(<-bytesChan), gs.Equals, "line 1\nline 2\n")
+
+			input.Stop()
+			c.Expect(<-errChan, gs.IsNil)
+			c.Expect(len(pConfig.Globals.SigChan()), gs.Equals, 0)
+		})
+	})
+}
@@ -0,0 +1,223 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+// Input plugin implementing the server side of the fluentd forward
+// protocol, so fluentd and fluent-bit forward outputs can send to Heka.
+type FluentdInput struct {
+	conf         *FluentdInputConfig
+	ir           InputRunner
+	listener     net.Listener
+	udpConn      *net.UDPConn
+	stopChan     chan bool
+	wg           sync.WaitGroup
+	messageCount int64
+	invalidCount int64
+}
+
+type FluentdInputConfig struct {
+	// Address to listen on. Defaults to "127.0.0.1:24224".
+	Address string
+	// Largest accepted forward protocol message in bytes, including packed
+	// entries once decompressed. Defaults to 16777216.
+	MaxMessageSize uint `toml:"max_message_size"`
+	// Record key whose value is used as the message payload. Defaults to
+	// "message".
+	PayloadKey string `toml:"payload_key"`
+	// Whether to answer UDP heartbeats on the same port. Defaults to true.
+	UdpHeartbeat bool `toml:"udp_heartbeat"`
+}
+
+func (fi *FluentdInput) ConfigStruct() interface{} {
+	return &FluentdInputConfig{
+		Address:        "127.0.0.1:24224",
+		MaxMessageSize: 16 * 1024 * 1024,
+		PayloadKey:     "message",
+		UdpHeartbeat:   true,
+	}
+}
+
+func (fi *FluentdInput) Init(config interface{}) (err error) {
+	fi.conf = config.(*FluentdInputConfig)
+	if fi.conf.MaxMessageSize == 0 {
+		return fmt.Errorf("FluentdInput: max_message_size must be greater than 0")
+	}
+	var addr *net.TCPAddr
+	if addr, err = net.ResolveTCPAddr("tcp", fi.conf.Address); err != nil {
+		return fmt.Errorf("FluentdInput: ResolveTCPAddr failed: %s", err)
+	}
+	if fi.listener, err = net.ListenTCP("tcp", addr); err != nil {
+		return fmt.Errorf("FluentdInput: ListenTCP failed: %s", err)
+	}
+	if fi.conf.UdpHeartbeat {
+		// Use the port actually bound, in case it was chosen by the system.
+		udpAddr := &net.UDPAddr{IP: addr.IP, Port: fi.listener.Addr().(*net.TCPAddr).Port}
+		if fi.udpConn, err = net.ListenUDP("udp", udpAddr); err != nil {
+			fi.listener.Close()
+			return fmt.Errorf("FluentdInput: ListenUDP failed: %s", err)
+		}
+	}
+	fi.stopChan = make(chan bool)
+	return nil
+}
+
+func (fi *FluentdInput) deliver(tag string, e event, host string) {
+	pack := <-fi.ir.InChan()
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetType("fluentd")
+	pack.Message.SetLogger(tag)
+	pack.Message.SetHostname(host)
+	populateMessage(pack.Message, e, fi.conf.PayloadKey)
+	atomic.AddInt64(&fi.messageCount, 1)
+	fi.ir.Deliver(pack)
+}
+
+func (fi *FluentdInput) handleConnection(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		fi.wg.Done()
+	}()
+	host := remoteHost(conn.RemoteAddr())
+	// Close the connection on shutdown to unblock the reader.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-fi.stopChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	max := int(fi.conf.MaxMessageSize)
+	reader := newMsgpackReader(conn, max)
+	for {
+		v, err := reader.readValue()
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-fi.stopChan:
+				default:
+					// The stream can't be resynchronized after a bad value.
+					atomic.AddInt64(&fi.invalidCount, 1)
+					fi.ir.LogError(fmt.Errorf("reading from %s: %s", host, err))
+				}
+			}
+			return
+		}
+		fm, err := decodeForward(v, max)
+		if err != nil {
+			atomic.AddInt64(&fi.invalidCount, 1)
+			fi.ir.LogError(fmt.Errorf("message from %s: %s", host, err))
+			continue
+		}
+		for _, e := range fm.events {
+			fi.deliver(fm.tag, e, host)
+		}
+		if fm.chunk != "" {
+			ack := appendMsgpackString([]byte{0x81}, "ack")
+			ack = appendMsgpackString(ack, fm.chunk)
+			if _, err = conn.Write(ack); err != nil {
+				fi.ir.LogError(fmt.Errorf("acknowledging to %s: %s", host, err))
+				return
+			}
+		}
+	}
+}
+
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// Answers each heartbeat datagram with a single null byte, as fluentd does.
+func (fi *FluentdInput) runHeartbeat() {
+	defer fi.wg.Done()
+	buf := make([]byte, 1024)
+	for {
+		_, addr, err := fi.udpConn.ReadFromUDP(buf)
+		if err == nil {
+			_, err = fi.udpConn.WriteToUDP([]byte{0}, addr)
+		}
+		if err != nil {
+			select {
+			case <-fi.stopChan:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			fi.ir.LogError(fmt.Errorf("UDP heartbeat failed: %s", err))
+			return
+		}
+	}
+}
+
+func (fi *FluentdInput) Run(ir InputRunner, h PluginHelper) error {
+	fi.ir = ir
+	if fi.udpConn != nil {
+		fi.wg.Add(1)
+		go fi.runHeartbeat()
+	}
+	for {
+		conn, err := fi.listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				fi.ir.LogError(fmt.Errorf("TCP accept failed: %s", err))
+				continue
+			}
+			break
+		}
+		fi.wg.Add(1)
+		go fi.handleConnection(conn)
+	}
+	fi.wg.Wait()
+	return nil
+}
+
+func (fi *FluentdInput) Stop() {
+	close(fi.stopChan)
+	fi.listener.Close()
+	if fi.udpConn != nil {
+		fi.udpConn.Close()
+	}
+}
+
+func (fi *FluentdInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "MessageCount",
+		atomic.LoadInt64(&fi.messageCount), "count")
+	message.NewInt64Field(msg, "InvalidCount",
+		atomic.LoadInt64(&fi.invalidCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("FluentdInput", func() interface{} {
+		return new(FluentdInput)
+	})
+}
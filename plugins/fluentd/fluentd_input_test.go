@@ -0,0 +1,125 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	. "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func FluentdInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A FluentdInput", func() {
+		input := new(FluentdInput)
+		config := input.ConfigStruct().(*FluentdInputConfig)
+		config.Address = "127.0.0.1:0"
+
+		ir := NewMockInputRunner(ctrl)
+		h := NewMockPluginHelper(ctrl)
+		inChan := make(chan *PipelinePack, 3)
+		for i := 0; i < 3; i++ {
+			inChan <- NewPipelinePack(pConfig.InputRecycleChan())
+		}
+		ir.EXPECT().InChan().Return(inChan).AnyTimes()
+		delivered := make(chan *PipelinePack, 3)
+		ir.EXPECT().Deliver(gomock.Any()).Do(func(pack *PipelinePack) {
+			delivered <- pack
+		}).AnyTimes()
+		ir.EXPECT().LogError(gomock.Any()).AnyTimes()
+
+		err := input.Init(config)
+		c.Assume(err, gs.IsNil)
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- input.Run(ir, h)
+		}()
+		addr := input.listener.Addr().String()
+
+		c.Specify("accepts forwarded events and acknowledges chunks", func() {
+			record := map[string]interface{}{"message": "hello", "status": 200}
+			var data []byte
+			data = appendMsgpack(data, []interface{}{"app.access", 100, record})
+			data = appendMsgpack(data, []interface{}{"app.access", "bogus"})
+			data = appendMsgpack(data, []interface{}{"app.error", []interface{}{
+				[]interface{}{101, record},
+				[]interface{}{102, record},
+			}, map[string]interface{}{"chunk": "c1"}})
+
+			conn, err := net.Dial("tcp", addr)
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			_, err = conn.Write(data)
+			c.Assume(err, gs.IsNil)
+
+			pack := <-delivered
+			c.Expect(pack.Message.GetType(), gs.Equals, "fluentd")
+			c.Expect(pack.Message.GetLogger(), gs.Equals, "app.access")
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "127.0.0.1")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "hello")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(100e9))
+			c.Expect(len(pack.Message.GetUuid()), gs.Equals, 16)
+			status, _ := pack.Message.GetFieldValue("status")
+			c.Expect(status, gs.Equals, int64(200))
+			for i := 0; i < 2; i++ {
+				pack = <-delivered
+				c.Expect(pack.Message.GetLogger(), gs.Equals, "app.error")
+			}
+
+			expected := appendMsgpack(nil, map[string]interface{}{"ack": "c1"})
+			ack := make([]byte, len(expected))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(ack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(bytes.Equal(ack, expected), gs.IsTrue)
+
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			count, _ := msg.GetFieldValue("MessageCount")
+			c.Expect(count, gs.Equals, int64(3))
+			invalid, _ := msg.GetFieldValue("InvalidCount")
+			c.Expect(invalid, gs.Equals, int64(1))
+		})
+
+		c.Specify("answers UDP heartbeats", func() {
+			conn, err := net.Dial("udp", addr)
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			conn.Write([]byte{0})
+			reply := make([]byte, 8)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(reply)
+			c.Expect(err, gs.IsNil)
+			c.Expect(n, gs.Equals, 1)
+		})
+
+		input.Stop()
+		select {
+		case err = <-errChan:
+			c.Expect(err, gs.IsNil)
+		case <-time.After(time.Second):
+			c.Expect("FluentdInput stopped", gs.Equals, "FluentdInput hung")
+		}
+	})
+}
@@ -0,0 +1,287 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// A single event from a forward protocol message.
+type event struct {
+	time   time.Time
+	record map[string]interface{}
+}
+
+// A decoded forward protocol message, in any of the Message, Forward,
+// PackedForward or CompressedPackedForward modes.
+type forwardMessage struct {
+	tag    string
+	events []event
+	// Chunk id to acknowledge, if the sender requested an ack.
+	chunk string
+}
+
+// Converts a fluentd time, either an integer number of seconds, a float
+// (as sent by some fluent-bit versions) or an EventTime extension, to a
+// time.Time.
+func eventTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(t, 0), nil
+	case uint64:
+		return time.Unix(int64(t), 0), nil
+	case float64:
+		sec, frac := math.Modf(t)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	case msgpackExt:
+		if t.typ == 0 && len(t.data) == 8 {
+			sec := binary.BigEndian.Uint32(t.data)
+			nsec := binary.BigEndian.Uint32(t.data[4:])
+			return time.Unix(int64(sec), int64(nsec)), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid event time: %v", v)
+}
+
+// Converts a [time, record] entry to an event.
+func decodeEntry(v interface{}) (e event, err error) {
+	entry, ok := v.([]interface{})
+	if !ok || len(entry) < 2 {
+		return e, errors.New("entry isn't a [time, record] array")
+	}
+	if e.time, err = eventTime(entry[0]); err != nil {
+		return e, err
+	}
+	if e.record, ok = entry[1].(map[string]interface{}); !ok {
+		return e, errors.New("record isn't a map")
+	}
+	return e, nil
+}
+
+// Decodes a message read from the connection. Packed entries are limited to
+// max bytes once decompressed.
+func decodeForward(v interface{}, max int) (fm forwardMessage, err error) {
+	arr, ok := v.([]interface{})
+	if !ok || len(arr) < 2 {
+		return fm, errors.New("message isn't an array of at least 2 elements")
+	}
+	switch tag := arr[0].(type) {
+	case string:
+		fm.tag = tag
+	case []byte:
+		fm.tag = string(tag)
+	default:
+		return fm, errors.New("tag isn't a string")
+	}
+
+	var option interface{}
+	switch entries := arr[1].(type) {
+	case []interface{}:
+		// Forward mode.
+		fm.events = make([]event, 0, len(entries))
+		for _, entry := range entries {
+			e, err := decodeEntry(entry)
+			if err != nil {
+				return fm, err
+			}
+			fm.events = append(fm.events, e)
+		}
+		if len(arr) > 2 {
+			option = arr[2]
+		}
+	case string, []byte:
+		// PackedForward mode.
+		if len(arr) > 2 {
+			option = arr[2]
+		}
+		var packed []byte
+		if s, ok := entries.(string); ok {
+			packed = []byte(s)
+		} else {
+			packed = entries.([]byte)
+		}
+		opts, _ := option.(map[string]interface{})
+		if opts["compressed"] == "gzip" {
+			if packed, err = gunzip(packed, max); err != nil {
+				return fm, err
+			}
+		}
+		if fm.events, err = decodePacked(packed, max); err != nil {
+			return fm, err
+		}
+	default:
+		// Message mode.
+		if len(arr) < 3 {
+			return fm, errors.New("message has no record")
+		}
+		e, err := decodeEntry(arr[1:3])
+		if err != nil {
+			return fm, err
+		}
+		fm.events = []event{e}
+		if len(arr) > 3 {
+			option = arr[3]
+		}
+	}
+
+	if opts, ok := option.(map[string]interface{}); ok {
+		switch chunk := opts["chunk"].(type) {
+		case string:
+			fm.chunk = chunk
+		case []byte:
+			fm.chunk = string(chunk)
+		}
+	}
+	return fm, nil
+}
+
+// Decompresses concatenated gzip members, as sent in the
+// CompressedPackedForward mode.
+func gunzip(data []byte, max int) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing entries: %s", err)
+	}
+	defer gz.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(gz, int64(max)+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing entries: %s", err)
+	}
+	if len(data) > max {
+		return nil, errMsgpackTooLarge
+	}
+	return data, nil
+}
+
+// Decodes the msgpack stream of [time, record] entries of a PackedForward
+// message.
+func decodePacked(data []byte, max int) (events []event, err error) {
+	r := newMsgpackReader(bytes.NewReader(data), max)
+	for {
+		v, err := r.readValue()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeEntry(v)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+}
+
+// Populates msg from an event's record. The payloadKey entry, if a string,
+// becomes the payload. Other entries become fields: strings, numbers and
+// booleans keep their type, bin values are stored as strings when they are
+// valid UTF-8 and as bytes otherwise, and arrays and maps are stored as JSON.
+func populateMessage(msg *message.Message, e event, payloadKey string) {
+	msg.SetTimestamp(e.time.UnixNano())
+	addRecordFields(msg, e.record, "", payloadKey, "")
+}
+
+// Adds the record's entries as fields named prefix + key, other than the
+// payloadKey entry, which becomes the payload if it's a string. With a
+// separator, nested maps are flattened into fields named
+// `key<separator>nestedKey` rather than stored as JSON.
+func addRecordFields(msg *message.Message, record map[string]interface{},
+	prefix, payloadKey, separator string) {
+
+	keys := make([]string, 0, len(record))
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := record[k]
+		if b, ok := v.([]byte); ok && utf8.Valid(b) {
+			v = string(b)
+		}
+		if k == payloadKey {
+			if s, ok := v.(string); ok {
+				msg.SetPayload(s)
+				continue
+			}
+		}
+		name := prefix + k
+		var (
+			f   *message.Field
+			err error
+		)
+		switch val := v.(type) {
+		case nil:
+			continue
+		case string, []byte, bool, int64, float64:
+			f, err = message.NewField(name, val, "")
+		case uint64:
+			f, err = message.NewField(name, float64(val), "")
+		case map[string]interface{}:
+			if separator != "" {
+				addRecordFields(msg, val, name+separator, "", separator)
+				continue
+			}
+			f, err = jsonField(name, val)
+		default:
+			f, err = jsonField(name, val)
+		}
+		if err == nil {
+			msg.AddField(f)
+		}
+	}
+}
+
+func jsonField(name string, v interface{}) (*message.Field, error) {
+	data, err := json.Marshal(toJSON(v))
+	if err != nil {
+		return nil, err
+	}
+	return message.NewField(name, string(data), "")
+}
+
+// Converts nested msgpack values to values encoding/json can marshal the
+// way they were sent.
+func toJSON(v interface{}) interface{} {
+	switch val := v.(type) {
+	case []byte:
+		return string(val)
+	case msgpackExt:
+		return val.data
+	case []interface{}:
+		a := make([]interface{}, len(val))
+		for i, e := range val {
+			a[i] = toJSON(e)
+		}
+		return a
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			m[k] = toJSON(e)
+		}
+		return m
+	}
+	return v
+}
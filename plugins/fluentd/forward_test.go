@@ -0,0 +1,226 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// Minimal msgpack encoder for building test messages.
+func appendMsgpack(b []byte, v interface{}) []byte {
+	switch val := v.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if val {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case int:
+		if val >= 0 && val < 128 {
+			return append(b, byte(val))
+		}
+		b = append(b, 0xd3)
+		return appendUint64(b, uint64(val))
+	case float64:
+		b = append(b, 0xcb)
+		return appendUint64(b, math.Float64bits(val))
+	case string:
+		return appendMsgpackString(b, val)
+	case []byte:
+		b = append(b, 0xc5, byte(len(val)>>8), byte(len(val)))
+		return append(b, val...)
+	case msgpackExt:
+		b = append(b, 0xd7, byte(val.typ))
+		return append(b, val.data...)
+	case []interface{}:
+		b = append(b, 0xdc, byte(len(val)>>8), byte(len(val)))
+		for _, e := range val {
+			b = appendMsgpack(b, e)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, 0xde, byte(len(val)>>8), byte(len(val)))
+		for _, k := range keys {
+			b = appendMsgpack(appendMsgpackString(b, k), val[k])
+		}
+		return b
+	}
+	panic("unsupported test value")
+}
+
+func eventTimeExt(t time.Time) msgpackExt {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, uint32(t.Unix()))
+	binary.BigEndian.PutUint32(data[4:], uint32(t.Nanosecond()))
+	return msgpackExt{0, data}
+}
+
+func decode(data []byte) (interface{}, error) {
+	return newMsgpackReader(bytes.NewReader(data), 1024).readValue()
+}
+
+func ForwardSpec(c gs.Context) {
+	record := map[string]interface{}{"message": "hello", "status": 200}
+	ts := time.Unix(1456833600, 123456789)
+
+	c.Specify("A msgpack reader", func() {
+		c.Specify("decodes scalar types", func() {
+			for _, test := range []struct {
+				data     []byte
+				expected interface{}
+			}{
+				{[]byte{0x05}, int64(5)},
+				{[]byte{0xff}, int64(-1)},
+				{[]byte{0xd0, 0x80}, int64(-128)},
+				{[]byte{0xd1, 0xff, 0x00}, int64(-256)},
+				{[]byte{0xcd, 0x01, 0x00}, int64(256)},
+				{[]byte{0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+					uint64(math.MaxUint64)},
+				{[]byte{0xca, 0x3f, 0xc0, 0x00, 0x00}, float64(1.5)},
+				{[]byte{0xc3}, true},
+				{[]byte{0xa2, 'h', 'i'}, "hi"},
+				{[]byte{0xd9, 0x02, 'h', 'i'}, "hi"},
+			} {
+				v, err := decode(test.data)
+				c.Expect(err, gs.IsNil)
+				c.Expect(v, gs.Equals, test.expected)
+			}
+		})
+
+		c.Specify("decodes nested values", func() {
+			data := appendMsgpack(nil, []interface{}{"a", record, []byte("b")})
+			v, err := decode(data)
+			c.Assume(err, gs.IsNil)
+			arr := v.([]interface{})
+			c.Assume(len(arr), gs.Equals, 3)
+			c.Expect(arr[0], gs.Equals, "a")
+			c.Expect(arr[1].(map[string]interface{})["status"], gs.Equals, int64(200))
+			c.Expect(string(arr[2].([]byte)), gs.Equals, "b")
+		})
+
+		c.Specify("rejects oversized and truncated values", func() {
+			_, err := decode([]byte{0xdb, 0x7f, 0xff, 0xff, 0xff})
+			c.Expect(err, gs.Equals, errMsgpackTooLarge)
+			_, err = decode([]byte{0xdd, 0x7f, 0xff, 0xff, 0xff})
+			c.Expect(err, gs.Equals, errMsgpackTooLarge)
+			_, err = decode([]byte{0xa5, 'h'})
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, err = decode([]byte{0xc1})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("decodeForward", func() {
+		c.Specify("decodes Message mode with an EventTime", func() {
+			v, _ := decode(appendMsgpack(nil, []interface{}{"app.access",
+				eventTimeExt(ts), record, map[string]interface{}{"chunk": "abc"}}))
+			fm, err := decodeForward(v, 1024)
+			c.Assume(err, gs.IsNil)
+			c.Expect(fm.tag, gs.Equals, "app.access")
+			c.Expect(fm.chunk, gs.Equals, "abc")
+			c.Assume(len(fm.events), gs.Equals, 1)
+			c.Expect(fm.events[0].time.Equal(ts), gs.IsTrue)
+		})
+
+		c.Specify("decodes Forward mode", func() {
+			v, _ := decode(appendMsgpack(nil, []interface{}{"app", []interface{}{
+				[]interface{}{100, record},
+				[]interface{}{101, record},
+			}}))
+			fm, err := decodeForward(v, 1024)
+			c.Assume(err, gs.IsNil)
+			c.Expect(fm.chunk, gs.Equals, "")
+			c.Assume(len(fm.events), gs.Equals, 2)
+			c.Expect(fm.events[1].time.Unix(), gs.Equals, int64(101))
+		})
+
+		c.Specify("decodes compressed PackedForward mode", func() {
+			var entries []byte
+			entries = appendMsgpack(entries, []interface{}{eventTimeExt(ts), record})
+			entries = appendMsgpack(entries, []interface{}{100, record})
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(entries)
+			gz.Close()
+
+			v, _ := decode(appendMsgpack(nil, []interface{}{"app", buf.Bytes(),
+				map[string]interface{}{"compressed": "gzip", "size": 2}}))
+			fm, err := decodeForward(v, 1024)
+			c.Assume(err, gs.IsNil)
+			c.Assume(len(fm.events), gs.Equals, 2)
+			c.Expect(fm.events[0].time.Equal(ts), gs.IsTrue)
+			c.Expect(fm.events[1].record["message"], gs.Equals, "hello")
+
+			// The decompressed size is limited too.
+			_, err = decodeForward(v, 16)
+			c.Expect(err, gs.Equals, errMsgpackTooLarge)
+		})
+
+		c.Specify("rejects malformed messages", func() {
+			for _, msg := range []interface{}{
+				"app",
+				[]interface{}{1, 100, record},
+				[]interface{}{"app", 100},
+				[]interface{}{"app", 100, "record"},
+				[]interface{}{"app", []interface{}{100}},
+			} {
+				v, _ := decode(appendMsgpack(nil, msg))
+				_, err := decodeForward(v, 1024)
+				c.Expect(err, gs.Not(gs.IsNil))
+			}
+		})
+	})
+
+	c.Specify("populateMessage", func() {
+		e := event{ts, map[string]interface{}{
+			"message":  "hello",
+			"status":   int64(200),
+			"duration": 0.5,
+			"raw":      []byte("bytes"),
+			"nested":   map[string]interface{}{"a": []byte("b")},
+			"missing":  nil,
+		}}
+		msg := new(message.Message)
+		populateMessage(msg, e, "message")
+		c.Expect(msg.GetTimestamp(), gs.Equals, ts.UnixNano())
+		c.Expect(msg.GetPayload(), gs.Equals, "hello")
+		c.Expect(len(msg.Fields), gs.Equals, 4)
+		status, _ := msg.GetFieldValue("status")
+		c.Expect(status, gs.Equals, int64(200))
+		duration, _ := msg.GetFieldValue("duration")
+		c.Expect(duration, gs.Equals, 0.5)
+		raw, _ := msg.GetFieldValue("raw")
+		c.Expect(raw, gs.Equals, "bytes")
+		nested, _ := msg.GetFieldValue("nested")
+		c.Expect(nested, gs.Equals, `{"a":"b"}`)
+	})
+}
@@ -0,0 +1,265 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Nesting deeper than this is rejected rather than risking the stack.
+const maxMsgpackDepth = 64
+
+var errMsgpackTooLarge = errors.New("msgpack value exceeds size limit")
+
+// A msgpack extension type value, such as fluentd's EventTime.
+type msgpackExt struct {
+	typ  int8
+	data []byte
+}
+
+// Reads msgpack encoded values from a stream. Integers are returned as
+// int64 (or uint64 if they don't fit), floats as float64, str as string,
+// bin as []byte, arrays as []interface{} and maps as
+// map[string]interface{}, with non-string keys formatted using fmt.
+type msgpackReader struct {
+	r         *bufio.Reader
+	max       int
+	remaining int
+}
+
+// Creates a reader that rejects top-level values larger than max bytes.
+func newMsgpackReader(r io.Reader, max int) *msgpackReader {
+	return &msgpackReader{r: bufio.NewReader(r), max: max}
+}
+
+// Reads the next value from the stream. io.EOF is only returned if the
+// stream ends between values.
+func (m *msgpackReader) readValue() (interface{}, error) {
+	if _, err := m.r.Peek(1); err != nil {
+		return nil, err
+	}
+	m.remaining = m.max
+	v, err := m.value(0)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (m *msgpackReader) read(n int) ([]byte, error) {
+	if n > m.remaining {
+		return nil, errMsgpackTooLarge
+	}
+	m.remaining -= n
+	b := make([]byte, n)
+	_, err := io.ReadFull(m.r, b)
+	return b, err
+}
+
+func (m *msgpackReader) uint(n int) (uint64, error) {
+	b, err := m.read(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (m *msgpackReader) value(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("msgpack value nested too deeply")
+	}
+	b, err := m.read(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return m.mapValue(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return m.array(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return m.str(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := m.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return m.bin(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := m.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return m.ext(n)
+	case 0xca:
+		n, err := m.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := m.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := m.uint(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := m.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend.
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return m.ext(uint64(1) << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := m.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(m.remaining) {
+			return nil, errMsgpackTooLarge
+		}
+		return m.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := m.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(m.remaining) {
+			return nil, errMsgpackTooLarge
+		}
+		return m.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := m.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(m.remaining) {
+			return nil, errMsgpackTooLarge
+		}
+		return m.mapValue(int(n), depth)
+	}
+	return nil, fmt.Errorf("invalid msgpack type byte 0x%02x", c)
+}
+
+func (m *msgpackReader) str(n int) (interface{}, error) {
+	b, err := m.read(n)
+	return string(b), err
+}
+
+func (m *msgpackReader) bin(n uint64) (interface{}, error) {
+	if n > uint64(m.remaining) {
+		return nil, errMsgpackTooLarge
+	}
+	return m.read(int(n))
+}
+
+func (m *msgpackReader) ext(n uint64) (interface{}, error) {
+	typ, err := m.read(1)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(m.remaining) {
+		return nil, errMsgpackTooLarge
+	}
+	data, err := m.read(int(n))
+	if err != nil {
+		return nil, err
+	}
+	return msgpackExt{int8(typ[0]), data}, nil
+}
+
+// Every element takes at least one byte, so the lengths checked against the
+// remaining size limit are safe to preallocate.
+func (m *msgpackReader) array(n, depth int) (interface{}, error) {
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+func (m *msgpackReader) mapValue(n, depth int) (interface{}, error) {
+	mp := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		v, err := m.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch key := k.(type) {
+		case string:
+			mp[key] = v
+		case []byte:
+			mp[string(key)] = v
+		default:
+			mp[fmt.Sprint(key)] = v
+		}
+	}
+	return mp, nil
+}
+
+// Appends a msgpack str.
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 256:
+		b = append(b, 0xd9, byte(n))
+	case n < 65536:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0xdb, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, s...)
+}
@@ -0,0 +1,134 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type MsgpackDecoderConfig struct {
+	// Record key whose value is used as the message payload. Defaults to
+	// "message".
+	PayloadKey string `toml:"payload_key"`
+	// Whether nested maps are flattened into separate fields rather than
+	// stored as JSON. Defaults to true.
+	Flatten bool
+	// Separator between the keys of flattened field names. Defaults to ".".
+	FlattenSeparator string `toml:"flatten_separator"`
+	// Record key whose value sets the message timestamp, if any.
+	TimestampKey      string `toml:"timestamp_key"`
+	TimestampLayout   string `toml:"timestamp_layout"`
+	TimestampLocation string `toml:"timestamp_location"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+}
+
+// Decoder for payloads holding a msgpack encoded map, or a fluentd style
+// [time, record] entry, whose entries become message fields.
+type MsgpackDecoder struct {
+	conf       *MsgpackDecoderConfig
+	separator  string
+	tzLocation *time.Location
+}
+
+func (md *MsgpackDecoder) ConfigStruct() interface{} {
+	return &MsgpackDecoderConfig{
+		PayloadKey:       "message",
+		Flatten:          true,
+		FlattenSeparator: ".",
+	}
+}
+
+func (md *MsgpackDecoder) Init(config interface{}) (err error) {
+	md.conf = config.(*MsgpackDecoderConfig)
+	if md.conf.Flatten {
+		if md.conf.FlattenSeparator == "" {
+			return errors.New("MsgpackDecoder: flatten_separator can't be empty")
+		}
+		md.separator = md.conf.FlattenSeparator
+	}
+	if md.tzLocation, err = time.LoadLocation(md.conf.TimestampLocation); err != nil {
+		return fmt.Errorf("MsgpackDecoder unknown timestamp_location '%s': %s",
+			md.conf.TimestampLocation, err)
+	}
+	return nil
+}
+
+func (md *MsgpackDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	payload := pack.Message.GetPayload()
+	reader := newMsgpackReader(bytes.NewReader([]byte(payload)), len(payload))
+	v, err := reader.readValue()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid msgpack: %s", err)
+	}
+	if _, err = reader.r.Peek(1); err == nil {
+		return nil, errors.New("Invalid msgpack: trailing data after the record")
+	}
+
+	msg := pack.Message
+	var record map[string]interface{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		record = val
+	case []interface{}:
+		e, err := decodeEntry(val)
+		if err != nil {
+			return nil, err
+		}
+		record = e.record
+		msg.SetTimestamp(e.time.UnixNano())
+	default:
+		return nil, errors.New("msgpack value isn't a map")
+	}
+
+	if key := md.conf.TimestampKey; key != "" {
+		if tv, ok := record[key]; ok {
+			t, err := md.timestamp(tv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", key, err)
+			}
+			msg.SetTimestamp(t.UnixNano())
+			delete(record, key)
+		}
+	}
+	msg.SetPayload("")
+	addRecordFields(msg, record, "", md.conf.PayloadKey, md.separator)
+	if md.conf.MessageType != "" {
+		msg.SetType(md.conf.MessageType)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Converts a timestamp, either a string in the timestamp layout or a fluentd
+// time, to a time.Time.
+func (md *MsgpackDecoder) timestamp(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case string:
+		return message.ForgivingTimeParse(md.conf.TimestampLayout, t, md.tzLocation)
+	case []byte:
+		return message.ForgivingTimeParse(md.conf.TimestampLayout, string(t),
+			md.tzLocation)
+	}
+	return eventTime(v)
+}
+
+func init() {
+	RegisterPlugin("MsgpackDecoder", func() interface{} {
+		return new(MsgpackDecoder)
+	})
+}
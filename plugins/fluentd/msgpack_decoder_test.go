@@ -0,0 +1,102 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package fluentd
+
+import (
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MsgpackDecoderSpec(c gs.Context) {
+	decoder := new(MsgpackDecoder)
+	conf := decoder.ConfigStruct().(*MsgpackDecoderConfig)
+	pack := NewPipelinePack(make(chan *PipelinePack, 1))
+	record := map[string]interface{}{
+		"message": "GET /index.html",
+		"status":  200,
+		"time":    "2016-03-01T12:00:00Z",
+		"http": map[string]interface{}{
+			"method": "GET",
+			"headers": map[string]interface{}{
+				"host": "example.com",
+			},
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	decode := func(v interface{}) error {
+		pack.Message.SetPayload(string(appendMsgpack(nil, v)))
+		packs, err := decoder.Decode(pack)
+		if err == nil {
+			c.Expect(len(packs), gs.Equals, 1)
+		}
+		return err
+	}
+
+	c.Specify("A MsgpackDecoder", func() {
+		c.Specify("flattens nested maps", func() {
+			conf.TimestampKey = "time"
+			conf.MessageType = "app.log"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			err = decode(record)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "GET /index.html")
+			c.Expect(pack.Message.GetType(), gs.Equals, "app.log")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1456833600000000000))
+			c.Expect(field("status"), gs.Equals, int64(200))
+			c.Expect(field("http.method"), gs.Equals, "GET")
+			c.Expect(field("http.headers.host"), gs.Equals, "example.com")
+			c.Expect(field("tags"), gs.Equals, `["a","b"]`)
+			c.Expect(field("time"), gs.IsNil)
+		})
+
+		c.Specify("stores nested maps as JSON when not flattening", func() {
+			conf.Flatten = false
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			err = decode(record)
+			c.Expect(err, gs.IsNil)
+			c.Expect(field("http"), gs.Equals,
+				`{"headers":{"host":"example.com"},"method":"GET"}`)
+			c.Expect(field("time"), gs.Equals, "2016-03-01T12:00:00Z")
+		})
+
+		c.Specify("decodes [time, record] entries", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			ts := time.Unix(1456833600, 5)
+			err = decode([]interface{}{eventTimeExt(ts), record})
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, ts.UnixNano())
+			c.Expect(field("status"), gs.Equals, int64(200))
+		})
+
+		c.Specify("rejects invalid payloads", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			c.Expect(decode("not a map"), gs.Not(gs.IsNil))
+			pack.Message.SetPayload(string(appendMsgpack(appendMsgpack(nil, record), 1)))
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			pack.Message.SetPayload("\xa5h")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
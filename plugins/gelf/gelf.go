@@ -0,0 +1,231 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+const (
+	chunkHeaderSize = 12
+	maxChunks       = 128
+)
+
+var chunkMagic = []byte{0x1e, 0x0f}
+
+// Returns true if the datagram is one chunk of a larger message.
+func isChunk(datagram []byte) bool {
+	return len(datagram) >= 2 && bytes.Equal(datagram[:2], chunkMagic)
+}
+
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+// Reassembles chunked GELF messages. Messages whose chunks don't all arrive
+// within the timeout are discarded. Not safe for concurrent use.
+type reassembler struct {
+	pending   map[string]*chunkedMessage
+	timeout   time.Duration
+	maxSize   int
+	lastSweep time.Time
+	expired   int64
+}
+
+func newReassembler(timeout time.Duration, maxSize int) *reassembler {
+	return &reassembler{
+		pending: make(map[string]*chunkedMessage),
+		timeout: timeout,
+		maxSize: maxSize,
+	}
+}
+
+// Adds a chunk, returning the complete message once all of its chunks have
+// arrived and nil before then.
+func (r *reassembler) add(datagram []byte, now time.Time) ([]byte, error) {
+	if now.Sub(r.lastSweep) >= time.Second {
+		r.sweep(now)
+	}
+	if len(datagram) < chunkHeaderSize {
+		return nil, errors.New("truncated chunk header")
+	}
+	id := string(datagram[2:10])
+	seq, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > maxChunks || seq >= count {
+		return nil, fmt.Errorf("invalid chunk %d of %d", seq, count)
+	}
+
+	m, ok := r.pending[id]
+	if !ok {
+		m = &chunkedMessage{chunks: make([][]byte, count), first: now}
+		r.pending[id] = m
+	} else if len(m.chunks) != count {
+		delete(r.pending, id)
+		return nil, errors.New("chunk count changed between chunks")
+	}
+	if m.chunks[seq] != nil {
+		// Duplicate.
+		return nil, nil
+	}
+	data := datagram[chunkHeaderSize:]
+	m.size += len(data)
+	if m.size > r.maxSize {
+		delete(r.pending, id)
+		return nil, fmt.Errorf("chunked message exceeds %d bytes", r.maxSize)
+	}
+	// The datagram buffer is reused, so keep a copy.
+	m.chunks[seq] = append([]byte(nil), data...)
+	m.received++
+	if m.received < count {
+		return nil, nil
+	}
+	delete(r.pending, id)
+	return bytes.Join(m.chunks, nil), nil
+}
+
+func (r *reassembler) sweep(now time.Time) {
+	for id, m := range r.pending {
+		if now.Sub(m.first) > r.timeout {
+			delete(r.pending, id)
+			r.expired++
+		}
+	}
+	r.lastSweep = now
+}
+
+// Decompresses a gzip or zlib compressed payload, returning uncompressed
+// payloads unchanged.
+func decompress(data []byte, maxSize int) ([]byte, error) {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch {
+	case len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case len(data) >= 2 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, fmt.Errorf("decompressed message exceeds %d bytes", maxSize)
+	}
+	return out, nil
+}
+
+// Standard GELF fields that are stored as message fields under the same
+// name.
+var standardFields = []string{"full_message", "facility", "file", "line"}
+
+// Populates msg from a GELF JSON document. The GELF host becomes the
+// Hostname (defaultHost if missing), short_message the Payload and level
+// the Severity; additional fields are stored without their leading
+// underscore.
+func populateMessage(msg *message.Message, data []byte, defaultHost string,
+	now time.Time) error {
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid GELF JSON: %s", err)
+	}
+	short, ok := doc["short_message"].(string)
+	if !ok {
+		return errors.New("GELF message has no short_message")
+	}
+	msg.SetPayload(short)
+
+	host, _ := doc["host"].(string)
+	if host == "" {
+		host = defaultHost
+	}
+	msg.SetHostname(host)
+
+	timestamp := now.UnixNano()
+	if ts, ok := doc["timestamp"].(float64); ok {
+		timestamp = int64(ts * 1e9)
+	}
+	msg.SetTimestamp(timestamp)
+
+	// GELF defaults to ALERT.
+	severity := int32(1)
+	if level, ok := doc["level"].(float64); ok {
+		severity = int32(level)
+	}
+	msg.SetSeverity(severity)
+
+	for _, name := range standardFields {
+		if v, ok := doc[name]; ok {
+			if err := addField(msg, name, v); err != nil {
+				return err
+			}
+		}
+	}
+	var extra []string
+	for name := range doc {
+		if strings.HasPrefix(name, "_") && name != "_id" && len(name) > 1 {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		if err := addField(msg, name[1:], doc[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addField(msg *message.Message, name string, v interface{}) error {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case float64:
+		// JSON doesn't distinguish integers, so treat whole numbers as such.
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			v = int64(value)
+		}
+	case string, bool:
+	default:
+		b, _ := json.Marshal(value)
+		v = string(b)
+	}
+	field, err := message.NewField(name, v, "")
+	if err != nil {
+		return fmt.Errorf("field %s: %s", name, err)
+	}
+	msg.AddField(field)
+	return nil
+}
@@ -0,0 +1,297 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+// Input plugin that accepts Graylog Extended Log Format (GELF) messages over
+// UDP, including chunked and compressed messages, or null byte delimited
+// over TCP.
+type GelfInput struct {
+	conf         *GelfInputConfig
+	ir           InputRunner
+	listener     net.Listener
+	udpConn      *net.UDPConn
+	stopChan     chan bool
+	wg           sync.WaitGroup
+	messageCount int64
+	invalidCount int64
+	expiredCount int64
+	maxSize      int
+	chunkTimeout time.Duration
+	reassembler  *reassembler
+}
+
+type GelfInputConfig struct {
+	// Network type ("udp", "udp4", "udp6", "tcp", "tcp4" or "tcp6").
+	// Defaults to "udp".
+	Net string
+	// Address to listen on. Defaults to "127.0.0.1:12201".
+	Address string
+	// Largest accepted message in bytes, after reassembly and
+	// decompression. Defaults to 1048576.
+	MaxMessageSize uint `toml:"max_message_size"`
+	// Milliseconds to wait for all of a chunked message's chunks to arrive.
+	// Defaults to 5000.
+	ChunkTimeout uint `toml:"chunk_timeout"`
+}
+
+func (gi *GelfInput) ConfigStruct() interface{} {
+	return &GelfInputConfig{
+		Net:            "udp",
+		Address:        "127.0.0.1:12201",
+		MaxMessageSize: 1024 * 1024,
+		ChunkTimeout:   5000,
+	}
+}
+
+func (gi *GelfInput) Init(config interface{}) (err error) {
+	gi.conf = config.(*GelfInputConfig)
+	if gi.conf.MaxMessageSize == 0 {
+		return fmt.Errorf("GelfInput: max_message_size must be greater than 0")
+	}
+	gi.maxSize = int(gi.conf.MaxMessageSize)
+	gi.chunkTimeout = time.Duration(gi.conf.ChunkTimeout) * time.Millisecond
+	switch gi.conf.Net {
+	case "tcp", "tcp4", "tcp6":
+		var addr *net.TCPAddr
+		if addr, err = net.ResolveTCPAddr(gi.conf.Net, gi.conf.Address); err != nil {
+			return fmt.Errorf("GelfInput: ResolveTCPAddr failed: %s", err)
+		}
+		if gi.listener, err = net.ListenTCP(gi.conf.Net, addr); err != nil {
+			return fmt.Errorf("GelfInput: ListenTCP failed: %s", err)
+		}
+	case "udp", "udp4", "udp6":
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr(gi.conf.Net, gi.conf.Address); err != nil {
+			return fmt.Errorf("GelfInput: ResolveUDPAddr failed: %s", err)
+		}
+		if gi.udpConn, err = net.ListenUDP(gi.conf.Net, addr); err != nil {
+			return fmt.Errorf("GelfInput: ListenUDP failed: %s", err)
+		}
+		gi.reassembler = newReassembler(gi.chunkTimeout, gi.maxSize)
+	default:
+		return fmt.Errorf(`GelfInput: "%s" is not a supported network, must be `+
+			`"udp", "udp4", "udp6", "tcp", "tcp4" or "tcp6"`, gi.conf.Net)
+	}
+	gi.stopChan = make(chan bool)
+	return nil
+}
+
+// Parses a complete, uncompressed GELF document and delivers it. Invalid
+// documents are logged and dropped.
+func (gi *GelfInput) handleMessage(data []byte, host string) {
+	pack := <-gi.ir.InChan()
+	err := populateMessage(pack.Message, data, host, time.Now())
+	if err != nil {
+		atomic.AddInt64(&gi.invalidCount, 1)
+		gi.ir.LogError(fmt.Errorf("message from %s: %s", host, err))
+		pack.Recycle(nil)
+		return
+	}
+	atomic.AddInt64(&gi.messageCount, 1)
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetType("gelf")
+	pack.Message.SetLogger(gi.ir.Name())
+	gi.ir.Deliver(pack)
+}
+
+func (gi *GelfInput) handleDatagram(datagram []byte, host string) {
+	data := datagram
+	if isChunk(datagram) {
+		var err error
+		data, err = gi.reassembler.add(datagram, time.Now())
+		atomic.StoreInt64(&gi.expiredCount, gi.reassembler.expired)
+		if err != nil {
+			atomic.AddInt64(&gi.invalidCount, 1)
+			gi.ir.LogError(fmt.Errorf("chunk from %s: %s", host, err))
+			return
+		}
+		if data == nil {
+			return
+		}
+	}
+	data, err := decompress(data, gi.maxSize)
+	if err != nil {
+		atomic.AddInt64(&gi.invalidCount, 1)
+		gi.ir.LogError(fmt.Errorf("decompressing message from %s: %s", host, err))
+		return
+	}
+	gi.handleMessage(data, host)
+}
+
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (gi *GelfInput) handleConnection(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		gi.wg.Done()
+	}()
+	host := remoteHost(conn.RemoteAddr())
+	// Close the connection on shutdown to unblock the reader.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-gi.stopChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		frame, err := readFrame(reader, gi.maxSize)
+		if err == errFrameTooLong {
+			atomic.AddInt64(&gi.invalidCount, 1)
+			gi.ir.LogError(fmt.Errorf("message from %s longer than %d bytes", host,
+				gi.maxSize))
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-gi.stopChan:
+				default:
+					gi.ir.LogError(fmt.Errorf("reading from %s: %s", host, err))
+				}
+			}
+			return
+		}
+		if len(frame) > 0 {
+			gi.handleMessage(frame, host)
+		}
+	}
+}
+
+var errFrameTooLong = errors.New("frame too long")
+
+// Reads the next null byte terminated frame, without the terminator, which
+// may not exceed max bytes. Longer frames are consumed and reported as
+// errFrameTooLong. An unterminated final frame is returned before io.EOF.
+func readFrame(r *bufio.Reader, max int) ([]byte, error) {
+	var (
+		frame   []byte
+		tooLong bool
+	)
+	for {
+		chunk, err := r.ReadSlice(0)
+		if !tooLong {
+			if len(frame)+len(chunk) > max+1 {
+				tooLong, frame = true, nil
+			} else {
+				frame = append(frame, chunk...)
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case tooLong:
+			return nil, errFrameTooLong
+		case err == io.EOF && len(frame) > 0:
+			return frame, nil
+		case err != nil:
+			return nil, err
+		}
+		return frame[:len(frame)-1], nil
+	}
+}
+
+func (gi *GelfInput) runTCP() {
+	for {
+		conn, err := gi.listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				gi.ir.LogError(fmt.Errorf("TCP accept failed: %s", err))
+				continue
+			}
+			break
+		}
+		gi.wg.Add(1)
+		go gi.handleConnection(conn)
+	}
+}
+
+func (gi *GelfInput) runUDP() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := gi.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-gi.stopChan:
+				return
+			default:
+			}
+			gi.ir.LogError(fmt.Errorf("UDP read failed: %s", err))
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
+		}
+		gi.handleDatagram(buf[:n], addr.IP.String())
+	}
+}
+
+func (gi *GelfInput) Run(ir InputRunner, h PluginHelper) error {
+	gi.ir = ir
+	if gi.listener != nil {
+		gi.runTCP()
+	} else {
+		gi.runUDP()
+	}
+	gi.wg.Wait()
+	return nil
+}
+
+func (gi *GelfInput) Stop() {
+	close(gi.stopChan)
+	if gi.listener != nil {
+		gi.listener.Close()
+	} else {
+		gi.udpConn.Close()
+	}
+}
+
+func (gi *GelfInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "MessageCount",
+		atomic.LoadInt64(&gi.messageCount), "count")
+	message.NewInt64Field(msg, "InvalidCount",
+		atomic.LoadInt64(&gi.invalidCount), "count")
+	message.NewInt64Field(msg, "ExpiredChunkedCount",
+		atomic.LoadInt64(&gi.expiredCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("GelfInput", func() interface{} {
+		return new(GelfInput)
+	})
+}
@@ -0,0 +1,137 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	. "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func GelfInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A GelfInput", func() {
+		input := new(GelfInput)
+		config := input.ConfigStruct().(*GelfInputConfig)
+		config.Address = "127.0.0.1:0"
+
+		ir := NewMockInputRunner(ctrl)
+		h := NewMockPluginHelper(ctrl)
+		inChan := make(chan *PipelinePack, 3)
+		for i := 0; i < 3; i++ {
+			inChan <- NewPipelinePack(pConfig.InputRecycleChan())
+		}
+		ir.EXPECT().InChan().Return(inChan).AnyTimes()
+		ir.EXPECT().Name().Return("gelf").AnyTimes()
+		delivered := make(chan *PipelinePack, 2)
+		ir.EXPECT().Deliver(gomock.Any()).Do(func(pack *PipelinePack) {
+			delivered <- pack
+		}).AnyTimes()
+		ir.EXPECT().LogError(gomock.Any()).AnyTimes()
+
+		first := `{"version": "1.1", "host": "app1", "short_message": "first", "level": 4}`
+		second := `{"version": "1.1", "short_message": "` + strings.Repeat("x", 100) +
+			`", "_request_id": "abc"}`
+		checkMessages := func() {
+			pack := <-delivered
+			c.Expect(pack.Message.GetType(), gs.Equals, "gelf")
+			c.Expect(pack.Message.GetLogger(), gs.Equals, "gelf")
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "app1")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "first")
+			c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(len(pack.Message.GetUuid()), gs.Equals, 16)
+
+			pack = <-delivered
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "127.0.0.1")
+			c.Expect(len(pack.Message.GetPayload()), gs.Equals, 100)
+			requestId, _ := pack.Message.GetFieldValue("request_id")
+			c.Expect(requestId, gs.Equals, "abc")
+			c.Expect(input.invalidCount, gs.Equals, int64(1))
+
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			count, _ := msg.GetFieldValue("MessageCount")
+			c.Expect(count, gs.Equals, int64(2))
+		}
+
+		c.Specify("rejects unknown networks", func() {
+			config.Net = "unix"
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("accepts chunked and compressed messages over UDP", func() {
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(ir, h)
+			}()
+
+			conn, err := net.Dial("udp", input.udpConn.LocalAddr().String())
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			conn.Write([]byte(first))
+			conn.Write([]byte("{bogus"))
+			var compressed bytes.Buffer
+			z := zlib.NewWriter(&compressed)
+			z.Write([]byte(second))
+			z.Close()
+			for _, chunk := range makeChunks("12345678", compressed.Bytes(), 16) {
+				conn.Write(chunk)
+			}
+			checkMessages()
+
+			input.Stop()
+			select {
+			case err = <-errChan:
+				c.Expect(err, gs.IsNil)
+			case <-time.After(time.Second):
+				c.Expect("GelfInput stopped", gs.Equals, "GelfInput hung")
+			}
+		})
+
+		c.Specify("accepts null byte delimited messages over TCP", func() {
+			config.Net = "tcp"
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(ir, h)
+			}()
+
+			conn, err := net.Dial("tcp", input.listener.Addr().String())
+			c.Assume(err, gs.IsNil)
+			_, err = conn.Write([]byte(first + "\x00{bogus\x00" + second + "\x00"))
+			c.Expect(err, gs.IsNil)
+			checkMessages()
+
+			// Stopping closes connections that are still open.
+			input.Stop()
+			c.Expect(<-errChan, gs.IsNil)
+			conn.Close()
+		})
+	})
+}
@@ -0,0 +1,164 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Splits data into GELF chunks of at most size bytes.
+func makeChunks(id string, data []byte, size int) [][]byte {
+	count := (len(data) + size - 1) / size
+	chunks := make([][]byte, count)
+	for i := range chunks {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks[i] = append(chunk, data[i*size:end]...)
+	}
+	return chunks
+}
+
+func GelfSpec(c gs.Context) {
+	now := time.Unix(1400000000, 0)
+	value := func(msg *message.Message, name string) interface{} {
+		v, _ := msg.GetFieldValue(name)
+		return v
+	}
+
+	c.Specify("A GELF document", func() {
+		msg := new(message.Message)
+
+		c.Specify("maps the standard fields", func() {
+			doc := `{"version": "1.1", "host": "app1", "short_message": "disk full",
+				"full_message": "disk full\nstack", "timestamp": 1385053862.3072,
+				"level": 3, "_user_id": 9001, "_ratio": 0.5, "_path": "/var",
+				"_id": "ignored", "_tags": ["a", "b"]}`
+			err := populateMessage(msg, []byte(doc), "10.0.0.1", now)
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetHostname(), gs.Equals, "app1")
+			c.Expect(msg.GetPayload(), gs.Equals, "disk full")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(3))
+			c.Expect(msg.GetTimestamp()/1e6, gs.Equals, int64(1385053862307))
+			c.Expect(value(msg, "full_message"), gs.Equals, "disk full\nstack")
+			c.Expect(value(msg, "user_id"), gs.Equals, int64(9001))
+			c.Expect(value(msg, "ratio"), gs.Equals, 0.5)
+			c.Expect(value(msg, "path"), gs.Equals, "/var")
+			c.Expect(value(msg, "tags"), gs.Equals, `["a","b"]`)
+			c.Expect(msg.FindFirstField("id"), gs.IsNil)
+		})
+
+		c.Specify("uses defaults for missing optional fields", func() {
+			err := populateMessage(msg, []byte(`{"short_message": "hi"}`), "10.0.0.1", now)
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetHostname(), gs.Equals, "10.0.0.1")
+			c.Expect(msg.GetSeverity(), gs.Equals, int32(1))
+			c.Expect(msg.GetTimestamp(), gs.Equals, now.UnixNano())
+		})
+
+		c.Specify("requires a short_message", func() {
+			err := populateMessage(msg, []byte(`{"host": "app1"}`), "", now)
+			c.Expect(err, gs.Not(gs.IsNil))
+			err = populateMessage(msg, []byte(`not json`), "", now)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("Compressed payloads", func() {
+		doc := []byte(`{"short_message": "hi"}`)
+
+		c.Specify("are decompressed", func() {
+			var gzipped, zlibbed bytes.Buffer
+			gz := gzip.NewWriter(&gzipped)
+			gz.Write(doc)
+			gz.Close()
+			z := zlib.NewWriter(&zlibbed)
+			z.Write(doc)
+			z.Close()
+			for _, data := range [][]byte{doc, gzipped.Bytes(), zlibbed.Bytes()} {
+				out, err := decompress(data, 1024)
+				c.Expect(err, gs.IsNil)
+				c.Expect(string(out), gs.Equals, string(doc))
+			}
+		})
+
+		c.Specify("are limited in size", func() {
+			var gzipped bytes.Buffer
+			gz := gzip.NewWriter(&gzipped)
+			gz.Write([]byte(strings.Repeat("x", 2000)))
+			gz.Close()
+			_, err := decompress(gzipped.Bytes(), 1024)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A chunk reassembler", func() {
+		r := newReassembler(5*time.Second, 1024)
+		data := []byte(`{"short_message": "a longer message split into chunks"}`)
+		chunks := makeChunks("abcdefgh", data, 10)
+
+		c.Specify("reassembles chunks in any order", func() {
+			c.Expect(isChunk(chunks[0]), gs.IsTrue)
+			c.Expect(isChunk(data), gs.IsFalse)
+			for i := len(chunks) - 1; i > 0; i-- {
+				out, err := r.add(chunks[i], now)
+				c.Expect(err, gs.IsNil)
+				c.Expect(out, gs.IsNil)
+			}
+			// Duplicates are ignored.
+			out, err := r.add(chunks[1], now)
+			c.Expect(out, gs.IsNil)
+			out, err = r.add(chunks[0], now)
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(out), gs.Equals, string(data))
+			c.Expect(len(r.pending), gs.Equals, 0)
+		})
+
+		c.Specify("discards incomplete messages after the timeout", func() {
+			r.add(chunks[0], now)
+			r.add(chunks[1], now.Add(6*time.Second))
+			c.Expect(r.expired, gs.Equals, int64(1))
+			c.Expect(r.pending["abcdefgh"].received, gs.Equals, 1)
+		})
+
+		c.Specify("rejects invalid chunks", func() {
+			_, err := r.add(chunks[0][:8], now)
+			c.Expect(err, gs.Not(gs.IsNil))
+			bad := append([]byte(nil), chunks[0]...)
+			bad[10], bad[11] = 3, 2
+			_, err = r.add(bad, now)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("limits the reassembled size", func() {
+			r.maxSize = 20
+			var err error
+			for _, chunk := range chunks {
+				if _, err = r.add(chunk, now); err != nil {
+					break
+				}
+			}
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(len(r.pending), gs.Equals, 0)
+		})
+	})
+}
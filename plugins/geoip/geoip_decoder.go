@@ -24,21 +24,37 @@ import (
 	"github.com/abh/geoip"
 	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
+	"os"
 	"strconv"
+	"time"
 )
 
 type GeoIpDecoderConfig struct {
 	DatabaseFile  string `toml:"db_file"`
 	SourceIpField string `toml:"source_ip_field"`
 	TargetField   string `toml:"target_field"`
+	// Prefix of the separate country, region, city and location fields, which
+	// aren't added if empty.
+	FieldPrefix string `toml:"field_prefix"`
+	// Whether the database is memory-mapped rather than read into memory.
+	MemoryMapped bool `toml:"memory_mapped"`
+	// Seconds between checks for a changed database file, 0 disables them.
+	ReloadInterval uint `toml:"reload_interval"`
 }
 
 type GeoIpDecoder struct {
-	DatabaseFile  string
-	SourceIpField string
-	TargetField   string
-	gi            *geoip.GeoIP
-	pConfig       *PipelineConfig
+	DatabaseFile   string
+	SourceIpField  string
+	TargetField    string
+	FieldPrefix    string
+	gi             *geoip.GeoIP
+	pConfig        *PipelineConfig
+	dRunner        DecoderRunner
+	openFlag       int
+	reloadInterval time.Duration
+	lastCheck      time.Time
+	dbModTime      time.Time
+	dbSize         int64
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -47,12 +63,18 @@ func (ld *GeoIpDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
 	ld.pConfig = pConfig
 }
 
+func (ld *GeoIpDecoder) SetDecoderRunner(dr DecoderRunner) {
+	ld.dRunner = dr
+}
+
 func (ld *GeoIpDecoder) ConfigStruct() interface{} {
 	globals := ld.pConfig.Globals
 	return &GeoIpDecoderConfig{
-		DatabaseFile:  globals.PrependShareDir("GeoLiteCity.dat"),
-		SourceIpField: "",
-		TargetField:   "geoip",
+		DatabaseFile:   globals.PrependShareDir("GeoLiteCity.dat"),
+		SourceIpField:  "",
+		TargetField:    "geoip",
+		MemoryMapped:   true,
+		ReloadInterval: 60,
 	}
 }
 
@@ -63,21 +85,67 @@ func (ld *GeoIpDecoder) Init(config interface{}) (err error) {
 		return errors.New("`source_ip_field` must be specified")
 	}
 
-	if conf.TargetField == "" {
-		return errors.New("`target_field` must be specified")
+	if conf.TargetField == "" && conf.FieldPrefix == "" {
+		return errors.New("`target_field` or `field_prefix` must be specified")
 	}
 
+	ld.DatabaseFile = conf.DatabaseFile
 	ld.TargetField = conf.TargetField
 	ld.SourceIpField = conf.SourceIpField
+	ld.FieldPrefix = conf.FieldPrefix
+	ld.reloadInterval = time.Duration(conf.ReloadInterval) * time.Second
+	ld.openFlag = geoip.GEOIP_MEMORY_CACHE
+	if conf.MemoryMapped {
+		ld.openFlag = geoip.GEOIP_MMAP_CACHE
+	}
 
 	if ld.gi == nil {
-		ld.gi, err = geoip.Open(conf.DatabaseFile)
+		if err = ld.openDatabase(); err != nil {
+			return fmt.Errorf("Could not open GeoIP database: %s", err)
+		}
 	}
+
+	return
+}
+
+// Opens the database file, replacing the database in use on success.
+func (ld *GeoIpDecoder) openDatabase() error {
+	fi, err := os.Stat(ld.DatabaseFile)
+	if err != nil {
+		return err
+	}
+	gi, err := geoip.OpenDb([]string{ld.DatabaseFile}, ld.openFlag)
 	if err != nil {
-		return fmt.Errorf("Could not open GeoIP database: %s\n")
+		return err
 	}
+	ld.gi = gi
+	ld.dbModTime = fi.ModTime()
+	ld.dbSize = fi.Size()
+	return nil
+}
 
-	return
+// Reopens the database if the file changed since it was opened, at most once
+// per reload interval. Replace the file by renaming a new one over it, since
+// a memory-mapped file that's modified in place can be read half written.
+func (ld *GeoIpDecoder) checkDatabase() {
+	if ld.reloadInterval == 0 || time.Since(ld.lastCheck) < ld.reloadInterval {
+		return
+	}
+	ld.lastCheck = time.Now()
+	fi, err := os.Stat(ld.DatabaseFile)
+	if err != nil || (fi.ModTime().Equal(ld.dbModTime) && fi.Size() == ld.dbSize) {
+		return
+	}
+	// The old database is freed by its finalizer once it's unreachable.
+	if err = ld.openDatabase(); err != nil {
+		if ld.dRunner != nil {
+			ld.dRunner.LogError(fmt.Errorf("can't reload GeoIP database: %s", err))
+		}
+		return
+	}
+	if ld.dRunner != nil {
+		ld.dRunner.LogMessage("reloaded GeoIP database")
+	}
 }
 
 func (ld *GeoIpDecoder) GetRecord(ip string) *geoip.GeoIPRecord {
@@ -151,10 +219,32 @@ func (ld *GeoIpDecoder) GeoBuff(rec *geoip.GeoIPRecord) bytes.Buffer {
 	return buf
 }
 
+// Adds the record's location as separate fields named with the field
+// prefix.
+func (ld *GeoIpDecoder) AddFields(msg *message.Message, rec *geoip.GeoIPRecord) {
+	strs := []struct{ name, value string }{
+		{"country_code", rec.CountryCode},
+		{"country_name", rec.CountryName},
+		{"region", rec.Region},
+		{"city", rec.City},
+	}
+	for _, s := range strs {
+		if s.value != "" {
+			message.NewStringField(msg, ld.FieldPrefix+s.name, s.value)
+		}
+	}
+	lat, _ := message.NewField(ld.FieldPrefix+"latitude", float64(rec.Latitude), "degrees")
+	msg.AddField(lat)
+	lon, _ := message.NewField(ld.FieldPrefix+"longitude", float64(rec.Longitude), "degrees")
+	msg.AddField(lon)
+}
+
 func (ld *GeoIpDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
 	var buf bytes.Buffer
 	var ipAddr, _ = pack.Message.GetFieldValue(ld.SourceIpField)
 
+	ld.checkDatabase()
+
 	ip, ok := ipAddr.(string)
 
 	if !ok {
@@ -166,7 +256,12 @@ func (ld *GeoIpDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err e
 	if ld.gi != nil {
 		rec := ld.gi.GetRecord(ip)
 		if rec != nil {
-			buf = ld.GeoBuff(rec)
+			if ld.TargetField != "" {
+				buf = ld.GeoBuff(rec)
+			}
+			if ld.FieldPrefix != "" {
+				ld.AddFields(pack.Message, rec)
+			}
 		} else {
 			// IP address did not return a valid GeoIp record but that's ok sometimes(private ip?). Return without error.
 			packs = []*PipelinePack{pack}
@@ -82,5 +82,21 @@ func GeoIpDecoderSpec(c gs.Context) {
 			c.Expect(string(b.([]byte)), gs.Equals, `{"latitude":37.4192008972168,"longitude":-122.0574035644531,"location":[-122.0574035644531,37.4192008972168],"coordinates":["-122.0574035644531","37.4192008972168"],"countrycode":"US","countrycode3":"USA","countryname":"United States","region":"CA","city":"Mountain View","postalcode":"94043","areacode":650,"charset":1,"continentcode":"NA"}`)
 		})
 
+		c.Specify("Test GeoIpDecoder separate fields", func() {
+			decoder.FieldPrefix = "geo_"
+			decoder.AddFields(pack.Message, rec)
+
+			value := func(name string) interface{} {
+				v, _ := pack.Message.GetFieldValue(name)
+				return v
+			}
+			c.Expect(value("geo_country_code"), gs.Equals, "US")
+			c.Expect(value("geo_country_name"), gs.Equals, "United States")
+			c.Expect(value("geo_region"), gs.Equals, "CA")
+			c.Expect(value("geo_city"), gs.Equals, "Mountain View")
+			c.Expect(value("geo_latitude"), gs.Equals, float64(rec.Latitude))
+			c.Expect(value("geo_longitude"), gs.Equals, float64(rec.Longitude))
+		})
+
 	})
 }
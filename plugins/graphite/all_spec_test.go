@@ -23,6 +23,7 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(CarbonInputSpec)
 	r.AddSpec(CarbonOutputSpec)
 	r.AddSpec(WhisperOutputSpec)
 	r.AddSpec(WhisperRunnerSpec)
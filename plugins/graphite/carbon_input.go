@@ -0,0 +1,310 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package graphite
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/pborman/uuid"
+)
+
+// Input plugin that accepts metrics in the Graphite plaintext protocol
+// ("<name> <value> <timestamp>\n" lines) over TCP or UDP, and emits them as
+// statmetric messages.
+type CarbonInput struct {
+	conf          *CarbonInputConfig
+	ir            InputRunner
+	listener      net.Listener
+	udpConn       *net.UDPConn
+	stopChan      chan bool
+	wg            sync.WaitGroup
+	metricCount   int64
+	invalidCount  int64
+	maxLineLength int
+}
+
+// ConfigStruct for CarbonInput plugin.
+type CarbonInputConfig struct {
+	// Network type ("tcp", "tcp4", "tcp6", "udp", "udp4" or "udp6"). Defaults
+	// to "tcp".
+	Net string
+	// Address to listen on. Defaults to "127.0.0.1:2003".
+	Address string
+	// Longest accepted line, and the largest UDP datagram, in bytes.
+	// Defaults to 65536.
+	MaxLineLength uint `toml:"max_line_length"`
+}
+
+func (ci *CarbonInput) ConfigStruct() interface{} {
+	return &CarbonInputConfig{
+		Net:           "tcp",
+		Address:       "127.0.0.1:2003",
+		MaxLineLength: 65536,
+	}
+}
+
+func (ci *CarbonInput) Init(config interface{}) (err error) {
+	ci.conf = config.(*CarbonInputConfig)
+	if ci.conf.MaxLineLength == 0 {
+		return fmt.Errorf("CarbonInput: max_line_length must be greater than 0")
+	}
+	ci.maxLineLength = int(ci.conf.MaxLineLength)
+	switch ci.conf.Net {
+	case "tcp", "tcp4", "tcp6":
+		var addr *net.TCPAddr
+		if addr, err = net.ResolveTCPAddr(ci.conf.Net, ci.conf.Address); err != nil {
+			return fmt.Errorf("CarbonInput: ResolveTCPAddr failed: %s", err)
+		}
+		if ci.listener, err = net.ListenTCP(ci.conf.Net, addr); err != nil {
+			return fmt.Errorf("CarbonInput: ListenTCP failed: %s", err)
+		}
+	case "udp", "udp4", "udp6":
+		var addr *net.UDPAddr
+		if addr, err = net.ResolveUDPAddr(ci.conf.Net, ci.conf.Address); err != nil {
+			return fmt.Errorf("CarbonInput: ResolveUDPAddr failed: %s", err)
+		}
+		if ci.udpConn, err = net.ListenUDP(ci.conf.Net, addr); err != nil {
+			return fmt.Errorf("CarbonInput: ListenUDP failed: %s", err)
+		}
+	default:
+		return fmt.Errorf(`CarbonInput: "%s" is not a supported network, must be `+
+			`"tcp", "tcp4", "tcp6", "udp", "udp4" or "udp6"`, ci.conf.Net)
+	}
+	ci.stopChan = make(chan bool)
+	return nil
+}
+
+// A single parsed plaintext protocol line.
+type carbonMetric struct {
+	name      string
+	value     float64
+	timestamp int64 // Unix seconds.
+}
+
+// Parses a "<name> <value> <timestamp>" line. A timestamp of -1 means now.
+func parseCarbonLine(line string, now time.Time) (m carbonMetric, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return m, fmt.Errorf("malformed line: '%s'", line)
+	}
+	m.name = fields[0]
+	if m.value, err = strconv.ParseFloat(fields[1], 64); err != nil {
+		return m, fmt.Errorf("invalid value in line: '%s'", line)
+	}
+	var ts float64
+	if ts, err = strconv.ParseFloat(fields[2], 64); err != nil {
+		return m, fmt.Errorf("invalid timestamp in line: '%s'", line)
+	}
+	if ts == -1 {
+		m.timestamp = now.Unix()
+	} else if ts < 0 {
+		return m, fmt.Errorf("invalid timestamp in line: '%s'", line)
+	} else {
+		m.timestamp = int64(ts)
+	}
+	return m, nil
+}
+
+// Parses a line and delivers it as a statmetric message. Blank lines are
+// ignored, invalid lines are logged and dropped.
+func (ci *CarbonInput) handleLine(line, host string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	metric, err := parseCarbonLine(line, time.Now())
+	if err != nil {
+		atomic.AddInt64(&ci.invalidCount, 1)
+		ci.ir.LogError(err)
+		return
+	}
+	atomic.AddInt64(&ci.metricCount, 1)
+
+	pack := <-ci.ir.InChan()
+	pack.Message.SetUuid(uuid.NewRandom())
+	pack.Message.SetTimestamp(metric.timestamp * int64(time.Second))
+	pack.Message.SetType("heka.statmetric")
+	pack.Message.SetLogger(ci.ir.Name())
+	pack.Message.SetHostname(host)
+	pack.Message.SetPayload(fmt.Sprintf("%s %s %d\n", metric.name,
+		strconv.FormatFloat(metric.value, 'f', -1, 64), metric.timestamp))
+	message.NewStringField(pack.Message, "Name", metric.name)
+	if field, err := message.NewField("Value", metric.value, ""); err == nil {
+		pack.Message.AddField(field)
+	}
+	ci.ir.Deliver(pack)
+}
+
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (ci *CarbonInput) handleConnection(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		ci.wg.Done()
+	}()
+	host := remoteHost(conn.RemoteAddr())
+	// Close the connection on shutdown to unblock the scanner.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ci.stopChan:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readLine(reader, ci.maxLineLength)
+		if err == errLineTooLong {
+			atomic.AddInt64(&ci.invalidCount, 1)
+			ci.ir.LogError(fmt.Errorf("line from %s longer than %d bytes", host,
+				ci.maxLineLength))
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				select {
+				case <-ci.stopChan:
+				default:
+					ci.ir.LogError(fmt.Errorf("reading from %s: %s", host, err))
+				}
+			}
+			return
+		}
+		ci.handleLine(line, host)
+	}
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// Reads the next newline terminated line, which may not exceed max bytes.
+// Longer lines are consumed and reported as errLineTooLong. An unterminated
+// final line is returned before io.EOF.
+func readLine(r *bufio.Reader, max int) (string, error) {
+	var (
+		line    []byte
+		tooLong bool
+	)
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLong {
+			if len(line)+len(chunk) > max+1 {
+				tooLong, line = true, nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+		switch {
+		case err == bufio.ErrBufferFull:
+			continue
+		case tooLong:
+			return "", errLineTooLong
+		case err == io.EOF && len(line) > 0:
+			return string(line), nil
+		case err != nil:
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+func (ci *CarbonInput) runTCP() {
+	for {
+		conn, err := ci.listener.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				ci.ir.LogError(fmt.Errorf("TCP accept failed: %s", err))
+				continue
+			}
+			break
+		}
+		ci.wg.Add(1)
+		go ci.handleConnection(conn)
+	}
+}
+
+func (ci *CarbonInput) runUDP() {
+	buf := make([]byte, ci.maxLineLength)
+	for {
+		n, addr, err := ci.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-ci.stopChan:
+				return
+			default:
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				ci.ir.LogError(fmt.Errorf("UDP read failed: %s", err))
+				continue
+			}
+			ci.ir.LogError(fmt.Errorf("UDP read failed: %s", err))
+			return
+		}
+		host := addr.IP.String()
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			ci.handleLine(line, host)
+		}
+	}
+}
+
+func (ci *CarbonInput) Run(ir InputRunner, h PluginHelper) error {
+	ci.ir = ir
+	if ci.listener != nil {
+		ci.runTCP()
+	} else {
+		ci.runUDP()
+	}
+	ci.wg.Wait()
+	return nil
+}
+
+func (ci *CarbonInput) Stop() {
+	close(ci.stopChan)
+	if ci.listener != nil {
+		ci.listener.Close()
+	} else {
+		ci.udpConn.Close()
+	}
+}
+
+func (ci *CarbonInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "MetricCount",
+		atomic.LoadInt64(&ci.metricCount), "count")
+	message.NewInt64Field(msg, "InvalidCount",
+		atomic.LoadInt64(&ci.invalidCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("CarbonInput", func() interface{} {
+		return new(CarbonInput)
+	})
+}
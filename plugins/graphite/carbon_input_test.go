@@ -0,0 +1,118 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package graphite
+
+import (
+	"net"
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	. "github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CarbonInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A CarbonInput", func() {
+		input := new(CarbonInput)
+		config := input.ConfigStruct().(*CarbonInputConfig)
+		config.Address = "127.0.0.1:0"
+
+		ir := NewMockInputRunner(ctrl)
+		h := NewMockPluginHelper(ctrl)
+		inChan := make(chan *PipelinePack, 2)
+		inChan <- NewPipelinePack(pConfig.InputRecycleChan())
+		inChan <- NewPipelinePack(pConfig.InputRecycleChan())
+		ir.EXPECT().InChan().Return(inChan).AnyTimes()
+		ir.EXPECT().Name().Return("carbon").AnyTimes()
+		delivered := make(chan *PipelinePack, 2)
+		ir.EXPECT().Deliver(gomock.Any()).Do(func(pack *PipelinePack) {
+			delivered <- pack
+		}).AnyTimes()
+		ir.EXPECT().LogError(gomock.Any()).AnyTimes()
+
+		checkMetrics := func() {
+			pack := <-delivered
+			c.Expect(pack.Message.GetType(), gs.Equals, "heka.statmetric")
+			c.Expect(pack.Message.GetLogger(), gs.Equals, "carbon")
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "127.0.0.1")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "servers.web1.load 1.5 1458000000\n")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1458000000)*1e9)
+			name, _ := pack.Message.GetFieldValue("Name")
+			c.Expect(name, gs.Equals, "servers.web1.load")
+			value, _ := pack.Message.GetFieldValue("Value")
+			c.Expect(value, gs.Equals, 1.5)
+
+			pack = <-delivered
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "servers.web2.load 2 1458000001\n")
+			c.Expect(input.invalidCount, gs.Equals, int64(1))
+		}
+		data := "servers.web1.load 1.5 1458000000\nbogus\nservers.web2.load 2 1458000001\n"
+
+		c.Specify("rejects unknown networks", func() {
+			config.Net = "unix"
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("accepts metrics over TCP", func() {
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(ir, h)
+			}()
+
+			conn, err := net.Dial("tcp", input.listener.Addr().String())
+			c.Assume(err, gs.IsNil)
+			_, err = conn.Write([]byte(data))
+			c.Expect(err, gs.IsNil)
+			checkMetrics()
+
+			// Stopping closes connections that are still open.
+			input.Stop()
+			c.Expect(<-errChan, gs.IsNil)
+			conn.Close()
+		})
+
+		c.Specify("accepts metrics over UDP", func() {
+			config.Net = "udp"
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(ir, h)
+			}()
+
+			conn, err := net.Dial("udp", input.udpConn.LocalAddr().String())
+			c.Assume(err, gs.IsNil)
+			_, err = conn.Write([]byte(data))
+			c.Expect(err, gs.IsNil)
+			checkMetrics()
+			conn.Close()
+
+			input.Stop()
+			select {
+			case err = <-errChan:
+				c.Expect(err, gs.IsNil)
+			case <-time.After(time.Second):
+				c.Expect("CarbonInput stopped", gs.Equals, "CarbonInput hung")
+			}
+		})
+	})
+}
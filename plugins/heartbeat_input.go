@@ -0,0 +1,124 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type HeartbeatInputConfig struct {
+	// Type of the heartbeat messages. Defaults to "heka.heartbeat".
+	MessageType string `toml:"message_type"`
+	// Optional payload for the heartbeat messages.
+	Payload string
+	// Interval in seconds between heartbeats. Defaults to 10.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// HeartbeatInput periodically delivers a message with a well-known type and
+// an increasing sequence number. A filter or alert matching the heartbeats
+// at the far end of a pipeline can then detect stalls and message loss
+// anywhere along the way, not just a dead process.
+type HeartbeatInput struct {
+	sequence int64
+
+	conf     *HeartbeatInputConfig
+	name     string
+	hostname string
+	// Random identifier that changes each time the input starts, so that a
+	// sequence number reset can be told apart from lost heartbeats.
+	runId    string
+	stopChan chan bool
+}
+
+func (hb *HeartbeatInput) SetName(name string) {
+	hb.name = name
+}
+
+func (hb *HeartbeatInput) ConfigStruct() interface{} {
+	return &HeartbeatInputConfig{
+		MessageType:    "heka.heartbeat",
+		TickerInterval: 10,
+	}
+}
+
+func (hb *HeartbeatInput) Init(config interface{}) error {
+	hb.conf = config.(*HeartbeatInputConfig)
+	if hb.conf.MessageType == "" {
+		return errors.New("HeartbeatInput: `message_type` can't be empty")
+	}
+	if hb.conf.TickerInterval == 0 {
+		return errors.New("HeartbeatInput: `ticker_interval` must be greater than 0")
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	hb.runId = hex.EncodeToString(id)
+	hb.stopChan = make(chan bool)
+	return nil
+}
+
+// Populates the message for the next heartbeat.
+func (hb *HeartbeatInput) heartbeat(msg *message.Message, now time.Time) {
+	seq := atomic.AddInt64(&hb.sequence, 1)
+	msg.SetType(hb.conf.MessageType)
+	msg.SetLogger(hb.name)
+	msg.SetHostname(hb.hostname)
+	msg.SetTimestamp(now.UnixNano())
+	msg.SetPayload(hb.conf.Payload)
+	message.NewInt64Field(msg, "Sequence", seq, "count")
+	message.NewStringField(msg, "RunId", hb.runId)
+	message.NewIntField(msg, "Interval", int(hb.conf.TickerInterval), "s")
+}
+
+func (hb *HeartbeatInput) Run(ir InputRunner, h PluginHelper) error {
+	hb.hostname = h.Hostname()
+	ticker := ir.Ticker()
+	for {
+		select {
+		case <-ticker:
+		case <-hb.stopChan:
+			return nil
+		}
+		var pack *PipelinePack
+		select {
+		case pack = <-ir.InChan():
+		case <-hb.stopChan:
+			return nil
+		}
+		hb.heartbeat(pack.Message, time.Now())
+		ir.Deliver(pack)
+	}
+}
+
+func (hb *HeartbeatInput) Stop() {
+	close(hb.stopChan)
+}
+
+func (hb *HeartbeatInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Sequence", atomic.LoadInt64(&hb.sequence), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("HeartbeatInput", func() interface{} {
+		return new(HeartbeatInput)
+	})
+}
@@ -0,0 +1,82 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func HeartbeatInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A HeartbeatInput", func() {
+		input := new(HeartbeatInput)
+		input.SetName("heartbeat")
+		config := input.ConfigStruct().(*HeartbeatInputConfig)
+
+		c.Specify("requires a ticker_interval", func() {
+			config.TickerInterval = 0
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("delivers numbered heartbeats on each tick", func() {
+			config.Payload = "alive"
+			c.Assume(input.Init(config), gs.IsNil)
+
+			ir := pipelinemock.NewMockInputRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			tickChan := make(chan time.Time)
+			recycleChan := make(chan *PipelinePack, 2)
+			recycleChan <- NewPipelinePack(recycleChan)
+			recycleChan <- NewPipelinePack(recycleChan)
+			delivered := make(chan *PipelinePack, 2)
+
+			h.EXPECT().Hostname().Return("box")
+			ir.EXPECT().Ticker().Return((<-chan time.Time)(tickChan))
+			ir.EXPECT().InChan().Return(recycleChan).Times(2)
+			ir.EXPECT().Deliver(gomock.Any()).Times(2).Do(func(pack *PipelinePack) {
+				delivered <- pack
+			})
+
+			done := make(chan error)
+			go func() {
+				done <- input.Run(ir, h)
+			}()
+			tickChan <- time.Now()
+			tickChan <- time.Now()
+			first, second := <-delivered, <-delivered
+			input.Stop()
+			c.Expect(<-done, gs.IsNil)
+
+			c.Expect(first.Message.GetType(), gs.Equals, "heka.heartbeat")
+			c.Expect(first.Message.GetLogger(), gs.Equals, "heartbeat")
+			c.Expect(first.Message.GetHostname(), gs.Equals, "box")
+			c.Expect(first.Message.GetPayload(), gs.Equals, "alive")
+			seq, _ := first.Message.GetFieldValue("Sequence")
+			c.Expect(seq, gs.Equals, int64(1))
+			seq, _ = second.Message.GetFieldValue("Sequence")
+			c.Expect(seq, gs.Equals, int64(2))
+			runId, _ := first.Message.GetFieldValue("RunId")
+			c.Expect(len(runId.(string)), gs.Equals, 16)
+			secondRunId, _ := second.Message.GetFieldValue("RunId")
+			c.Expect(secondRunId, gs.Equals, runId)
+		})
+	})
+}
@@ -49,6 +49,7 @@ type HttpInput struct {
 	hostname        string
 	packSupply      chan *PipelinePack
 	customUserAgent bool
+	client          *http.Client
 }
 
 // Http Input config struct
@@ -64,10 +65,13 @@ type HttpInputConfig struct {
 	// Request body for POST
 	Body string
 	// Username and password for Basic Authentication
-	Username     string
+	Username string
 	Password string
 	// Default interval at which http.Get will execute. Default is 10 seconds.
 	TickerInterval uint `toml:"ticker_interval"`
+	// Time in milliseconds to wait for a response, including reading the
+	// body. Default is 0 (no timeout).
+	HttpTimeout uint32 `toml:"http_timeout"`
 	// Severity level of successful requests. Default is 6 (information)
 	SuccessSeverity int32 `toml:"success_severity"`
 	// Severity level of errors and unsuccessful requests. Default is 1 (alert)
@@ -98,6 +102,10 @@ func (hi *HttpInput) Init(config interface{}) error {
 		hi.urls = []string{hi.conf.Url}
 	}
 	hi.stopChan = make(chan bool)
+	hi.client = new(http.Client)
+	if hi.conf.HttpTimeout > 0 {
+		hi.client.Timeout = time.Duration(hi.conf.HttpTimeout) * time.Millisecond
+	}
 
 	// Check to see if a custom user-agent is in use.
 	h := make(http.Header)
@@ -142,7 +150,6 @@ func (hi *HttpInput) makePackDecorator(respData ResponseData) func(*PipelinePack
 
 func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
 	responseTimeStart := time.Now()
-	httpClient := &http.Client{}
 	req, err := http.NewRequest(hi.conf.Method, url, strings.NewReader(hi.conf.Body))
 	if err != nil {
 		hi.ir.LogError(fmt.Errorf("can't create HTTP request for %s: %s", url, err.Error()))
@@ -159,7 +166,7 @@ func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
 	if !hi.customUserAgent {
 		req.Header.Add("User-Agent", "Heka")
 	}
-	resp, err := httpClient.Do(req)
+	resp, err := hi.client.Do(req)
 	responseTime := time.Since(responseTimeStart)
 	if err != nil {
 		pack := <-hi.ir.InChan()
@@ -169,6 +176,7 @@ func (hi *HttpInput) fetchUrl(url string, sRunner SplitterRunner) {
 		pack.Message.SetPayload(err.Error())
 		pack.Message.SetSeverity(hi.conf.ErrorSeverity)
 		pack.Message.SetLogger(url)
+		hi.addField(pack, "ResponseTime", responseTime.Seconds(), "s")
 		hi.ir.Deliver(pack)
 		return
 	}
@@ -17,6 +17,8 @@ package http
 
 import (
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"time"
 
 	. "github.com/mozilla-services/heka/pipeline"
@@ -197,6 +199,38 @@ func HttpInputSpec(c gs.Context) {
 			c.Expect(string(respBody), gs.Equals, json_post)
 		})
 
+		c.Specify("emits an error message when the request times out", func() {
+			server := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(200 * time.Millisecond)
+				}))
+			defer server.Close()
+
+			config.Url = server.URL
+			config.HttpTimeout = 50
+			err := httpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			inChan := make(chan *PipelinePack, 1)
+			inChan <- ith.Pack
+			ith.MockInputRunner.EXPECT().InChan().Return(inChan)
+			deliverChan := make(chan *PipelinePack, 1)
+			ith.MockInputRunner.EXPECT().Deliver(ith.Pack).Do(func(pack *PipelinePack) {
+				deliverChan <- pack
+			})
+
+			startInput()
+			tickChan <- time.Now()
+
+			pack := <-deliverChan
+			c.Expect(pack.Message.GetType(), gs.Equals, "heka.httpinput.error")
+			c.Expect(pack.Message.GetSeverity(), gs.Equals, config.ErrorSeverity)
+			c.Expect(pack.Message.GetLogger(), gs.Equals, server.URL)
+			respTime, ok := pack.Message.GetFieldValue("ResponseTime")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(respTime.(float64) < 0.2, gs.IsTrue)
+		})
+
 		httpInput.Stop()
 		runOutput := <-runOutputChan
 		c.Expect(runOutput, gs.IsNil)
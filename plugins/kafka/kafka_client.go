@@ -0,0 +1,140 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins/tcp"
+)
+
+type KafkaClientConfig struct {
+	// Client Config
+	Id                         string
+	Addrs                      []string
+	MetadataRetries            int    `toml:"metadata_retries"`
+	WaitForElection            uint32 `toml:"wait_for_election"`
+	BackgroundRefreshFrequency uint32 `toml:"background_refresh_frequency"`
+
+	// TLS Config
+	UseTls      bool `toml:"use_tls"`
+	Tls         tcp.TlsConfig
+	TlsResource string `toml:"tls_resource"`
+
+	// Broker Config
+	MaxOpenRequests int    `toml:"max_open_requests"`
+	DialTimeout     uint32 `toml:"dial_timeout"`
+	ReadTimeout     uint32 `toml:"read_timeout"`
+	WriteTimeout    uint32 `toml:"write_timeout"`
+}
+
+// Shared Kafka cluster connection, defined once in the `[resources]` config
+// section with a type of "KafkaClient" and referenced by name from the Kafka
+// plugins' `client_resource` setting. It holds the client, TLS and broker
+// settings and a single client used for cluster metadata. Producers and
+// consumers still open their own broker connections, since their settings
+// are specific to each plugin.
+type KafkaClient struct {
+	config       *KafkaClientConfig
+	saramaConfig *sarama.Config
+	client       sarama.Client
+	pConfig      *pipeline.PipelineConfig
+}
+
+func (k *KafkaClient) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	k.pConfig = pConfig
+}
+
+func (k *KafkaClient) ConfigStruct() interface{} {
+	return &KafkaClientConfig{
+		Id:                         k.pConfig.Hostname(),
+		MetadataRetries:            3,
+		WaitForElection:            250,
+		BackgroundRefreshFrequency: 10 * 60 * 1000,
+		MaxOpenRequests:            4,
+		DialTimeout:                60 * 1000,
+		ReadTimeout:                60 * 1000,
+		WriteTimeout:               60 * 1000,
+	}
+}
+
+func (k *KafkaClient) Init(config interface{}) (err error) {
+	k.config = config.(*KafkaClientConfig)
+	if len(k.config.Addrs) == 0 {
+		return errors.New("addrs must have at least one entry")
+	}
+	if k.config.TlsResource != "" {
+		var tlsConf *tcp.TlsConfig
+		if tlsConf, err = tcp.TlsResourceConfig(k.pConfig, k.config.TlsResource); err != nil {
+			return err
+		}
+		k.config.Tls = *tlsConf
+		k.config.UseTls = true
+	}
+
+	k.saramaConfig = sarama.NewConfig()
+	if err = k.apply(k.saramaConfig); err != nil {
+		return err
+	}
+	k.client, err = sarama.NewClient(k.config.Addrs, k.saramaConfig)
+	return err
+}
+
+// Copies the client, TLS and broker settings to a plugin's sarama config.
+func (k *KafkaClient) apply(saramaConfig *sarama.Config) (err error) {
+	saramaConfig.ClientID = k.config.Id
+	saramaConfig.Metadata.Retry.Max = k.config.MetadataRetries
+	saramaConfig.Metadata.Retry.Backoff = time.Duration(k.config.WaitForElection) * time.Millisecond
+	saramaConfig.Metadata.RefreshFrequency = time.Duration(k.config.BackgroundRefreshFrequency) * time.Millisecond
+
+	saramaConfig.Net.TLS.Enable = k.config.UseTls
+	if k.config.UseTls {
+		if saramaConfig.Net.TLS.Config, err = tcp.CreateGoTlsConfig(&k.config.Tls); err != nil {
+			return fmt.Errorf("TLS init error: %s", err)
+		}
+	}
+
+	saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
+	saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
+	saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
+	saramaConfig.Net.WriteTimeout = time.Duration(k.config.WriteTimeout) * time.Millisecond
+	return nil
+}
+
+func (k *KafkaClient) Stop() {
+	k.client.Close()
+}
+
+// Returns the named KafkaClient resource.
+func kafkaClientResource(pConfig *pipeline.PipelineConfig, name string) (*KafkaClient,
+	error) {
+
+	resource, err := pConfig.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	client, ok := resource.(*KafkaClient)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a KafkaClient resource", name)
+	}
+	return client, nil
+}
+
+func init() {
+	pipeline.RegisterResource("KafkaClient", func() interface{} {
+		return new(KafkaClient)
+	})
+}
@@ -34,6 +34,10 @@ import (
 type KafkaInputConfig struct {
 	Splitter string
 
+	// Name of a shared KafkaClient resource to use instead of the client,
+	// TLS and broker settings below.
+	ClientResource string `toml:"client_resource"`
+
 	// Client Config
 	Id                         string
 	Addrs                      []string
@@ -139,6 +143,14 @@ func (k *KafkaInput) SetName(name string) {
 
 func (k *KafkaInput) Init(config interface{}) (err error) {
 	k.config = config.(*KafkaInputConfig)
+	var clientResource *KafkaClient
+	if k.config.ClientResource != "" {
+		if clientResource, err = kafkaClientResource(k.pConfig,
+			k.config.ClientResource); err != nil {
+			return err
+		}
+		k.config.Addrs = clientResource.config.Addrs
+	}
 	if len(k.config.Addrs) == 0 {
 		return errors.New("addrs must have at least one entry")
 	}
@@ -147,22 +159,28 @@ func (k *KafkaInput) Init(config interface{}) (err error) {
 	}
 
 	k.saramaConfig = sarama.NewConfig()
-	k.saramaConfig.ClientID = k.config.Id
-	k.saramaConfig.Metadata.Retry.Max = k.config.MetadataRetries
-	k.saramaConfig.Metadata.Retry.Backoff = time.Duration(k.config.WaitForElection) * time.Millisecond
-	k.saramaConfig.Metadata.RefreshFrequency = time.Duration(k.config.BackgroundRefreshFrequency) * time.Millisecond
-
-	k.saramaConfig.Net.TLS.Enable = k.config.UseTls
-	if k.config.UseTls {
-		if k.saramaConfig.Net.TLS.Config, err = tcp.CreateGoTlsConfig(&k.config.Tls); err != nil {
-			return fmt.Errorf("TLS init error: %s", err)
+	if clientResource != nil {
+		if err = clientResource.apply(k.saramaConfig); err != nil {
+			return err
+		}
+	} else {
+		k.saramaConfig.ClientID = k.config.Id
+		k.saramaConfig.Metadata.Retry.Max = k.config.MetadataRetries
+		k.saramaConfig.Metadata.Retry.Backoff = time.Duration(k.config.WaitForElection) * time.Millisecond
+		k.saramaConfig.Metadata.RefreshFrequency = time.Duration(k.config.BackgroundRefreshFrequency) * time.Millisecond
+
+		k.saramaConfig.Net.TLS.Enable = k.config.UseTls
+		if k.config.UseTls {
+			if k.saramaConfig.Net.TLS.Config, err = tcp.CreateGoTlsConfig(&k.config.Tls); err != nil {
+				return fmt.Errorf("TLS init error: %s", err)
+			}
 		}
-	}
 
-	k.saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
-	k.saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
-	k.saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
-	k.saramaConfig.Net.WriteTimeout = time.Duration(k.config.WriteTimeout) * time.Millisecond
+		k.saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
+		k.saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
+		k.saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
+		k.saramaConfig.Net.WriteTimeout = time.Duration(k.config.WriteTimeout) * time.Millisecond
+	}
 
 	k.saramaConfig.Consumer.Fetch.Default = k.config.DefaultFetchSize
 	k.saramaConfig.Consumer.Fetch.Min = k.config.MinFetchSize
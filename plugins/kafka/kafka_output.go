@@ -32,6 +32,10 @@ import (
 )
 
 type KafkaOutputConfig struct {
+	// Name of a shared KafkaClient resource to use instead of the client,
+	// TLS and broker settings below.
+	ClientResource string `toml:"client_resource"`
+
 	// Client Config
 	Id                         string
 	Addrs                      []string
@@ -87,6 +91,7 @@ type KafkaOutput struct {
 	config         *KafkaOutputConfig
 	saramaConfig   *sarama.Config
 	client         sarama.Client
+	sharedClient   bool
 	producer       sarama.AsyncProducer
 	pipelineConfig *pipeline.PipelineConfig
 }
@@ -207,6 +212,14 @@ func (k *KafkaOutput) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
 
 func (k *KafkaOutput) Init(config interface{}) (err error) {
 	k.config = config.(*KafkaOutputConfig)
+	var clientResource *KafkaClient
+	if k.config.ClientResource != "" {
+		if clientResource, err = kafkaClientResource(k.pipelineConfig,
+			k.config.ClientResource); err != nil {
+			return err
+		}
+		k.config.Addrs = clientResource.config.Addrs
+	}
 	if len(k.config.Addrs) == 0 {
 		return errors.New("addrs must have at least one entry")
 	}
@@ -216,22 +229,28 @@ func (k *KafkaOutput) Init(config interface{}) (err error) {
 	}
 
 	k.saramaConfig = sarama.NewConfig()
-	k.saramaConfig.ClientID = k.config.Id
-	k.saramaConfig.Metadata.Retry.Max = k.config.MetadataRetries
-	k.saramaConfig.Metadata.Retry.Backoff = time.Duration(k.config.WaitForElection) * time.Millisecond
-	k.saramaConfig.Metadata.RefreshFrequency = time.Duration(k.config.BackgroundRefreshFrequency) * time.Millisecond
-
-	k.saramaConfig.Net.TLS.Enable = k.config.UseTls
-	if k.config.UseTls {
-		if k.saramaConfig.Net.TLS.Config, err = tcp.CreateGoTlsConfig(&k.config.Tls); err != nil {
-			return fmt.Errorf("TLS init error: %s", err)
+	if clientResource != nil {
+		if err = clientResource.apply(k.saramaConfig); err != nil {
+			return err
+		}
+	} else {
+		k.saramaConfig.ClientID = k.config.Id
+		k.saramaConfig.Metadata.Retry.Max = k.config.MetadataRetries
+		k.saramaConfig.Metadata.Retry.Backoff = time.Duration(k.config.WaitForElection) * time.Millisecond
+		k.saramaConfig.Metadata.RefreshFrequency = time.Duration(k.config.BackgroundRefreshFrequency) * time.Millisecond
+
+		k.saramaConfig.Net.TLS.Enable = k.config.UseTls
+		if k.config.UseTls {
+			if k.saramaConfig.Net.TLS.Config, err = tcp.CreateGoTlsConfig(&k.config.Tls); err != nil {
+				return fmt.Errorf("TLS init error: %s", err)
+			}
 		}
-	}
 
-	k.saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
-	k.saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
-	k.saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
-	k.saramaConfig.Net.WriteTimeout = time.Duration(k.config.WriteTimeout) * time.Millisecond
+		k.saramaConfig.Net.MaxOpenRequests = k.config.MaxOpenRequests
+		k.saramaConfig.Net.DialTimeout = time.Duration(k.config.DialTimeout) * time.Millisecond
+		k.saramaConfig.Net.ReadTimeout = time.Duration(k.config.ReadTimeout) * time.Millisecond
+		k.saramaConfig.Net.WriteTimeout = time.Duration(k.config.WriteTimeout) * time.Millisecond
+	}
 
 	k.saramaConfig.Producer.MaxMessageBytes = int(k.config.MaxMessageBytes)
 	switch k.config.Partitioner {
@@ -289,8 +308,10 @@ func (k *KafkaOutput) Init(config interface{}) (err error) {
 	k.saramaConfig.Producer.Flush.Bytes = int(k.config.MaxBufferedBytes)
 	k.saramaConfig.Producer.Flush.Frequency = time.Duration(k.config.MaxBufferTime) * time.Millisecond
 
-	k.client, err = sarama.NewClient(k.config.Addrs, k.saramaConfig)
-	if err != nil {
+	if clientResource != nil {
+		k.client = clientResource.client
+		k.sharedClient = true
+	} else if k.client, err = sarama.NewClient(k.config.Addrs, k.saramaConfig); err != nil {
 		return err
 	}
 	k.producer, err = sarama.NewAsyncProducer(k.config.Addrs, k.saramaConfig)
@@ -334,7 +355,9 @@ func (k *KafkaOutput) processKafkaErrors(or pipeline.OutputRunner, errChan <-cha
 func (k *KafkaOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err error) {
 	defer func() {
 		k.producer.Close()
-		k.client.Close()
+		if !k.sharedClient {
+			k.client.Close()
+		}
 	}()
 
 	if or.Encoder() == nil {
@@ -124,6 +124,20 @@ func TestEmptyAddress(t *testing.T) {
 	}
 }
 
+func TestUnknownClientResource(t *testing.T) {
+	pConfig := NewPipelineConfig(nil)
+	ko := new(KafkaOutput)
+	ko.SetPipelineConfig(pConfig)
+	config := ko.ConfigStruct().(*KafkaOutputConfig)
+	config.ClientResource = "missing"
+	err := ko.Init(config)
+
+	errmsg := "unknown resource 'missing'"
+	if err == nil || err.Error() != errmsg {
+		t.Errorf("Expected: %s, received: %v", errmsg, err)
+	}
+}
+
 func TestInvalidPartitioner(t *testing.T) {
 	pConfig := NewPipelineConfig(nil)
 	ko := new(KafkaOutput)
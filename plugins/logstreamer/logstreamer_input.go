@@ -21,6 +21,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,6 +39,10 @@ type LogstreamerInputConfig struct {
 	JournalDirectory string `toml:"journal_directory"`
 	// File match for regular expression
 	FileMatch string `toml:"file_match"`
+	// Glob patterns or plain paths, relative to the log directory, as an
+	// alternative to `file_match`. Every matching file becomes its own
+	// logstream and its path is recorded on each message.
+	FileGlobs []string `toml:"file_globs"`
 	// Priority to sort in
 	Priority []string
 	// Differentiator for splitting out logstreams if applicable
@@ -72,6 +77,7 @@ type LogstreamerInput struct {
 	delimiterLocation  string
 	hostName           string
 	pluginName         string
+	recordPath         bool
 }
 
 // Heka will call this before calling any other methods to give us access to
@@ -110,8 +116,28 @@ func (li *LogstreamerInput) Init(config interface{}) (err error) {
 		return err
 	}
 
+	if len(conf.FileGlobs) > 0 {
+		if conf.FileMatch != "" {
+			return errors.New("`file_match` and `file_globs` are mutually exclusive.")
+		}
+		globs := make([]string, len(conf.FileGlobs))
+		for i, glob := range conf.FileGlobs {
+			if globs[i], err = relativeGlob(conf.LogDirectory, glob); err != nil {
+				return err
+			}
+		}
+		if conf.FileMatch, err = ls.GlobsToFileMatch(globs); err != nil {
+			return fmt.Errorf("Invalid `file_globs`: %s", err)
+		}
+		// Each matched file is tailed as a separate logstream.
+		if len(conf.Differentiator) == 0 {
+			conf.Differentiator = []string{li.pluginName, "-", ls.GlobPathMatchName}
+		}
+		li.recordPath = true
+	}
+
 	if conf.FileMatch == "" {
-		return errors.New("`file_match` setting is required.")
+		return errors.New("`file_match` or `file_globs` setting is required.")
 	}
 	if len(conf.FileMatch) > 0 && conf.FileMatch[len(conf.FileMatch)-1:] != "$" {
 		conf.FileMatch += "$"
@@ -180,13 +206,35 @@ func (li *LogstreamerInput) Init(config interface{}) (err error) {
 		if !ok {
 			continue
 		}
-		li.plugins[name] = NewLogstreamInput(stream, name, li.hostName, li.checkDataInterval)
+		li.plugins[name] = li.newLogstreamInput(stream, name)
 	}
 	li.stopLogstreamChans = make([]chan chan bool, 0, len(plugins))
 	li.stopChan = make(chan bool)
 	return
 }
 
+// Converts an absolute glob into one relative to the log directory, globs that
+// are already relative are returned unchanged.
+func relativeGlob(logDir, glob string) (string, error) {
+	if !filepath.IsAbs(glob) {
+		return glob, nil
+	}
+	rel, err := filepath.Rel(logDir, glob)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("`file_globs` entry '%s' is not within log_directory '%s'",
+			glob, logDir)
+	}
+	return rel, nil
+}
+
+func (li *LogstreamerInput) newLogstreamInput(stream *ls.Logstream,
+	name string) *LogstreamInput {
+
+	lsi := NewLogstreamInput(stream, name, li.hostName, li.checkDataInterval)
+	lsi.recordPath = li.recordPath
+	return lsi
+}
+
 // Creates deliverer and stop channel and starts the provided LogstreamInput.
 func (li *LogstreamerInput) startLogstreamInput(logstream *LogstreamInput, i int,
 	ir p.InputRunner, h p.PluginHelper) {
@@ -252,7 +300,7 @@ func (li *LogstreamerInput) Run(ir p.InputRunner, h p.PluginHelper) (err error)
 					continue
 				}
 
-				lsi := NewLogstreamInput(stream, name, li.hostName, li.checkDataInterval)
+				lsi := li.newLogstreamInput(stream, name)
 				li.plugins[name] = lsi
 				i++
 				li.startLogstreamInput(lsi, i, ir, h)
@@ -280,6 +328,7 @@ type LogstreamInput struct {
 	stopChan            chan chan bool
 	deliverer           p.Deliverer
 	sRunner             p.SplitterRunner
+	recordPath          bool
 }
 
 func NewLogstreamInput(stream *ls.Logstream, loggerIdent,
@@ -398,6 +447,10 @@ func (lsi *LogstreamInput) packDecorator(pack *p.PipelinePack) {
 	pack.Message.SetType("logfile")
 	pack.Message.SetHostname(lsi.hostName)
 	pack.Message.SetLogger(lsi.loggerIdent)
+	if lsi.recordPath {
+		fname, _ := lsi.stream.ReportPosition()
+		message.NewStringField(pack.Message, "LogstreamerPath", fname)
+	}
 }
 
 func (lsi *LogstreamInput) countRecord() {
@@ -186,5 +186,38 @@ func LogstreamerInputSpec(c gs.Context) {
 						"'missing' key.")
 			})
 		})
+
+		c.Specify("with file globs", func() {
+			lsiConfig.FileMatch = ""
+			lsiConfig.Differentiator = nil
+			lsiConfig.Priority = nil
+			lsiConfig.FileGlobs = []string{"file.log*"}
+
+			c.Specify("creates a logstream per matching file", func() {
+				err := lsInput.Init(lsiConfig)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(lsInput.plugins), gs.Equals, 3)
+				c.Expect(lsInput.recordPath, gs.IsTrue)
+			})
+
+			c.Specify("accepts absolute paths under log_directory", func() {
+				lsiConfig.FileGlobs = []string{filepath.Join(dirPath, "file.log")}
+				err := lsInput.Init(lsiConfig)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(lsInput.plugins), gs.Equals, 1)
+			})
+
+			c.Specify("rejects paths outside of log_directory", func() {
+				lsiConfig.FileGlobs = []string{filepath.Join(here, "*.log")}
+				err := lsInput.Init(lsiConfig)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("can't be combined with file_match", func() {
+				lsiConfig.FileMatch = `file.log`
+				err := lsInput.Init(lsiConfig)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
 	})
 }
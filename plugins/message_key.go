@@ -0,0 +1,107 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+var keyFieldRegex = regexp.MustCompile(`^Fields\[([^\]]+)\]$`)
+
+// Extracts a string key from a message, made of the values of a list of
+// message headers (e.g. "Logger") and fields (e.g. "Fields[app]").
+type messageKey struct {
+	names []string
+	// Field name for each entry of names, empty for headers.
+	fields []string
+}
+
+func newMessageKey(names []string) (*messageKey, error) {
+	k := &messageKey{
+		names:  names,
+		fields: make([]string, len(names)),
+	}
+	for i, name := range names {
+		switch name {
+		case "Type", "Logger", "Hostname", "Payload", "EnvVersion", "Severity",
+			"Pid":
+			continue
+		}
+		matches := keyFieldRegex.FindStringSubmatch(name)
+		if matches == nil {
+			return nil, fmt.Errorf("unknown message variable '%s'", name)
+		}
+		k.fields[i] = matches[1]
+	}
+	return k, nil
+}
+
+// Returns the value of the ith variable as a string, or an empty string if
+// the message doesn't have it.
+func (k *messageKey) value(msg *message.Message, i int) string {
+	if k.fields[i] != "" {
+		field := msg.FindFirstField(k.fields[i])
+		if field == nil {
+			return ""
+		}
+		return fieldString(field)
+	}
+	switch k.names[i] {
+	case "Type":
+		return msg.GetType()
+	case "Logger":
+		return msg.GetLogger()
+	case "Hostname":
+		return msg.GetHostname()
+	case "Payload":
+		return msg.GetPayload()
+	case "EnvVersion":
+		return msg.GetEnvVersion()
+	case "Severity":
+		return strconv.Itoa(int(msg.GetSeverity()))
+	case "Pid":
+		return strconv.Itoa(int(msg.GetPid()))
+	}
+	return ""
+}
+
+// Returns a field's first value as a string.
+func fieldString(field *message.Field) string {
+	switch v := field.GetValue().(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Returns the message's key, the values of all variables joined with "|".
+func (k *messageKey) key(msg *message.Message) string {
+	switch len(k.names) {
+	case 0:
+		return ""
+	case 1:
+		return k.value(msg, 0)
+	}
+	values := make([]string, len(k.names))
+	for i := range k.names {
+		values[i] = k.value(msg, i)
+	}
+	return strings.Join(values, "|")
+}
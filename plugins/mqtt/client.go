@@ -0,0 +1,269 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// MQTT 3.1.1 control packet types.
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	protocolLevel     = 4
+	subscribePacketId = 1
+)
+
+var connackErrors = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "client identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+type packet struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// Reads a single control packet, refusing ones larger than Heka's maximum
+// record size.
+func readPacket(r *bufio.Reader) (*packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var length, shift uint32
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length |= uint32(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	if length > message.MAX_RECORD_SIZE {
+		return nil, fmt.Errorf("packet size %d exceeds maximum %d", length,
+			message.MAX_RECORD_SIZE)
+	}
+	p := &packet{typ: header >> 4, flags: header & 0x0f, body: make([]byte, length)}
+	if _, err = io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func encodePacket(typ, flags byte, body []byte) []byte {
+	buf := make([]byte, 1, len(body)+5)
+	buf[0] = typ<<4 | flags
+	length := len(body)
+	for {
+		b := byte(length & 0x7f)
+		length >>= 7
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	return append(buf, body...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint16(b, uint16(len(s))), s...)
+}
+
+func readString(b []byte) (s string, rest []byte, err error) {
+	if len(b) < 2 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
+
+type publish struct {
+	topic    string
+	qos      byte
+	retain   bool
+	packetId uint16
+	payload  []byte
+}
+
+func parsePublish(p *packet) (pub *publish, err error) {
+	pub = &publish{
+		qos:    (p.flags >> 1) & 0x03,
+		retain: p.flags&0x01 != 0,
+	}
+	var rest []byte
+	if pub.topic, rest, err = readString(p.body); err != nil {
+		return nil, fmt.Errorf("malformed publish packet: %s", err)
+	}
+	if pub.qos > 0 {
+		if len(rest) < 2 {
+			return nil, errors.New("malformed publish packet: missing packet id")
+		}
+		pub.packetId = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	pub.payload = rest
+	return pub, nil
+}
+
+type connectOptions struct {
+	clientId     string
+	username     string
+	password     string
+	cleanSession bool
+	keepAlive    time.Duration
+}
+
+// A minimal MQTT 3.1.1 subscriber connection. Writes may happen from
+// multiple goroutines, reads must only happen from one.
+type client struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	wMutex    sync.Mutex
+	keepAlive time.Duration
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) write(typ, flags byte, body []byte) error {
+	c.wMutex.Lock()
+	defer c.wMutex.Unlock()
+	_, err := c.conn.Write(encodePacket(typ, flags, body))
+	return err
+}
+
+// Reads the next packet, treating more than one and a half keep alive
+// intervals without traffic from the broker as a dead connection.
+func (c *client) read() (*packet, error) {
+	if c.keepAlive > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+	}
+	return readPacket(c.r)
+}
+
+// Sends CONNECT and waits for the broker's CONNACK.
+func (c *client) connect(opts connectOptions) error {
+	var flags byte
+	if opts.cleanSession {
+		flags |= 0x02
+	}
+	if opts.username != "" {
+		flags |= 0x80
+		if opts.password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, uint16(opts.keepAlive/time.Second))
+	body = appendString(body, opts.clientId)
+	if flags&0x80 != 0 {
+		body = appendString(body, opts.username)
+	}
+	if flags&0x40 != 0 {
+		body = appendString(body, opts.password)
+	}
+	if err := c.write(packetConnect, 0, body); err != nil {
+		return err
+	}
+
+	c.keepAlive = opts.keepAlive
+	p, err := c.read()
+	if err != nil {
+		return fmt.Errorf("reading CONNACK: %s", err)
+	}
+	if p.typ != packetConnack || len(p.body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", p.typ)
+	}
+	if code := p.body[1]; code != 0 {
+		reason, ok := connackErrors[code]
+		if !ok {
+			reason = fmt.Sprintf("return code %d", code)
+		}
+		return fmt.Errorf("connection refused: %s", reason)
+	}
+	return nil
+}
+
+// Sends SUBSCRIBE for topics. The broker's SUBACK is handled by the caller's
+// read loop, since messages may be delivered before it.
+func (c *client) subscribe(topics []string, qos byte) error {
+	body := appendUint16(nil, subscribePacketId)
+	for _, topic := range topics {
+		body = append(appendString(body, topic), qos)
+	}
+	return c.write(packetSubscribe, 0x02, body)
+}
+
+// Checks a SUBACK's return codes, one per topic.
+func checkSuback(p *packet, topics []string) error {
+	if len(p.body) != 2+len(topics) {
+		return errors.New("malformed SUBACK packet")
+	}
+	for i, code := range p.body[2:] {
+		if code == 0x80 {
+			return fmt.Errorf("subscription to %s refused", topics[i])
+		}
+	}
+	return nil
+}
+
+func (c *client) puback(packetId uint16) error {
+	return c.write(packetPuback, 0, appendUint16(nil, packetId))
+}
+
+func (c *client) ping() error {
+	return c.write(packetPingreq, 0, nil)
+}
+
+func (c *client) disconnect() error {
+	c.write(packetDisconnect, 0, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,240 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package mqtt
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	"github.com/mozilla-services/heka/plugins/tcp"
+)
+
+type MqttInputConfig struct {
+	// Address of the broker, e.g. "127.0.0.1:1883".
+	Address string
+	// Topic filters to subscribe to, e.g. "sensors/+/log".
+	Topics []string
+	// Quality of service to subscribe with, 0 (at most once) or 1 (at least
+	// once). Defaults to 0.
+	Qos int
+	// Client identifier. Defaults to "heka-" followed by the hostname.
+	ClientId string `toml:"client_id"`
+	Username string
+	Password string
+	// Whether the broker should discard the session when we disconnect.
+	// Set to false with QoS 1 to receive messages published while
+	// reconnecting. Defaults to true.
+	CleanSession bool `toml:"clean_session"`
+	// Seconds between keep alive pings. Defaults to 60.
+	KeepAlive uint `toml:"keep_alive"`
+	// Seconds to wait for the connection to be established. Defaults to 10.
+	ConnectTimeout uint `toml:"connect_timeout"`
+	// Set to true to connect to the broker using TLS.
+	UseTls bool `toml:"use_tls"`
+	// Subsection for TLS configuration.
+	Tls tcp.TlsConfig
+}
+
+// MqttInput subscribes to topics on an MQTT broker and delivers each
+// published message's payload, using the topic as the message's Logger.
+type MqttInput struct {
+	conf      *MqttInputConfig
+	tlsConf   *tls.Config
+	client    *client
+	clientMu  sync.Mutex
+	stopped   uint32
+	topic     string
+	hostname  string
+	processed int64
+}
+
+func (m *MqttInput) ConfigStruct() interface{} {
+	return &MqttInputConfig{
+		Address:        "127.0.0.1:1883",
+		CleanSession:   true,
+		KeepAlive:      60,
+		ConnectTimeout: 10,
+	}
+}
+
+func (m *MqttInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	m.hostname = pConfig.Hostname()
+}
+
+func (m *MqttInput) Init(config interface{}) (err error) {
+	m.conf = config.(*MqttInputConfig)
+	if len(m.conf.Topics) == 0 {
+		return errors.New("at least one topic must be specified")
+	}
+	if m.conf.Qos != 0 && m.conf.Qos != 1 {
+		return fmt.Errorf("'qos' must be 0 or 1: %d", m.conf.Qos)
+	}
+	if m.conf.ClientId == "" {
+		m.conf.ClientId = "heka-" + m.hostname
+	}
+	if m.conf.Password != "" && m.conf.Username == "" {
+		return errors.New("'password' requires 'username'")
+	}
+	if m.conf.UseTls {
+		if m.tlsConf, err = tcp.CreateGoTlsConfig(&m.conf.Tls); err != nil {
+			return fmt.Errorf("TLS init error: %s", err)
+		}
+	}
+	atomic.StoreUint32(&m.stopped, 0)
+	return nil
+}
+
+func (m *MqttInput) dial() (conn net.Conn, err error) {
+	timeout := time.Duration(m.conf.ConnectTimeout) * time.Second
+	if m.tlsConf != nil {
+		dialer := &net.Dialer{Timeout: timeout}
+		return tls.DialWithDialer(dialer, "tcp", m.conf.Address, m.tlsConf)
+	}
+	return net.DialTimeout("tcp", m.conf.Address, timeout)
+}
+
+func (m *MqttInput) packDecorator(pack *PipelinePack) {
+	pack.Message.SetType("mqtt")
+	pack.Message.SetLogger(m.topic)
+}
+
+func (m *MqttInput) Run(ir InputRunner, h PluginHelper) error {
+	conn, err := m.dial()
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %s", m.conf.Address, err)
+	}
+	m.clientMu.Lock()
+	if atomic.LoadUint32(&m.stopped) == 1 {
+		m.clientMu.Unlock()
+		conn.Close()
+		return nil
+	}
+	m.client = newClient(conn)
+	m.clientMu.Unlock()
+	defer m.client.disconnect()
+
+	keepAlive := time.Duration(m.conf.KeepAlive) * time.Second
+	err = m.client.connect(connectOptions{
+		clientId:     m.conf.ClientId,
+		username:     m.conf.Username,
+		password:     m.conf.Password,
+		cleanSession: m.conf.CleanSession,
+		keepAlive:    keepAlive,
+	})
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %s", m.conf.Address, err)
+	}
+	if err = m.client.subscribe(m.conf.Topics, byte(m.conf.Qos)); err != nil {
+		return fmt.Errorf("subscribing: %s", err)
+	}
+	ir.LogMessage(fmt.Sprintf("connected to %s", m.conf.Address))
+
+	if keepAlive > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go m.pinger(keepAlive, done)
+	}
+
+	sRunner := ir.NewSplitterRunner("")
+	if !sRunner.UseMsgBytes() {
+		sRunner.SetPackDecorator(m.packDecorator)
+	}
+	defer sRunner.Done()
+
+	for {
+		p, err := m.client.read()
+		if err != nil {
+			if atomic.LoadUint32(&m.stopped) == 1 {
+				return nil
+			}
+			return fmt.Errorf("reading from %s: %s", m.conf.Address, err)
+		}
+		switch p.typ {
+		case packetPublish:
+			pub, err := parsePublish(p)
+			if err != nil {
+				return err
+			}
+			m.topic = pub.topic
+			n, err := sRunner.SplitBytes(pub.payload, nil)
+			if err != nil {
+				ir.LogError(fmt.Errorf("processing message from %s: %s", pub.topic, err))
+			}
+			if n > 0 && n != len(pub.payload) {
+				ir.LogError(fmt.Errorf("extra data in message from %s dropped", pub.topic))
+			}
+			atomic.AddInt64(&m.processed, 1)
+			// Only acknowledge once the message has been handed off, so
+			// it's redelivered if we go away before then.
+			if pub.qos == 1 {
+				if err = m.client.puback(pub.packetId); err != nil {
+					return fmt.Errorf("acknowledging message: %s", err)
+				}
+			}
+		case packetSuback:
+			if err = checkSuback(p, m.conf.Topics); err != nil {
+				return err
+			}
+		case packetPingresp:
+		default:
+			ir.LogError(fmt.Errorf("ignoring unexpected packet type %d", p.typ))
+		}
+	}
+}
+
+func (m *MqttInput) pinger(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := m.client.ping(); err != nil {
+				// The read loop will notice the broken connection.
+				return
+			}
+		}
+	}
+}
+
+// Run closes the connection on the way out, so there's nothing left to
+// clean up before reconnecting.
+func (m *MqttInput) CleanupForRestart() {}
+
+func (m *MqttInput) Stop() {
+	atomic.StoreUint32(&m.stopped, 1)
+	m.clientMu.Lock()
+	if m.client != nil {
+		m.client.conn.Close()
+	}
+	m.clientMu.Unlock()
+}
+
+func (m *MqttInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&m.processed), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("MqttInput", func() interface{} {
+		return new(MqttInput)
+	})
+}
@@ -0,0 +1,196 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func MqttInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("The MQTT codec", func() {
+		c.Specify("round trips remaining lengths", func() {
+			for _, size := range []int{0, 127, 128, 16383, 16384, 60000} {
+				body := make([]byte, size)
+				encoded := encodePacket(packetPublish, 0x02, body)
+				p, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+				c.Assume(err, gs.IsNil)
+				c.Expect(p.typ, gs.Equals, byte(packetPublish))
+				c.Expect(p.flags, gs.Equals, byte(0x02))
+				c.Expect(len(p.body), gs.Equals, size)
+			}
+		})
+
+		c.Specify("rejects oversized packets", func() {
+			encoded := []byte{packetPublish << 4, 0xff, 0xff, 0xff, 0x7f}
+			_, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("parses publish packets", func() {
+			body := appendUint16(appendString(nil, "a/b"), 7)
+			body = append(body, "payload"...)
+			pub, err := parsePublish(&packet{packetPublish, 0x03, body})
+			c.Assume(err, gs.IsNil)
+			c.Expect(pub.topic, gs.Equals, "a/b")
+			c.Expect(pub.qos, gs.Equals, byte(1))
+			c.Expect(pub.retain, gs.IsTrue)
+			c.Expect(pub.packetId, gs.Equals, uint16(7))
+			c.Expect(string(pub.payload), gs.Equals, "payload")
+
+			_, err = parsePublish(&packet{packetPublish, 0x02, body[:4]})
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A MqttInput", func() {
+		input := new(MqttInput)
+		input.SetPipelineConfig(NewPipelineConfig(nil))
+		config := input.ConfigStruct().(*MqttInputConfig)
+		config.Topics = []string{"sensors/+/log"}
+
+		c.Specify("requires topics", func() {
+			config.Topics = nil
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("doesn't support QoS 2", func() {
+			config.Qos = 2
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("delivers and acknowledges published messages", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+			config.Address = listener.Addr().String()
+			config.Qos = 1
+			config.ClientId = "edge-1"
+			config.Username = "heka"
+			config.Password = "s3cret"
+			err = input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			ir := pipelinemock.NewMockInputRunner(ctrl)
+			sr := pipelinemock.NewMockSplitterRunner(ctrl)
+			ir.EXPECT().LogMessage(gomock.Any()).AnyTimes()
+			ir.EXPECT().NewSplitterRunner("").Return(sr)
+			sr.EXPECT().UseMsgBytes().Return(false)
+			sr.EXPECT().Done()
+
+			var decorator func(*PipelinePack)
+			sr.EXPECT().SetPackDecorator(gomock.Any()).Do(func(d func(*PipelinePack)) {
+				decorator = d
+			})
+			payloads := make(chan string, 1)
+			loggers := make(chan string, 1)
+			split := sr.EXPECT().SplitBytes(gomock.Any(), nil).Return(5, nil)
+			split.Do(func(b []byte, del Deliverer) {
+				pack := NewPipelinePack(nil)
+				decorator(pack)
+				payloads <- string(b)
+				loggers <- pack.Message.GetLogger()
+			})
+
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(ir, nil)
+			}()
+
+			conn, err := listener.Accept()
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+
+			connect, err := readPacket(r)
+			c.Assume(err, gs.IsNil)
+			c.Expect(connect.typ, gs.Equals, byte(packetConnect))
+			name, rest, _ := readString(connect.body)
+			c.Expect(name, gs.Equals, "MQTT")
+			c.Expect(rest[0], gs.Equals, byte(protocolLevel))
+			c.Expect(rest[1], gs.Equals, byte(0xc2))
+			c.Expect(binary.BigEndian.Uint16(rest[2:]), gs.Equals, uint16(60))
+			clientId, rest, _ := readString(rest[4:])
+			c.Expect(clientId, gs.Equals, "edge-1")
+			username, rest, _ := readString(rest)
+			c.Expect(username, gs.Equals, "heka")
+			password, _, _ := readString(rest)
+			c.Expect(password, gs.Equals, "s3cret")
+			conn.Write(encodePacket(packetConnack, 0, []byte{0, 0}))
+
+			subscribe, err := readPacket(r)
+			c.Assume(err, gs.IsNil)
+			c.Expect(subscribe.typ, gs.Equals, byte(packetSubscribe))
+			topic, rest, _ := readString(subscribe.body[2:])
+			c.Expect(topic, gs.Equals, "sensors/+/log")
+			c.Expect(rest[0], gs.Equals, byte(1))
+			conn.Write(encodePacket(packetSuback, 0, []byte{0, 1, 1}))
+
+			body := appendUint16(appendString(nil, "sensors/7/log"), 42)
+			body = append(body, "hello"...)
+			conn.Write(encodePacket(packetPublish, 0x02, body))
+
+			c.Expect(<-payloads, gs.Equals, "hello")
+			c.Expect(<-loggers, gs.Equals, "sensors/7/log")
+			puback, err := readPacket(r)
+			c.Assume(err, gs.IsNil)
+			c.Expect(puback.typ, gs.Equals, byte(packetPuback))
+			c.Expect(binary.BigEndian.Uint16(puback.body), gs.Equals, uint16(42))
+
+			msg := new(message.Message)
+			input.ReportMsg(msg)
+			count, _ := msg.GetFieldValue("ProcessMessageCount")
+			c.Expect(count, gs.Equals, int64(1))
+
+			input.Stop()
+			c.Expect(<-errChan, gs.IsNil)
+		})
+
+		c.Specify("fails when the broker refuses the connection", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assume(err, gs.IsNil)
+			defer listener.Close()
+			config.Address = listener.Addr().String()
+			err = input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			errChan := make(chan error, 1)
+			go func() {
+				errChan <- input.Run(nil, nil)
+			}()
+			conn, err := listener.Accept()
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			_, err = readPacket(bufio.NewReader(conn))
+			c.Assume(err, gs.IsNil)
+			conn.Write(encodePacket(packetConnack, 0, []byte{0, 5}))
+
+			err = <-errChan
+			c.Assume(err, gs.Not(gs.IsNil))
+			c.Expect(err.Error(), gs.Equals, "connecting to "+config.Address+
+				": connection refused: not authorized")
+		})
+	})
+}
@@ -0,0 +1,64 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+)
+
+// Number, sum, minimum and maximum of a set of values.
+type numericStats struct {
+	values int64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+func (s *numericStats) add(value float64) {
+	if s.values == 0 || value < s.min {
+		s.min = value
+	}
+	if s.values == 0 || value > s.max {
+		s.max = value
+	}
+	s.values++
+	s.sum += value
+}
+
+// Adds the values of other to s.
+func (s *numericStats) merge(other numericStats) {
+	if other.values == 0 {
+		return
+	}
+	if s.values == 0 || other.min < s.min {
+		s.min = other.min
+	}
+	if s.values == 0 || other.max > s.max {
+		s.max = other.max
+	}
+	s.values += other.values
+	s.sum += other.sum
+}
+
+func (s *numericStats) avg() float64 {
+	return s.sum / float64(s.values)
+}
+
+// Returns the value of the first numeric field with the given name, or nil.
+func getNumericField(msg *message.Message, name string) interface{} {
+	v, _ := msg.GetFieldValue(name)
+	switch v.(type) {
+	case int64, float64:
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"regexp"
+
+	"github.com/golang/snappy"
+)
+
+const avroMagic = "Obj\x01"
+
+// Avro names must start with a letter or underscore, followed by letters,
+// digits and underscores.
+var avroNameRegex = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+
+func appendLong(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64((v<<1)^(v>>63)))
+	return append(b, buf[:n]...)
+}
+
+func appendAvroBytes(b, data []byte) []byte {
+	return append(appendLong(b, int64(len(data))), data...)
+}
+
+func appendAvroDouble(b []byte, v float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(b, buf[:]...)
+}
+
+func appendAvroBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// Returns the Avro type of a column.
+func avroType(c columnSpec) interface{} {
+	var typ interface{}
+	switch c.typ {
+	case columnString:
+		typ = "string"
+	case columnInt32:
+		typ = "int"
+	case columnInt64:
+		typ = "long"
+	case columnDouble:
+		typ = "double"
+	case columnBool:
+		typ = "boolean"
+	case columnTimestamp:
+		typ = map[string]string{"type": "long", "logicalType": "timestamp-micros"}
+	}
+	if c.required {
+		return typ
+	}
+	return []interface{}{"null", typ}
+}
+
+// Returns the JSON schema of a record with one field per column. Nullable
+// columns default to null, so readers can add and remove them as the
+// configured fields change.
+func avroSchema(columns []columnSpec) ([]byte, error) {
+	fields := make([]interface{}, len(columns))
+	for i, c := range columns {
+		field := map[string]interface{}{"name": c.name, "type": avroType(c)}
+		if !c.required {
+			field["default"] = nil
+		}
+		fields[i] = field
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      "Message",
+		"namespace": "heka",
+		"fields":    fields,
+	})
+}
+
+// Writes an object container file header.
+func writeAvroHeader(w io.Writer, schema []byte, codec string, sync []byte) error {
+	b := []byte(avroMagic)
+	b = appendLong(b, 2)
+	b = appendAvroBytes(b, []byte("avro.schema"))
+	b = appendAvroBytes(b, schema)
+	b = appendAvroBytes(b, []byte("avro.codec"))
+	b = appendAvroBytes(b, []byte(codec))
+	b = appendLong(b, 0)
+	b = append(b, sync...)
+	_, err := w.Write(b)
+	return err
+}
+
+// Compresses a block of serialized objects using one of the codecs defined
+// by the Avro specification.
+func compressAvroBlock(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case "null":
+		return data, nil
+	case "deflate":
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = fw.Write(data); err != nil {
+			return nil, err
+		}
+		if err = fw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "snappy":
+		// Raw snappy block followed by the CRC32 of the uncompressed data.
+		compressed := snappy.Encode(nil, data)
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(data))
+		return append(compressed, crc[:]...), nil
+	}
+	return nil, fmt.Errorf("unsupported Avro codec: %s", codec)
+}
+
+// Writes a data block containing count serialized objects.
+func writeAvroBlock(w io.Writer, codec string, count int64, data, sync []byte) (int, error) {
+	compressed, err := compressAvroBlock(codec, data)
+	if err != nil {
+		return 0, err
+	}
+	b := appendLong(nil, count)
+	b = appendLong(b, int64(len(compressed)))
+	b = append(b, compressed...)
+	b = append(b, sync...)
+	return w.Write(b)
+}
@@ -0,0 +1,259 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AvroDecoderConfig struct {
+	// Path of the JSON schema of the records, for payloads holding a plain
+	// binary encoded record.
+	SchemaFile string `toml:"schema_file"`
+	// URL of a Confluent style schema registry, for payloads framed with a
+	// magic byte and the id of their schema in the registry.
+	SchemaRegistry string `toml:"schema_registry"`
+	// Timeout in seconds of schema registry requests. Defaults to 5.
+	RegistryTimeout uint `toml:"registry_timeout"`
+	// Separator between the names of nested record and map fields. Defaults
+	// to ".".
+	FlattenSeparator string `toml:"flatten_separator"`
+	// Record field whose value sets the message timestamp, if any.
+	TimestampField string `toml:"timestamp_field"`
+	// Unit of numeric timestamps, one of "s", "ms", "us" or "ns". Defaults
+	// to "ms", as used by Avro's timestamp-millis logical type.
+	TimestampUnit string `toml:"timestamp_unit"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+}
+
+// Decoder for Avro binary encoded records, such as those written to Kafka by
+// Avro producers. Record fields become typed message fields, nested records
+// and maps are flattened.
+type AvroDecoder struct {
+	conf       *AvroDecoderConfig
+	schema     *avroSchemaType
+	tsScale    int64
+	client     *http.Client
+	schemasMu  sync.Mutex
+	schemasIds map[uint32]*avroSchemaType
+}
+
+// Payloads framed for a schema registry start with this byte, followed by
+// the 4 byte big endian schema id.
+const avroRegistryMagic = 0
+
+var avroTimestampScales = map[string]int64{
+	"s":  1e9,
+	"ms": 1e6,
+	"us": 1e3,
+	"ns": 1,
+}
+
+func (ad *AvroDecoder) ConfigStruct() interface{} {
+	return &AvroDecoderConfig{
+		RegistryTimeout:  5,
+		FlattenSeparator: ".",
+		TimestampUnit:    "ms",
+	}
+}
+
+func (ad *AvroDecoder) Init(config interface{}) (err error) {
+	ad.conf = config.(*AvroDecoderConfig)
+	switch {
+	case ad.conf.SchemaFile != "" && ad.conf.SchemaRegistry != "":
+		return errors.New("AvroDecoder: only one of `schema_file` and `schema_registry` can be set")
+	case ad.conf.SchemaFile != "":
+		data, err := ioutil.ReadFile(ad.conf.SchemaFile)
+		if err != nil {
+			return fmt.Errorf("AvroDecoder: %s", err)
+		}
+		if ad.schema, err = parseAvroSchema(data); err != nil {
+			return fmt.Errorf("AvroDecoder: %s: %s", ad.conf.SchemaFile, err)
+		}
+	case ad.conf.SchemaRegistry != "":
+		ad.client = &http.Client{
+			Timeout: time.Duration(ad.conf.RegistryTimeout) * time.Second,
+		}
+		ad.schemasIds = make(map[uint32]*avroSchemaType)
+	default:
+		return errors.New("AvroDecoder: either `schema_file` or `schema_registry` must be set")
+	}
+	var ok bool
+	if ad.tsScale, ok = avroTimestampScales[ad.conf.TimestampUnit]; !ok {
+		return fmt.Errorf("AvroDecoder: unknown timestamp_unit '%s'", ad.conf.TimestampUnit)
+	}
+	return nil
+}
+
+// Returns the registry schema with the given id, fetching it the first time
+// it's used.
+func (ad *AvroDecoder) registrySchema(id uint32) (*avroSchemaType, error) {
+	ad.schemasMu.Lock()
+	defer ad.schemasMu.Unlock()
+	if schema, ok := ad.schemasIds[id]; ok {
+		return schema, nil
+	}
+	url := fmt.Sprintf("%s/schemas/ids/%d", strings.TrimRight(ad.conf.SchemaRegistry, "/"), id)
+	resp, err := ad.client.Get(url)
+	if err != nil {
+		return nil, NewTransientError("fetching schema %d: %s", id, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("unknown schema id %d", id)
+	case resp.StatusCode != http.StatusOK:
+		return nil, NewTransientError("fetching schema %d: %s", id, resp.Status)
+	}
+	var body struct {
+		Schema string
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, NewTransientError("fetching schema %d: %s", id, err)
+	}
+	schema, err := parseAvroSchema([]byte(body.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %s", id, err)
+	}
+	ad.schemasIds[id] = schema
+	return schema, nil
+}
+
+func (ad *AvroDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	data := []byte(pack.Message.GetPayload())
+	schema := ad.schema
+	if schema == nil {
+		if len(data) < 5 || data[0] != avroRegistryMagic {
+			return nil, errors.New("payload isn't framed with a schema id")
+		}
+		if schema, err = ad.registrySchema(binary.BigEndian.Uint32(data[1:5])); err != nil {
+			return nil, err
+		}
+		data = data[5:]
+	}
+	reader := &avroBinaryReader{data: data}
+	v, err := reader.read(schema)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Avro data: %s", err)
+	}
+	if len(reader.data) > 0 {
+		return nil, fmt.Errorf("Invalid Avro data: %d bytes left over", len(reader.data))
+	}
+
+	msg := pack.Message
+	msg.SetPayload("")
+	if rec, ok := v.(*avroRecordValue); ok {
+		if err = ad.addFields(msg, "", rec); err != nil {
+			return nil, err
+		}
+	} else if err = ad.addField(msg, "value", v); err != nil {
+		return nil, err
+	}
+	if ad.conf.MessageType != "" {
+		msg.SetType(ad.conf.MessageType)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Adds the record's fields to the message, named prefix + field name.
+func (ad *AvroDecoder) addFields(msg *message.Message, prefix string,
+	rec *avroRecordValue) error {
+
+	for i, f := range rec.typ.fields {
+		name := prefix + f.name
+		if prefix == "" && name == ad.conf.TimestampField {
+			if err := ad.setTimestamp(msg, rec.values[i]); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			continue
+		}
+		if err := ad.addField(msg, name, rec.values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ad *AvroDecoder) addField(msg *message.Message, name string, v interface{}) error {
+	var val interface{}
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case *avroRecordValue:
+		return ad.addFields(msg, name+ad.conf.FlattenSeparator, value)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := ad.addField(msg, name+ad.conf.FlattenSeparator+k,
+				value[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		data, err := json.Marshal(avroJSONValue(value))
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		val = string(data)
+	default:
+		val = value
+	}
+	f, err := message.NewField(name, val, "")
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+func (ad *AvroDecoder) setTimestamp(msg *message.Message, v interface{}) error {
+	switch t := v.(type) {
+	case int64:
+		msg.SetTimestamp(t * ad.tsScale)
+	case float64:
+		msg.SetTimestamp(int64(t * float64(ad.tsScale)))
+	case string:
+		ts, err := message.ForgivingTimeParse(time.RFC3339Nano, t, time.UTC)
+		if err != nil {
+			return err
+		}
+		msg.SetTimestamp(ts.UnixNano())
+	case nil:
+	default:
+		return errors.New("not a timestamp")
+	}
+	return nil
+}
+
+func init() {
+	RegisterPlugin("AvroDecoder", func() interface{} {
+		return new(AvroDecoder)
+	})
+}
@@ -0,0 +1,166 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "Event",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "host", "type": "string"},
+		{"name": "latency", "type": ["null", "double"]},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "request", "type": {
+			"type": "record",
+			"name": "Request",
+			"fields": [
+				{"name": "method", "type": {"type": "enum", "name": "Method", "symbols": ["GET", "POST"]}},
+				{"name": "ok", "type": "boolean"}
+			]
+		}}
+	]
+}`
+
+func testAvroRecord() []byte {
+	var b []byte
+	b = appendLong(b, 1456833600000)
+	b = appendAvroBytes(b, []byte("web1"))
+	b = appendLong(b, 1)
+	b = appendAvroDouble(b, 0.25)
+	b = appendLong(b, 2)
+	b = appendAvroBytes(b, []byte("a"))
+	b = appendAvroBytes(b, []byte("b"))
+	b = appendLong(b, 0)
+	b = appendLong(b, 1)
+	b = appendAvroBool(b, true)
+	return b
+}
+
+func AvroDecoderSpec(c gs.Context) {
+	tmpDir, err := ioutil.TempDir("", "avro-decoder-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	decoder := new(AvroDecoder)
+	conf := decoder.ConfigStruct().(*AvroDecoderConfig)
+	pack := NewPipelinePack(make(chan *PipelinePack, 1))
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	expectFields := func() {
+		c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+		c.Expect(field("host"), gs.Equals, "web1")
+		c.Expect(field("latency"), gs.Equals, 0.25)
+		c.Expect(field("tags"), gs.Equals, `["a","b"]`)
+		c.Expect(field("request.method"), gs.Equals, "POST")
+		c.Expect(field("request.ok"), gs.Equals, true)
+	}
+
+	c.Specify("An AvroDecoder", func() {
+		c.Specify("requires a schema source", func() {
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with a schema file", func() {
+			conf.SchemaFile = filepath.Join(tmpDir, "event.avsc")
+			err := ioutil.WriteFile(conf.SchemaFile, []byte(testAvroSchema), 0644)
+			c.Assume(err, gs.IsNil)
+			conf.TimestampField = "time"
+			conf.MessageType = "event"
+			err = decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("maps record fields to message fields", func() {
+				pack.Message.SetPayload(string(testAvroRecord()))
+				packs, err := decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				expectFields()
+				c.Expect(pack.Message.GetType(), gs.Equals, "event")
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals,
+					int64(1456833600000000000))
+				c.Expect(field("time"), gs.IsNil)
+			})
+
+			c.Specify("rejects truncated records", func() {
+				data := testAvroRecord()
+				pack.Message.SetPayload(string(data[:len(data)-2]))
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("rejects trailing data", func() {
+				pack.Message.SetPayload(string(testAvroRecord()) + "x")
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("with a schema registry", func() {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					requests++
+					if r.URL.Path != "/schemas/ids/7" {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintf(w, `{"schema": %q}`, testAvroSchema)
+				}))
+			defer server.Close()
+			conf.SchemaRegistry = server.URL
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("fetches and caches schemas by id", func() {
+				payload := string([]byte{0, 0, 0, 0, 7}) + string(testAvroRecord())
+				for i := 0; i < 2; i++ {
+					pack.Message.SetPayload(payload)
+					_, err := decoder.Decode(pack)
+					c.Expect(err, gs.IsNil)
+				}
+				c.Expect(requests, gs.Equals, 1)
+				expectFields()
+				c.Expect(field("time"), gs.Equals, int64(1456833600000))
+			})
+
+			c.Specify("fails on unknown schema ids", func() {
+				pack.Message.SetPayload(string([]byte{0, 0, 0, 0, 8}) +
+					string(testAvroRecord()))
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("fails on unframed payloads", func() {
+				pack.Message.SetPayload(string(testAvroRecord()))
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+	})
+}
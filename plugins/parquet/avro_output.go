@@ -0,0 +1,268 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AvroOutputConfig struct {
+	// Directory the Avro files are written to.
+	Path string
+	// Prefix of the file names. Defaults to "heka".
+	FilePrefix string `toml:"file_prefix"`
+	// Message fields to store in addition to the message headers, as
+	// "name:type" where type is one of string, int, double or bool.
+	Fields []string
+	// Approximate file size in bytes at which a new file is started.
+	// Defaults to 64MiB.
+	RollSize uint32 `toml:"roll_size"`
+	// Approximate uncompressed size in bytes of the blocks objects are
+	// written in. Defaults to 65536.
+	BlockSize uint32 `toml:"block_size"`
+	// Block compression codec, "snappy", "deflate" or "none". Defaults to
+	// "snappy".
+	Compression string
+	// Interval in seconds at which the current file is completed, regardless
+	// of its size. Defaults to 3600.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// AvroOutput writes messages to Avro object container files, with the
+// message headers and configured message fields as the fields of an
+// embedded record schema.
+type AvroOutput struct {
+	conf       *AvroOutputConfig
+	codec      string
+	columns    []columnSpec
+	fields     []columnSpec
+	schema     []byte
+	sync       []byte
+	block      []byte
+	blockCount int64
+	file       *os.File
+	path       string
+	tmpPath    string
+	fileSize   int64
+	cursor     string
+	filesCount int64
+	or         OutputRunner
+}
+
+func (a *AvroOutput) ConfigStruct() interface{} {
+	return &AvroOutputConfig{
+		FilePrefix:     "heka",
+		RollSize:       64 * 1024 * 1024,
+		BlockSize:      64 * 1024,
+		Compression:    "snappy",
+		TickerInterval: 3600,
+	}
+}
+
+func (a *AvroOutput) Init(config interface{}) (err error) {
+	a.conf = config.(*AvroOutputConfig)
+	if a.conf.Path == "" {
+		return errors.New("'path' must be specified")
+	}
+	if err = os.MkdirAll(a.conf.Path, 0755); err != nil {
+		return fmt.Errorf("creating %s: %s", a.conf.Path, err)
+	}
+	a.codec = a.conf.Compression
+	if a.codec == "none" {
+		a.codec = "null"
+	}
+	if _, err = compressAvroBlock(a.codec, nil); err != nil {
+		return err
+	}
+	if a.fields, err = parseFieldSpecs(a.conf.Fields); err != nil {
+		return err
+	}
+	for _, f := range a.fields {
+		if !avroNameRegex.MatchString(f.name) {
+			return fmt.Errorf("field name %s isn't a valid Avro name", f.name)
+		}
+	}
+	a.columns = append(append([]columnSpec(nil), headerColumns...), a.fields...)
+	if a.schema, err = avroSchema(a.columns); err != nil {
+		return err
+	}
+	a.sync = make([]byte, 16)
+	return nil
+}
+
+func (a *AvroOutput) Prepare(or OutputRunner, h PluginHelper) error {
+	a.or = or
+	return nil
+}
+
+func appendOptionalAvroString(b []byte, s *string) []byte {
+	if s == nil {
+		return appendLong(b, 0)
+	}
+	return appendAvroBytes(appendLong(b, 1), []byte(*s))
+}
+
+func appendOptionalAvroInt(b []byte, v *int32) []byte {
+	if v == nil {
+		return appendLong(b, 0)
+	}
+	return appendLong(appendLong(b, 1), int64(*v))
+}
+
+// Appends msg to b as an Avro record matching the schema.
+func (a *AvroOutput) appendRecord(b []byte, msg *message.Message) []byte {
+	b = appendLong(b, msg.GetTimestamp()/int64(time.Microsecond))
+	b = appendAvroBytes(b, []byte(msg.GetUuidString()))
+	b = appendOptionalAvroString(b, msg.Type)
+	b = appendOptionalAvroString(b, msg.Logger)
+	b = appendOptionalAvroInt(b, msg.Severity)
+	b = appendOptionalAvroString(b, msg.Payload)
+	b = appendOptionalAvroInt(b, msg.Pid)
+	b = appendOptionalAvroString(b, msg.Hostname)
+	for _, f := range a.fields {
+		v, ok := fieldValue(f.typ, msg.FindFirstField(f.name))
+		if !ok {
+			b = appendLong(b, 0)
+			continue
+		}
+		b = appendLong(b, 1)
+		switch f.typ {
+		case columnString:
+			b = appendAvroBytes(b, []byte(v.(string)))
+		case columnInt64:
+			b = appendLong(b, v.(int64))
+		case columnDouble:
+			b = appendAvroDouble(b, v.(float64))
+		case columnBool:
+			b = appendAvroBool(b, v.(bool))
+		}
+	}
+	return b
+}
+
+func (a *AvroOutput) ProcessMessage(pack *PipelinePack) (err error) {
+	a.block = a.appendRecord(a.block, pack.Message)
+	a.blockCount++
+	a.cursor = pack.QueueCursor
+	if len(a.block) >= int(a.conf.BlockSize) {
+		if err = a.writeBlock(); err != nil {
+			return err
+		}
+	}
+	if a.fileSize >= int64(a.conf.RollSize) {
+		return a.roll()
+	}
+	return nil
+}
+
+func (a *AvroOutput) openFile() (err error) {
+	name := fmt.Sprintf("%s-%s.avro", a.conf.FilePrefix,
+		time.Now().UTC().Format("20060102T150405.000000000Z"))
+	a.path = filepath.Join(a.conf.Path, name)
+	a.tmpPath = filepath.Join(a.conf.Path, "."+name+".tmp")
+	if a.file, err = os.Create(a.tmpPath); err != nil {
+		return fmt.Errorf("creating %s: %s", a.tmpPath, err)
+	}
+	// Every file gets its own sync marker.
+	if _, err = io.ReadFull(rand.Reader, a.sync); err == nil {
+		err = writeAvroHeader(a.file, a.schema, a.codec, a.sync)
+	}
+	if err != nil {
+		a.discardFile()
+		return fmt.Errorf("writing %s: %s", a.tmpPath, err)
+	}
+	a.fileSize = 0
+	return nil
+}
+
+func (a *AvroOutput) discardFile() {
+	a.file.Close()
+	os.Remove(a.tmpPath)
+	a.file = nil
+}
+
+// Writes the buffered objects to the current file as one block, starting
+// a new file if needed.
+func (a *AvroOutput) writeBlock() error {
+	if a.blockCount == 0 {
+		return nil
+	}
+	if a.file == nil {
+		if err := a.openFile(); err != nil {
+			return err
+		}
+	}
+	n, err := writeAvroBlock(a.file, a.codec, a.blockCount, a.block, a.sync)
+	if err != nil {
+		// The file may now be corrupt, so start over with a new one.
+		a.discardFile()
+		return fmt.Errorf("writing %s: %s", a.tmpPath, err)
+	}
+	a.fileSize += int64(n)
+	a.block = a.block[:0]
+	a.blockCount = 0
+	return nil
+}
+
+// Completes the current file, renaming it so it becomes visible.
+func (a *AvroOutput) roll() error {
+	if err := a.writeBlock(); err != nil {
+		return err
+	}
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	if err == nil {
+		err = os.Rename(a.tmpPath, a.path)
+	}
+	if err != nil {
+		os.Remove(a.tmpPath)
+		a.file = nil
+		return fmt.Errorf("writing %s: %s", a.path, err)
+	}
+	a.file = nil
+	atomic.AddInt64(&a.filesCount, 1)
+	a.or.UpdateCursor(a.cursor)
+	return nil
+}
+
+func (a *AvroOutput) TimerEvent() error {
+	return a.roll()
+}
+
+func (a *AvroOutput) CleanUp() {
+	if err := a.roll(); err != nil {
+		a.or.LogError(err)
+	}
+}
+
+func (a *AvroOutput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "FilesWritten", atomic.LoadInt64(&a.filesCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("AvroOutput", func() interface{} {
+		return new(AvroOutput)
+	})
+}
@@ -0,0 +1,180 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Minimal reader for the Avro binary encoding.
+type avroReader struct {
+	data []byte
+}
+
+func (r *avroReader) long() int64 {
+	v, n := binary.Uvarint(r.data)
+	r.data = r.data[n:]
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *avroReader) bytes() []byte {
+	n := int(r.long())
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *avroReader) fixed(n int) []byte {
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func AvroOutputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "avro-output-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	c.Specify("An AvroOutput", func() {
+		output := new(AvroOutput)
+		config := output.ConfigStruct().(*AvroOutputConfig)
+		config.Path = tmpDir
+		config.Fields = []string{"status:int"}
+
+		c.Specify("rejects invalid configuration", func() {
+			config.Fields = []string{"bad-name:string"}
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+			config.Fields = nil
+			config.Compression = "lzo"
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("writes a container file", func() {
+			config.Compression = "none"
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			or := pipelinemock.NewMockOutputRunner(ctrl)
+			output.Prepare(or, nil)
+			or.EXPECT().UpdateCursor("cursor-2")
+
+			pack := NewPipelinePack(nil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			message.NewInt64Field(pack.Message, "status", 200, "")
+			pack.QueueCursor = "cursor-1"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			pack.QueueCursor = "cursor-2"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+
+			files, _ := filepath.Glob(filepath.Join(tmpDir, "*.avro"))
+			c.Expect(len(files), gs.Equals, 0)
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			files, _ = filepath.Glob(filepath.Join(tmpDir, "*"))
+			c.Assume(len(files), gs.Equals, 1)
+			c.Expect(filepath.Ext(files[0]), gs.Equals, ".avro")
+
+			data, err := ioutil.ReadFile(files[0])
+			c.Assume(err, gs.IsNil)
+			c.Assume(string(data[:4]), gs.Equals, avroMagic)
+			r := &avroReader{data[4:]}
+			c.Assume(r.long(), gs.Equals, int64(2))
+			meta := make(map[string][]byte)
+			for i := 0; i < 2; i++ {
+				key := string(r.bytes())
+				meta[key] = r.bytes()
+			}
+			c.Expect(r.long(), gs.Equals, int64(0))
+			c.Expect(string(meta["avro.codec"]), gs.Equals, "null")
+			var schema struct {
+				Name   string
+				Fields []struct{ Name string }
+			}
+			c.Assume(json.Unmarshal(meta["avro.schema"], &schema), gs.IsNil)
+			c.Expect(schema.Name, gs.Equals, "Message")
+			c.Assume(len(schema.Fields), gs.Equals, 9)
+			c.Expect(schema.Fields[8].Name, gs.Equals, "status")
+			sync := r.fixed(16)
+
+			c.Expect(r.long(), gs.Equals, int64(2))
+			block := &avroReader{r.bytes()}
+			c.Expect(bytes.Equal(r.fixed(16), sync), gs.IsTrue)
+			c.Expect(len(r.data), gs.Equals, 0)
+
+			msg := pipeline_ts.GetTestMessage()
+			c.Expect(block.long(), gs.Equals, msg.GetTimestamp()/1000)
+			c.Expect(string(block.bytes()), gs.Equals, msg.GetUuidString())
+			c.Expect(block.long(), gs.Equals, int64(1))
+			c.Expect(string(block.bytes()), gs.Equals, msg.GetType())
+			// Skip Logger, Severity, Payload, Pid and Hostname.
+			block.long()
+			block.bytes()
+			block.long()
+			block.long()
+			block.long()
+			block.bytes()
+			block.long()
+			block.long()
+			block.long()
+			block.bytes()
+			c.Expect(block.long(), gs.Equals, int64(1))
+			c.Expect(block.long(), gs.Equals, int64(200))
+
+			msg = new(message.Message)
+			output.ReportMsg(msg)
+			written, _ := msg.GetFieldValue("FilesWritten")
+			c.Expect(written, gs.Equals, int64(1))
+		})
+
+		c.Specify("writes snappy blocks with a checksum", func() {
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			data := []byte("some uncompressed object data")
+			compressed, err := compressAvroBlock(output.codec, data)
+			c.Assume(err, gs.IsNil)
+			crc := binary.BigEndian.Uint32(compressed[len(compressed)-4:])
+			c.Expect(crc, gs.Equals, crc32.ChecksumIEEE(data))
+		})
+
+		c.Specify("rolls files by size", func() {
+			config.RollSize = 1
+			config.BlockSize = 1
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			or := pipelinemock.NewMockOutputRunner(ctrl)
+			output.Prepare(or, nil)
+			or.EXPECT().UpdateCursor(gomock.Any())
+
+			pack := NewPipelinePack(nil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			files, _ := filepath.Glob(filepath.Join(tmpDir, "*.avro"))
+			c.Expect(len(files), gs.Equals, 1)
+		})
+	})
+}
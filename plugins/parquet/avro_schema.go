@@ -0,0 +1,388 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+type avroKind int
+
+const (
+	avroNull avroKind = iota
+	avroBoolean
+	avroInt
+	avroLong
+	avroFloat
+	avroDouble
+	avroBytes
+	avroString
+	avroRecord
+	avroEnum
+	avroArray
+	avroMap
+	avroUnion
+	avroFixed
+)
+
+var avroPrimitives = map[string]avroKind{
+	"null":    avroNull,
+	"boolean": avroBoolean,
+	"int":     avroInt,
+	"long":    avroLong,
+	"float":   avroFloat,
+	"double":  avroDouble,
+	"bytes":   avroBytes,
+	"string":  avroString,
+}
+
+// A parsed Avro schema, used to read binary encoded data.
+type avroSchemaType struct {
+	kind     avroKind
+	name     string
+	fields   []avroField       // Record fields.
+	items    *avroSchemaType   // Array items and map values.
+	branches []*avroSchemaType // Union branches.
+	symbols  []string          // Enum symbols.
+	size     int               // Fixed size.
+}
+
+type avroField struct {
+	name string
+	typ  *avroSchemaType
+}
+
+// Parses an Avro schema in its JSON form.
+func parseAvroSchema(data []byte) (*avroSchemaType, error) {
+	var schema interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema JSON: %s", err)
+	}
+	return parseAvroType(schema, "", make(map[string]*avroSchemaType))
+}
+
+// Parses a schema node. Named types are added to names as they're
+// defined, so later references, including recursive ones, resolve to them.
+func parseAvroType(schema interface{}, namespace string,
+	names map[string]*avroSchemaType) (*avroSchemaType, error) {
+
+	switch s := schema.(type) {
+	case string:
+		if kind, ok := avroPrimitives[s]; ok {
+			return &avroSchemaType{kind: kind}, nil
+		}
+		if t, ok := names[fullAvroName(s, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := names[s]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown type '%s'", s)
+	case []interface{}:
+		t := &avroSchemaType{kind: avroUnion, branches: make([]*avroSchemaType, len(s))}
+		for i, branch := range s {
+			var err error
+			if t.branches[i], err = parseAvroType(branch, namespace, names); err != nil {
+				return nil, err
+			}
+		}
+		return t, nil
+	case map[string]interface{}:
+		return parseAvroComplex(s, namespace, names)
+	}
+	return nil, fmt.Errorf("invalid type: %v", schema)
+}
+
+func fullAvroName(name, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func parseAvroComplex(s map[string]interface{}, namespace string,
+	names map[string]*avroSchemaType) (t *avroSchemaType, err error) {
+
+	typeName, _ := s["type"].(string)
+	// Named types define a name, and a namespace for the types they contain.
+	define := func() error {
+		name, _ := s["name"].(string)
+		if name == "" {
+			return fmt.Errorf("%s type has no name", typeName)
+		}
+		if ns, ok := s["namespace"].(string); ok {
+			namespace = ns
+		}
+		t.name = fullAvroName(name, namespace)
+		if i := strings.LastIndex(t.name, "."); i >= 0 {
+			namespace = t.name[:i]
+		}
+		names[t.name] = t
+		return nil
+	}
+
+	switch typeName {
+	case "record", "error":
+		t = &avroSchemaType{kind: avroRecord}
+		if err = define(); err != nil {
+			return nil, err
+		}
+		fields, ok := s["fields"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("record %s has no fields", t.name)
+		}
+		for _, f := range fields {
+			field, _ := f.(map[string]interface{})
+			name, _ := field["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("record %s has a field without a name", t.name)
+			}
+			ft, err := parseAvroType(field["type"], namespace, names)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", t.name, name, err)
+			}
+			t.fields = append(t.fields, avroField{name, ft})
+		}
+	case "enum":
+		t = &avroSchemaType{kind: avroEnum}
+		if err = define(); err != nil {
+			return nil, err
+		}
+		symbols, _ := s["symbols"].([]interface{})
+		for _, symbol := range symbols {
+			name, _ := symbol.(string)
+			t.symbols = append(t.symbols, name)
+		}
+	case "fixed":
+		t = &avroSchemaType{kind: avroFixed}
+		if err = define(); err != nil {
+			return nil, err
+		}
+		size, ok := s["size"].(float64)
+		if !ok || size < 0 {
+			return nil, fmt.Errorf("fixed %s has no valid size", t.name)
+		}
+		t.size = int(size)
+	case "array", "map":
+		t = &avroSchemaType{kind: avroArray}
+		key := "items"
+		if typeName == "map" {
+			t.kind, key = avroMap, "values"
+		}
+		if t.items, err = parseAvroType(s[key], namespace, names); err != nil {
+			return nil, err
+		}
+	default:
+		// A primitive type, possibly with a logical type annotation.
+		return parseAvroType(s["type"], namespace, names)
+	}
+	return t, nil
+}
+
+// A decoded record, keeping its fields in schema order.
+type avroRecordValue struct {
+	typ    *avroSchemaType
+	values []interface{}
+}
+
+var errAvroShort = errors.New("data is shorter than the schema requires")
+
+// Reads binary encoded Avro data.
+type avroBinaryReader struct {
+	data []byte
+	// Nesting depth, limited so recursive schemas can't exhaust the stack.
+	depth int
+}
+
+const maxAvroDepth = 64
+
+func (r *avroBinaryReader) long() (int64, error) {
+	u, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		return 0, errAvroShort
+	}
+	r.data = r.data[n:]
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+func (r *avroBinaryReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data) {
+		return nil, errAvroShort
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+func (r *avroBinaryReader) lengthPrefixed() ([]byte, error) {
+	n, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(len(r.data)) {
+		return nil, errAvroShort
+	}
+	return r.bytes(int(n))
+}
+
+// Reads a value of the given type. Records are returned as
+// *avroRecordValue, maps as map[string]interface{}, arrays as
+// []interface{}, enums as their symbol, int and long as int64, float and
+// double as float64, and bytes and fixed as []byte.
+func (r *avroBinaryReader) read(t *avroSchemaType) (interface{}, error) {
+	switch t.kind {
+	case avroNull:
+		return nil, nil
+	case avroBoolean:
+		b, err := r.bytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case avroInt, avroLong:
+		return r.long()
+	case avroFloat:
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case avroDouble:
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case avroBytes:
+		return r.lengthPrefixed()
+	case avroString:
+		b, err := r.lengthPrefixed()
+		return string(b), err
+	case avroFixed:
+		return r.bytes(t.size)
+	case avroEnum:
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(t.symbols)) {
+			return nil, fmt.Errorf("enum %s index %d out of range", t.name, i)
+		}
+		return t.symbols[i], nil
+	case avroUnion:
+		i, err := r.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(t.branches)) {
+			return nil, fmt.Errorf("union index %d out of range", i)
+		}
+		return r.read(t.branches[i])
+	}
+
+	if r.depth++; r.depth > maxAvroDepth {
+		return nil, errors.New("data nested too deeply")
+	}
+	defer func() { r.depth-- }()
+	switch t.kind {
+	case avroRecord:
+		rec := &avroRecordValue{typ: t, values: make([]interface{}, len(t.fields))}
+		for i, f := range t.fields {
+			v, err := r.read(f.typ)
+			if err != nil {
+				return nil, err
+			}
+			rec.values[i] = v
+		}
+		return rec, nil
+	case avroArray:
+		var a []interface{}
+		err := r.blocks(func() error {
+			v, err := r.read(t.items)
+			a = append(a, v)
+			return err
+		})
+		return a, err
+	case avroMap:
+		m := make(map[string]interface{})
+		err := r.blocks(func() error {
+			k, err := r.lengthPrefixed()
+			if err != nil {
+				return err
+			}
+			v, err := r.read(t.items)
+			m[string(k)] = v
+			return err
+		})
+		return m, err
+	}
+	return nil, fmt.Errorf("unsupported type %d", t.kind)
+}
+
+// Reads the blocks of an array or map, calling item for each item.
+func (r *avroBinaryReader) blocks(item func() error) error {
+	for {
+		count, err := r.long()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil
+		}
+		if count < 0 {
+			// A negative count is followed by the block's size in bytes.
+			count = -count
+			if _, err = r.long(); err != nil {
+				return err
+			}
+		}
+		// Every item takes at least one byte, other than nulls.
+		if count > int64(len(r.data)) && count > 1<<20 {
+			return errAvroShort
+		}
+		for i := int64(0); i < count; i++ {
+			if err = item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Converts a decoded value to one encoding/json can marshal.
+func avroJSONValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case *avroRecordValue:
+		m := make(map[string]interface{}, len(val.values))
+		for i, f := range val.typ.fields {
+			m[f.name] = avroJSONValue(val.values[i])
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			m[k] = avroJSONValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(val))
+		for i, e := range val {
+			a[i] = avroJSONValue(e)
+		}
+		return a
+	}
+	return v
+}
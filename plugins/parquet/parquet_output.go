@@ -0,0 +1,226 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type ParquetOutputConfig struct {
+	// Directory the Parquet files are written to.
+	Path string
+	// Prefix of the file names. Defaults to "heka".
+	FilePrefix string `toml:"file_prefix"`
+	// Message fields to store in addition to the message headers, as
+	// "name:type" where type is one of string, int, double or bool.
+	Fields []string
+	// Approximate amount of buffered data in bytes at which a file is
+	// written. Defaults to 64MiB.
+	RollSize uint32 `toml:"roll_size"`
+	// Compression codec for the data pages, "gzip" or "none". Defaults to
+	// "gzip".
+	Compression string
+	// Interval in seconds at which any buffered messages are written to a
+	// file, regardless of size. Defaults to 3600.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// ParquetOutput buffers messages in memory and periodically writes them as
+// columnar Parquet files, with one column per message header and configured
+// message field.
+type ParquetOutput struct {
+	conf       *ParquetOutputConfig
+	codec      int32
+	columns    []*column
+	fields     []*column
+	rows       int64
+	cursor     string
+	filesCount int64
+	or         OutputRunner
+}
+
+func (p *ParquetOutput) ConfigStruct() interface{} {
+	return &ParquetOutputConfig{
+		FilePrefix:     "heka",
+		RollSize:       64 * 1024 * 1024,
+		Compression:    "gzip",
+		TickerInterval: 3600,
+	}
+}
+
+func (p *ParquetOutput) Init(config interface{}) error {
+	p.conf = config.(*ParquetOutputConfig)
+	if p.conf.Path == "" {
+		return errors.New("'path' must be specified")
+	}
+	if err := os.MkdirAll(p.conf.Path, 0755); err != nil {
+		return fmt.Errorf("creating %s: %s", p.conf.Path, err)
+	}
+	switch p.conf.Compression {
+	case "gzip":
+		p.codec = codecGzip
+	case "none":
+		p.codec = codecUncompressed
+	default:
+		return fmt.Errorf("unsupported compression: %s", p.conf.Compression)
+	}
+
+	fields, err := parseFieldSpecs(p.conf.Fields)
+	if err != nil {
+		return err
+	}
+	p.columns = nil
+	for _, spec := range headerColumns {
+		p.columns = append(p.columns, newColumn(spec.name, spec.typ, spec.required))
+	}
+	p.fields = nil
+	for _, spec := range fields {
+		p.fields = append(p.fields, newColumn(spec.name, spec.typ, spec.required))
+	}
+	p.columns = append(p.columns, p.fields...)
+	p.rows = 0
+	return nil
+}
+
+func (p *ParquetOutput) Prepare(or OutputRunner, h PluginHelper) error {
+	p.or = or
+	return nil
+}
+
+func appendOptionalString(c *column, s *string) {
+	if s == nil {
+		c.appendNull()
+	} else {
+		c.appendBytes([]byte(*s))
+	}
+}
+
+func appendOptionalInt32(c *column, v *int32) {
+	if v == nil {
+		c.appendNull()
+	} else {
+		c.appendInt32(*v)
+	}
+}
+
+// Appends the value of field to c, or a null if there's no suitable value.
+func appendField(c *column, field *message.Field) {
+	v, ok := fieldValue(c.typ, field)
+	if !ok {
+		c.appendNull()
+		return
+	}
+	switch c.typ {
+	case columnString:
+		c.appendBytes([]byte(v.(string)))
+	case columnInt64:
+		c.appendInt64(v.(int64))
+	case columnDouble:
+		c.appendDouble(v.(float64))
+	case columnBool:
+		c.appendBool(v.(bool))
+	}
+}
+
+func (p *ParquetOutput) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	c := p.columns
+	c[0].appendInt64(msg.GetTimestamp() / int64(time.Microsecond))
+	c[1].appendBytes([]byte(msg.GetUuidString()))
+	appendOptionalString(c[2], msg.Type)
+	appendOptionalString(c[3], msg.Logger)
+	appendOptionalInt32(c[4], msg.Severity)
+	appendOptionalString(c[5], msg.Payload)
+	appendOptionalInt32(c[6], msg.Pid)
+	appendOptionalString(c[7], msg.Hostname)
+	for _, f := range p.fields {
+		appendField(f, msg.FindFirstField(f.name))
+	}
+	p.rows++
+	p.cursor = pack.QueueCursor
+
+	var size int
+	for _, c := range p.columns {
+		size += c.size()
+	}
+	if size >= int(p.conf.RollSize) {
+		return p.flush()
+	}
+	return nil
+}
+
+// Writes the buffered rows to a new file. The file is written under a
+// temporary name and renamed once complete so readers never see partial
+// files.
+func (p *ParquetOutput) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+	name := fmt.Sprintf("%s-%s.parquet", p.conf.FilePrefix,
+		time.Now().UTC().Format("20060102T150405.000000000Z"))
+	path := filepath.Join(p.conf.Path, name)
+	tmpPath := filepath.Join(p.conf.Path, "."+name+".tmp")
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("creating %s: %s", tmpPath, err)
+	}
+	if err = writeFile(f, p.columns, p.codec, "heka"); err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("writing %s: %s", path, err)
+	}
+
+	for _, c := range p.columns {
+		c.reset()
+	}
+	p.rows = 0
+	atomic.AddInt64(&p.filesCount, 1)
+	p.or.UpdateCursor(p.cursor)
+	return nil
+}
+
+func (p *ParquetOutput) TimerEvent() error {
+	return p.flush()
+}
+
+func (p *ParquetOutput) CleanUp() {
+	if err := p.flush(); err != nil {
+		p.or.LogError(err)
+	}
+}
+
+func (p *ParquetOutput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "FilesWritten", atomic.LoadInt64(&p.filesCount), "count")
+	return nil
+}
+
+func init() {
+	RegisterPlugin("ParquetOutput", func() interface{} {
+		return new(ParquetOutput)
+	})
+}
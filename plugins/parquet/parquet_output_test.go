@@ -0,0 +1,116 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ParquetOutputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	tmpDir, err := ioutil.TempDir("", "parquet-output-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	c.Specify("A ParquetOutput", func() {
+		output := new(ParquetOutput)
+		config := output.ConfigStruct().(*ParquetOutputConfig)
+		config.Path = tmpDir
+		config.Fields = []string{"status:int", "request_time:double"}
+
+		c.Specify("rejects invalid field specs", func() {
+			config.Fields = []string{"status"}
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+			config.Fields = []string{"status:float"}
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+			config.Fields = []string{"Payload:string"}
+			c.Expect(output.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("writes buffered messages to a file", func() {
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			or := pipelinemock.NewMockOutputRunner(ctrl)
+			output.Prepare(or, nil)
+			or.EXPECT().UpdateCursor("cursor-2")
+
+			pack := NewPipelinePack(nil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			message.NewInt64Field(pack.Message, "status", 200, "")
+			pack.QueueCursor = "cursor-1"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+
+			pack.Message = pipeline_ts.GetTestMessage()
+			message.NewStringField(pack.Message, "status", "unknown")
+			message.NewInt64Field(pack.Message, "request_time", 3, "")
+			pack.QueueCursor = "cursor-2"
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+
+			// Nothing is written until the ticker fires.
+			files, _ := filepath.Glob(filepath.Join(tmpDir, "*.parquet"))
+			c.Expect(len(files), gs.Equals, 0)
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			files, _ = filepath.Glob(filepath.Join(tmpDir, "*"))
+			c.Assume(len(files), gs.Equals, 1)
+			c.Expect(filepath.Ext(files[0]), gs.Equals, ".parquet")
+
+			data, err := ioutil.ReadFile(files[0])
+			c.Assume(err, gs.IsNil)
+			meta, err := readFooter(data)
+			c.Assume(err, gs.IsNil)
+			c.Expect(meta.get(3), gs.Equals, int64(2))
+			schema := meta.get(2).([]interface{})
+			c.Assume(len(schema), gs.Equals, 11)
+			status := testStruct(schema[9].(map[int16]interface{}))
+			c.Expect(status.get(4), gs.Equals, "status")
+			c.Expect(status.get(1), gs.Equals, int64(typeInt64))
+
+			// An empty buffer doesn't produce a file.
+			c.Expect(output.TimerEvent(), gs.IsNil)
+			files, _ = filepath.Glob(filepath.Join(tmpDir, "*"))
+			c.Expect(len(files), gs.Equals, 1)
+
+			msg := new(message.Message)
+			output.ReportMsg(msg)
+			written, _ := msg.GetFieldValue("FilesWritten")
+			c.Expect(written, gs.Equals, int64(1))
+		})
+
+		c.Specify("rolls files by size", func() {
+			config.RollSize = 1
+			config.Compression = "none"
+			err := output.Init(config)
+			c.Assume(err, gs.IsNil)
+			or := pipelinemock.NewMockOutputRunner(ctrl)
+			output.Prepare(or, nil)
+			or.EXPECT().UpdateCursor(gomock.Any())
+
+			pack := NewPipelinePack(nil)
+			pack.Message = pipeline_ts.GetTestMessage()
+			c.Expect(output.ProcessMessage(pack), gs.IsNil)
+			files, _ := filepath.Glob(filepath.Join(tmpDir, "*.parquet"))
+			c.Expect(len(files), gs.Equals, 1)
+		})
+	})
+}
@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+type columnSpec struct {
+	name     string
+	typ      columnType
+	required bool
+}
+
+// Columns holding the message headers, which every archive file starts
+// with.
+var headerColumns = []columnSpec{
+	{"Timestamp", columnTimestamp, true},
+	{"Uuid", columnString, true},
+	{"Type", columnString, false},
+	{"Logger", columnString, false},
+	{"Severity", columnInt32, false},
+	{"Payload", columnString, false},
+	{"Pid", columnInt32, false},
+	{"Hostname", columnString, false},
+}
+
+// Parses "name:type" field specs into nullable columns, rejecting unknown
+// types and names that clash with other columns.
+func parseFieldSpecs(specs []string) ([]columnSpec, error) {
+	names := make(map[string]bool)
+	for _, c := range headerColumns {
+		names[c.name] = true
+	}
+	columns := make([]columnSpec, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid field %q, expected name:type", spec)
+		}
+		typ, ok := columnTypeNames[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("invalid type for field %s: %s", parts[0], parts[1])
+		}
+		if names[parts[0]] {
+			return nil, fmt.Errorf("duplicate column: %s", parts[0])
+		}
+		names[parts[0]] = true
+		columns = append(columns, columnSpec{parts[0], typ, false})
+	}
+	return columns, nil
+}
+
+// Returns the first value of field converted to a column's type, or false if
+// the field is missing or of a type that doesn't fit the column. Integer
+// values are accepted for double columns.
+func fieldValue(typ columnType, field *message.Field) (interface{}, bool) {
+	if field == nil {
+		return nil, false
+	}
+	switch typ {
+	case columnString:
+		if v := field.GetValueString(); len(v) > 0 {
+			return v[0], true
+		}
+	case columnInt64:
+		if v := field.GetValueInteger(); len(v) > 0 {
+			return v[0], true
+		}
+	case columnDouble:
+		if v := field.GetValueDouble(); len(v) > 0 {
+			return v[0], true
+		} else if v := field.GetValueInteger(); len(v) > 0 {
+			return float64(v[0]), true
+		}
+	case columnBool:
+		if v := field.GetValueBool(); len(v) > 0 {
+			return v[0], true
+		}
+	}
+	return nil, false
+}
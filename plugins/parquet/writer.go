@@ -0,0 +1,347 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const magic = "PAR1"
+
+// Parquet physical types.
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeDouble    = 5
+	typeByteArray = 6
+)
+
+// Parquet converted types.
+const (
+	convertedUTF8            = 0
+	convertedTimestampMicros = 10
+)
+
+const (
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecGzip         = 2
+
+	pageTypeData = 0
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+)
+
+// Logical column types supported by the writer.
+type columnType int
+
+const (
+	columnString columnType = iota
+	columnInt32
+	columnInt64
+	columnDouble
+	columnBool
+	columnTimestamp
+)
+
+var columnTypeNames = map[string]columnType{
+	"string": columnString,
+	"int":    columnInt64,
+	"double": columnDouble,
+	"bool":   columnBool,
+}
+
+func (t columnType) physical() int32 {
+	switch t {
+	case columnString:
+		return typeByteArray
+	case columnInt32:
+		return typeInt32
+	case columnDouble:
+		return typeDouble
+	case columnBool:
+		return typeBoolean
+	}
+	return typeInt64
+}
+
+// A column of a single row group being buffered in memory. Values are kept
+// PLAIN encoded, except for booleans which are bit packed when the page is
+// written.
+type column struct {
+	name     string
+	typ      columnType
+	required bool
+	// One definition level per row for optional columns: 1 if the row has
+	// a value, 0 if it's null.
+	defLevels []byte
+	values    bytes.Buffer
+	bools     []bool
+	rows      int
+}
+
+func newColumn(name string, typ columnType, required bool) *column {
+	return &column{name: name, typ: typ, required: required}
+}
+
+func (c *column) present() {
+	c.rows++
+	if !c.required {
+		c.defLevels = append(c.defLevels, 1)
+	}
+}
+
+func (c *column) appendNull() {
+	if c.required {
+		panic(fmt.Sprintf("null value for required column %s", c.name))
+	}
+	c.rows++
+	c.defLevels = append(c.defLevels, 0)
+}
+
+func (c *column) appendInt32(v int32) {
+	c.present()
+	binary.Write(&c.values, binary.LittleEndian, v)
+}
+
+func (c *column) appendInt64(v int64) {
+	c.present()
+	binary.Write(&c.values, binary.LittleEndian, v)
+}
+
+func (c *column) appendDouble(v float64) {
+	c.present()
+	binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+}
+
+func (c *column) appendBytes(b []byte) {
+	c.present()
+	binary.Write(&c.values, binary.LittleEndian, uint32(len(b)))
+	c.values.Write(b)
+}
+
+func (c *column) appendBool(v bool) {
+	c.present()
+	c.bools = append(c.bools, v)
+}
+
+// Approximate size of the buffered values in bytes.
+func (c *column) size() int {
+	return c.values.Len() + len(c.defLevels) + len(c.bools)/8
+}
+
+func (c *column) reset() {
+	c.defLevels = c.defLevels[:0]
+	c.values.Reset()
+	c.bools = c.bools[:0]
+	c.rows = 0
+}
+
+// Encodes the definition levels using the RLE / bit-packing hybrid
+// encoding with a bit width of 1, prefixed by their length, as data pages
+// require. Only RLE runs are used.
+func encodeLevels(levels []byte) []byte {
+	var runs bytes.Buffer
+	var varint [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		n := binary.PutUvarint(varint[:], uint64(j-i)<<1)
+		runs.Write(varint[:n])
+		runs.WriteByte(levels[i])
+		i = j
+	}
+	out := make([]byte, 4, 4+runs.Len())
+	binary.LittleEndian.PutUint32(out, uint32(runs.Len()))
+	return append(out, runs.Bytes()...)
+}
+
+func packBools(bools []bool) []byte {
+	packed := make([]byte, (len(bools)+7)/8)
+	for i, b := range bools {
+		if b {
+			packed[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return packed
+}
+
+// Returns the uncompressed contents of the column's single data page.
+func (c *column) pageData() []byte {
+	var data []byte
+	if !c.required {
+		data = encodeLevels(c.defLevels)
+	}
+	if c.typ == columnBool {
+		return append(data, packBools(c.bools)...)
+	}
+	return append(data, c.values.Bytes()...)
+}
+
+func compress(data []byte, codec int32) ([]byte, error) {
+	if codec == codecUncompressed {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type chunkInfo struct {
+	offset           int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+// Writes columns as a Parquet file containing a single row group with one
+// data page per column. All columns must have the same number of rows.
+func writeFile(w io.Writer, columns []*column, codec int32, createdBy string) error {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	offset := int64(len(magic))
+	rows := columns[0].rows
+	chunks := make([]chunkInfo, len(columns))
+
+	for i, c := range columns {
+		if c.rows != rows {
+			return fmt.Errorf("column %s has %d rows, expected %d", c.name, c.rows, rows)
+		}
+		data := c.pageData()
+		compressed, err := compress(data, codec)
+		if err != nil {
+			return err
+		}
+
+		h := new(compactWriter)
+		h.structBegin()
+		h.i32Field(1, pageTypeData)
+		h.i32Field(2, int32(len(data)))
+		h.i32Field(3, int32(len(compressed)))
+		h.structField(5)
+		h.i32Field(1, int32(rows))
+		h.i32Field(2, encodingPlain)
+		h.i32Field(3, encodingRLE)
+		h.i32Field(4, encodingRLE)
+		h.structEnd()
+		h.structEnd()
+
+		if _, err = w.Write(h.Bytes()); err != nil {
+			return err
+		}
+		if _, err = w.Write(compressed); err != nil {
+			return err
+		}
+		header := int64(len(h.Bytes()))
+		chunks[i] = chunkInfo{
+			offset:           offset,
+			uncompressedSize: header + int64(len(data)),
+			compressedSize:   header + int64(len(compressed)),
+		}
+		offset += chunks[i].compressedSize
+	}
+
+	meta := fileMetaData(columns, chunks, int64(rows), codec, createdBy)
+	if _, err := w.Write(meta); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(meta))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, magic)
+	return err
+}
+
+func fileMetaData(columns []*column, chunks []chunkInfo, rows int64, codec int32,
+	createdBy string) []byte {
+
+	m := new(compactWriter)
+	m.structBegin()
+	m.i32Field(1, 1)
+
+	// The schema is a flat list of columns under a root element.
+	m.listField(2, ctStruct, len(columns)+1)
+	m.structBegin()
+	m.stringField(4, "schema")
+	m.i32Field(5, int32(len(columns)))
+	m.structEnd()
+	for _, c := range columns {
+		m.structBegin()
+		m.i32Field(1, c.typ.physical())
+		if c.required {
+			m.i32Field(3, repetitionRequired)
+		} else {
+			m.i32Field(3, repetitionOptional)
+		}
+		m.stringField(4, c.name)
+		switch c.typ {
+		case columnString:
+			m.i32Field(6, convertedUTF8)
+		case columnTimestamp:
+			m.i32Field(6, convertedTimestampMicros)
+		}
+		m.structEnd()
+	}
+
+	m.i64Field(3, rows)
+
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.uncompressedSize
+	}
+	m.listField(4, ctStruct, 1)
+	m.structBegin()
+	m.listField(1, ctStruct, len(columns))
+	for i, c := range columns {
+		chunk := chunks[i]
+		m.structBegin()
+		m.i64Field(2, chunk.offset)
+		m.structField(3)
+		m.i32Field(1, c.typ.physical())
+		m.listField(2, ctI32, 2)
+		m.i32Elem(encodingPlain)
+		m.i32Elem(encodingRLE)
+		m.listField(3, ctBinary, 1)
+		m.stringElem(c.name)
+		m.i32Field(4, codec)
+		m.i64Field(5, int64(c.rows))
+		m.i64Field(6, chunk.uncompressedSize)
+		m.i64Field(7, chunk.compressedSize)
+		m.i64Field(9, chunk.offset)
+		m.structEnd()
+		m.structEnd()
+	}
+	m.i64Field(2, totalSize)
+	m.i64Field(3, rows)
+	m.structEnd()
+
+	m.stringField(6, createdBy)
+	m.structEnd()
+	return m.Bytes()
+}
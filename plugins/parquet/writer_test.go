@@ -0,0 +1,242 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package parquet
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Generic Thrift compact protocol decoder, used to check the written
+// metadata. Structs decode to maps keyed by field id.
+type compactReader struct {
+	r *bufio.Reader
+}
+
+func (cr *compactReader) readStruct() (map[int16]interface{}, error) {
+	fields := make(map[int16]interface{})
+	var last int16
+	for {
+		b, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == 0 {
+			return fields, nil
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := binary.ReadUvarint(cr.r)
+			if err != nil {
+				return nil, err
+			}
+			id = int16(int64(v>>1) ^ -int64(v&1))
+		}
+		last = id
+		if fields[id], err = cr.readValue(typ); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (cr *compactReader) readValue(typ byte) (interface{}, error) {
+	switch typ {
+	case 1, 2:
+		return typ == 1, nil
+	case ctI32, ctI64:
+		v, err := binary.ReadUvarint(cr.r)
+		return int64(v>>1) ^ -int64(v&1), err
+	case ctBinary:
+		n, err := binary.ReadUvarint(cr.r)
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(cr.r, b)
+		return string(b), err
+	case ctList:
+		h, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(h >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(cr.r); err != nil {
+				return nil, err
+			}
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			if list[i], err = cr.readValue(h & 0x0f); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case ctStruct:
+		return cr.readStruct()
+	}
+	return nil, fmt.Errorf("unsupported type %d", typ)
+}
+
+type testStruct map[int16]interface{}
+
+func (s testStruct) get(path ...int16) interface{} {
+	var v interface{} = map[int16]interface{}(s)
+	for _, id := range path {
+		v = v.(map[int16]interface{})[id]
+	}
+	return v
+}
+
+func readFooter(data []byte) (testStruct, error) {
+	if string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		return nil, fmt.Errorf("missing magic")
+	}
+	size := binary.LittleEndian.Uint32(data[len(data)-8:])
+	footer := data[len(data)-8-int(size) : len(data)-8]
+	cr := &compactReader{bufio.NewReader(bytes.NewReader(footer))}
+	m, err := cr.readStruct()
+	return testStruct(m), err
+}
+
+// Returns the decompressed data page of the column chunk at offset.
+func readPage(data []byte, offset int64, gzipped bool) ([]byte, error) {
+	r := bufio.NewReader(bytes.NewReader(data[offset:]))
+	header, err := (&compactReader{r}).readStruct()
+	if err != nil {
+		return nil, err
+	}
+	page := make([]byte, header[3].(int64))
+	if _, err = io.ReadFull(r, page); err != nil {
+		return nil, err
+	}
+	if gzipped {
+		gz, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(gz)
+	}
+	return page, nil
+}
+
+func WriterSpec(c gs.Context) {
+	c.Specify("A Parquet file writer", func() {
+		ts := newColumn("Timestamp", columnTimestamp, true)
+		name := newColumn("name", columnString, false)
+		count := newColumn("count", columnInt64, false)
+		ratio := newColumn("ratio", columnDouble, true)
+		ok := newColumn("ok", columnBool, true)
+		columns := []*column{ts, name, count, ratio, ok}
+
+		ts.appendInt64(1000)
+		name.appendBytes([]byte("a"))
+		count.appendNull()
+		ratio.appendDouble(0.5)
+		ok.appendBool(true)
+
+		ts.appendInt64(2000)
+		name.appendNull()
+		count.appendInt64(7)
+		ratio.appendDouble(1.5)
+		ok.appendBool(false)
+
+		ts.appendInt64(3000)
+		name.appendBytes([]byte("bc"))
+		count.appendNull()
+		ratio.appendDouble(-1)
+		ok.appendBool(true)
+
+		c.Specify("encodes definition levels as RLE runs", func() {
+			c.Expect(fmt.Sprint(encodeLevels([]byte{1, 1, 0, 1})), gs.Equals,
+				fmt.Sprint([]byte{6, 0, 0, 0, 4, 1, 2, 0, 2, 1}))
+		})
+
+		c.Specify("writes a readable file", func() {
+			for _, gzipped := range []bool{false, true} {
+				codec := int32(codecUncompressed)
+				if gzipped {
+					codec = codecGzip
+				}
+				var buf bytes.Buffer
+				err := writeFile(&buf, columns, codec, "heka test")
+				c.Assume(err, gs.IsNil)
+				data := buf.Bytes()
+				meta, err := readFooter(data)
+				c.Assume(err, gs.IsNil)
+
+				c.Expect(meta.get(1), gs.Equals, int64(1))
+				c.Expect(meta.get(3), gs.Equals, int64(3))
+				c.Expect(meta.get(6), gs.Equals, "heka test")
+				schema := meta.get(2).([]interface{})
+				c.Assume(len(schema), gs.Equals, 6)
+				c.Expect(testStruct(schema[0].(map[int16]interface{})).get(5),
+					gs.Equals, int64(5))
+				nameSchema := testStruct(schema[2].(map[int16]interface{}))
+				c.Expect(nameSchema.get(1), gs.Equals, int64(typeByteArray))
+				c.Expect(nameSchema.get(3), gs.Equals, int64(repetitionOptional))
+				c.Expect(nameSchema.get(4), gs.Equals, "name")
+				c.Expect(nameSchema.get(6), gs.Equals, int64(convertedUTF8))
+
+				rowGroup := testStruct(meta.get(4).([]interface{})[0].(map[int16]interface{}))
+				c.Expect(rowGroup.get(3), gs.Equals, int64(3))
+				chunks := rowGroup.get(1).([]interface{})
+				c.Assume(len(chunks), gs.Equals, 5)
+
+				page := func(i int) []byte {
+					chunk := testStruct(chunks[i].(map[int16]interface{}))
+					c.Expect(chunk.get(3, 4), gs.Equals, int64(codec))
+					c.Expect(chunk.get(3, 5), gs.Equals, int64(3))
+					offset := chunk.get(3, 9).(int64)
+					c.Expect(chunk.get(2), gs.Equals, offset)
+					p, err := readPage(data, offset, gzipped)
+					c.Assume(err, gs.IsNil)
+					return p
+				}
+
+				var tsValues [3]int64
+				binary.Read(bytes.NewReader(page(0)), binary.LittleEndian, &tsValues)
+				c.Expect(tsValues[2], gs.Equals, int64(3000))
+
+				// Levels 1, 0, 1 then the two non-null values.
+				c.Expect(fmt.Sprint(page(1)), gs.Equals, fmt.Sprint([]byte{
+					6, 0, 0, 0, 2, 1, 2, 0, 2, 1,
+					1, 0, 0, 0, 'a', 2, 0, 0, 0, 'b', 'c'}))
+
+				countPage := page(2)
+				c.Expect(binary.LittleEndian.Uint32(countPage), gs.Equals, uint32(6))
+				c.Expect(binary.LittleEndian.Uint64(countPage[10:]), gs.Equals, uint64(7))
+
+				ratioPage := page(3)
+				c.Expect(math.Float64frombits(binary.LittleEndian.Uint64(ratioPage[8:])),
+					gs.Equals, 1.5)
+
+				c.Expect(fmt.Sprint(page(4)), gs.Equals, fmt.Sprint([]byte{5}))
+			}
+		})
+
+		c.Specify("refuses columns of different lengths", func() {
+			ok.appendBool(true)
+			err := writeFile(ioutil.Discard, columns, codecUncompressed, "")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,381 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AccessLogDecoderConfig struct {
+	// The server's access log format: an Apache `LogFormat` string, e.g.
+	// `%h %l %u %t "%r" %>s %b`, or an Nginx `log_format` string, e.g.
+	// `$remote_addr - $remote_user [$time_local] "$request" $status`.
+	LogFormat string `toml:"log_format"`
+	// Either "apache" or "nginx". Defaults to "nginx" if the format contains
+	// a `$` variable and "apache" otherwise.
+	Server string
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+	// Whether the original log line is kept as the message payload.
+	PayloadKeep bool `toml:"payload_keep"`
+}
+
+// Decoder for Apache and Nginx access logs that derives its parser from the
+// server's log format, so it can't drift from the server config. Apache
+// format specifiers are mapped onto the Nginx variable names, e.g. %a to
+// remote_addr, so the resulting messages are the same for either server.
+type AccessLogDecoder struct {
+	match       *regexp.Regexp
+	fields      []accessLogField
+	msgType     string
+	payloadKeep bool
+}
+
+// Kinds of access log values.
+const (
+	alString = iota
+	alInt
+	alSeconds // Stored as double seconds, divided by the field's scale.
+	alTime    // Sets the message timestamp, parsed with the field's layout.
+	alEpoch   // Sets the message timestamp from seconds since the Epoch.
+	alRequest // Request line, also split into method, uri and protocol.
+)
+
+const (
+	clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+	intPattern    = `(-|[0-9]+)`
+	numPattern    = `(-|[0-9.]+)`
+)
+
+type accessLogField struct {
+	name   string
+	kind   int
+	layout string
+	scale  float64
+	repr   string
+}
+
+func (ad *AccessLogDecoder) ConfigStruct() interface{} {
+	return new(AccessLogDecoderConfig)
+}
+
+func (ad *AccessLogDecoder) Init(config interface{}) (err error) {
+	conf := config.(*AccessLogDecoderConfig)
+	if conf.LogFormat == "" {
+		return errors.New("AccessLogDecoder: `log_format` setting is required")
+	}
+	server := conf.Server
+	if server == "" {
+		server = "apache"
+		if nginxVarRegex.MatchString(conf.LogFormat) {
+			server = "nginx"
+		}
+	}
+	var expr string
+	switch server {
+	case "apache":
+		expr, ad.fields, err = parseApacheFormat(conf.LogFormat)
+	case "nginx":
+		expr, ad.fields, err = parseNginxFormat(conf.LogFormat)
+	default:
+		return fmt.Errorf("AccessLogDecoder: unknown server '%s', must be 'apache' or 'nginx'",
+			server)
+	}
+	if err != nil {
+		return fmt.Errorf("AccessLogDecoder: %s", err)
+	}
+	if ad.match, err = regexp.Compile("^" + expr + "$"); err != nil {
+		return fmt.Errorf("AccessLogDecoder: %s", err)
+	}
+	ad.msgType = conf.MessageType
+	ad.payloadKeep = conf.PayloadKeep
+	return nil
+}
+
+func (ad *AccessLogDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	line := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	values := ad.match.FindStringSubmatch(line)
+	if values == nil {
+		return nil, fmt.Errorf("No match: %s", line)
+	}
+	msg := pack.Message
+	for i, field := range ad.fields {
+		if err = field.populate(msg, values[i+1]); err != nil {
+			return nil, fmt.Errorf("%s: %s", field.name, err)
+		}
+	}
+	if ad.msgType != "" {
+		msg.SetType(ad.msgType)
+	}
+	if !ad.payloadKeep {
+		msg.SetPayload("")
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Adds the value to the message. Values logged as "-" mean there's no value
+// and are skipped, other than for plain strings.
+func (f *accessLogField) populate(msg *message.Message, val string) error {
+	if val == "-" && f.kind != alString {
+		return nil
+	}
+	switch f.kind {
+	case alString:
+		addField(msg, f.name, val, f.repr)
+	case alInt:
+		i, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return err
+		}
+		addField(msg, f.name, i, f.repr)
+	case alSeconds:
+		secs, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		addField(msg, f.name, secs/f.scale, f.repr)
+	case alTime:
+		t, err := time.Parse(f.layout, val)
+		if err != nil {
+			return err
+		}
+		msg.SetTimestamp(t.UnixNano())
+	case alEpoch:
+		secs, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		msg.SetTimestamp(int64(secs * 1e9))
+	case alRequest:
+		addField(msg, f.name, val, "")
+		parts := strings.Fields(val)
+		if len(parts) == 3 {
+			addField(msg, "request_method", parts[0], "")
+			addField(msg, "request_uri", parts[1], "uri")
+			addField(msg, "server_protocol", parts[2], "")
+		}
+	}
+	return nil
+}
+
+func addField(msg *message.Message, name string, val interface{}, repr string) {
+	if f, err := message.NewField(name, val, repr); err == nil {
+		msg.AddField(f)
+	}
+}
+
+// Apache format specifiers without a `{...}` argument, mapped onto the
+// equivalent Nginx variables.
+var apacheSpecifiers = map[byte]accessLogField{
+	'a': {name: "remote_addr"},
+	'A': {name: "server_addr"},
+	'b': {name: "body_bytes_sent", kind: alInt, repr: "B"},
+	'B': {name: "body_bytes_sent", kind: alInt, repr: "B"},
+	'D': {name: "request_time", kind: alSeconds, scale: 1e6, repr: "s"},
+	'f': {name: "request_filename"},
+	'h': {name: "remote_addr"},
+	'H': {name: "server_protocol"},
+	'I': {name: "request_length", kind: alInt, repr: "B"},
+	'k': {name: "connection_requests", kind: alInt},
+	'l': {name: "remote_logname"},
+	'm': {name: "request_method"},
+	'O': {name: "bytes_sent", kind: alInt, repr: "B"},
+	'p': {name: "server_port", kind: alInt},
+	'P': {name: "pid", kind: alInt},
+	'q': {name: "query_string"},
+	'r': {name: "request", kind: alRequest},
+	's': {name: "status", kind: alInt},
+	't': {name: "time_local", kind: alTime, layout: clfTimeLayout},
+	'T': {name: "request_time", kind: alSeconds, scale: 1, repr: "s"},
+	'u': {name: "remote_user"},
+	'U': {name: "uri", repr: "uri"},
+	'v': {name: "server_name"},
+	'V': {name: "server_name"},
+}
+
+// Converts an Apache LogFormat string into a regular expression with a
+// capture group for each specifier, returning the matching fields.
+func parseApacheFormat(format string) (string, []accessLogField, error) {
+	var (
+		expr   []string
+		fields []accessLogField
+	)
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		if ch == '\\' && i+1 < len(format) {
+			// Escapes as written in the Apache config, e.g. \".
+			i++
+			switch ch = format[i]; ch {
+			case 'n':
+				ch = '\n'
+			case 't':
+				ch = '\t'
+			}
+			expr = append(expr, regexp.QuoteMeta(string(ch)))
+			continue
+		}
+		if ch != '%' {
+			expr = append(expr, regexp.QuoteMeta(string(ch)))
+			continue
+		}
+		i++
+		// Skip status code conditions and the original/final modifiers.
+		for i < len(format) && strings.IndexByte("!0123456789,<>", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) {
+			return "", nil, errors.New("log_format ends in an incomplete specifier")
+		}
+		if format[i] == '%' {
+			expr = append(expr, "%")
+			continue
+		}
+		var arg string
+		if format[i] == '{' {
+			end := strings.IndexByte(format[i:], '}')
+			if end < 0 || i+end+1 >= len(format) {
+				return "", nil, errors.New("log_format has an unterminated '{'")
+			}
+			arg = format[i+1 : i+end]
+			i += end + 1
+		}
+		field, pattern, err := apacheField(format[i], arg)
+		if err != nil {
+			return "", nil, err
+		}
+		expr = append(expr, pattern)
+		fields = append(fields, field)
+	}
+	return strings.Join(expr, ""), fields, nil
+}
+
+func apacheField(spec byte, arg string) (field accessLogField, pattern string, err error) {
+	headerName := strings.ToLower(strings.Replace(arg, "-", "_", -1))
+	switch {
+	case arg != "" && spec == 'i':
+		field = accessLogField{name: "http_" + headerName}
+	case arg != "" && spec == 'o':
+		field = accessLogField{name: "sent_http_" + headerName}
+	case arg != "" && spec == 'C':
+		field = accessLogField{name: "cookie_" + arg}
+	case arg != "" && spec == 'e':
+		field = accessLogField{name: arg}
+	case arg != "" && spec == 'n':
+		field = accessLogField{name: arg}
+	case arg != "" && spec == 'p':
+		field = accessLogField{name: "server_port", kind: alInt}
+		if arg == "remote" {
+			field.name = "remote_port"
+		}
+	case arg != "" && spec == 'T':
+		field = accessLogField{name: "request_time", kind: alSeconds, scale: 1, repr: "s"}
+		switch arg {
+		case "ms":
+			field.scale = 1e3
+		case "us":
+			field.scale = 1e6
+		}
+	case arg != "":
+		return field, "", fmt.Errorf("unsupported log_format specifier '%%{%s}%c'", arg,
+			spec)
+	default:
+		var ok bool
+		if field, ok = apacheSpecifiers[spec]; !ok {
+			return field, "", fmt.Errorf("unsupported log_format specifier '%%%c'", spec)
+		}
+	}
+	switch field.kind {
+	case alInt:
+		pattern = intPattern
+	case alSeconds:
+		pattern = numPattern
+	case alTime:
+		pattern = `\[([^\]]+)\]`
+	default:
+		pattern = "(.*?)"
+	}
+	return field, pattern, nil
+}
+
+var nginxVarRegex = regexp.MustCompile(`\$(?:\{([a-zA-Z0-9_]+)\}|([a-zA-Z0-9_]+))`)
+
+// Nginx variables with typed values.
+var nginxVariables = map[string]accessLogField{
+	"body_bytes_sent":     {kind: alInt, repr: "B"},
+	"bytes_sent":          {kind: alInt, repr: "B"},
+	"connection_requests": {kind: alInt},
+	"content_length":      {kind: alInt, repr: "B"},
+	"msec":                {kind: alEpoch},
+	"pid":                 {kind: alInt},
+	"remote_port":         {kind: alInt},
+	"request":             {kind: alRequest},
+	"request_length":      {kind: alInt, repr: "B"},
+	"request_time":        {kind: alSeconds, scale: 1, repr: "s"},
+	"server_port":         {kind: alInt},
+	"status":              {kind: alInt},
+	"time_iso8601":        {kind: alTime, layout: time.RFC3339},
+	"time_local":          {kind: alTime, layout: clfTimeLayout},
+	"uri":                 {repr: "uri"},
+	"request_uri":         {repr: "uri"},
+}
+
+// Converts an Nginx log_format string into a regular expression with a
+// capture group for each variable, returning the matching fields.
+func parseNginxFormat(format string) (string, []accessLogField, error) {
+	var (
+		expr   []string
+		fields []accessLogField
+	)
+	pos := 0
+	for _, loc := range nginxVarRegex.FindAllStringSubmatchIndex(format, -1) {
+		expr = append(expr, regexp.QuoteMeta(format[pos:loc[0]]))
+		pos = loc[1]
+		var name string
+		if loc[2] >= 0 {
+			name = format[loc[2]:loc[3]]
+		} else {
+			name = format[loc[4]:loc[5]]
+		}
+		field := nginxVariables[name]
+		field.name = name
+		switch field.kind {
+		case alInt:
+			expr = append(expr, intPattern)
+		case alSeconds, alEpoch:
+			expr = append(expr, numPattern)
+		case alTime:
+			expr = append(expr, `(\S+(?: [+-][0-9]{4})?)`)
+		default:
+			expr = append(expr, "(.*?)")
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return "", nil, errors.New("log_format contains no variables")
+	}
+	expr = append(expr, regexp.QuoteMeta(format[pos:]))
+	return strings.Join(expr, ""), fields, nil
+}
+
+func init() {
+	RegisterPlugin("AccessLogDecoder", func() interface{} {
+		return new(AccessLogDecoder)
+	})
+}
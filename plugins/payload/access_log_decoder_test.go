@@ -0,0 +1,117 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AccessLogDecoderSpec(c gs.Context) {
+	decoder := new(AccessLogDecoder)
+	conf := decoder.ConfigStruct().(*AccessLogDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+
+	c.Specify("An AccessLogDecoder", func() {
+		c.Specify("requires a log_format", func() {
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unsupported specifiers", func() {
+			conf.LogFormat = `%h %{%Y}t`
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("decodes Apache LogFormat lines", func() {
+			conf.LogFormat = `%v:%p %h %l %u %t \"%r\" %>s %O \"%{Referer}i\" ` +
+				`\"%{User-Agent}i\" %D`
+			conf.MessageType = "apache.access"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(`www.example.com:80 127.0.0.1 - frank ` +
+				`[10/Oct/2000:13:55:36 -0700] "GET /a.gif?x=1 HTTP/1.0" 200 2326 ` +
+				`"http://www.example.com/" "Mozilla/4.08 [en]" 1500` + "\n")
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+
+			c.Expect(pack.Message.GetType(), gs.Equals, "apache.access")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(971211336000000000))
+			c.Expect(field("server_name"), gs.Equals, "www.example.com")
+			c.Expect(field("server_port"), gs.Equals, int64(80))
+			c.Expect(field("remote_addr"), gs.Equals, "127.0.0.1")
+			c.Expect(field("remote_user"), gs.Equals, "frank")
+			c.Expect(field("request_method"), gs.Equals, "GET")
+			c.Expect(field("request_uri"), gs.Equals, "/a.gif?x=1")
+			c.Expect(field("server_protocol"), gs.Equals, "HTTP/1.0")
+			c.Expect(field("status"), gs.Equals, int64(200))
+			c.Expect(field("bytes_sent"), gs.Equals, int64(2326))
+			c.Expect(field("http_user_agent"), gs.Equals, "Mozilla/4.08 [en]")
+			c.Expect(field("request_time"), gs.Equals, 0.0015)
+			pack.Zero()
+		})
+
+		c.Specify("decodes Nginx log_format lines", func() {
+			conf.LogFormat = `$remote_addr - $remote_user [$time_local] "$request" ` +
+				`$status $body_bytes_sent "$http_referer" $request_time ${host}`
+			conf.PayloadKeep = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			line := `10.1.1.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 404 0 ` +
+				`"-" 0.002 example.com`
+			pack.Message.SetPayload(line)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+
+			c.Expect(pack.Message.GetPayload(), gs.Equals, line)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(971211336000000000))
+			c.Expect(field("remote_user"), gs.Equals, "-")
+			c.Expect(field("status"), gs.Equals, int64(404))
+			c.Expect(field("body_bytes_sent"), gs.Equals, int64(0))
+			c.Expect(field("request_time"), gs.Equals, 0.002)
+			c.Expect(field("host"), gs.Equals, "example.com")
+			c.Expect(pack.Message.FindFirstField("body_bytes_sent").GetRepresentation(),
+				gs.Equals, "B")
+			pack.Zero()
+		})
+
+		c.Specify("skips typed values logged as '-'", func() {
+			conf.LogFormat = `%h %b`
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("127.0.0.1 -")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.FindFirstField("body_bytes_sent") == nil, gs.IsTrue)
+			pack.Zero()
+		})
+
+		c.Specify("fails lines that don't match the format", func() {
+			conf.LogFormat = `%h %>s`
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload("127.0.0.1 OK")
+			_, err = decoder.Decode(pack)
+			c.Expect(err.Error(), gs.Equals, "No match: 127.0.0.1 OK")
+			pack.Zero()
+		})
+	})
+}
@@ -23,8 +23,16 @@ func TestAllSpecs(t *testing.T) {
 	r := gospec.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(AccessLogDecoderSpec)
+	r.AddSpec(CsvDecoderSpec)
+	r.AddSpec(GrokDecoderSpec)
+	r.AddSpec(KvDecoderSpec)
 	r.AddSpec(MultiDecoderSpec)
+	r.AddSpec(MultilineDecoderSpec)
 	r.AddSpec(PayloadDecodersSpec)
+	r.AddSpec(PayloadDecompressDecoderSpec)
+	r.AddSpec(SyslogDecoderSpec)
+	r.AddSpec(XmlDecoderSpec)
 
 	gospec.MainGoTest(r, t)
 }
@@ -0,0 +1,131 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Describes the message field a named capture group is stored in.
+type CaptureField struct {
+	// Name of the message field, defaults to the capture name.
+	Field string
+	// One of "string", "int", "float", "bool" or "timestamp". Defaults to
+	// "string".
+	Type string
+	// Layout used to parse "timestamp" captures, see `timestamp_layout`.
+	Layout string
+	// Representation of the field, defaults to "ns" for timestamps.
+	Representation string
+}
+
+// Validates the capture field specs, filling in defaults, and makes sure
+// each refers to a named capture group of the regex.
+func prepCaptureFields(fields map[string]CaptureField, captureNames []string) (
+	map[string]CaptureField, error) {
+
+	names := make(map[string]bool, len(captureNames))
+	for _, name := range captureNames {
+		names[name] = true
+	}
+	prepped := make(map[string]CaptureField, len(fields))
+	for capture, cf := range fields {
+		if !names[capture] {
+			return nil, fmt.Errorf("capture_fields: no capture group named '%s'", capture)
+		}
+		var err error
+		if prepped[capture], err = cf.prep(capture); err != nil {
+			return nil, fmt.Errorf("capture_fields: %s", err)
+		}
+	}
+	return prepped, nil
+}
+
+// Validates the spec for the named value and fills in its defaults.
+func (cf CaptureField) prep(name string) (CaptureField, error) {
+	if cf.Field == "" {
+		cf.Field = name
+	}
+	switch cf.Type {
+	case "":
+		cf.Type = "string"
+	case "string", "int", "float", "bool":
+	case "timestamp":
+		if cf.Representation == "" {
+			cf.Representation = "ns"
+		}
+	default:
+		return cf, fmt.Errorf("unknown type '%s' for '%s'", cf.Type, name)
+	}
+	return cf, nil
+}
+
+// Adds a typed field for each capture with a CaptureField spec. Captures that
+// didn't match anything are skipped, a value that can't be converted to its
+// type is an error.
+func addCaptureFields(msg *message.Message, fields map[string]CaptureField,
+	captures map[string]string, nf *NumberFormat, loc *time.Location) error {
+
+	for capture, cf := range fields {
+		s := captures[capture]
+		if s == "" {
+			continue
+		}
+		if err := cf.addField(msg, s, nf, loc); err != nil {
+			return fmt.Errorf("capture '%s': %s", capture, err)
+		}
+	}
+	return nil
+}
+
+// Converts the value to the field's type.
+func (cf CaptureField) value(s string, nf *NumberFormat, loc *time.Location) (
+	val interface{}, err error) {
+
+	switch cf.Type {
+	case "string":
+		val = s
+	case "int":
+		val, err = nf.ParseInt(s)
+	case "float":
+		val, err = nf.ParseFloat(s)
+	case "bool":
+		val, err = strconv.ParseBool(s)
+	case "timestamp":
+		var t time.Time
+		if t, err = message.ForgivingTimeParse(cf.Layout, s, loc); err == nil {
+			val = t.UnixNano()
+		}
+	}
+	return
+}
+
+// Converts the value to the field's type and adds it to the message.
+func (cf CaptureField) addField(msg *message.Message, s string, nf *NumberFormat,
+	loc *time.Location) error {
+
+	val, err := cf.value(s, nf, loc)
+	if err != nil {
+		return err
+	}
+	f, err := message.NewField(cf.Field, val, cf.Representation)
+	if err != nil {
+		return err
+	}
+	msg.AddField(f)
+	return nil
+}
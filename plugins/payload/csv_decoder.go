@@ -0,0 +1,239 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type CsvDecoderConfig struct {
+	// Character separating the values of a record, defaults to ",". Use
+	// "\t" for TSV.
+	Delimiter string
+	// Whether a quote may appear in an unquoted value and a non-doubled
+	// quote in a quoted value.
+	LazyQuotes bool `toml:"lazy_quotes"`
+	// Names of the record's columns. Columns named "" or "-" are skipped.
+	Columns []string
+	// Whether the first record is a header. It names the columns unless
+	// `columns` is set, and records identical to it are dropped.
+	Header bool
+	// Typed message fields for columns, keyed by column name. Columns
+	// without one are stored as string fields named after the column.
+	ColumnFields map[string]CaptureField `toml:"column_fields"`
+	// Column whose value sets the message timestamp, parsed with the
+	// timestamp layout and location.
+	TimestampColumn   string `toml:"timestamp_column"`
+	TimestampLayout   string `toml:"timestamp_layout"`
+	TimestampLocation string `toml:"timestamp_location"`
+	// Layouts tried in order after the timestamp layout, see
+	// `timestamp_layouts`, and the field holding unparsable timestamps.
+	TimestampLayouts      []string `toml:"timestamp_layouts"`
+	TimestampFailureField string   `toml:"timestamp_failure_field"`
+	// Character used as the decimal separator in numeric values. Defaults to
+	// ".".
+	DecimalSeparator string `toml:"decimal_separator"`
+	// Characters that may be used to group digits in numeric values.
+	GroupSeparators string `toml:"group_separators"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+	// Whether the original record is kept as the message payload.
+	PayloadKeep bool `toml:"payload_keep"`
+}
+
+// Decoder for delimiter separated records, such as CSV and TSV, one record
+// per payload. Each column becomes a message field, typed as configured.
+type CsvDecoder struct {
+	conf         *CsvDecoderConfig
+	delimiter    rune
+	tzLocation   *time.Location
+	tsParser     *message.TimestampParser
+	numberFormat *NumberFormat
+	// Column names and the field each is stored in, a zero CaptureField for
+	// skipped columns. Nil until the header has been read, if the columns
+	// are named by it.
+	columns []string
+	fields  []CaptureField
+	tsIndex int
+	header  []string
+}
+
+func (cd *CsvDecoder) ConfigStruct() interface{} {
+	return &CsvDecoderConfig{
+		Delimiter: ",",
+	}
+}
+
+func (cd *CsvDecoder) Init(config interface{}) (err error) {
+	conf := config.(*CsvDecoderConfig)
+	if utf8.RuneCountInString(conf.Delimiter) != 1 {
+		return fmt.Errorf("CsvDecoder: delimiter must be a single character: '%s'",
+			conf.Delimiter)
+	}
+	cd.delimiter, _ = utf8.DecodeRuneInString(conf.Delimiter)
+	if cd.delimiter == '"' || cd.delimiter == '\r' || cd.delimiter == '\n' {
+		return fmt.Errorf("CsvDecoder: invalid delimiter '%s'", conf.Delimiter)
+	}
+	if len(conf.Columns) == 0 && !conf.Header {
+		return errors.New("CsvDecoder: either `columns` or `header` must be set")
+	}
+	if cd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
+		return fmt.Errorf("CsvDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if cd.tsParser, err = newTimestampParser(conf.TimestampLayout,
+		conf.TimestampLayouts, conf.TimestampLocation,
+		conf.TimestampFailureField); err != nil {
+		return fmt.Errorf("CsvDecoder: %s", err)
+	}
+	if cd.numberFormat, err = NewNumberFormat(conf.DecimalSeparator,
+		conf.GroupSeparators); err != nil {
+		return fmt.Errorf("CsvDecoder: %s", err)
+	}
+	cd.conf = conf
+	if len(conf.Columns) > 0 {
+		if err = cd.setColumns(conf.Columns); err != nil {
+			return fmt.Errorf("CsvDecoder: %s", err)
+		}
+	}
+	return nil
+}
+
+// Sets up the fields for the named columns, checking that the configured
+// column fields and timestamp column exist.
+func (cd *CsvDecoder) setColumns(columns []string) (err error) {
+	fields := make([]CaptureField, len(columns))
+	tsIndex := -1
+	named := make(map[string]bool, len(columns))
+	for i, name := range columns {
+		if name == "" || name == "-" {
+			continue
+		}
+		if named[name] {
+			return fmt.Errorf("duplicate column '%s'", name)
+		}
+		named[name] = true
+		if name == cd.conf.TimestampColumn {
+			tsIndex = i
+			continue
+		}
+		if fields[i], err = cd.conf.ColumnFields[name].prep(name); err != nil {
+			return fmt.Errorf("column_fields: %s", err)
+		}
+	}
+	for name := range cd.conf.ColumnFields {
+		if !named[name] {
+			return fmt.Errorf("column_fields: no column named '%s'", name)
+		}
+	}
+	if cd.conf.TimestampColumn != "" && tsIndex < 0 {
+		return fmt.Errorf("timestamp_column: no column named '%s'",
+			cd.conf.TimestampColumn)
+	}
+	cd.columns, cd.fields, cd.tsIndex = columns, fields, tsIndex
+	return nil
+}
+
+func (cd *CsvDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	payload := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	if payload == "" {
+		return nil, nil
+	}
+	reader := csv.NewReader(strings.NewReader(payload))
+	reader.Comma = cd.delimiter
+	reader.LazyQuotes = cd.conf.LazyQuotes
+	reader.FieldsPerRecord = -1
+	record, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record: %s", err)
+	}
+
+	if cd.conf.Header {
+		if cd.header == nil {
+			cd.header = record
+			if cd.fields == nil {
+				if err = cd.setColumns(record); err != nil {
+					// Nothing can be decoded without the columns.
+					return nil, NewConfigError("CsvDecoder header: %s", err)
+				}
+			}
+			return nil, nil
+		}
+		if equalRecords(record, cd.header) {
+			return nil, nil
+		}
+	}
+	if len(record) != len(cd.fields) {
+		return nil, fmt.Errorf("Record has %d values, expected %d: %s",
+			len(record), len(cd.fields), payload)
+	}
+
+	msg := pack.Message
+	for i, value := range record {
+		if i == cd.tsIndex && cd.tsParser != nil {
+			if err = setParsedTimestamp(msg, cd.tsParser,
+				cd.conf.TimestampFailureField, value); err != nil {
+				return nil, fmt.Errorf("column '%s': %s", cd.columns[i], err)
+			}
+			continue
+		}
+		if i == cd.tsIndex {
+			var t time.Time
+			if t, err = message.ForgivingTimeParse(cd.conf.TimestampLayout, value,
+				cd.tzLocation); err != nil {
+				return nil, fmt.Errorf("column '%s': %s", cd.columns[i], err)
+			}
+			msg.SetTimestamp(t.UnixNano())
+			continue
+		}
+		field := cd.fields[i]
+		if field.Field == "" || value == "" {
+			continue
+		}
+		if err = field.addField(msg, value, cd.numberFormat, cd.tzLocation); err != nil {
+			return nil, fmt.Errorf("column '%s': %s", cd.columns[i], err)
+		}
+	}
+	if cd.conf.MessageType != "" {
+		msg.SetType(cd.conf.MessageType)
+	}
+	if !cd.conf.PayloadKeep {
+		msg.SetPayload("")
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func equalRecords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	RegisterPlugin("CsvDecoder", func() interface{} {
+		return new(CsvDecoder)
+	})
+}
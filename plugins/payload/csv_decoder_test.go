@@ -0,0 +1,155 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func CsvDecoderSpec(c gs.Context) {
+	decoder := new(CsvDecoder)
+	conf := decoder.ConfigStruct().(*CsvDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	decode := func(payload string) ([]*PipelinePack, error) {
+		pack.Zero()
+		pack.Message.SetPayload(payload)
+		return decoder.Decode(pack)
+	}
+
+	c.Specify("A CsvDecoder", func() {
+		c.Specify("requires columns or a header", func() {
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects column fields for unknown columns", func() {
+			conf.Columns = []string{"a", "b"}
+			conf.ColumnFields = map[string]CaptureField{"c": {Type: "int"}}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with configured columns", func() {
+			conf.Columns = []string{"time", "host", "-", "status", "bytes", "agent"}
+			conf.ColumnFields = map[string]CaptureField{
+				"status": {Type: "int"},
+				"bytes":  {Field: "size", Type: "int", Representation: "B"},
+			}
+			conf.TimestampColumn = "time"
+			conf.MessageType = "csv"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("decodes records", func() {
+				packs, err := decode(`2016-01-02T03:04:05Z,web1,x,200,1024,` +
+					`"Mozilla/5.0 (X11, Linux)"` + "\n")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(pack.Message.GetType(), gs.Equals, "csv")
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1451703845000000000))
+				c.Expect(field("host"), gs.Equals, "web1")
+				c.Expect(field("status"), gs.Equals, int64(200))
+				c.Expect(field("size"), gs.Equals, int64(1024))
+				c.Expect(field("agent"), gs.Equals, "Mozilla/5.0 (X11, Linux)")
+				c.Expect(field("-"), gs.IsNil)
+				c.Expect(field("time"), gs.IsNil)
+			})
+
+			c.Specify("handles quoted delimiters, quotes and empty values", func() {
+				_, err := decode(`2016-01-02T03:04:05Z,"web,1",,,,"say ""hi"""`)
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("host"), gs.Equals, "web,1")
+				c.Expect(field("status"), gs.IsNil)
+				c.Expect(field("agent"), gs.Equals, `say "hi"`)
+			})
+
+			c.Specify("fails on the wrong number of values", func() {
+				_, err := decode("2016-01-02T03:04:05Z,web1,x,200")
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("fails on values of the wrong type", func() {
+				_, err := decode("2016-01-02T03:04:05Z,web1,x,OK,1024,curl")
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("with timestamp layouts and a failure field", func() {
+			conf.Columns = []string{"time", "host"}
+			conf.TimestampColumn = "time"
+			conf.TimestampLayouts = []string{"02.01.2006 15:04", "Epoch"}
+			conf.TimestampLocation = "Europe/Berlin"
+			conf.TimestampFailureField = "bad_time"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			_, err = decode("02.01.2016 04:04,web1")
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1451703840000000000))
+			_, err = decode("1451703845,web1")
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1451703845000000000))
+
+			_, err = decode("soon,web1")
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp() > 1451703845000000000, gs.IsTrue)
+			c.Expect(field("bad_time"), gs.Equals, "soon")
+			c.Expect(field("host"), gs.Equals, "web1")
+		})
+
+		c.Specify("with a header", func() {
+			conf.Delimiter = "\t"
+			conf.Header = true
+			conf.ColumnFields = map[string]CaptureField{"count": {Type: "int"}}
+			conf.PayloadKeep = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			packs, err := decode("name\tcount\n")
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 0)
+
+			c.Specify("names the columns from it", func() {
+				packs, err := decode("foo\t3\n")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(field("name"), gs.Equals, "foo")
+				c.Expect(field("count"), gs.Equals, int64(3))
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "foo\t3\n")
+			})
+
+			c.Specify("drops repeated headers", func() {
+				packs, err := decode("name\tcount")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 0)
+			})
+		})
+
+		c.Specify("fails if the header lacks a configured column", func() {
+			conf.Header = true
+			conf.TimestampColumn = "time"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decode("name,count")
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(ErrorCategoryOf(err), gs.Equals, ErrorConfig)
+		})
+	})
+}
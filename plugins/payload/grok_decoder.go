@@ -0,0 +1,322 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type GrokDecoderConfig struct {
+	// Grok expression the payload is matched against, e.g.
+	// "%{COMBINEDAPACHELOG}".
+	Match string
+
+	// Files holding additional patterns, one "NAME regex" definition per
+	// line. Relative paths are relative to Heka's share_dir.
+	PatternFiles []string `toml:"pattern_files"`
+
+	// Additional patterns keyed by name, taking precedence over those from
+	// the library and the pattern files.
+	Patterns map[string]string
+
+	// Maps severity strings to their int version
+	SeverityMap map[string]int32 `toml:"severity_map"`
+
+	// Keyed to the message field that should be filled in, the value will be
+	// interpolated so it can use the named captures. Defaults to a field for
+	// each named capture.
+	MessageFields MessageTemplate `toml:"message_fields"`
+
+	// User specified timestamp layout string, used for parsing a timestamp
+	// string into an actual time object.
+	TimestampLayout string `toml:"timestamp_layout"`
+
+	// Time zone in which the timestamps in the text are presumed to be in.
+	// Defaults to "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+
+	// Layouts tried in order, after `timestamp_layout` if set, to parse
+	// timestamps. If set, timestamps are only parsed with the configured
+	// layouts rather than also with all of the default ones.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Field holding the timestamps that can't be parsed, which then keep the
+	// time they were received at instead of causing an error.
+	TimestampFailureField string `toml:"timestamp_failure_field"`
+
+	// Whether payloads that do not match the expression should be logged.
+	LogErrors bool `toml:"log_errors"`
+}
+
+type GrokDecoder struct {
+	Match           *regexp.Regexp
+	SeverityMap     map[string]int32
+	MessageFields   MessageTemplate
+	TimestampLayout string
+	tzLocation      *time.Location
+	tsParser        *message.TimestampParser
+	tsFailureField  string
+	dRunner         DecoderRunner
+	logErrors       bool
+	numberFormat    *NumberFormat
+	doubleFields    []string
+	integerFields   []string
+	pConfig         *PipelineConfig
+}
+
+func (gd *GrokDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	gd.pConfig = pConfig
+}
+
+func (gd *GrokDecoder) ConfigStruct() interface{} {
+	return &GrokDecoderConfig{
+		LogErrors: true,
+	}
+}
+
+func (gd *GrokDecoder) Init(config interface{}) (err error) {
+	conf := config.(*GrokDecoderConfig)
+	if conf.Match == "" {
+		return fmt.Errorf("GrokDecoder: `match` setting is required")
+	}
+
+	grok := newGrok()
+	for _, path := range conf.PatternFiles {
+		if gd.pConfig != nil {
+			path = gd.pConfig.Globals.PrependShareDir(path)
+		}
+		if err = grok.loadFile(path); err != nil {
+			return fmt.Errorf("GrokDecoder: %s", err)
+		}
+	}
+	for name, pattern := range conf.Patterns {
+		grok.patterns[name] = pattern
+	}
+	var expr string
+	if expr, err = grok.compile(conf.Match); err != nil {
+		return fmt.Errorf("GrokDecoder: %s", err)
+	}
+	if gd.Match, err = regexp.Compile(expr); err != nil {
+		return fmt.Errorf("GrokDecoder: %s", err)
+	}
+
+	gd.SeverityMap = make(map[string]int32)
+	for codeString, codeInt := range conf.SeverityMap {
+		gd.SeverityMap[codeString] = codeInt
+	}
+	gd.MessageFields = make(MessageTemplate)
+	for field, action := range conf.MessageFields {
+		gd.MessageFields[field] = action
+	}
+	gd.TimestampLayout = conf.TimestampLayout
+	if gd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
+		return fmt.Errorf("GrokDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if gd.tsParser, err = newTimestampParser(conf.TimestampLayout,
+		conf.TimestampLayouts, conf.TimestampLocation,
+		conf.TimestampFailureField); err != nil {
+		return fmt.Errorf("GrokDecoder: %s", err)
+	}
+	gd.tsFailureField = conf.TimestampFailureField
+	gd.logErrors = conf.LogErrors
+	// Typed captures are converted using the default number format.
+	gd.numberFormat, _ = NewNumberFormat("", "")
+	gd.doubleFields = grok.doubleFields
+	gd.integerFields = grok.integerFields
+	return
+}
+
+// Heka will call this to give us access to the runner.
+func (gd *GrokDecoder) SetDecoderRunner(dr DecoderRunner) {
+	gd.dRunner = dr
+}
+
+// Matches the message payload against the compiled grok expression and
+// populates the message from the named captures.
+func (gd *GrokDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	match, captures := tryMatch(gd.Match, pack.Message.GetPayload())
+	if !match {
+		if gd.logErrors {
+			err = fmt.Errorf("No match: %s", pack.Message.GetPayload())
+		}
+		return
+	}
+
+	pdh := &PayloadDecoderHelper{
+		Captures:              captures,
+		dRunner:               gd.dRunner,
+		TimestampLayout:       gd.TimestampLayout,
+		TzLocation:            gd.tzLocation,
+		SeverityMap:           gd.SeverityMap,
+		TimestampParser:       gd.tsParser,
+		TimestampFailureField: gd.tsFailureField,
+	}
+	pdh.DecodeTimestamp(pack)
+	pdh.DecodeSeverity(pack)
+
+	if len(gd.MessageFields) > 0 {
+		err = gd.MessageFields.PopulateMessage(pack.Message, captures)
+	} else {
+		err = populateCaptures(pack.Message, gd.Match, captures)
+	}
+	if err != nil {
+		return
+	}
+	if err = gd.numberFormat.ConvertFields(pack.Message, gd.doubleFields,
+		gd.integerFields); err == nil {
+		packs = []*PipelinePack{pack}
+	}
+	return
+}
+
+// Adds a string field for each named capture that matched something, in the
+// order the captures appear in the expression. The Timestamp capture has
+// already been used for the message timestamp.
+func populateCaptures(msg *message.Message, re *regexp.Regexp,
+	captures map[string]string) error {
+
+	added := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		if name == "" || name == "Timestamp" || added[name] {
+			continue
+		}
+		added[name] = true
+		if val := captures[name]; val != "" {
+			f, err := message.NewField(name, val, "")
+			if err != nil {
+				return err
+			}
+			msg.AddField(f)
+		}
+	}
+	return nil
+}
+
+// Matches pattern references: %{NAME}, %{NAME:capture} or
+// %{NAME:capture:type}.
+var grokRefRegex = regexp.MustCompile(`%\{([^}:]+)(?::([^}:]+))?(?::([^}:]+))?\}`)
+
+var grokNameRegex = regexp.MustCompile(`^\w+$`)
+
+// Expands grok expressions into regular expressions using a set of named
+// patterns.
+type grok struct {
+	patterns      map[string]string
+	doubleFields  []string
+	integerFields []string
+}
+
+func newGrok() *grok {
+	g := &grok{patterns: make(map[string]string, len(grokBuiltinPatterns))}
+	for name, pattern := range grokBuiltinPatterns {
+		g.patterns[name] = pattern
+	}
+	return g
+}
+
+// Loads the pattern definitions from a pattern file. Named groups written
+// in the Oniguruma `(?<name>...)` syntax are rewritten to Go's, and atomic
+// groups are treated as plain non-capturing groups.
+func (g *grok) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || !grokNameRegex.MatchString(parts[0]) {
+			return fmt.Errorf("%s:%d: invalid pattern definition", path, lineNum)
+		}
+		pattern := strings.TrimSpace(parts[1])
+		pattern = strings.Replace(pattern, "(?<", "(?P<", -1)
+		pattern = strings.Replace(pattern, "(?P<=", "(?<=", -1)
+		pattern = strings.Replace(pattern, "(?P<!", "(?<!", -1)
+		pattern = strings.Replace(pattern, "(?>", "(?:", -1)
+		g.patterns[parts[0]] = pattern
+	}
+	return scanner.Err()
+}
+
+// Returns the regular expression for a grok expression, recording any typed
+// captures.
+func (g *grok) compile(expr string) (string, error) {
+	return g.expand(expr, make(map[string]bool))
+}
+
+func (g *grok) expand(expr string, active map[string]bool) (string, error) {
+	var err error
+	result := grokRefRegex.ReplaceAllStringFunc(expr, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		parts := grokRefRegex.FindStringSubmatch(ref)
+		name, capture, typ := parts[1], parts[2], parts[3]
+		pattern, ok := g.patterns[name]
+		if !ok {
+			err = fmt.Errorf("unknown pattern '%s'", name)
+			return ""
+		}
+		if active[name] {
+			err = fmt.Errorf("pattern '%s' refers to itself", name)
+			return ""
+		}
+		active[name] = true
+		pattern, err = g.expand(pattern, active)
+		delete(active, name)
+		if err != nil {
+			return ""
+		}
+		if capture == "" {
+			return "(?:" + pattern + ")"
+		}
+		if !grokNameRegex.MatchString(capture) {
+			err = fmt.Errorf("invalid capture name '%s'", capture)
+			return ""
+		}
+		switch typ {
+		case "":
+		case "int":
+			g.integerFields = append(g.integerFields, capture)
+		case "float":
+			g.doubleFields = append(g.doubleFields, capture)
+		default:
+			err = fmt.Errorf("unknown type '%s' for capture '%s'", typ, capture)
+			return ""
+		}
+		return "(?P<" + capture + ">" + pattern + ")"
+	})
+	return result, err
+}
+
+func init() {
+	RegisterPlugin("GrokDecoder", func() interface{} {
+		return new(GrokDecoder)
+	})
+}
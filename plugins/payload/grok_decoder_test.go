@@ -0,0 +1,159 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func GrokDecoderSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	decoder := new(GrokDecoder)
+	conf := decoder.ConfigStruct().(*GrokDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+	dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+
+	apacheLine := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif ` +
+		`HTTP/1.0" 200 2326 "http://www.example.com/start.html" "Mozilla/4.08"`
+
+	c.Specify("A GrokDecoder", func() {
+		c.Specify("requires a match expression", func() {
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown patterns", func() {
+			conf.Match = "%{NOPE:foo}"
+			err := decoder.Init(conf)
+			c.Expect(err.Error(), gs.Equals, "GrokDecoder: unknown pattern 'NOPE'")
+		})
+
+		c.Specify("rejects recursive patterns", func() {
+			conf.Match = "%{LOOP}"
+			conf.Patterns = map[string]string{"LOOP": "a%{LOOP}"}
+			err := decoder.Init(conf)
+			c.Expect(err.Error(), gs.Equals, "GrokDecoder: pattern 'LOOP' refers to itself")
+		})
+
+		c.Specify("rejects unknown capture types", func() {
+			conf.Match = "%{INT:count:long}"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("decodes shipped patterns into fields", func() {
+			conf.Match = "%{COMBINEDAPACHELOG}"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload(apacheLine)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			msg := pack.Message
+			val, _ := msg.GetFieldValue("clientip")
+			c.Expect(val, gs.Equals, "127.0.0.1")
+			val, _ = msg.GetFieldValue("verb")
+			c.Expect(val, gs.Equals, "GET")
+			val, _ = msg.GetFieldValue("response")
+			c.Expect(val, gs.Equals, "200")
+			val, _ = msg.GetFieldValue("agent")
+			c.Expect(val, gs.Equals, `"Mozilla/4.08"`)
+			// Captures that didn't match anything aren't added.
+			c.Expect(msg.FindFirstField("rawrequest") == nil, gs.IsTrue)
+			pack.Zero()
+		})
+
+		c.Specify("converts typed captures and sets the timestamp", func() {
+			conf.Match = `\[%{HTTPDATE:Timestamp}\] %{WORD:verb} %{NUMBER:bytes:int} ` +
+				`%{NUMBER:duration:float}`
+			conf.TimestampLayout = "02/Jan/2006:15:04:05 -0700"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload("[18/Apr/2013:14:00:28 -0700] GET 2326 0.25")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+			c.Expect(pack.Message.FindFirstField("Timestamp") == nil, gs.IsTrue)
+			val, _ := pack.Message.GetFieldValue("bytes")
+			c.Expect(val, gs.Equals, int64(2326))
+			val, _ = pack.Message.GetFieldValue("duration")
+			c.Expect(val, gs.Equals, 0.25)
+			pack.Zero()
+		})
+
+		c.Specify("uses the message_fields template", func() {
+			conf.Match = "%{SYSLOGLINE}"
+			conf.MessageFields = MessageTemplate{
+				"Hostname": "%logsource%",
+				"Payload":  "%message%",
+				"Program":  "%program%",
+			}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload("Mar  1 12:00:00 web1 sshd[1234]: Accepted publickey")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "web1")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "Accepted publickey")
+			val, _ := pack.Message.GetFieldValue("Program")
+			c.Expect(val, gs.Equals, "sshd")
+			c.Expect(pack.Message.FindFirstField("pid") == nil, gs.IsTrue)
+			pack.Zero()
+		})
+
+		c.Specify("loads pattern files", func() {
+			tmpDir, err := ioutil.TempDir("", "grok-tests")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(tmpDir)
+			path := filepath.Join(tmpDir, "app")
+			contents := "# Application patterns\n" +
+				"APPID (?<appid>[a-z]+-[0-9]+)\n" +
+				"APPLINE %{APPID} %{LOGLEVEL:level}\n"
+			err = ioutil.WriteFile(path, []byte(contents), 0644)
+			c.Assume(err, gs.IsNil)
+			conf.PatternFiles = []string{path}
+			conf.Match = "^%{APPLINE}$"
+			err = decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload("billing-42 WARN")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			val, _ := pack.Message.GetFieldValue("appid")
+			c.Expect(val, gs.Equals, "billing-42")
+			val, _ = pack.Message.GetFieldValue("level")
+			c.Expect(val, gs.Equals, "WARN")
+			pack.Zero()
+
+			pack.Message.SetPayload("billing WARN")
+			_, err = decoder.Decode(pack)
+			c.Expect(err.Error(), gs.Equals, "No match: billing WARN")
+			pack.Zero()
+		})
+	})
+}
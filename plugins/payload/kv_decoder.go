@@ -0,0 +1,268 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type KvDecoderConfig struct {
+	// Keys to decode, all keys are decoded if empty.
+	IncludeKeys []string `toml:"include_keys"`
+	// Keys that are never decoded.
+	ExcludeKeys []string `toml:"exclude_keys"`
+	// Typed message fields for keys, keyed by key name. Keys without one are
+	// typed by their value if `infer_types` is set, or stored as string
+	// fields named after the key.
+	KeyFields map[string]CaptureField `toml:"key_fields"`
+	// Whether unquoted integer, float and boolean values are stored as
+	// fields of that type. Defaults to true.
+	InferTypes bool `toml:"infer_types"`
+	// Key whose value sets the message timestamp, parsed with the timestamp
+	// layout and location.
+	TimestampKey      string `toml:"timestamp_key"`
+	TimestampLayout   string `toml:"timestamp_layout"`
+	TimestampLocation string `toml:"timestamp_location"`
+	// Layouts tried in order after the timestamp layout, see
+	// `timestamp_layouts`, and the field holding unparsable timestamps.
+	TimestampLayouts      []string `toml:"timestamp_layouts"`
+	TimestampFailureField string   `toml:"timestamp_failure_field"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+	// Whether the original record is kept as the message payload.
+	PayloadKeep bool `toml:"payload_keep"`
+}
+
+// Decoder for logfmt style `key=value key2="quoted value"` payloads. Each
+// pair becomes a message field, typed as configured or by its value.
+type KvDecoder struct {
+	conf         *KvDecoderConfig
+	include      map[string]bool
+	exclude      map[string]bool
+	fields       map[string]CaptureField
+	tzLocation   *time.Location
+	tsParser     *message.TimestampParser
+	numberFormat *NumberFormat
+}
+
+// A key and its value, quoted values are unquoted.
+type kvPair struct {
+	key    string
+	value  string
+	quoted bool
+	bare   bool
+}
+
+func (kd *KvDecoder) ConfigStruct() interface{} {
+	return &KvDecoderConfig{
+		InferTypes: true,
+	}
+}
+
+func (kd *KvDecoder) Init(config interface{}) (err error) {
+	conf := config.(*KvDecoderConfig)
+	kd.include = keySet(conf.IncludeKeys)
+	kd.exclude = keySet(conf.ExcludeKeys)
+	for key := range kd.include {
+		if kd.exclude[key] {
+			return fmt.Errorf("KvDecoder: key '%s' is both included and excluded", key)
+		}
+	}
+	kd.fields = make(map[string]CaptureField, len(conf.KeyFields))
+	for key, cf := range conf.KeyFields {
+		if kd.fields[key], err = cf.prep(key); err != nil {
+			return fmt.Errorf("KvDecoder key_fields: %s", err)
+		}
+	}
+	if conf.TimestampKey != "" {
+		if _, ok := conf.KeyFields[conf.TimestampKey]; ok {
+			return fmt.Errorf("KvDecoder: timestamp_key '%s' can't have a key field",
+				conf.TimestampKey)
+		}
+	}
+	if kd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
+		return fmt.Errorf("KvDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	if kd.tsParser, err = newTimestampParser(conf.TimestampLayout,
+		conf.TimestampLayouts, conf.TimestampLocation,
+		conf.TimestampFailureField); err != nil {
+		return fmt.Errorf("KvDecoder: %s", err)
+	}
+	kd.numberFormat, _ = NewNumberFormat("", "")
+	kd.conf = conf
+	return nil
+}
+
+func keySet(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+func (kd *KvDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	payload := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	if payload == "" {
+		return nil, nil
+	}
+	pairs, err := parseLogfmt(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record: %s: %s", err, payload)
+	}
+
+	msg := pack.Message
+	for _, pair := range pairs {
+		if (kd.include != nil && !kd.include[pair.key]) || kd.exclude[pair.key] {
+			continue
+		}
+		if pair.key == kd.conf.TimestampKey {
+			if err = kd.setTimestamp(msg, pair.value); err != nil {
+				return nil, fmt.Errorf("key '%s': %s", pair.key, err)
+			}
+			continue
+		}
+		if cf, ok := kd.fields[pair.key]; ok {
+			if pair.value == "" {
+				continue
+			}
+			if err = cf.addField(msg, pair.value, kd.numberFormat, kd.tzLocation); err != nil {
+				return nil, fmt.Errorf("key '%s': %s", pair.key, err)
+			}
+			continue
+		}
+		var f *message.Field
+		if f, err = message.NewField(pair.key, kd.value(pair), ""); err != nil {
+			return nil, fmt.Errorf("key '%s': %s", pair.key, err)
+		}
+		msg.AddField(f)
+	}
+	if kd.conf.MessageType != "" {
+		msg.SetType(kd.conf.MessageType)
+	}
+	if !kd.conf.PayloadKeep {
+		msg.SetPayload("")
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+func (kd *KvDecoder) setTimestamp(msg *message.Message, value string) error {
+	if kd.tsParser != nil {
+		return setParsedTimestamp(msg, kd.tsParser, kd.conf.TimestampFailureField,
+			value)
+	}
+	t, err := message.ForgivingTimeParse(kd.conf.TimestampLayout, value, kd.tzLocation)
+	if err != nil {
+		return err
+	}
+	msg.SetTimestamp(t.UnixNano())
+	return nil
+}
+
+// Types the value of a key without a key field. Bare keys are true, quoted
+// values are always strings.
+func (kd *KvDecoder) value(pair kvPair) interface{} {
+	if pair.bare {
+		return true
+	}
+	if !kd.conf.InferTypes || pair.quoted || pair.value == "" {
+		return pair.value
+	}
+	switch pair.value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if i, err := strconv.ParseInt(pair.value, 10, 64); err == nil {
+		return i
+	}
+	// Only plain decimal numbers, ParseFloat also accepts "inf" and "nan".
+	if c := pair.value[len(pair.value)-1]; c >= '0' && c <= '9' {
+		if f, err := strconv.ParseFloat(pair.value, 64); err == nil {
+			return f
+		}
+	}
+	return pair.value
+}
+
+// Splits a logfmt record into its key value pairs. Keys run up to a space,
+// `=` or `"`, values up to a space unless they're quoted, in which case Go
+// escape sequences are allowed. A key without a `=` is a bare key.
+func parseLogfmt(s string) (pairs []kvPair, err error) {
+	i := 0
+	for i < len(s) {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(s) && s[i] != ' ' && s[i] != '\t' && s[i] != '=' && s[i] != '"' {
+			i++
+		}
+		if i == start {
+			return nil, fmt.Errorf("unexpected '%c' at offset %d", s[i], i)
+		}
+		pair := kvPair{key: s[start:i]}
+		if i == len(s) || s[i] != '=' {
+			if i < len(s) && s[i] == '"' {
+				return nil, fmt.Errorf("unexpected '\"' at offset %d", i)
+			}
+			pair.bare = true
+			pairs = append(pairs, pair)
+			continue
+		}
+		i++ // Skip the '='.
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, errors.New("unterminated quoted value")
+			}
+			if pair.value, err = strconv.Unquote(s[i : end+1]); err != nil {
+				return nil, fmt.Errorf("bad quoted value %s", s[i:end+1])
+			}
+			pair.quoted = true
+			i = end + 1
+		} else {
+			start = i
+			for i < len(s) && s[i] != ' ' && s[i] != '\t' {
+				i++
+			}
+			pair.value = s[start:i]
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+func init() {
+	RegisterPlugin("KvDecoder", func() interface{} {
+		return new(KvDecoder)
+	})
+}
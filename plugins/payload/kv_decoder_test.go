@@ -0,0 +1,160 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func KvDecoderSpec(c gs.Context) {
+	decoder := new(KvDecoder)
+	conf := decoder.ConfigStruct().(*KvDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	decode := func(payload string) ([]*PipelinePack, error) {
+		pack.Zero()
+		pack.Message.SetPayload(payload)
+		return decoder.Decode(pack)
+	}
+
+	c.Specify("A KvDecoder", func() {
+		c.Specify("rejects keys both included and excluded", func() {
+			conf.IncludeKeys = []string{"a", "b"}
+			conf.ExcludeKeys = []string{"b"}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown key field types", func() {
+			conf.KeyFields = map[string]CaptureField{"a": {Type: "blob"}}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with the default config", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("decodes typed fields", func() {
+				packs, err := decode(`level=info msg="request done" status=200 ` +
+					`took=0.25 cached=false path=/index.html` + "\n")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+				c.Expect(field("level"), gs.Equals, "info")
+				c.Expect(field("msg"), gs.Equals, "request done")
+				c.Expect(field("status"), gs.Equals, int64(200))
+				c.Expect(field("took"), gs.Equals, 0.25)
+				c.Expect(field("cached"), gs.Equals, false)
+				c.Expect(field("path"), gs.Equals, "/index.html")
+			})
+
+			c.Specify("keeps quoted values as strings", func() {
+				_, err := decode(`id="42" note="say \"hi\"" empty=""`)
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("id"), gs.Equals, "42")
+				c.Expect(field("note"), gs.Equals, `say "hi"`)
+				c.Expect(field("empty"), gs.Equals, "")
+			})
+
+			c.Specify("treats bare keys as true", func() {
+				_, err := decode("debug user=bob")
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("debug"), gs.Equals, true)
+				c.Expect(field("user"), gs.Equals, "bob")
+			})
+
+			c.Specify("doesn't infer infinities", func() {
+				_, err := decode("value=inf other=NaN")
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("value"), gs.Equals, "inf")
+				c.Expect(field("other"), gs.Equals, "NaN")
+			})
+
+			c.Specify("fails on unterminated quotes", func() {
+				_, err := decode(`msg="oops`)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("filters keys", func() {
+			c.Specify("by inclusion", func() {
+				conf.IncludeKeys = []string{"status"}
+				err := decoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				_, err = decode("status=200 path=/")
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("status"), gs.Equals, int64(200))
+				c.Expect(field("path"), gs.IsNil)
+			})
+
+			c.Specify("by exclusion", func() {
+				conf.ExcludeKeys = []string{"password"}
+				err := decoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				_, err = decode("user=bob password=hunter2")
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("user"), gs.Equals, "bob")
+				c.Expect(field("password"), gs.IsNil)
+			})
+		})
+
+		c.Specify("with key fields, a timestamp key and no type inference", func() {
+			conf.InferTypes = false
+			conf.KeyFields = map[string]CaptureField{
+				"bytes": {Field: "size", Type: "int", Representation: "B"},
+			}
+			conf.TimestampKey = "ts"
+			conf.MessageType = "logfmt"
+			conf.PayloadKeep = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			payload := "ts=2016-01-02T03:04:05Z bytes=1024 status=200"
+			_, err = decode(payload)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetType(), gs.Equals, "logfmt")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1451703845000000000))
+			c.Expect(field("size"), gs.Equals, int64(1024))
+			c.Expect(field("status"), gs.Equals, "200")
+			c.Expect(field("ts"), gs.IsNil)
+
+			c.Specify("fails on values that don't match their type", func() {
+				_, err = decode("bytes=lots")
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("with timestamp layouts and a failure field", func() {
+			conf.TimestampKey = "ts"
+			conf.TimestampLayouts = []string{"Epoch"}
+			conf.TimestampFailureField = "bad_ts"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			_, err = decode("ts=1451703845 a=1")
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1451703845000000000))
+
+			_, err = decode("ts=yesterday a=1")
+			c.Expect(err, gs.IsNil)
+			c.Expect(field("bad_ts"), gs.Equals, "yesterday")
+		})
+	})
+}
@@ -87,6 +87,12 @@ func MultiDecoderSpec(c gospec.Context) {
 		log_errors = true
 		[StartsWithM2.message_fields]
 		StartsWithM2 = "%TheData%"
+
+		[CatchAll]
+		type = "PayloadRegexDecoder"
+		match_regex = '^(?P<TheData>.*)'
+		[CatchAll.message_fields]
+		CatchAll = "%TheData%"
 		`
 
 		RegisterPlugin("PayloadRegexDecoder", func() interface{} {
@@ -183,6 +189,29 @@ func MultiDecoderSpec(c gospec.Context) {
 			c.Expect(subRunner.Decoder(), gs.Equals, sub)
 		})
 
+		c.Specify("rejects invalid failure policies", func() {
+			// Call LogError to appease the angry gomock gods.
+			dRunner.LogError(errors.New("foo"))
+
+			c.Specify("like `stop` w/ `first-wins` cascading", func() {
+				conf.FailurePolicy = "stop"
+				err := decoder.Init(conf)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("like `fallback` w/o a failure decoder", func() {
+				conf.FailurePolicy = "fallback"
+				err := decoder.Init(conf)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("like a failure decoder w/o `fallback`", func() {
+				conf.FailureDecoder = "StartsWithM"
+				err := decoder.Init(conf)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
 		c.Specify("with multiple registered decoders", func() {
 			sSection, ok := configFile["StartsWithS"]
 			c.Assume(ok, gs.IsTrue)
@@ -287,6 +316,70 @@ func MultiDecoderSpec(c gospec.Context) {
 					c.Expect(ok, gs.IsFalse)
 				})
 			})
+
+			c.Specify("and a `stop` failure policy", func() {
+				conf.CascadeStrategy = "all"
+				conf.FailurePolicy = "stop"
+				err := decoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				decoder.SetDecoderRunner(dRunner)
+
+				c.Specify("stops the chain at the first failure", func() {
+					pack.Message.SetPayload("matches twice")
+					packs, err := decoder.Decode(pack)
+					c.Expect(len(packs), gs.Equals, 0)
+					c.Expect(err.Error(), gs.Equals, "Subdecoder 'StartsWithS' failed.")
+					_, ok = pack.Message.GetFieldValue("StartsWithM")
+					c.Expect(ok, gs.IsTrue)
+					_, ok = pack.Message.GetFieldValue("StartsWithM2")
+					c.Expect(ok, gs.IsFalse)
+				})
+			})
+
+			c.Specify("and a `fallback` failure policy", func() {
+				catchSection, ok := configFile["CatchAll"]
+				c.Assume(ok, gs.IsTrue)
+				catchMaker, err := NewPluginMaker("CatchAll", pConfig, catchSection)
+				c.Assume(err, gs.IsNil)
+				pConfig.DecoderMakers["CatchAll"] = catchMaker
+				conf.FailurePolicy = "fallback"
+				conf.FailureDecoder = "CatchAll"
+
+				// The failure decoder gets a DecoderRunner too.
+				dRunner.EXPECT().LogError(gomock.Any())
+
+				c.Specify("hands messages nothing decodes to the failure decoder", func() {
+					err := decoder.Init(conf)
+					c.Assume(err, gs.IsNil)
+					decoder.SetDecoderRunner(dRunner)
+
+					pack.Message.SetPayload("won't match")
+					packs, err := decoder.Decode(pack)
+					c.Expect(err, gs.IsNil)
+					c.Expect(len(packs), gs.Equals, 1)
+					value, ok := pack.Message.GetFieldValue("CatchAll")
+					c.Expect(ok, gs.IsTrue)
+					c.Expect(value, gs.Equals, "won't match")
+				})
+
+				c.Specify("stops the `all` chain at the first failure", func() {
+					conf.CascadeStrategy = "all"
+					err := decoder.Init(conf)
+					c.Assume(err, gs.IsNil)
+					decoder.SetDecoderRunner(dRunner)
+
+					pack.Message.SetPayload("matches twice")
+					packs, err := decoder.Decode(pack)
+					c.Expect(err, gs.IsNil)
+					c.Expect(len(packs), gs.Equals, 1)
+					_, ok = pack.Message.GetFieldValue("StartsWithM")
+					c.Expect(ok, gs.IsTrue)
+					_, ok = pack.Message.GetFieldValue("StartsWithM2")
+					c.Expect(ok, gs.IsFalse)
+					_, ok = pack.Message.GetFieldValue("CatchAll")
+					c.Expect(ok, gs.IsTrue)
+				})
+			})
 		})
 	})
 
@@ -0,0 +1,199 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type MultilineDecoderConfig struct {
+	// Regular expression matching the first line of a record. Lines that
+	// don't match are appended to the preceding record.
+	StartPattern string `toml:"start_pattern"`
+
+	// Maximum number of lines in a record. Continuation lines beyond it start
+	// a new record.
+	MaxLines int `toml:"max_lines"`
+
+	// Milliseconds without a new line after which a pending record is
+	// emitted, 0 to only emit records when the next one starts.
+	FlushTimeout uint `toml:"flush_timeout"`
+}
+
+// Decoder that merges continuation lines, such as the frames of a stack
+// trace, into the message holding the line that started the record. A record
+// is held back until the next record starts or no line has been added to it
+// for flush_timeout.
+type MultilineDecoder struct {
+	startPattern *regexp.Regexp
+	maxLines     int
+	flushTimeout time.Duration
+	dRunner      DecoderRunner
+
+	// Protects the pending record, which the flush timer also accesses.
+	lock     sync.Mutex
+	pending  *message.Message
+	lines    []string
+	lastLine time.Time
+	timer    *time.Timer
+}
+
+func (md *MultilineDecoder) ConfigStruct() interface{} {
+	return &MultilineDecoderConfig{
+		MaxLines:     500,
+		FlushTimeout: 1000,
+	}
+}
+
+func (md *MultilineDecoder) Init(config interface{}) (err error) {
+	conf := config.(*MultilineDecoderConfig)
+	if conf.StartPattern == "" {
+		return errors.New("MultilineDecoder: `start_pattern` setting is required")
+	}
+	if md.startPattern, err = regexp.Compile(conf.StartPattern); err != nil {
+		return fmt.Errorf("MultilineDecoder: %s", err)
+	}
+	if conf.MaxLines < 1 {
+		return errors.New("MultilineDecoder: `max_lines` must be at least 1")
+	}
+	md.maxLines = conf.MaxLines
+	md.flushTimeout = time.Duration(conf.FlushTimeout) * time.Millisecond
+	return nil
+}
+
+// Heka will call this to give us access to the runner, which is needed to
+// emit records on flush timeout.
+func (md *MultilineDecoder) SetDecoderRunner(dr DecoderRunner) {
+	md.dRunner = dr
+}
+
+func (md *MultilineDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	line := strings.TrimRight(pack.Message.GetPayload(), "\r\n")
+	md.lock.Lock()
+	defer md.lock.Unlock()
+
+	if md.pending != nil && len(md.lines) < md.maxLines &&
+		!md.startPattern.MatchString(line) {
+
+		md.lines = append(md.lines, line)
+		md.lastLine = time.Now()
+		return nil, nil
+	}
+
+	// The line starts a new record, so the pending one, if any, is emitted in
+	// place of the pack's message.
+	record := md.takePending()
+	md.pending = pack.Message
+	md.lines = append(md.lines, line)
+	md.lastLine = time.Now()
+	md.startTimer()
+	if record == nil {
+		pack.Message = new(message.Message)
+		return nil, nil
+	}
+	pack.Message = record
+	pack.TrustMsgBytes = false
+	return []*PipelinePack{pack}, nil
+}
+
+// Returns the pending record with its lines joined into the payload, or nil
+// if there's none. Must be called with the lock held.
+func (md *MultilineDecoder) takePending() (record *message.Message) {
+	if md.pending == nil {
+		return nil
+	}
+	record = md.pending
+	record.SetPayload(strings.Join(md.lines, "\n"))
+	md.pending = nil
+	md.lines = md.lines[:0]
+	return record
+}
+
+// Arms the flush timer for a new pending record. Must be called with the lock
+// held.
+func (md *MultilineDecoder) startTimer() {
+	if md.flushTimeout == 0 || md.dRunner == nil {
+		return
+	}
+	if md.timer == nil {
+		md.timer = time.AfterFunc(md.flushTimeout, md.flushExpired)
+	} else {
+		md.timer.Reset(md.flushTimeout)
+	}
+}
+
+// Emits the pending record if no line has been added to it for the flush
+// timeout, otherwise rearms the timer for the remaining time.
+func (md *MultilineDecoder) flushExpired() {
+	md.lock.Lock()
+	if md.pending == nil {
+		md.lock.Unlock()
+		return
+	}
+	if wait := md.flushTimeout - time.Since(md.lastLine); wait > 0 {
+		md.timer.Reset(wait)
+		md.lock.Unlock()
+		return
+	}
+	record := md.takePending()
+	md.lock.Unlock()
+	md.emit(record)
+}
+
+// Hands a record that's emitted outside of Decode to the router.
+func (md *MultilineDecoder) emit(record *message.Message) {
+	pack := md.dRunner.NewPack()
+	if pack == nil {
+		return // We're aborting.
+	}
+	pack.Message = record
+	if err := pack.EncodeMsgBytes(); err != nil {
+		md.dRunner.LogError(fmt.Errorf("encoding message: %s", err))
+		pack.Recycle(nil)
+		return
+	}
+	md.dRunner.Router().Inject(pack)
+}
+
+// Continuation lines are merged into the record started by an earlier
+// message, so all of an input's messages need to go through one decoder.
+func (md *MultilineDecoder) IsStateful() bool {
+	return true
+}
+
+// Emits any pending record when the decoder runner exits, so the end of the
+// input isn't lost.
+func (md *MultilineDecoder) Shutdown() {
+	md.lock.Lock()
+	if md.timer != nil {
+		md.timer.Stop()
+	}
+	record := md.takePending()
+	md.lock.Unlock()
+	if record != nil && md.dRunner != nil {
+		md.emit(record)
+	}
+}
+
+func init() {
+	RegisterPlugin("MultilineDecoder", func() interface{} {
+		return new(MultilineDecoder)
+	})
+}
@@ -0,0 +1,144 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Router that only accepts injected packs.
+type injectRouter struct {
+	inChan chan *PipelinePack
+}
+
+func (r *injectRouter) InChan() chan *PipelinePack {
+	return r.inChan
+}
+
+func (r *injectRouter) Inject(pack *PipelinePack) error {
+	r.inChan <- pack
+	return nil
+}
+
+func (r *injectRouter) AddFilterMatcher() chan *MatchRunner {
+	return nil
+}
+
+func (r *injectRouter) RemoveFilterMatcher() chan *MatchRunner {
+	return nil
+}
+
+func (r *injectRouter) RemoveOutputMatcher() chan *MatchRunner {
+	return nil
+}
+
+func MultilineDecoderSpec(c gs.Context) {
+	t := &pipeline_ts.SimpleT{}
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	decoder := new(MultilineDecoder)
+	conf := decoder.ConfigStruct().(*MultilineDecoderConfig)
+	conf.StartPattern = `^\S`
+	conf.FlushTimeout = 0
+	supply := make(chan *PipelinePack, 1)
+
+	// Decodes a line, returning the payload of the emitted record, if any.
+	decode := func(line string) (payload string, emitted bool) {
+		pack := NewPipelinePack(supply)
+		pack.Message = pipeline_ts.GetTestMessage()
+		pack.Message.SetPayload(line + "\n")
+		packs, err := decoder.Decode(pack)
+		c.Expect(err, gs.IsNil)
+		if len(packs) == 0 {
+			return "", false
+		}
+		c.Expect(len(packs), gs.Equals, 1)
+		return packs[0].Message.GetPayload(), true
+	}
+
+	c.Specify("A MultilineDecoder", func() {
+		c.Specify("requires a start_pattern", func() {
+			conf.StartPattern = ""
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("can't be scaled out with max_decoders", func() {
+			var stateful StatefulDecoder = decoder
+			c.Expect(stateful.IsStateful(), gs.IsTrue)
+		})
+
+		c.Specify("merges continuation lines into the preceding record", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, emitted := decode("Exception in thread \"main\" java.lang.Error")
+			c.Expect(emitted, gs.IsFalse)
+			_, emitted = decode("\tat Foo.bar(Foo.java:1)")
+			c.Expect(emitted, gs.IsFalse)
+			_, emitted = decode("\tat Foo.main(Foo.java:5)")
+			c.Expect(emitted, gs.IsFalse)
+			payload, emitted := decode("next record")
+			c.Expect(emitted, gs.IsTrue)
+			c.Expect(payload, gs.Equals, "Exception in thread \"main\" java.lang.Error\n"+
+				"\tat Foo.bar(Foo.java:1)\n\tat Foo.main(Foo.java:5)")
+			payload, emitted = decode("and another")
+			c.Expect(emitted, gs.IsTrue)
+			c.Expect(payload, gs.Equals, "next record")
+		})
+
+		c.Specify("starts a new record after max_lines", func() {
+			conf.MaxLines = 2
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decode("first")
+			decode(" second")
+			payload, emitted := decode(" third")
+			c.Expect(emitted, gs.IsTrue)
+			c.Expect(payload, gs.Equals, "first\n second")
+			payload, _ = decode("fourth")
+			c.Expect(payload, gs.Equals, " third")
+		})
+
+		c.Specify("emits pending records after flush_timeout", func() {
+			conf.FlushTimeout = 10
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			router := &injectRouter{make(chan *PipelinePack, 1)}
+			flushPack := NewPipelinePack(nil)
+			dRunner.EXPECT().NewPack().Return(flushPack)
+			dRunner.EXPECT().Router().Return(router)
+			decoder.SetDecoderRunner(dRunner)
+
+			decode("Traceback (most recent call last):")
+			decode("  File \"foo.py\", line 1, in <module>")
+			select {
+			case pack := <-router.inChan:
+				c.Expect(pack, gs.Equals, flushPack)
+				c.Expect(pack.Message.GetPayload(), gs.Equals,
+					"Traceback (most recent call last):\n"+
+						"  File \"foo.py\", line 1, in <module>")
+				c.Expect(pack.TrustMsgBytes, gs.IsTrue)
+			case <-time.After(time.Second):
+				c.Expect("", gs.Equals, "record should have been flushed")
+			}
+			decoder.Shutdown()
+		})
+	})
+}
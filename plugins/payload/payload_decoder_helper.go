@@ -28,6 +28,50 @@ type PayloadDecoderHelper struct {
 	TimestampLayout string
 	TzLocation      *time.Location
 	SeverityMap     map[string]int32
+	// Parses timestamps instead of TimestampLayout and TzLocation if set.
+	TimestampParser       *TimestampParser
+	TimestampFailureField string
+}
+
+// Creates the parser of the `timestamp_layouts` setting, tried in order after
+// `timestamp_layout` if that's set. Returns nil if neither
+// `timestamp_layouts` nor `timestamp_failure_field` is set, in which case
+// timestamps are parsed with ForgivingTimeParse.
+func newTimestampParser(layout string, layouts []string, location,
+	failureField string) (*TimestampParser, error) {
+
+	if len(layouts) == 0 && failureField == "" {
+		return nil, nil
+	}
+	if layout != "" {
+		layouts = append([]string{layout}, layouts...)
+	}
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("`timestamp_failure_field` requires a timestamp layout")
+	}
+	return NewTimestampParser(layouts, location)
+}
+
+// Sets the message timestamp to the time parsed from value. If the value
+// can't be parsed the message keeps the time it was received at and the
+// value is stored in the failure field, or an error is returned if there's
+// no failure field.
+func setParsedTimestamp(msg *Message, parser *TimestampParser, failureField,
+	value string) error {
+
+	t, err := parser.Parse(value)
+	if err == nil {
+		msg.SetTimestamp(t.UnixNano())
+		return nil
+	}
+	if msg.GetTimestamp() == 0 {
+		msg.SetTimestamp(time.Now().UnixNano())
+	}
+	if failureField == "" {
+		return err
+	}
+	NewStringField(msg, failureField, value)
+	return nil
 }
 
 /*
@@ -38,19 +82,20 @@ timestamp is written and the default of 0 is used.
 */
 func (pdh *PayloadDecoderHelper) DecodeTimestamp(pack *PipelinePack) {
 	if timeStamp, ok := pdh.Captures["Timestamp"]; ok {
+		if pdh.TimestampParser != nil {
+			if err := setParsedTimestamp(pack.Message, pdh.TimestampParser,
+				pdh.TimestampFailureField, timeStamp); err != nil {
+
+				pdh.dRunner.LogError(err)
+			}
+			return
+		}
 		val, err := ForgivingTimeParse(pdh.TimestampLayout, timeStamp, pdh.TzLocation)
 		if err != nil {
 			pdh.dRunner.LogError(fmt.Errorf("Don't recognize Timestamp: '%s'", timeStamp))
 		}
-		// If we only get a timestamp, use the current date
-		if val.Year() == 0 && val.Month() == 1 && val.Day() == 1 {
-			now := time.Now()
-			val = val.AddDate(now.Year(), int(now.Month()-1), now.Day()-1)
-		} else if val.Year() == 0 {
-			// If there's no year, use current year
-			val = val.AddDate(time.Now().Year(), 0, 0)
-		}
-		pack.Message.SetTimestamp(val.UnixNano())
+		// If we only get a timestamp, use the current date or year.
+		pack.Message.SetTimestamp(CompleteDate(val).UnixNano())
 	}
 }
 
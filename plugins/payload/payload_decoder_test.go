@@ -149,6 +149,46 @@ func PayloadDecodersSpec(c gospec.Context) {
 			pack.Zero()
 		})
 
+		c.Specify("tries the timestamp layouts in order", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayouts = []string{"2006-01-02 15:04:05", "EpochMilli"}
+			conf.TimestampLocation = "America/Los_Angeles"
+			conf.TimestampFailureField = "timestamp_failure"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload("[2013-04-18 14:00:28]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828000000000))
+			pack.Zero()
+
+			pack.Message.SetPayload("[1366318828123]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1366318828123000000))
+			pack.Zero()
+
+			c.Specify("keeping the receive time of unparsable timestamps", func() {
+				pack.Message.SetTimestamp(42)
+				pack.Message.SetPayload("[last tuesday]")
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(42))
+				failure, _ := pack.Message.GetFieldValue("timestamp_failure")
+				c.Expect(failure, gs.Equals, "last tuesday")
+				pack.Zero()
+			})
+		})
+
+		c.Specify("rejects an unknown epoch timestamp layout", func() {
+			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
+			conf.TimestampLayouts = []string{"EpochDays"}
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+
 		c.Specify("logs invalid messages", func() {
 			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
 			err := decoder.Init(conf)
@@ -218,6 +258,57 @@ func PayloadDecodersSpec(c gospec.Context) {
 			pack.Zero()
 		})
 
+		c.Specify("stores captures in typed fields", func() {
+			conf.MatchRegex = `(?P<ip>\S+) (?P<size>\d+) (?P<secs>\S+) ` +
+				`(?P<cached>\w+) \[(?P<ts>[^\]]+)\](?: (?P<extra>\d+))?`
+			conf.CaptureFields = map[string]CaptureField{
+				"ip":     {Field: "ClientIP"},
+				"size":   {Field: "Size", Type: "int", Representation: "B"},
+				"secs":   {Field: "Elapsed", Type: "float"},
+				"cached": {Type: "bool"},
+				"ts":     {Field: "Requested", Type: "timestamp", Layout: "02/Jan/2006:15:04:05 -0700"},
+				"extra":  {Type: "int"},
+			}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload("10.0.0.1 2326 0.25 true [18/Apr/2013:14:00:28 -0700]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+
+			val, _ := pack.Message.GetFieldValue("ClientIP")
+			c.Expect(val, gs.Equals, "10.0.0.1")
+			f := pack.Message.FindFirstField("Size")
+			c.Expect(f.GetValue(), gs.Equals, int64(2326))
+			c.Expect(f.GetRepresentation(), gs.Equals, "B")
+			val, _ = pack.Message.GetFieldValue("Elapsed")
+			c.Expect(val, gs.Equals, 0.25)
+			val, _ = pack.Message.GetFieldValue("cached")
+			c.Expect(val, gs.Equals, true)
+			f = pack.Message.FindFirstField("Requested")
+			c.Expect(f.GetValue(), gs.Equals, int64(1366318828000000000))
+			c.Expect(f.GetRepresentation(), gs.Equals, "ns")
+			c.Expect(pack.Message.FindFirstField("extra") == nil, gs.IsTrue)
+			pack.Zero()
+
+			pack.Message.SetPayload("10.0.0.1 2326 0.25 maybe [18/Apr/2013:14:00:28 -0700]")
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			pack.Zero()
+		})
+
+		c.Specify("rejects invalid capture fields", func() {
+			conf.MatchRegex = `(?P<size>\d+)`
+			conf.CaptureFields = map[string]CaptureField{"bytes": {Type: "int"}}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			conf.CaptureFields = map[string]CaptureField{"size": {Type: "long"}}
+			err = decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("adjusts timestamps as specified", func() {
 			conf.MatchRegex = `\[(?P<Timestamp>[^\]]+)\]`
 			conf.TimestampLayout = "02/Jan/2006:15:04:05"
@@ -268,6 +359,46 @@ func PayloadDecodersSpec(c gospec.Context) {
 			pack.Zero()
 		})
 
+		c.Specify("parses locale formatted numeric fields", func() {
+			conf.MatchRegex = `amount=(?P<Amount>\S+) items=(?P<Items>\S+)`
+			conf.MessageFields = MessageTemplate{
+				"Amount|EUR": "%Amount%",
+				"Items":      "%Items%",
+			}
+			conf.DoubleFields = []string{"Amount"}
+			conf.IntegerFields = []string{"Items"}
+			conf.DecimalSeparator = ","
+			conf.GroupSeparators = "."
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			dRunner := pipelinemock.NewMockDecoderRunner(ctrl)
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload("amount=1.234,56 items=1.200")
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+			f := pack.Message.FindFirstField("Amount")
+			c.Expect(f.GetValue(), gs.Equals, 1234.56)
+			c.Expect(f.GetRepresentation(), gs.Equals, "EUR")
+			f = pack.Message.FindFirstField("Items")
+			c.Expect(f.GetValue(), gs.Equals, int64(1200))
+			pack.Zero()
+
+			pack.Message.SetPayload("amount=1.234,56 items=1,5")
+			packs, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(len(packs), gs.Equals, 0)
+			pack.Zero()
+		})
+
+		c.Specify("rejects an invalid decimal separator", func() {
+			conf.MatchRegex = `(?P<Amount>\S+)`
+			conf.DecimalSeparator = ",,"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
 		c.Specify("reading test-zeus.log", func() {
 			conf.MatchRegex = `(?P<Ip>([0-9]{1,3}\.){3}[0-9]{1,3}) (?P<Hostname>(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\-]*[a-zA-Z0-9])\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\-]*[A-Za-z0-9])) (?P<User>\w+) \[(?P<Timestamp>[^\]]+)\] \"(?P<Verb>[A-X]+) (?P<Request>\/\S*) HTTP\/(?P<Httpversion>\d\.\d)\" (?P<Response>\d{3}) (?P<Bytes>\d+)`
 			conf.MessageFields = MessageTemplate{
@@ -0,0 +1,229 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type PayloadDecompressDecoderConfig struct {
+	// Wrappings that are detected and stripped, "zlib", "base64" or the
+	// name of a registered compression codec, e.g. "gzip".
+	Encodings []string `toml:"encodings"`
+	// Maximum size of the unwrapped payload, 0 means the maximum message
+	// size. Larger payloads fail to decode.
+	MaxSize uint32 `toml:"max_size"`
+	// Maximum number of nested wrappings stripped, e.g. 2 for base64
+	// encoded gzip data.
+	MaxLayers int `toml:"max_layers"`
+	// Name of a field listing the stripped wrappings, outermost first. Not
+	// set if empty.
+	EncodingField string `toml:"encoding_field"`
+}
+
+// Decoder that strips compression, zlib and base64 wrapping from message
+// payloads, so the payload can be passed on to another decoder in a
+// MultiDecoder chain. Payloads that aren't wrapped are passed through
+// untouched.
+type PayloadDecompressDecoder struct {
+	// Names of the compression codecs detected, in config order.
+	codecs  []string
+	zlib    bool
+	base64  bool
+	maxSize int64
+	conf    *PayloadDecompressDecoderConfig
+}
+
+func (pd *PayloadDecompressDecoder) ConfigStruct() interface{} {
+	return &PayloadDecompressDecoderConfig{
+		Encodings: []string{"gzip", "zlib", "base64"},
+		MaxLayers: 3,
+	}
+}
+
+func (pd *PayloadDecompressDecoder) Init(config interface{}) error {
+	conf := config.(*PayloadDecompressDecoderConfig)
+	if len(conf.Encodings) == 0 {
+		return fmt.Errorf("PayloadDecompressDecoder: no encodings specified")
+	}
+	pd.codecs = pd.codecs[:0]
+	for _, encoding := range conf.Encodings {
+		switch encoding = strings.ToLower(encoding); encoding {
+		case "zlib":
+			pd.zlib = true
+		case "base64":
+			pd.base64 = true
+		default:
+			// Only codecs with a magic number can be detected.
+			codec, _ := GetCompressionCodec(encoding)
+			if _, ok := codec.(MagicCodec); !ok {
+				return fmt.Errorf("PayloadDecompressDecoder: unknown encoding '%s'",
+					encoding)
+			}
+			pd.codecs = append(pd.codecs, encoding)
+		}
+	}
+	if conf.MaxLayers < 1 {
+		return fmt.Errorf("PayloadDecompressDecoder: max_layers must be at least 1")
+	}
+	pd.maxSize = int64(conf.MaxSize)
+	if pd.maxSize == 0 {
+		pd.maxSize = int64(message.MAX_MESSAGE_SIZE)
+	}
+	pd.conf = conf
+	return nil
+}
+
+func (pd *PayloadDecompressDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
+	err error) {
+
+	data := []byte(pack.Message.GetPayload())
+	var stripped []string
+	for len(stripped) < pd.conf.MaxLayers {
+		encoding := pd.detect(data)
+		if encoding == "" {
+			break
+		}
+		if data, err = pd.unwrap(encoding, data); err != nil {
+			return nil, fmt.Errorf("stripping %s: %s", encoding, err)
+		}
+		stripped = append(stripped, encoding)
+	}
+	if len(stripped) == 0 {
+		return []*PipelinePack{pack}, nil
+	}
+
+	pack.Message.SetPayload(string(data))
+	if pd.conf.EncodingField != "" {
+		var f *message.Field
+		if f, err = message.NewField(pd.conf.EncodingField,
+			strings.Join(stripped, ","), ""); err != nil {
+			return nil, err
+		}
+		pack.Message.AddField(f)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Returns the name of the wrapping the data is in, or "" if there's none.
+func (pd *PayloadDecompressDecoder) detect(data []byte) string {
+	for _, name := range pd.codecs {
+		if codec, _ := GetCompressionCodec(name); HasCompressionMagic(codec, data) {
+			return name
+		}
+	}
+	switch {
+	case pd.zlib && isZlib(data):
+		return "zlib"
+	case pd.base64 && isBase64(data):
+		return "base64"
+	}
+	return ""
+}
+
+// Checks for deflate compression with a 32KB window, which is what zlib
+// always writes, no preset dictionary, and a valid header checksum. Smaller
+// windows are legal, but would let too much plain text pass as zlib data.
+func isZlib(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x78 && data[1]&0x20 == 0 &&
+		(uint16(data[0])<<8|uint16(data[1]))%31 == 0
+}
+
+// Plain text can consist of base64 characters only, so data is only
+// considered base64 encoded if it decodes to compressed data or text.
+func isBase64(data []byte) bool {
+	decoded, err := decodeBase64(data)
+	if err != nil || len(decoded) == 0 {
+		return false
+	}
+	if name, _ := DetectCompressionCodec(decoded); name != "" || isZlib(decoded) {
+		return true
+	}
+	if !utf8.Valid(decoded) {
+		return false
+	}
+	for _, r := range string(decoded) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Decodes standard or URL safe base64, padded or not, ignoring line breaks.
+func decodeBase64(data []byte) ([]byte, error) {
+	s := strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, string(data))
+	if len(s) < 4 {
+		return nil, errors.New("too short")
+	}
+	if n := len(s) % 4; n != 0 {
+		s += strings.Repeat("=", 4-n)
+	}
+	if strings.ContainsAny(s, "-_") {
+		return base64.URLEncoding.DecodeString(s)
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+func (pd *PayloadDecompressDecoder) unwrap(encoding string, data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+	switch encoding {
+	case "base64":
+		return decodeBase64(data)
+	case "zlib":
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		var codec CompressionCodec
+		if codec, err = GetCompressionCodec(encoding); err == nil {
+			r, err = codec.NewReader(bytes.NewReader(data))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// Read one byte past the limit to tell whether it was exceeded.
+	unwrapped, err := ioutil.ReadAll(io.LimitReader(r, pd.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(unwrapped)) > pd.maxSize {
+		return nil, fmt.Errorf("payload expands to more than %d bytes", pd.maxSize)
+	}
+	return unwrapped, nil
+}
+
+func init() {
+	RegisterPlugin("PayloadDecompressDecoder", func() interface{} {
+		return new(PayloadDecompressDecoder)
+	})
+}
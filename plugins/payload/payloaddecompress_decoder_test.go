@@ -0,0 +1,157 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"strings"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func PayloadDecompressDecoderSpec(c gs.Context) {
+	decoder := new(PayloadDecompressDecoder)
+	conf := decoder.ConfigStruct().(*PayloadDecompressDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.String()
+	}
+	zlibbed := func(s string) string {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		w.Write([]byte(s))
+		w.Close()
+		return buf.String()
+	}
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	decode := func(payload string) ([]*PipelinePack, error) {
+		pack.Zero()
+		pack.Message.SetPayload(payload)
+		return decoder.Decode(pack)
+	}
+
+	c.Specify("A PayloadDecompressDecoder", func() {
+		c.Specify("rejects unknown encodings", func() {
+			conf.Encodings = []string{"gzip", "lzma"}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with the default config", func() {
+			conf.EncodingField = "Encoding"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("strips gzip", func() {
+				packs, err := decode(gzipped("hello world"))
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello world")
+				val, _ := pack.Message.GetFieldValue("Encoding")
+				c.Expect(val, gs.Equals, "gzip")
+			})
+
+			c.Specify("strips zlib", func() {
+				_, err := decode(zlibbed("hello world"))
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello world")
+			})
+
+			c.Specify("strips nested wrappings", func() {
+				_, err := decode(b64(gzipped("hello world")))
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello world")
+				val, _ := pack.Message.GetFieldValue("Encoding")
+				c.Expect(val, gs.Equals, "base64,gzip")
+			})
+
+			c.Specify("decodes base64 text", func() {
+				_, err := decode(b64("hello world"))
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello world")
+			})
+
+			c.Specify("passes plain payloads through", func() {
+				for _, payload := range []string{"hello world", "testtest", "x"} {
+					packs, err := decode(payload)
+					c.Expect(err, gs.IsNil)
+					c.Expect(len(packs), gs.Equals, 1)
+					c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+					c.Expect(pack.Message.FindFirstField("Encoding"), gs.IsNil)
+				}
+			})
+
+			c.Specify("fails on corrupt data", func() {
+				data := gzipped("hello world")
+				_, err := decode(data[:len(data)-6])
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("caps the expanded size", func() {
+			conf.MaxSize = 100
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decode(gzipped(strings.Repeat("a", 100)))
+			c.Expect(err, gs.IsNil)
+			_, err = decode(gzipped(strings.Repeat("a", 101)))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("only strips the configured encodings", func() {
+			conf.Encodings = []string{"gzip"}
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			payload := b64(gzipped("hello world"))
+			_, err = decode(payload)
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetPayload(), gs.Equals, payload)
+		})
+
+		c.Specify("strips other registered codecs", func() {
+			conf.Encodings = []string{"snappy", "base64"}
+			conf.EncodingField = "Encoding"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			codec, err := GetCompressionCodec("snappy")
+			c.Assume(err, gs.IsNil)
+			compressed, err := Compress(codec, []byte("hello world"))
+			c.Assume(err, gs.IsNil)
+			_, err = decode(b64(string(compressed)))
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "hello world")
+			val, _ := pack.Message.GetFieldValue("Encoding")
+			c.Expect(val, gs.Equals, "base64,snappy")
+		})
+
+		c.Specify("stops after max_layers", func() {
+			conf.MaxLayers = 1
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decode(b64(gzipped("hello world")))
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetPayload(), gs.Equals, gzipped("hello world"))
+		})
+	})
+}
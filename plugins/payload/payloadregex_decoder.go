@@ -17,6 +17,7 @@ package payload
 
 import (
 	"fmt"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"regexp"
 	"time"
@@ -49,8 +50,33 @@ type PayloadRegexDecoderConfig struct {
 	// since those can be parsed as specified in the `timestamp_layout`.
 	TimestampLocation string `toml:"timestamp_location"`
 
+	// Layouts tried in order, after `timestamp_layout` if set, to parse
+	// timestamps. If set, timestamps are only parsed with the configured
+	// layouts rather than also with all of the default ones.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Field holding the timestamps that can't be parsed, which then keep the
+	// time they were received at instead of causing an error.
+	TimestampFailureField string `toml:"timestamp_failure_field"`
+
 	// Whether payloads that do not match the regex should be logged.
 	LogErrors bool `toml:"log_errors"`
+
+	// Names of message fields whose values should be parsed as numbers and
+	// stored as doubles or integers respectively, rather than as strings.
+	DoubleFields  []string `toml:"double_fields"`
+	IntegerFields []string `toml:"integer_fields"`
+
+	// Character used as the decimal separator in numeric field values.
+	// Defaults to ".".
+	DecimalSeparator string `toml:"decimal_separator"`
+
+	// Characters that may be used to group digits in numeric field values,
+	// e.g. "." for "1.234,56" or " " for "1 234,56".
+	GroupSeparators string `toml:"group_separators"`
+
+	// Typed message fields for named capture groups, keyed by capture name.
+	CaptureFields map[string]CaptureField `toml:"capture_fields"`
 }
 
 type PayloadRegexDecoder struct {
@@ -59,8 +85,14 @@ type PayloadRegexDecoder struct {
 	MessageFields   MessageTemplate
 	TimestampLayout string
 	tzLocation      *time.Location
+	tsParser        *message.TimestampParser
+	tsFailureField  string
 	dRunner         DecoderRunner
 	logErrors       bool
+	numberFormat    *NumberFormat
+	doubleFields    []string
+	integerFields   []string
+	captureFields   map[string]CaptureField
 }
 
 func (ld *PayloadRegexDecoder) ConfigStruct() interface{} {
@@ -97,7 +129,28 @@ func (ld *PayloadRegexDecoder) Init(config interface{}) (err error) {
 		err = fmt.Errorf("PayloadRegexDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
 	}
+	if err != nil {
+		return
+	}
 	ld.logErrors = conf.LogErrors
+	if ld.tsParser, err = newTimestampParser(conf.TimestampLayout,
+		conf.TimestampLayouts, conf.TimestampLocation,
+		conf.TimestampFailureField); err != nil {
+		err = fmt.Errorf("PayloadRegexDecoder: %s", err)
+		return
+	}
+	ld.tsFailureField = conf.TimestampFailureField
+	if ld.numberFormat, err = NewNumberFormat(conf.DecimalSeparator,
+		conf.GroupSeparators); err != nil {
+		err = fmt.Errorf("PayloadRegexDecoder: %s", err)
+		return
+	}
+	ld.doubleFields = conf.DoubleFields
+	ld.integerFields = conf.IntegerFields
+	if ld.captureFields, err = prepCaptureFields(conf.CaptureFields,
+		ld.Match.SubexpNames()); err != nil {
+		err = fmt.Errorf("PayloadRegexDecoder: %s", err)
+	}
 	return
 }
 
@@ -141,11 +194,13 @@ func (ld *PayloadRegexDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 	}
 
 	pdh := &PayloadDecoderHelper{
-		Captures:        captures,
-		dRunner:         ld.dRunner,
-		TimestampLayout: ld.TimestampLayout,
-		TzLocation:      ld.tzLocation,
-		SeverityMap:     ld.SeverityMap,
+		Captures:              captures,
+		dRunner:               ld.dRunner,
+		TimestampLayout:       ld.TimestampLayout,
+		TzLocation:            ld.tzLocation,
+		SeverityMap:           ld.SeverityMap,
+		TimestampParser:       ld.tsParser,
+		TimestampFailureField: ld.tsFailureField,
 	}
 
 	pdh.DecodeTimestamp(pack)
@@ -153,7 +208,15 @@ func (ld *PayloadRegexDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 
 	// Update the new message fields based on the fields we should
 	// change and the capture parts
-	if err = ld.MessageFields.PopulateMessage(pack.Message, captures); err == nil {
+	if err = ld.MessageFields.PopulateMessage(pack.Message, captures); err != nil {
+		return
+	}
+	if err = addCaptureFields(pack.Message, ld.captureFields, captures,
+		ld.numberFormat, ld.tzLocation); err != nil {
+		return
+	}
+	if err = ld.numberFormat.ConvertFields(pack.Message, ld.doubleFields,
+		ld.integerFields); err == nil {
 		packs = []*PipelinePack{pack}
 	}
 	return
@@ -17,6 +17,7 @@ package payload
 import (
 	"fmt"
 	"github.com/crankycoder/xmlpath"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	"strings"
 	"time"
@@ -47,6 +48,15 @@ type PayloadXmlDecoderConfig struct {
 	// required if valid time zone info is embedded in every parsed timestamp,
 	// since those can be parsed as specified in the `timestamp_layout`.
 	TimestampLocation string `toml:"timestamp_location"`
+
+	// Layouts tried in order, after `timestamp_layout` if set, to parse
+	// timestamps. If set, timestamps are only parsed with the configured
+	// layouts rather than also with all of the default ones.
+	TimestampLayouts []string `toml:"timestamp_layouts"`
+
+	// Field holding the timestamps that can't be parsed, which then keep the
+	// time they were received at instead of causing an error.
+	TimestampFailureField string `toml:"timestamp_failure_field"`
 }
 
 type PayloadXmlDecoder struct {
@@ -55,6 +65,8 @@ type PayloadXmlDecoder struct {
 	MessageFields   MessageTemplate
 	TimestampLayout string
 	tzLocation      *time.Location
+	tsParser        *message.TimestampParser
+	tsFailureField  string
 	dRunner         DecoderRunner
 }
 
@@ -89,6 +101,16 @@ func (pxd *PayloadXmlDecoder) Init(config interface{}) (err error) {
 		err = fmt.Errorf("PayloadXmlDecoder unknown timestamp_location '%s': %s",
 			conf.TimestampLocation, err)
 	}
+	if err != nil {
+		return
+	}
+	if pxd.tsParser, err = newTimestampParser(conf.TimestampLayout,
+		conf.TimestampLayouts, conf.TimestampLocation,
+		conf.TimestampFailureField); err != nil {
+		err = fmt.Errorf("PayloadXmlDecoder: %s", err)
+		return
+	}
+	pxd.tsFailureField = conf.TimestampFailureField
 	return
 }
 
@@ -128,11 +150,13 @@ func (pxd *PayloadXmlDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
 	captures := pxd.match(pack.Message.GetPayload())
 
 	pdh := &PayloadDecoderHelper{
-		Captures:        captures,
-		dRunner:         pxd.dRunner,
-		TimestampLayout: pxd.TimestampLayout,
-		TzLocation:      pxd.tzLocation,
-		SeverityMap:     pxd.SeverityMap,
+		Captures:              captures,
+		dRunner:               pxd.dRunner,
+		TimestampLayout:       pxd.TimestampLayout,
+		TzLocation:            pxd.tzLocation,
+		SeverityMap:           pxd.SeverityMap,
+		TimestampParser:       pxd.tsParser,
+		TimestampFailureField: pxd.tsFailureField,
 	}
 
 	pdh.DecodeTimestamp(pack)
@@ -0,0 +1,316 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type SyslogDecoderConfig struct {
+	// One of "rfc3164", "rfc5424" or "auto", which tells the formats apart by
+	// the version following the PRI. Defaults to "auto".
+	Format string
+	// Time zone of RFC3164 timestamps, which don't include one. Defaults to
+	// "UTC".
+	TimestampLocation string `toml:"timestamp_location"`
+	// Prefix of the fields structured data parameters are stored in, each
+	// named <prefix><SD-ID>.<PARAM-NAME>. Defaults to "sd.".
+	StructuredDataPrefix string `toml:"structured_data_prefix"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+}
+
+// Decoder for syslog records in RFC3164 (BSD) or RFC5424 format, one record
+// per payload. The header sets the message's timestamp, hostname, severity
+// and pid and becomes `facility`, `app_name`, `procid` and `msgid` fields,
+// the MSG part becomes the payload.
+type SyslogDecoder struct {
+	conf       *SyslogDecoderConfig
+	tzLocation *time.Location
+}
+
+// The parts of a syslog record, empty if absent or NILVALUE.
+type syslogRecord struct {
+	facility  int32
+	severity  int32
+	timestamp time.Time
+	hostname  string
+	appName   string
+	procId    string
+	msgId     string
+	sd        [][3]string // SD-ID, PARAM-NAME, PARAM-VALUE
+	msg       string
+}
+
+func (sd *SyslogDecoder) ConfigStruct() interface{} {
+	return &SyslogDecoderConfig{
+		Format:               "auto",
+		StructuredDataPrefix: "sd.",
+	}
+}
+
+func (sd *SyslogDecoder) Init(config interface{}) (err error) {
+	conf := config.(*SyslogDecoderConfig)
+	switch conf.Format {
+	case "auto", "rfc3164", "rfc5424":
+	default:
+		return fmt.Errorf("SyslogDecoder: unknown format '%s'", conf.Format)
+	}
+	if sd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
+		return fmt.Errorf("SyslogDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	sd.conf = conf
+	return nil
+}
+
+func (sd *SyslogDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	payload := strings.TrimRight(pack.Message.GetPayload(), "\r\n\x00")
+	if payload == "" {
+		return nil, nil
+	}
+	pri, rest, err := parsePri(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid record: %s: %s", err, payload)
+	}
+	format := sd.conf.Format
+	if format == "auto" {
+		format = "rfc3164"
+		if strings.HasPrefix(rest, "1 ") {
+			format = "rfc5424"
+		}
+	}
+	var rec *syslogRecord
+	if format == "rfc5424" {
+		rec, err = parseRfc5424(rest)
+	} else {
+		rec, err = parseRfc3164(rest, sd.tzLocation, time.Now())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s record: %s: %s", format, err, payload)
+	}
+	rec.facility, rec.severity = pri/8, pri%8
+
+	msg := pack.Message
+	msg.SetSeverity(rec.severity)
+	if !rec.timestamp.IsZero() {
+		msg.SetTimestamp(rec.timestamp.UnixNano())
+	}
+	if rec.hostname != "" {
+		msg.SetHostname(rec.hostname)
+	}
+	if pid, e := strconv.ParseInt(rec.procId, 10, 32); e == nil {
+		msg.SetPid(int32(pid))
+	}
+	msg.SetPayload(rec.msg)
+	message.NewInt64Field(msg, "facility", int64(rec.facility), "")
+	for _, f := range [][2]string{
+		{"app_name", rec.appName},
+		{"procid", rec.procId},
+		{"msgid", rec.msgId},
+	} {
+		if f[1] != "" {
+			message.NewStringField(msg, f[0], f[1])
+		}
+	}
+	for _, param := range rec.sd {
+		message.NewStringField(msg,
+			sd.conf.StructuredDataPrefix+param[0]+"."+param[1], param[2])
+	}
+	if sd.conf.MessageType != "" {
+		msg.SetType(sd.conf.MessageType)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Splits the `<PRI>` off a record.
+func parsePri(s string) (pri int32, rest string, err error) {
+	end := strings.IndexByte(s, '>')
+	if len(s) < 3 || s[0] != '<' || end < 2 || end > 4 {
+		return 0, "", errors.New("missing PRI")
+	}
+	n, err := strconv.ParseUint(s[1:end], 10, 8)
+	if err != nil || n > 191 || (end > 2 && s[1] == '0') {
+		return 0, "", fmt.Errorf("bad PRI '%s'", s[:end+1])
+	}
+	return int32(n), s[end+1:], nil
+}
+
+// Splits the next space separated header field off, returning "" for the
+// NILVALUE.
+func nextSyslogField(s string) (field, rest string, err error) {
+	end := strings.IndexByte(s, ' ')
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return "", "", errors.New("missing header field")
+	}
+	field, rest = s[:end], s[end:]
+	if rest != "" {
+		rest = rest[1:]
+	}
+	if field == "-" {
+		field = ""
+	}
+	return field, rest, nil
+}
+
+func parseRfc5424(s string) (rec *syslogRecord, err error) {
+	rec = new(syslogRecord)
+	fields := make([]string, 6)
+	for i := range fields {
+		if fields[i], s, err = nextSyslogField(s); err != nil {
+			return nil, err
+		}
+	}
+	if fields[0] != "1" {
+		return nil, fmt.Errorf("unsupported version '%s'", fields[0])
+	}
+	if fields[1] != "" {
+		if rec.timestamp, err = time.Parse(time.RFC3339Nano, fields[1]); err != nil {
+			return nil, fmt.Errorf("bad timestamp '%s'", fields[1])
+		}
+	}
+	rec.hostname, rec.appName, rec.procId, rec.msgId = fields[2], fields[3],
+		fields[4], fields[5]
+
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		if rec.sd, s, err = parseStructuredData(s); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.New("missing structured data")
+	}
+	if s != "" {
+		if s[0] != ' ' {
+			return nil, errors.New("no space after structured data")
+		}
+		s = strings.TrimPrefix(s[1:], "\ufeff")
+	}
+	rec.msg = s
+	return rec, nil
+}
+
+// Parses the `[id param="value" ...]` elements, returning the parameters
+// and the rest of the record.
+func parseStructuredData(s string) (params [][3]string, rest string, err error) {
+	for strings.HasPrefix(s, "[") {
+		s = s[1:]
+		end := strings.IndexAny(s, " ]")
+		if end <= 0 {
+			return nil, "", errors.New("bad SD-ID")
+		}
+		id := s[:end]
+		s = s[end:]
+		for strings.HasPrefix(s, " ") {
+			s = s[1:]
+			eq := strings.Index(s, "=\"")
+			if eq <= 0 {
+				return nil, "", fmt.Errorf("bad SD-PARAM in '%s'", id)
+			}
+			name := s[:eq]
+			s = s[eq+2:]
+			var value []byte
+			i := 0
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) &&
+					(s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+					i++
+				}
+				value = append(value, s[i])
+			}
+			if i == len(s) {
+				return nil, "", fmt.Errorf("unterminated value of '%s' in '%s'",
+					name, id)
+			}
+			params = append(params, [3]string{id, name, string(value)})
+			s = s[i+1:]
+		}
+		if !strings.HasPrefix(s, "]") {
+			return nil, "", fmt.Errorf("unterminated SD-ELEMENT '%s'", id)
+		}
+		s = s[1:]
+	}
+	return params, s, nil
+}
+
+// Parses the BSD syslog format, `Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG`,
+// where the timestamp may also be RFC3339 as written by rsyslog, and the
+// hostname may be missing.
+func parseRfc3164(s string, loc *time.Location, now time.Time) (
+	rec *syslogRecord, err error) {
+
+	rec = new(syslogRecord)
+	if len(s) >= len(time.Stamp) {
+		if t, e := time.ParseInLocation(time.Stamp, s[:len(time.Stamp)], loc); e == nil {
+			t = message.CompleteDate(t)
+			// A December record received in January is from last year.
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			rec.timestamp = t
+			s = strings.TrimPrefix(s[len(time.Stamp):], " ")
+		}
+	}
+	if rec.timestamp.IsZero() {
+		if end := strings.IndexByte(s, ' '); end > 0 {
+			if t, e := time.Parse(time.RFC3339Nano, s[:end]); e == nil {
+				rec.timestamp = t
+				s = s[end+1:]
+			}
+		}
+	}
+	if rec.timestamp.IsZero() {
+		// No header, it's all MSG.
+		rec.msg = s
+		return rec, nil
+	}
+
+	// A hostname is followed by a space and isn't itself a tag.
+	if end := strings.IndexByte(s, ' '); end > 0 && !strings.ContainsAny(s[:end], ":[") {
+		rec.hostname = s[:end]
+		s = s[end+1:]
+	}
+	// TAG[PID]: or TAG:
+	if end := strings.IndexAny(s, ":[ "); end > 0 && s[end] != ' ' {
+		tag, rest := s[:end], s[end:]
+		if rest[0] == '[' {
+			closing := strings.Index(rest, "]:")
+			if closing < 0 {
+				rec.msg = s
+				return rec, nil
+			}
+			rec.procId = rest[1:closing]
+			rest = rest[closing+1:]
+		}
+		rec.appName = tag
+		s = strings.TrimPrefix(rest[1:], " ")
+	}
+	rec.msg = s
+	return rec, nil
+}
+
+func init() {
+	RegisterPlugin("SyslogDecoder", func() interface{} {
+		return new(SyslogDecoder)
+	})
+}
@@ -0,0 +1,138 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SyslogDecoderSpec(c gs.Context) {
+	decoder := new(SyslogDecoder)
+	conf := decoder.ConfigStruct().(*SyslogDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	decode := func(payload string) ([]*PipelinePack, error) {
+		pack.Zero()
+		pack.Message.SetPayload(payload)
+		return decoder.Decode(pack)
+	}
+
+	c.Specify("A SyslogDecoder", func() {
+		c.Specify("rejects unknown formats", func() {
+			conf.Format = "rfc1234"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with the default config", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("decodes RFC5424 records", func() {
+				packs, err := decode(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com ` +
+					`evntslog 8710 ID47 [exampleSDID@32473 iut="3" eventSource=` +
+					`"Application" eventID="1011"][examplePriority@32473 class="high"] ` +
+					"\ufeffAn application event log entry...\n")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				msg := pack.Message
+				c.Expect(msg.GetSeverity(), gs.Equals, int32(5))
+				c.Expect(field("facility"), gs.Equals, int64(20))
+				c.Expect(msg.GetTimestamp(), gs.Equals, int64(1065910455003000000))
+				c.Expect(msg.GetHostname(), gs.Equals, "mymachine.example.com")
+				c.Expect(msg.GetPid(), gs.Equals, int32(8710))
+				c.Expect(msg.GetPayload(), gs.Equals, "An application event log entry...")
+				c.Expect(field("app_name"), gs.Equals, "evntslog")
+				c.Expect(field("procid"), gs.Equals, "8710")
+				c.Expect(field("msgid"), gs.Equals, "ID47")
+				c.Expect(field("sd.exampleSDID@32473.iut"), gs.Equals, "3")
+				c.Expect(field("sd.exampleSDID@32473.eventSource"), gs.Equals, "Application")
+				c.Expect(field("sd.examplePriority@32473.class"), gs.Equals, "high")
+			})
+
+			c.Specify("decodes RFC5424 records with nil values and escapes", func() {
+				_, err := decode(`<34>1 - - su - - [meta note="a \"quoted\\ \] value"]`)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(2))
+				c.Expect(pack.Message.GetHostname(), gs.Equals, "")
+				c.Expect(field("app_name"), gs.Equals, "su")
+				c.Expect(field("procid"), gs.IsNil)
+				c.Expect(field("sd.meta.note"), gs.Equals, `a "quoted\ ] value`)
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+			})
+
+			c.Specify("decodes RFC3164 records", func() {
+				_, err := decode("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed " +
+					"for lonvick on /dev/pts/8")
+				c.Expect(err, gs.IsNil)
+				msg := pack.Message
+				c.Expect(msg.GetSeverity(), gs.Equals, int32(2))
+				c.Expect(field("facility"), gs.Equals, int64(4))
+				c.Expect(msg.GetHostname(), gs.Equals, "mymachine")
+				c.Expect(msg.GetPid(), gs.Equals, int32(123))
+				c.Expect(field("app_name"), gs.Equals, "su")
+				c.Expect(msg.GetPayload(), gs.Equals, "'su root' failed for lonvick on /dev/pts/8")
+				ts := time.Unix(0, msg.GetTimestamp()).UTC()
+				c.Expect(ts.Format("Jan _2 15:04:05"), gs.Equals, "Oct 11 22:14:15")
+			})
+
+			c.Specify("decodes RFC3164 records with RFC3339 timestamps and no hostname", func() {
+				_, err := decode("<13>2016-03-01T10:00:00+01:00 cron: job done")
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1456822800000000000))
+				c.Expect(pack.Message.GetHostname(), gs.Equals, "")
+				c.Expect(field("app_name"), gs.Equals, "cron")
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "job done")
+			})
+
+			c.Specify("keeps records without a header as the message", func() {
+				_, err := decode("<13>just some text")
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(5))
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "just some text")
+			})
+
+			c.Specify("fails on a missing or bad PRI", func() {
+				_, err := decode("Oct 11 22:14:15 mymachine su: failed")
+				c.Expect(err, gs.Not(gs.IsNil))
+				_, err = decode("<192>Oct 11 22:14:15 mymachine su: failed")
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("fails on malformed structured data", func() {
+				_, err := decode(`<165>1 - host app - - [id x="unterminated]`)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		c.Specify("puts RFC3164 records in the configured location", func() {
+			conf.Format = "rfc3164"
+			conf.TimestampLocation = "America/New_York"
+			conf.MessageType = "syslog"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			_, err = decode("<34>Jul  4 12:00:00 host app: hi")
+			c.Expect(err, gs.IsNil)
+			c.Expect(pack.Message.GetType(), gs.Equals, "syslog")
+			ts := time.Unix(0, pack.Message.GetTimestamp()).UTC()
+			c.Expect(ts.Hour(), gs.Equals, 16)
+		})
+	})
+}
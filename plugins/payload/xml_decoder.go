@@ -0,0 +1,197 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crankycoder/xmlpath"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Describes how a message field is extracted from the XML.
+type XmlField struct {
+	// XPath expression selecting the field's value.
+	XPath string `toml:"xpath"`
+	// One of "string", "int", "float", "bool" or "timestamp". Defaults to
+	// "string".
+	Type string
+	// Layout used to parse "timestamp" values, see `timestamp_layout`.
+	Layout string
+	// Representation of the field, defaults to "ns" for timestamps.
+	Representation string
+	// Whether the values of all of the selected nodes are stored, as a
+	// multi-value field, rather than only the first one.
+	All bool
+}
+
+type XmlDecoderConfig struct {
+	// Message fields to extract, keyed by field name.
+	Fields map[string]XmlField
+	// XPath expression selecting the message timestamp, if any.
+	TimestampXPath    string `toml:"timestamp_xpath"`
+	TimestampLayout   string `toml:"timestamp_layout"`
+	TimestampLocation string `toml:"timestamp_location"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+	// Whether the original XML is kept as the message payload.
+	PayloadKeep bool `toml:"payload_keep"`
+}
+
+type xmlField struct {
+	CaptureField
+	path *xmlpath.Path
+	all  bool
+}
+
+// Decoder that extracts values from an XML document in the message payload
+// into typed message fields, using an XPath expression per field.
+type XmlDecoder struct {
+	fields       []xmlField
+	tsPath       *xmlpath.Path
+	tsLayout     string
+	tzLocation   *time.Location
+	numberFormat *NumberFormat
+	msgType      string
+	payloadKeep  bool
+}
+
+func (xd *XmlDecoder) ConfigStruct() interface{} {
+	return new(XmlDecoderConfig)
+}
+
+func (xd *XmlDecoder) Init(config interface{}) (err error) {
+	conf := config.(*XmlDecoderConfig)
+	if len(conf.Fields) == 0 && conf.TimestampXPath == "" {
+		return errors.New("XmlDecoder: no `fields` configured")
+	}
+	// Sorted, so the message fields are always added in the same order.
+	names := make([]string, 0, len(conf.Fields))
+	for name := range conf.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	xd.fields = make([]xmlField, len(names))
+	for i, name := range names {
+		xf := conf.Fields[name]
+		field := &xd.fields[i]
+		field.CaptureField, err = CaptureField{
+			Field:          name,
+			Type:           xf.Type,
+			Layout:         xf.Layout,
+			Representation: xf.Representation,
+		}.prep(name)
+		if err != nil {
+			return fmt.Errorf("XmlDecoder fields: %s", err)
+		}
+		if field.path, err = compileXPath(xf.XPath); err != nil {
+			return fmt.Errorf("XmlDecoder field '%s': %s", name, err)
+		}
+		field.all = xf.All
+	}
+	if conf.TimestampXPath != "" {
+		if xd.tsPath, err = compileXPath(conf.TimestampXPath); err != nil {
+			return fmt.Errorf("XmlDecoder timestamp_xpath: %s", err)
+		}
+	}
+	xd.tsLayout = conf.TimestampLayout
+	if xd.tzLocation, err = time.LoadLocation(conf.TimestampLocation); err != nil {
+		return fmt.Errorf("XmlDecoder unknown timestamp_location '%s': %s",
+			conf.TimestampLocation, err)
+	}
+	xd.numberFormat, _ = NewNumberFormat("", "")
+	xd.msgType = conf.MessageType
+	xd.payloadKeep = conf.PayloadKeep
+	return nil
+}
+
+func compileXPath(expr string) (*xmlpath.Path, error) {
+	if expr == "" {
+		return nil, errors.New("missing xpath")
+	}
+	return xmlpath.Compile(expr)
+}
+
+func (xd *XmlDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack, err error) {
+	root, err := xmlpath.Parse(strings.NewReader(pack.Message.GetPayload()))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid XML: %s", err)
+	}
+	msg := pack.Message
+	if xd.tsPath != nil {
+		if s, ok := xd.tsPath.String(root); ok {
+			t, err := message.ForgivingTimeParse(xd.tsLayout, strings.TrimSpace(s),
+				xd.tzLocation)
+			if err != nil {
+				return nil, fmt.Errorf("timestamp: %s", err)
+			}
+			msg.SetTimestamp(t.UnixNano())
+		}
+	}
+	for _, xf := range xd.fields {
+		if err = xf.populate(msg, root, xd.numberFormat, xd.tzLocation); err != nil {
+			return nil, fmt.Errorf("field '%s': %s", xf.Field, err)
+		}
+	}
+	if xd.msgType != "" {
+		msg.SetType(xd.msgType)
+	}
+	if !xd.payloadKeep {
+		msg.SetPayload("")
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Adds the value of the first node selected by the field's path, or the
+// values of all of them, to the message. Empty values are skipped, as is the
+// field if no node is selected.
+func (xf *xmlField) populate(msg *message.Message, root *xmlpath.Node,
+	nf *NumberFormat, loc *time.Location) error {
+
+	var field *message.Field
+	iter := xf.path.Iter(root)
+	for iter.Next() {
+		s := strings.TrimSpace(iter.Node().String())
+		if s == "" {
+			continue
+		}
+		val, err := xf.value(s, nf, loc)
+		if err != nil {
+			return err
+		}
+		if field == nil {
+			if field, err = message.NewField(xf.Field, val, xf.Representation); err != nil {
+				return err
+			}
+		} else if err = field.AddValue(val); err != nil {
+			return err
+		}
+		if !xf.all {
+			break
+		}
+	}
+	if field != nil {
+		msg.AddField(field)
+	}
+	return nil
+}
+
+func init() {
+	RegisterPlugin("XmlDecoder", func() interface{} {
+		return new(XmlDecoder)
+	})
+}
@@ -0,0 +1,100 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package payload
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+const windowsEventXml = `<Event>
+  <System>
+    <Provider Name="Service Control Manager"/>
+    <EventID>7036</EventID>
+    <Level>4</Level>
+    <TimeCreated SystemTime="2016-03-01T10:20:30.5Z"/>
+    <Computer>WIN-SRV01</Computer>
+  </System>
+  <EventData>
+    <Data Name="param1">Windows Update</Data>
+    <Data Name="param2">running</Data>
+  </EventData>
+</Event>`
+
+func XmlDecoderSpec(c gs.Context) {
+	decoder := new(XmlDecoder)
+	conf := decoder.ConfigStruct().(*XmlDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+
+	c.Specify("An XmlDecoder", func() {
+		c.Specify("requires fields", func() {
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects invalid XPath expressions", func() {
+			conf.Fields = map[string]XmlField{"id": {XPath: "/Event/["}}
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with fields", func() {
+			conf.Fields = map[string]XmlField{
+				"EventID":  {XPath: "/Event/System/EventID", Type: "int"},
+				"Provider": {XPath: "/Event/System/Provider/@Name"},
+				"Computer": {XPath: "//Computer"},
+				"Data":     {XPath: "/Event/EventData/Data", All: true},
+				"Missing":  {XPath: "/Event/System/Keywords"},
+			}
+			conf.TimestampXPath = "/Event/System/TimeCreated/@SystemTime"
+			conf.MessageType = "windows.event"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("decodes XML documents", func() {
+				pack.Message.SetPayload(windowsEventXml)
+				packs, err := decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(pack.Message.GetType(), gs.Equals, "windows.event")
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "")
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1456827630500000000))
+				c.Expect(field("EventID"), gs.Equals, int64(7036))
+				c.Expect(field("Provider"), gs.Equals, "Service Control Manager")
+				c.Expect(field("Computer"), gs.Equals, "WIN-SRV01")
+				c.Expect(field("Missing"), gs.IsNil)
+				data := pack.Message.FindFirstField("Data").GetValueString()
+				c.Expect(len(data), gs.Equals, 2)
+				c.Expect(data[0], gs.Equals, "Windows Update")
+				c.Expect(data[1], gs.Equals, "running")
+			})
+
+			c.Specify("fails on invalid XML", func() {
+				pack.Message.SetPayload("<Event><System>")
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("fails on values of the wrong type", func() {
+				pack.Message.SetPayload("<Event><System><EventID>x</EventID></System></Event>")
+				_, err := decoder.Decode(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+	})
+}
@@ -0,0 +1,261 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package protobuf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type ProtobufSchemaDecoderConfig struct {
+	// Path of the FileDescriptorSet holding the message type, as written by
+	// `protoc --include_imports --descriptor_set_out`.
+	DescriptorSet string `toml:"descriptor_set"`
+	// Fully qualified name of the message type of the payloads.
+	MessageName string `toml:"message_name"`
+	// Separator between the names of nested message and map fields.
+	// Defaults to ".".
+	FlattenSeparator string `toml:"flatten_separator"`
+	// Message field whose value sets the message timestamp, if any.
+	TimestampField string `toml:"timestamp_field"`
+	// Unit of numeric timestamps, one of "s", "ms", "us" or "ns". Defaults
+	// to "ms".
+	TimestampUnit string `toml:"timestamp_unit"`
+	// Message type set on decoded messages, if any.
+	MessageType string `toml:"message_type"`
+}
+
+// Decoder for payloads holding a protobuf encoded message of a type described
+// by a user provided descriptor set. The message's fields become typed
+// message fields, nested messages and maps are flattened.
+type ProtobufSchemaDecoder struct {
+	conf    *ProtobufSchemaDecoderConfig
+	schema  *protoSchema
+	typ     *protoMessageType
+	tsScale int64
+	pConfig *PipelineConfig
+}
+
+var protoTimestampScales = map[string]int64{
+	"s":  1e9,
+	"ms": 1e6,
+	"us": 1e3,
+	"ns": 1,
+}
+
+func (pd *ProtobufSchemaDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	pd.pConfig = pConfig
+}
+
+func (pd *ProtobufSchemaDecoder) ConfigStruct() interface{} {
+	return &ProtobufSchemaDecoderConfig{
+		FlattenSeparator: ".",
+		TimestampUnit:    "ms",
+	}
+}
+
+func (pd *ProtobufSchemaDecoder) Init(config interface{}) (err error) {
+	pd.conf = config.(*ProtobufSchemaDecoderConfig)
+	if pd.conf.DescriptorSet == "" {
+		return errors.New("ProtobufSchemaDecoder: `descriptor_set` must be set")
+	}
+	if pd.conf.MessageName == "" {
+		return errors.New("ProtobufSchemaDecoder: `message_name` must be set")
+	}
+	path := pd.conf.DescriptorSet
+	if pd.pConfig != nil {
+		path = pd.pConfig.Globals.PrependShareDir(path)
+	}
+	if pd.schema, err = loadProtoSchema(path); err != nil {
+		return fmt.Errorf("ProtobufSchemaDecoder: %s: %s", path, err)
+	}
+	if pd.typ, err = pd.schema.messageType(pd.conf.MessageName); err != nil {
+		return fmt.Errorf("ProtobufSchemaDecoder: %s", err)
+	}
+	var ok bool
+	if pd.tsScale, ok = protoTimestampScales[pd.conf.TimestampUnit]; !ok {
+		return fmt.Errorf("ProtobufSchemaDecoder: unknown timestamp_unit '%s'",
+			pd.conf.TimestampUnit)
+	}
+	return nil
+}
+
+func (pd *ProtobufSchemaDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
+	err error) {
+
+	reader := &protoReader{schema: pd.schema, data: []byte(pack.Message.GetPayload())}
+	rec, err := reader.readMessage(pd.typ)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid protobuf data: %s", err)
+	}
+
+	msg := pack.Message
+	msg.SetPayload("")
+	if err = pd.addFields(msg, "", rec); err != nil {
+		return nil, err
+	}
+	if pd.conf.MessageType != "" {
+		msg.SetType(pd.conf.MessageType)
+	}
+	return []*PipelinePack{pack}, nil
+}
+
+// Adds the set fields of the record to the message, named prefix + field
+// name.
+func (pd *ProtobufSchemaDecoder) addFields(msg *message.Message, prefix string,
+	rec *protoRecordValue) error {
+
+	for i, f := range rec.typ.fields {
+		name := prefix + f.name
+		if name == pd.conf.TimestampField {
+			if err := pd.setTimestamp(msg, rec.values[i]); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			continue
+		}
+		if err := pd.addField(msg, name, rec.values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pd *ProtobufSchemaDecoder) addField(msg *message.Message, name string,
+	v interface{}) error {
+
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case *protoRecordValue:
+		return pd.addFields(msg, name+pd.conf.FlattenSeparator, value)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := pd.addField(msg, name+pd.conf.FlattenSeparator+k,
+				value[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		return pd.addRepeated(msg, name, value)
+	}
+	f, err := message.NewField(name, fieldValue(v), "")
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+// Repeated scalars become a field with multiple values, repeated messages a
+// field holding them as a JSON array.
+func (pd *ProtobufSchemaDecoder) addRepeated(msg *message.Message, name string,
+	values []interface{}) error {
+
+	var (
+		f    *message.Field
+		data []byte
+		err  error
+	)
+	if _, ok := values[0].(*protoRecordValue); ok {
+		if data, err = json.Marshal(protoJSONValue(values)); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		f, err = message.NewField(name, string(data), "")
+	} else {
+		f, err = message.NewField(name, fieldValue(values[0]), "")
+		for _, v := range values[1:] {
+			if err != nil {
+				break
+			}
+			err = f.AddValue(fieldValue(v))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	msg.AddField(f)
+	return nil
+}
+
+// Timestamps are stored as RFC 3339 strings.
+func fieldValue(v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// Converts records to maps, so they can be marshaled to JSON.
+func protoJSONValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case *protoRecordValue:
+		m := make(map[string]interface{}, len(value.values))
+		for i, f := range value.typ.fields {
+			if value.values[i] != nil {
+				m[f.name] = protoJSONValue(value.values[i])
+			}
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, val := range value {
+			m[k] = protoJSONValue(val)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, val := range value {
+			list[i] = protoJSONValue(val)
+		}
+		return list
+	}
+	return v
+}
+
+func (pd *ProtobufSchemaDecoder) setTimestamp(msg *message.Message, v interface{}) error {
+	switch t := v.(type) {
+	case time.Time:
+		msg.SetTimestamp(t.UnixNano())
+	case int64:
+		msg.SetTimestamp(t * pd.tsScale)
+	case float64:
+		msg.SetTimestamp(int64(t * float64(pd.tsScale)))
+	case string:
+		ts, err := message.ForgivingTimeParse(time.RFC3339Nano, t, time.UTC)
+		if err != nil {
+			return err
+		}
+		msg.SetTimestamp(ts.UnixNano())
+	case nil:
+	default:
+		return errors.New("not a timestamp")
+	}
+	return nil
+}
+
+func init() {
+	RegisterPlugin("ProtobufSchemaDecoder", func() interface{} {
+		return new(ProtobufSchemaDecoder)
+	})
+}
@@ -0,0 +1,325 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package protobuf
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func protoFieldDesc(name string, number int32, kind descriptor.FieldDescriptorProto_Type,
+	typeName string, repeated bool) *descriptor.FieldDescriptorProto {
+
+	label := descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptor.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  &label,
+		Type:   &kind,
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// Describes:
+//
+//	package acme;
+//	message Request {
+//	  string path = 1;
+//	  fixed32 status = 2;
+//	}
+//	message Event {
+//	  enum Level { INFO = 0; WARN = 1; }
+//	  string name = 1;
+//	  int64 count = 2;
+//	  sint32 delta = 3;
+//	  double ratio = 4;
+//	  bool ok = 5;
+//	  Level level = 6;
+//	  repeated int32 codes = 7;
+//	  Request request = 8;
+//	  map<string, int64> labels = 9;
+//	  google.protobuf.Timestamp time = 10;
+//	  repeated Request requests = 11;
+//	}
+func testDescriptorSet() *descriptor.FileDescriptorSet {
+	const (
+		tString  = descriptor.FieldDescriptorProto_TYPE_STRING
+		tInt64   = descriptor.FieldDescriptorProto_TYPE_INT64
+		tInt32   = descriptor.FieldDescriptorProto_TYPE_INT32
+		tSint32  = descriptor.FieldDescriptorProto_TYPE_SINT32
+		tDouble  = descriptor.FieldDescriptorProto_TYPE_DOUBLE
+		tBool    = descriptor.FieldDescriptorProto_TYPE_BOOL
+		tEnum    = descriptor.FieldDescriptorProto_TYPE_ENUM
+		tFixed32 = descriptor.FieldDescriptorProto_TYPE_FIXED32
+		tMessage = descriptor.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	timestamp := &descriptor.FileDescriptorProto{
+		Name:    proto.String("google/protobuf/timestamp.proto"),
+		Package: proto.String("google.protobuf"),
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Timestamp"),
+			Field: []*descriptor.FieldDescriptorProto{
+				protoFieldDesc("seconds", 1, tInt64, "", false),
+				protoFieldDesc("nanos", 2, tInt32, "", false),
+			},
+		}},
+	}
+	event := &descriptor.FileDescriptorProto{
+		Name:       proto.String("acme/event.proto"),
+		Package:    proto.String("acme"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Request"),
+			Field: []*descriptor.FieldDescriptorProto{
+				protoFieldDesc("path", 1, tString, "", false),
+				protoFieldDesc("status", 2, tFixed32, "", false),
+			},
+		}, {
+			Name: proto.String("Event"),
+			Field: []*descriptor.FieldDescriptorProto{
+				protoFieldDesc("name", 1, tString, "", false),
+				protoFieldDesc("count", 2, tInt64, "", false),
+				protoFieldDesc("delta", 3, tSint32, "", false),
+				protoFieldDesc("ratio", 4, tDouble, "", false),
+				protoFieldDesc("ok", 5, tBool, "", false),
+				protoFieldDesc("level", 6, tEnum, ".acme.Event.Level", false),
+				protoFieldDesc("codes", 7, tInt32, "", true),
+				protoFieldDesc("request", 8, tMessage, ".acme.Request", false),
+				protoFieldDesc("labels", 9, tMessage, ".acme.Event.LabelsEntry", true),
+				protoFieldDesc("time", 10, tMessage, ".google.protobuf.Timestamp", false),
+				protoFieldDesc("requests", 11, tMessage, ".acme.Request", true),
+			},
+			NestedType: []*descriptor.DescriptorProto{{
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptor.FieldDescriptorProto{
+					protoFieldDesc("key", 1, tString, "", false),
+					protoFieldDesc("value", 2, tInt64, "", false),
+				},
+				Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			EnumType: []*descriptor.EnumDescriptorProto{{
+				Name: proto.String("Level"),
+				Value: []*descriptor.EnumValueDescriptorProto{
+					{Name: proto.String("INFO"), Number: proto.Int32(0)},
+					{Name: proto.String("WARN"), Number: proto.Int32(1)},
+				},
+			}},
+		}},
+	}
+	return &descriptor.FileDescriptorSet{
+		File: []*descriptor.FileDescriptorProto{timestamp, event},
+	}
+}
+
+// Minimal protobuf wire format encoder for the test messages.
+type protoWriter []byte
+
+func (w *protoWriter) varint(x uint64) {
+	for x >= 0x80 {
+		*w = append(*w, byte(x)|0x80)
+		x >>= 7
+	}
+	*w = append(*w, byte(x))
+}
+
+func (w *protoWriter) tag(number int, wireType uint64) {
+	w.varint(uint64(number)<<3 | wireType)
+}
+
+func (w *protoWriter) bytes(number int, b []byte) {
+	w.tag(number, proto.WireBytes)
+	w.varint(uint64(len(b)))
+	*w = append(*w, b...)
+}
+
+func testRequest(path string, status uint32) []byte {
+	var w protoWriter
+	w.bytes(1, []byte(path))
+	w.tag(2, proto.WireFixed32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], status)
+	w = append(w, b[:]...)
+	return w
+}
+
+func testEvent() []byte {
+	var w protoWriter
+	w.bytes(1, []byte("click"))
+	w.tag(2, proto.WireVarint)
+	w.varint(42)
+	w.tag(3, proto.WireVarint)
+	w.varint(5) // zigzag encoded -3
+	w.tag(4, proto.WireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(0.25))
+	w = append(w, b[:]...)
+	w.tag(5, proto.WireVarint)
+	w.varint(1)
+	w.tag(6, proto.WireVarint)
+	w.varint(1)
+	// Packed repeated codes.
+	var codes protoWriter
+	codes.varint(200)
+	codes.varint(404)
+	w.bytes(7, codes)
+	w.bytes(8, testRequest("/index", 200))
+	for _, entry := range []struct {
+		key   string
+		value uint64
+	}{{"b", 2}, {"a", 1}} {
+		var e protoWriter
+		e.bytes(1, []byte(entry.key))
+		e.tag(2, proto.WireVarint)
+		e.varint(entry.value)
+		w.bytes(9, e)
+	}
+	var ts protoWriter
+	ts.tag(1, proto.WireVarint)
+	ts.varint(1456833600)
+	ts.tag(2, proto.WireVarint)
+	ts.varint(500)
+	w.bytes(10, ts)
+	w.bytes(11, testRequest("/a", 200))
+	w.bytes(11, testRequest("/b", 500))
+	// Unknown fields are skipped.
+	w.bytes(99, []byte("ignored"))
+	return w
+}
+
+func ProtobufSchemaDecoderSpec(c gs.Context) {
+	tmpDir, err := ioutil.TempDir("", "protobuf-decoder-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	setPath := filepath.Join(tmpDir, "acme.desc")
+	data, err := proto.Marshal(testDescriptorSet())
+	c.Assume(err, gs.IsNil)
+	err = ioutil.WriteFile(setPath, data, 0644)
+	c.Assume(err, gs.IsNil)
+
+	decoder := new(ProtobufSchemaDecoder)
+	conf := decoder.ConfigStruct().(*ProtobufSchemaDecoderConfig)
+	conf.DescriptorSet = setPath
+	conf.MessageName = "acme.Event"
+	pack := NewPipelinePack(make(chan *PipelinePack, 1))
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+
+	c.Specify("A ProtobufSchemaDecoder", func() {
+		c.Specify("requires a descriptor set", func() {
+			conf.DescriptorSet = ""
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown message types", func() {
+			conf.MessageName = "acme.Missing"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects descriptor sets missing referenced types", func() {
+			set := testDescriptorSet()
+			// Drops the Request message.
+			set.File[1].MessageType = set.File[1].MessageType[1:]
+			data, err := proto.Marshal(set)
+			c.Assume(err, gs.IsNil)
+			err = ioutil.WriteFile(setPath, data, 0644)
+			c.Assume(err, gs.IsNil)
+			err = decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("maps message fields to message fields", func() {
+			conf.MessageType = "acme.event"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			pack.Message.SetPayload(string(testEvent()))
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 1)
+
+			msg := pack.Message
+			c.Expect(msg.GetPayload(), gs.Equals, "")
+			c.Expect(msg.GetType(), gs.Equals, "acme.event")
+			c.Expect(field("name"), gs.Equals, "click")
+			c.Expect(field("count"), gs.Equals, int64(42))
+			c.Expect(field("delta"), gs.Equals, int64(-3))
+			c.Expect(field("ratio"), gs.Equals, 0.25)
+			c.Expect(field("ok"), gs.Equals, true)
+			c.Expect(field("level"), gs.Equals, "WARN")
+			codes := msg.FindFirstField("codes")
+			c.Assume(codes, gs.Not(gs.IsNil))
+			c.Expect(len(codes.GetValueInteger()), gs.Equals, 2)
+			c.Expect(codes.GetValueInteger()[1], gs.Equals, int64(404))
+			c.Expect(field("request.path"), gs.Equals, "/index")
+			c.Expect(field("request.status"), gs.Equals, int64(200))
+			c.Expect(field("labels.a"), gs.Equals, int64(1))
+			c.Expect(field("labels.b"), gs.Equals, int64(2))
+			c.Expect(field("time"), gs.Equals, "2016-03-01T12:00:00.0000005Z")
+			c.Expect(field("requests"), gs.Equals,
+				`[{"path":"/a","status":200},{"path":"/b","status":500}]`)
+			c.Expect(msg.FindFirstField("missing"), gs.IsNil)
+		})
+
+		c.Specify("sets the timestamp", func() {
+			c.Specify("from a Timestamp field", func() {
+				conf.TimestampField = "time"
+				err := decoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				pack.Message.SetPayload(string(testEvent()))
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals,
+					int64(1456833600000000500))
+				c.Expect(field("time"), gs.IsNil)
+			})
+
+			c.Specify("from a nested numeric field", func() {
+				conf.TimestampField = "request.status"
+				conf.TimestampUnit = "s"
+				err := decoder.Init(conf)
+				c.Assume(err, gs.IsNil)
+				pack.Message.SetPayload(string(testEvent()))
+				_, err = decoder.Decode(pack)
+				c.Expect(err, gs.IsNil)
+				c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(200e9))
+			})
+		})
+
+		c.Specify("rejects truncated data", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			data := testEvent()
+			pack.Message.SetPayload(string(data[:len(data)-3]))
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
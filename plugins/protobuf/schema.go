@@ -0,0 +1,443 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/protoc-gen-gogo/descriptor"
+)
+
+// Message type decoded as a timestamp rather than a nested record.
+const timestampTypeName = ".google.protobuf.Timestamp"
+
+// The message and enum types of a descriptor set, by fully qualified name
+// with a leading ".", as used in the type names of fields.
+type protoSchema struct {
+	messages map[string]*protoMessageType
+	enums    map[string]map[int32]string
+}
+
+type protoMessageType struct {
+	name string
+	// Fields in declaration order, and by number.
+	fields   []*protoField
+	byNumber map[int32]*protoField
+	// Whether this is the entry type generated for a map field.
+	mapEntry bool
+}
+
+type protoField struct {
+	name     string
+	number   int32
+	kind     descriptor.FieldDescriptorProto_Type
+	repeated bool
+	typeName string
+}
+
+// Loads a FileDescriptorSet, as written by `protoc --descriptor_set_out`.
+func loadProtoSchema(path string) (*protoSchema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := new(descriptor.FileDescriptorSet)
+	if err = proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %s", err)
+	}
+	schema := &protoSchema{
+		messages: make(map[string]*protoMessageType),
+		enums:    make(map[string]map[int32]string),
+	}
+	for _, file := range set.File {
+		prefix := "."
+		if file.GetPackage() != "" {
+			prefix += file.GetPackage() + "."
+		}
+		for _, enum := range file.EnumType {
+			schema.addEnum(prefix, enum)
+		}
+		for _, msg := range file.MessageType {
+			schema.addMessage(prefix, msg)
+		}
+	}
+	return schema, nil
+}
+
+func (s *protoSchema) addEnum(prefix string, enum *descriptor.EnumDescriptorProto) {
+	names := make(map[int32]string, len(enum.Value))
+	for _, value := range enum.Value {
+		names[value.GetNumber()] = value.GetName()
+	}
+	s.enums[prefix+enum.GetName()] = names
+}
+
+func (s *protoSchema) addMessage(prefix string, msg *descriptor.DescriptorProto) {
+	name := prefix + msg.GetName()
+	typ := &protoMessageType{
+		name:     name,
+		byNumber: make(map[int32]*protoField, len(msg.Field)),
+		mapEntry: msg.GetOptions().GetMapEntry(),
+	}
+	for _, f := range msg.Field {
+		field := &protoField{
+			name:     f.GetName(),
+			number:   f.GetNumber(),
+			kind:     f.GetType(),
+			repeated: f.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED,
+			typeName: f.GetTypeName(),
+		}
+		typ.fields = append(typ.fields, field)
+		typ.byNumber[field.number] = field
+	}
+	s.messages[name] = typ
+	for _, enum := range msg.EnumType {
+		s.addEnum(name+".", enum)
+	}
+	for _, nested := range msg.NestedType {
+		s.addMessage(name+".", nested)
+	}
+}
+
+// Returns the named message type, checking that every type it refers to,
+// directly or not, is part of the schema.
+func (s *protoSchema) messageType(name string) (*protoMessageType, error) {
+	if !strings.HasPrefix(name, ".") {
+		name = "." + name
+	}
+	typ, ok := s.messages[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type '%s'", name[1:])
+	}
+	if err := s.check(typ, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	return typ, nil
+}
+
+func (s *protoSchema) check(typ *protoMessageType, checked map[string]bool) error {
+	if checked[typ.name] {
+		return nil
+	}
+	checked[typ.name] = true
+	for _, f := range typ.fields {
+		switch f.kind {
+		case descriptor.FieldDescriptorProto_TYPE_GROUP:
+			return fmt.Errorf("%s.%s: groups aren't supported", typ.name[1:], f.name)
+		case descriptor.FieldDescriptorProto_TYPE_ENUM:
+			if _, ok := s.enums[f.typeName]; !ok {
+				return fmt.Errorf("%s.%s: unknown enum type '%s'", typ.name[1:],
+					f.name, f.typeName)
+			}
+		case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+			if f.typeName == timestampTypeName {
+				continue
+			}
+			nested, ok := s.messages[f.typeName]
+			if !ok {
+				return fmt.Errorf("%s.%s: unknown message type '%s', was the "+
+					"descriptor set generated with --include_imports?",
+					typ.name[1:], f.name, f.typeName)
+			}
+			if err := s.check(nested, checked); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A decoded message. Values holds the values of each of the type's fields,
+// nil for fields that weren't set. Repeated fields hold a []interface{},
+// map fields a map[string]interface{}.
+type protoRecordValue struct {
+	typ    *protoMessageType
+	values []interface{}
+}
+
+var errProtoTruncated = errors.New("truncated data")
+
+// Decodes protobuf wire format data, see
+// https://developers.google.com/protocol-buffers/docs/encoding.
+type protoReader struct {
+	schema *protoSchema
+	data   []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if len(r.data) == 0 {
+			return 0, errProtoTruncated
+		}
+		b := r.data[0]
+		r.data = r.data[1:]
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, errors.New("varint overflow")
+}
+
+func (r *protoReader) fixed(size int) (uint64, error) {
+	if len(r.data) < size {
+		return 0, errProtoTruncated
+	}
+	var x uint64
+	if size == 4 {
+		x = uint64(binary.LittleEndian.Uint32(r.data))
+	} else {
+		x = binary.LittleEndian.Uint64(r.data)
+	}
+	r.data = r.data[size:]
+	return x, nil
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)) {
+		return nil, errProtoTruncated
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// Skips a field of an unknown number.
+func (r *protoReader) skip(wireType uint64) (err error) {
+	switch wireType {
+	case proto.WireVarint:
+		_, err = r.varint()
+	case proto.WireFixed64:
+		_, err = r.fixed(8)
+	case proto.WireBytes:
+		_, err = r.bytes()
+	case proto.WireFixed32:
+		_, err = r.fixed(4)
+	default:
+		err = fmt.Errorf("unsupported wire type %d", wireType)
+	}
+	return err
+}
+
+// Decodes a message of the given type, which is the rest of the data.
+func (r *protoReader) readMessage(typ *protoMessageType) (*protoRecordValue, error) {
+	rec := &protoRecordValue{
+		typ:    typ,
+		values: make([]interface{}, len(typ.fields)),
+	}
+	index := make(map[int32]int, len(typ.fields))
+	for i, f := range typ.fields {
+		index[f.number] = i
+	}
+	for len(r.data) > 0 {
+		tag, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		number, wireType := int32(tag>>3), tag&7
+		f, ok := typ.byNumber[number]
+		if !ok {
+			if err = r.skip(wireType); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		i := index[number]
+		if f.repeated && wireType == proto.WireBytes && isPackable(f.kind) {
+			// Packed repeated scalars.
+			data, err := r.bytes()
+			if err != nil {
+				return nil, err
+			}
+			packed := &protoReader{schema: r.schema, data: data}
+			for len(packed.data) > 0 {
+				v, err := packed.readValue(f, scalarWireType(f.kind))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", f.name, err)
+				}
+				rec.values[i] = appendValue(rec.values[i], v)
+			}
+			continue
+		}
+		v, err := r.readValue(f, wireType)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.name, err)
+		}
+		switch {
+		case f.repeated && r.isMapEntry(f):
+			entry := v.(*protoRecordValue)
+			m, _ := rec.values[i].(map[string]interface{})
+			if m == nil {
+				m = make(map[string]interface{})
+				rec.values[i] = m
+			}
+			m[fmt.Sprint(entry.values[0])] = entry.values[1]
+		case f.repeated:
+			rec.values[i] = appendValue(rec.values[i], v)
+		default:
+			rec.values[i] = v
+		}
+	}
+	return rec, nil
+}
+
+func (r *protoReader) isMapEntry(f *protoField) bool {
+	if f.kind != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return false
+	}
+	typ := r.schema.messages[f.typeName]
+	return typ != nil && typ.mapEntry
+}
+
+func appendValue(values interface{}, v interface{}) interface{} {
+	list, _ := values.([]interface{})
+	return append(list, v)
+}
+
+func isPackable(kind descriptor.FieldDescriptorProto_Type) bool {
+	switch kind {
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+func scalarWireType(kind descriptor.FieldDescriptorProto_Type) uint64 {
+	switch kind {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return proto.WireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return proto.WireFixed32
+	}
+	return proto.WireVarint
+}
+
+// Reads a single value of the field. Integers are returned as int64,
+// floating point numbers as float64, enums as the name of their value, or
+// the number if it's unknown, and timestamps as time.Time.
+func (r *protoReader) readValue(f *protoField, wireType uint64) (interface{}, error) {
+	if f.kind == descriptor.FieldDescriptorProto_TYPE_STRING ||
+		f.kind == descriptor.FieldDescriptorProto_TYPE_BYTES ||
+		f.kind == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+
+		if wireType != proto.WireBytes {
+			return nil, fmt.Errorf("unexpected wire type %d", wireType)
+		}
+		data, err := r.bytes()
+		if err != nil {
+			return nil, err
+		}
+		switch f.kind {
+		case descriptor.FieldDescriptorProto_TYPE_STRING:
+			return string(data), nil
+		case descriptor.FieldDescriptorProto_TYPE_BYTES:
+			return append([]byte(nil), data...), nil
+		}
+		nested := &protoReader{schema: r.schema, data: data}
+		if f.typeName == timestampTypeName {
+			return nested.readTimestamp()
+		}
+		return nested.readMessage(r.schema.messages[f.typeName])
+	}
+
+	if expected := scalarWireType(f.kind); wireType != expected {
+		return nil, fmt.Errorf("unexpected wire type %d", wireType)
+	}
+	var (
+		x   uint64
+		err error
+	)
+	switch wireType {
+	case proto.WireFixed64:
+		x, err = r.fixed(8)
+	case proto.WireFixed32:
+		x, err = r.fixed(4)
+	default:
+		x, err = r.varint()
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch f.kind {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(x), nil
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return float64(math.Float32frombits(uint32(x))), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return x != 0, nil
+	case descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int64(int32(x)), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return int64(uint32(x)), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return int64(x>>1) ^ -int64(x&1), nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		if name, ok := r.schema.enums[f.typeName][int32(x)]; ok {
+			return name, nil
+		}
+		return int64(int32(x)), nil
+	}
+	// int64, uint64, fixed64 and sfixed64. Heka fields are signed, uint64
+	// values above the int64 range wrap around.
+	return int64(x), nil
+}
+
+// Decodes a google.protobuf.Timestamp, which has the seconds since the
+// epoch as field 1 and the nanoseconds as field 2.
+func (r *protoReader) readTimestamp() (time.Time, error) {
+	var seconds, nanos int64
+	for len(r.data) > 0 {
+		tag, err := r.varint()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if tag&7 != proto.WireVarint {
+			if err = r.skip(tag & 7); err != nil {
+				return time.Time{}, err
+			}
+			continue
+		}
+		x, err := r.varint()
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch tag >> 3 {
+		case 1:
+			seconds = int64(x)
+		case 2:
+			nanos = int64(int32(x))
+		}
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
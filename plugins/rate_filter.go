@@ -0,0 +1,220 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type RateFilterConfig struct {
+	// Names of the monotonically increasing counter fields converted to
+	// rates.
+	Fields []string
+	// Message variables identifying a source of counters, e.g. ["Hostname",
+	// "Fields[interface]"]. Defaults to a single source.
+	KeyFields []string `toml:"key_fields"`
+	// Appended to the counter field names to name the rate fields. Defaults
+	// to "_rate".
+	RateSuffix string `toml:"rate_suffix"`
+	// Value after which the counters wrap around to 0, e.g. 4294967295 for
+	// 32 bit SNMP counters. A decreasing counter is taken to have wrapped if
+	// set, and to have been reset otherwise. Defaults to 0.
+	CounterMax uint64 `toml:"counter_max"`
+	// Number of seconds after which a counter's last value is too old to
+	// compute a rate from, and is forgotten. Defaults to 600.
+	MaxInterval uint `toml:"max_interval"`
+	// Appended to the Type of the injected messages. Defaults to ".rate".
+	TypeSuffix string `toml:"type_suffix"`
+	// Interval in seconds at which forgotten counters are cleaned up.
+	// Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// Last value of a counter.
+type rateSample struct {
+	value float64
+	// Message timestamp in nanoseconds.
+	timestamp int64
+	ok        bool
+}
+
+type rateSource struct {
+	// Last value of each of the configured fields.
+	samples []rateSample
+	// When the source last sent a message, by the filter's clock.
+	seen time.Time
+}
+
+// RateFilter injects copies of the messages it receives with the per second
+// rate of increase of their counter fields added, computed from the previous
+// message of the same source.
+type RateFilter struct {
+	conf        *RateFilterConfig
+	key         *messageKey
+	maxInterval time.Duration
+	sources     map[string]*rateSource
+	// Number of counter resets and wraps seen, accessed atomically.
+	resets int64
+	now    func() time.Time
+	fr     FilterRunner
+	h      PluginHelper
+}
+
+func (f *RateFilter) ConfigStruct() interface{} {
+	return &RateFilterConfig{
+		RateSuffix:     "_rate",
+		MaxInterval:    600,
+		TypeSuffix:     ".rate",
+		TickerInterval: 60,
+	}
+}
+
+func (f *RateFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*RateFilterConfig)
+	if len(f.conf.Fields) == 0 {
+		return errors.New("at least one of 'fields' must be specified")
+	}
+	if f.key, err = newMessageKey(f.conf.KeyFields); err != nil {
+		return fmt.Errorf("key_fields: %s", err)
+	}
+	if f.conf.RateSuffix == "" {
+		return errors.New("'rate_suffix' must not be empty")
+	}
+	if f.conf.MaxInterval == 0 {
+		return errors.New("'max_interval' must be greater than 0")
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	if f.conf.TickerInterval == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	f.maxInterval = time.Duration(f.conf.MaxInterval) * time.Second
+	f.sources = make(map[string]*rateSource)
+	f.now = time.Now
+	return nil
+}
+
+func (f *RateFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Returns the per second rate of increase from the previous sample to the
+// current one, ok is false if there's no usable previous sample.
+func (f *RateFilter) rate(prev rateSample, value float64, timestamp int64) (
+	rate float64, ok bool) {
+
+	elapsed := time.Duration(timestamp - prev.timestamp)
+	if !prev.ok || elapsed <= 0 || elapsed > f.maxInterval {
+		return 0, false
+	}
+	delta := value - prev.value
+	if delta < 0 {
+		atomic.AddInt64(&f.resets, 1)
+		if f.conf.CounterMax > 0 && prev.value <= float64(f.conf.CounterMax) {
+			delta = float64(f.conf.CounterMax) - prev.value + value + 1
+		} else {
+			// The counter restarted from 0.
+			delta = value
+		}
+	}
+	return delta / elapsed.Seconds(), true
+}
+
+func (f *RateFilter) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	key := f.key.key(msg)
+	source, ok := f.sources[key]
+	if !ok {
+		source = &rateSource{samples: make([]rateSample, len(f.conf.Fields))}
+		f.sources[key] = source
+	}
+	source.seen = f.now()
+
+	timestamp := msg.GetTimestamp()
+	rates := make([]float64, len(f.conf.Fields))
+	computed := make([]bool, len(f.conf.Fields))
+	haveRate := false
+	for i, name := range f.conf.Fields {
+		var value float64
+		switch v := getNumericField(msg, name).(type) {
+		case int64:
+			value = float64(v)
+		case float64:
+			value = v
+		default:
+			continue
+		}
+		prev := source.samples[i]
+		if prev.ok && timestamp <= prev.timestamp {
+			// Out of order, keep the newer sample.
+			continue
+		}
+		rates[i], computed[i] = f.rate(prev, value, timestamp)
+		haveRate = haveRate || computed[i]
+		source.samples[i] = rateSample{value, timestamp, true}
+	}
+	if !haveRate {
+		return nil
+	}
+
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	msg.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(msg.GetType() + f.conf.TypeSuffix)
+	for i, name := range f.conf.Fields {
+		if computed[i] {
+			addDoubleField(newPack.Message, name+f.conf.RateSuffix, rates[i], "/s")
+		}
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+// Forgets the sources that haven't sent a message for longer than
+// max_interval.
+func (f *RateFilter) TimerEvent() error {
+	now := f.now()
+	for key, source := range f.sources {
+		if now.Sub(source.seen) > f.maxInterval {
+			delete(f.sources, key)
+		}
+	}
+	return nil
+}
+
+// ReportMsg provides the number of counter resets and wraps seen to Heka's
+// report and dashboard.
+func (f *RateFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Resets", atomic.LoadInt64(&f.resets), "count")
+	return nil
+}
+
+func (f *RateFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("RateFilter", func() interface{} {
+		return new(RateFilter)
+	})
+}
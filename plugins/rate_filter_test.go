@@ -0,0 +1,148 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RateFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A RateFilter", func() {
+		filter := new(RateFilter)
+		config := filter.ConfigStruct().(*RateFilterConfig)
+		config.Fields = []string{"rx_bytes", "tx_bytes"}
+		config.KeyFields = []string{"Hostname"}
+
+		c.Specify("requires fields", func() {
+			config.Fields = nil
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown key fields", func() {
+			config.KeyFields = []string{"Fields[interface"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("when running", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			now := time.Unix(1e9, 0)
+			send := func(host string, seconds int64, rx, tx interface{}) {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message = new(message.Message)
+				pack.Message.SetType("stats.net")
+				pack.Message.SetHostname(host)
+				pack.Message.SetTimestamp(now.Add(time.Duration(seconds) * time.Second).UnixNano())
+				for name, value := range map[string]interface{}{"rx_bytes": rx, "tx_bytes": tx} {
+					if value != nil {
+						field, _ := message.NewField(name, value, "B")
+						pack.Message.AddField(field)
+					}
+				}
+				c.Expect(filter.ProcessMessage(pack), gs.IsNil)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.now = func() time.Time { return now }
+			}
+
+			c.Specify("computes per second rates per source", func() {
+				start()
+				send("web1", 0, int64(1000), int64(500))
+				send("web2", 0, int64(0), int64(0))
+				c.Expect(len(injected), gs.Equals, 0)
+
+				send("web1", 10, int64(3000), 600.0)
+				c.Assume(len(injected), gs.Equals, 1)
+				msg := injected[0]
+				c.Expect(msg.GetType(), gs.Equals, "stats.net.rate")
+				c.Expect(value(msg, "rx_bytes"), gs.Equals, int64(3000))
+				c.Expect(value(msg, "rx_bytes_rate"), gs.Equals, 200.0)
+				c.Expect(value(msg, "tx_bytes_rate"), gs.Equals, 10.0)
+
+				send("web2", 5, int64(50), nil)
+				c.Assume(len(injected), gs.Equals, 2)
+				c.Expect(value(injected[1], "rx_bytes_rate"), gs.Equals, 10.0)
+				c.Expect(value(injected[1], "tx_bytes_rate"), gs.IsNil)
+			})
+
+			c.Specify("handles counter resets", func() {
+				start()
+				send("web1", 0, int64(1000), nil)
+				send("web1", 10, int64(200), nil)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "rx_bytes_rate"), gs.Equals, 20.0)
+
+				msg := new(message.Message)
+				c.Expect(filter.ReportMsg(msg), gs.IsNil)
+				c.Expect(value(msg, "Resets"), gs.Equals, int64(1))
+			})
+
+			c.Specify("handles counter wraps", func() {
+				config.CounterMax = 4294967295
+				start()
+				send("web1", 0, int64(4294967000), nil)
+				send("web1", 10, int64(704), nil)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "rx_bytes_rate"), gs.Equals, 100.0)
+			})
+
+			c.Specify("ignores stale and out of order samples", func() {
+				config.MaxInterval = 60
+				start()
+				send("web1", 0, int64(0), nil)
+				send("web1", 120, int64(1200), nil)
+				send("web1", 100, int64(1000), nil)
+				c.Expect(len(injected), gs.Equals, 0)
+				send("web1", 130, int64(1300), nil)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(value(injected[0], "rx_bytes_rate"), gs.Equals, 10.0)
+			})
+
+			c.Specify("forgets idle sources", func() {
+				config.MaxInterval = 60
+				start()
+				send("web1", 0, int64(0), nil)
+				now = now.Add(61 * time.Second)
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Expect(len(filter.sources), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,229 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Values of the RateLimitFilter's `action` setting.
+const (
+	rateLimitDrop      = "drop"
+	rateLimitMark      = "mark"
+	rateLimitSummarize = "summarize"
+)
+
+type RateLimitFilterConfig struct {
+	// Message variables making up the key budgets are kept per, e.g.
+	// ["Logger"] or ["Hostname", "Fields[app]"]. Defaults to a single
+	// budget for all messages.
+	KeyFields []string `toml:"key_fields"`
+	// Messages per second allowed per key.
+	Rate float64
+	// Number of messages a key may send in a burst. Defaults to the rate,
+	// rounded up.
+	Burst uint
+	// What to do with messages over the limit: "drop", "mark" or
+	// "summarize". Defaults to "drop".
+	Action string
+	// Name of the boolean field set on messages over the limit with the
+	// "mark" action. Defaults to "RateLimited".
+	MarkField string `toml:"mark_field"`
+	// Appended to the Type of the injected messages. Defaults to
+	// ".ratelimited".
+	TypeSuffix string `toml:"type_suffix"`
+	// Interval in seconds at which suppression summaries are emitted and
+	// idle keys are forgotten. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// Token bucket for one key.
+type rateBucket struct {
+	tokens     float64
+	last       time.Time
+	suppressed int64
+}
+
+// RateLimitFilter injects copies of the messages it receives as long as their
+// key stays within a messages per second budget, and drops, marks or
+// summarizes the messages over it.
+type RateLimitFilter struct {
+	conf    *RateLimitFilterConfig
+	key     *messageKey
+	burst   float64
+	buckets map[string]*rateBucket
+	// Total number of messages over the limit, accessed atomically.
+	suppressed int64
+	now        func() time.Time
+	fr         FilterRunner
+	h          PluginHelper
+}
+
+func (f *RateLimitFilter) ConfigStruct() interface{} {
+	return &RateLimitFilterConfig{
+		Action:         rateLimitDrop,
+		MarkField:      "RateLimited",
+		TypeSuffix:     ".ratelimited",
+		TickerInterval: 60,
+	}
+}
+
+func (f *RateLimitFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*RateLimitFilterConfig)
+	if f.conf.Rate <= 0 {
+		return fmt.Errorf("'rate' must be greater than 0: %g", f.conf.Rate)
+	}
+	switch f.conf.Action {
+	case rateLimitDrop, rateLimitSummarize:
+	case rateLimitMark:
+		if f.conf.MarkField == "" {
+			return errors.New("'mark_field' must not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown action '%s'", f.conf.Action)
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	if f.conf.TickerInterval == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	if f.key, err = newMessageKey(f.conf.KeyFields); err != nil {
+		return fmt.Errorf("key_fields: %s", err)
+	}
+	f.burst = float64(f.conf.Burst)
+	if f.burst == 0 {
+		f.burst = math.Ceil(f.conf.Rate)
+	}
+	f.buckets = make(map[string]*rateBucket)
+	f.now = time.Now
+	return nil
+}
+
+func (f *RateLimitFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Refills the bucket for the time passed since it was last used.
+func (f *RateLimitFilter) refill(b *rateBucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * f.conf.Rate
+	if b.tokens > f.burst {
+		b.tokens = f.burst
+	}
+	b.last = now
+}
+
+func (f *RateLimitFilter) ProcessMessage(pack *PipelinePack) error {
+	now := f.now()
+	key := f.key.key(pack.Message)
+	b, ok := f.buckets[key]
+	if ok {
+		f.refill(b, now)
+	} else {
+		b = &rateBucket{tokens: f.burst, last: now}
+		f.buckets[key] = b
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return f.inject(pack, false)
+	}
+
+	atomic.AddInt64(&f.suppressed, 1)
+	switch f.conf.Action {
+	case rateLimitMark:
+		return f.inject(pack, true)
+	case rateLimitSummarize:
+		b.suppressed++
+	}
+	return nil
+}
+
+// Injects a copy of the pack's message, with the mark field set if marked is
+// true.
+func (f *RateLimitFilter) inject(pack *PipelinePack, marked bool) error {
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	if marked {
+		if field, err := message.NewField(f.conf.MarkField, true, ""); err == nil {
+			newPack.Message.AddField(field)
+		}
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *RateLimitFilter) TimerEvent() error {
+	now := f.now()
+	for key, b := range f.buckets {
+		if b.suppressed > 0 {
+			if err := f.injectSummary(key, b.suppressed); err != nil {
+				return err
+			}
+			b.suppressed = 0
+		}
+		// A full bucket behaves like a new one, no need to keep it.
+		f.refill(b, now)
+		if b.tokens >= f.burst {
+			delete(f.buckets, key)
+		}
+	}
+	return nil
+}
+
+func (f *RateLimitFilter) injectSummary(key string, suppressed int64) error {
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetType("heka.ratelimit.summary")
+	msg.SetLogger(f.fr.Name())
+	msg.SetSeverity(4)
+	msg.SetPayload(fmt.Sprintf("%d messages suppressed for key '%s' in the last %ds",
+		suppressed, key, f.conf.TickerInterval))
+	message.NewStringField(msg, "RateLimit", f.fr.Name())
+	message.NewStringField(msg, "Key", key)
+	message.NewInt64Field(msg, "Suppressed", suppressed, "count")
+	f.fr.Inject(pack)
+	return nil
+}
+
+// ReportMsg provides the number of messages over the limit to Heka's report
+// and dashboard.
+func (f *RateLimitFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Suppressed", atomic.LoadInt64(&f.suppressed),
+		"count")
+	return nil
+}
+
+func (f *RateLimitFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("RateLimitFilter", func() interface{} {
+		return new(RateLimitFilter)
+	})
+}
@@ -0,0 +1,138 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RateLimitFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A RateLimitFilter", func() {
+		filter := new(RateLimitFilter)
+		config := filter.ConfigStruct().(*RateLimitFilterConfig)
+		config.KeyFields = []string{"Logger"}
+		config.Rate = 2
+
+		c.Specify("requires a rate", func() {
+			config.Rate = 0
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an unknown action", func() {
+			config.Action = "ignore"
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown key fields", func() {
+			config.KeyFields = []string{"Fields[app]", "Timestamp"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("limits each key", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			fr.EXPECT().Name().Return("limiter").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			now := time.Unix(1e9, 0)
+			send := func(logger string, n int) {
+				pack := NewPipelinePack(recycleChan)
+				for i := 0; i < n; i++ {
+					pack.Message = new(message.Message)
+					pack.Message.SetType("app.log")
+					pack.Message.SetLogger(logger)
+					filter.ProcessMessage(pack)
+				}
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.now = func() time.Time { return now }
+			}
+
+			c.Specify("dropping messages over the limit", func() {
+				start()
+				send("web", 3)
+				send("db", 1)
+				c.Assume(len(injected), gs.Equals, 3)
+				c.Expect(injected[0].GetType(), gs.Equals, "app.log.ratelimited")
+				c.Expect(injected[2].GetLogger(), gs.Equals, "db")
+
+				// Half a second buys another message.
+				injected = nil
+				now = now.Add(500 * time.Millisecond)
+				send("web", 2)
+				c.Expect(len(injected), gs.Equals, 1)
+
+				msg := new(message.Message)
+				filter.ReportMsg(msg)
+				c.Expect(value(msg, "Suppressed"), gs.Equals, int64(2))
+			})
+
+			c.Specify("marking messages over the limit", func() {
+				config.Action = "mark"
+				start()
+				send("web", 3)
+				c.Assume(len(injected), gs.Equals, 3)
+				c.Expect(injected[1].FindFirstField("RateLimited"), gs.IsNil)
+				c.Expect(value(injected[2], "RateLimited"), gs.Equals, true)
+			})
+
+			c.Specify("summarizing messages over the limit", func() {
+				config.Action = "summarize"
+				start()
+				send("web", 5)
+				send("db", 2)
+				injected = nil
+				err := filter.TimerEvent()
+				c.Expect(err, gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				summary := injected[0]
+				c.Expect(summary.GetType(), gs.Equals, "heka.ratelimit.summary")
+				c.Expect(value(summary, "Key"), gs.Equals, "web")
+				c.Expect(value(summary, "Suppressed"), gs.Equals, int64(3))
+
+				// Nothing more was suppressed, and the keys are forgotten
+				// once their buckets have refilled.
+				injected = nil
+				now = now.Add(time.Second)
+				filter.TimerEvent()
+				c.Expect(len(injected), gs.Equals, 0)
+				c.Expect(len(filter.buckets), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,353 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type RedisInputConfig struct {
+	// Redis server address, in host:port format. Defaults to
+	// "127.0.0.1:6379".
+	Address string
+	// Optional password sent using the AUTH command after connecting.
+	Password string
+	// Redis database number to SELECT after connecting.
+	Database int
+	// Name of a shared RedisPool resource providing the server address,
+	// password and database instead.
+	PoolResource string `toml:"pool_resource"`
+	// Lists that will be drained using BLPOP.
+	Keys []string
+	// Pub/sub channels to SUBSCRIBE to.
+	Channels []string
+	// Seconds each BLPOP call will block before checking for shutdown.
+	// Defaults to 1.
+	BlpopTimeout uint `toml:"blpop_timeout"`
+	// Milliseconds allowed to establish a connection. Defaults to 5000.
+	ConnectTimeout uint `toml:"connect_timeout"`
+	// If true, each item is expected to be a JSON object and is used to
+	// populate the message rather than being handed to the splitter.
+	DecodeJson bool `toml:"decode_json"`
+	// JSON key that will be used as the message payload when `decode_json`
+	// is set. Defaults to "message".
+	PayloadKey string `toml:"payload_key"`
+}
+
+type RedisInput struct {
+	processMessageCount    int64
+	processMessageFailures int64
+
+	config   *RedisInputConfig
+	name     string
+	pConfig  *pipeline.PipelineConfig
+	pool     *RedisPool
+	ir       pipeline.InputRunner
+	hostname string
+	stopChan chan bool
+	connLock sync.Mutex
+	conns    []redis.Conn
+}
+
+func (r *RedisInput) SetName(name string) {
+	r.name = name
+}
+
+func (r *RedisInput) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	r.pConfig = pConfig
+}
+
+func (r *RedisInput) ConfigStruct() interface{} {
+	return &RedisInputConfig{
+		Address:        "127.0.0.1:6379",
+		BlpopTimeout:   1,
+		ConnectTimeout: 5000,
+		PayloadKey:     "message",
+	}
+}
+
+func (r *RedisInput) Init(config interface{}) (err error) {
+	r.config = config.(*RedisInputConfig)
+	if len(r.config.Keys) == 0 && len(r.config.Channels) == 0 {
+		return errors.New("at least one of 'keys' or 'channels' must be specified")
+	}
+	if r.config.BlpopTimeout == 0 {
+		return errors.New("'blpop_timeout' must be greater than zero")
+	}
+	if r.config.PoolResource != "" {
+		if r.pool, err = redisPoolResource(r.pConfig, r.config.PoolResource); err != nil {
+			return err
+		}
+	}
+	r.stopChan = make(chan bool)
+	return nil
+}
+
+// Opens a new connection, BLPOP and SUBSCRIBE block their connection so the
+// input never uses pooled ones.
+func (r *RedisInput) dial() (conn redis.Conn, err error) {
+	if r.pool != nil {
+		conn, err = r.pool.Dial()
+	} else {
+		conn, err = dialRedis(r.config.Address, r.config.Password, r.config.Database,
+			r.config.ConnectTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.connLock.Lock()
+	r.conns = append(r.conns, conn)
+	r.connLock.Unlock()
+	return conn, nil
+}
+
+func (r *RedisInput) closeConns() {
+	r.connLock.Lock()
+	for _, conn := range r.conns {
+		conn.Close()
+	}
+	r.conns = r.conns[:0]
+	r.connLock.Unlock()
+}
+
+func (r *RedisInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) (err error) {
+	r.ir = ir
+	r.hostname = h.Hostname()
+
+	var (
+		wg      sync.WaitGroup
+		errChan = make(chan error, 2)
+	)
+	defer r.closeConns()
+
+	if len(r.config.Keys) > 0 {
+		conn, err := r.dial()
+		if err != nil {
+			return err
+		}
+		sRunner := ir.NewSplitterRunner("lists")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sRunner.Done()
+			errChan <- r.consumeLists(conn, sRunner)
+		}()
+	}
+
+	if len(r.config.Channels) > 0 {
+		conn, err := r.dial()
+		if err != nil {
+			r.closeConns()
+			wg.Wait()
+			return err
+		}
+		sRunner := ir.NewSplitterRunner("channels")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer sRunner.Done()
+			errChan <- r.consumeChannels(conn, sRunner)
+		}()
+	}
+
+	// The first consumer to exit, cleanly or not, takes the other one down
+	// with it so the runner can restart us as a whole.
+	select {
+	case err = <-errChan:
+	case <-r.stopChan:
+	}
+	r.closeConns()
+	wg.Wait()
+	return err
+}
+
+func (r *RedisInput) isStopping() bool {
+	select {
+	case <-r.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+func (r *RedisInput) consumeLists(conn redis.Conn, sRunner pipeline.SplitterRunner) error {
+	args := make(redis.Args, 0, len(r.config.Keys)+1)
+	args = args.AddFlat(r.config.Keys).Add(r.config.BlpopTimeout)
+	for !r.isStopping() {
+		reply, err := redis.Values(conn.Do("BLPOP", args...))
+		if err == redis.ErrNil {
+			continue // Timed out, check for shutdown and try again.
+		}
+		if err != nil {
+			if r.isStopping() {
+				return nil
+			}
+			return fmt.Errorf("BLPOP failed: %s", err)
+		}
+		if len(reply) != 2 {
+			atomic.AddInt64(&r.processMessageFailures, 1)
+			r.ir.LogError(fmt.Errorf("unexpected BLPOP reply length: %d", len(reply)))
+			continue
+		}
+		key, _ := redis.String(reply[0], nil)
+		data, _ := redis.Bytes(reply[1], nil)
+		r.deliver(sRunner, "list", key, data)
+	}
+	return nil
+}
+
+func (r *RedisInput) consumeChannels(conn redis.Conn, sRunner pipeline.SplitterRunner) error {
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(redis.Args{}.AddFlat(r.config.Channels)...); err != nil {
+		return fmt.Errorf("SUBSCRIBE failed: %s", err)
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			r.deliver(sRunner, "channel", v.Channel, v.Data)
+		case redis.Subscription:
+			if v.Kind == "subscribe" {
+				r.ir.LogMessage(fmt.Sprintf("subscribed to channel '%s'", v.Channel))
+			}
+		case error:
+			// Closing the connection is the only way to interrupt a blocked
+			// Receive, so an error here is expected during shutdown.
+			if r.isStopping() {
+				return nil
+			}
+			return fmt.Errorf("pub/sub receive failed: %s", v)
+		}
+	}
+}
+
+func (r *RedisInput) deliver(sRunner pipeline.SplitterRunner, sourceType, source string,
+	data []byte) {
+
+	atomic.AddInt64(&r.processMessageCount, 1)
+	decorate := func(pack *pipeline.PipelinePack) {
+		pack.Message.SetType("heka.redis")
+		pack.Message.SetLogger(r.name)
+		pack.Message.SetHostname(r.hostname)
+		message.NewStringField(pack.Message, "RedisSourceType", sourceType)
+		message.NewStringField(pack.Message, "RedisSource", source)
+	}
+
+	if !r.config.DecodeJson {
+		if !sRunner.UseMsgBytes() {
+			sRunner.SetPackDecorator(decorate)
+		}
+		if _, err := sRunner.SplitBytes(data, nil); err != nil {
+			atomic.AddInt64(&r.processMessageFailures, 1)
+			r.ir.LogError(fmt.Errorf("processing item from %s '%s': %s", sourceType,
+				source, err))
+		}
+		return
+	}
+
+	pack := <-r.ir.InChan()
+	decorate(pack)
+	if err := populateFromJson(pack.Message, data, r.config.PayloadKey); err != nil {
+		atomic.AddInt64(&r.processMessageFailures, 1)
+		r.ir.LogError(fmt.Errorf("decoding JSON from %s '%s': %s", sourceType,
+			source, err))
+		pack.Recycle(nil)
+		return
+	}
+	r.ir.Deliver(pack)
+}
+
+// populateFromJson parses a JSON object and copies its contents into the
+// provided message. The `payloadKey` value becomes the payload; the logstash
+// conventional `type`, `host`, and `@timestamp` keys populate the
+// corresponding message headers; all other scalar values become fields.
+func populateFromJson(msg *message.Message, data []byte, payloadKey string) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for key, value := range doc {
+		switch key {
+		case payloadKey:
+			if s, ok := value.(string); ok {
+				msg.SetPayload(s)
+				continue
+			}
+		case "type":
+			if s, ok := value.(string); ok {
+				msg.SetType(s)
+				continue
+			}
+		case "host":
+			if s, ok := value.(string); ok {
+				msg.SetHostname(s)
+				continue
+			}
+		case "@timestamp":
+			if s, ok := value.(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					msg.SetTimestamp(t.UnixNano())
+					continue
+				}
+			}
+		}
+		var f *message.Field
+		var err error
+		switch v := value.(type) {
+		case string, float64, bool:
+			f, err = message.NewField(key, v, "")
+		case nil:
+			continue
+		default:
+			// Nested objects and arrays are kept as their JSON encoding.
+			var b []byte
+			if b, err = json.Marshal(v); err == nil {
+				f, err = message.NewField(key, string(b), "json")
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("can't create field '%s': %s", key, err)
+		}
+		msg.AddField(f)
+	}
+	return nil
+}
+
+func (r *RedisInput) Stop() {
+	close(r.stopChan)
+}
+
+func (r *RedisInput) CleanupForRestart() {
+	r.closeConns()
+}
+
+func (r *RedisInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&r.processMessageCount), "count")
+	message.NewInt64Field(msg, "ProcessMessageFailures",
+		atomic.LoadInt64(&r.processMessageFailures), "count")
+	return nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("RedisInput", func() interface{} {
+		return new(RedisInput)
+	})
+}
@@ -0,0 +1,275 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package redis
+
+import (
+	"errors"
+	"net"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// Hands out canned replies, one per `Do` or `Receive` call. An error value
+// is returned as the call's error.
+type fakeConn struct {
+	replies []interface{}
+	sent    []string
+}
+
+func (f *fakeConn) next() (interface{}, error) {
+	if len(f.replies) == 0 {
+		return nil, errors.New("connection closed")
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	if err, ok := reply.(error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (f *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	f.sent = append(f.sent, cmd)
+	return f.next()
+}
+
+func (f *fakeConn) Send(cmd string, args ...interface{}) error {
+	f.sent = append(f.sent, cmd)
+	return nil
+}
+
+func (f *fakeConn) Receive() (interface{}, error) {
+	return f.next()
+}
+
+func (f *fakeConn) Flush() error { return nil }
+func (f *fakeConn) Close() error { return nil }
+func (f *fakeConn) Err() error   { return nil }
+
+func RedisInputSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pConfig := NewPipelineConfig(nil)
+
+	c.Specify("A RedisInput", func() {
+		input := new(RedisInput)
+		input.SetName("redis")
+		input.SetPipelineConfig(pConfig)
+		config := input.ConfigStruct().(*RedisInputConfig)
+		config.Keys = []string{"logstash"}
+
+		ir := pipelinemock.NewMockInputRunner(ctrl)
+		sr := pipelinemock.NewMockSplitterRunner(ctrl)
+		input.ir = ir
+		input.hostname = "collector"
+
+		c.Specify("requires keys or channels", func() {
+			config.Keys = nil
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires a blpop_timeout", func() {
+			config.BlpopTimeout = 0
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("fails to run when the server can't be reached", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assume(err, gs.IsNil)
+			config.Address = listener.Addr().String()
+			listener.Close()
+			err = input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			helper := pipelinemock.NewMockPluginHelper(ctrl)
+			helper.EXPECT().Hostname().Return("collector")
+			err = input.Run(ir, helper)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(err.Error(), gs.Contains, "can't connect to "+config.Address)
+		})
+
+		c.Specify("draining lists", func() {
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			conn := new(fakeConn)
+
+			c.Specify("splits items and decorates their packs", func() {
+				conn.replies = []interface{}{
+					redis.ErrNil, // BLPOP timed out.
+					[]interface{}{[]byte("logstash"), []byte("hello")},
+				}
+				sr.EXPECT().UseMsgBytes().Return(false)
+				var decorator func(*PipelinePack)
+				sr.EXPECT().SetPackDecorator(gomock.Any()).Do(func(d func(*PipelinePack)) {
+					decorator = d
+				})
+				var split []byte
+				sr.EXPECT().SplitBytes([]byte("hello"), nil).Return(5, nil).Do(
+					func(b []byte, del Deliverer) {
+						split = b
+						input.Stop()
+					})
+
+				err = input.consumeLists(conn, sr)
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(conn.sent), gs.Equals, 2)
+				c.Expect(conn.sent[0], gs.Equals, "BLPOP")
+				c.Expect(string(split), gs.Equals, "hello")
+
+				pack := NewPipelinePack(pConfig.InputRecycleChan())
+				decorator(pack)
+				c.Expect(pack.Message.GetType(), gs.Equals, "heka.redis")
+				c.Expect(pack.Message.GetLogger(), gs.Equals, "redis")
+				c.Expect(pack.Message.GetHostname(), gs.Equals, "collector")
+				sourceType, _ := pack.Message.GetFieldValue("RedisSourceType")
+				c.Expect(sourceType, gs.Equals, "list")
+				source, _ := pack.Message.GetFieldValue("RedisSource")
+				c.Expect(source, gs.Equals, "logstash")
+			})
+
+			c.Specify("counts unexpected replies", func() {
+				conn.replies = []interface{}{
+					[]interface{}{[]byte("logstash")},
+				}
+				ir.EXPECT().LogError(gomock.Any()).Do(func(err error) {
+					input.Stop()
+				})
+
+				err = input.consumeLists(conn, sr)
+				c.Expect(err, gs.IsNil)
+				c.Expect(input.processMessageFailures, gs.Equals, int64(1))
+			})
+
+			c.Specify("returns BLPOP errors", func() {
+				conn.replies = []interface{}{errors.New("READONLY")}
+				err = input.consumeLists(conn, sr)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(err.Error(), gs.Equals, "BLPOP failed: READONLY")
+			})
+		})
+
+		c.Specify("subscribed to channels", func() {
+			config.Keys = nil
+			config.Channels = []string{"events"}
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			conn := new(fakeConn)
+			conn.replies = []interface{}{
+				[]interface{}{[]byte("subscribe"), []byte("events"), int64(1)},
+				[]interface{}{[]byte("message"), []byte("events"), []byte("hello")},
+			}
+
+			ir.EXPECT().LogMessage("subscribed to channel 'events'")
+			sr.EXPECT().UseMsgBytes().Return(false)
+			var decorator func(*PipelinePack)
+			sr.EXPECT().SetPackDecorator(gomock.Any()).Do(func(d func(*PipelinePack)) {
+				decorator = d
+			})
+			sr.EXPECT().SplitBytes([]byte("hello"), nil).Return(5, nil).Do(
+				func(b []byte, del Deliverer) {
+					input.Stop()
+				})
+
+			// The fake connection fails once it runs out of replies, like a
+			// real one that was closed during shutdown.
+			err = input.consumeChannels(conn, sr)
+			c.Expect(err, gs.IsNil)
+			c.Expect(conn.sent[0], gs.Equals, "SUBSCRIBE")
+
+			pack := NewPipelinePack(pConfig.InputRecycleChan())
+			decorator(pack)
+			sourceType, _ := pack.Message.GetFieldValue("RedisSourceType")
+			c.Expect(sourceType, gs.Equals, "channel")
+			source, _ := pack.Message.GetFieldValue("RedisSource")
+			c.Expect(source, gs.Equals, "events")
+		})
+
+		c.Specify("decoding JSON", func() {
+			config.DecodeJson = true
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			pack := NewPipelinePack(pConfig.InputRecycleChan())
+			inChan := make(chan *PipelinePack, 1)
+			inChan <- pack
+			ir.EXPECT().InChan().Return(inChan)
+
+			c.Specify("delivers the populated pack", func() {
+				ir.EXPECT().Deliver(pack)
+				input.deliver(sr, "list", "logstash",
+					[]byte(`{"message": "hello", "status": 200}`))
+				c.Expect(pack.Message.GetPayload(), gs.Equals, "hello")
+				c.Expect(pack.Message.GetType(), gs.Equals, "heka.redis")
+				status, _ := pack.Message.GetFieldValue("status")
+				c.Expect(status, gs.Equals, float64(200))
+			})
+
+			c.Specify("recycles the pack of an invalid item", func() {
+				ir.EXPECT().LogError(gomock.Any())
+				input.deliver(sr, "list", "logstash", []byte("not json"))
+				c.Expect(input.processMessageFailures, gs.Equals, int64(1))
+				recycled := <-pConfig.InputRecycleChan()
+				c.Expect(recycled, gs.Equals, pack)
+
+				msg := message.Message{}
+				input.ReportMsg(&msg)
+				failures, _ := msg.GetFieldValue("ProcessMessageFailures")
+				c.Expect(failures, gs.Equals, int64(1))
+			})
+		})
+	})
+
+	c.Specify("Populating a message from JSON", func() {
+		msg := new(message.Message)
+
+		c.Specify("maps the logstash conventions and keeps the rest as fields", func() {
+			data := []byte(`{"message": "hello", "type": "app.log", "host": "web1",
+				"@timestamp": "2016-02-01T10:20:30.5Z", "status": 200, "ok": true,
+				"tags": ["a", "b"], "empty": null}`)
+			err := populateFromJson(msg, data, "message")
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetPayload(), gs.Equals, "hello")
+			c.Expect(msg.GetType(), gs.Equals, "app.log")
+			c.Expect(msg.GetHostname(), gs.Equals, "web1")
+			c.Expect(msg.GetTimestamp(), gs.Equals, int64(1454322030500000000))
+			status, _ := msg.GetFieldValue("status")
+			c.Expect(status, gs.Equals, float64(200))
+			ok, _ := msg.GetFieldValue("ok")
+			c.Expect(ok, gs.IsTrue)
+			tags, _ := msg.GetFieldValue("tags")
+			c.Expect(tags, gs.Equals, `["a","b"]`)
+			_, found := msg.GetFieldValue("empty")
+			c.Expect(found, gs.IsFalse)
+		})
+
+		c.Specify("uses the configured payload key", func() {
+			err := populateFromJson(msg, []byte(`{"msg": "hi", "message": "x"}`), "msg")
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetPayload(), gs.Equals, "hi")
+			field, _ := msg.GetFieldValue("message")
+			c.Expect(field, gs.Equals, "x")
+		})
+
+		c.Specify("rejects invalid JSON", func() {
+			err := populateFromJson(msg, []byte("not json"), "message")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,123 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type RedisPoolConfig struct {
+	// Redis server address, in host:port format. Defaults to
+	// "127.0.0.1:6379".
+	Address string
+	// Optional password sent using the AUTH command after connecting.
+	Password string
+	// Redis database number to SELECT after connecting.
+	Database int
+	// Milliseconds allowed to establish a connection. Defaults to 5000.
+	ConnectTimeout uint `toml:"connect_timeout"`
+	// Maximum number of idle connections kept in the pool. Defaults to 4.
+	MaxIdle int `toml:"max_idle"`
+	// Maximum number of connections handed out by the pool at a time, 0 (the
+	// default) means no limit.
+	MaxActive int `toml:"max_active"`
+	// Seconds after which idle connections are closed. Defaults to 300.
+	IdleTimeout uint `toml:"idle_timeout"`
+}
+
+// Shared Redis server settings and connection pool, defined once in the
+// `[resources]` config section with a type of "RedisPool" and referenced by
+// name from plugins' `pool_resource` setting.
+type RedisPool struct {
+	config *RedisPoolConfig
+	pool   *redis.Pool
+}
+
+func (p *RedisPool) ConfigStruct() interface{} {
+	return &RedisPoolConfig{
+		Address:        "127.0.0.1:6379",
+		ConnectTimeout: 5000,
+		MaxIdle:        4,
+		IdleTimeout:    300,
+	}
+}
+
+func (p *RedisPool) Init(config interface{}) error {
+	p.config = config.(*RedisPoolConfig)
+	if p.config.MaxActive < 0 || p.config.MaxIdle < 0 {
+		return errors.New("'max_active' and 'max_idle' can't be negative")
+	}
+	p.pool = &redis.Pool{
+		Dial:        p.Dial,
+		MaxIdle:     p.config.MaxIdle,
+		MaxActive:   p.config.MaxActive,
+		IdleTimeout: time.Duration(p.config.IdleTimeout) * time.Second,
+	}
+	return nil
+}
+
+// Returns a pooled connection, which must be closed to return it to the pool.
+func (p *RedisPool) Get() redis.Conn {
+	return p.pool.Get()
+}
+
+// Opens a dedicated connection outside of the pool, for blocking commands
+// like BLPOP or SUBSCRIBE that tie up their connection.
+func (p *RedisPool) Dial() (redis.Conn, error) {
+	return dialRedis(p.config.Address, p.config.Password, p.config.Database,
+		p.config.ConnectTimeout)
+}
+
+func (p *RedisPool) Stop() {
+	p.pool.Close()
+}
+
+func dialRedis(address, password string, database int, connectTimeout uint) (
+	redis.Conn, error) {
+
+	timeout := time.Duration(connectTimeout) * time.Millisecond
+	conn, err := redis.Dial("tcp", address,
+		redis.DialConnectTimeout(timeout),
+		redis.DialPassword(password),
+		redis.DialDatabase(database),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %s", address, err)
+	}
+	return conn, nil
+}
+
+// Returns the named RedisPool resource.
+func redisPoolResource(pConfig *pipeline.PipelineConfig, name string) (*RedisPool,
+	error) {
+
+	resource, err := pConfig.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	pool, ok := resource.(*RedisPool)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a RedisPool resource", name)
+	}
+	return pool, nil
+}
+
+func init() {
+	pipeline.RegisterResource("RedisPool", func() interface{} {
+		return new(RedisPool)
+	})
+}
@@ -0,0 +1,71 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package redis
+
+import (
+	"net"
+
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RedisPoolSpec(c gs.Context) {
+	c.Specify("A RedisPool", func() {
+		pool := new(RedisPool)
+		config := pool.ConfigStruct().(*RedisPoolConfig)
+
+		c.Specify("rejects negative limits", func() {
+			config.MaxActive = -1
+			c.Expect(pool.Init(config), gs.Not(gs.IsNil))
+			config.MaxActive = 0
+			config.MaxIdle = -1
+			c.Expect(pool.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("applies its limits to the connection pool", func() {
+			config.MaxActive = 10
+			err := pool.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer pool.Stop()
+			c.Expect(pool.pool.MaxActive, gs.Equals, 10)
+			c.Expect(pool.pool.MaxIdle, gs.Equals, 4)
+		})
+
+		c.Specify("reports connection failures when dialing", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			c.Assume(err, gs.IsNil)
+			config.Address = listener.Addr().String()
+			listener.Close()
+			err = pool.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer pool.Stop()
+
+			_, err = pool.Dial()
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(err.Error(), gs.Contains, "can't connect to "+config.Address)
+		})
+	})
+
+	c.Specify("A RedisInput using a pool resource", func() {
+		input := new(RedisInput)
+		input.SetPipelineConfig(NewPipelineConfig(nil))
+		config := input.ConfigStruct().(*RedisInputConfig)
+		config.Keys = []string{"logstash"}
+
+		c.Specify("fails on an unknown resource", func() {
+			config.PoolResource = "missing"
+			err := input.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(err.Error(), gs.Equals, "unknown resource 'missing'")
+		})
+	})
+}
@@ -0,0 +1,207 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type RollupFilterConfig struct {
+	// Message variables messages are grouped by, e.g. ["Hostname",
+	// "Fields[status]"]. Defaults to a single group.
+	Dimensions []string
+	// Names of the numeric fields aggregated per group.
+	Fields []string
+	// Aggregates emitted for each of Fields, any of "sum", "avg", "min" and
+	// "max". Defaults to all of them.
+	Aggregations []string
+	// Maximum number of groups per window. Messages that would start another
+	// group are only counted in the filter's report. Defaults to 10000.
+	MaxGroups uint `toml:"max_groups"`
+	// Type of the emitted aggregate messages. Defaults to "heka.rollup".
+	MessageType string `toml:"message_type"`
+	// Length in seconds of the tumbling window, i.e. the interval at which
+	// aggregates are emitted. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+type rollupGroup struct {
+	dimensions []string
+	count      int64
+	// Aggregates of each of the configured fields.
+	stats []numericStats
+}
+
+// RollupFilter groups the messages it receives by dimension values over a
+// tumbling window and emits one message per group with its message count and
+// aggregates of numeric fields.
+type RollupFilter struct {
+	conf       *RollupFilterConfig
+	dimensions *messageKey
+	// Names of the dimension fields on the emitted messages.
+	names  []string
+	groups map[string]*rollupGroup
+	// Messages that didn't fit in max_groups, accessed atomically.
+	overflow int64
+	fr       FilterRunner
+	h        PluginHelper
+}
+
+func (f *RollupFilter) ConfigStruct() interface{} {
+	return &RollupFilterConfig{
+		Aggregations:   []string{"sum", "avg", "min", "max"},
+		MaxGroups:      10000,
+		MessageType:    "heka.rollup",
+		TickerInterval: 60,
+	}
+}
+
+func (f *RollupFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*RollupFilterConfig)
+	if f.dimensions, err = newMessageKey(f.conf.Dimensions); err != nil {
+		return fmt.Errorf("dimensions: %s", err)
+	}
+	f.names = make([]string, len(f.conf.Dimensions))
+	for i, name := range f.conf.Dimensions {
+		f.names[i] = name
+		if field := f.dimensions.fields[i]; field != "" {
+			f.names[i] = field
+		}
+	}
+	for _, agg := range f.conf.Aggregations {
+		switch agg {
+		case "sum", "avg", "min", "max":
+		default:
+			return fmt.Errorf("unknown aggregation '%s'", agg)
+		}
+	}
+	if f.conf.MaxGroups == 0 {
+		return errors.New("'max_groups' must be greater than 0")
+	}
+	if f.conf.MessageType == "" {
+		return errors.New("'message_type' must not be empty")
+	}
+	if f.conf.TickerInterval == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	f.groups = make(map[string]*rollupGroup)
+	return nil
+}
+
+func (f *RollupFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *RollupFilter) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	dimensions := make([]string, len(f.names))
+	for i := range f.names {
+		dimensions[i] = f.dimensions.value(msg, i)
+	}
+	key := strings.Join(dimensions, "\x00")
+	g, ok := f.groups[key]
+	if !ok {
+		if len(f.groups) >= int(f.conf.MaxGroups) {
+			atomic.AddInt64(&f.overflow, 1)
+			return nil
+		}
+		g = &rollupGroup{
+			dimensions: dimensions,
+			stats:      make([]numericStats, len(f.conf.Fields)),
+		}
+		f.groups[key] = g
+	}
+	g.count++
+	for i, name := range f.conf.Fields {
+		switch v := getNumericField(msg, name).(type) {
+		case int64:
+			g.stats[i].add(float64(v))
+		case float64:
+			g.stats[i].add(v)
+		}
+	}
+	return nil
+}
+
+func (f *RollupFilter) TimerEvent() error {
+	now := time.Now()
+	for _, g := range f.groups {
+		if err := f.injectGroup(g, now); err != nil {
+			return err
+		}
+	}
+	f.groups = make(map[string]*rollupGroup)
+	return nil
+}
+
+func (f *RollupFilter) injectGroup(g *rollupGroup, now time.Time) error {
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetType(f.conf.MessageType)
+	msg.SetLogger(f.fr.Name())
+	msg.SetTimestamp(now.UnixNano())
+	for i, name := range f.names {
+		message.NewStringField(msg, name, g.dimensions[i])
+	}
+	message.NewInt64Field(msg, "Count", g.count, "count")
+	for i, name := range f.conf.Fields {
+		stats := g.stats[i]
+		if stats.values == 0 {
+			continue
+		}
+		for _, agg := range f.conf.Aggregations {
+			var value float64
+			switch agg {
+			case "sum":
+				value = stats.sum
+			case "avg":
+				value = stats.avg()
+			case "min":
+				value = stats.min
+			case "max":
+				value = stats.max
+			}
+			addDoubleField(msg, name+"_"+agg, value, "")
+		}
+	}
+	message.NewInt64Field(msg, "Window", int64(f.conf.TickerInterval), "s")
+	f.fr.Inject(pack)
+	return nil
+}
+
+// ReportMsg provides the number of messages that didn't fit in max_groups to
+// Heka's report and dashboard.
+func (f *RollupFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "Overflow", atomic.LoadInt64(&f.overflow),
+		"count")
+	return nil
+}
+
+func (f *RollupFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("RollupFilter", func() interface{} {
+		return new(RollupFilter)
+	})
+}
@@ -0,0 +1,134 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RollupFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A RollupFilter", func() {
+		filter := new(RollupFilter)
+		config := filter.ConfigStruct().(*RollupFilterConfig)
+		config.Dimensions = []string{"Hostname", "Fields[status]"}
+		config.Fields = []string{"bytes", "duration"}
+
+		c.Specify("rejects unknown aggregations", func() {
+			config.Aggregations = []string{"median"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown dimensions", func() {
+			config.Dimensions = []string{"Timestamp"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rolls up messages", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			fr.EXPECT().Name().Return("rollup").AnyTimes()
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			send := func(host string, status, bytes int64, duration float64) {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message.SetHostname(host)
+				message.NewInt64Field(pack.Message, "status", status, "")
+				message.NewInt64Field(pack.Message, "bytes", bytes, "B")
+				if duration >= 0 {
+					addDoubleField(pack.Message, "duration", duration, "s")
+				}
+				filter.ProcessMessage(pack)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+			byGroup := func() map[string]*message.Message {
+				groups := make(map[string]*message.Message)
+				for _, msg := range injected {
+					groups[value(msg, "Hostname").(string)+
+						value(msg, "status").(string)] = msg
+				}
+				return groups
+			}
+
+			c.Specify("by dimension", func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+
+				send("web1", 200, 100, 0.5)
+				send("web1", 200, 300, 1.5)
+				send("web1", 500, 10, -1)
+				send("web2", 200, 50, 0.1)
+				err = filter.TimerEvent()
+				c.Expect(err, gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 3)
+
+				groups := byGroup()
+				ok := groups["web1200"]
+				c.Assume(ok, gs.Not(gs.IsNil))
+				c.Expect(ok.GetType(), gs.Equals, "heka.rollup")
+				c.Expect(value(ok, "Count"), gs.Equals, int64(2))
+				c.Expect(value(ok, "bytes_sum"), gs.Equals, 400.0)
+				c.Expect(value(ok, "bytes_avg"), gs.Equals, 200.0)
+				c.Expect(value(ok, "duration_min"), gs.Equals, 0.5)
+				c.Expect(value(ok, "duration_max"), gs.Equals, 1.5)
+
+				errs := groups["web1500"]
+				c.Assume(errs, gs.Not(gs.IsNil))
+				c.Expect(value(errs, "Count"), gs.Equals, int64(1))
+				c.Expect(errs.FindFirstField("duration_sum"), gs.IsNil)
+
+				// The window tumbles.
+				injected = nil
+				filter.TimerEvent()
+				c.Expect(len(injected), gs.Equals, 0)
+			})
+
+			c.Specify("up to max_groups", func() {
+				config.MaxGroups = 1
+				config.Aggregations = []string{"max"}
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+
+				send("web1", 200, 100, 0.5)
+				send("web2", 200, 100, 0.5)
+				filter.TimerEvent()
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(injected[0].FindFirstField("bytes_sum"), gs.IsNil)
+				c.Expect(value(injected[0], "bytes_max"), gs.Equals, 100.0)
+
+				msg := new(message.Message)
+				filter.ReportMsg(msg)
+				c.Expect(value(msg, "Overflow"), gs.Equals, int64(1))
+			})
+		})
+	})
+}
@@ -0,0 +1,150 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Resolution of hash based sampling.
+const sampleHashBuckets = 1000000
+
+type SampleFilterConfig struct {
+	// Forwards one in this many messages. Mutually exclusive with Percent.
+	OneIn uint `toml:"one_in"`
+	// Percentage of messages forwarded. Mutually exclusive with OneIn.
+	Percent float64
+	// Message variable whose value decides whether a message is sampled,
+	// e.g. "Fields[request_id]", so all messages sharing the value are kept
+	// or dropped together. Defaults to sampling each message independently.
+	HashField string `toml:"hash_field"`
+	// Name of the field holding the sampling rate on the injected messages.
+	// Defaults to "SampleRate".
+	RateField string `toml:"rate_field"`
+	// Appended to the Type of the injected messages. Defaults to ".sampled".
+	TypeSuffix string `toml:"type_suffix"`
+}
+
+// SampleFilter injects copies of a sample of the messages it receives, tagged
+// with the sampling rate so downstream aggregations can scale counts back up.
+type SampleFilter struct {
+	conf *SampleFilterConfig
+	// Fraction of messages sampled.
+	probability float64
+	// Number of messages each sampled one stands for.
+	rate    float64
+	hashKey *messageKey
+	// Messages seen since the last sampled one, for unhashed one_in sampling.
+	count uint
+	rand  *rand.Rand
+	fr    FilterRunner
+	h     PluginHelper
+}
+
+func (f *SampleFilter) ConfigStruct() interface{} {
+	return &SampleFilterConfig{
+		RateField:  "SampleRate",
+		TypeSuffix: ".sampled",
+	}
+}
+
+func (f *SampleFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*SampleFilterConfig)
+	switch {
+	case f.conf.OneIn > 0 && f.conf.Percent > 0:
+		return errors.New("only one of 'one_in' and 'percent' may be specified")
+	case f.conf.OneIn > 0:
+		f.probability = 1 / float64(f.conf.OneIn)
+	case f.conf.Percent > 0 && f.conf.Percent <= 100:
+		f.probability = f.conf.Percent / 100
+	case f.conf.Percent != 0:
+		return fmt.Errorf("'percent' must be between 0 exclusive and 100: %g",
+			f.conf.Percent)
+	default:
+		return errors.New("one of 'one_in' or 'percent' must be specified")
+	}
+	f.rate = 1 / f.probability
+	if f.conf.RateField == "" {
+		return errors.New("'rate_field' must not be empty")
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	if f.conf.HashField != "" {
+		if f.hashKey, err = newMessageKey([]string{f.conf.HashField}); err != nil {
+			return fmt.Errorf("hash_field: %s", err)
+		}
+	}
+	f.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return nil
+}
+
+func (f *SampleFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// Decides whether the message is part of the sample.
+func (f *SampleFilter) sampled(msg *message.Message) bool {
+	if f.hashKey != nil {
+		hash := fnv.New64a()
+		hash.Write([]byte(f.hashKey.key(msg)))
+		// The low bits of FNV hashes are better distributed than the high
+		// ones for short values.
+		return float64(hash.Sum64()%sampleHashBuckets)/sampleHashBuckets <
+			f.probability
+	}
+	if f.conf.OneIn > 0 {
+		f.count++
+		if f.count < f.conf.OneIn {
+			return false
+		}
+		f.count = 0
+		return true
+	}
+	return f.rand.Float64() < f.probability
+}
+
+func (f *SampleFilter) ProcessMessage(pack *PipelinePack) error {
+	if !f.sampled(pack.Message) {
+		return nil
+	}
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	if field, err := message.NewField(f.conf.RateField, f.rate, ""); err == nil {
+		newPack.Message.AddField(field)
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *SampleFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("SampleFilter", func() interface{} {
+		return new(SampleFilter)
+	})
+}
@@ -0,0 +1,124 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SampleFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A SampleFilter", func() {
+		filter := new(SampleFilter)
+		config := filter.ConfigStruct().(*SampleFilterConfig)
+
+		c.Specify("requires a sampling rate", func() {
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+			config.Percent = 120
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("doesn't accept both one_in and percent", func() {
+			config.OneIn = 10
+			config.Percent = 10
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("samples messages", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			send := func(requestId string) {
+				pack := NewPipelinePack(recycleChan)
+				pack.Message = new(message.Message)
+				pack.Message.SetType("web.request")
+				message.NewStringField(pack.Message, "request_id", requestId)
+				filter.ProcessMessage(pack)
+			}
+			start := func() {
+				err := filter.Init(config)
+				c.Assume(err, gs.IsNil)
+				filter.Prepare(fr, h)
+				filter.rand = rand.New(rand.NewSource(1))
+			}
+
+			c.Specify("one in N", func() {
+				config.OneIn = 4
+				start()
+				for i := 0; i < 10; i++ {
+					send(fmt.Sprint(i))
+				}
+				c.Assume(len(injected), gs.Equals, 2)
+				c.Expect(injected[0].GetType(), gs.Equals, "web.request.sampled")
+				v, _ := injected[0].GetFieldValue("request_id")
+				c.Expect(v, gs.Equals, "3")
+				v, _ = injected[1].GetFieldValue("SampleRate")
+				c.Expect(v, gs.Equals, 4.0)
+			})
+
+			c.Specify("by percentage", func() {
+				config.Percent = 25
+				start()
+				for i := 0; i < 1000; i++ {
+					send(fmt.Sprint(i))
+				}
+				c.Expect(len(injected) > 200 && len(injected) < 300, gs.IsTrue)
+			})
+
+			c.Specify("consistently by hash field", func() {
+				config.Percent = 50
+				config.HashField = "Fields[request_id]"
+				start()
+				for i := 0; i < 100; i++ {
+					send(fmt.Sprint(i))
+				}
+				kept := make(map[interface{}]bool)
+				for _, msg := range injected {
+					v, _ := msg.GetFieldValue("request_id")
+					kept[v] = true
+				}
+				c.Expect(len(kept) > 30 && len(kept) < 70, gs.IsTrue)
+
+				// The same requests are sampled again.
+				first := len(injected)
+				for i := 0; i < 100; i++ {
+					send(fmt.Sprint(i))
+				}
+				c.Assume(len(injected), gs.Equals, 2*first)
+				for _, msg := range injected[first:] {
+					v, _ := msg.GetFieldValue("request_id")
+					c.Expect(kept[v], gs.IsTrue)
+				}
+			})
+		})
+	})
+}
@@ -0,0 +1,205 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type SchemaDriftFilterConfig struct {
+	// Number of messages of a Type used to learn its field profile before
+	// changes are reported. Defaults to 1000.
+	LearningMessages uint `toml:"learning_messages"`
+	// Seconds a known field may be missing from messages of its Type
+	// before it's reported as vanished. Defaults to 3600.
+	VanishAfter uint `toml:"vanish_after"`
+	// Maximum number of message Types tracked. Messages of further Types
+	// are ignored. Defaults to 1000.
+	MaxTypes uint `toml:"max_types"`
+	// Interval in seconds at which vanished fields are checked for.
+	// Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+type schemaField struct {
+	valueType message.Field_ValueType
+	lastSeen  time.Time
+}
+
+// Field profile learned for one message Type.
+type schemaProfile struct {
+	messages uint
+	lastSeen time.Time
+	fields   map[string]*schemaField
+}
+
+// SchemaDriftFilter learns the names and value types of the fields carried
+// by each message Type and emits alert messages when, once learned, a new
+// field appears, a field's type changes, or a field stops being sent.
+type SchemaDriftFilter struct {
+	conf        *SchemaDriftFilterConfig
+	vanishAfter time.Duration
+	profiles    map[string]*schemaProfile
+	now         func() time.Time
+	fr          FilterRunner
+	h           PluginHelper
+}
+
+func (f *SchemaDriftFilter) ConfigStruct() interface{} {
+	return &SchemaDriftFilterConfig{
+		LearningMessages: 1000,
+		VanishAfter:      3600,
+		MaxTypes:         1000,
+		TickerInterval:   60,
+	}
+}
+
+func (f *SchemaDriftFilter) Init(config interface{}) error {
+	f.conf = config.(*SchemaDriftFilterConfig)
+	if f.conf.VanishAfter == 0 {
+		return errors.New("'vanish_after' must be greater than 0")
+	}
+	if f.conf.MaxTypes == 0 {
+		return errors.New("'max_types' must be greater than 0")
+	}
+	f.vanishAfter = time.Duration(f.conf.VanishAfter) * time.Second
+	f.profiles = make(map[string]*schemaProfile)
+	if f.now == nil {
+		f.now = time.Now
+	}
+	return nil
+}
+
+func (f *SchemaDriftFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func valueTypeName(t message.Field_ValueType) string {
+	return strings.ToLower(t.String())
+}
+
+func (f *SchemaDriftFilter) ProcessMessage(pack *PipelinePack) error {
+	msgType := pack.Message.GetType()
+	profile, ok := f.profiles[msgType]
+	if !ok {
+		if len(f.profiles) >= int(f.conf.MaxTypes) {
+			return nil
+		}
+		profile = &schemaProfile{fields: make(map[string]*schemaField)}
+		f.profiles[msgType] = profile
+	}
+	now := f.now()
+	learning := profile.messages < f.conf.LearningMessages
+	profile.messages++
+	profile.lastSeen = now
+
+	for _, field := range pack.Message.Fields {
+		name := field.GetName()
+		valueType := field.GetValueType()
+		known, ok := profile.fields[name]
+		if !ok {
+			profile.fields[name] = &schemaField{valueType, now}
+			if !learning {
+				f.injectAlert(msgType, name, "added", valueType, nil)
+			}
+			continue
+		}
+		known.lastSeen = now
+		if known.valueType != valueType {
+			previous := known.valueType
+			known.valueType = valueType
+			if !learning {
+				f.injectAlert(msgType, name, "type_changed", valueType, &previous)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *SchemaDriftFilter) injectAlert(msgType, name, change string,
+	valueType message.Field_ValueType, previous *message.Field_ValueType) {
+
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		f.fr.LogError(err)
+		return
+	}
+	msg := pack.Message
+	msg.SetType("heka.schema.alert")
+	msg.SetLogger(f.fr.Name())
+	msg.SetSeverity(4)
+	message.NewStringField(msg, "MessageType", msgType)
+	message.NewStringField(msg, "FieldName", name)
+	message.NewStringField(msg, "Change", change)
+	message.NewStringField(msg, "ValueType", valueTypeName(valueType))
+	switch change {
+	case "added":
+		msg.SetPayload(fmt.Sprintf("%s: new %s field '%s'", msgType,
+			valueTypeName(valueType), name))
+	case "type_changed":
+		message.NewStringField(msg, "PreviousValueType", valueTypeName(*previous))
+		msg.SetPayload(fmt.Sprintf("%s: field '%s' changed from %s to %s", msgType,
+			name, valueTypeName(*previous), valueTypeName(valueType)))
+	case "vanished":
+		msg.SetPayload(fmt.Sprintf("%s: %s field '%s' not seen for %s", msgType,
+			valueTypeName(valueType), name, f.vanishAfter))
+	}
+	f.fr.Inject(pack)
+}
+
+// Reports and forgets fields that haven't been seen within vanish_after
+// while messages of their Type kept arriving.
+func (f *SchemaDriftFilter) TimerEvent() error {
+	now := f.now()
+	types := make([]string, 0, len(f.profiles))
+	for msgType := range f.profiles {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	for _, msgType := range types {
+		profile := f.profiles[msgType]
+		if profile.messages < f.conf.LearningMessages {
+			continue
+		}
+		var vanished []string
+		for name, field := range profile.fields {
+			if now.Sub(field.lastSeen) >= f.vanishAfter &&
+				profile.lastSeen.After(field.lastSeen) {
+
+				vanished = append(vanished, name)
+			}
+		}
+		sort.Strings(vanished)
+		for _, name := range vanished {
+			f.injectAlert(msgType, name, "vanished", profile.fields[name].valueType, nil)
+			delete(profile.fields, name)
+		}
+	}
+	return nil
+}
+
+func (f *SchemaDriftFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("SchemaDriftFilter", func() interface{} {
+		return new(SchemaDriftFilter)
+	})
+}
@@ -0,0 +1,128 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SchemaDriftFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A SchemaDriftFilter", func() {
+		filter := new(SchemaDriftFilter)
+		config := filter.ConfigStruct().(*SchemaDriftFilterConfig)
+		config.LearningMessages = 2
+		config.VanishAfter = 60
+
+		c.Specify("requires a non-zero vanish_after", func() {
+			config.VanishAfter = 0
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("reports field changes once learned", func() {
+			now := time.Unix(1456833600, 0)
+			filter.now = func() time.Time { return now }
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			filter.Prepare(fr, h)
+			fr.EXPECT().Name().Return("schema").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			pack := NewPipelinePack(recycleChan)
+			send := func(msgType string, fields map[string]interface{}) {
+				pack.Message = new(message.Message)
+				pack.Message.SetType(msgType)
+				for name, value := range fields {
+					f, _ := message.NewField(name, value, "")
+					pack.Message.AddField(f)
+				}
+				filter.ProcessMessage(pack)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+
+			// Fields seen while learning don't alert.
+			send("access", map[string]interface{}{"status": int64(200)})
+			send("access", map[string]interface{}{"status": int64(200), "path": "/"})
+			c.Expect(len(injected), gs.Equals, 0)
+
+			send("access", map[string]interface{}{"status": "OK", "path": "/",
+				"referer": "x"})
+			c.Assume(len(injected), gs.Equals, 2)
+			var added, changed *message.Message
+			for _, msg := range injected {
+				c.Expect(msg.GetType(), gs.Equals, "heka.schema.alert")
+				c.Expect(msg.GetLogger(), gs.Equals, "schema")
+				c.Expect(value(msg, "MessageType"), gs.Equals, "access")
+				if value(msg, "Change") == "added" {
+					added = msg
+				} else {
+					changed = msg
+				}
+			}
+			c.Assume(added, gs.Not(gs.IsNil))
+			c.Expect(value(added, "FieldName"), gs.Equals, "referer")
+			c.Expect(value(added, "ValueType"), gs.Equals, "string")
+			c.Assume(changed, gs.Not(gs.IsNil))
+			c.Expect(value(changed, "Change"), gs.Equals, "type_changed")
+			c.Expect(value(changed, "FieldName"), gs.Equals, "status")
+			c.Expect(value(changed, "PreviousValueType"), gs.Equals, "integer")
+
+			// Other Types are learned separately.
+			injected = nil
+			send("error", map[string]interface{}{"code": int64(1)})
+			c.Expect(len(injected), gs.Equals, 0)
+
+			// Fields are only reported as vanished while their Type is still
+			// arriving.
+			now = now.Add(2 * time.Minute)
+			c.Expect(filter.TimerEvent(), gs.IsNil)
+			c.Expect(len(injected), gs.Equals, 0)
+			send("access", map[string]interface{}{"status": "OK", "path": "/"})
+			c.Expect(filter.TimerEvent(), gs.IsNil)
+			c.Assume(len(injected), gs.Equals, 1)
+			c.Expect(value(injected[0], "Change"), gs.Equals, "vanished")
+			c.Expect(value(injected[0], "FieldName"), gs.Equals, "referer")
+
+			// A vanished field that returns is new again.
+			injected = nil
+			c.Expect(filter.TimerEvent(), gs.IsNil)
+			c.Expect(len(injected), gs.Equals, 0)
+			send("access", map[string]interface{}{"status": "OK", "referer": "y"})
+			c.Assume(len(injected), gs.Equals, 1)
+			c.Expect(value(injected[0], "Change"), gs.Equals, "added")
+		})
+	})
+}
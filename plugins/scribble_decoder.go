@@ -15,6 +15,9 @@
 package plugins
 
 import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 )
 
@@ -36,6 +39,11 @@ func (sd *ScribbleDecoder) ConfigStruct() interface{} {
 
 func (sd *ScribbleDecoder) Init(config interface{}) (err error) {
 	conf := config.(*ScribbleDecoderConfig)
+	// Catch unparseable Pid, Severity or Uuid values now rather than failing
+	// every message.
+	if err = conf.MessageFields.PopulateMessage(new(message.Message), nil); err != nil {
+		return fmt.Errorf("invalid message_fields: %s", err)
+	}
 	sd.messageFields = conf.MessageFields
 	return
 }
@@ -43,5 +43,26 @@ func ScribbleDecoderSpec(c gs.Context) {
 			c.Expect(pack.Message.GetType(), gs.Equals, myType)
 			c.Expect(pack.Message.GetPayload(), gs.Equals, myPayload)
 		})
+
+		c.Specify("sets user fields", func() {
+			config.MessageFields["datacenter"] = "ams1"
+			config.MessageFields["env"] = "prod"
+			err := decoder.Init(config)
+			c.Assume(err, gs.IsNil)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			value, ok := pack.Message.GetFieldValue("datacenter")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "ams1")
+			value, ok = pack.Message.GetFieldValue("env")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "prod")
+		})
+
+		c.Specify("rejects values that can't be parsed", func() {
+			config.MessageFields["Severity"] = "high"
+			err := decoder.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
 	})
 }
@@ -0,0 +1,207 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mozilla-services/heka/message"
+)
+
+// Message type of the standard aggregate schema. Aggregate messages carry
+// the following fields, all of which are mergeable:
+//
+//   Aggregate (string): what was aggregated, usually a field name
+//   AggregateKey (string): the group the values belong to, may be empty
+//   Count (int), Sum, Min, Max (double): summary of the values
+//   Sketch (bytes): optional serialized TDigest of the values
+//
+// Derived fields such as Mean and percentiles may also be present but are
+// recomputed, never merged.
+const AggregateType = "heka.aggregate"
+
+// Aggregate summarizes a set of numeric values such that summaries
+// computed by different agents can be combined exactly (or, for the
+// sketch, with bounded error) instead of averaging averages.
+type Aggregate struct {
+	Name   string
+	Key    string
+	Count  int64
+	Sum    float64
+	Min    float64
+	Max    float64
+	Sketch *TDigest
+}
+
+// Creates an empty aggregate. If compression is greater than zero, a sketch
+// of the values is kept as well.
+func NewAggregate(name, key string, compression float64) *Aggregate {
+	a := &Aggregate{
+		Name: name,
+		Key:  key,
+		Min:  math.Inf(1),
+		Max:  math.Inf(-1),
+	}
+	if compression > 0 {
+		a.Sketch = NewTDigest(compression)
+	}
+	return a
+}
+
+// Adds a single value.
+func (a *Aggregate) Add(v float64) {
+	a.Count++
+	a.Sum += v
+	if v < a.Min {
+		a.Min = v
+	}
+	if v > a.Max {
+		a.Max = v
+	}
+	if a.Sketch != nil {
+		a.Sketch.Add(v)
+	}
+}
+
+// Folds other into a. If either side has no sketch the result has none,
+// since a partial sketch would skew the percentiles.
+func (a *Aggregate) Merge(other *Aggregate) {
+	if other.Count == 0 {
+		return
+	}
+	if a.Count == 0 && a.Sketch == nil && other.Sketch != nil {
+		a.Sketch = NewTDigest(other.Sketch.compression)
+	}
+	a.Count += other.Count
+	a.Sum += other.Sum
+	if other.Min < a.Min {
+		a.Min = other.Min
+	}
+	if other.Max > a.Max {
+		a.Max = other.Max
+	}
+	if a.Sketch != nil && other.Sketch != nil {
+		a.Sketch.Merge(other.Sketch)
+	} else {
+		a.Sketch = nil
+	}
+}
+
+// Returns the mean of the values, or NaN if there are none.
+func (a *Aggregate) Mean() float64 {
+	if a.Count == 0 {
+		return math.NaN()
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// Writes the aggregate to msg using the standard aggregate schema, followed
+// by a Mean field and, if there is a sketch, one field per percentile named
+// after the corresponding entry of names.
+func (a *Aggregate) Encode(msg *message.Message, percentiles []float64,
+	names []string) error {
+
+	msg.SetType(AggregateType)
+	message.NewStringField(msg, "Aggregate", a.Name)
+	message.NewStringField(msg, "AggregateKey", a.Key)
+	message.NewInt64Field(msg, "Count", a.Count, "count")
+	addDoubleField(msg, "Sum", a.Sum)
+	addDoubleField(msg, "Min", a.Min)
+	addDoubleField(msg, "Max", a.Max)
+	addDoubleField(msg, "Mean", a.Mean())
+	if a.Sketch == nil {
+		return nil
+	}
+	data, err := a.Sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	field, err := message.NewField("Sketch", data, "")
+	if err != nil {
+		return err
+	}
+	msg.AddField(field)
+	for i, p := range percentiles {
+		addDoubleField(msg, names[i], a.Sketch.Quantile(p/100))
+	}
+	return nil
+}
+
+func addDoubleField(msg *message.Message, name string, value float64) {
+	if field, err := message.NewField(name, value, ""); err == nil {
+		msg.AddField(field)
+	}
+}
+
+func doubleField(msg *message.Message, name string) (float64, error) {
+	v, _ := msg.GetFieldValue(name)
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("aggregate has no double %s field", name)
+	}
+	return f, nil
+}
+
+// Reads an aggregate written by Encode.
+func DecodeAggregate(msg *message.Message) (a *Aggregate, err error) {
+	if msg.GetType() != AggregateType {
+		return nil, fmt.Errorf("not an aggregate message: %s", msg.GetType())
+	}
+	a = new(Aggregate)
+	v, _ := msg.GetFieldValue("Aggregate")
+	if a.Name, _ = v.(string); a.Name == "" {
+		return nil, errors.New("aggregate has no Aggregate field")
+	}
+	v, _ = msg.GetFieldValue("AggregateKey")
+	a.Key, _ = v.(string)
+	v, _ = msg.GetFieldValue("Count")
+	var ok bool
+	if a.Count, ok = v.(int64); !ok || a.Count < 0 {
+		return nil, errors.New("aggregate has no valid Count field")
+	}
+	if a.Sum, err = doubleField(msg, "Sum"); err != nil {
+		return nil, err
+	}
+	if a.Min, err = doubleField(msg, "Min"); err != nil {
+		return nil, err
+	}
+	if a.Max, err = doubleField(msg, "Max"); err != nil {
+		return nil, err
+	}
+	if v, ok = msg.GetFieldValue("Sketch"); ok {
+		data, ok := v.([]byte)
+		if !ok {
+			return nil, errors.New("aggregate Sketch field isn't a bytes field")
+		}
+		a.Sketch = new(TDigest)
+		if err = a.Sketch.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// Returns the field names used for percentiles, e.g. "P99.9" for 99.9,
+// after checking that they're in range.
+func percentileNames(percentiles []float64) ([]string, error) {
+	names := make([]string, len(percentiles))
+	for i, p := range percentiles {
+		if p <= 0 || p >= 100 {
+			return nil, fmt.Errorf("percentiles must be between 0 and 100 exclusive: %g", p)
+		}
+		names[i] = "P" + strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return names, nil
+}
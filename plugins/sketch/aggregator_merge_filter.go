@@ -0,0 +1,91 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type AggregatorMergeFilterConfig struct {
+	// Percentiles emitted with each merged aggregate that has a sketch.
+	// Defaults to [50, 90, 99].
+	Percentiles []float64
+	// Interval in seconds at which merged aggregates are emitted and reset.
+	// Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// AggregatorMergeFilter combines heka.aggregate messages, typically emitted
+// by many agents on a lower tier, by aggregate name and key, and
+// periodically emits the merged aggregates in the same format so that
+// further tiers can merge them again.
+type AggregatorMergeFilter struct {
+	conf       *AggregatorMergeFilterConfig
+	aggregates map[string]*Aggregate
+	names      []string
+	fr         FilterRunner
+	h          PluginHelper
+}
+
+func (f *AggregatorMergeFilter) ConfigStruct() interface{} {
+	return &AggregatorMergeFilterConfig{
+		Percentiles:    []float64{50, 90, 99},
+		TickerInterval: 60,
+	}
+}
+
+func (f *AggregatorMergeFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*AggregatorMergeFilterConfig)
+	if f.names, err = percentileNames(f.conf.Percentiles); err != nil {
+		return err
+	}
+	f.aggregates = make(map[string]*Aggregate)
+	return nil
+}
+
+func (f *AggregatorMergeFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *AggregatorMergeFilter) ProcessMessage(pack *PipelinePack) error {
+	in, err := DecodeAggregate(pack.Message)
+	if err != nil {
+		return err
+	}
+	id := in.Name + "\x00" + in.Key
+	if a, ok := f.aggregates[id]; ok {
+		a.Merge(in)
+	} else {
+		f.aggregates[id] = in
+	}
+	return nil
+}
+
+func (f *AggregatorMergeFilter) TimerEvent() error {
+	if err := injectAggregates(f.fr, f.h, f.aggregates, f.conf.Percentiles,
+		f.names); err != nil {
+
+		return err
+	}
+	f.aggregates = make(map[string]*Aggregate)
+	return nil
+}
+
+func (f *AggregatorMergeFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("AggregatorMergeFilter", func() interface{} {
+		return new(AggregatorMergeFilter)
+	})
+}
@@ -0,0 +1,158 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"math"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func AggregatorMergeFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	value := func(msg *message.Message, name string) interface{} {
+		v, _ := msg.GetFieldValue(name)
+		return v
+	}
+	// Builds the aggregate message an agent would emit for values.
+	encode := func(key string, compression float64, values ...float64) *message.Message {
+		a := NewAggregate("latency", key, compression)
+		for _, v := range values {
+			a.Add(v)
+		}
+		msg := new(message.Message)
+		a.Encode(msg, nil, nil)
+		return msg
+	}
+
+	c.Specify("An Aggregate", func() {
+		c.Specify("round trips through a message", func() {
+			msg := encode("/api", 100, 3, 1, 2)
+			a, err := DecodeAggregate(msg)
+			c.Assume(err, gs.IsNil)
+			c.Expect(a.Name, gs.Equals, "latency")
+			c.Expect(a.Key, gs.Equals, "/api")
+			c.Expect(a.Count, gs.Equals, int64(3))
+			c.Expect(a.Sum, gs.Equals, 6.0)
+			c.Expect(a.Min, gs.Equals, 1.0)
+			c.Expect(a.Max, gs.Equals, 3.0)
+			c.Expect(a.Sketch.Quantile(0.5), gs.Equals, 2.0)
+		})
+
+		c.Specify("rejects other messages", func() {
+			msg := encode("", 0, 1)
+			msg.SetType("nginx.access")
+			_, err := DecodeAggregate(msg)
+			c.Expect(err, gs.Not(gs.IsNil))
+
+			msg = encode("", 0, 1)
+			msg.DeleteField(msg.FindFirstField("Sum"))
+			_, err = DecodeAggregate(msg)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("drops the sketch when merging one without", func() {
+			a := NewAggregate("latency", "", 100)
+			a.Add(1)
+			b := NewAggregate("latency", "", 0)
+			b.Add(5)
+			a.Merge(b)
+			c.Expect(a.Count, gs.Equals, int64(2))
+			c.Expect(a.Mean(), gs.Equals, 3.0)
+			c.Expect(a.Sketch == nil, gs.IsTrue)
+		})
+
+		c.Specify("has no mean when empty", func() {
+			c.Expect(math.IsNaN(NewAggregate("latency", "", 0).Mean()), gs.IsTrue)
+		})
+	})
+
+	c.Specify("An AggregatorMergeFilter", func() {
+		filter := new(AggregatorMergeFilter)
+		config := filter.ConfigStruct().(*AggregatorMergeFilterConfig)
+		err := filter.Init(config)
+		c.Assume(err, gs.IsNil)
+
+		fr := pipelinemock.NewMockFilterRunner(ctrl)
+		h := pipelinemock.NewMockPluginHelper(ctrl)
+		filter.Prepare(fr, h)
+		fr.EXPECT().Name().Return("latency_merge").AnyTimes()
+
+		recycleChan := make(chan *PipelinePack, 10)
+		var injected []*message.Message
+		fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+			injected = append(injected, p.Message)
+			p.Message = new(message.Message)
+		}).Return(true).AnyTimes()
+		h.EXPECT().PipelinePack(uint(0)).Return(
+			NewPipelinePack(recycleChan), nil).AnyTimes()
+		pack := NewPipelinePack(recycleChan)
+
+		c.Specify("merges aggregates by key", func() {
+			// Two agents see very different latencies; averaging their
+			// means would give 27.5 rather than the true 9.5.
+			var slow, fast []float64
+			for i := 1; i <= 10; i++ {
+				slow = append(slow, 50)
+			}
+			for i := 1; i <= 90; i++ {
+				fast = append(fast, 5)
+			}
+			for _, msg := range []*message.Message{
+				encode("/api", 100, slow...),
+				encode("/api", 100, fast...),
+				encode("/health", 100, 1),
+			} {
+				pack.Message = msg
+				c.Expect(filter.ProcessMessage(pack), gs.IsNil)
+			}
+
+			err = filter.TimerEvent()
+			c.Expect(err, gs.IsNil)
+			c.Assume(len(injected), gs.Equals, 2)
+			var api *message.Message
+			for _, msg := range injected {
+				c.Expect(msg.GetType(), gs.Equals, "heka.aggregate")
+				c.Expect(msg.GetLogger(), gs.Equals, "latency_merge")
+				if value(msg, "AggregateKey") == "/api" {
+					api = msg
+				}
+			}
+			c.Assume(api, gs.Not(gs.IsNil))
+			c.Expect(value(api, "Count"), gs.Equals, int64(100))
+			c.Expect(value(api, "Mean"), gs.Equals, 9.5)
+			c.Expect(value(api, "Min"), gs.Equals, 5.0)
+			c.Expect(value(api, "Max"), gs.Equals, 50.0)
+			c.Expect(value(api, "P50"), gs.Equals, 5.0)
+			c.Expect(value(api, "P99"), gs.Equals, 50.0)
+
+			// The merged output can itself be merged on a higher tier.
+			merged, err := DecodeAggregate(api)
+			c.Expect(err, gs.IsNil)
+			c.Expect(merged.Sum, gs.Equals, 950.0)
+		})
+
+		c.Specify("rejects messages that aren't aggregates", func() {
+			pack.Message = new(message.Message)
+			pack.Message.SetType("nginx.access")
+			c.Expect(filter.ProcessMessage(pack), gs.Not(gs.IsNil))
+		})
+	})
+}
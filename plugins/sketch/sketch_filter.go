@@ -0,0 +1,158 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type SketchFilterConfig struct {
+	// Name of the numeric message field whose values are tracked.
+	ValueField string `toml:"value_field"`
+	// Optional name of a field whose value selects the sketch a message's
+	// values are added to, e.g. an endpoint name. Messages without it are
+	// tracked under the empty key.
+	KeyField string `toml:"key_field"`
+	// Percentiles emitted with each sketch. Defaults to [50, 90, 99].
+	Percentiles []float64
+	// t-digest compression; higher values trade size for accuracy.
+	// Defaults to 100.
+	Compression float64
+	// Interval in seconds at which sketches are emitted and reset.
+	// Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// SketchFilter maintains an aggregate, including a t-digest, per key for
+// the values of a numeric field and periodically emits them as heka.aggregate
+// messages along with the configured percentiles. The aggregates can be
+// combined across hosts by an AggregatorMergeFilter.
+type SketchFilter struct {
+	conf       *SketchFilterConfig
+	aggregates map[string]*Aggregate
+	names      []string
+	fr         FilterRunner
+	h          PluginHelper
+}
+
+func (f *SketchFilter) ConfigStruct() interface{} {
+	return &SketchFilterConfig{
+		Percentiles:    []float64{50, 90, 99},
+		Compression:    100,
+		TickerInterval: 60,
+	}
+}
+
+func (f *SketchFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*SketchFilterConfig)
+	if f.conf.ValueField == "" {
+		return errors.New("'value_field' must be specified")
+	}
+	if f.conf.Compression < 10 {
+		return fmt.Errorf("'compression' must be at least 10: %g", f.conf.Compression)
+	}
+	if f.names, err = percentileNames(f.conf.Percentiles); err != nil {
+		return err
+	}
+	f.aggregates = make(map[string]*Aggregate)
+	return nil
+}
+
+func (f *SketchFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *SketchFilter) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	field := msg.FindFirstField(f.conf.ValueField)
+	if field == nil {
+		return fmt.Errorf("message has no %s field", f.conf.ValueField)
+	}
+	var key string
+	if f.conf.KeyField != "" {
+		if v, ok := msg.GetFieldValue(f.conf.KeyField); ok {
+			key = fmt.Sprint(v)
+		}
+	}
+	a, ok := f.aggregates[key]
+	if !ok {
+		a = NewAggregate(f.conf.ValueField, key, f.conf.Compression)
+		f.aggregates[key] = a
+	}
+
+	switch field.GetValueType() {
+	case message.Field_DOUBLE:
+		for _, v := range field.GetValueDouble() {
+			a.Add(v)
+		}
+	case message.Field_INTEGER:
+		for _, v := range field.GetValueInteger() {
+			a.Add(float64(v))
+		}
+	case message.Field_STRING:
+		for _, s := range field.GetValueString() {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("non-numeric %s value: %s", f.conf.ValueField, s)
+			}
+			a.Add(v)
+		}
+	default:
+		return fmt.Errorf("%s field isn't numeric", f.conf.ValueField)
+	}
+	return nil
+}
+
+func (f *SketchFilter) TimerEvent() error {
+	if err := injectAggregates(f.fr, f.h, f.aggregates, f.conf.Percentiles,
+		f.names); err != nil {
+
+		return err
+	}
+	f.aggregates = make(map[string]*Aggregate)
+	return nil
+}
+
+// Injects one aggregate message per entry of aggregates.
+func injectAggregates(fr FilterRunner, h PluginHelper, aggregates map[string]*Aggregate,
+	percentiles []float64, names []string) error {
+
+	for _, a := range aggregates {
+		pack, err := h.PipelinePack(0)
+		if err != nil {
+			return err
+		}
+		pack.Message.SetLogger(fr.Name())
+		pack.Message.SetSeverity(6)
+		if err = a.Encode(pack.Message, percentiles, names); err != nil {
+			pack.Recycle(err)
+			return err
+		}
+		fr.Inject(pack)
+	}
+	return nil
+}
+
+func (f *SketchFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("SketchFilter", func() interface{} {
+		return new(SketchFilter)
+	})
+}
@@ -0,0 +1,108 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SketchFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A SketchFilter", func() {
+		filter := new(SketchFilter)
+		config := filter.ConfigStruct().(*SketchFilterConfig)
+		config.ValueField = "latency"
+
+		c.Specify("requires a value_field", func() {
+			config.ValueField = ""
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects out of range percentiles", func() {
+			config.Percentiles = []float64{50, 100}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("emits aggregates and percentiles per key", func() {
+			config.KeyField = "endpoint"
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			filter.Prepare(fr, h)
+			fr.EXPECT().Name().Return("latency_sketch").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			pack := NewPipelinePack(recycleChan)
+			send := func(endpoint string, latency int64) error {
+				pack.Message = new(message.Message)
+				message.NewStringField(pack.Message, "endpoint", endpoint)
+				message.NewInt64Field(pack.Message, "latency", latency, "ms")
+				return filter.ProcessMessage(pack)
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+
+			for i := int64(1); i <= 100; i++ {
+				c.Expect(send("/api", i), gs.IsNil)
+			}
+			c.Expect(send("/health", 3), gs.IsNil)
+
+			pack.Message = new(message.Message)
+			c.Expect(filter.ProcessMessage(pack), gs.Not(gs.IsNil))
+
+			err = filter.TimerEvent()
+			c.Expect(err, gs.IsNil)
+			c.Assume(len(injected), gs.Equals, 2)
+			var api *message.Message
+			for _, msg := range injected {
+				c.Expect(msg.GetType(), gs.Equals, "heka.aggregate")
+				c.Expect(value(msg, "Aggregate"), gs.Equals, "latency")
+				if value(msg, "AggregateKey") == "/api" {
+					api = msg
+				}
+			}
+			c.Assume(api, gs.Not(gs.IsNil))
+			c.Expect(value(api, "Count"), gs.Equals, int64(100))
+			c.Expect(value(api, "Min"), gs.Equals, 1.0)
+			c.Expect(value(api, "Max"), gs.Equals, 100.0)
+			c.Expect(value(api, "P50"), gs.Equals, 50.5)
+			c.Expect(value(api, "P99"), gs.Equals, 99.5)
+			c.Expect(value(api, "Sum"), gs.Equals, 5050.0)
+			c.Expect(value(api, "Mean"), gs.Equals, 50.5)
+
+			// Aggregates are reset once emitted.
+			injected = nil
+			filter.TimerEvent()
+			c.Expect(len(injected), gs.Equals, 0)
+		})
+	})
+}
@@ -0,0 +1,168 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// An item tracked by a SpaceSaving summary. Its estimated Count exceeds its
+// true count by at most Error.
+type SpaceSavingItem struct {
+	Item  string
+	Count float64
+	Error float64
+}
+
+type ssCounter struct {
+	SpaceSavingItem
+	// Position in the heap.
+	index int
+}
+
+// Min-heap of counters by count.
+type ssHeap []*ssCounter
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *ssHeap) Push(x interface{}) {
+	c := x.(*ssCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *ssHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// SpaceSaving is a Space-Saving summary (see Metwally, Agrawal & El Abbadi,
+// "Efficient Computation of Frequent and Top-k Elements in Data Streams"),
+// which tracks the approximate heaviest items of a stream in a fixed number
+// of counters. Items whose true weight exceeds 1/capacity of the total are
+// guaranteed to be tracked.
+type SpaceSaving struct {
+	capacity int
+	counters ssHeap
+	items    map[string]*ssCounter
+}
+
+// Creates an empty summary with the given number of counters.
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: make(ssHeap, 0, capacity),
+		items:    make(map[string]*ssCounter, capacity),
+	}
+}
+
+// Adds weight to an item. If the item isn't tracked and all counters are in
+// use, it replaces the item with the lowest count, inheriting its count as
+// its error.
+func (s *SpaceSaving) Add(item string, weight float64) {
+	if c, ok := s.items[item]; ok {
+		c.Count += weight
+		heap.Fix(&s.counters, c.index)
+		return
+	}
+	if len(s.counters) < s.capacity {
+		c := &ssCounter{SpaceSavingItem: SpaceSavingItem{Item: item, Count: weight}}
+		heap.Push(&s.counters, c)
+		s.items[item] = c
+		return
+	}
+	c := s.counters[0]
+	delete(s.items, c.Item)
+	c.Item = item
+	c.Error = c.Count
+	c.Count += weight
+	s.items[item] = c
+	heap.Fix(&s.counters, 0)
+}
+
+// Returns the count an untracked item may have, 0 unless all counters are in
+// use.
+func (s *SpaceSaving) minCount() float64 {
+	if len(s.counters) < s.capacity {
+		return 0
+	}
+	return s.counters[0].Count
+}
+
+// Merges another summary into this one. Items only tracked by one of the
+// summaries get the other's minimum count added to their count and error.
+func (s *SpaceSaving) Merge(other *SpaceSaving) {
+	merged := make(map[string]SpaceSavingItem, len(s.items)+len(other.items))
+	sMin, otherMin := s.minCount(), other.minCount()
+	for item, c := range s.items {
+		merged[item] = SpaceSavingItem{item, c.Count + otherMin, c.Error + otherMin}
+	}
+	for item, c := range other.items {
+		if m, ok := merged[item]; ok {
+			m.Count += c.Count - otherMin
+			m.Error += c.Error - otherMin
+			merged[item] = m
+		} else {
+			merged[item] = SpaceSavingItem{item, c.Count + sMin, c.Error + sMin}
+		}
+	}
+
+	all := make([]SpaceSavingItem, 0, len(merged))
+	for _, item := range merged {
+		all = append(all, item)
+	}
+	sort.Sort(byCount(all))
+	if len(all) > s.capacity {
+		all = all[:s.capacity]
+	}
+	s.counters = s.counters[:0]
+	s.items = make(map[string]*ssCounter, s.capacity)
+	for _, item := range all {
+		c := &ssCounter{SpaceSavingItem: item}
+		heap.Push(&s.counters, c)
+		s.items[item.Item] = c
+	}
+}
+
+// Returns up to n of the heaviest items, heaviest first.
+func (s *SpaceSaving) Top(n int) []SpaceSavingItem {
+	all := make([]SpaceSavingItem, len(s.counters))
+	for i, c := range s.counters {
+		all[i] = c.SpaceSavingItem
+	}
+	sort.Sort(byCount(all))
+	if len(all) > n {
+		all = all[:n]
+	}
+	return all
+}
+
+// Sorts items by descending count, then by item.
+type byCount []SpaceSavingItem
+
+func (c byCount) Len() int      { return len(c) }
+func (c byCount) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c byCount) Less(i, j int) bool {
+	if c[i].Count != c[j].Count {
+		return c[i].Count > c[j].Count
+	}
+	return c[i].Item < c[j].Item
+}
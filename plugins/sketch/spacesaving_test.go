@@ -0,0 +1,94 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"fmt"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SpaceSavingSpec(c gs.Context) {
+	c.Specify("A SpaceSaving summary", func() {
+		s := NewSpaceSaving(3)
+
+		c.Specify("is empty initially", func() {
+			c.Expect(len(s.Top(10)), gs.Equals, 0)
+		})
+
+		c.Specify("counts exactly while counters are free", func() {
+			s.Add("a", 1)
+			s.Add("b", 3)
+			s.Add("a", 1)
+			top := s.Top(10)
+			c.Expect(len(top), gs.Equals, 2)
+			c.Expect(top[0], gs.Equals, SpaceSavingItem{"b", 3, 0})
+			c.Expect(top[1], gs.Equals, SpaceSavingItem{"a", 2, 0})
+		})
+
+		c.Specify("replaces the lightest item when full", func() {
+			s.Add("a", 5)
+			s.Add("b", 2)
+			s.Add("c", 1)
+			s.Add("d", 1)
+			top := s.Top(10)
+			c.Expect(len(top), gs.Equals, 3)
+			c.Expect(top[0], gs.Equals, SpaceSavingItem{"a", 5, 0})
+			c.Expect(top[1], gs.Equals, SpaceSavingItem{"b", 2, 0})
+			c.Expect(top[2], gs.Equals, SpaceSavingItem{"d", 2, 1})
+		})
+
+		c.Specify("returns at most n items", func() {
+			s.Add("a", 1)
+			s.Add("b", 2)
+			top := s.Top(1)
+			c.Expect(len(top), gs.Equals, 1)
+			c.Expect(top[0].Item, gs.Equals, "b")
+		})
+
+		c.Specify("tracks the heavy hitters of a long stream", func() {
+			s = NewSpaceSaving(20)
+			for i := 0; i < 10000; i++ {
+				s.Add(fmt.Sprintf("rare%d", i), 1)
+				if i%2 == 0 {
+					s.Add("often", 1)
+				}
+				if i%4 == 0 {
+					s.Add("sometimes", 1)
+				}
+			}
+			top := s.Top(2)
+			c.Expect(top[0].Item, gs.Equals, "often")
+			c.Expect(top[0].Count-top[0].Error <= 5000, gs.IsTrue)
+			c.Expect(top[0].Count >= 5000, gs.IsTrue)
+			c.Expect(top[1].Item, gs.Equals, "sometimes")
+			c.Expect(top[1].Count >= 2500, gs.IsTrue)
+		})
+
+		c.Specify("merges another summary", func() {
+			s.Add("a", 5)
+			s.Add("b", 2)
+			s.Add("c", 1)
+			s.Add("d", 1)
+			other := NewSpaceSaving(3)
+			other.Add("a", 1)
+			other.Add("e", 4)
+			s.Merge(other)
+			top := s.Top(10)
+			c.Expect(len(top), gs.Equals, 3)
+			c.Expect(top[0], gs.Equals, SpaceSavingItem{"a", 6, 0})
+			// Untracked by s, which may have counted up to 2 of it.
+			c.Expect(top[1], gs.Equals, SpaceSavingItem{"e", 6, 2})
+			c.Expect(top[2], gs.Equals, SpaceSavingItem{"b", 2, 0})
+		})
+	})
+}
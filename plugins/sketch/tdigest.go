@@ -0,0 +1,248 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const tdigestVersion = 1
+
+type centroid struct {
+	mean  float64
+	count float64
+}
+
+type byMean []centroid
+
+func (c byMean) Len() int           { return len(c) }
+func (c byMean) Less(i, j int) bool { return c[i].mean < c[j].mean }
+func (c byMean) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// TDigest is a merging t-digest (see Dunning & Ertl, "Computing Extremely
+// Accurate Quantiles Using t-Digests"), a compact sketch of a distribution
+// that gives accurate quantile estimates, especially near the tails, and can
+// be merged with other digests without losing accuracy.
+type TDigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	total       float64
+	min         float64
+	max         float64
+}
+
+// Creates an empty digest. Higher compression values give more accurate
+// results at the cost of size; 100 is a reasonable default.
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{
+		compression: compression,
+		buffer:      make([]centroid, 0, bufferSize(compression)),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func bufferSize(compression float64) int {
+	return int(compression) * 5
+}
+
+// Adds a single observation.
+func (t *TDigest) Add(x float64) {
+	t.add(centroid{x, 1}, x, x)
+}
+
+func (t *TDigest) add(c centroid, min, max float64) {
+	if min < t.min {
+		t.min = min
+	}
+	if max > t.max {
+		t.max = max
+	}
+	t.buffer = append(t.buffer, c)
+	t.total += c.count
+	if len(t.buffer) >= bufferSize(t.compression) {
+		t.compress()
+	}
+}
+
+// Merges all of other's observations into this digest.
+func (t *TDigest) Merge(other *TDigest) {
+	if other.total == 0 {
+		return
+	}
+	other.compress()
+	for _, c := range other.centroids {
+		t.add(c, other.min, other.max)
+	}
+}
+
+// Scale function k1 from the t-digest paper, limiting centroid sizes near
+// the tails.
+func (t *TDigest) scale(q float64) float64 {
+	return t.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func (t *TDigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(t.centroids)+len(t.buffer))
+	all = append(all, t.centroids...)
+	all = append(all, t.buffer...)
+	sort.Sort(byMean(all))
+
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	var sofar float64
+	cur := all[0]
+	for _, c := range all[1:] {
+		qLeft := sofar / t.total
+		qRight := (sofar + cur.count + c.count) / t.total
+		if t.scale(qRight)-t.scale(qLeft) <= 1 {
+			cur.count += c.count
+			cur.mean += (c.mean - cur.mean) * c.count / cur.count
+		} else {
+			sofar += cur.count
+			merged = append(merged, cur)
+			cur = c
+		}
+	}
+	t.centroids = append(merged, cur)
+	t.buffer = t.buffer[:0]
+}
+
+// Returns the number of observations in the digest.
+func (t *TDigest) Count() float64 {
+	return t.total
+}
+
+// Returns the smallest observation, or NaN if the digest is empty.
+func (t *TDigest) Min() float64 {
+	if t.total == 0 {
+		return math.NaN()
+	}
+	return t.min
+}
+
+// Returns the largest observation, or NaN if the digest is empty.
+func (t *TDigest) Max() float64 {
+	if t.total == 0 {
+		return math.NaN()
+	}
+	return t.max
+}
+
+// Returns the estimated value below which the fraction q (0 to 1) of the
+// observations fall, or NaN if the digest is empty.
+func (t *TDigest) Quantile(q float64) float64 {
+	if t.total == 0 {
+		return math.NaN()
+	}
+	t.compress()
+	if q <= 0 {
+		return t.min
+	}
+	if q >= 1 {
+		return t.max
+	}
+	cs := t.centroids
+	if len(cs) == 1 {
+		return cs[0].mean
+	}
+
+	// Interpolate between the centers of neighbouring centroids, treating
+	// the min and max as the outer bounds.
+	target := q * t.total
+	var sofar float64
+	for i, c := range cs {
+		center := sofar + c.count/2
+		if target < center {
+			if i == 0 {
+				return interpolate(t.min, c.mean, target/center)
+			}
+			prev := cs[i-1]
+			prevCenter := sofar - prev.count/2
+			return interpolate(prev.mean, c.mean, (target-prevCenter)/(center-prevCenter))
+		}
+		sofar += c.count
+	}
+	last := cs[len(cs)-1]
+	lastCenter := t.total - last.count/2
+	return interpolate(last.mean, t.max, (target-lastCenter)/(t.total-lastCenter))
+}
+
+func interpolate(a, b, fraction float64) float64 {
+	return a + (b-a)*fraction
+}
+
+// Serializes the digest so it can be sent to, and merged by, another
+// process.
+func (t *TDigest) MarshalBinary() ([]byte, error) {
+	t.compress()
+	buf := new(bytes.Buffer)
+	buf.WriteByte(tdigestVersion)
+	header := []interface{}{t.compression, t.min, t.max, uint32(len(t.centroids))}
+	for _, v := range header {
+		binary.Write(buf, binary.BigEndian, v)
+	}
+	for _, c := range t.centroids {
+		binary.Write(buf, binary.BigEndian, c.mean)
+		binary.Write(buf, binary.BigEndian, c.count)
+	}
+	return buf.Bytes(), nil
+}
+
+// Restores a digest serialized with MarshalBinary.
+func (t *TDigest) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	version, err := r.ReadByte()
+	if err != nil {
+		return errors.New("empty t-digest")
+	}
+	if version != tdigestVersion {
+		return fmt.Errorf("unsupported t-digest version %d", version)
+	}
+	var (
+		compression, min, max float64
+		n                     uint32
+	)
+	for _, v := range []interface{}{&compression, &min, &max, &n} {
+		if err = binary.Read(r, binary.BigEndian, v); err != nil {
+			return fmt.Errorf("truncated t-digest: %s", err)
+		}
+	}
+	if compression <= 0 || int(n) > r.Len()/16 {
+		return errors.New("corrupt t-digest")
+	}
+	centroids := make([]centroid, n)
+	var total float64
+	for i := range centroids {
+		binary.Read(r, binary.BigEndian, &centroids[i].mean)
+		binary.Read(r, binary.BigEndian, &centroids[i].count)
+		if centroids[i].count <= 0 {
+			return errors.New("corrupt t-digest")
+		}
+		total += centroids[i].count
+	}
+	*t = *NewTDigest(compression)
+	t.centroids = centroids
+	t.total = total
+	if total > 0 {
+		t.min, t.max = min, max
+	}
+	return nil
+}
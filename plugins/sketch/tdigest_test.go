@@ -0,0 +1,83 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"math"
+	"math/rand"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func TDigestSpec(c gs.Context) {
+	within := func(actual, expected, tolerance float64) bool {
+		return math.Abs(actual-expected) <= tolerance
+	}
+
+	c.Specify("A TDigest", func() {
+		d := NewTDigest(100)
+
+		c.Specify("is empty initially", func() {
+			c.Expect(d.Count(), gs.Equals, 0.0)
+			c.Expect(math.IsNaN(d.Quantile(0.5)), gs.IsTrue)
+		})
+
+		c.Specify("estimates quantiles of a small sample exactly", func() {
+			for i := 1; i <= 100; i++ {
+				d.Add(float64(i))
+			}
+			c.Expect(d.Count(), gs.Equals, 100.0)
+			c.Expect(d.Min(), gs.Equals, 1.0)
+			c.Expect(d.Max(), gs.Equals, 100.0)
+			c.Expect(d.Quantile(0.5), gs.Equals, 50.5)
+			c.Expect(d.Quantile(0.99), gs.Equals, 99.5)
+		})
+
+		c.Specify("estimates quantiles of a large sample", func() {
+			r := rand.New(rand.NewSource(42))
+			for i := 0; i < 100000; i++ {
+				d.Add(r.Float64() * 1000)
+			}
+			c.Expect(within(d.Quantile(0.5), 500, 10), gs.IsTrue)
+			c.Expect(within(d.Quantile(0.99), 990, 2), gs.IsTrue)
+			c.Expect(within(d.Quantile(0.999), 999, 0.5), gs.IsTrue)
+		})
+
+		c.Specify("survives serialization and merging", func() {
+			r := rand.New(rand.NewSource(42))
+			other := NewTDigest(100)
+			for i := 0; i < 50000; i++ {
+				d.Add(r.Float64() * 1000)
+				other.Add(r.Float64() * 1000)
+			}
+			data, err := other.MarshalBinary()
+			c.Expect(err, gs.IsNil)
+			restored := new(TDigest)
+			err = restored.UnmarshalBinary(data)
+			c.Assume(err, gs.IsNil)
+			c.Expect(restored.Count(), gs.Equals, 50000.0)
+
+			d.Merge(restored)
+			c.Expect(d.Count(), gs.Equals, 100000.0)
+			c.Expect(within(d.Quantile(0.99), 990, 2), gs.IsTrue)
+		})
+
+		c.Specify("rejects corrupt data", func() {
+			d.Add(1)
+			data, _ := d.MarshalBinary()
+			restored := new(TDigest)
+			c.Expect(restored.UnmarshalBinary(data[:len(data)-4]), gs.Not(gs.IsNil))
+			data[0] = 99
+			c.Expect(restored.UnmarshalBinary(data), gs.Not(gs.IsNil))
+		})
+	})
+}
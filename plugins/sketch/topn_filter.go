@@ -0,0 +1,179 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type TopNFilterConfig struct {
+	// Name of the message field whose values are ranked.
+	Field string
+	// Number of values reported. Defaults to 10.
+	N uint
+	// Number of counters kept per ticker interval. More counters give more
+	// accurate results. Defaults to 10 times N.
+	Capacity uint
+	// Optional name of a numeric field whose value is used as the message's
+	// weight instead of counting messages, e.g. bytes sent.
+	WeightField string `toml:"weight_field"`
+	// Length in seconds of the sliding window values are ranked over. Must be
+	// a multiple of ticker_interval. Defaults to 300.
+	Window uint
+	// Interval in seconds at which reports are emitted and the window
+	// advances. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+// TopNFilter tracks the values of a message field with the highest message
+// count or total weight over a sliding window, using a SpaceSaving summary
+// per ticker interval, and periodically emits them as a heka.topn message.
+type TopNFilter struct {
+	conf    *TopNFilterConfig
+	buckets []*SpaceSaving
+	current int
+	fr      FilterRunner
+	h       PluginHelper
+}
+
+func (f *TopNFilter) ConfigStruct() interface{} {
+	return &TopNFilterConfig{
+		N:              10,
+		Window:         300,
+		TickerInterval: 60,
+	}
+}
+
+func (f *TopNFilter) Init(config interface{}) error {
+	f.conf = config.(*TopNFilterConfig)
+	if f.conf.Field == "" {
+		return errors.New("'field' must be specified")
+	}
+	if f.conf.N == 0 {
+		return errors.New("'n' must be greater than 0")
+	}
+	if f.conf.Capacity == 0 {
+		f.conf.Capacity = 10 * f.conf.N
+	}
+	if f.conf.Capacity < f.conf.N {
+		return fmt.Errorf("'capacity' must be at least n (%d)", f.conf.N)
+	}
+	tick := f.conf.TickerInterval
+	if tick == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	if f.conf.Window == 0 || f.conf.Window%tick != 0 {
+		return fmt.Errorf("'window' must be a non-zero multiple of ticker_interval (%d)",
+			tick)
+	}
+	f.buckets = make([]*SpaceSaving, f.conf.Window/tick)
+	for i := range f.buckets {
+		f.buckets[i] = NewSpaceSaving(int(f.conf.Capacity))
+	}
+	return nil
+}
+
+func (f *TopNFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *TopNFilter) ProcessMessage(pack *PipelinePack) error {
+	msg := pack.Message
+	v, ok := msg.GetFieldValue(f.conf.Field)
+	if !ok {
+		return nil
+	}
+	var item string
+	if b, ok := v.([]byte); ok {
+		item = string(b)
+	} else {
+		item = fmt.Sprint(v)
+	}
+	weight := 1.0
+	if f.conf.WeightField != "" {
+		w, _ := msg.GetFieldValue(f.conf.WeightField)
+		switch w := w.(type) {
+		case int64:
+			weight = float64(w)
+		case float64:
+			weight = w
+		default:
+			return fmt.Errorf("message has no numeric %s field", f.conf.WeightField)
+		}
+		if weight <= 0 {
+			return nil
+		}
+	}
+	f.buckets[f.current].Add(item, weight)
+	return nil
+}
+
+func (f *TopNFilter) TimerEvent() error {
+	window := NewSpaceSaving(int(f.conf.Capacity))
+	for _, b := range f.buckets {
+		window.Merge(b)
+	}
+	if top := window.Top(int(f.conf.N)); len(top) > 0 {
+		if err := f.injectReport(top); err != nil {
+			return err
+		}
+	}
+	// Start a new bucket, dropping the oldest one.
+	f.current = (f.current + 1) % len(f.buckets)
+	f.buckets[f.current] = NewSpaceSaving(int(f.conf.Capacity))
+	return nil
+}
+
+func (f *TopNFilter) injectReport(top []SpaceSavingItem) error {
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetType("heka.topn")
+	msg.SetLogger(f.fr.Name())
+	msg.SetSeverity(6)
+	items := message.NewFieldInit("Items", message.Field_STRING, "")
+	counts := message.NewFieldInit("Counts", message.Field_DOUBLE, "")
+	errs := message.NewFieldInit("Errors", message.Field_DOUBLE, "")
+	var payload bytes.Buffer
+	for i, item := range top {
+		items.AddValue(item.Item)
+		counts.AddValue(item.Count)
+		errs.AddValue(item.Error)
+		fmt.Fprintf(&payload, "%d\t%s\t%g\n", i+1, item.Item, item.Count)
+	}
+	msg.SetPayload(payload.String())
+	message.NewStringField(msg, "TopN", f.fr.Name())
+	message.NewStringField(msg, "Field", f.conf.Field)
+	msg.AddField(items)
+	msg.AddField(counts)
+	msg.AddField(errs)
+	message.NewInt64Field(msg, "Window", int64(f.conf.Window), "s")
+	f.fr.Inject(pack)
+	return nil
+}
+
+func (f *TopNFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("TopNFilter", func() interface{} {
+		return new(TopNFilter)
+	})
+}
@@ -0,0 +1,145 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sketch
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func TopNFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A TopNFilter", func() {
+		filter := new(TopNFilter)
+		config := filter.ConfigStruct().(*TopNFilterConfig)
+		config.Field = "url"
+
+		c.Specify("requires a field", func() {
+			config.Field = ""
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires capacity for n values", func() {
+			config.N = 5
+			config.Capacity = 4
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires the window to be a multiple of the ticker interval", func() {
+			config.Window = 90
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("defaults the capacity to 10 times n", func() {
+			config.N = 3
+			c.Assume(filter.Init(config), gs.IsNil)
+			c.Expect(config.Capacity, gs.Equals, uint(30))
+		})
+
+		c.Specify("when running", func() {
+			config.N = 2
+			config.Window = 120
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			fr.EXPECT().Name().Return("top_urls").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			pack := NewPipelinePack(recycleChan)
+			send := func(url string, bytes int64, times int) {
+				for i := 0; i < times; i++ {
+					pack.Message = new(message.Message)
+					message.NewStringField(pack.Message, "url", url)
+					message.NewInt64Field(pack.Message, "bytes", bytes, "B")
+					c.Expect(filter.ProcessMessage(pack), gs.IsNil)
+				}
+			}
+			values := func(msg *message.Message, name string) []interface{} {
+				field := msg.FindFirstField(name)
+				c.Assume(field, gs.Not(gs.IsNil))
+				return field.GetValue().([]interface{})
+			}
+
+			c.Specify("ranks values by message count", func() {
+				c.Assume(filter.Init(config), gs.IsNil)
+				filter.Prepare(fr, h)
+				send("/a", 1, 3)
+				send("/b", 1, 5)
+				send("/c", 1, 1)
+
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				msg := injected[0]
+				c.Expect(msg.GetType(), gs.Equals, "heka.topn")
+				c.Expect(msg.GetPayload(), gs.Equals, "1\t/b\t5\n2\t/a\t3\n")
+				field, _ := msg.GetFieldValue("Field")
+				c.Expect(field, gs.Equals, "url")
+				c.Expect(len(values(msg, "Items")), gs.Equals, 2)
+				c.Expect(values(msg, "Items")[0], gs.Equals, "/b")
+				c.Expect(values(msg, "Counts")[1], gs.Equals, 3.0)
+				c.Expect(values(msg, "Errors")[0], gs.Equals, 0.0)
+			})
+
+			c.Specify("ranks values by weight", func() {
+				config.WeightField = "bytes"
+				c.Assume(filter.Init(config), gs.IsNil)
+				filter.Prepare(fr, h)
+				send("/a", 1000, 1)
+				send("/b", 10, 5)
+
+				pack.Message = new(message.Message)
+				message.NewStringField(pack.Message, "url", "/c")
+				c.Expect(filter.ProcessMessage(pack), gs.Not(gs.IsNil))
+
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 1)
+				c.Expect(injected[0].GetPayload(), gs.Equals, "1\t/a\t1000\n2\t/b\t50\n")
+			})
+
+			c.Specify("slides the window", func() {
+				c.Assume(filter.Init(config), gs.IsNil)
+				filter.Prepare(fr, h)
+				send("/a", 1, 2)
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				send("/a", 1, 1)
+				send("/b", 1, 2)
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 2)
+				c.Expect(injected[1].GetPayload(), gs.Equals, "1\t/a\t3\n2\t/b\t2\n")
+
+				// The first interval has dropped out of the window.
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Assume(len(injected), gs.Equals, 3)
+				c.Expect(injected[2].GetPayload(), gs.Equals, "1\t/b\t2\n2\t/a\t1\n")
+
+				injected = nil
+				c.Expect(filter.TimerEvent(), gs.IsNil)
+				c.Expect(len(injected), gs.Equals, 0)
+			})
+		})
+	})
+}
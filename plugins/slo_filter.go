@@ -0,0 +1,241 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type SloFilterConfig struct {
+	// Message matcher selecting the good events among those matched by the
+	// filter's message_matcher, which selects all events counted by the SLO.
+	GoodMatcher string `toml:"good_matcher"`
+	// Target percentage of good events, e.g. 99.9.
+	Objective float64
+	// Length in seconds of the rolling window compliance is computed over.
+	// Must be a multiple of ticker_interval. Defaults to 3600.
+	Window uint
+	// Length in seconds of the recent window used to confirm that an alert
+	// is still burning. Must be a multiple of ticker_interval and no longer
+	// than Window. Defaults to 300.
+	ShortWindow uint `toml:"short_window"`
+	// Error budget burn rate at or above which an alert fires, e.g. 14.4.
+	// Defaults to 0, which disables alerting.
+	AlertBurnRate float64 `toml:"alert_burn_rate"`
+	// Interval in seconds at which SLO status messages are emitted and the
+	// window advances. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+}
+
+type sloBucket struct {
+	good  int64
+	total int64
+}
+
+// Good and total event counts over some number of buckets.
+type sloStats struct {
+	good  int64
+	total int64
+}
+
+// Returns the error budget burn rate, i.e. the observed error rate as a
+// multiple of the error rate the objective allows.
+func (s sloStats) burnRate(objective float64) float64 {
+	if s.total == 0 {
+		return 0
+	}
+	errorRate := float64(s.total-s.good) / float64(s.total)
+	return errorRate / (1 - objective/100)
+}
+
+// SloFilter counts good and total events over a rolling window and
+// periodically emits SLO compliance, error budget and burn rate status
+// messages, plus alert messages when the burn rate crosses a threshold.
+type SloFilter struct {
+	conf         *SloFilterConfig
+	good         *message.MatcherSpecification
+	buckets      []sloBucket
+	current      int
+	shortBuckets int
+	alerting     bool
+	fr           FilterRunner
+	h            PluginHelper
+}
+
+func (f *SloFilter) ConfigStruct() interface{} {
+	return &SloFilterConfig{
+		Window:         3600,
+		ShortWindow:    300,
+		TickerInterval: 60,
+	}
+}
+
+func (f *SloFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*SloFilterConfig)
+	if f.conf.GoodMatcher == "" {
+		return errors.New("'good_matcher' must be specified")
+	}
+	if f.good, err = message.CreateMatcherSpecification(f.conf.GoodMatcher); err != nil {
+		return fmt.Errorf("invalid good_matcher: %s", err)
+	}
+	if f.conf.Objective <= 0 || f.conf.Objective >= 100 {
+		return fmt.Errorf("'objective' must be between 0 and 100 exclusive: %g",
+			f.conf.Objective)
+	}
+	tick := f.conf.TickerInterval
+	if tick == 0 {
+		return errors.New("'ticker_interval' must be greater than 0")
+	}
+	if f.conf.Window == 0 || f.conf.Window%tick != 0 {
+		return fmt.Errorf("'window' must be a non-zero multiple of ticker_interval (%d)",
+			tick)
+	}
+	if f.conf.ShortWindow == 0 || f.conf.ShortWindow%tick != 0 ||
+		f.conf.ShortWindow > f.conf.Window {
+
+		return fmt.Errorf("'short_window' must be a non-zero multiple of "+
+			"ticker_interval (%d) no longer than window", tick)
+	}
+	if f.conf.AlertBurnRate < 0 {
+		return fmt.Errorf("'alert_burn_rate' must not be negative: %g",
+			f.conf.AlertBurnRate)
+	}
+	f.buckets = make([]sloBucket, f.conf.Window/tick)
+	f.shortBuckets = int(f.conf.ShortWindow / tick)
+	return nil
+}
+
+func (f *SloFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *SloFilter) ProcessMessage(pack *PipelinePack) error {
+	b := &f.buckets[f.current]
+	b.total++
+	if f.good.Match(pack.Message) {
+		b.good++
+	}
+	return nil
+}
+
+// Sums the counts of the n most recent buckets.
+func (f *SloFilter) stats(n int) (s sloStats) {
+	for i := 0; i < n; i++ {
+		b := f.buckets[(f.current-i+len(f.buckets))%len(f.buckets)]
+		s.good += b.good
+		s.total += b.total
+	}
+	return
+}
+
+func (f *SloFilter) newPack(msgType string, severity int32) (*PipelinePack, error) {
+	pack, err := f.h.PipelinePack(0)
+	if err != nil {
+		return nil, err
+	}
+	pack.Message.SetType(msgType)
+	pack.Message.SetLogger(f.fr.Name())
+	pack.Message.SetSeverity(severity)
+	message.NewStringField(pack.Message, "Slo", f.fr.Name())
+	return pack, nil
+}
+
+func addDoubleField(msg *message.Message, name string, value float64,
+	representation string) {
+
+	if field, err := message.NewField(name, value, representation); err == nil {
+		msg.AddField(field)
+	}
+}
+
+func (f *SloFilter) TimerEvent() error {
+	window := f.stats(len(f.buckets))
+	short := f.stats(f.shortBuckets)
+	objective := f.conf.Objective
+	burnRate := window.burnRate(objective)
+	shortBurnRate := short.burnRate(objective)
+
+	compliance := 100.0
+	if window.total > 0 {
+		compliance = float64(window.good) * 100 / float64(window.total)
+	}
+	// Fraction of the window's error budget that hasn't been spent.
+	budgetRemaining := 1 - burnRate
+
+	pack, err := f.newPack("heka.slo", 6)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetPayload(fmt.Sprintf("%s: %.4f%% good over %ds (objective %g%%), burn rate %.2f",
+		f.fr.Name(), compliance, f.conf.Window, objective, burnRate))
+	addDoubleField(msg, "Objective", objective, "%")
+	addDoubleField(msg, "Compliance", compliance, "%")
+	message.NewInt64Field(msg, "GoodEvents", window.good, "count")
+	message.NewInt64Field(msg, "TotalEvents", window.total, "count")
+	addDoubleField(msg, "ErrorBudgetRemaining", budgetRemaining, "")
+	addDoubleField(msg, "BurnRate", burnRate, "")
+	addDoubleField(msg, "ShortBurnRate", shortBurnRate, "")
+	message.NewInt64Field(msg, "Window", int64(f.conf.Window), "s")
+	f.fr.Inject(pack)
+
+	if f.conf.AlertBurnRate > 0 {
+		firing := burnRate >= f.conf.AlertBurnRate &&
+			shortBurnRate >= f.conf.AlertBurnRate
+		if firing != f.alerting {
+			f.alerting = firing
+			if err = f.injectAlert(firing, burnRate, shortBurnRate); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Start a new bucket, dropping the oldest one.
+	f.current = (f.current + 1) % len(f.buckets)
+	f.buckets[f.current] = sloBucket{}
+	return nil
+}
+
+func (f *SloFilter) injectAlert(firing bool, burnRate, shortBurnRate float64) error {
+	state, severity := "resolved", int32(6)
+	if firing {
+		state, severity = "firing", 1
+	}
+	pack, err := f.newPack("heka.slo.alert", severity)
+	if err != nil {
+		return err
+	}
+	msg := pack.Message
+	msg.SetPayload(fmt.Sprintf("%s: SLO alert %s, burn rate %.2f (%ds) / %.2f (%ds), threshold %g",
+		f.fr.Name(), state, burnRate, f.conf.Window, shortBurnRate,
+		f.conf.ShortWindow, f.conf.AlertBurnRate))
+	message.NewStringField(msg, "AlertState", state)
+	addDoubleField(msg, "BurnRate", burnRate, "")
+	addDoubleField(msg, "ShortBurnRate", shortBurnRate, "")
+	addDoubleField(msg, "AlertBurnRate", f.conf.AlertBurnRate, "")
+	f.fr.Inject(pack)
+	return nil
+}
+
+func (f *SloFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("SloFilter", func() interface{} {
+		return new(SloFilter)
+	})
+}
@@ -0,0 +1,133 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SloFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A SloFilter", func() {
+		filter := new(SloFilter)
+		config := filter.ConfigStruct().(*SloFilterConfig)
+		config.GoodMatcher = "Fields[status] < 500"
+		config.Objective = 99
+
+		c.Specify("requires a good_matcher", func() {
+			config.GoodMatcher = ""
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects an invalid objective", func() {
+			config.Objective = 100
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("requires windows to be multiples of the ticker interval", func() {
+			config.ShortWindow = 90
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+			config.ShortWindow = 7200
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("computes rolling compliance", func() {
+			config.Window = 180
+			config.ShortWindow = 60
+			config.AlertBurnRate = 10
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			filter.Prepare(fr, h)
+			fr.EXPECT().Name().Return("checkout_slo").AnyTimes()
+
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			// The helper hands out the same pack every time, so give it a
+			// fresh message once the injected one has been captured.
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(uint(0)).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			send := func(good, bad int) {
+				pack := NewPipelinePack(recycleChan)
+				for i := 0; i < good+bad; i++ {
+					pack.Message = new(message.Message)
+					status := int64(200)
+					if i >= good {
+						status = 503
+					}
+					message.NewInt64Field(pack.Message, "status", status, "")
+					filter.ProcessMessage(pack)
+				}
+			}
+			value := func(msg *message.Message, name string) interface{} {
+				v, _ := msg.GetFieldValue(name)
+				return v
+			}
+
+			send(99, 1)
+			err = filter.TimerEvent()
+			c.Expect(err, gs.IsNil)
+			c.Assume(len(injected), gs.Equals, 1)
+			status := injected[0]
+			c.Expect(status.GetType(), gs.Equals, "heka.slo")
+			c.Expect(value(status, "Slo"), gs.Equals, "checkout_slo")
+			c.Expect(value(status, "TotalEvents"), gs.Equals, int64(100))
+			c.Expect(value(status, "GoodEvents"), gs.Equals, int64(99))
+			c.Expect(value(status, "Compliance"), gs.Equals, 99.0)
+			c.Expect(value(status, "ErrorBudgetRemaining").(float64) < 1e-9, gs.IsTrue)
+
+			// Half of the next interval's events are bad, which burns the
+			// budget fast enough to fire an alert.
+			injected = nil
+			send(50, 50)
+			err = filter.TimerEvent()
+			c.Expect(err, gs.IsNil)
+			c.Assume(len(injected), gs.Equals, 2)
+			c.Expect(value(injected[0], "TotalEvents"), gs.Equals, int64(200))
+			c.Expect(value(injected[0], "ShortBurnRate").(float64) > 49.9, gs.IsTrue)
+			alert := injected[1]
+			c.Expect(alert.GetType(), gs.Equals, "heka.slo.alert")
+			c.Expect(value(alert, "AlertState"), gs.Equals, "firing")
+			c.Expect(alert.GetSeverity(), gs.Equals, int32(1))
+
+			// Still burning, no repeated alert.
+			injected = nil
+			send(50, 50)
+			filter.TimerEvent()
+			c.Expect(len(injected), gs.Equals, 1)
+
+			// The short window recovers and the alert resolves.
+			injected = nil
+			send(100, 0)
+			filter.TimerEvent()
+			c.Assume(len(injected), gs.Equals, 2)
+			// The first interval has rolled out of the 180s window.
+			c.Expect(value(injected[0], "TotalEvents"), gs.Equals, int64(300))
+			c.Expect(value(injected[1], "AlertState"), gs.Equals, "resolved")
+		})
+	})
+}
@@ -0,0 +1,419 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sql
+
+import (
+	gosql "database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+// Layout used to store time checkpoints, which both MySQL and Postgres
+// accept as a timestamp literal.
+const checkpointTimeLayout = "2006-01-02 15:04:05.999999999"
+
+type SqlInputConfig struct {
+	// database/sql driver name, "mysql" or "postgres".
+	Driver string
+	// Driver specific data source name, e.g.
+	// "user:password@tcp(db:3306)/app" for MySQL or
+	// "postgres://user:password@db/app?sslmode=disable" for Postgres.
+	Dsn string
+	// Query run on every tick. It must take the last checkpoint value as its
+	// only parameter (`?` for MySQL, `$1` for Postgres) and return rows in
+	// ascending checkpoint column order.
+	Query string
+	// Column whose value in the last row returned is stored as the
+	// checkpoint for the next query.
+	CheckpointColumn string `toml:"checkpoint_column"`
+	// Checkpoint value used before any rows have been read. Defaults to "0".
+	InitialCheckpoint string `toml:"initial_checkpoint"`
+	// Path of the file the checkpoint is stored in. Defaults to
+	// "sql/<plugin name>.checkpoint" under base_dir.
+	CheckpointFile string `toml:"checkpoint_file"`
+	// How often, in seconds, the query is run. Defaults to 60.
+	TickerInterval uint `toml:"ticker_interval"`
+	// Type of the generated messages. Defaults to "heka.sql".
+	MessageType string `toml:"message_type"`
+	// Optional column used as the message payload instead of a field.
+	PayloadColumn string `toml:"payload_column"`
+	// Optional column used as the message timestamp instead of a field.
+	TimestampColumn string `toml:"timestamp_column"`
+	// Field types for columns the driver returns as raw bytes, keyed by
+	// column name. Valid types are "string", "int", "float" and "bool".
+	ColumnTypes map[string]string `toml:"column_types"`
+}
+
+type SqlInput struct {
+	processMessageCount    int64
+	processMessageFailures int64
+	queryFailures          int64
+
+	config     *SqlInputConfig
+	name       string
+	pConfig    *pipeline.PipelineConfig
+	db         *gosql.DB
+	checkpoint *sqlCheckpoint
+	ir         pipeline.InputRunner
+	hostname   string
+	stopChan   chan bool
+}
+
+func (s *SqlInput) SetName(name string) {
+	s.name = name
+}
+
+func (s *SqlInput) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
+	s.pConfig = pConfig
+}
+
+func (s *SqlInput) ConfigStruct() interface{} {
+	return &SqlInputConfig{
+		InitialCheckpoint: "0",
+		TickerInterval:    60,
+		MessageType:       "heka.sql",
+	}
+}
+
+func (s *SqlInput) Init(config interface{}) (err error) {
+	s.config = config.(*SqlInputConfig)
+	switch s.config.Driver {
+	case "mysql", "postgres":
+	default:
+		return fmt.Errorf("unsupported driver '%s', must be 'mysql' or 'postgres'",
+			s.config.Driver)
+	}
+	if s.config.Dsn == "" {
+		return errors.New("'dsn' must be specified")
+	}
+	if s.config.Query == "" {
+		return errors.New("'query' must be specified")
+	}
+	if s.config.CheckpointColumn == "" {
+		return errors.New("'checkpoint_column' must be specified")
+	}
+	for column, typ := range s.config.ColumnTypes {
+		switch typ {
+		case "string", "int", "float", "bool":
+		default:
+			return fmt.Errorf("invalid type '%s' for column '%s'", typ, column)
+		}
+	}
+
+	ckPath := s.config.CheckpointFile
+	if ckPath == "" {
+		ckPath = filepath.Join("sql", s.name+".checkpoint")
+	}
+	ckPath = s.pConfig.Globals.PrependBaseDir(ckPath)
+	if err = os.MkdirAll(filepath.Dir(ckPath), 0766); err != nil {
+		return err
+	}
+	if s.checkpoint, err = readSqlCheckpoint(ckPath, s.config.InitialCheckpoint); err != nil {
+		return fmt.Errorf("can't read checkpoint file: %s", err)
+	}
+
+	// sql.Open only validates its arguments, connections are made lazily so
+	// an unavailable database doesn't prevent Heka from starting.
+	if s.db, err = gosql.Open(s.config.Driver, s.config.Dsn); err != nil {
+		return err
+	}
+	s.stopChan = make(chan bool)
+	return nil
+}
+
+func (s *SqlInput) Run(ir pipeline.InputRunner, h pipeline.PluginHelper) error {
+	s.ir = ir
+	s.hostname = h.Hostname()
+	defer s.db.Close()
+
+	tickChan := ir.Ticker()
+	for {
+		if err := s.poll(); err != nil {
+			atomic.AddInt64(&s.queryFailures, 1)
+			ir.LogError(err)
+		}
+		select {
+		case <-s.stopChan:
+			return nil
+		case <-tickChan:
+		}
+	}
+}
+
+func (s *SqlInput) isStopping() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+	}
+	return false
+}
+
+// poll runs the query once, delivering a message for every row returned and
+// advancing the checkpoint past each delivered row.
+func (s *SqlInput) poll() error {
+	rows, err := s.db.Query(s.config.Query, s.checkpoint.Value)
+	if err != nil {
+		return fmt.Errorf("running query: %s", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("reading columns: %s", err)
+	}
+	ckIndex := -1
+	for i, column := range columns {
+		if column == s.config.CheckpointColumn {
+			ckIndex = i
+			break
+		}
+	}
+	if ckIndex == -1 {
+		return fmt.Errorf("checkpoint column '%s' not in query results",
+			s.config.CheckpointColumn)
+	}
+
+	values := make([]interface{}, len(columns))
+	scanArgs := make([]interface{}, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	advanced := false
+	defer func() {
+		if advanced {
+			if err := s.checkpoint.write(); err != nil {
+				s.ir.LogError(fmt.Errorf("writing checkpoint: %s", err))
+			}
+		}
+	}()
+
+	for rows.Next() {
+		if s.isStopping() {
+			return nil
+		}
+		if err = rows.Scan(scanArgs...); err != nil {
+			return fmt.Errorf("reading row: %s", err)
+		}
+		ckValue, err := checkpointValue(values[ckIndex])
+		if err != nil {
+			return fmt.Errorf("checkpoint column '%s': %s", s.config.CheckpointColumn, err)
+		}
+
+		pack := <-s.ir.InChan()
+		pack.Message.SetType(s.config.MessageType)
+		pack.Message.SetLogger(s.name)
+		pack.Message.SetHostname(s.hostname)
+		if err = s.populateMessage(pack.Message, columns, values); err != nil {
+			atomic.AddInt64(&s.processMessageFailures, 1)
+			s.ir.LogError(fmt.Errorf("row with %s %s: %s", s.config.CheckpointColumn,
+				ckValue, err))
+			pack.Recycle(nil)
+		} else {
+			atomic.AddInt64(&s.processMessageCount, 1)
+			s.ir.Deliver(pack)
+		}
+		s.checkpoint.Value = ckValue
+		advanced = true
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("reading rows: %s", err)
+	}
+	return nil
+}
+
+// populateMessage copies a row into the message, one field per non-NULL
+// column, except for the payload and timestamp columns which populate the
+// corresponding headers.
+func (s *SqlInput) populateMessage(msg *message.Message, columns []string,
+	values []interface{}) error {
+
+	for i, column := range columns {
+		value := values[i]
+		if value == nil {
+			continue
+		}
+		switch column {
+		case s.config.PayloadColumn:
+			msg.SetPayload(fmt.Sprint(stringValue(value)))
+			continue
+		case s.config.TimestampColumn:
+			t, err := timestampValue(value)
+			if err != nil {
+				return fmt.Errorf("timestamp column '%s': %s", column, err)
+			}
+			msg.SetTimestamp(t.UnixNano())
+			continue
+		}
+		fieldValue, err := s.fieldValue(column, value)
+		if err != nil {
+			return fmt.Errorf("column '%s': %s", column, err)
+		}
+		f, err := message.NewField(column, fieldValue, "")
+		if err != nil {
+			return fmt.Errorf("can't create field '%s': %s", column, err)
+		}
+		msg.AddField(f)
+	}
+	return nil
+}
+
+// fieldValue converts a value returned by the driver into a value that can
+// be stored in a message field, applying any configured column type.
+func (s *SqlInput) fieldValue(column string, value interface{}) (interface{}, error) {
+	value = stringValue(value)
+	typ, ok := s.config.ColumnTypes[column]
+	if !ok {
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(time.RFC3339Nano), nil
+		case int64, float64, bool, string:
+			return v, nil
+		}
+		return fmt.Sprint(value), nil
+	}
+
+	str := fmt.Sprint(value)
+	switch typ {
+	case "int":
+		if i, ok := value.(int64); ok {
+			return i, nil
+		}
+		return strconv.ParseInt(str, 10, 64)
+	case "float":
+		if f, ok := value.(float64); ok {
+			return f, nil
+		}
+		return strconv.ParseFloat(str, 64)
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return strconv.ParseBool(str)
+	}
+	if t, ok := value.(time.Time); ok {
+		return t.Format(time.RFC3339Nano), nil
+	}
+	return str, nil
+}
+
+// stringValue converts raw byte values, which is how most drivers return
+// text columns, to strings.
+func stringValue(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
+
+func timestampValue(value interface{}) (time.Time, error) {
+	switch v := stringValue(value).(type) {
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, checkpointTimeLayout} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("can't parse '%s' as a timestamp", v)
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp type %T", value)
+}
+
+// checkpointValue converts a checkpoint column value to the string form in
+// which it's stored and passed back to the query.
+func checkpointValue(value interface{}) (string, error) {
+	switch v := stringValue(value).(type) {
+	case nil:
+		return "", errors.New("value is NULL")
+	case time.Time:
+		return v.UTC().Format(checkpointTimeLayout), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return v, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (s *SqlInput) Stop() {
+	close(s.stopChan)
+}
+
+func (s *SqlInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ProcessMessageCount",
+		atomic.LoadInt64(&s.processMessageCount), "count")
+	message.NewInt64Field(msg, "ProcessMessageFailures",
+		atomic.LoadInt64(&s.processMessageFailures), "count")
+	message.NewInt64Field(msg, "QueryFailures",
+		atomic.LoadInt64(&s.queryFailures), "count")
+	return nil
+}
+
+// sqlCheckpoint stores the checkpoint column value of the last row read.
+type sqlCheckpoint struct {
+	path  string
+	Value string
+}
+
+func readSqlCheckpoint(path, initial string) (*sqlCheckpoint, error) {
+	ck := &sqlCheckpoint{path: path, Value: initial}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ck, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, ck); err != nil {
+		return nil, err
+	}
+	return ck, nil
+}
+
+// write replaces the checkpoint file atomically so a crash can't leave a
+// truncated checkpoint behind.
+func (ck *sqlCheckpoint) write() error {
+	data, err := json.Marshal(ck)
+	if err != nil {
+		return err
+	}
+	tmpPath := ck.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, ck.path)
+}
+
+func init() {
+	pipeline.RegisterPlugin("SqlInput", func() interface{} {
+		return new(SqlInput)
+	})
+}
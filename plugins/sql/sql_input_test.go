@@ -0,0 +1,147 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package sql
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func SqlInputSpec(c gs.Context) {
+	baseDir, err := ioutil.TempDir("", "sql-input-tests")
+	c.Assume(err, gs.IsNil)
+	defer os.RemoveAll(baseDir)
+
+	c.Specify("A SqlInput", func() {
+		globals := pipeline.DefaultGlobals()
+		globals.BaseDir = baseDir
+		input := new(SqlInput)
+		input.SetName("audit")
+		input.SetPipelineConfig(pipeline.NewPipelineConfig(globals))
+		config := input.ConfigStruct().(*SqlInputConfig)
+		config.Driver = "postgres"
+		config.Dsn = "postgres://heka@localhost/app?sslmode=disable"
+		config.Query = "SELECT id, action FROM audit WHERE id > $1 ORDER BY id"
+		config.CheckpointColumn = "id"
+
+		c.Specify("rejects unsupported drivers", func() {
+			config.Driver = "sqlite3"
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects invalid column types", func() {
+			config.ColumnTypes = map[string]string{"id": "uint"}
+			c.Expect(input.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("starts from the initial checkpoint", func() {
+			config.ColumnTypes = map[string]string{"id": "int"}
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer input.db.Close()
+			c.Expect(input.checkpoint.Value, gs.Equals, "0")
+			c.Expect(input.checkpoint.path, gs.Equals,
+				filepath.Join(baseDir, "sql", "audit.checkpoint"))
+		})
+
+		c.Specify("populates messages from rows", func() {
+			config.PayloadColumn = "detail"
+			config.TimestampColumn = "created"
+			config.ColumnTypes = map[string]string{"amount": "float", "admin": "bool"}
+			err := input.Init(config)
+			c.Assume(err, gs.IsNil)
+			defer input.db.Close()
+
+			ts := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+			columns := []string{"id", "action", "amount", "admin", "detail", "created",
+				"deleted"}
+			values := []interface{}{int64(7), []byte("login"), []byte("12.5"),
+				[]byte("1"), []byte("user logged in"), ts, nil}
+
+			msg := new(message.Message)
+			err = input.populateMessage(msg, columns, values)
+			c.Assume(err, gs.IsNil)
+			c.Expect(msg.GetPayload(), gs.Equals, "user logged in")
+			c.Expect(msg.GetTimestamp(), gs.Equals, ts.UnixNano())
+
+			expected := map[string]interface{}{
+				"id":     int64(7),
+				"action": "login",
+				"amount": 12.5,
+				"admin":  true,
+			}
+			for name, value := range expected {
+				actual, ok := msg.GetFieldValue(name)
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(actual, gs.Equals, value)
+			}
+			// The payload and timestamp columns aren't repeated as fields and
+			// NULL values are skipped.
+			for _, name := range []string{"detail", "created", "deleted"} {
+				_, ok := msg.GetFieldValue(name)
+				c.Expect(ok, gs.IsFalse)
+			}
+
+			c.Specify("and rejects malformed typed columns", func() {
+				values[2] = []byte("lots")
+				err = input.populateMessage(new(message.Message), columns, values)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+	})
+
+	c.Specify("A SQL checkpoint", func() {
+		path := filepath.Join(baseDir, "test.checkpoint")
+
+		c.Specify("is restored after being written", func() {
+			ck, err := readSqlCheckpoint(path, "0")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ck.Value, gs.Equals, "0")
+			ck.Value = "2016-03-01 12:00:00.5"
+			err = ck.write()
+			c.Assume(err, gs.IsNil)
+
+			ck, err = readSqlCheckpoint(path, "0")
+			c.Assume(err, gs.IsNil)
+			c.Expect(ck.Value, gs.Equals, "2016-03-01 12:00:00.5")
+		})
+
+		c.Specify("formats column values", func() {
+			ts := time.Date(2016, 3, 1, 12, 0, 0, 500000000, time.UTC)
+			tests := []struct {
+				value    interface{}
+				expected string
+			}{
+				{int64(42), "42"},
+				{[]byte("42"), "42"},
+				{1.5, "1.5"},
+				{ts, "2016-03-01 12:00:00.5"},
+			}
+			for _, test := range tests {
+				value, err := checkpointValue(test.value)
+				c.Expect(err, gs.IsNil)
+				c.Expect(value, gs.Equals, test.expected)
+			}
+		})
+
+		c.Specify("can't be NULL", func() {
+			_, err := checkpointValue(nil)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
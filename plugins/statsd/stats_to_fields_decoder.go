@@ -52,6 +52,14 @@ func (d *StatsToFieldsDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 		timestamp uint64
 		extras    []*extraStatMessage
 	)
+	defer func() {
+		if err != nil {
+			// Return the extra packs to the supply.
+			for _, e := range extras {
+				e.pack.Recycle(nil)
+			}
+		}
+	}()
 
 	lines := strings.Split(strings.Trim(pack.Message.GetPayload(), "\n"), "\n")
 	for _, line := range lines {
@@ -91,15 +99,17 @@ func (d *StatsToFieldsDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack
 					}
 				}
 				if !found {
-					// No existing message, create one.
+					// No existing message, create one with the original
+					// message's headers.
 					sMsg = &extraStatMessage{
 						timestamp: unixTime,
-						pack:      d.runner.NewPack(),
+						pack:      d.runner.NewPackFrom(pack),
+					}
+					if sMsg.pack == nil {
+						// We're aborting.
+						err = fmt.Errorf("no pack for extra stat message")
+						return
 					}
-					// Copy the original message, but clear out the payload and the fields.
-					pack.Message.Copy(sMsg.pack.Message)
-					sMsg.pack.Message.Fields = make([]*message.Field, 0, 2)
-					sMsg.pack.Message.SetPayload("")
 					if err = d.addStatField(sMsg.pack, "timestamp", int64(unixTime)); err != nil {
 						return
 					}
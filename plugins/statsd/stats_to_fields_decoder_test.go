@@ -88,8 +88,8 @@ func StatsToFieldsDecoderSpec(c gospec.Context) {
 				{"stat.five", "5", "1380047332"},
 			}
 			// Prime the pack supply w/ two new packs.
-			dRunner.EXPECT().NewPack().Return(NewPipelinePack(nil))
-			dRunner.EXPECT().NewPack().Return(NewPipelinePack(nil))
+			dRunner.EXPECT().NewPackFrom(pack).Return(NewPipelinePack(nil))
+			dRunner.EXPECT().NewPackFrom(pack).Return(NewPipelinePack(nil))
 
 			// Decode and check the main pack.
 			pack.Message.SetPayload(mergeStats(stats))
@@ -22,6 +22,7 @@ import (
 	"math"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -30,10 +31,11 @@ import (
 // for standard statsd packets of message type Counter, Gauge, or Timer. It
 // also accepts StatPacket objects generated from within Heka itself (usually
 // via a configured StatFilter plugin) over the exposed `Packet` channel. It
-// currently doesn't support Sets or other metric types.
+// currently doesn't support Sets or other metric types. Several UDP sockets
+// can share the address, each read by its own goroutine.
 type StatsdInput struct {
 	name          string
-	listener      net.Conn
+	listeners     []net.Conn
 	stopChan      chan bool
 	statChan      chan<- Stat
 	statAccumName string
@@ -44,9 +46,14 @@ type StatsdInput struct {
 
 // StatsInput config struct
 type StatsdInputConfig struct {
+	// Network type ("udp", "udp4" or "udp6"). Defaults to "udp".
+	Net string
 	// UDP Address to listen to for statsd packets. Defaults to
 	// "127.0.0.1:8125".
 	Address string
+	// Name of a network interface to listen on. If set, Address must only
+	// specify a port (e.g. ":8125").
+	Interface string
 	// Configured name of StatAccumInput plugin to which this filter should be
 	// delivering its stats. Defaults to "StatsAccumInput".
 	StatAccumName string `toml:"stat_accum_name"`
@@ -54,33 +61,49 @@ type StatsdInputConfig struct {
 	// sends a lots in single message of stats it's required to boost this value.
 	// Defaults to 512.
 	MaxMsgSize uint `toml:"max_msg_size"`
+	// Number of sockets to listen on, each read by its own goroutine. More
+	// than one requires SO_REUSEPORT support. Defaults to 1.
+	Sockets uint
 }
 
 func (s *StatsdInput) ConfigStruct() interface{} {
 	return &StatsdInputConfig{
+		Net:           "udp",
 		Address:       "127.0.0.1:8125",
 		StatAccumName: "StatAccumInput",
 		MaxMsgSize:    512,
+		Sockets:       1,
 	}
 }
 
 func (s *StatsdInput) Init(config interface{}) error {
 	conf := config.(*StatsdInputConfig)
-	udpAddr, err := net.ResolveUDPAddr("udp", conf.Address)
+	var err error
+	address := conf.Address
+	if conf.Interface != "" {
+		if address, err = InterfaceAddress(conf.Net, conf.Interface, address); err != nil {
+			return err
+		}
+	}
+	udpAddr, err := net.ResolveUDPAddr(conf.Net, address)
 	if err != nil {
 		return fmt.Errorf("ResolveUDPAddr failed: %s\n", err.Error())
 	}
-	s.listener, err = net.ListenUDP("udp", udpAddr)
+	conns, err := ListenUDPSockets(conf.Net, udpAddr, int(conf.Sockets))
 	if err != nil {
 		return fmt.Errorf("ListenUDP failed: %s\n", err.Error())
 	}
+	s.listeners = make([]net.Conn, len(conns))
+	for i, conn := range conns {
+		s.listeners[i] = conn
+	}
 	s.statAccumName = conf.StatAccumName
 	s.maxMsgSize = conf.MaxMsgSize
 	s.stopChan = make(chan bool)
 	return nil
 }
 
-// Spins up a statsd server listening on a UDP connection.
+// Spins up a statsd server listening on one or more UDP connections.
 func (s *StatsdInput) Run(ir InputRunner, h PluginHelper) (err error) {
 	s.ir = ir
 
@@ -88,19 +111,33 @@ func (s *StatsdInput) Run(ir InputRunner, h PluginHelper) (err error) {
 		return
 	}
 
-	// Spin up the UDP listener.
+	// Spin up a reader for each UDP listener.
+	var wg sync.WaitGroup
+	for _, listener := range s.listeners {
+		wg.Add(1)
+		go func(listener net.Conn) {
+			s.readListener(listener)
+			wg.Done()
+		}(listener)
+	}
+	wg.Wait()
+
+	return
+}
+
+func (s *StatsdInput) readListener(listener net.Conn) {
 	var (
 		n       int
 		e       error
 		stopped bool
 	)
-	defer s.listener.Close()
+	defer listener.Close()
 	timeout := time.Duration(time.Millisecond * 100)
 
 	for !stopped {
 		message := make([]byte, s.maxMsgSize)
-		s.listener.SetReadDeadline(time.Now().Add(timeout))
-		n, e = s.listener.Read(message)
+		listener.SetReadDeadline(time.Now().Add(timeout))
+		n, e = listener.Read(message)
 
 		select {
 		case <-s.stopChan:
@@ -114,8 +151,6 @@ func (s *StatsdInput) Run(ir InputRunner, h PluginHelper) (err error) {
 
 		s.handleMessage(message[:n])
 	}
-
-	return
 }
 
 func (s *StatsdInput) Stop() {
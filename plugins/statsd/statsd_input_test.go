@@ -22,6 +22,8 @@ import (
 	plugins_ts "github.com/mozilla-services/heka/plugins/testsupport"
 	"github.com/rafrombrc/gomock/gomock"
 	gs "github.com/rafrombrc/gospec/src/gospec"
+	"net"
+	"runtime"
 	"strconv"
 	"sync"
 	"testing"
@@ -54,11 +56,11 @@ func StatsdInputSpec(c gs.Context) {
 		config.Address = ith.AddrStr
 		err := statsdInput.Init(config)
 		c.Assume(err, gs.IsNil)
-		realListener := statsdInput.listener
+		realListener := statsdInput.listeners[0]
 		c.Expect(realListener.LocalAddr().String(), gs.Equals, ith.ResolvedAddrStr)
 		realListener.Close()
 		mockListener := pipeline_ts.NewMockConn(ctrl)
-		statsdInput.listener = mockListener
+		statsdInput.listeners = []net.Conn{mockListener}
 
 		ith.MockHelper.EXPECT().StatAccumulator("StatAccumInput").Return(mockStatAccum, nil)
 		mockListener.EXPECT().Close()
@@ -86,6 +88,23 @@ func StatsdInputSpec(c gs.Context) {
 			wg.Wait()
 		})
 	})
+
+	if runtime.GOOS == "linux" {
+		c.Specify("A StatsdInput with multiple sockets", func() {
+			statsdInput := StatsdInput{}
+			config := statsdInput.ConfigStruct().(*StatsdInputConfig)
+			config.Address = "127.0.0.1:55566"
+			config.Sockets = 4
+
+			err := statsdInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(statsdInput.listeners), gs.Equals, 4)
+			for _, listener := range statsdInput.listeners {
+				c.Expect(listener.LocalAddr().String(), gs.Equals, "127.0.0.1:55566")
+				listener.Close()
+			}
+		})
+	}
 }
 
 func TestParseMessage(t *testing.T) {
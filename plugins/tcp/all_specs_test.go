@@ -24,7 +24,10 @@ func TestAllSpecs(t *testing.T) {
 	r.Parallel = false
 
 	r.AddSpec(TcpInputSpec)
+	r.AddSpec(SharedBudgetSpec)
 	r.AddSpec(TcpOutputSpec)
+	r.AddSpec(TcpOutputDiscoverySpec)
+	r.AddSpec(ThrottleSpec)
 	r.AddSpec(TlsSpec)
 	r.AddSpec(TcpInputSpecFailure)
 
@@ -0,0 +1,197 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// Token bucket limiting a connection to rate units per second, allowing
+// bursts of up to one second's worth.
+type throttle struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newThrottle(rate uint, now time.Time) *throttle {
+	return &throttle{rate: float64(rate), tokens: float64(rate), last: now}
+}
+
+// Takes n units at time now and returns how long the caller has to wait
+// for them. Units beyond those available are borrowed from the future, so
+// the wait pays them back.
+func (th *throttle) take(n int, now time.Time) time.Duration {
+	th.tokens += now.Sub(th.last).Seconds() * th.rate
+	if th.tokens > th.rate {
+		th.tokens = th.rate
+	}
+	th.last = now
+	th.tokens -= float64(n)
+	if th.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-th.tokens / th.rate * float64(time.Second))
+}
+
+// Reader limiting the rate at which bytes are read from a connection. Not
+// reading applies TCP back pressure to the sender.
+type throttledReader struct {
+	r     io.Reader
+	th    *throttle
+	input *TcpInput
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if max := int(tr.th.rate); len(p) > max {
+		p = p[:max]
+	}
+	n, err := tr.r.Read(p)
+	tr.input.throttleWait(tr.th.take(n, time.Now()))
+	return n, err
+}
+
+// Deliverer limiting the rate at which messages from a connection are
+// delivered.
+type throttledDeliverer struct {
+	Deliverer
+	th    *throttle
+	input *TcpInput
+}
+
+func (td *throttledDeliverer) Deliver(pack *PipelinePack) {
+	td.input.throttleWait(td.th.take(1, time.Now()))
+	td.Deliverer.Deliver(pack)
+}
+
+// Ingest budget shared by all of an input's connections. The budget's
+// throttle limits the total rate while deficit round robin scheduling keeps
+// the connections waiting for it fair: each turn a connection is credited a
+// quantum of bytes and may only read once its credit covers what it read, so
+// a chatty client can't take more than its share from the others.
+type sharedBudget struct {
+	th      *throttle
+	quantum int
+	lock    sync.Mutex
+	// Flows waiting for a grant, in round robin order.
+	waiting []*budgetFlow
+	wake    chan bool
+	input   *TcpInput
+}
+
+func newSharedBudget(rate, quantum uint, input *TcpInput) *sharedBudget {
+	return &sharedBudget{
+		th:      newThrottle(rate, time.Now()),
+		quantum: int(quantum),
+		wake:    make(chan bool, 1),
+		input:   input,
+	}
+}
+
+// A single connection's share of a sharedBudget.
+type budgetFlow struct {
+	budget  *sharedBudget
+	deficit int
+	cost    int
+	granted chan bool
+}
+
+func (b *sharedBudget) newFlow() *budgetFlow {
+	return &budgetFlow{budget: b, granted: make(chan bool, 1)}
+}
+
+// Queues a request for n units from the flow.
+func (b *sharedBudget) request(f *budgetFlow, n int) {
+	b.lock.Lock()
+	f.cost = n
+	b.waiting = append(b.waiting, f)
+	b.lock.Unlock()
+	select {
+	case b.wake <- true:
+	default:
+	}
+}
+
+// Picks the next waiting flow to be served, crediting each flow it passes
+// over with a quantum. Returns nil if no flow is waiting.
+func (b *sharedBudget) next() *budgetFlow {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for len(b.waiting) > 0 {
+		f := b.waiting[0]
+		b.waiting = b.waiting[1:]
+		if f.deficit < f.cost {
+			f.deficit += b.quantum
+		}
+		if f.deficit >= f.cost {
+			f.deficit -= f.cost
+			return f
+		}
+		b.waiting = append(b.waiting, f)
+	}
+	return nil
+}
+
+// Grants the waiting flows their requests in turn, at the budget's rate,
+// until the input is stopped.
+func (b *sharedBudget) run() {
+	for {
+		f := b.next()
+		if f == nil {
+			select {
+			case <-b.wake:
+				continue
+			case <-b.input.stopChan:
+				return
+			}
+		}
+		b.input.throttleWait(b.th.take(f.cost, time.Now()))
+		select {
+		case <-b.input.stopChan:
+			return
+		default:
+		}
+		f.granted <- true
+	}
+}
+
+// Waits until the flow has been granted n units, returning early if the
+// input is stopped.
+func (f *budgetFlow) acquire(n int) {
+	f.budget.request(f, n)
+	select {
+	case <-f.granted:
+	case <-f.budget.input.stopChan:
+	}
+}
+
+// Reader charging the bytes read from a connection to the input's shared
+// budget.
+type budgetReader struct {
+	r    io.Reader
+	flow *budgetFlow
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	if max := br.flow.budget.quantum; len(p) > max {
+		p = p[:max]
+	}
+	n, err := br.r.Read(p)
+	if n > 0 {
+		br.flow.acquire(n)
+	}
+	return n, err
+}
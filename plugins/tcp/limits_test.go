@@ -0,0 +1,125 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"bytes"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ThrottleSpec(c gs.Context) {
+	start := time.Unix(1456833600, 0)
+
+	c.Specify("A throttle", func() {
+		th := newThrottle(100, start)
+
+		c.Specify("allows a one second burst", func() {
+			c.Expect(th.take(60, start), gs.Equals, time.Duration(0))
+			c.Expect(th.take(40, start), gs.Equals, time.Duration(0))
+			c.Expect(th.take(50, start), gs.Equals, 500*time.Millisecond)
+		})
+
+		c.Specify("refills at its rate", func() {
+			th.take(100, start)
+			now := start.Add(200 * time.Millisecond)
+			c.Expect(th.take(20, now), gs.Equals, time.Duration(0))
+			c.Expect(th.take(10, now), gs.Equals, 100*time.Millisecond)
+
+			// Idle time doesn't accumulate beyond the burst.
+			now = now.Add(time.Hour)
+			c.Expect(th.take(100, now), gs.Equals, time.Duration(0))
+			c.Expect(th.take(1, now), gs.Equals, 10*time.Millisecond)
+		})
+	})
+
+	c.Specify("A throttledReader", func() {
+		input := &TcpInput{stopChan: make(chan bool)}
+		data := bytes.Repeat([]byte("x"), 64)
+		reader := &throttledReader{bytes.NewReader(data),
+			newThrottle(1000, time.Now()), input}
+
+		c.Specify("reads at most a second's worth at once", func() {
+			reader.th = newThrottle(16, time.Now())
+			buf := make([]byte, 64)
+			n, err := reader.Read(buf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(n, gs.Equals, 16)
+			c.Expect(input.throttledCount, gs.Equals, int64(0))
+
+			// The next read has to wait, but stopping the input ends the
+			// wait early.
+			close(input.stopChan)
+			n, err = reader.Read(buf)
+			c.Expect(n, gs.Equals, 16)
+			c.Expect(input.throttledCount, gs.Equals, int64(1))
+		})
+
+		c.Specify("doesn't wait below its rate", func() {
+			buf := make([]byte, 64)
+			n, err := reader.Read(buf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(n, gs.Equals, 64)
+			c.Expect(input.throttledCount, gs.Equals, int64(0))
+		})
+	})
+}
+
+func SharedBudgetSpec(c gs.Context) {
+	input := &TcpInput{stopChan: make(chan bool)}
+	budget := newSharedBudget(1000000, 100, input)
+
+	c.Specify("A sharedBudget", func() {
+		chatty := budget.newFlow()
+		quiet := budget.newFlow()
+
+		c.Specify("serves nothing without requests", func() {
+			c.Expect(budget.next() == nil, gs.IsTrue)
+		})
+
+		c.Specify("makes large requests wait for their credit", func() {
+			budget.request(chatty, 250)
+			budget.request(quiet, 50)
+			c.Expect(budget.next(), gs.Equals, quiet)
+			c.Expect(budget.next(), gs.Equals, chatty)
+			c.Expect(chatty.deficit, gs.Equals, 50)
+			c.Expect(budget.next() == nil, gs.IsTrue)
+		})
+
+		c.Specify("alternates between busy flows", func() {
+			var served []*budgetFlow
+			budget.request(chatty, 100)
+			budget.request(quiet, 100)
+			for i := 0; i < 4; i++ {
+				f := budget.next()
+				served = append(served, f)
+				budget.request(f, 100)
+			}
+			c.Expect(served[0], gs.Equals, chatty)
+			c.Expect(served[1], gs.Equals, quiet)
+			c.Expect(served[2], gs.Equals, chatty)
+			c.Expect(served[3], gs.Equals, quiet)
+		})
+
+		c.Specify("grants requests while running", func() {
+			go budget.run()
+			reader := &budgetReader{bytes.NewReader(bytes.Repeat([]byte("x"), 64)),
+				chatty}
+			buf := make([]byte, 64)
+			n, err := reader.Read(buf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(n, gs.Equals, 64)
+			close(input.stopChan)
+		})
+	})
+}
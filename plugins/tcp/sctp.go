@@ -0,0 +1,29 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"strings"
+)
+
+// isSCTP reports whether the network name refers to SCTP ("sctp", "sctp4"
+// or "sctp6").
+func isSCTP(network string) bool {
+	return strings.HasPrefix(network, "sctp")
+}
+
+// sctpAddrNetwork returns the TCP network name to use when resolving an
+// address for the given SCTP network. SCTP uses the same host:port address
+// format as TCP.
+func sctpAddrNetwork(network string) string {
+	return "tcp" + strings.TrimPrefix(network, "sctp")
+}
@@ -0,0 +1,128 @@
+// +build linux
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// SCTP sockets are created with one-to-one (SOCK_STREAM) semantics, which
+// behave like TCP sockets as far as the net package is concerned. That lets
+// us hand the socket off to net.FileListener / net.FileConn and use the
+// result exactly like a TCP listener or connection.
+
+func sctpFamily(network string, ip net.IP) int {
+	switch network {
+	case "sctp4":
+		return syscall.AF_INET
+	case "sctp6":
+		return syscall.AF_INET6
+	}
+	if ip != nil && ip.To4() != nil && !ip.IsUnspecified() {
+		return syscall.AF_INET
+	}
+	return syscall.AF_INET6
+}
+
+func sctpSockaddr(family int, addr *net.TCPAddr) (syscall.Sockaddr, error) {
+	if family == syscall.AF_INET {
+		sa := &syscall.SockaddrInet4{Port: addr.Port}
+		if addr.IP != nil {
+			copy(sa.Addr[:], addr.IP.To4())
+		}
+		return sa, nil
+	}
+	sa := &syscall.SockaddrInet6{Port: addr.Port}
+	if addr.IP != nil {
+		copy(sa.Addr[:], addr.IP.To16())
+	}
+	if addr.Zone != "" {
+		ifi, err := net.InterfaceByName(addr.Zone)
+		if err != nil {
+			return nil, err
+		}
+		sa.ZoneId = uint32(ifi.Index)
+	}
+	return sa, nil
+}
+
+func sctpSocket(family int) (int, error) {
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC,
+		syscall.IPPROTO_SCTP)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	return fd, nil
+}
+
+func sctpBind(fd, family int, addr *net.TCPAddr) error {
+	sa, err := sctpSockaddr(family, addr)
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("bind", syscall.Bind(fd, sa))
+}
+
+// listenSCTP returns a listener accepting SCTP associations on the given
+// address.
+func listenSCTP(network string, laddr *net.TCPAddr) (net.Listener, error) {
+	family := sctpFamily(network, laddr.IP)
+	fd, err := sctpSocket(family)
+	if err != nil {
+		return nil, err
+	}
+	err = os.NewSyscallError("setsockopt", syscall.SetsockoptInt(fd,
+		syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1))
+	if err == nil {
+		err = sctpBind(fd, family, laddr)
+	}
+	if err == nil {
+		err = os.NewSyscallError("listen", syscall.Listen(fd, syscall.SOMAXCONN))
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "sctp")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// dialSCTP establishes an SCTP association with the remote address,
+// optionally binding to a local address first.
+func dialSCTP(network string, laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	family := sctpFamily(network, raddr.IP)
+	fd, err := sctpSocket(family)
+	if err != nil {
+		return nil, err
+	}
+	if laddr != nil {
+		err = sctpBind(fd, family, laddr)
+	}
+	if err == nil {
+		var sa syscall.Sockaddr
+		if sa, err = sctpSockaddr(family, raddr); err == nil {
+			err = os.NewSyscallError("connect", syscall.Connect(fd, sa))
+		}
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "sctp")
+	defer f.Close()
+	return net.FileConn(f)
+}
@@ -0,0 +1,29 @@
+// +build !linux
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package tcp
+
+import (
+	"errors"
+	"net"
+)
+
+var errSCTPUnsupported = errors.New("SCTP is only supported on Linux")
+
+func listenSCTP(network string, laddr *net.TCPAddr) (net.Listener, error) {
+	return nil, errSCTPUnsupported
+}
+
+func dialSCTP(network string, laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	return nil, errSCTPUnsupported
+}
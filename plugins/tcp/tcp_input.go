@@ -16,75 +16,163 @@
 package tcp
 
 import (
+	"bytes"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 )
 
+// Name of the message field holding the common name of a verified TLS client
+// certificate.
+const clientCNField = "TlsClientCN"
+
 // Input plugin implementation that listens for Heka protocol messages on a
 // specified TCP socket. Creates a separate goroutine for each TCP connection.
 type TcpInput struct {
+	// Accessed atomically, kept first for 64-bit alignment.
+	activeCount       int64
+	rejectedCount     int64
+	throttledCount    int64
 	keepAliveDuration time.Duration
 	listener          net.Listener
+	budget            *sharedBudget
 	wg                sync.WaitGroup
 	stopChan          chan bool
 	ir                InputRunner
 	config            *TcpInputConfig
+	pConfig           *PipelineConfig
+	// Decompresses the data read from each connection, if compression is
+	// enabled.
+	codec CompressionCodec
+	// Connections taken over from the hekad process being upgraded.
+	inherited []InheritedConn
 }
 
 type TcpInputConfig struct {
-	// Network type (e.g. "tcp", "tcp4", "tcp6", "unix" or "unixpacket").
-	// Needs to match the input type.
+	// Network type (e.g. "tcp", "tcp4", "tcp6", "unix", "unixpacket", or, on
+	// Linux, "sctp", "sctp4" or "sctp6"). Needs to match the input type.
 	Net string
 	// String representation of the address of the network connection on which
-	// the listener should be listening (e.g. "127.0.0.1:5565").
+	// the listener should be listening (e.g. "127.0.0.1:5565" or
+	// "[::1]:5565").
 	Address string
+	// Name of a network interface to listen on. If set, Address must only
+	// specify a port (e.g. ":5565").
+	Interface string
 	// Set to true if the TCP connection should be tunneled through TLS.
 	// Requires additional Tls config section.
 	UseTls bool `toml:"use_tls"`
 	// Subsection for TLS configuration.
 	Tls TlsConfig
+	// Name of a shared TlsConfig resource to use instead of the Tls
+	// subsection. Implies UseTls.
+	TlsResource string `toml:"tls_resource"`
 	// Set to true if TCP Keep Alive should be used.
 	KeepAlive bool `toml:"keep_alive"`
 	// Integer indicating seconds between keep alives.
 	KeepAlivePeriod int `toml:"keep_alive_period"`
+	// Maximum number of simultaneous connections. Further connections are
+	// closed as soon as they're accepted. Defaults to 0, which means no
+	// limit.
+	MaxConnections uint `toml:"max_connections"`
+	// Maximum rate, in bytes per second, at which data is read from each
+	// connection. Defaults to 0, which means no limit.
+	MaxBytesPerSec uint `toml:"max_bytes_per_sec"`
+	// Maximum rate, in messages per second, at which messages from each
+	// connection are delivered. Defaults to 0, which means no limit.
+	MaxMessagesPerSec uint `toml:"max_messages_per_sec"`
+	// Maximum rate, in bytes per second, at which data is read from all
+	// connections combined. The budget is shared fairly between the
+	// connections using it. Defaults to 0, which means no limit.
+	SharedBytesPerSec uint `toml:"shared_bytes_per_sec"`
+	// Number of bytes each connection is credited per round when sharing
+	// the budget. Defaults to 16384.
+	FairShareQuantum uint `toml:"fair_share_quantum"`
 	// So we can default to using ProtobufDecoder.
 	Decoder string
 	// So we can default to using HekaFramingSplitter.
 	Splitter string
+	// Name of the compression codec the data sent over each connection is
+	// compressed with, "none" by default. Needs to match the sending
+	// TcpOutput's setting.
+	Compression string `toml:"compression"`
 }
 
 func (t *TcpInput) ConfigStruct() interface{} {
 	config := &TcpInputConfig{
-		Net:      "tcp",
-		Decoder:  "ProtobufDecoder",
-		Splitter: "HekaFramingSplitter",
+		Net:              "tcp",
+		Decoder:          "ProtobufDecoder",
+		Splitter:         "HekaFramingSplitter",
+		FairShareQuantum: 16384,
 	}
 	config.Tls = TlsConfig{PreferServerCiphers: true}
 	return config
 }
 
+func (t *TcpInput) SetPipelineConfig(pConfig *PipelineConfig) {
+	t.pConfig = pConfig
+}
+
 func (t *TcpInput) Init(config interface{}) error {
 	var err error
 	t.config = config.(*TcpInputConfig)
-	address, err := net.ResolveTCPAddr(t.config.Net, t.config.Address)
-	if err != nil {
-		return fmt.Errorf("ResolveTCPAddress failed: %s\n", err.Error())
+	if t.codec, err = GetCompressionCodec(t.config.Compression); err != nil {
+		return err
 	}
-	t.listener, err = net.ListenTCP(t.config.Net, address)
-	if err != nil {
-		return fmt.Errorf("ListenTCP failed: %s\n", err.Error())
+	if t.config.TlsResource != "" {
+		tlsConf, err := TlsResourceConfig(t.pConfig, t.config.TlsResource)
+		if err != nil {
+			return err
+		}
+		t.config.Tls = *tlsConf
+		t.config.UseTls = true
+	}
+	addrStr := t.config.Address
+	if t.config.Interface != "" {
+		if addrStr, err = InterfaceAddress(t.config.Net, t.config.Interface,
+			addrStr); err != nil {
+			return err
+		}
+	}
+	if isSCTP(t.config.Net) {
+		if t.config.KeepAlive {
+			return errors.New("KeepAlive only supported for TCP Connections.")
+		}
+		address, err := net.ResolveTCPAddr(sctpAddrNetwork(t.config.Net), addrStr)
+		if err != nil {
+			return fmt.Errorf("ResolveTCPAddress failed: %s\n", err.Error())
+		}
+		if t.listener, err = listenSCTP(t.config.Net, address); err != nil {
+			return fmt.Errorf("ListenSCTP failed: %s\n", err.Error())
+		}
+	} else {
+		address, err := net.ResolveTCPAddr(t.config.Net, addrStr)
+		if err != nil {
+			return fmt.Errorf("ResolveTCPAddress failed: %s\n", err.Error())
+		}
+		t.listener, err = ListenTCP(t.config.Net, address)
+		if err != nil {
+			return fmt.Errorf("ListenTCP failed: %s\n", err.Error())
+		}
+		t.inherited = InheritedConns(t.listener)
 	}
 	// We're already listening, make sure we clean up if init fails later on.
 	closeIt := true
 	defer func() {
 		if closeIt {
 			t.listener.Close()
+			for _, ic := range t.inherited {
+				ic.Conn.Close()
+			}
 		}
 	}()
 	if t.config.UseTls {
@@ -96,6 +184,13 @@ func (t *TcpInput) Init(config interface{}) error {
 		t.keepAliveDuration = time.Duration(t.config.KeepAlivePeriod) * time.Second
 	}
 	t.stopChan = make(chan bool)
+	if t.config.SharedBytesPerSec > 0 {
+		if t.config.FairShareQuantum == 0 {
+			return errors.New("fair_share_quantum must be greater than 0")
+		}
+		t.budget = newSharedBudget(t.config.SharedBytesPerSec,
+			t.config.FairShareQuantum, t)
+	}
 	closeIt = false
 	return nil
 }
@@ -111,9 +206,45 @@ func (t *TcpInput) setupTls(tomlConf *TlsConfig) (err error) {
 	return
 }
 
+// Returns the subject common name of the client certificate presented during
+// the TLS handshake, or an empty string if no certificate was verified.
+func verifiedClientCN(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
+
+// Stores the verified client common name in the pack's message, replacing
+// any field of the same name the sender may have supplied. If the pack only
+// holds raw message bytes they are decoded and re-encoded so the field
+// survives the decoder.
+func setClientCN(pack *PipelinePack, cn string, useMsgBytes bool) (err error) {
+	if useMsgBytes {
+		if err = proto.Unmarshal(pack.MsgBytes, pack.Message); err != nil {
+			return
+		}
+	}
+	for _, f := range pack.Message.FindAllFields(clientCNField) {
+		pack.Message.DeleteField(f)
+	}
+	message.NewStringField(pack.Message, clientCNField, cn)
+	if useMsgBytes {
+		var msgBytes []byte
+		if msgBytes, err = proto.Marshal(pack.Message); err != nil {
+			return
+		}
+		pack.MsgBytes = msgBytes
+	}
+	return
+}
+
 // Listen on the provided TCP connection, extracting messages from the incoming
-// data until the connection is closed or Stop is called on the input.
-func (t *TcpInput) handleConnection(conn net.Conn) {
+// data, starting with any `pending` data read from the connection by the hekad
+// process it was taken over from, until the connection is closed or Stop is
+// called on the input. Connections still open when the input is stopped for
+// an upgrade are handed over to the new hekad process.
+func (t *TcpInput) handleConnection(conn net.Conn, pending []byte) {
 	raddr := conn.RemoteAddr().String()
 	host, _, err := net.SplitHostPort(raddr)
 	if err != nil {
@@ -125,28 +256,86 @@ func (t *TcpInput) handleConnection(conn net.Conn) {
 
 	defer func() {
 		conn.Close()
+		atomic.AddInt64(&t.activeCount, -1)
 		t.wg.Done()
 		deliverer.Done()
 		sr.Done()
 	}()
 
-	if !sr.UseMsgBytes() {
-		name := t.ir.Name()
+	var reader io.Reader = conn
+	if t.codec != nil {
+		// Decompressing readers don't survive the read deadline timeouts
+		// used to check for shutdown, so they're handled underneath.
+		reader = &retryingReader{conn, t.stopChan}
+	}
+	if len(pending) > 0 {
+		reader = io.MultiReader(bytes.NewReader(pending), reader)
+	}
+	if t.config.MaxBytesPerSec > 0 {
+		th := newThrottle(t.config.MaxBytesPerSec, time.Now())
+		reader = &throttledReader{reader, th, t}
+	}
+	if t.budget != nil {
+		reader = &budgetReader{reader, t.budget.newFlow()}
+	}
+	if t.codec != nil {
+		decompressor, err := t.codec.NewReader(reader)
+		if err != nil {
+			if err != io.EOF {
+				t.ir.LogError(fmt.Errorf("can't decompress data from %s: %s", raddr,
+					err))
+			}
+			return
+		}
+		defer decompressor.Close()
+		reader = decompressor
+	}
+	var del Deliverer = deliverer
+	if t.config.MaxMessagesPerSec > 0 {
+		th := newThrottle(t.config.MaxMessagesPerSec, time.Now())
+		del = &throttledDeliverer{deliverer, th, t}
+	}
+
+	useMsgBytes := sr.UseMsgBytes()
+	tlsConn, isTls := conn.(*tls.Conn)
+	if !useMsgBytes || isTls {
+		var name, clientCN string
+		if !useMsgBytes {
+			name = t.ir.Name()
+		}
+		// The handshake happens on the first read, so the peer certificate
+		// isn't available until the first record has been split off.
+		cnChecked := false
 		packDec := func(pack *PipelinePack) {
-			pack.Message.SetHostname(raddr)
-			pack.Message.SetType(name)
+			if !useMsgBytes {
+				pack.Message.SetHostname(raddr)
+				pack.Message.SetType(name)
+			}
+			if !isTls {
+				return
+			}
+			if !cnChecked {
+				clientCN = verifiedClientCN(tlsConn.ConnectionState())
+				cnChecked = true
+			}
+			if clientCN != "" {
+				if err := setClientCN(pack, clientCN, useMsgBytes); err != nil {
+					t.ir.LogError(fmt.Errorf("can't set %s for %s: %s",
+						clientCNField, raddr, err))
+				}
+			}
 		}
 		sr.SetPackDecorator(packDec)
 	}
 
-	stopped := false
+	stopped, stopping := false, false
 	for !stopped {
 		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 		select {
 		case <-t.stopChan:
-			stopped = true
+			stopped, stopping = true, true
 		default:
-			err = sr.SplitStream(conn, deliverer)
+			err = sr.SplitStream(reader, del)
 			if err != nil {
 				if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
 					// keep the connection open, we are just checking to see if
@@ -157,10 +346,46 @@ func (t *TcpInput) handleConnection(conn net.Conn) {
 			}
 		}
 	}
+	// Hand the connection over if we're stopping for an upgrade, unless it's
+	// compressed, as the decompressor's state can't be handed over.
+	if stopping && t.codec == nil {
+		HandOverConn(t.listener, conn, sr.GetRemainingData())
+	}
+}
+
+// Reads from a connection, retrying the reads that hit the read deadline
+// until the input is stopped, at which point it returns io.EOF.
+type retryingReader struct {
+	conn     net.Conn
+	stopChan chan bool
+}
+
+func (r *retryingReader) Read(p []byte) (n int, err error) {
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err = r.conn.Read(p)
+		if neterr, ok := err.(net.Error); !ok || !neterr.Timeout() || n > 0 {
+			return n, err
+		}
+		select {
+		case <-r.stopChan:
+			return 0, io.EOF
+		default:
+		}
+	}
 }
 
 func (t *TcpInput) Run(ir InputRunner, h PluginHelper) error {
 	t.ir = ir
+	if t.budget != nil {
+		go t.budget.run()
+	}
+	for _, ic := range t.inherited {
+		atomic.AddInt64(&t.activeCount, 1)
+		t.wg.Add(1)
+		go t.handleConnection(ic.Conn, ic.Pending)
+	}
+	t.inherited = nil
 	var conn net.Conn
 	var e error
 	for {
@@ -182,13 +407,34 @@ func (t *TcpInput) Run(ir InputRunner, h PluginHelper) error {
 				tcpConn.SetKeepAlivePeriod(t.keepAliveDuration)
 			}
 		}
+		if t.config.MaxConnections > 0 &&
+			atomic.LoadInt64(&t.activeCount) >= int64(t.config.MaxConnections) {
+
+			conn.Close()
+			atomic.AddInt64(&t.rejectedCount, 1)
+			continue
+		}
+		atomic.AddInt64(&t.activeCount, 1)
 		t.wg.Add(1)
-		go t.handleConnection(conn)
+		go t.handleConnection(conn, nil)
 	}
 	t.wg.Wait()
 	return nil
 }
 
+// Waits for a throttled connection, returning early if the input is
+// stopped.
+func (t *TcpInput) throttleWait(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	atomic.AddInt64(&t.throttledCount, 1)
+	select {
+	case <-time.After(d):
+	case <-t.stopChan:
+	}
+}
+
 func (t *TcpInput) Stop() {
 	if err := t.listener.Close(); err != nil {
 		t.ir.LogError(fmt.Errorf("Error closing listener: %s", err))
@@ -196,6 +442,16 @@ func (t *TcpInput) Stop() {
 	close(t.stopChan)
 }
 
+func (t *TcpInput) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "ActiveConnections",
+		atomic.LoadInt64(&t.activeCount), "count")
+	message.NewInt64Field(msg, "RejectedConnections",
+		atomic.LoadInt64(&t.rejectedCount), "count")
+	message.NewInt64Field(msg, "ThrottledCount",
+		atomic.LoadInt64(&t.throttledCount), "count")
+	return nil
+}
+
 func init() {
 	RegisterPlugin("TcpInput", func() interface{} {
 		return new(TcpInput)
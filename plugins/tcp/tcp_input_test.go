@@ -17,12 +17,18 @@ package tcp
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"io"
 	"io/ioutil"
 	"net"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/pipelinemock"
@@ -91,8 +97,8 @@ func TcpInputSpec(c gs.Context) {
 			// second time it returns io.EOF.
 			splitCall := ith.MockSplitterRunner.EXPECT().SplitStream(gomock.Any(),
 				ith.MockDeliverer).AnyTimes()
-			splitCall.Do(func(conn net.Conn, del Deliverer) {
-				recd, _ := ioutil.ReadAll(conn)
+			splitCall.Do(func(r io.Reader, del Deliverer) {
+				recd, _ := ioutil.ReadAll(r)
 				bytesChan <- recd
 				splitCall.Return(io.EOF)
 			})
@@ -126,6 +132,100 @@ func TcpInputSpec(c gs.Context) {
 				c.Expect(err, gs.IsNil)
 				srDoneWG.Wait()
 			})
+
+			c.Specify("splits the pending data of taken over connections first", func() {
+				other, err := net.Listen("tcp", "127.0.0.1:0")
+				c.Assume(err, gs.IsNil)
+				defer other.Close()
+				outConn, err := net.Dial("tcp", other.Addr().String())
+				c.Assume(err, gs.IsNil)
+				inConn, err := other.Accept()
+				c.Assume(err, gs.IsNil)
+				tcpInput.inherited = []InheritedConn{
+					{Conn: inConn, Pending: []byte("THIS IS ")},
+				}
+
+				go startServer()
+				_, err = outConn.Write([]byte("THE DATA"))
+				c.Expect(err, gs.IsNil)
+				outConn.Close()
+
+				recd := <-bytesChan
+				c.Expect(string(recd), gs.Equals, "THIS IS THE DATA")
+
+				tcpInput.Stop()
+				err = <-errChan
+				c.Expect(err, gs.IsNil)
+				srDoneWG.Wait()
+			})
+		})
+
+		c.Specify("decompresses the data of each connection", func() {
+			config.Compression = "gzip"
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			go startServer()
+			data := []byte("THIS IS THE DATA")
+
+			outConn, err := net.Dial("tcp", ith.AddrStr)
+			c.Assume(err, gs.IsNil)
+			codec, _ := GetCompressionCodec("gzip")
+			w, err := codec.NewWriter(outConn)
+			c.Assume(err, gs.IsNil)
+			_, err = w.Write(data)
+			c.Expect(err, gs.IsNil)
+			w.Close()
+			outConn.Close()
+
+			recd := <-bytesChan
+			c.Expect(string(recd), gs.Equals, string(data))
+
+			tcpInput.Stop()
+			err = <-errChan
+			c.Expect(err, gs.IsNil)
+			srDoneWG.Wait()
+		})
+
+		c.Specify("rejects an unknown compression codec", func() {
+			config.Compression = "rar"
+			err := tcpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects connections beyond max_connections", func() {
+			config.MaxConnections = 1
+			err := tcpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			go startServer()
+
+			conn1, err := net.Dial("tcp", ith.AddrStr)
+			c.Assume(err, gs.IsNil)
+			conn2, err := net.Dial("tcp", ith.AddrStr)
+			c.Assume(err, gs.IsNil)
+			// The input closes the second connection right away.
+			conn2.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn2.Read(make([]byte, 1))
+			c.Expect(err, gs.Equals, io.EOF)
+			conn2.Close()
+
+			data := []byte("THIS IS THE DATA")
+			_, err = conn1.Write(data)
+			c.Expect(err, gs.IsNil)
+			conn1.Close()
+			recd := <-bytesChan
+			c.Expect(string(recd), gs.Equals, string(data))
+
+			tcpInput.Stop()
+			err = <-errChan
+			c.Expect(err, gs.IsNil)
+			srDoneWG.Wait()
+
+			msg := new(message.Message)
+			tcpInput.ReportMsg(msg)
+			rejected, _ := msg.GetFieldValue("RejectedConnections")
+			c.Expect(rejected, gs.Equals, int64(1))
+			active, _ := msg.GetFieldValue("ActiveConnections")
+			c.Expect(active, gs.Equals, int64(0))
 		})
 
 		c.Specify("using TLS", func() {
@@ -193,6 +293,87 @@ func TcpInputSpec(c gs.Context) {
 				srDoneWG.Wait()
 			})
 		})
+
+		c.Specify("exposes the verified client certificate CN", func() {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "dc2-relay"}}
+			state := tls.ConnectionState{}
+			c.Expect(verifiedClientCN(state), gs.Equals, "")
+			state.PeerCertificates = []*x509.Certificate{cert}
+			c.Expect(verifiedClientCN(state), gs.Equals, "")
+			state.VerifiedChains = [][]*x509.Certificate{{cert}}
+			c.Expect(verifiedClientCN(state), gs.Equals, "dc2-relay")
+
+			pack := NewPipelinePack(pConfig.InputRecycleChan())
+
+			c.Specify("on payload packs", func() {
+				err := setClientCN(pack, "dc2-relay", false)
+				c.Expect(err, gs.IsNil)
+				cn, ok := pack.Message.GetFieldValue(clientCNField)
+				c.Expect(ok, gs.IsTrue)
+				c.Expect(cn.(string), gs.Equals, "dc2-relay")
+			})
+
+			c.Specify("on message bytes, replacing a spoofed value", func() {
+				msg := pipeline_ts.GetTestMessage()
+				message.NewStringField(msg, clientCNField, "spoofed")
+				pack.MsgBytes, _ = proto.Marshal(msg)
+
+				err := setClientCN(pack, "dc2-relay", true)
+				c.Expect(err, gs.IsNil)
+				decoded := new(message.Message)
+				err = proto.Unmarshal(pack.MsgBytes, decoded)
+				c.Assume(err, gs.IsNil)
+				fields := decoded.FindAllFields(clientCNField)
+				c.Expect(len(fields), gs.Equals, 1)
+				c.Expect(fields[0].GetValue().(string), gs.Equals, "dc2-relay")
+				c.Expect(decoded.GetPayload(), gs.Equals, msg.GetPayload())
+			})
+
+			c.Specify("fails on undecodable message bytes", func() {
+				pack.MsgBytes = []byte("not a protobuf message")
+				err := setClientCN(pack, "dc2-relay", true)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+		})
+
+		if runtime.GOOS == "linux" {
+			c.Specify("using SCTP", func() {
+				config.Net = "sctp4"
+				config.Address = "127.0.0.1:55567"
+
+				c.Specify("doesn't support keep alive", func() {
+					config.KeepAlive = true
+					err := tcpInput.Init(config)
+					c.Expect(err, gs.Not(gs.IsNil))
+				})
+
+				c.Specify("accepts associations and passes them to the splitter", func() {
+					err := tcpInput.Init(config)
+					if err != nil && strings.Contains(err.Error(), "protocol not supported") {
+						return // No SCTP support in this kernel.
+					}
+					c.Assume(err, gs.IsNil)
+					go startServer()
+					data := []byte("THIS IS THE DATA")
+
+					raddr, err := net.ResolveTCPAddr("tcp4", config.Address)
+					c.Assume(err, gs.IsNil)
+					outConn, err := dialSCTP("sctp4", nil, raddr)
+					c.Assume(err, gs.IsNil)
+					_, err = outConn.Write(data)
+					c.Expect(err, gs.IsNil)
+					outConn.Close()
+
+					recd := <-bytesChan
+					c.Expect(string(recd), gs.Equals, string(data))
+
+					tcpInput.Stop()
+					err = <-errChan
+					c.Expect(err, gs.IsNil)
+					srDoneWG.Wait()
+				})
+			})
+		}
 	})
 }
 
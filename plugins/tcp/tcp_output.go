@@ -18,7 +18,9 @@ package tcp
 
 import (
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"regexp"
 	"sync"
@@ -42,16 +44,36 @@ type TcpOutput struct {
 	reportLock          sync.Mutex
 	or                  OutputRunner
 	pConfig             *PipelineConfig
+	// Compresses the data sent over the connection, if compression is
+	// enabled.
+	codec      CompressionCodec
+	compressor io.WriteCloser
+	// Resource the address is picked from, if any.
+	discovery    *ServiceDiscovery
+	discoveryGen uint64
+	connectErr   error
+	// Ready once the first connection has been established, so inputs
+	// aren't started before the destination can be reached.
+	ReadyFlag
 }
 
 // ConfigStruct for TcpOutput plugin.
 type TcpOutputConfig struct {
+	// Network type ("tcp", "tcp4", "tcp6", or, on Linux, "sctp", "sctp4" or
+	// "sctp6"). Defaults to "tcp".
+	Net string
 	// String representation of the TCP address to which this output should be
 	// sending data.
 	Address      string
 	LocalAddress string `toml:"local_address"`
-	UseTls       bool   `toml:"use_tls"`
-	Tls          TlsConfig
+	// Name of a network interface to send from, as an alternative to
+	// local_address.
+	Interface string
+	UseTls    bool `toml:"use_tls"`
+	Tls       TlsConfig
+	// Name of a shared TlsConfig resource to use instead of the Tls
+	// subsection. Implies UseTls.
+	TlsResource string `toml:"tls_resource"`
 	// Interval at which the output queue logs will roll, in seconds. Defaults
 	// to 300.
 	TickerInterval uint `toml:"ticker_interval"`
@@ -71,6 +93,18 @@ type TcpOutputConfig struct {
 	// Defaults to true for TcpOutput.
 	UseBuffering *bool `toml:"use_buffering"`
 	Buffering    QueueBufferConfig
+	// Name of a ServiceDiscovery resource to pick the address from, among
+	// the discovered instances' endpoints, instead of using `address`.
+	DiscoveryResource string `toml:"discovery_resource"`
+	// Role of the discovered instances to send to. Defaults to "aggregator".
+	DiscoveryRole string `toml:"discovery_role"`
+	// Name of the discovered instances' endpoint to connect to. Defaults to
+	// "tcp".
+	DiscoveryEndpoint string `toml:"discovery_endpoint"`
+	// Name of the compression codec applied to the data sent over each
+	// connection, "none" by default. The receiving TcpInput needs the same
+	// setting.
+	Compression string `toml:"compression"`
 }
 
 func (t *TcpOutput) ConfigStruct() interface{} {
@@ -82,10 +116,14 @@ func (t *TcpOutput) ConfigStruct() interface{} {
 		FullAction:        "shutdown",
 	}
 	return &TcpOutputConfig{
+		Net:          "tcp",
 		Address:      "localhost:9125",
 		Encoder:      "ProtobufEncoder",
 		UseBuffering: &b,
 		Buffering:    queueConfig,
+
+		DiscoveryRole:     "aggregator",
+		DiscoveryEndpoint: "tcp",
 	}
 }
 
@@ -94,17 +132,55 @@ func (t *TcpOutput) SetName(name string) {
 	t.name = re.ReplaceAllString(name, "_")
 }
 
+func (t *TcpOutput) SetPipelineConfig(pConfig *PipelineConfig) {
+	t.pConfig = pConfig
+}
+
 func (t *TcpOutput) Init(config interface{}) (err error) {
 	t.conf = config.(*TcpOutputConfig)
 	t.address = t.conf.Address
 
+	if t.codec, err = GetCompressionCodec(t.conf.Compression); err != nil {
+		return
+	}
+
+	if t.conf.TlsResource != "" {
+		var tlsConf *TlsConfig
+		if tlsConf, err = TlsResourceConfig(t.pConfig, t.conf.TlsResource); err != nil {
+			return
+		}
+		t.conf.Tls = *tlsConf
+		t.conf.UseTls = true
+	}
+
+	if t.conf.DiscoveryResource != "" {
+		if t.discovery, err = t.pConfig.ServiceDiscovery(t.conf.DiscoveryResource); err != nil {
+			return
+		}
+		t.address = ""
+	}
+
+	if t.conf.Interface != "" {
+		if t.conf.LocalAddress != "" {
+			return errors.New("Cannot combine local_address and interface config options")
+		}
+		if t.conf.LocalAddress, err = InterfaceAddress(t.conf.Net, t.conf.Interface,
+			":0"); err != nil {
+			return
+		}
+	}
+
 	if t.conf.LocalAddress != "" {
 		// Error out if use_tls and local_address options are both set for now.
 		if t.conf.UseTls {
 			return fmt.Errorf("Cannot combine local_address %s and use_tls config options",
 				t.localAddress)
 		}
-		t.localAddress, err = net.ResolveTCPAddr("tcp", t.conf.LocalAddress)
+		t.localAddress, err = net.ResolveTCPAddr(t.addrNetwork(), t.conf.LocalAddress)
+	}
+
+	if isSCTP(t.conf.Net) && t.conf.KeepAlive {
+		return errors.New("KeepAlive only supported for TCP Connections.")
 	}
 
 	if t.conf.KeepAlivePeriod != 0 {
@@ -126,10 +202,21 @@ func (t *TcpOutput) Prepare(or OutputRunner, h PluginHelper) (err error) {
 	t.pConfig = h.PipelineConfig()
 	t.or = or
 
+	// Connect right away to warm up, failures are retried with the first
+	// message.
+	if t.connect() != nil {
+		t.connection = nil
+	} else {
+		t.SetReady()
+	}
 	return nil
 }
 
 func (t *TcpOutput) cleanupConn() {
+	if t.compressor != nil {
+		t.compressor.Close()
+		t.compressor = nil
+	}
 	if t.connection != nil {
 		t.connection.Close()
 		t.connection = nil
@@ -141,6 +228,11 @@ func (t *TcpOutput) CleanUp() {
 }
 
 func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
+	if t.connection != nil && t.discovery != nil &&
+		t.discovery.Generation() != t.discoveryGen {
+
+		t.checkDiscovered()
+	}
 	if t.connection == nil {
 		if err = t.connect(); err != nil {
 			// Explicitly set t.connection to nil because Go, see
@@ -148,6 +240,14 @@ func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
 			t.connection = nil
 			return NewRetryMessageError("can't connect: %s", err)
 		}
+		t.SetReady()
+	}
+	if t.codec != nil && t.compressor == nil {
+		// Each connection carries a single compressed stream.
+		if t.compressor, err = t.codec.NewWriter(t.connection); err != nil {
+			t.cleanupConn()
+			return NewRetryMessageError("can't compress: %s", err)
+		}
 	}
 
 	var (
@@ -160,7 +260,7 @@ func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
 		return fmt.Errorf("can't encode: %s", err)
 	}
 
-	if n, err = t.connection.Write(record); err != nil {
+	if n, err = t.write(record); err != nil {
 		t.cleanupConn()
 		err = NewRetryMessageError("writing to %s: %s", t.address, err)
 	} else if n != len(record) {
@@ -179,7 +279,115 @@ func (t *TcpOutput) ProcessMessage(pack *PipelinePack) (err error) {
 	return err
 }
 
+// Writes a record to the connection, compressing it and flushing the
+// compressed data so the record reaches the receiver right away if
+// compression is enabled.
+func (t *TcpOutput) write(record []byte) (n int, err error) {
+	if t.compressor == nil {
+		return t.connection.Write(record)
+	}
+	if n, err = t.compressor.Write(record); err == nil {
+		err = FlushCompressed(t.compressor)
+	}
+	return
+}
+
+// addrNetwork returns the network name to use when resolving addresses.
+func (t *TcpOutput) addrNetwork() string {
+	if isSCTP(t.conf.Net) {
+		return sctpAddrNetwork(t.conf.Net)
+	}
+	return t.conf.Net
+}
+
+// connectSCTP establishes an SCTP association, wrapping it in a TLS client
+// connection if needed. The framing and encoding of the data sent is the
+// same as for TCP.
+func (t *TcpOutput) connectSCTP() (err error) {
+	var laddr *net.TCPAddr
+	if t.localAddress != nil {
+		var ok bool
+		if laddr, ok = t.localAddress.(*net.TCPAddr); !ok {
+			return fmt.Errorf("local_address %s isn't usable with %s",
+				t.localAddress, t.conf.Net)
+		}
+	}
+	raddr, err := net.ResolveTCPAddr(t.addrNetwork(), t.address)
+	if err != nil {
+		return err
+	}
+	conn, err := dialSCTP(t.conf.Net, laddr, raddr)
+	if err != nil || !t.conf.UseTls {
+		t.connection = conn
+		return err
+	}
+	goTlsConf, err := CreateGoTlsConfig(&t.conf.Tls)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("TLS init error: %s", err)
+	}
+	if goTlsConf.ServerName == "" {
+		goTlsConf.ServerName, _, _ = net.SplitHostPort(t.address)
+	}
+	tlsConn := tls.Client(conn, goTlsConf)
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return err
+	}
+	t.connection = tlsConn
+	return nil
+}
+
+// Picks the address to connect to among the discovered instances' endpoints:
+// the current one unless connecting to it failed, in which case the next
+// one, or else the one the hostname maps to, so that the senders spread
+// over the instances.
+func (t *TcpOutput) pickAddress() error {
+	addrs, gen := t.discovery.Addresses(t.conf.DiscoveryRole, t.conf.DiscoveryEndpoint)
+	t.discoveryGen = gen
+	if len(addrs) == 0 {
+		return fmt.Errorf("no '%s' instances discovered", t.conf.DiscoveryRole)
+	}
+	for i, addr := range addrs {
+		if addr == t.address {
+			if t.connectErr != nil {
+				t.address = addrs[(i+1)%len(addrs)]
+			}
+			return nil
+		}
+	}
+	t.address = addrs[PreferredAddress(t.pConfig.Hostname(), addrs)]
+	return nil
+}
+
+// Drops the connection if the instance it goes to is no longer discovered.
+func (t *TcpOutput) checkDiscovered() {
+	addrs, gen := t.discovery.Addresses(t.conf.DiscoveryRole, t.conf.DiscoveryEndpoint)
+	t.discoveryGen = gen
+	for _, addr := range addrs {
+		if addr == t.address {
+			return
+		}
+	}
+	t.or.LogMessage(fmt.Sprintf("%s is no longer discovered, reconnecting", t.address))
+	t.cleanupConn()
+	t.address = ""
+}
+
 func (t *TcpOutput) connect() (err error) {
+	if t.discovery != nil {
+		if err = t.pickAddress(); err != nil {
+			return err
+		}
+		defer func() {
+			t.connectErr = err
+		}()
+	}
+
+	if isSCTP(t.conf.Net) {
+		return t.connectSCTP()
+	}
+
 	dialer := &net.Dialer{LocalAddr: t.localAddress}
 
 	if t.conf.UseTls {
@@ -190,9 +398,9 @@ func (t *TcpOutput) connect() (err error) {
 		// We should use DialWithDialer but its not in GOLANG release yet.
 		// https://code.google.com/p/go/source/detail?r=3d37606fb79393f22a69573afe31f0b0cd4866e3&name=default
 		// t.connection, err = tls.DialWithDialer(dialer, "tcp", t.address, goTlsConf)
-		t.connection, err = tls.Dial("tcp", t.address, goTlsConf)
+		t.connection, err = tls.Dial(t.conf.Net, t.address, goTlsConf)
 	} else {
-		t.connection, err = dialer.Dial("tcp", t.address)
+		t.connection, err = dialer.Dial(t.conf.Net, t.address)
 	}
 	if err == nil && t.conf.KeepAlive {
 		tcpConn, ok := t.connection.(*net.TCPConn)
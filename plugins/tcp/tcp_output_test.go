@@ -16,9 +16,16 @@
 package tcp
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"runtime"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -128,6 +135,12 @@ func TcpOutputSpec(c gs.Context) {
 			oth.MockOutputRunner.EXPECT().SetUseFraming(true)
 			err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
 			c.Assume(err, gs.IsNil)
+			// Connecting in Prepare warms the output up.
+			select {
+			case <-tcpOutput.Ready():
+			default:
+				c.Expect("", gs.Equals, "tcpOutput should be ready after connecting")
+			}
 
 			oth.MockOutputRunner.EXPECT().Encode(pack).Return(encoder.Encode(pack))
 			oth.MockOutputRunner.EXPECT().UpdateCursor(pack.QueueCursor)
@@ -148,6 +161,55 @@ func TcpOutputSpec(c gs.Context) {
 			tcpOutput.CleanUp()
 		})
 
+		c.Specify("compresses the data it writes", func() {
+			config.Compression = "gzip"
+			ln, err := net.Listen("tcp", "localhost:9125")
+			c.Assume(err, gs.IsNil)
+			defer ln.Close()
+			ch := make(chan string, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					ch <- err.Error()
+					return
+				}
+				defer conn.Close()
+				codec, _ := GetCompressionCodec("gzip")
+				r, err := codec.NewReader(conn)
+				if err != nil {
+					ch <- err.Error()
+					return
+				}
+				b := make([]byte, len(matchBytes))
+				if _, err = io.ReadFull(r, b); err != nil {
+					ch <- err.Error()
+					return
+				}
+				ch <- string(b)
+			}()
+
+			err = tcpOutput.Init(config)
+			c.Assume(err, gs.IsNil)
+			oth.MockOutputRunner.EXPECT().Encoder().Return(encoder)
+			oth.MockOutputRunner.EXPECT().SetUseFraming(true)
+			err = tcpOutput.Prepare(oth.MockOutputRunner, oth.MockHelper)
+			c.Assume(err, gs.IsNil)
+			oth.MockOutputRunner.EXPECT().Encode(pack).Return(encoder.Encode(pack))
+			oth.MockOutputRunner.EXPECT().UpdateCursor(pack.QueueCursor)
+			pack.Message.SetPayload(outStr)
+
+			err = tcpOutput.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			// The record is flushed, it arrives while the connection is open.
+			select {
+			case result := <-ch:
+				c.Expect(result, gs.Equals, string(matchBytes))
+			case <-time.After(5 * time.Second):
+				c.Expect("", gs.Equals, "compressed record wasn't flushed")
+			}
+			tcpOutput.CleanUp()
+		})
+
 		c.Specify("far end not initially listening", func() {
 			oth.MockOutputRunner.EXPECT().LogError(gomock.Any()).AnyTimes()
 
@@ -167,6 +229,11 @@ func TcpOutputSpec(c gs.Context) {
 			err = tcpOutput.ProcessMessage(pack)
 			_, ok := err.(RetryMessageError)
 			c.Expect(ok, gs.IsTrue)
+			select {
+			case <-tcpOutput.Ready():
+				c.Expect("", gs.Equals, "tcpOutput should NOT be ready yet")
+			default:
+			}
 			msgcount = atomic.LoadInt64(&tcpOutput.processMessageCount)
 			c.Expect(msgcount, gs.Equals, int64(0))
 
@@ -209,6 +276,46 @@ func TcpOutputSpec(c gs.Context) {
 			tcpOutput.CleanUp()
 		})
 
+		if runtime.GOOS == "linux" {
+			c.Specify("using SCTP", func() {
+				config.Net = "sctp4"
+				config.Address = "127.0.0.1:9126"
+				config.LocalAddress = "127.0.0.1:0"
+
+				c.Specify("dials from the local address", func() {
+					laddr, err := net.ResolveTCPAddr("tcp4", config.Address)
+					c.Assume(err, gs.IsNil)
+					ln, err := listenSCTP("sctp4", laddr)
+					if err != nil && strings.Contains(err.Error(), "protocol not supported") {
+						return // No SCTP support in this kernel.
+					}
+					c.Assume(err, gs.IsNil)
+					defer ln.Close()
+
+					err = tcpOutput.Init(config)
+					c.Assume(err, gs.IsNil)
+					err = tcpOutput.connect()
+					c.Expect(err, gs.IsNil)
+					conn, err := ln.Accept()
+					c.Assume(err, gs.IsNil)
+					c.Expect(conn.RemoteAddr().(*net.TCPAddr).IP.String(), gs.Equals,
+						"127.0.0.1")
+					conn.Close()
+					tcpOutput.CleanUp()
+				})
+
+				c.Specify("fails on a local address that isn't a TCP address", func() {
+					err := tcpOutput.Init(config)
+					c.Assume(err, gs.IsNil)
+					tcpOutput.localAddress = &net.UnixAddr{Name: "/tmp/heka.sock", Net: "unix"}
+					err = tcpOutput.connect()
+					c.Expect(err, gs.Not(gs.IsNil))
+					c.Expect(err.Error(), gs.Equals,
+						"local_address /tmp/heka.sock isn't usable with sctp4")
+				})
+			})
+		}
+
 		// c.Specify("Overload queue drops messages", func() {
 		// 	config.QueueFullAction = "drop"
 		// 	config.QueueMaxBufferSize = uint64(1)
@@ -290,3 +397,77 @@ func TcpOutputSpec(c gs.Context) {
 
 	})
 }
+
+func TcpOutputDiscoverySpec(c gs.Context) {
+	tmpDir, tmpErr := ioutil.TempDir("", "tcp-discovery-tests")
+	c.Assume(tmpErr, gs.IsNil)
+	defer os.RemoveAll(tmpDir)
+
+	// etcd directory holding two aggregators and an agent.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		req *http.Request) {
+
+		fmt.Fprint(w, `{"node": {"nodes": [
+			{"key": "a", "value": "{\"node\": \"agg1\", \"role\": \"aggregator\", \"endpoints\": {\"tcp\": \"10.0.0.1:5565\"}}"},
+			{"key": "b", "value": "{\"node\": \"agg2\", \"role\": \"aggregator\", \"endpoints\": {\"tcp\": \"10.0.0.2:5565\"}}"},
+			{"key": "c", "value": "{\"node\": \"agent1\", \"role\": \"agent\", \"endpoints\": {\"tcp\": \"10.0.0.3:5565\"}}"}
+		]}}`)
+	}))
+	defer ts.Close()
+
+	globals := DefaultGlobals()
+	globals.BaseDir = tmpDir
+	pConfig := NewPipelineConfig(globals)
+	discovery := new(ServiceDiscovery)
+	discoveryConfig := discovery.ConfigStruct().(*ServiceDiscoveryConfig)
+	discoveryConfig.Backend = "etcd"
+	discoveryConfig.Address = ts.URL
+	err := discovery.Init(discoveryConfig)
+	c.Assume(err, gs.IsNil)
+	defer discovery.Stop()
+
+	c.Specify("A TcpOutput using service discovery", func() {
+		tcpOutput := new(TcpOutput)
+		tcpOutput.SetName("test")
+		tcpOutput.SetPipelineConfig(pConfig)
+		config := tcpOutput.ConfigStruct().(*TcpOutputConfig)
+		err := tcpOutput.Init(config)
+		c.Assume(err, gs.IsNil)
+		tcpOutput.discovery = discovery
+
+		c.Specify("picks a discovered aggregator", func() {
+			err = tcpOutput.pickAddress()
+			c.Expect(err, gs.IsNil)
+			addrs := []string{"10.0.0.1:5565", "10.0.0.2:5565"}
+			c.Expect(tcpOutput.address, gs.Equals,
+				addrs[PreferredAddress(pConfig.Hostname(), addrs)])
+
+			c.Specify("sticking to it while connecting works", func() {
+				first := tcpOutput.address
+				c.Expect(tcpOutput.pickAddress(), gs.IsNil)
+				c.Expect(tcpOutput.address, gs.Equals, first)
+			})
+
+			c.Specify("moving on to the next one when connecting fails", func() {
+				first := tcpOutput.address
+				tcpOutput.connectErr = errors.New("connection refused")
+				c.Expect(tcpOutput.pickAddress(), gs.IsNil)
+				c.Expect(tcpOutput.address != first, gs.IsTrue)
+				c.Expect(tcpOutput.address != "10.0.0.3:5565", gs.IsTrue)
+			})
+		})
+
+		c.Specify("fails without discovered instances of the role", func() {
+			tcpOutput.conf.DiscoveryRole = "collector"
+			c.Expect(tcpOutput.pickAddress(), gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A TcpOutput rejects an unknown discovery resource", func() {
+		tcpOutput := new(TcpOutput)
+		tcpOutput.SetPipelineConfig(pConfig)
+		config := tcpOutput.ConfigStruct().(*TcpOutputConfig)
+		config.DiscoveryResource = "missing"
+		c.Expect(tcpOutput.Init(config), gs.Not(gs.IsNil))
+	})
+}
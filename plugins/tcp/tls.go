@@ -20,6 +20,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
 )
 
 var ciphers map[string]uint16 = map[string]uint16{
@@ -38,6 +41,27 @@ var ciphers map[string]uint16 = map[string]uint16{
 	"ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 }
 
+// The cipher suites allowed in FIPS mode, all using AES and SHA-1 or SHA-2.
+var fipsCiphers = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+}
+
+func isFipsCipher(cipher uint16) bool {
+	for _, c := range fipsCiphers {
+		if c == cipher {
+			return true
+		}
+	}
+	return false
+}
+
 var tlsVersions map[string]uint16 = map[string]uint16{
 	"SSL30": tls.VersionSSL30,
 	"TLS10": tls.VersionTLS10,
@@ -70,6 +94,37 @@ type TlsConfig struct {
 	RootCAs                string `toml:"root_cafile"`
 }
 
+// Shared TLS settings, defined once in the `[resources]` config section with
+// a type of "TlsConfig" and referenced by name from plugins' `tls_resource`
+// setting.
+type TlsResource struct {
+	TlsConfig
+}
+
+func (r *TlsResource) ConfigStruct() interface{} {
+	return new(TlsConfig)
+}
+
+func (r *TlsResource) Init(config interface{}) error {
+	r.TlsConfig = *config.(*TlsConfig)
+	// Fail early on unreadable certificates or invalid settings.
+	_, err := CreateGoTlsConfig(&r.TlsConfig)
+	return err
+}
+
+// Returns the settings of the named TlsConfig resource.
+func TlsResourceConfig(pConfig *PipelineConfig, name string) (*TlsConfig, error) {
+	resource, err := pConfig.Resource(name)
+	if err != nil {
+		return nil, err
+	}
+	tlsResource, ok := resource.(*TlsResource)
+	if !ok {
+		return nil, fmt.Errorf("resource '%s' isn't a TlsConfig resource", name)
+	}
+	return &tlsResource.TlsConfig, nil
+}
+
 func CreateGoTlsConfig(tomlConf *TlsConfig) (goConf *tls.Config, err error) {
 	goConf = new(tls.Config)
 
@@ -143,11 +198,39 @@ func CreateGoTlsConfig(tomlConf *TlsConfig) (goConf *tls.Config, err error) {
 		if cipher, ok = ciphers[cipherStr]; !ok {
 			return nil, fmt.Errorf("Invalid cipher string: %s", cipherStr)
 		}
+		if message.FipsMode() && !isFipsCipher(cipher) {
+			return nil, fmt.Errorf("Cipher not allowed in FIPS mode: %s", cipherStr)
+		}
 		goConf.CipherSuites = append(goConf.CipherSuites, cipher)
 	}
+
+	if message.FipsMode() {
+		if err = restrictToFips(goConf, tomlConf); err != nil {
+			return nil, err
+		}
+	}
 	return
 }
 
+// Limits a TLS config to TLS 1.2 and the FIPS-approved cipher suites.
+func restrictToFips(goConf *tls.Config, tomlConf *TlsConfig) error {
+	if goConf.MinVersion == 0 {
+		goConf.MinVersion = tls.VersionTLS12
+	}
+	if goConf.MinVersion < tls.VersionTLS12 {
+		return fmt.Errorf("MinVersion not allowed in FIPS mode: %s, must be TLS12",
+			tomlConf.MinVersion)
+	}
+	if goConf.MaxVersion != 0 && goConf.MaxVersion < tls.VersionTLS12 {
+		return fmt.Errorf("MaxVersion not allowed in FIPS mode: %s, must be TLS12",
+			tomlConf.MaxVersion)
+	}
+	if len(goConf.CipherSuites) == 0 {
+		goConf.CipherSuites = fipsCiphers
+	}
+	return nil
+}
+
 func certPoolFromFile(pemfile string) (*x509.CertPool, error) {
 	roots := x509.NewCertPool()
 	data, err := ioutil.ReadFile(pemfile)
@@ -159,3 +242,9 @@ func certPoolFromFile(pemfile string) (*x509.CertPool, error) {
 	}
 	return nil, fmt.Errorf("No PEM encoded certificates found in: %s\n", pemfile)
 }
+
+func init() {
+	RegisterResource("TlsConfig", func() interface{} {
+		return new(TlsResource)
+	})
+}
@@ -17,6 +17,8 @@ package tcp
 import (
 	"crypto/tls"
 	"encoding/hex"
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
 	gs "github.com/rafrombrc/gospec/src/gospec"
 	"strings"
 )
@@ -123,5 +125,55 @@ func TlsSpec(c gs.Context) {
 			c.Expect(len(goConf.RootCAs.Subjects()), gs.Equals, 1)
 		})
 
+		c.Specify("in FIPS mode", func() {
+			message.SetFipsMode(true)
+			defer message.SetFipsMode(message.FIPS_BUILD)
+
+			c.Specify("defaults to TLS 1.2 and approved ciphers", func() {
+				goConf, err = CreateGoTlsConfig(tomlConf)
+				c.Expect(err, gs.IsNil)
+				c.Expect(goConf.MinVersion, gs.Equals, uint16(tls.VersionTLS12))
+				c.Expect(len(goConf.CipherSuites), gs.Equals, len(fipsCiphers))
+			})
+
+			c.Specify("rejects older protocol versions", func() {
+				tomlConf.MinVersion = "TLS10"
+				goConf, err = CreateGoTlsConfig(tomlConf)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("rejects unapproved ciphers", func() {
+				tomlConf.Ciphers = []string{"RSA_WITH_AES_128_CBC_SHA", "RSA_WITH_RC4_128_SHA"}
+				goConf, err = CreateGoTlsConfig(tomlConf)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(err.Error(), gs.Equals,
+					"Cipher not allowed in FIPS mode: RSA_WITH_RC4_128_SHA")
+			})
+		})
+	})
+
+	c.Specify("A TlsResource", func() {
+		resource := new(TlsResource)
+		tomlConf = resource.ConfigStruct().(*TlsConfig)
+
+		c.Specify("keeps its settings", func() {
+			tomlConf.CertFile = "./testsupport/cert.pem"
+			tomlConf.KeyFile = "./testsupport/key.pem"
+			err = resource.Init(tomlConf)
+			c.Expect(err, gs.IsNil)
+			c.Expect(resource.CertFile, gs.Equals, tomlConf.CertFile)
+		})
+
+		c.Specify("fails w/ a missing certificate", func() {
+			tomlConf.CertFile = "./testsupport/missing.pem"
+			tomlConf.KeyFile = "./testsupport/key.pem"
+			err = resource.Init(tomlConf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("lookup fails for unknown resources", func() {
+			_, err = TlsResourceConfig(NewPipelineConfig(nil), "missing")
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
 	})
 }
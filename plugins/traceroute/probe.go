@@ -0,0 +1,93 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package traceroute
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+const (
+	icmpTimeExceeded    = 11
+	icmpDestUnreachable = 3
+)
+
+// Hop is the result of probing a single TTL. Addr is nil if nothing answered
+// before the hop timeout.
+type Hop struct {
+	TTL  int
+	Addr net.IP
+	Rtt  time.Duration
+}
+
+// TraceResult holds the outcome of a traceroute to a single destination.
+type TraceResult struct {
+	Destination net.IP
+	Hops        []Hop
+	// Whether the destination itself responded.
+	Reached bool
+	// Set if probing couldn't be performed at all.
+	Err error
+	// When the trace finished, used for cache expiry.
+	Time time.Time
+}
+
+// ProbeOptions control how a traceroute is performed.
+type ProbeOptions struct {
+	MaxHops  int
+	Timeout  time.Duration
+	BasePort int
+}
+
+// icmpReply is the information we need from an ICMP error message to match
+// it against the UDP probe that triggered it.
+type icmpReply struct {
+	Type    byte
+	Dst     net.IP
+	SrcPort int
+	DstPort int
+}
+
+// parseICMPReply decodes a raw ICMPv4 packet, including its IP header, as
+// received from a raw socket. Only time exceeded and destination unreachable
+// messages quoting a UDP datagram are of interest; anything else returns
+// false.
+func parseICMPReply(b []byte) (reply icmpReply, ok bool) {
+	if len(b) < 20 {
+		return reply, false
+	}
+	ihl := int(b[0]&0x0f) * 4
+	if len(b) < ihl+8 {
+		return reply, false
+	}
+	icmp := b[ihl:]
+	reply.Type = icmp[0]
+	if reply.Type != icmpTimeExceeded && reply.Type != icmpDestUnreachable {
+		return reply, false
+	}
+	// The ICMP payload quotes the IP header and first 8 bytes of the
+	// datagram that caused the error.
+	inner := icmp[8:]
+	if len(inner) < 20 {
+		return reply, false
+	}
+	innerIhl := int(inner[0]&0x0f) * 4
+	if len(inner) < innerIhl+8 || inner[9] != 17 { // 17 == UDP
+		return reply, false
+	}
+	reply.Dst = net.IPv4(inner[16], inner[17], inner[18], inner[19])
+	udp := inner[innerIhl:]
+	reply.SrcPort = int(binary.BigEndian.Uint16(udp[0:2]))
+	reply.DstPort = int(binary.BigEndian.Uint16(udp[2:4]))
+	return reply, true
+}
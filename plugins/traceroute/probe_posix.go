@@ -0,0 +1,118 @@
+// +build !windows
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package traceroute
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Trace performs a UDP traceroute to an IPv4 destination, sending one probe
+// per TTL and listening for the resulting ICMP errors on a raw socket. This
+// requires root or the CAP_NET_RAW capability.
+func Trace(dest net.IP, opts ProbeOptions) (result *TraceResult) {
+	result = &TraceResult{Destination: dest}
+	defer func() {
+		result.Time = time.Now()
+	}()
+
+	dest4 := dest.To4()
+	if dest4 == nil {
+		result.Err = errors.New("only IPv4 destinations are supported")
+		return
+	}
+
+	recvFd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		result.Err = os.NewSyscallError("socket", err)
+		return
+	}
+	defer syscall.Close(recvFd)
+
+	sendFd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_UDP)
+	if err != nil {
+		result.Err = os.NewSyscallError("socket", err)
+		return
+	}
+	defer syscall.Close(sendFd)
+
+	// Bind to an ephemeral port so replies can be matched to our probes.
+	if err = syscall.Bind(sendFd, &syscall.SockaddrInet4{}); err != nil {
+		result.Err = os.NewSyscallError("bind", err)
+		return
+	}
+	sa, err := syscall.Getsockname(sendFd)
+	if err != nil {
+		result.Err = os.NewSyscallError("getsockname", err)
+		return
+	}
+	srcPort := sa.(*syscall.SockaddrInet4).Port
+
+	// Short receive timeouts let us keep checking our own deadline while
+	// discarding ICMP traffic that isn't ours.
+	tv := syscall.NsecToTimeval((100 * time.Millisecond).Nanoseconds())
+	if err = syscall.SetsockoptTimeval(recvFd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO,
+		&tv); err != nil {
+		result.Err = os.NewSyscallError("setsockopt", err)
+		return
+	}
+
+	to := &syscall.SockaddrInet4{}
+	copy(to.Addr[:], dest4)
+	buf := make([]byte, 512)
+
+	for ttl := 1; ttl <= opts.MaxHops; ttl++ {
+		if err = syscall.SetsockoptInt(sendFd, syscall.IPPROTO_IP, syscall.IP_TTL,
+			ttl); err != nil {
+			result.Err = os.NewSyscallError("setsockopt", err)
+			return
+		}
+		dstPort := opts.BasePort + ttl
+		to.Port = dstPort
+		start := time.Now()
+		if err = syscall.Sendto(sendFd, []byte("heka"), 0, to); err != nil {
+			result.Err = os.NewSyscallError("sendto", err)
+			return
+		}
+
+		hop := Hop{TTL: ttl}
+		var replyType byte
+		for time.Since(start) < opts.Timeout {
+			n, _, err := syscall.Recvfrom(recvFd, buf, 0)
+			if err != nil {
+				continue // Receive timeout or interrupted, check the deadline.
+			}
+			reply, ok := parseICMPReply(buf[:n])
+			if !ok || !reply.Dst.Equal(dest4) || reply.SrcPort != srcPort ||
+				reply.DstPort != dstPort {
+				continue
+			}
+			// The responding router's address is the source of the outer
+			// IP header.
+			hop.Addr = net.IPv4(buf[12], buf[13], buf[14], buf[15])
+			hop.Rtt = time.Since(start)
+			replyType = reply.Type
+			break
+		}
+		result.Hops = append(result.Hops, hop)
+		if replyType == icmpDestUnreachable {
+			result.Reached = hop.Addr.Equal(dest4)
+			break
+		}
+	}
+	return
+}
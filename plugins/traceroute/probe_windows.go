@@ -0,0 +1,28 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package traceroute
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// Trace isn't implemented on Windows, which doesn't provide the raw socket
+// access needed to receive ICMP errors for UDP probes.
+func Trace(dest net.IP, opts ProbeOptions) *TraceResult {
+	return &TraceResult{
+		Destination: dest,
+		Err:         errors.New("traceroute isn't supported on Windows"),
+		Time:        time.Now(),
+	}
+}
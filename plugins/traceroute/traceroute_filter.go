@@ -0,0 +1,265 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package traceroute
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type TracerouteFilterConfig struct {
+	// Name of the message field holding the destination to probe, either a
+	// host name or IP address, optionally followed by a port. Defaults to
+	// "Destination".
+	DestinationField string `toml:"destination_field"`
+	// Maximum number of hops probed. Defaults to 30.
+	MaxHops uint `toml:"max_hops"`
+	// Milliseconds to wait for each hop to answer. Defaults to 1000.
+	HopTimeout uint `toml:"hop_timeout"`
+	// UDP probes are sent to this port plus the TTL. Defaults to 33434.
+	BasePort uint `toml:"base_port"`
+	// Upper limit on the number of traceroutes started per minute. Defaults
+	// to 10.
+	MaxTracesPerMinute uint `toml:"max_traces_per_minute"`
+	// Seconds a trace result is reused for further messages with the same
+	// destination. Defaults to 300.
+	CacheTtl uint `toml:"cache_ttl"`
+	// Number of messages that can be waiting for a trace. Messages arriving
+	// while the queue is full are dropped. Defaults to 100.
+	QueueSize uint `toml:"queue_size"`
+}
+
+type traceRequest struct {
+	dest         string
+	msg          *message.Message
+	msgLoopCount uint
+}
+
+// TracerouteFilter performs rate limited traceroutes to the destination of
+// each matched message and injects a copy of the message with the hop data
+// attached.
+type TracerouteFilter struct {
+	traceCount   int64
+	cachedCount  int64
+	droppedCount int64
+
+	conf    *TracerouteFilterConfig
+	opts    ProbeOptions
+	fr      pipeline.FilterRunner
+	h       pipeline.PluginHelper
+	queue   chan *traceRequest
+	wg      sync.WaitGroup
+	cache   map[string]*TraceResult
+	recent  []time.Time
+	trace   func(dest net.IP, opts ProbeOptions) *TraceResult
+	resolve func(host string) (net.IP, error)
+	timeNow func() time.Time
+}
+
+func (f *TracerouteFilter) ConfigStruct() interface{} {
+	return &TracerouteFilterConfig{
+		DestinationField:   "Destination",
+		MaxHops:            30,
+		HopTimeout:         1000,
+		BasePort:           33434,
+		MaxTracesPerMinute: 10,
+		CacheTtl:           300,
+		QueueSize:          100,
+	}
+}
+
+func (f *TracerouteFilter) Init(config interface{}) error {
+	f.conf = config.(*TracerouteFilterConfig)
+	if f.conf.DestinationField == "" {
+		return errors.New("'destination_field' must be specified")
+	}
+	if f.conf.MaxHops == 0 || f.conf.MaxHops > 255 {
+		return errors.New("'max_hops' must be between 1 and 255")
+	}
+	if f.conf.BasePort+f.conf.MaxHops > 65535 {
+		return errors.New("'base_port' plus 'max_hops' must be a valid port")
+	}
+	f.opts = ProbeOptions{
+		MaxHops:  int(f.conf.MaxHops),
+		Timeout:  time.Duration(f.conf.HopTimeout) * time.Millisecond,
+		BasePort: int(f.conf.BasePort),
+	}
+	f.cache = make(map[string]*TraceResult)
+	f.trace = Trace
+	f.resolve = resolveIPv4
+	f.timeNow = time.Now
+	return nil
+}
+
+func resolveIPv4(host string) (net.IP, error) {
+	addr, err := net.ResolveIPAddr("ip4", host)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+func (f *TracerouteFilter) Prepare(fr pipeline.FilterRunner, h pipeline.PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	f.queue = make(chan *traceRequest, f.conf.QueueSize)
+	f.wg.Add(1)
+	go f.worker()
+	return nil
+}
+
+func (f *TracerouteFilter) ProcessMessage(pack *pipeline.PipelinePack) error {
+	value, ok := pack.Message.GetFieldValue(f.conf.DestinationField)
+	dest, isString := value.(string)
+	if !ok || !isString || dest == "" {
+		return fmt.Errorf("message has no '%s' field", f.conf.DestinationField)
+	}
+	if host, _, err := net.SplitHostPort(dest); err == nil {
+		dest = host
+	}
+
+	req := &traceRequest{
+		dest:         dest,
+		msg:          message.CopyMessage(pack.Message),
+		msgLoopCount: pack.MsgLoopCount,
+	}
+	select {
+	case f.queue <- req:
+	default:
+		atomic.AddInt64(&f.droppedCount, 1)
+	}
+	return nil
+}
+
+// worker handles queued messages one at a time, so at most one traceroute
+// is ever in flight.
+func (f *TracerouteFilter) worker() {
+	defer f.wg.Done()
+	for req := range f.queue {
+		f.handleRequest(req)
+	}
+}
+
+func (f *TracerouteFilter) handleRequest(req *traceRequest) {
+	now := f.timeNow()
+	result, ok := f.cache[req.dest]
+	if ok && now.Sub(result.Time) < time.Duration(f.conf.CacheTtl)*time.Second {
+		atomic.AddInt64(&f.cachedCount, 1)
+	} else {
+		if !f.allowTrace(now) {
+			atomic.AddInt64(&f.droppedCount, 1)
+			return
+		}
+		ip, err := f.resolve(req.dest)
+		if err != nil {
+			result = &TraceResult{Err: err, Time: now}
+		} else {
+			result = f.trace(ip, f.opts)
+		}
+		atomic.AddInt64(&f.traceCount, 1)
+		if result.Err != nil {
+			f.fr.LogError(fmt.Errorf("tracing route to %s: %s", req.dest, result.Err))
+		}
+		f.cache[req.dest] = result
+		f.expireCache(now)
+	}
+	f.inject(req, result)
+}
+
+// allowTrace enforces the per minute trace limit.
+func (f *TracerouteFilter) allowTrace(now time.Time) bool {
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(f.recent) && !f.recent[i].After(cutoff) {
+		i++
+	}
+	f.recent = f.recent[i:]
+	if uint(len(f.recent)) >= f.conf.MaxTracesPerMinute {
+		return false
+	}
+	f.recent = append(f.recent, now)
+	return true
+}
+
+func (f *TracerouteFilter) expireCache(now time.Time) {
+	ttl := time.Duration(f.conf.CacheTtl) * time.Second
+	for dest, result := range f.cache {
+		if now.Sub(result.Time) >= ttl {
+			delete(f.cache, dest)
+		}
+	}
+}
+
+func (f *TracerouteFilter) inject(req *traceRequest, result *TraceResult) {
+	pack, err := f.h.PipelinePack(req.msgLoopCount)
+	if err != nil {
+		f.fr.LogError(err)
+		return
+	}
+	uuid := pack.Message.GetUuid()
+	req.msg.Copy(pack.Message)
+	pack.Message.SetUuid(uuid)
+	pack.Message.SetType("heka.traceroute")
+	addTraceFields(pack.Message, result)
+	f.fr.Inject(pack)
+}
+
+func addTraceFields(msg *message.Message, result *TraceResult) {
+	if result.Err != nil {
+		message.NewStringField(msg, "TraceError", result.Err.Error())
+		return
+	}
+	message.NewStringField(msg, "TraceDestination", result.Destination.String())
+	hops := message.NewFieldInit("TraceHops", message.Field_STRING, "")
+	rtts := message.NewFieldInit("TraceRtt", message.Field_DOUBLE, "ms")
+	for _, hop := range result.Hops {
+		if hop.Addr == nil {
+			hops.AddValue("*")
+			rtts.AddValue(float64(-1))
+			continue
+		}
+		hops.AddValue(hop.Addr.String())
+		rtts.AddValue(float64(hop.Rtt) / float64(time.Millisecond))
+	}
+	if len(result.Hops) > 0 {
+		msg.AddField(hops)
+		msg.AddField(rtts)
+	}
+	if field, err := message.NewField("TraceReached", result.Reached, ""); err == nil {
+		msg.AddField(field)
+	}
+}
+
+func (f *TracerouteFilter) CleanUp() {
+	close(f.queue)
+	f.wg.Wait()
+}
+
+func (f *TracerouteFilter) ReportMsg(msg *message.Message) error {
+	message.NewInt64Field(msg, "TraceCount", atomic.LoadInt64(&f.traceCount), "count")
+	message.NewInt64Field(msg, "CachedCount", atomic.LoadInt64(&f.cachedCount), "count")
+	message.NewInt64Field(msg, "DroppedCount", atomic.LoadInt64(&f.droppedCount), "count")
+	return nil
+}
+
+func init() {
+	pipeline.RegisterPlugin("TracerouteFilter", func() interface{} {
+		return new(TracerouteFilter)
+	})
+}
@@ -0,0 +1,188 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package traceroute
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+// icmpPacket builds a raw IPv4 ICMP error quoting a UDP datagram.
+func icmpPacket(icmpType byte, from, dst net.IP, srcPort, dstPort int) []byte {
+	b := make([]byte, 20+8+20+8)
+	b[0] = 0x45
+	copy(b[12:16], from.To4())
+	b[20] = icmpType
+	inner := b[28:]
+	inner[0] = 0x45
+	inner[9] = 17
+	copy(inner[16:20], dst.To4())
+	udp := inner[20:]
+	udp[0], udp[1] = byte(srcPort>>8), byte(srcPort)
+	udp[2], udp[3] = byte(dstPort>>8), byte(dstPort)
+	return b
+}
+
+func TracerouteFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("ICMP reply parsing", func() {
+		dst := net.ParseIP("192.0.2.10")
+
+		c.Specify("decodes time exceeded messages", func() {
+			b := icmpPacket(icmpTimeExceeded, net.ParseIP("10.0.0.1"), dst, 40000, 33435)
+			reply, ok := parseICMPReply(b)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(reply.Type, gs.Equals, byte(icmpTimeExceeded))
+			c.Expect(reply.Dst.Equal(dst), gs.IsTrue)
+			c.Expect(reply.SrcPort, gs.Equals, 40000)
+			c.Expect(reply.DstPort, gs.Equals, 33435)
+		})
+
+		c.Specify("ignores other ICMP messages", func() {
+			b := icmpPacket(0, net.ParseIP("10.0.0.1"), dst, 40000, 33435)
+			_, ok := parseICMPReply(b)
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("ignores truncated packets", func() {
+			b := icmpPacket(icmpTimeExceeded, net.ParseIP("10.0.0.1"), dst, 40000, 33435)
+			_, ok := parseICMPReply(b[:40])
+			c.Expect(ok, gs.IsFalse)
+		})
+	})
+
+	c.Specify("A TracerouteFilter", func() {
+		filter := new(TracerouteFilter)
+		config := filter.ConfigStruct().(*TracerouteFilterConfig)
+		config.MaxTracesPerMinute = 2
+		err := filter.Init(config)
+		c.Assume(err, gs.IsNil)
+
+		now := time.Unix(1450000000, 0)
+		filter.timeNow = func() time.Time { return now }
+		filter.resolve = func(host string) (net.IP, error) {
+			if host == "unknown" {
+				return nil, errors.New("no such host")
+			}
+			return net.ParseIP("192.0.2.10"), nil
+		}
+		traced := 0
+		filter.trace = func(dest net.IP, opts ProbeOptions) *TraceResult {
+			traced++
+			return &TraceResult{
+				Destination: dest,
+				Hops: []Hop{
+					{TTL: 1, Addr: net.ParseIP("10.0.0.1"), Rtt: 2 * time.Millisecond},
+					{TTL: 2},
+					{TTL: 3, Addr: dest, Rtt: 10 * time.Millisecond},
+				},
+				Reached: true,
+				Time:    now,
+			}
+		}
+
+		fr := pipelinemock.NewMockFilterRunner(ctrl)
+		h := pipelinemock.NewMockPluginHelper(ctrl)
+		filter.fr = fr
+		filter.h = h
+
+		var injected []*message.Message
+		recycleChan := make(chan *PipelinePack, 10)
+		h.EXPECT().PipelinePack(gomock.Any()).AnyTimes().Return(NewPipelinePack(recycleChan), nil)
+		fr.EXPECT().Inject(gomock.Any()).AnyTimes().Do(func(pack *PipelinePack) {
+			injected = append(injected, message.CopyMessage(pack.Message))
+		}).Return(true)
+
+		newRequest := func(dest string) *traceRequest {
+			msg := pipeline_ts.GetTestMessage()
+			msg.SetType("connect.error")
+			return &traceRequest{dest: dest, msg: msg}
+		}
+
+		c.Specify("attaches hop data to a copy of the message", func() {
+			filter.handleRequest(newRequest("example.com"))
+			c.Expect(len(injected), gs.Equals, 1)
+			msg := injected[0]
+			c.Expect(msg.GetType(), gs.Equals, "heka.traceroute")
+			hops := msg.FindFirstField("TraceHops")
+			c.Assume(hops, gs.Not(gs.IsNil))
+			c.Expect(len(hops.GetValueString()), gs.Equals, 3)
+			c.Expect(hops.GetValueString()[1], gs.Equals, "*")
+			rtts := msg.FindFirstField("TraceRtt")
+			c.Expect(rtts.GetValueDouble()[0], gs.Equals, float64(2))
+			c.Expect(rtts.GetValueDouble()[1], gs.Equals, float64(-1))
+			reached, _ := msg.GetFieldValue("TraceReached")
+			c.Expect(reached, gs.Equals, true)
+		})
+
+		c.Specify("reuses cached results", func() {
+			filter.handleRequest(newRequest("example.com"))
+			filter.handleRequest(newRequest("example.com"))
+			c.Expect(traced, gs.Equals, 1)
+			c.Expect(len(injected), gs.Equals, 2)
+			c.Expect(filter.cachedCount, gs.Equals, int64(1))
+
+			now = now.Add(time.Duration(config.CacheTtl) * time.Second)
+			filter.handleRequest(newRequest("example.com"))
+			c.Expect(traced, gs.Equals, 2)
+		})
+
+		c.Specify("limits the number of traces per minute", func() {
+			filter.handleRequest(newRequest("a.example.com"))
+			filter.handleRequest(newRequest("b.example.com"))
+			filter.handleRequest(newRequest("c.example.com"))
+			c.Expect(traced, gs.Equals, 2)
+			c.Expect(len(injected), gs.Equals, 2)
+			c.Expect(filter.droppedCount, gs.Equals, int64(1))
+
+			now = now.Add(time.Minute)
+			filter.handleRequest(newRequest("c.example.com"))
+			c.Expect(traced, gs.Equals, 3)
+		})
+
+		c.Specify("reports destinations that can't be resolved", func() {
+			fr.EXPECT().LogError(gomock.Any())
+			filter.handleRequest(newRequest("unknown"))
+			c.Expect(len(injected), gs.Equals, 1)
+			traceErr, _ := injected[0].GetFieldValue("TraceError")
+			c.Expect(traceErr, gs.Equals, "no such host")
+		})
+
+		c.Specify("strips ports from the destination field", func() {
+			filter.queue = make(chan *traceRequest, 1)
+			pack := NewPipelinePack(recycleChan)
+			message.NewStringField(pack.Message, "Destination", "example.com:443")
+			err := filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			req := <-filter.queue
+			c.Expect(req.dest, gs.Equals, "example.com")
+		})
+
+		c.Specify("rejects messages without a destination", func() {
+			filter.queue = make(chan *traceRequest, 1)
+			pack := NewPipelinePack(recycleChan)
+			err := filter.ProcessMessage(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+}
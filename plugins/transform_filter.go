@@ -0,0 +1,298 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// A single TransformFilter operation.
+type TransformOperation struct {
+	// One of "rename", "copy", "remove", "set", "replace" and
+	// "regex_replace".
+	Op string
+	// Message variable the operation applies to, a header ("Type",
+	// "Logger", "Hostname", "Payload", "EnvVersion", "Severity" or "Pid") or
+	// "Fields[name]".
+	Field string
+	// Message variable "rename" and "copy" write to.
+	To string
+	// Value "set" writes.
+	Value string
+	// Substring replaced by "replace", or regular expression replaced by
+	// "regex_replace".
+	Pattern string
+	// Replacement for Pattern. May refer to regex_replace capture groups as
+	// $1, ${name} and so on.
+	Replacement string
+}
+
+type TransformFilterConfig struct {
+	// Operations applied to each message, in order.
+	Operation []TransformOperation
+	// Appended to the Type of the injected messages, before the operations
+	// are applied. Defaults to ".transformed".
+	TypeSuffix string `toml:"type_suffix"`
+}
+
+// Message variable an operation reads or writes. Exactly one of header and
+// field is set.
+type transformVar struct {
+	header string
+	field  string
+}
+
+func newTransformVar(name string) (v transformVar, err error) {
+	switch name {
+	case "Type", "Logger", "Hostname", "Payload", "EnvVersion", "Severity",
+		"Pid":
+		v.header = name
+		return
+	}
+	matches := keyFieldRegex.FindStringSubmatch(name)
+	if matches == nil {
+		return v, fmt.Errorf("unknown message variable '%s'", name)
+	}
+	v.field = matches[1]
+	return
+}
+
+// Returns the variable's value as a string, ok is false if the message doesn't
+// have the field.
+func (v transformVar) get(msg *message.Message) (value string, ok bool) {
+	if v.field != "" {
+		field := msg.FindFirstField(v.field)
+		if field == nil {
+			return "", false
+		}
+		return fieldString(field), true
+	}
+	switch v.header {
+	case "Type":
+		value = msg.GetType()
+	case "Logger":
+		value = msg.GetLogger()
+	case "Hostname":
+		value = msg.GetHostname()
+	case "Payload":
+		value = msg.GetPayload()
+	case "EnvVersion":
+		value = msg.GetEnvVersion()
+	case "Severity":
+		value = strconv.Itoa(int(msg.GetSeverity()))
+	case "Pid":
+		value = strconv.Itoa(int(msg.GetPid()))
+	}
+	return value, true
+}
+
+// Sets the variable to a string value, replacing any fields of the same name.
+func (v transformVar) set(msg *message.Message, value string) error {
+	if v.field != "" {
+		v.remove(msg)
+		message.NewStringField(msg, v.field, value)
+		return nil
+	}
+	switch v.header {
+	case "Type":
+		msg.SetType(value)
+	case "Logger":
+		msg.SetLogger(value)
+	case "Hostname":
+		msg.SetHostname(value)
+	case "Payload":
+		msg.SetPayload(value)
+	case "EnvVersion":
+		msg.SetEnvVersion(value)
+	case "Severity", "Pid":
+		i, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s'", v.header, value)
+		}
+		if v.header == "Severity" {
+			msg.SetSeverity(int32(i))
+		} else {
+			msg.SetPid(int32(i))
+		}
+	}
+	return nil
+}
+
+// Removes all fields named by the variable.
+func (v transformVar) remove(msg *message.Message) {
+	for _, field := range msg.FindAllFields(v.field) {
+		msg.DeleteField(field)
+	}
+}
+
+type transformOp struct {
+	conf  TransformOperation
+	from  transformVar
+	to    transformVar
+	regex *regexp.Regexp
+}
+
+// Copies the from variable to the to variable. Field to field copies keep the
+// field's type, representation and values.
+func (op *transformOp) copy(msg *message.Message) error {
+	if op.from.field != "" && op.to.field != "" {
+		fields := msg.FindAllFields(op.from.field)
+		op.to.remove(msg)
+		for _, field := range fields {
+			copied := message.CopyField(field)
+			name := op.to.field
+			copied.Name = &name
+			msg.AddField(copied)
+		}
+		return nil
+	}
+	value, ok := op.from.get(msg)
+	if !ok {
+		return nil
+	}
+	return op.to.set(msg, value)
+}
+
+func (op *transformOp) apply(msg *message.Message) error {
+	switch op.conf.Op {
+	case "rename":
+		if msg.FindFirstField(op.from.field) == nil {
+			return nil
+		}
+		if err := op.copy(msg); err != nil {
+			return err
+		}
+		op.from.remove(msg)
+	case "copy":
+		return op.copy(msg)
+	case "remove":
+		op.from.remove(msg)
+	case "set":
+		return op.from.set(msg, op.conf.Value)
+	case "replace", "regex_replace":
+		value, ok := op.from.get(msg)
+		if !ok {
+			return nil
+		}
+		if op.regex != nil {
+			value = op.regex.ReplaceAllString(value, op.conf.Replacement)
+		} else {
+			value = strings.Replace(value, op.conf.Pattern, op.conf.Replacement, -1)
+		}
+		return op.from.set(msg, value)
+	}
+	return nil
+}
+
+// TransformFilter injects copies of the messages it receives with an ordered
+// list of operations applied to their headers and fields.
+type TransformFilter struct {
+	conf *TransformFilterConfig
+	ops  []*transformOp
+	fr   FilterRunner
+	h    PluginHelper
+}
+
+func (f *TransformFilter) ConfigStruct() interface{} {
+	return &TransformFilterConfig{
+		TypeSuffix: ".transformed",
+	}
+}
+
+func (f *TransformFilter) Init(config interface{}) (err error) {
+	f.conf = config.(*TransformFilterConfig)
+	if len(f.conf.Operation) == 0 {
+		return errors.New("at least one 'operation' must be specified")
+	}
+	if f.conf.TypeSuffix == "" {
+		return errors.New("'type_suffix' must not be empty")
+	}
+	f.ops = make([]*transformOp, len(f.conf.Operation))
+	for i, conf := range f.conf.Operation {
+		if f.ops[i], err = newTransformOp(conf); err != nil {
+			return fmt.Errorf("operation %d (%s): %s", i+1, conf.Op, err)
+		}
+	}
+	return nil
+}
+
+func newTransformOp(conf TransformOperation) (op *transformOp, err error) {
+	op = &transformOp{conf: conf}
+	if op.from, err = newTransformVar(conf.Field); err != nil {
+		return nil, err
+	}
+	switch conf.Op {
+	case "rename", "copy":
+		if op.to, err = newTransformVar(conf.To); err != nil {
+			return nil, fmt.Errorf("to: %s", err)
+		}
+		if conf.Op == "rename" && op.from.field == "" {
+			return nil, errors.New("only fields can be renamed")
+		}
+	case "remove":
+		if op.from.field == "" {
+			return nil, errors.New("only fields can be removed")
+		}
+	case "set":
+	case "replace":
+		if conf.Pattern == "" {
+			return nil, errors.New("'pattern' must be specified")
+		}
+	case "regex_replace":
+		if op.regex, err = regexp.Compile(conf.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern: %s", err)
+		}
+	default:
+		return nil, errors.New("unknown op")
+	}
+	return op, nil
+}
+
+func (f *TransformFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+func (f *TransformFilter) ProcessMessage(pack *PipelinePack) error {
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	for i, op := range f.ops {
+		if err = op.apply(newPack.Message); err != nil {
+			newPack.Recycle(nil)
+			return fmt.Errorf("operation %d (%s): %s", i+1, op.conf.Op, err)
+		}
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *TransformFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("TransformFilter", func() interface{} {
+		return new(TransformFilter)
+	})
+}
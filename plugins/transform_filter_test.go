@@ -0,0 +1,111 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func TransformFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A TransformFilter", func() {
+		filter := new(TransformFilter)
+		config := filter.ConfigStruct().(*TransformFilterConfig)
+
+		c.Specify("requires operations", func() {
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects invalid operations", func() {
+			config.Operation = []TransformOperation{{Op: "rename", Field: "Type", To: "Fields[type]"}}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+			config.Operation = []TransformOperation{{Op: "truncate", Field: "Payload"}}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+			config.Operation = []TransformOperation{{Op: "regex_replace", Field: "Payload",
+				Pattern: "("}}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("applies operations in order", func() {
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			recycleChan := make(chan *PipelinePack, 10)
+			var injected []*message.Message
+			fr.EXPECT().Inject(gomock.Any()).Do(func(p *PipelinePack) {
+				injected = append(injected, p.Message)
+				p.Message = new(message.Message)
+			}).Return(true).AnyTimes()
+			h.EXPECT().PipelinePack(gomock.Any()).Return(
+				NewPipelinePack(recycleChan), nil).AnyTimes()
+
+			config.Operation = []TransformOperation{
+				{Op: "rename", Field: "Fields[lvl]", To: "Fields[level]"},
+				{Op: "copy", Field: "Fields[level]", To: "Severity"},
+				{Op: "copy", Field: "Fields[app]", To: "Logger"},
+				{Op: "remove", Field: "Fields[secret]"},
+				{Op: "set", Field: "Fields[env]", Value: "prod"},
+				{Op: "replace", Field: "Hostname", Pattern: ".example.com"},
+				{Op: "regex_replace", Field: "Payload", Pattern: `user=(\w+)`,
+					Replacement: "user=<$1>"},
+			}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+			filter.Prepare(fr, h)
+
+			pack := NewPipelinePack(recycleChan)
+			msg := pack.Message
+			msg.SetType("app.log")
+			msg.SetHostname("web1.example.com")
+			msg.SetPayload("login user=alice")
+			message.NewInt64Field(msg, "lvl", 3, "")
+			message.NewStringField(msg, "app", "checkout")
+			message.NewStringField(msg, "secret", "hunter2")
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+
+			c.Assume(len(injected), gs.Equals, 1)
+			out := injected[0]
+			c.Expect(out.GetType(), gs.Equals, "app.log.transformed")
+			c.Expect(out.FindFirstField("lvl"), gs.IsNil)
+			level, _ := out.GetFieldValue("level")
+			c.Expect(level, gs.Equals, int64(3))
+			c.Expect(out.GetSeverity(), gs.Equals, int32(3))
+			c.Expect(out.GetLogger(), gs.Equals, "checkout")
+			c.Expect(out.FindFirstField("secret"), gs.IsNil)
+			env, _ := out.GetFieldValue("env")
+			c.Expect(env, gs.Equals, "prod")
+			c.Expect(out.GetHostname(), gs.Equals, "web1")
+			c.Expect(out.GetPayload(), gs.Equals, "login user=<alice>")
+			// The original message is left alone.
+			c.Expect(msg.GetType(), gs.Equals, "app.log")
+			c.Expect(msg.FindFirstField("secret"), gs.Not(gs.IsNil))
+
+			c.Specify("and reports values that can't be set", func() {
+				injected = nil
+				pack.Message.SetType("app.log")
+				pack.Message.DeleteField(pack.Message.FindFirstField("lvl"))
+				message.NewStringField(pack.Message, "lvl", "warning")
+				err = filter.ProcessMessage(pack)
+				c.Expect(err, gs.Not(gs.IsNil))
+				c.Expect(len(injected), gs.Equals, 0)
+			})
+		})
+	})
+}
@@ -23,6 +23,7 @@ func TestAllSpecs(t *testing.T) {
 	r := gs.NewRunner()
 	r.Parallel = false
 
+	r.AddSpec(ChunkReaderSpec)
 	r.AddSpec(UdpInputSpec)
 	r.AddSpec(UdpInputSpecFailure)
 	r.AddSpec(UdpOutputSpec)
@@ -0,0 +1,206 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package udp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// First byte of every chunk datagram. Framed Heka records start with
+	// RECORD_SEPARATOR (0x1e) so the ASCII group separator can't be confused
+	// with an unchunked record.
+	CHUNK_MARKER = 0x1d
+	// Marker, 8 byte message id, 2 byte chunk index and 2 byte chunk count.
+	CHUNK_HEADER_SIZE = 13
+	// Largest datagram we'll ever read.
+	MAX_DATAGRAM_SIZE = 65536
+)
+
+// Splits a record that doesn't fit in a single datagram of `maxSize` bytes
+// into chunks, each prefixed with a header identifying the message and the
+// chunk's position in it.
+func makeChunks(id uint64, record []byte, maxSize int) ([][]byte, error) {
+	dataSize := maxSize - CHUNK_HEADER_SIZE
+	count := (len(record) + dataSize - 1) / dataSize
+	if count > 0xffff {
+		return nil, fmt.Errorf("record needs %d chunks, the limit is %d", count,
+			0xffff)
+	}
+	chunks := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(record) {
+			end = len(record)
+		}
+		data := record[i*dataSize : end]
+		chunk := make([]byte, CHUNK_HEADER_SIZE+len(data))
+		chunk[0] = CHUNK_MARKER
+		binary.BigEndian.PutUint64(chunk[1:9], id)
+		binary.BigEndian.PutUint16(chunk[9:11], uint16(i))
+		binary.BigEndian.PutUint16(chunk[11:13], uint16(count))
+		copy(chunk[CHUNK_HEADER_SIZE:], data)
+		chunks[i] = chunk
+	}
+	return chunks, nil
+}
+
+// A partially received chunked message.
+type chunkedMessage struct {
+	chunks   [][]byte
+	received int
+	size     int
+	started  time.Time
+}
+
+// io.Reader that reads datagrams from a packet connection, passing unchunked
+// datagrams through untouched and reassembling chunked ones, so the
+// splitter only ever sees complete records.
+type chunkReader struct {
+	conn       net.PacketConn
+	buf        []byte
+	pending    []byte
+	messages   map[string]*chunkedMessage
+	timeout    time.Duration
+	maxPending int
+	lastPurge  time.Time
+	logError   func(error)
+	// Address of the sender of the data most recently returned by Read.
+	remoteAddr string
+}
+
+func newChunkReader(conn net.PacketConn, timeout time.Duration, maxPending int,
+	logError func(error)) *chunkReader {
+
+	return &chunkReader{
+		conn:       conn,
+		buf:        make([]byte, MAX_DATAGRAM_SIZE),
+		messages:   make(map[string]*chunkedMessage),
+		timeout:    timeout,
+		maxPending: maxPending,
+		logError:   logError,
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (n int, err error) {
+	if len(r.pending) == 0 {
+		if r.pending, err = r.nextRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Returns the next unchunked datagram or reassembled record.
+func (r *chunkReader) nextRecord() ([]byte, error) {
+	for {
+		size, addr, err := r.conn.ReadFrom(r.buf)
+		if err != nil {
+			return nil, err
+		}
+		sender := ""
+		if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr != nil {
+			sender = udpAddr.IP.String()
+		} else if addr != nil {
+			sender = addr.String()
+		}
+		datagram := r.buf[:size]
+		if size == 0 || datagram[0] != CHUNK_MARKER {
+			r.remoteAddr = sender
+			return datagram, nil
+		}
+		record, err := r.addChunk(addr, datagram)
+		if err != nil {
+			r.logError(err)
+			continue
+		}
+		if record != nil {
+			r.remoteAddr = sender
+			return record, nil
+		}
+	}
+}
+
+// Stores a chunk, returning the reassembled record once every chunk of its
+// message has arrived.
+func (r *chunkReader) addChunk(addr net.Addr, chunk []byte) ([]byte, error) {
+	if len(chunk) < CHUNK_HEADER_SIZE {
+		return nil, errors.New("chunk shorter than the chunk header")
+	}
+	id := binary.BigEndian.Uint64(chunk[1:9])
+	index := int(binary.BigEndian.Uint16(chunk[9:11]))
+	count := int(binary.BigEndian.Uint16(chunk[11:13]))
+	if count == 0 || index >= count {
+		return nil, fmt.Errorf("invalid chunk %d of %d", index, count)
+	}
+
+	now := time.Now()
+	if now.Sub(r.lastPurge) >= time.Second {
+		r.purge(now)
+	}
+
+	key := fmt.Sprintf("%s/%d", addr, id)
+	msg, ok := r.messages[key]
+	if !ok {
+		if len(r.messages) >= r.maxPending {
+			r.purge(now)
+			if len(r.messages) >= r.maxPending {
+				return nil, fmt.Errorf("dropping chunked message, %d already pending",
+					len(r.messages))
+			}
+		}
+		msg = &chunkedMessage{chunks: make([][]byte, count), started: now}
+		r.messages[key] = msg
+	}
+	if len(msg.chunks) != count {
+		delete(r.messages, key)
+		return nil, fmt.Errorf("chunk count mismatch for message %d: %d != %d",
+			id, count, len(msg.chunks))
+	}
+	if msg.chunks[index] != nil {
+		// Duplicate datagram.
+		return nil, nil
+	}
+	data := make([]byte, len(chunk)-CHUNK_HEADER_SIZE)
+	copy(data, chunk[CHUNK_HEADER_SIZE:])
+	msg.chunks[index] = data
+	msg.received++
+	msg.size += len(data)
+	if msg.received < count {
+		return nil, nil
+	}
+
+	delete(r.messages, key)
+	record := make([]byte, 0, msg.size)
+	for _, data := range msg.chunks {
+		record = append(record, data...)
+	}
+	return record, nil
+}
+
+// Drops messages whose chunks haven't all arrived within the timeout.
+func (r *chunkReader) purge(now time.Time) {
+	r.lastPurge = now
+	for key, msg := range r.messages {
+		if now.Sub(msg.started) > r.timeout {
+			delete(r.messages, key)
+			r.logError(fmt.Errorf("incomplete chunked message expired, "+
+				"received %d of %d chunks", msg.received, len(msg.chunks)))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package udp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+type fakeDatagram struct {
+	data []byte
+	addr net.Addr
+}
+
+// PacketConn that hands out queued datagrams and then reports EOF.
+type fakePacketConn struct {
+	datagrams []fakeDatagram
+}
+
+func (f *fakePacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(f.datagrams) == 0 {
+		return 0, nil, io.EOF
+	}
+	d := f.datagrams[0]
+	f.datagrams = f.datagrams[1:]
+	return copy(b, d.data), d.addr, nil
+}
+
+func (f *fakePacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return 0, errors.New("not supported")
+}
+func (f *fakePacketConn) Close() error                       { return nil }
+func (f *fakePacketConn) LocalAddr() net.Addr                { return nil }
+func (f *fakePacketConn) SetDeadline(t time.Time) error      { return nil }
+func (f *fakePacketConn) SetReadDeadline(t time.Time) error  { return nil }
+func (f *fakePacketConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func ChunkReaderSpec(c gs.Context) {
+	sender := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5565}
+	other := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5565}
+	record := bytes.Repeat([]byte("0123456789"), 200)
+	conn := new(fakePacketConn)
+	var errs []error
+	logError := func(err error) {
+		errs = append(errs, err)
+	}
+	reader := newChunkReader(conn, time.Second, 10, logError)
+
+	queue := func(addr net.Addr, datagrams ...[]byte) {
+		for _, d := range datagrams {
+			conn.datagrams = append(conn.datagrams, fakeDatagram{d, addr})
+		}
+	}
+
+	readAll := func() []byte {
+		var out bytes.Buffer
+		buf := make([]byte, 300)
+		for {
+			n, err := reader.Read(buf)
+			out.Write(buf[:n])
+			if err != nil {
+				return out.Bytes()
+			}
+		}
+	}
+
+	c.Specify("makeChunks", func() {
+		chunks, err := makeChunks(7, record, 512)
+		c.Expect(err, gs.IsNil)
+
+		c.Specify("keeps every chunk within the datagram size", func() {
+			c.Expect(len(chunks), gs.Equals, 5)
+			for _, chunk := range chunks {
+				c.Expect(len(chunk) <= 512, gs.IsTrue)
+				c.Expect(chunk[0], gs.Equals, byte(CHUNK_MARKER))
+			}
+		})
+
+		c.Specify("rejects records needing too many chunks", func() {
+			_, err := makeChunks(7, make([]byte, 0x10000*2), CHUNK_HEADER_SIZE+1)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("A chunkReader", func() {
+		chunks, _ := makeChunks(7, record, 512)
+
+		c.Specify("passes unchunked datagrams through", func() {
+			queue(sender, []byte("plain"))
+			c.Expect(string(readAll()), gs.Equals, "plain")
+			c.Expect(reader.remoteAddr, gs.Equals, "10.0.0.1")
+		})
+
+		c.Specify("reassembles out of order chunks", func() {
+			queue(sender, chunks[4], chunks[1], chunks[0], chunks[3], chunks[2])
+			c.Expect(bytes.Equal(readAll(), record), gs.IsTrue)
+			c.Expect(len(reader.messages), gs.Equals, 0)
+			c.Expect(len(errs), gs.Equals, 0)
+		})
+
+		c.Specify("ignores duplicate chunks", func() {
+			queue(sender, chunks[0], chunks[0], chunks[1], chunks[2], chunks[3],
+				chunks[3], chunks[4])
+			c.Expect(bytes.Equal(readAll(), record), gs.IsTrue)
+		})
+
+		c.Specify("keeps messages from different senders apart", func() {
+			queue(sender, chunks[0], chunks[1])
+			queue(other, chunks[0], chunks[1], chunks[2], chunks[3], chunks[4])
+			c.Expect(bytes.Equal(readAll(), record), gs.IsTrue)
+			c.Expect(reader.remoteAddr, gs.Equals, "10.0.0.2")
+			c.Expect(len(reader.messages), gs.Equals, 1)
+		})
+
+		c.Specify("expires incomplete messages", func() {
+			queue(sender, chunks[0])
+			readAll()
+			reader.purge(time.Now().Add(2 * time.Second))
+			c.Expect(len(reader.messages), gs.Equals, 0)
+			c.Expect(len(errs), gs.Equals, 1)
+		})
+
+		c.Specify("limits the pending messages", func() {
+			reader.maxPending = 1
+			second, _ := makeChunks(8, record, 512)
+			queue(sender, chunks[0], second[0])
+			readAll()
+			c.Expect(len(reader.messages), gs.Equals, 1)
+			c.Expect(len(errs), gs.Equals, 1)
+		})
+
+		c.Specify("drops malformed chunks", func() {
+			queue(sender, []byte{CHUNK_MARKER, 1, 2})
+			readAll()
+			c.Expect(len(errs), gs.Equals, 1)
+		})
+	})
+}
@@ -0,0 +1,64 @@
+// +build !windows
+
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package udp
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// joinGroup adds an additional multicast group membership to an already
+// listening socket. The net package only supports joining a single group per
+// socket, so we need to drop down to setsockopt for any others.
+func joinGroup(conn *net.UDPConn, ifi *net.Interface, group net.IP) error {
+	f, err := conn.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	// The duplicated descriptor shares its flags with the original, and
+	// retrieving it switches the socket into blocking mode. Switch it back
+	// or closing the listener won't interrupt a pending read.
+	defer syscall.SetNonblock(fd, true)
+
+	if ip4 := group.To4(); ip4 != nil {
+		mreq := &syscall.IPMreq{}
+		copy(mreq.Multiaddr[:], ip4)
+		if ifi != nil {
+			addrs, err := ifi.Addrs()
+			if err != nil {
+				return err
+			}
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+					copy(mreq.Interface[:], ipNet.IP.To4())
+					break
+				}
+			}
+		}
+		err = syscall.SetsockoptIPMreq(fd, syscall.IPPROTO_IP,
+			syscall.IP_ADD_MEMBERSHIP, mreq)
+	} else {
+		mreq := &syscall.IPv6Mreq{}
+		copy(mreq.Multiaddr[:], group)
+		if ifi != nil {
+			mreq.Interface = uint32(ifi.Index)
+		}
+		err = syscall.SetsockoptIPv6Mreq(fd, syscall.IPPROTO_IPV6,
+			syscall.IPV6_JOIN_GROUP, mreq)
+	}
+	return os.NewSyscallError("setsockopt", err)
+}
@@ -0,0 +1,21 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package udp
+
+import (
+	"errors"
+	"net"
+)
+
+func joinGroup(conn *net.UDPConn, ifi *net.Interface, group net.IP) error {
+	return errors.New("joining more than one multicast group is not supported on Windows")
+}
@@ -15,27 +15,33 @@
 package udp
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	. "github.com/mozilla-services/heka/message"
 	. "github.com/mozilla-services/heka/pipeline"
 )
 
 // Input plugin implementation that listens for Heka protocol messages on a
-// specified UDP socket.
+// specified UDP socket, or on several sockets sharing the address, each read
+// by its own goroutine.
 type UdpInput struct {
-	listener    net.Conn
-	reader      UdpInputReader
-	name        string
-	stopChan    chan struct{}
-	config      *UdpInputConfig
-	remote_addr string
+	listeners []net.Conn
+	name      string
+	stopChan  chan struct{}
+	config    *UdpInputConfig
+	// Decompresses the records read, if compression is enabled.
+	codec CompressionCodec
 }
 
 // ConfigStruct for NetworkInput plugins.
@@ -44,27 +50,71 @@ type UdpInputConfig struct {
 	// input type.
 	Net string
 	// String representation of the address of the network connection on which
-	// the listener should be listening (e.g. "127.0.0.1:5565").
+	// the listener should be listening (e.g. "127.0.0.1:5565" or
+	// "[::1]:5565").
 	Address string
+	// Name of a network interface to listen on. If set, Address must only
+	// specify a port (e.g. ":5565"). When joining multicast groups this is
+	// the interface the groups are joined on.
+	Interface string
+	// Multicast group addresses to join. If set, Address must only specify a
+	// port.
+	MulticastGroups []string `toml:"multicast_groups"`
 	// Set Hostname field from remote address
 	SetHostname bool `toml:"set_hostname"`
+	// Number of sockets to listen on, each read by its own goroutine. More
+	// than one requires SO_REUSEPORT support. Defaults to 1.
+	Sockets uint
+	// Reassemble records that a UdpOutput split across several datagrams.
+	Chunking bool
+	// Seconds to wait for the remaining chunks of a message before dropping
+	// it.
+	ChunkTimeout uint `toml:"chunk_timeout"`
+	// Maximum number of partially received chunked messages held per socket.
+	MaxPendingChunked int `toml:"max_pending_chunked"`
+	// Name of the compression codec the records are compressed with, "none"
+	// by default. Needs to match the sending UdpOutput's setting.
+	Compression string `toml:"compression"`
 }
 
-// Wrap ReadFrom into Read and set Hostname
+// Wrap ReadFrom into Read and remember the sender's address for the Hostname
 type UdpInputReader struct {
-	listener *net.UDPConn
-	input *UdpInput
+	listener   *net.UDPConn
+	remoteAddr string
 }
 
 func (u *UdpInput) ConfigStruct() interface{} {
 	return &UdpInputConfig{
-		Net: "udp",
+		Net:               "udp",
+		Sockets:           1,
+		ChunkTimeout:      5,
+		MaxPendingChunked: 1000,
 	}
 }
 
 func (u *UdpInput) Init(config interface{}) (err error) {
 	u.config = config.(*UdpInputConfig)
 
+	ipAddress := u.config.Net != "unixgram" && len(u.config.MulticastGroups) == 0 &&
+		!strings.HasPrefix(u.config.Address, "fd:")
+	if u.config.Sockets > 1 && !ipAddress {
+		return errors.New(
+			"Multiple sockets are only supported for unicast UDP addresses.")
+	}
+
+	if u.codec, err = GetCompressionCodec(u.config.Compression); err != nil {
+		return err
+	}
+
+	if u.config.Chunking {
+		if u.config.ChunkTimeout == 0 {
+			return errors.New("chunk_timeout must be greater than zero.")
+		}
+		if u.config.MaxPendingChunked < 1 {
+			return errors.New("max_pending_chunked must be greater than zero.")
+		}
+	}
+
 	if u.config.Net == "unixgram" {
 		if runtime.GOOS == "windows" {
 			return errors.New(
@@ -82,10 +132,11 @@ func (u *UdpInput) Init(config interface{}) (err error) {
 		if err != nil {
 			return fmt.Errorf("Error resolving unixgram address: %s", err)
 		}
-		u.listener, err = net.ListenUnixgram(u.config.Net, unixAddr)
+		listener, err := net.ListenUnixgram(u.config.Net, unixAddr)
 		if err != nil {
 			return fmt.Errorf("Error listening on unixgram: %s", err)
 		}
+		u.listeners = []net.Conn{listener}
 		// Ensure socket file is world writable, unless socket is abstract.
 		if !strings.HasPrefix(u.config.Address, "@") {
 			if err = os.Chmod(u.config.Address, 0666); err != nil {
@@ -108,43 +159,168 @@ func (u *UdpInput) Init(config interface{}) (err error) {
 		}
 		fd := uintptr(fdInt)
 		udpFile := os.NewFile(fd, "udpFile")
-		u.listener, err = net.FileConn(udpFile)
+		listener, err := net.FileConn(udpFile)
 		if err != nil {
 			return fmt.Errorf("Error accessing UDP fd: %s\n", err.Error())
 		}
+		u.listeners = []net.Conn{listener}
+	} else if len(u.config.MulticastGroups) > 0 {
+		conn, err := u.listenMulticast()
+		if err != nil {
+			return err
+		}
+		u.listeners = []net.Conn{conn}
 	} else {
 		// IP address
-		udpAddr, err := net.ResolveUDPAddr(u.config.Net, u.config.Address)
+		addrStr := u.config.Address
+		if u.config.Interface != "" {
+			if addrStr, err = InterfaceAddress(u.config.Net, u.config.Interface,
+				addrStr); err != nil {
+				return err
+			}
+		}
+		udpAddr, err := net.ResolveUDPAddr(u.config.Net, addrStr)
 		if err != nil {
 			return fmt.Errorf("ResolveUDPAddr failed: %s\n", err.Error())
 		}
-		u.listener, err = net.ListenUDP(u.config.Net, udpAddr)
+		conns, err := ListenUDPSockets(u.config.Net, udpAddr, int(u.config.Sockets))
 		if err != nil {
 			return fmt.Errorf("ListenUDP failed: %s\n", err.Error())
 		}
-		if u.config.SetHostname {
-			u.reader = UdpInputReader {
-				u.listener.(*net.UDPConn),
-				u,
-			}
+		for _, conn := range conns {
+			u.listeners = append(u.listeners, conn)
 		}
 	}
 	u.stopChan = make(chan struct{})
 	return
 }
 
+// listenMulticast binds to the configured port and joins each of the
+// configured multicast groups.
+func (u *UdpInput) listenMulticast() (*net.UDPConn, error) {
+	host, port, err := net.SplitHostPort(u.config.Address)
+	if err != nil {
+		return nil, err
+	}
+	if host != "" {
+		return nil, fmt.Errorf("address '%s' must only specify a port when "+
+			"joining multicast groups", u.config.Address)
+	}
+
+	var ifi *net.Interface
+	if u.config.Interface != "" {
+		if ifi, err = net.InterfaceByName(u.config.Interface); err != nil {
+			return nil, fmt.Errorf("can't find interface '%s': %s",
+				u.config.Interface, err)
+		}
+	}
+
+	groups := make([]net.IP, len(u.config.MulticastGroups))
+	for i, group := range u.config.MulticastGroups {
+		if groups[i] = net.ParseIP(group); groups[i] == nil || !groups[i].IsMulticast() {
+			return nil, fmt.Errorf("'%s' is not a multicast group address", group)
+		}
+	}
+
+	gaddr, err := net.ResolveUDPAddr(u.config.Net, net.JoinHostPort(
+		groups[0].String(), port))
+	if err != nil {
+		return nil, fmt.Errorf("ResolveUDPAddr failed: %s", err)
+	}
+	conn, err := net.ListenMulticastUDP(u.config.Net, ifi, gaddr)
+	if err != nil {
+		return nil, fmt.Errorf("ListenMulticastUDP failed: %s", err)
+	}
+	for _, group := range groups[1:] {
+		if err = joinGroup(conn, ifi, group); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("can't join multicast group %s: %s", group, err)
+		}
+	}
+	return conn, nil
+}
+
 func (u *UdpInput) Run(ir InputRunner, h PluginHelper) error {
-	sr := ir.NewSplitterRunner("")
+	var wg sync.WaitGroup
+	for i, listener := range u.listeners {
+		// Every socket needs its own splitter runner.
+		token := ""
+		if len(u.listeners) > 1 {
+			token = strconv.Itoa(i)
+		}
+		wg.Add(1)
+		go func(listener net.Conn, token string) {
+			u.readListener(ir, listener, token)
+			wg.Done()
+		}(listener, token)
+	}
+	wg.Wait()
+
+	if u.config.Net == "unixgram" {
+		if !strings.HasPrefix(u.config.Address, "@") {
+			err := os.Remove(u.config.Address)
+			if err != nil {
+				ir.LogError(errors.New("Error cleaning up unix datagram socket"))
+			}
+		}
+	}
+	return nil
+}
+
+func (u *UdpInput) readListener(ir InputRunner, listener net.Conn, token string) {
+	sr := ir.NewSplitterRunner(token)
 	defer sr.Done()
+	// With several sockets each one gets its own deliverer, and so its own
+	// decoder, so that synchronous decoding happens in parallel in the
+	// reading goroutines.
+	var deliverer Deliverer
+	if token != "" {
+		deliverer = ir.NewDeliverer(token)
+		defer deliverer.Done()
+	}
 	ok := true
 	var err error
 
+	var reader io.Reader = listener
+	var udpReader *UdpInputReader
+	var chunks *chunkReader
+	if u.config.Chunking {
+		if pConn, isPacket := listener.(net.PacketConn); isPacket {
+			chunks = newChunkReader(pConn,
+				time.Duration(u.config.ChunkTimeout)*time.Second,
+				u.config.MaxPendingChunked, ir.LogError)
+			reader = chunks
+		} else {
+			ir.LogError(errors.New("Can't reassemble chunks on a non-packet socket."))
+		}
+	}
+	if u.config.SetHostname && chunks == nil {
+		udpReader = &UdpInputReader{listener: listener.(*net.UDPConn)}
+		reader = udpReader
+	}
+	if u.codec != nil {
+		decompressor := &decompressingReader{codec: u.codec, logError: ir.LogError}
+		if chunks != nil {
+			decompressor.next = chunks.nextRecord
+		} else {
+			datagramReader := reader
+			buf := make([]byte, MAX_DATAGRAM_SIZE)
+			decompressor.next = func() ([]byte, error) {
+				n, err := datagramReader.Read(buf)
+				return buf[:n], err
+			}
+		}
+		reader = decompressor
+	}
+
 	if !sr.UseMsgBytes() {
 		name := ir.Name()
 		packDec := func(pack *PipelinePack) {
 			pack.Message.SetType(name)
-			if u.config.SetHostname {
-				pack.Message.SetHostname(u.remote_addr)
+			if udpReader != nil {
+				pack.Message.SetHostname(udpReader.remoteAddr)
+			} else if chunks != nil && u.config.SetHostname {
+				pack.Message.SetHostname(chunks.remoteAddr)
 			}
 		}
 		sr.SetPackDecorator(packDec)
@@ -155,11 +331,7 @@ func (u *UdpInput) Run(ir InputRunner, h PluginHelper) error {
 		case _, ok = <-u.stopChan:
 			break
 		default:
-			if u.config.SetHostname {
-				err = sr.SplitStream(u.reader, nil)
-			} else {
-				err = sr.SplitStream(u.listener, nil)
-			}
+			err = sr.SplitStream(reader, deliverer)
 			// "use of closed" -> we're stopping.
 			if err != nil && !strings.Contains(err.Error(), "use of closed") {
 				ir.LogError(fmt.Errorf("Read error: %s", err))
@@ -167,28 +339,60 @@ func (u *UdpInput) Run(ir InputRunner, h PluginHelper) error {
 			sr.GetRemainingData() // reset the receiving buffer
 		}
 	}
-	if u.config.Net == "unixgram" {
-		if !strings.HasPrefix(u.config.Address, "@") {
-			err = os.Remove(u.config.Address)
-			if err != nil {
-				ir.LogError(errors.New("Error cleaning up unix datagram socket"))
-			}
-		}
-	}
-	return nil
 }
 
 func (u *UdpInput) Stop() {
 	close(u.stopChan)
-	u.listener.Close()
+	for _, listener := range u.listeners {
+		listener.Close()
+	}
+}
+
+// io.Reader decompressing the records returned by `next`, each a datagram or
+// a reassembled chunked record that a UdpOutput compressed as a whole.
+type decompressingReader struct {
+	next     func() ([]byte, error)
+	codec    CompressionCodec
+	pending  []byte
+	logError func(error)
+}
+
+func (r *decompressingReader) Read(p []byte) (n int, err error) {
+	for len(r.pending) == 0 {
+		var record []byte
+		if record, err = r.next(); err != nil {
+			return 0, err
+		}
+		if r.pending, err = decompressRecord(r.codec, record); err != nil {
+			r.logError(fmt.Errorf("can't decompress record: %s", err))
+		}
+	}
+	n = copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Decompresses a record, refusing to inflate it beyond the maximum record
+// size.
+func decompressRecord(codec CompressionCodec, record []byte) ([]byte, error) {
+	r, err := codec.NewReader(bytes.NewReader(record))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(r, int64(MAX_RECORD_SIZE)+1))
+	if err == nil && len(data) > int(MAX_RECORD_SIZE) {
+		return nil, fmt.Errorf("decompressed record exceeds %d bytes", MAX_RECORD_SIZE)
+	}
+	return data, err
 }
 
-func (r UdpInputReader) Read(p []byte) (n int, err error) {
+func (r *UdpInputReader) Read(p []byte) (n int, err error) {
 	n, addr, err := r.listener.ReadFromUDP(p)
 	if addr != nil {
-		r.input.remote_addr = addr.IP.String()
+		r.remoteAddr = addr.IP.String()
 	} else {
-		r.input.remote_addr = ""
+		r.remoteAddr = ""
 	}
 	return n, err
 }
@@ -16,6 +16,7 @@
 package udp
 
 import (
+	"io"
 	"io/ioutil"
 	"net"
 	"path/filepath"
@@ -78,9 +79,9 @@ func UdpInputSpec(c gs.Context) {
 
 		splitCall := ith.MockSplitterRunner.EXPECT().SplitStream(gomock.Any(),
 			nil).AnyTimes()
-		splitCall.Do(func(conn net.Conn, del Deliverer) {
+		splitCall.Do(func(r io.Reader, del Deliverer) {
 			recd := make([]byte, 65536)
-			n, _ := conn.Read(recd)
+			n, _ := r.Read(recd)
 			recd = recd[:n]
 			bytesChan <- recd
 		})
@@ -93,7 +94,7 @@ func UdpInputSpec(c gs.Context) {
 
 			err := udpInput.Init(config)
 			c.Assume(err, gs.IsNil)
-			realListener := (udpInput.listener).(*net.UDPConn)
+			realListener := (udpInput.listeners[0]).(*net.UDPConn)
 			c.Expect(realListener.LocalAddr().String(), gs.Equals, ith.ResolvedAddrStr)
 
 			c.Specify("passes the connection to SplitStream", func() {
@@ -111,6 +112,97 @@ func UdpInputSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("decompresses the datagrams", func() {
+			config.Net = "udp"
+			config.Address = "localhost:55566"
+			config.Compression = "gzip"
+			err := udpInput.Init(config)
+			c.Assume(err, gs.IsNil)
+			go udpInput.Run(ith.MockInputRunner, ith.MockHelper)
+
+			codec, _ := GetCompressionCodec("gzip")
+			compressed, err := Compress(codec, buf)
+			c.Assume(err, gs.IsNil)
+			conn, err := net.Dial("udp", config.Address)
+			c.Assume(err, gs.IsNil)
+			_, err = conn.Write(compressed)
+			c.Assume(err, gs.IsNil)
+			conn.Close()
+
+			recd := <-bytesChan
+			c.Expect(string(recd), gs.Equals, string(buf))
+			udpInput.Stop()
+		})
+
+		c.Specify("rejects an unknown compression codec", func() {
+			config.Net = "udp"
+			config.Address = "localhost:55566"
+			config.Compression = "rar"
+			err := udpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		if runtime.GOOS == "linux" {
+			c.Specify("using multiple sockets", func() {
+				config.Net = "udp"
+				config.Address = "127.0.0.1:55567"
+				config.Sockets = 3
+
+				err := udpInput.Init(config)
+				c.Assume(err, gs.IsNil)
+				c.Expect(len(udpInput.listeners), gs.Equals, 3)
+				for _, listener := range udpInput.listeners {
+					c.Expect(listener.LocalAddr().String(), gs.Equals, "127.0.0.1:55567")
+					listener.Close()
+				}
+			})
+		}
+
+		c.Specify("rejects multiple sockets for a unix datagram socket", func() {
+			config.Net = "unixgram"
+			config.Address = "@unixgram-socket"
+			config.Sockets = 2
+			err := udpInput.Init(config)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects a zero chunk timeout when chunking", func() {
+			config.Address = "127.0.0.1:0"
+			config.Chunking = true
+			config.ChunkTimeout = 0
+			err := udpInput.Init(config)
+			c.Expect(err.Error(), gs.Equals, "chunk_timeout must be greater than zero.")
+		})
+
+		c.Specify("joining multicast groups", func() {
+			config.Net = "udp4"
+			config.Address = ":55566"
+			config.MulticastGroups = []string{"239.255.0.1", "239.255.0.2"}
+
+			c.Specify("requires a port-only address", func() {
+				config.Address = "127.0.0.1:55566"
+				err := udpInput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			c.Specify("rejects unicast group addresses", func() {
+				config.MulticastGroups = []string{"10.0.0.1"}
+				err := udpInput.Init(config)
+				c.Expect(err, gs.Not(gs.IsNil))
+			})
+
+			if runtime.GOOS == "linux" {
+				c.Specify("listens on the group port", func() {
+					err := udpInput.Init(config)
+					c.Assume(err, gs.IsNil)
+					realListener := (udpInput.listeners[0]).(*net.UDPConn)
+					c.Expect(realListener.LocalAddr().String(), gs.Equals,
+						"0.0.0.0:55566")
+					realListener.Close()
+				})
+			}
+		})
+
 		if runtime.GOOS != "windows" {
 			c.Specify("using a unix datagram socket", func() {
 				tmpDir, err := ioutil.TempDir("", "heka-socket")
@@ -122,7 +214,7 @@ func UdpInputSpec(c gs.Context) {
 
 				err = udpInput.Init(config)
 				c.Assume(err, gs.IsNil)
-				realListener := (udpInput.listener).(*net.UnixConn)
+				realListener := (udpInput.listeners[0]).(*net.UnixConn)
 				c.Expect(realListener.LocalAddr().String(), gs.Equals, unixPath)
 
 				c.Specify("passes the socket to SplitStream", func() {
@@ -152,7 +244,7 @@ func UdpInputSpec(c gs.Context) {
 
 				err := udpInput.Init(config)
 				c.Assume(err, gs.IsNil)
-				realListener := (udpInput.listener).(*net.UnixConn)
+				realListener := (udpInput.listeners[0]).(*net.UnixConn)
 				c.Expect(realListener.LocalAddr().String(), gs.Equals, unixPath)
 
 				c.Specify("passes the socket to SplitStream", func() {
@@ -15,18 +15,25 @@
 package udp
 
 import (
+	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
 	"runtime"
 
+	"github.com/mozilla-services/heka/client"
+	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
 )
 
 // This is our plugin struct.
 type UdpOutput struct {
 	*UdpOutputConfig
-	conn net.Conn
+	conn    net.Conn
+	chunkId uint64
+	// Compresses each record, if compression is enabled.
+	codec pipeline.CompressionCodec
 }
 
 // This is our plugin's config struct
@@ -42,6 +49,17 @@ type UdpOutputConfig struct {
 
 	// Maximum size of message, plugin drops the data if it exceeds this limit.
 	MaxMessageSize int `toml:"max_message_size"`
+	// Split records larger than MaxMessageSize across several datagrams for
+	// a UdpInput with chunking enabled to reassemble, instead of dropping
+	// them.
+	Chunking bool
+	// Optional HMAC signing of the framed records. Setting a signer implies
+	// Heka stream framing.
+	Signer *message.MessageSigningConfig `toml:"signer"`
+	// Name of the compression codec each record is compressed with before
+	// it's sent, "none" by default. The receiving UdpInput needs the same
+	// setting.
+	Compression string `toml:"compression"`
 }
 
 // Provides pipeline.HasConfigStruct interface.
@@ -62,6 +80,30 @@ func (o *UdpOutput) Init(config interface{}) (err error) {
 		return fmt.Errorf("Maximum message size can't be smaller than 512 bytes.")
 	}
 
+	if o.codec, err = pipeline.GetCompressionCodec(o.Compression); err != nil {
+		return
+	}
+
+	if o.Signer != nil {
+		if o.Signer.Name == "" {
+			return errors.New("Signer name is required.")
+		}
+		if o.Signer.Hash != "" && o.Signer.Hash != "md5" && o.Signer.Hash != "sha1" {
+			return fmt.Errorf("Unsupported signer hmac_hash '%s'.", o.Signer.Hash)
+		}
+	}
+
+	if o.Chunking {
+		// Chunked message ids only need to be unique per sender for the
+		// lifetime of a reassembly, start at a random point so restarts
+		// don't collide with chunks still pending on the receiver.
+		var seed [8]byte
+		if _, err = rand.Read(seed[:]); err != nil {
+			return fmt.Errorf("Can't seed chunk ids: %s", err)
+		}
+		o.chunkId = binary.BigEndian.Uint64(seed[:])
+	}
+
 	if o.Net == "unixgram" {
 		if runtime.GOOS == "windows" {
 			return errors.New("Can't use Unix datagram sockets on Windows.")
@@ -106,9 +148,14 @@ func (o *UdpOutput) Init(config interface{}) (err error) {
 
 func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err error) {
 
-	if or.Encoder() == nil {
+	encoder := or.Encoder()
+	if encoder == nil {
 		return errors.New("Encoder required.")
 	}
+	if o.Signer != nil {
+		// We do the framing ourselves so the header can carry the signature.
+		or.SetUseFraming(false)
+	}
 
 	var (
 		outBytes []byte
@@ -116,7 +163,15 @@ func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err
 	)
 
 	for pack := range or.InChan() {
-		if outBytes, e = or.Encode(pack); e != nil {
+		if o.Signer != nil {
+			outBytes, e = o.signedRecord(encoder, pack)
+		} else {
+			outBytes, e = or.Encode(pack)
+		}
+		if e == nil && outBytes != nil && o.codec != nil {
+			outBytes, e = pipeline.Compress(o.codec, outBytes)
+		}
+		if e != nil {
 			or.UpdateCursor(pack.QueueCursor)
 			e = fmt.Errorf("Error encoding message: %s", e.Error())
 			pack.Recycle(e)
@@ -124,11 +179,17 @@ func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err
 		} else if outBytes != nil {
 			msgSize := len(outBytes)
 			if msgSize > o.UdpOutputConfig.MaxMessageSize {
-				or.UpdateCursor(pack.QueueCursor)
-				e = fmt.Errorf("Message has exceeded allowed UDP data size: %d > %d",
-					msgSize, o.UdpOutputConfig.MaxMessageSize)
-				pack.Recycle(e)
-				continue
+				if o.Chunking {
+					e = o.writeChunks(outBytes)
+				} else {
+					e = fmt.Errorf("Message has exceeded allowed UDP data size: %d > %d",
+						msgSize, o.UdpOutputConfig.MaxMessageSize)
+				}
+				if e != nil {
+					or.UpdateCursor(pack.QueueCursor)
+					pack.Recycle(e)
+					continue
+				}
 			} else {
 				o.conn.Write(outBytes)
 			}
@@ -139,6 +200,34 @@ func (o *UdpOutput) Run(or pipeline.OutputRunner, h pipeline.PluginHelper) (err
 	return
 }
 
+// Encodes the pack and frames it with a header signed by the configured
+// signer.
+func (o *UdpOutput) signedRecord(encoder pipeline.Encoder,
+	pack *pipeline.PipelinePack) (record []byte, err error) {
+
+	var encoded []byte
+	if encoded, err = encoder.Encode(pack); err != nil || encoded == nil {
+		return
+	}
+	err = client.CreateHekaStream(encoded, &record, o.Signer)
+	return
+}
+
+// Sends a record that's too big for one datagram as a series of chunks.
+func (o *UdpOutput) writeChunks(record []byte) error {
+	o.chunkId++
+	chunks, err := makeChunks(o.chunkId, record, o.MaxMessageSize)
+	if err != nil {
+		return fmt.Errorf("Can't chunk message: %s", err)
+	}
+	for _, chunk := range chunks {
+		if _, err = o.conn.Write(chunk); err != nil {
+			return fmt.Errorf("Error writing chunk: %s", err)
+		}
+	}
+	return nil
+}
+
 func init() {
 	pipeline.RegisterPlugin("UdpOutput", func() interface{} {
 		return new(UdpOutput)
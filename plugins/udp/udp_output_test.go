@@ -22,7 +22,9 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/mozilla-services/heka/message"
 	"github.com/mozilla-services/heka/pipeline"
 	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
 	"github.com/mozilla-services/heka/plugins"
@@ -125,6 +127,35 @@ func UdpOutputSpec(c gs.Context) {
 			})
 		})
 
+		c.Specify("compresses the records", func() {
+			addr := "127.0.0.1:45679"
+			conn, err := net.ListenPacket("udp", addr)
+			c.Assume(err, gs.IsNil)
+			defer conn.Close()
+			config.Address = addr
+			config.Compression = "gzip"
+			err = udpOutput.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			wg.Add(1)
+			go func() {
+				err := udpOutput.Run(oth.MockOutputRunner, oth.MockHelper)
+				c.Expect(err, gs.IsNil)
+				wg.Done()
+			}()
+
+			inChan <- pack
+			b := make([]byte, 1000)
+			n, _, err := conn.ReadFrom(b)
+			c.Assume(err, gs.IsNil)
+			codec, _ := pipeline.GetCompressionCodec("gzip")
+			decompressed, err := pipeline.Decompress(codec, b[:n])
+			c.Expect(err, gs.IsNil)
+			c.Expect(string(decompressed), gs.Equals, payload)
+			close(inChan)
+			wg.Wait()
+		})
+
 		c.Specify("using Unix datagrams", func() {
 			if runtime.GOOS == "windows" {
 				return
@@ -246,6 +277,73 @@ func UdpOutputSpec(c gs.Context) {
 		wg.Wait()
 	})
 
+	c.Specify("with a signer and chunking", func() {
+		addr := "127.0.0.1:45679"
+		config.Address = addr
+		config.Chunking = true
+		config.MaxMessageSize = 512
+		config.Signer = &message.MessageSigningConfig{
+			Name:    "ops",
+			Key:     "secret",
+			Version: 1,
+		}
+
+		msg := pipeline_ts.GetTestMessage()
+		msg.SetPayload(strings.Repeat("x", 2000))
+		pack := pipeline.NewPipelinePack(rChan)
+		pack.Message = msg
+
+		oth.MockOutputRunner.EXPECT().InChan().Return(inChan)
+		oth.MockOutputRunner.EXPECT().UpdateCursor("").AnyTimes()
+		oth.MockOutputRunner.EXPECT().Encoder().Return(encoder)
+		oth.MockOutputRunner.EXPECT().SetUseFraming(false)
+
+		conn, err := net.ListenPacket("udp", addr)
+		c.Assume(err, gs.IsNil)
+		defer conn.Close()
+
+		err = udpOutput.Init(config)
+		c.Assume(err, gs.IsNil)
+
+		wg.Add(1)
+		go func() {
+			err = udpOutput.Run(oth.MockOutputRunner, oth.MockHelper)
+			c.Expect(err, gs.IsNil)
+			wg.Done()
+		}()
+		inChan <- pack
+		close(inChan)
+		wg.Wait()
+
+		var logged []error
+		reader := newChunkReader(conn, time.Second, 10, func(err error) {
+			logged = append(logged, err)
+		})
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		record := make([]byte, 4096)
+		n, err := reader.Read(record)
+		c.Expect(err, gs.IsNil)
+		c.Expect(len(logged), gs.Equals, 0)
+		record = record[:n]
+
+		headerLen := int(record[1])
+		header := new(message.Header)
+		decoded, err := message.DecodeHeader(record[2:headerLen+3], header)
+		c.Expect(decoded, gs.IsTrue)
+		c.Expect(header.GetHmacSigner(), gs.Equals, "ops")
+		c.Expect(header.GetHmacKeyVersion(), gs.Equals, uint32(1))
+		c.Expect(len(header.GetHmac()) > 0, gs.IsTrue)
+		c.Expect(len(record), gs.Equals, headerLen+3+int(header.GetMessageLength()))
+		c.Expect(string(record[headerLen+3:]), gs.Equals, strings.Repeat("x", 2000))
+	})
+
+	c.Specify("rejects an unsupported signer hash", func() {
+		config.Address = "localhost:12345"
+		config.Signer = &message.MessageSigningConfig{Name: "ops", Hash: "sha512"}
+		err := udpOutput.Init(config)
+		c.Expect(err, gs.Not(gs.IsNil))
+	})
+
 	c.Specify("checks validation of of Maximum message size limit", func() {
 
 		config.Address = "localhost:12345"
@@ -0,0 +1,137 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"fmt"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+// A single unit conversion. If From is empty the field's representation is
+// used as the source unit.
+type UnitConversion struct {
+	From string
+	To   string
+}
+
+type UnitFilterConfig struct {
+	// Conversions to apply, keyed by field name.
+	Fields map[string]UnitConversion
+	// Target units keyed by source unit. Applies to every numeric field whose
+	// representation is the source unit and isn't listed in Fields.
+	Representations map[string]string
+	// Appended to the Type of the injected messages. Defaults to
+	// ".normalized".
+	TypeSuffix string `toml:"type_suffix"`
+}
+
+// UnitFilter injects copies of the messages it receives with numeric fields
+// converted to consistent units, so data from sources reporting in different
+// units can be combined.
+type UnitFilter struct {
+	conf *UnitFilterConfig
+	fr   FilterRunner
+	h    PluginHelper
+}
+
+func (f *UnitFilter) ConfigStruct() interface{} {
+	return &UnitFilterConfig{
+		TypeSuffix: ".normalized",
+	}
+}
+
+func checkConversion(from, to string) error {
+	if !message.IsKnownUnit(to) {
+		return fmt.Errorf("unknown unit '%s'", to)
+	}
+	if from == "" {
+		return nil
+	}
+	_, err := message.ConvertUnit(0, from, to)
+	return err
+}
+
+func (f *UnitFilter) Init(config interface{}) error {
+	f.conf = config.(*UnitFilterConfig)
+	if len(f.conf.Fields) == 0 && len(f.conf.Representations) == 0 {
+		return fmt.Errorf("at least one of 'fields' or 'representations' must be specified")
+	}
+	for name, conv := range f.conf.Fields {
+		if err := checkConversion(conv.From, conv.To); err != nil {
+			return fmt.Errorf("field '%s': %s", name, err)
+		}
+	}
+	for from, to := range f.conf.Representations {
+		if err := checkConversion(from, to); err != nil {
+			return fmt.Errorf("representation '%s': %s", from, err)
+		}
+	}
+	return nil
+}
+
+func (f *UnitFilter) Prepare(fr FilterRunner, h PluginHelper) error {
+	f.fr = fr
+	f.h = h
+	return nil
+}
+
+// normalize converts the fields of msg in place.
+func (f *UnitFilter) normalize(msg *message.Message) error {
+	for i, field := range msg.Fields {
+		var from, to string
+		if conv, ok := f.conf.Fields[field.GetName()]; ok {
+			from, to = conv.From, conv.To
+		} else if to, ok = f.conf.Representations[field.GetRepresentation()]; ok {
+			// Representation based conversions only make sense for numbers,
+			// quietly leave anything else alone.
+			valueType := field.GetValueType()
+			if valueType != message.Field_DOUBLE && valueType != message.Field_INTEGER {
+				continue
+			}
+		} else {
+			continue
+		}
+		converted, err := message.ConvertFieldUnit(field, from, to)
+		if err != nil {
+			return err
+		}
+		msg.Fields[i] = converted
+	}
+	return nil
+}
+
+func (f *UnitFilter) ProcessMessage(pack *PipelinePack) error {
+	newPack, err := f.h.PipelinePack(pack.MsgLoopCount)
+	if err != nil {
+		return err
+	}
+	uuid := newPack.Message.GetUuid()
+	pack.Message.Copy(newPack.Message)
+	newPack.Message.SetUuid(uuid)
+	newPack.Message.SetType(pack.Message.GetType() + f.conf.TypeSuffix)
+	if err = f.normalize(newPack.Message); err != nil {
+		newPack.Recycle(nil)
+		return err
+	}
+	f.fr.Inject(newPack)
+	return nil
+}
+
+func (f *UnitFilter) CleanUp() {}
+
+func init() {
+	RegisterPlugin("UnitFilter", func() interface{} {
+		return new(UnitFilter)
+	})
+}
@@ -0,0 +1,115 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package plugins
+
+import (
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	pipeline_ts "github.com/mozilla-services/heka/pipeline/testsupport"
+	"github.com/mozilla-services/heka/pipelinemock"
+	"github.com/rafrombrc/gomock/gomock"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func UnitFilterSpec(c gs.Context) {
+	t := new(pipeline_ts.SimpleT)
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c.Specify("A UnitFilter", func() {
+		filter := new(UnitFilter)
+		config := filter.ConfigStruct().(*UnitFilterConfig)
+
+		c.Specify("requires at least one conversion", func() {
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects unknown units", func() {
+			config.Fields = map[string]UnitConversion{"latency": {To: "fortnights"}}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects conversions between dimensions", func() {
+			config.Representations = map[string]string{"ms": "MiB"}
+			c.Expect(filter.Init(config), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("injects normalized copies", func() {
+			config.Fields = map[string]UnitConversion{
+				"latency":  {From: "ns", To: "ms"},
+				"response": {To: "s"},
+			}
+			config.Representations = map[string]string{"B": "MiB"}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			filter.Prepare(fr, h)
+
+			recycleChan := make(chan *PipelinePack, 2)
+			pack := NewPipelinePack(recycleChan)
+			pack.Message.SetType("metrics")
+			message.NewInt64Field(pack.Message, "latency", 2500000, "")
+			message.NewInt64Field(pack.Message, "response", 1500, "ms")
+			message.NewInt64Field(pack.Message, "body_size", 3145728, "B")
+			message.NewInt64Field(pack.Message, "count", 7, "count")
+			message.NewStringField(pack.Message, "path", "/")
+
+			newPack := NewPipelinePack(recycleChan)
+			h.EXPECT().PipelinePack(uint(0)).Return(newPack, nil)
+			var injected *message.Message
+			fr.EXPECT().Inject(newPack).Do(func(p *PipelinePack) {
+				injected = p.Message
+			}).Return(true)
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.IsNil)
+			c.Assume(injected, gs.Not(gs.IsNil))
+			c.Expect(injected.GetType(), gs.Equals, "metrics.normalized")
+
+			latency := injected.FindFirstField("latency")
+			c.Expect(latency.GetValueDouble()[0], gs.Equals, 2.5)
+			c.Expect(latency.GetRepresentation(), gs.Equals, "ms")
+			response := injected.FindFirstField("response")
+			c.Expect(response.GetValueDouble()[0], gs.Equals, 1.5)
+			size := injected.FindFirstField("body_size")
+			c.Expect(size.GetValueDouble()[0], gs.Equals, float64(3))
+			c.Expect(size.GetRepresentation(), gs.Equals, "MiB")
+			count, _ := injected.GetFieldValue("count")
+			c.Expect(count, gs.Equals, int64(7))
+
+			// The original message is left alone.
+			orig, _ := pack.Message.GetFieldValue("latency")
+			c.Expect(orig, gs.Equals, int64(2500000))
+		})
+
+		c.Specify("drops messages whose fields can't be converted", func() {
+			config.Fields = map[string]UnitConversion{"path": {From: "ms", To: "s"}}
+			err := filter.Init(config)
+			c.Assume(err, gs.IsNil)
+
+			fr := pipelinemock.NewMockFilterRunner(ctrl)
+			h := pipelinemock.NewMockPluginHelper(ctrl)
+			filter.Prepare(fr, h)
+
+			recycleChan := make(chan *PipelinePack, 2)
+			pack := NewPipelinePack(recycleChan)
+			message.NewStringField(pack.Message, "path", "/")
+			h.EXPECT().PipelinePack(uint(0)).Return(NewPipelinePack(recycleChan), nil)
+
+			err = filter.ProcessMessage(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+			c.Expect(len(recycleChan), gs.Equals, 1)
+		})
+	})
+}
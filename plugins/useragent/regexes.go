@@ -0,0 +1,408 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A user-agent string parsed into its browser, OS and device.
+type Agent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+	Device         string
+	DeviceBrand    string
+	DeviceModel    string
+}
+
+// One rule of a regexes.yaml section: a regex and replacement templates
+// for the values it extracts, which refer to its groups as $1 to $9.
+type uaRule struct {
+	regex        *regexp.Regexp
+	replacements []string
+}
+
+// The compiled user_agent_parsers, os_parsers and device_parsers sections
+// of a regexes.yaml file, each tried in order until a rule matches.
+type regexSet struct {
+	browsers []uaRule
+	oses     []uaRule
+	devices  []uaRule
+}
+
+// Replacement keys of each section, in the order of the values they
+// replace. A missing family or model defaults to the regex's first group
+// and a missing version part to the group following the family's.
+var (
+	browserKeys = []string{"family_replacement", "v1_replacement",
+		"v2_replacement", "v3_replacement"}
+	osKeys = []string{"os_replacement", "os_v1_replacement",
+		"os_v2_replacement", "os_v3_replacement"}
+	deviceKeys = []string{"device_replacement", "brand_replacement",
+		"model_replacement"}
+)
+
+// Parses the regexes.yaml format of the ua-parser project. Only the subset
+// of YAML the format uses is supported: top level sections holding lists of
+// flat maps with plain or quoted string values. Rules whose regex isn't
+// supported by Go's regexp package, e.g. because of lookaheads, are
+// skipped and counted.
+func parseRegexes(data []byte) (set *regexSet, skipped int, err error) {
+	set = new(regexSet)
+	var (
+		section *[]uaRule
+		keys    []string
+		item    map[string]string
+	)
+	flush := func() {
+		if item == nil || section == nil {
+			return
+		}
+		rule, err := compileRule(item, keys)
+		if err != nil {
+			skipped++
+		} else {
+			*section = append(*section, rule)
+		}
+		item = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '-' {
+			// A new top level section.
+			flush()
+			name := strings.TrimSuffix(trimmed, ":")
+			switch name {
+			case "user_agent_parsers":
+				section, keys = &set.browsers, browserKeys
+			case "os_parsers":
+				section, keys = &set.oses, osKeys
+			case "device_parsers":
+				section, keys = &set.devices, deviceKeys
+			default:
+				section, keys = nil, nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") {
+			flush()
+			item = make(map[string]string)
+			trimmed = strings.TrimSpace(trimmed[2:])
+		}
+		if item == nil {
+			return nil, 0, fmt.Errorf("line %d: value outside of a list item", lineNum)
+		}
+		colon := strings.Index(trimmed, ":")
+		if colon < 1 {
+			return nil, 0, fmt.Errorf("line %d: expected 'key: value'", lineNum)
+		}
+		value, err := parseYamlValue(strings.TrimSpace(trimmed[colon+1:]))
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+		item[strings.TrimSpace(trimmed[:colon])] = value
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	flush()
+	return set, skipped, nil
+}
+
+// Parses a plain, single-quoted or double-quoted scalar, dropping any
+// trailing comment.
+func parseYamlValue(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '\'':
+		var buf bytes.Buffer
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				buf.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				buf.WriteByte('\'')
+				i++
+				continue
+			}
+			return buf.String(), nil
+		}
+		return "", fmt.Errorf("unterminated quoted value: %s", s)
+	case '"':
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '"' {
+				return strconv.Unquote(s[:i+1])
+			}
+		}
+		return "", fmt.Errorf("unterminated quoted value: %s", s)
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+func compileRule(item map[string]string, keys []string) (uaRule, error) {
+	rule := uaRule{replacements: make([]string, len(keys))}
+	expr := item["regex"]
+	if expr == "" {
+		return rule, fmt.Errorf("rule without a regex")
+	}
+	if item["regex_flag"] == "i" {
+		expr = "(?i)" + expr
+	}
+	var err error
+	if rule.regex, err = regexp.Compile(expr); err != nil {
+		return rule, err
+	}
+	for i, key := range keys {
+		rule.replacements[i] = item[key]
+	}
+	return rule, nil
+}
+
+// Substitutes the $1 to $9 group references of a replacement template,
+// references to groups that didn't match becoming empty.
+func expandTemplate(template string, groups []string) string {
+	if !strings.Contains(template, "$") {
+		return template
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c == '$' && i+1 < len(template) && template[i+1] >= '1' &&
+			template[i+1] <= '9' {
+
+			if group := int(template[i+1] - '0'); group < len(groups) {
+				buf.WriteString(groups[group])
+			}
+			i++
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// Returns the values extracted by the first matching rule, values without
+// a replacement taking the groups following the first one. Returns nil if
+// no rule matches.
+func matchRules(rules []uaRule, s string, defaultGroups []int) []string {
+	for _, rule := range rules {
+		groups := rule.regex.FindStringSubmatch(s)
+		if groups == nil {
+			continue
+		}
+		values := make([]string, len(rule.replacements))
+		for i, template := range rule.replacements {
+			if template != "" {
+				values[i] = expandTemplate(template, groups)
+			} else if g := defaultGroups[i]; g > 0 && g < len(groups) {
+				values[i] = groups[g]
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Joins the non-empty leading version parts with dots.
+func joinVersion(parts []string) string {
+	version := ""
+	for _, part := range parts {
+		if part == "" {
+			break
+		}
+		if version != "" {
+			version += "."
+		}
+		version += part
+	}
+	return version
+}
+
+// Parses a user-agent string. Parts that no rule matches are set to
+// "Other", as ua-parser does.
+func (set *regexSet) parse(ua string) *Agent {
+	agent := &Agent{Browser: "Other", OS: "Other", Device: "Other"}
+	if values := matchRules(set.browsers, ua, []int{1, 2, 3, 4}); values != nil {
+		if values[0] != "" {
+			agent.Browser = values[0]
+		}
+		agent.BrowserVersion = joinVersion(values[1:])
+	}
+	if values := matchRules(set.oses, ua, []int{1, 2, 3, 4}); values != nil {
+		if values[0] != "" {
+			agent.OS = values[0]
+		}
+		agent.OSVersion = joinVersion(values[1:])
+	}
+	if values := matchRules(set.devices, ua, []int{1, 0, 1}); values != nil {
+		if values[0] != "" {
+			agent.Device = values[0]
+		}
+		agent.DeviceBrand = values[1]
+		agent.DeviceModel = values[2]
+	}
+	return agent
+}
+
+// Rules used when no regexes file is configured, covering the common
+// browsers, operating systems, devices and crawlers. Earlier rules take
+// precedence, so e.g. Edge and Opera, which also announce themselves as
+// Chrome, come before it.
+const defaultRegexes = `
+user_agent_parsers:
+  - regex: '(Googlebot|bingbot|YandexBot|Baiduspider|DuckDuckBot|Applebot|facebookexternalhit|Twitterbot)(?:/(\d+)(?:\.(\d+))?)?'
+  - regex: '(Slurp)'
+    family_replacement: 'Yahoo! Slurp'
+  - regex: '(curl|Wget|python-requests|Go-http-client|okhttp|Apache-HttpClient)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: '(?:Edge|Edg|EdgA|EdgiOS)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+    family_replacement: 'Edge'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: '(?:OPR|Opera)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+    family_replacement: 'Opera'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: 'SamsungBrowser/(\d+)(?:\.(\d+))?'
+    family_replacement: 'Samsung Internet'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+  - regex: 'YaBrowser/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+    family_replacement: 'Yandex Browser'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: '(Vivaldi)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: 'CriOS/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+    family_replacement: 'Chrome Mobile iOS'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: 'FxiOS/(\d+)(?:\.(\d+))?'
+    family_replacement: 'Firefox iOS'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+  - regex: '; wv\).+Chrome/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+    family_replacement: 'Chrome Mobile WebView'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: 'Chrome/(\d+)(?:\.(\d+))?(?:\.(\d+))?[\d.]* Mobile'
+    family_replacement: 'Chrome Mobile'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: '(Chromium|Chrome)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: 'Mobile;.*Firefox/(\d+)(?:\.(\d+))?'
+    family_replacement: 'Firefox Mobile'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+  - regex: '(Firefox)/(\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: 'MSIE (\d+)(?:\.(\d+))?'
+    family_replacement: 'IE'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+  - regex: 'Trident/7\.0.*rv:(\d+)(?:\.(\d+))?'
+    family_replacement: 'IE'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+  - regex: 'Version/(\d+)(?:\.(\d+))?(?:\.(\d+))? Mobile/\S+ Safari/'
+    family_replacement: 'Mobile Safari'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+  - regex: 'Version/(\d+)(?:\.(\d+))?(?:\.(\d+))?.* Safari/'
+    family_replacement: 'Safari'
+    v1_replacement: '$1'
+    v2_replacement: '$2'
+    v3_replacement: '$3'
+
+os_parsers:
+  - regex: '(Windows Phone)(?: OS)? (\d+)\.(\d+)'
+  - regex: 'Windows NT 10\.0'
+    os_replacement: 'Windows'
+    os_v1_replacement: '10'
+  - regex: 'Windows NT 6\.3'
+    os_replacement: 'Windows'
+    os_v1_replacement: '8'
+    os_v2_replacement: '1'
+  - regex: 'Windows NT 6\.2'
+    os_replacement: 'Windows'
+    os_v1_replacement: '8'
+  - regex: 'Windows NT 6\.1'
+    os_replacement: 'Windows'
+    os_v1_replacement: '7'
+  - regex: 'Windows NT 6\.0'
+    os_replacement: 'Windows'
+    os_v1_replacement: 'Vista'
+  - regex: 'Windows NT 5\.[12]'
+    os_replacement: 'Windows'
+    os_v1_replacement: 'XP'
+  - regex: '(Android)[ /-](\d+)(?:\.(\d+))?(?:\.(\d+))?'
+  - regex: '(?:iPhone|iPad|iPod).*OS (\d+)_(\d+)(?:_(\d+))?'
+    os_replacement: 'iOS'
+    os_v1_replacement: '$1'
+    os_v2_replacement: '$2'
+    os_v3_replacement: '$3'
+  - regex: 'CrOS \S+ (\d+)\.(\d+)(?:\.(\d+))?'
+    os_replacement: 'Chrome OS'
+    os_v1_replacement: '$1'
+    os_v2_replacement: '$2'
+    os_v3_replacement: '$3'
+  - regex: '(Mac OS X) (\d+)[_.](\d+)(?:[_.](\d+))?'
+  - regex: '(Ubuntu|Fedora|Debian|CentOS)'
+  - regex: '(FreeBSD|OpenBSD|NetBSD)'
+  - regex: '(Linux)'
+
+device_parsers:
+  - regex: '(bot|crawler|spider|crawl|slurp)'
+    regex_flag: 'i'
+    device_replacement: 'Spider'
+    brand_replacement: 'Spider'
+    model_replacement: 'Desktop'
+  - regex: '(iPhone|iPad|iPod)'
+    brand_replacement: 'Apple'
+  - regex: 'Android [\d.]+;(?: [^;]+;)? (SM-[A-Z0-9]+)'
+    device_replacement: 'Samsung $1'
+    brand_replacement: 'Samsung'
+  - regex: 'Android [\d.]+;(?: [^;]+;)? (Pixel[^;)]*?)(?: Build/|\))'
+    brand_replacement: 'Google'
+  - regex: 'Android [\d.]+;(?: [a-z]{2}[-_][a-zA-Z]{2};)? ([^;)]+?)(?: Build/|\))'
+  - regex: '(Macintosh)'
+    device_replacement: 'Mac'
+    brand_replacement: 'Apple'
+    model_replacement: 'Mac'
+`
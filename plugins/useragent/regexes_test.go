@@ -0,0 +1,127 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func RegexesSpec(c gs.Context) {
+	c.Specify("The regexes.yaml parser", func() {
+		c.Specify("parses quoted and plain values and skips comments", func() {
+			set, skipped, err := parseRegexes([]byte(`
+# Comment
+user_agent_parsers:
+  # Another comment
+  - regex: '(Foo)/(\d+)\.(\d+)'
+  - regex: "(B\\w+) it''s"
+    family_replacement: Bar $1 # trailing comment
+    v1_replacement: '9'
+
+other_section:
+  - regex: 'ignored'
+`))
+			c.Assume(err, gs.IsNil)
+			c.Expect(skipped, gs.Equals, 0)
+			c.Expect(len(set.browsers), gs.Equals, 2)
+			c.Expect(set.browsers[1].regex.String(), gs.Equals, `(B\w+) it''s`)
+
+			agent := set.parse("Foo/1.2")
+			c.Expect(agent.Browser, gs.Equals, "Foo")
+			c.Expect(agent.BrowserVersion, gs.Equals, "1.2")
+			c.Expect(agent.OS, gs.Equals, "Other")
+			agent = set.parse("Baz it''s")
+			c.Expect(agent.Browser, gs.Equals, "Bar Baz")
+			c.Expect(agent.BrowserVersion, gs.Equals, "9")
+		})
+
+		c.Specify("skips regexes Go doesn't support", func() {
+			set, skipped, err := parseRegexes([]byte(`
+os_parsers:
+  - regex: '(Foo)(?!bar)'
+  - regex: '(Foo)'
+    regex_flag: 'i'
+`))
+			c.Assume(err, gs.IsNil)
+			c.Expect(skipped, gs.Equals, 1)
+			c.Expect(set.parse("FOO").OS, gs.Equals, "FOO")
+		})
+
+		c.Specify("rejects malformed files", func() {
+			_, _, err := parseRegexes([]byte("user_agent_parsers:\n  regex: 'x'\n"))
+			c.Expect(err, gs.Not(gs.IsNil))
+			_, _, err = parseRegexes([]byte("user_agent_parsers:\n  - regex: 'x\n"))
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+	})
+
+	c.Specify("The bundled regexes", func() {
+		set, skipped, err := parseRegexes([]byte(defaultRegexes))
+		c.Assume(err, gs.IsNil)
+		c.Expect(skipped, gs.Equals, 0)
+
+		cases := []struct {
+			ua   string
+			want Agent
+		}{
+			{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 " +
+					"(KHTML, like Gecko) Chrome/58.0.3029.110 Safari/537.36 Edge/16.16299",
+				Agent{"Edge", "16.16299", "Windows", "10", "Other", "", ""},
+			},
+			{
+				"Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 " +
+					"(KHTML, like Gecko) Chrome/49.0.2623.112 Safari/537.36",
+				Agent{"Chrome", "49.0.2623", "Windows", "7", "Other", "", ""},
+			},
+			{
+				"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_11_6) AppleWebKit/601.7.7 " +
+					"(KHTML, like Gecko) Version/9.1.2 Safari/601.7.7",
+				Agent{"Safari", "9.1.2", "Mac OS X", "10.11.6", "Mac", "Apple", "Mac"},
+			},
+			{
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 9_3_2 like Mac OS X) " +
+					"AppleWebKit/601.1.46 (KHTML, like Gecko) Version/9.0 " +
+					"Mobile/13F69 Safari/601.1",
+				Agent{"Mobile Safari", "9.0", "iOS", "9.3.2", "iPhone", "Apple", "iPhone"},
+			},
+			{
+				"Mozilla/5.0 (Linux; Android 6.0.1; SM-G920V Build/MMB29K) " +
+					"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.98 " +
+					"Mobile Safari/537.36",
+				Agent{"Chrome Mobile", "52.0.2743", "Android", "6.0.1",
+					"Samsung SM-G920V", "Samsung", "SM-G920V"},
+			},
+			{
+				"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:47.0) Gecko/20100101 " +
+					"Firefox/47.0",
+				Agent{"Firefox", "47.0", "Ubuntu", "", "Other", "", ""},
+			},
+			{
+				"Mozilla/5.0 (compatible; Googlebot/2.1; " +
+					"+http://www.google.com/bot.html)",
+				Agent{"Googlebot", "2.1", "Other", "", "Spider", "Spider", "Desktop"},
+			},
+			{
+				"curl/7.47.0",
+				Agent{"curl", "7.47.0", "Other", "", "Other", "", ""},
+			},
+			{
+				"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+				Agent{"IE", "11.0", "Windows", "7", "Other", "", ""},
+			},
+		}
+		for _, tc := range cases {
+			c.Expect(*set.parse(tc.ua), gs.Equals, tc.want)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+)
+
+type UserAgentDecoderConfig struct {
+	// Field holding the user-agent string.
+	SourceField string `toml:"source_field"`
+	// Prefix of the names of the fields added to the message.
+	FieldPrefix string `toml:"field_prefix"`
+	// Path of a regexes.yaml file in the ua-parser format, relative to the
+	// share_dir unless absolute. The bundled rules are used if empty.
+	RegexesFile string `toml:"regexes_file"`
+	// Number of distinct user-agent strings whose parsing results are
+	// cached, 0 disables the cache.
+	CacheSize int `toml:"cache_size"`
+}
+
+// Decoder parsing a user-agent field into browser, OS and device fields.
+type UserAgentDecoder struct {
+	conf    *UserAgentDecoderConfig
+	pConfig *PipelineConfig
+	dRunner DecoderRunner
+	regexes *regexSet
+	cache   map[string]*Agent
+}
+
+func (ud *UserAgentDecoder) SetPipelineConfig(pConfig *PipelineConfig) {
+	ud.pConfig = pConfig
+}
+
+func (ud *UserAgentDecoder) SetDecoderRunner(dr DecoderRunner) {
+	ud.dRunner = dr
+}
+
+func (ud *UserAgentDecoder) ConfigStruct() interface{} {
+	return &UserAgentDecoderConfig{
+		SourceField: "http_user_agent",
+		FieldPrefix: "ua_",
+		CacheSize:   10000,
+	}
+}
+
+func (ud *UserAgentDecoder) Init(config interface{}) (err error) {
+	ud.conf = config.(*UserAgentDecoderConfig)
+	if ud.conf.SourceField == "" {
+		return errors.New("`source_field` must be specified")
+	}
+
+	var skipped int
+	if ud.conf.RegexesFile == "" {
+		ud.regexes, _, err = parseRegexes([]byte(defaultRegexes))
+		if err != nil {
+			return fmt.Errorf("can't parse bundled regexes: %s", err)
+		}
+	} else {
+		path := ud.conf.RegexesFile
+		if ud.pConfig != nil {
+			path = ud.pConfig.Globals.PrependShareDir(path)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("can't read regexes file: %s", err)
+		}
+		if ud.regexes, skipped, err = parseRegexes(data); err != nil {
+			return fmt.Errorf("can't parse regexes file %s: %s", path, err)
+		}
+	}
+	if skipped > 0 && ud.dRunner != nil {
+		ud.dRunner.LogMessage(fmt.Sprintf(
+			"skipped %d rules with regexes Go doesn't support", skipped))
+	}
+	if ud.conf.CacheSize > 0 {
+		ud.cache = make(map[string]*Agent)
+	}
+	return nil
+}
+
+// Parses a user-agent string, caching the result. The cache is emptied
+// once full, which is cheaper than tracking usage and keeps the frequent
+// strings of a steady stream of traffic cached most of the time.
+func (ud *UserAgentDecoder) Parse(ua string) *Agent {
+	if ud.cache == nil {
+		return ud.regexes.parse(ua)
+	}
+	if agent, ok := ud.cache[ua]; ok {
+		return agent
+	}
+	if len(ud.cache) >= ud.conf.CacheSize {
+		ud.cache = make(map[string]*Agent)
+	}
+	agent := ud.regexes.parse(ua)
+	ud.cache[ua] = agent
+	return agent
+}
+
+func (ud *UserAgentDecoder) Decode(pack *PipelinePack) (packs []*PipelinePack,
+	err error) {
+
+	packs = []*PipelinePack{pack}
+	value, _ := pack.Message.GetFieldValue(ud.conf.SourceField)
+	ua, ok := value.(string)
+	if !ok || ua == "" {
+		// Not every message has a user-agent, leave it untouched.
+		return
+	}
+	agent := ud.Parse(ua)
+	fields := []struct{ name, value string }{
+		{"browser", agent.Browser},
+		{"browser_version", agent.BrowserVersion},
+		{"os", agent.OS},
+		{"os_version", agent.OSVersion},
+		{"device", agent.Device},
+		{"device_brand", agent.DeviceBrand},
+		{"device_model", agent.DeviceModel},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			message.NewStringField(pack.Message, ud.conf.FieldPrefix+f.name, f.value)
+		}
+	}
+	return
+}
+
+func init() {
+	RegisterPlugin("UserAgentDecoder", func() interface{} {
+		return new(UserAgentDecoder)
+	})
+}
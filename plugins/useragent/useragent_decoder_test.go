@@ -0,0 +1,116 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package useragent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mozilla-services/heka/message"
+	. "github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func UserAgentDecoderSpec(c gs.Context) {
+	decoder := new(UserAgentDecoder)
+	conf := decoder.ConfigStruct().(*UserAgentDecoderConfig)
+	supply := make(chan *PipelinePack, 1)
+	pack := NewPipelinePack(supply)
+
+	field := func(name string) interface{} {
+		val, _ := pack.Message.GetFieldValue(name)
+		return val
+	}
+	decode := func(ua string) ([]*PipelinePack, error) {
+		pack.Zero()
+		if ua != "" {
+			message.NewStringField(pack.Message, "http_user_agent", ua)
+		}
+		return decoder.Decode(pack)
+	}
+
+	c.Specify("A UserAgentDecoder", func() {
+		c.Specify("requires a source field", func() {
+			conf.SourceField = ""
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+
+		c.Specify("with the bundled regexes", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			c.Specify("adds the browser, OS and device fields", func() {
+				packs, err := decode("Mozilla/5.0 (iPhone; CPU iPhone OS 9_3_2 like " +
+					"Mac OS X) AppleWebKit/601.1.46 (KHTML, like Gecko) " +
+					"Version/9.0 Mobile/13F69 Safari/601.1")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(field("ua_browser"), gs.Equals, "Mobile Safari")
+				c.Expect(field("ua_browser_version"), gs.Equals, "9.0")
+				c.Expect(field("ua_os"), gs.Equals, "iOS")
+				c.Expect(field("ua_os_version"), gs.Equals, "9.3.2")
+				c.Expect(field("ua_device"), gs.Equals, "iPhone")
+				c.Expect(field("ua_device_brand"), gs.Equals, "Apple")
+				c.Expect(field("ua_device_model"), gs.Equals, "iPhone")
+			})
+
+			c.Specify("omits empty values", func() {
+				_, err := decode("SomeClient")
+				c.Expect(err, gs.IsNil)
+				c.Expect(field("ua_browser"), gs.Equals, "Other")
+				c.Expect(field("ua_browser_version"), gs.IsNil)
+				c.Expect(field("ua_device_brand"), gs.IsNil)
+			})
+
+			c.Specify("leaves messages without a user-agent untouched", func() {
+				packs, err := decode("")
+				c.Expect(err, gs.IsNil)
+				c.Expect(len(packs), gs.Equals, 1)
+				c.Expect(len(pack.Message.Fields), gs.Equals, 0)
+			})
+
+			c.Specify("caches parsing results", func() {
+				ua := "curl/7.47.0"
+				c.Expect(decoder.Parse(ua), gs.Equals, decoder.Parse(ua))
+				c.Expect(len(decoder.cache), gs.Equals, 1)
+			})
+		})
+
+		c.Specify("with a regexes file", func() {
+			dir, err := ioutil.TempDir("", "useragent")
+			c.Assume(err, gs.IsNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "regexes.yaml")
+			err = ioutil.WriteFile(path, []byte(`
+user_agent_parsers:
+  - regex: '(MyApp)/(\d+)\.(\d+)'
+`), 0644)
+			c.Assume(err, gs.IsNil)
+			conf.RegexesFile = path
+			conf.FieldPrefix = "agent."
+			err = decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			_, err = decode("MyApp/3.4 (Linux)")
+			c.Expect(err, gs.IsNil)
+			c.Expect(field("agent.browser"), gs.Equals, "MyApp")
+			c.Expect(field("agent.browser_version"), gs.Equals, "3.4")
+			c.Expect(field("agent.os"), gs.Equals, "Other")
+		})
+
+		c.Specify("fails with a missing regexes file", func() {
+			conf.RegexesFile = "/does/not/exist.yaml"
+			c.Expect(decoder.Init(conf), gs.Not(gs.IsNil))
+		})
+	})
+}
@@ -0,0 +1,40 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+// Package zstd registers the "zstd" compression codec. It wraps the zstd C
+// library, so it's kept out of the pipeline package, which stays buildable
+// without cgo.
+package zstd
+
+import (
+	"io"
+
+	"github.com/DataDog/zstd"
+	"github.com/mozilla-services/heka/pipeline"
+)
+
+type ZstdCodec struct{}
+
+func (c ZstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w), nil
+}
+
+func (c ZstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zstd.NewReader(r), nil
+}
+
+func (c ZstdCodec) Magic() []byte {
+	return []byte{0x28, 0xb5, 0x2f, 0xfd}
+}
+
+func init() {
+	pipeline.RegisterCompressionCodec("zstd", ZstdCodec{})
+}
@@ -0,0 +1,48 @@
+/***** BEGIN LICENSE BLOCK *****
+# This Source Code Form is subject to the terms of the Mozilla Public
+# License, v. 2.0. If a copy of the MPL was not distributed with this file,
+# You can obtain one at http://mozilla.org/MPL/2.0/.
+#
+# The Initial Developer of the Original Code is the Mozilla Foundation.
+# Portions created by the Initial Developer are Copyright (C) 2016
+# the Initial Developer. All Rights Reserved.
+#
+# ***** END LICENSE BLOCK *****/
+
+package zstd
+
+import (
+	"bytes"
+
+	"github.com/mozilla-services/heka/pipeline"
+	gs "github.com/rafrombrc/gospec/src/gospec"
+)
+
+func ZstdCodecSpec(c gs.Context) {
+	data := bytes.Repeat([]byte("compress me, please. "), 100)
+
+	c.Specify("The zstd codec", func() {
+		codec, err := pipeline.GetCompressionCodec("zstd")
+		c.Assume(err, gs.IsNil)
+		c.Assume(codec, gs.Equals, ZstdCodec{})
+
+		c.Specify("round trips concatenated streams", func() {
+			compressed, err := pipeline.Compress(codec, data)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(compressed) < len(data), gs.IsTrue)
+
+			compressed = append(compressed, compressed...)
+			decompressed, err := pipeline.Decompress(codec, compressed)
+			c.Expect(err, gs.IsNil)
+			c.Expect(bytes.Equal(decompressed, append(data, data...)), gs.IsTrue)
+		})
+
+		c.Specify("is detected by its magic number", func() {
+			compressed, err := pipeline.Compress(codec, data)
+			c.Assume(err, gs.IsNil)
+			name, detected := pipeline.DetectCompressionCodec(compressed)
+			c.Expect(name, gs.Equals, "zstd")
+			c.Expect(detected, gs.Equals, codec)
+		})
+	})
+}
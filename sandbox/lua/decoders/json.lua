@@ -71,6 +71,39 @@ Config:
     String specifying the character to use between keys during flattening.
     For example: '{"top":{"nested":1}}' would decode to '{"top.nested":1}"
 
+- flatten_arrays (bool, optional, default true)
+    Whether arrays are flattened like objects, keyed by their 1-based
+    index, e.g. '{"tags":["a","b"]}' would decode to
+    '{"tags.1":"a","tags.2":"b"}'. If false arrays are stringified via json
+    encoding instead, '{"tags":"[\"a\",\"b\"]"}'.
+
+- maximum_keys (uint, optional, default nil)
+    Maximum number of fields a document may be flattened to. Documents with
+    more fail to decode, protecting the pipeline from pathological input.
+
+- split_arrays (bool, optional, default false)
+    Whether a payload holding a JSON array of objects is decoded into one
+    message per object rather than a single message. Each message's
+    Payload, if kept, is the JSON encoding of its object. For example,
+    '[{"id":1},{"id":2}]' would decode to two messages, one with an "id"
+    field of 1 and one with an "id" field of 2.
+
+- mappings (string, optional, default nil)
+    Space separated list of `key:target[:type]` entries mapping JSON keys to
+    message headers or fields, converting their values to the given type.
+    Keys of nested values are the flattened key names, e.g. "top.nested".
+    A target of Payload, Uuid, Type, Logger, Hostname, Severity, EnvVersion,
+    Pid or Timestamp sets that header, any other target names the field the
+    value is stored in. The type is one of "int", "float", "bool", "string"
+    or "timestamp" (nanoseconds since the epoch, parsed with the
+    timestamp_format if the value is a string); it defaults to the header's
+    type, or the value's JSON type for fields. Severity also accepts syslog
+    severity names such as "error" or "warning". Mappings take precedence
+    over map_fields, and a value that can't be converted fails the decode.
+    For example, "level:Severity svc:Logger req.bytes:bytes:int" with
+    '{"level":"error","svc":"api","req":{"bytes":"1413"}}' sets the Severity
+    to 3 and the Logger to "api", and adds an integer "bytes" field.
+
 .. code-block:: javascript
 
     {
@@ -121,11 +154,14 @@ Config:
 --]]
 
 require "cjson"
-local util = require("util")
 local dt = require "date_time"
 
 local max_depth = read_config("maximum_depth")
-local separator = read_config("separator")
+local separator = read_config("separator") or "."
+local flatten_arrays = read_config("flatten_arrays")
+if flatten_arrays == nil then flatten_arrays = true end
+local max_keys = read_config("maximum_keys")
+local split_arrays = read_config("split_arrays")
 local map_fields = read_config("map_fields")
 local payload_keep = read_config("payload_keep")
 local timestamp_format = read_config("timestamp_format")
@@ -157,10 +193,85 @@ local field_type_map = {
     Pid        = "number",
 }
 
+local header_types = {
+    Payload    = "string",
+    Uuid       = "string",
+    Type       = "string",
+    Logger     = "string",
+    Hostname   = "string",
+    Severity   = "int",
+    EnvVersion = "int",
+    Pid        = "int",
+    Timestamp  = "timestamp"
+}
+
+local severities = {
+    emerg = 0, emergency = 0, alert = 1, crit = 2, critical = 2, err = 3,
+    error = 3, warn = 4, warning = 4, notice = 5, info = 6,
+    informational = 6, debug = 7
+}
+
+local function to_int(v)
+    if type(v) == "boolean" then return v and 1 or 0 end
+    v = tonumber(v)
+    if not v then return nil end
+    if v < 0 then return math.ceil(v) end
+    return math.floor(v)
+end
+
+local function to_timestamp(v)
+    if type(v) == "string" and tsg then
+        local ts = tsg:match(v)
+        if not ts then return nil end
+        return dt.time_to_ns(ts)
+    end
+    return to_int(v)
+end
+
+local converters = {
+    int = to_int,
+    float = function(v)
+        if type(v) == "boolean" then return v and 1 or 0 end
+        return tonumber(v)
+    end,
+    bool = function(v)
+        if type(v) == "boolean" then return v end
+        if type(v) == "number" then return v ~= 0 end
+        if v == "true" or v == "1" then return true end
+        if v == "false" or v == "0" then return false end
+    end,
+    string = function(v)
+        if type(v) == "table" then return nil end
+        return tostring(v)
+    end,
+    timestamp = to_timestamp
+}
+
+local mappings = {}
+local mappings_config = read_config("mappings")
+if mappings_config then
+    for entry in string.gmatch(mappings_config, "%S+") do
+        local key, target, typ = string.match(entry, "^([^:]+):([^:]+):?([^:]*)$")
+        if not key then error("invalid mapping: " .. entry) end
+        if typ == "" then typ = header_types[target] end
+        if typ and not converters[typ] then
+            error("invalid mapping type: " .. entry)
+        end
+        if header_types[target] and typ ~= header_types[target] then
+            error(string.format("%s must be mapped as %s: %s", target,
+                                header_types[target], entry))
+        end
+        mappings[#mappings+1] = {key = key, target = target, type = typ}
+    end
+end
+mappings_config = nil
+
+local msg_type = read_config("type") or "json"
+
 local msg = {
     Payload    = nil,
     Uuid       = nil,
-    Type       = read_config("type") or "json",
+    Type       = msg_type,
     Logger     = nil,
     Hostname   = nil,
     Severity   = nil,
@@ -170,13 +281,49 @@ local msg = {
     Fields     = nil
 }
 
-function process_message()
-    local ok, json = pcall(cjson.decode, read_message("Payload"))
-    if not ok then return -1, "Failed to decode JSON." end
+local function is_array(t)
+    local n = #t
+    if n == 0 then return false end
+    for k in pairs(t) do
+        if type(k) ~= "number" or k < 1 or k > n or k % 1 ~= 0 then
+            return false
+        end
+    end
+    return true
+end
+
+-- Flattens the nested tables in t into fields, returning the number of
+-- fields, or nil if there would be more than max_keys.
+local function flatten(t, fields, prefix, depth, count)
+    for k, v in pairs(t) do
+        local key = k
+        if prefix then key = prefix .. separator .. k end
+        if type(v) == "table" then
+            if (max_depth and depth >= max_depth)
+            or (not flatten_arrays and is_array(v)) then
+                count = count + 1
+                fields[key] = cjson.encode(v)
+            else
+                count = flatten(v, fields, key, depth + 1, count)
+                if not count then return nil end
+            end
+        elseif type(v) ~= "userdata" then -- skip JSON nulls
+            count = count + 1
+            fields[key] = v
+        end
+        if max_keys and count > max_keys then return nil end
+    end
+    return count
+end
+
+-- Decodes the JSON object json, read from payload, into a message and
+-- injects it.
+local function decode_object(json, payload)
+    if type(json) ~= "table" then return -1, "JSON value isn't an object." end
 
     -- keep payload, or not
     if payload_keep then
-        msg.Payload = read_message("Payload")
+        msg.Payload = payload
     end
 
     -- map fields
@@ -209,8 +356,42 @@ function process_message()
 
     -- flatten and assign remaining fields to heka fields
     local flat = {}
-    if not pcall(util.table_to_fields, json, flat, nil, separator, max_depth) then
-        return -1, "Failed to flatten message."
+    local ok, count = pcall(flatten, json, flat, nil, 1, 0)
+    if not ok then return -1, "Failed to flatten message." end
+    if not count then return -1, "Too many keys." end
+
+    -- apply mappings to the flattened fields
+    for _, m in ipairs(mappings) do
+        local v = flat[m.key]
+        flat[m.key] = nil
+        if v == nil then
+            -- avoid leaking values from last decode
+            if m.target == "Type" then
+                msg.Type = msg_type
+            elseif header_types[m.target] then
+                msg[m.target] = nil
+            end
+        else
+            local converted
+            if m.target == "Severity" and type(v) == "string" then
+                converted = severities[string.lower(v)]
+            end
+            if converted == nil and m.type then
+                converted = converters[m.type](v)
+            elseif converted == nil then
+                converted = v
+            end
+            if converted == nil then
+                return -1, string.format("Failed to convert '%s' to %s.", m.key, m.type)
+            end
+            if header_types[m.target] then
+                msg[m.target] = converted
+            elseif m.type == "int" or m.type == "timestamp" then
+                flat[m.target] = {value = converted, value_type = 2}
+            else
+                flat[m.target] = converted
+            end
+        end
     end
 
     msg.Fields = flat
@@ -221,3 +402,18 @@ function process_message()
 
     return 0
 end
+
+function process_message()
+    local payload = read_message("Payload")
+    local ok, json = pcall(cjson.decode, payload)
+    if not ok then return -1, "Failed to decode JSON." end
+
+    if not (split_arrays and type(json) == "table" and is_array(json)) then
+        return decode_object(json, payload)
+    end
+    for _, object in ipairs(json) do
+        local status, err = decode_object(object, cjson.encode(object))
+        if status ~= 0 then return status, err end
+    end
+    return 0
+end
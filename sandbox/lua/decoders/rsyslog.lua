@@ -13,6 +13,18 @@ Config:
     The 'template' configuration string from rsyslog.conf.
     http://rsyslog-5-8-6-doc.neocities.org/rsyslog_conf_templates.html
 
+    The name of one of rsyslog's built-in templates can be given instead:
+    "RSYSLOG_TraditionalFileFormat", "RSYSLOG_FileFormat",
+    "RSYSLOG_TraditionalForwardFormat", "RSYSLOG_ForwardFormat" or
+    "RSYSLOG_SyslogProtocol23Format". So can a whole template statement
+    copied from rsyslog.conf, either the legacy
+    `$template Name,"<string>"` form or the
+    `template(name="Name" type="string" string="<string>")` form, in which
+    case the template string is taken from it.
+
+- type (string, optional, defaults to the built-in template's name)
+    Sets the message 'Type' header.
+
 - tz (string, optional, defaults to UTC)
     If your rsyslog timestamp field in the template does not carry zone offset information, you may set an offset
     to be applied to your events here. Typically this would be used with the "Traditional" rsyslog formats.
@@ -33,6 +45,14 @@ Config:
     template = '%TIMESTAMP% %HOSTNAME% %syslogtag%%msg:::sp-if-no-1st-sp%%msg:::drop-last-lf%\n'
     tz = "America/Los_Angeles"
 
+Or, equivalently:
+
+.. code-block:: ini
+
+    [RsyslogDecoder.config]
+    template = "RSYSLOG_TraditionalFileFormat"
+    tz = "America/Los_Angeles"
+
 *Example Heka Message*
 
 :Timestamp: 2014-02-10 12:58:58 -0800 PST
@@ -54,6 +74,55 @@ local template = read_config("template")
 local msg_type = read_config("type")
 local hostname_keep = read_config("hostname_keep")
 
+-- rsyslog's built-in templates, by name.
+local builtin_templates = {
+    RSYSLOG_TraditionalFileFormat = "%TIMESTAMP% %HOSTNAME% %syslogtag%%msg:::sp-if-no-1st-sp%%msg:::drop-last-lf%\n",
+    RSYSLOG_FileFormat = "%TIMESTAMP:::date-rfc3339% %HOSTNAME% %syslogtag%%msg:::sp-if-no-1st-sp%%msg:::drop-last-lf%\n",
+    RSYSLOG_TraditionalForwardFormat = "<%PRI%>%TIMESTAMP% %HOSTNAME% %syslogtag:1:32%%msg:::sp-if-no-1st-sp%%msg%",
+    RSYSLOG_ForwardFormat = "<%PRI%>%TIMESTAMP:::date-rfc3339% %HOSTNAME% %syslogtag:1:32%%msg:::sp-if-no-1st-sp%%msg%",
+    RSYSLOG_SyslogProtocol23Format = "<%PRI%>1 %TIMESTAMP:::date-rfc3339% %HOSTNAME% %APP-NAME% %PROCID% %MSGID% %STRUCTURED-DATA% %msg%\n"
+}
+
+-- rsyslog.conf string escapes.
+local escapes = {n = "\n", r = "\r", t = "\t", ["\\"] = "\\", ['"'] = '"'}
+
+-- Returns the unescaped contents of the double quoted string starting at
+-- position start of s, or nil if it isn't terminated.
+local function unquote(s, start)
+    local parts = {}
+    local i = start
+    while i <= #s do
+        local c = string.sub(s, i, i)
+        if c == '"' then return table.concat(parts) end
+        if c == "\\" then
+            i = i + 1
+            c = string.sub(s, i, i)
+            c = escapes[c] or "\\" .. c
+        end
+        parts[#parts+1] = c
+        i = i + 1
+    end
+end
+
+-- Returns the template string named or defined by t.
+local function template_string(t)
+    if builtin_templates[t] then
+        msg_type = msg_type or t
+        return builtin_templates[t]
+    end
+    local _, stop = string.find(t, '^%s*%$template%s+[^,]+,%s*"')
+    if not stop then
+        _, stop = string.find(t, '^%s*template%s*%(.-string%s*=%s*"')
+    end
+    if not stop then return t end
+    local str = unquote(t, stop + 1)
+    if not str then error("unterminated template string: " .. t) end
+    return str
+end
+
+if not template then error("template must be specified") end
+template = template_string(template)
+
 local msg = {
 Timestamp   = nil,
 Type        = msg_type,
@@ -170,6 +170,31 @@ int read_config(lua_State* lua)
     return 1;
 }
 
+////////////////////////////////////////////////////////////////////////////////
+int seen(lua_State* lua)
+{
+    void* luserdata = lua_touserdata(lua, lua_upvalueindex(1));
+    if (NULL == luserdata) {
+        luaL_error(lua, "seen() invalid lightuserdata");
+    }
+    lua_sandbox* lsb = (lua_sandbox*)luserdata;
+
+    if (lua_gettop(lua) != 2) {
+        luaL_error(lua, "seen() must have two arguments");
+    }
+    const char* name = luaL_checkstring(lua, 1);
+    size_t len;
+    const char* key = luaL_checklstring(lua, 2, &len);
+
+    int result = go_lua_seen(lsb_get_parent(lsb), (char*)name, (char*)key,
+                             (int)len);
+    if (result < 0) {
+        luaL_error(lua, "seen() unknown filter '%s'", name);
+    }
+    lua_pushboolean(lua, result);
+    return 1;
+}
+
 ////////////////////////////////////////////////////////////////////////////////
 int read_message(lua_State* lua)
 {
@@ -485,6 +510,7 @@ int sandbox_init(lua_sandbox* lsb, const char* data_file, const char* plugin_typ
     int add_to_payload = 0;
 
     lsb_add_function(lsb, &read_config, "read_config");
+    lsb_add_function(lsb, &seen, "seen");
     lsb_add_function(lsb, &lsb_decode_protobuf, "decode_message");
 
     if (strcmp(plugin_type, "input") == 0) {
@@ -50,6 +50,16 @@ int timer_event(lua_sandbox* lsb, long long ns);
 */
 int read_config(lua_State* lua);
 
+/**
+* Adds a key to a named seen filter, such as a BloomFilter resource, and
+* returns whether the key had been seen before.
+*
+* @param lua Pointer to the Lua state.
+*
+* @return int Returns one value on the stack.
+*/
+int seen(lua_State* lua);
+
 /**
 * Reads a data field from a Heka message and returns the value.
 *
@@ -0,0 +1,8 @@
+function process_message ()
+    inject_payload("txt", "", "message one")
+    inject_payload("txt", "", "message two")
+
+    local hm = {Payload = "message three"}
+    inject_message(hm)
+    return -1, "failed after injecting"
+end
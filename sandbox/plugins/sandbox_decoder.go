@@ -46,6 +46,8 @@ type SandboxDecoder struct {
 	sample                 bool
 	pack                   *pipeline.PipelinePack
 	packs                  []*pipeline.PipelinePack
+	maxHeld                int  // Injected packs held before delivering them.
+	delivered              bool // Packs of the current record were delivered.
 	dRunner                pipeline.DecoderRunner
 	name                   string
 	tz                     *time.Location
@@ -68,12 +70,25 @@ func (s *SandboxDecoder) SetPipelineConfig(pConfig *pipeline.PipelineConfig) {
 	s.pConfig = pConfig
 }
 
+// Satisfies the `pipeline.UsesFiles` interface so a config reload replaces
+// the decoder when its script changes.
+func (s *SandboxDecoder) FilesUsed(config interface{}) []string {
+	filename := config.(*SandboxConfig).ScriptFilename
+	return []string{s.pConfig.Globals.PrependShareDir(filename)}
+}
+
 func (s *SandboxDecoder) Init(config interface{}) (err error) {
 	s.sbc = config.(*SandboxConfig)
 	globals := s.pConfig.Globals
 	s.sbc.ScriptFilename = globals.PrependShareDir(s.sbc.ScriptFilename)
 	s.sbc.PluginType = "decoder"
 	s.sampleDenominator = globals.SampleDenominator
+	// Every held pack is one fewer in the input supply, so a record
+	// injecting more messages than the supply holds would block forever.
+	s.maxHeld = globals.PoolSize / 2
+	if s.maxHeld < 1 {
+		s.maxHeld = 1
+	}
 
 	s.tz = time.UTC
 	if tz, ok := s.sbc.Config["tz"]; ok {
@@ -253,6 +268,18 @@ func (s *SandboxDecoder) SetDecoderRunner(dr pipeline.DecoderRunner) {
 		}
 		s.packs = append(s.packs, s.pack)
 		s.pack = nil
+		if len(s.packs) >= s.maxHeld {
+			if original == nil || original == s.packs[0].Message {
+				// The first pack goes out with the others, keep its headers.
+				original = new(message.Message)
+				copyMessageHeaders(original, s.packs[0].Message)
+			}
+			for _, p := range s.packs {
+				dr.Deliver(p)
+			}
+			s.packs = s.packs[:0]
+			s.delivered = true
+		}
 		return 0
 	})
 }
@@ -288,6 +315,7 @@ func (s *SandboxDecoder) Decode(pack *pipeline.PipelinePack) (packs []*pipeline.
 		return
 	}
 	s.pack = pack
+	s.delivered = false
 	atomic.AddInt64(&s.processMessageCount, 1)
 
 	var startTime time.Time
@@ -316,6 +344,15 @@ func (s *SandboxDecoder) Decode(pack *pipeline.PipelinePack) (packs []*pipeline.
 		} else {
 			err = fmt.Errorf("Failed after a successful inject_message call: %s", s.sb.LastError())
 		}
+		if s.delivered {
+			// The original pack is already on its way, so the runner can only
+			// count the failure.
+			for _, p := range s.packs {
+				p.Recycle(nil)
+			}
+			s.packs = nil
+			return nil, pipeline.NewPartialDecodeError(err.Error())
+		}
 		if len(s.packs) > 1 {
 			for _, p := range s.packs[1:] {
 				p.Recycle(nil)
@@ -330,6 +367,11 @@ func (s *SandboxDecoder) Decode(pack *pipeline.PipelinePack) (packs []*pipeline.
 		s.pack = nil
 	} else {
 		packs = s.packs
+		if packs == nil && s.delivered {
+			// Everything was delivered already, nothing is left to pass
+			// through.
+			packs = []*pipeline.PipelinePack{}
+		}
 	}
 	s.packs = nil
 	return packs, err
@@ -30,6 +30,13 @@ func DecoderSpec(c gs.Context) {
 		pack := pipeline.NewPipelinePack(supply)
 		dRunner := pm.NewMockDecoderRunner(ctrl)
 
+		c.Specify("reports its script as a used file", func() {
+			conf.ScriptFilename = "lua_decoders/decoder.lua"
+			expected := pConfig.Globals.PrependShareDir(conf.ScriptFilename)
+			c.Expect(decoder.FilesUsed(conf), gs.ContainsExactly,
+				[]string{expected})
+		})
+
 		c.Specify("that uses lpeg and inject_message", func() {
 			dRunner.EXPECT().Name().Return("serialize")
 			conf.ScriptFilename = "../lua/testsupport/decoder.lua"
@@ -296,6 +303,57 @@ func DecoderSpec(c gs.Context) {
 			}
 			decoder.Shutdown()
 		})
+
+		c.Specify("delivers packs as it goes when the supply is small", func() {
+			pConfig.Globals.PoolSize = 2
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			var delivered []*pipeline.PipelinePack
+			deliver := func(p *pipeline.PipelinePack) {
+				delivered = append(delivered, p)
+			}
+			gomock.InOrder(
+				dRunner.EXPECT().Deliver(pack).Do(deliver),
+				dRunner.EXPECT().NewPack().Return(pack1),
+				dRunner.EXPECT().Deliver(pack1).Do(deliver),
+				dRunner.EXPECT().NewPack().Return(pack2),
+				dRunner.EXPECT().Deliver(pack2).Do(deliver),
+			)
+			packs, err := decoder.Decode(pack)
+			c.Expect(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 0)
+			c.Expect(packs, gs.Not(gs.IsNil))
+			c.Expect(len(delivered), gs.Equals, 3)
+			c.Expect(pack2.Message.GetPayload(), gs.Equals, "message three")
+			c.Expect(pack2.Message.GetHostname(), gs.Equals, "my.host.name")
+			decoder.Shutdown()
+		})
+
+		c.Specify("reports a failure after delivering packs as a partial decode", func() {
+			pConfig.Globals.PoolSize = 2
+			conf.ScriptFilename = "../lua/testsupport/multipack_failing_decoder.lua"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			var delivered []*pipeline.PipelinePack
+			deliver := func(p *pipeline.PipelinePack) {
+				delivered = append(delivered, p)
+			}
+			gomock.InOrder(
+				dRunner.EXPECT().Deliver(pack).Do(deliver),
+				dRunner.EXPECT().NewPack().Return(pack1),
+				dRunner.EXPECT().Deliver(pack1).Do(deliver),
+				dRunner.EXPECT().NewPack().Return(pack2),
+				dRunner.EXPECT().Deliver(pack2).Do(deliver),
+			)
+			packs, err := decoder.Decode(pack)
+			c.Expect(packs, gs.IsNil)
+			_, ok := err.(pipeline.PartialDecodeError)
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(len(delivered), gs.Equals, 3)
+			decoder.Shutdown()
+		})
 	})
 
 	c.Specify("JSON decoder", func() {
@@ -370,6 +428,156 @@ func DecoderSpec(c gs.Context) {
 			c.Expect(ok, gs.IsTrue)
 			c.Expect(value, gs.Equals, `{"nested2":"value"}`)
 		})
+
+		c.Specify("decodes an array into one message per object", func() {
+			conf.Config["split_arrays"] = true
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+			pack2 := pipeline.NewPipelinePack(supply)
+			dRunner.EXPECT().NewPack().Return(pack2)
+
+			payload := `[{"hostname":"one.local","msg":"first"},{"hostname":"two.local","msg":"second"}]`
+			pack.Message.SetPayload(payload)
+
+			packs, err := decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(len(packs), gs.Equals, 2)
+			c.Expect(packs[0].Message.GetHostname(), gs.Equals, "one.local")
+			c.Expect(packs[1].Message.GetHostname(), gs.Equals, "two.local")
+			value, ok := packs[1].Message.GetFieldValue("msg")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "second")
+		})
+
+		c.Specify("decodes a message w/ mappings", func() {
+			conf.Config["timestamp_format"] = "%m/%d/%Y %H:%M:%S"
+			conf.Config["mappings"] = "level:Severity svc:Logger msg:Payload " +
+				"ts:Timestamp req-bytes:bytes:int req-ok:ok:bool req-ms:ms:float " +
+				"code:code:string"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+
+			payload := `{"level":"Warning","svc":"api","msg":"request done","ts":"05/07/2014 15:12:24","req":{"bytes":"1413","ok":"true","ms":12},"code":404,"other":"value"}`
+			pack.Message.SetPayload(payload)
+
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack.Message.GetSeverity(), gs.Equals, int32(4))
+			c.Expect(pack.Message.GetLogger(), gs.Equals, "api")
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "request done")
+			c.Expect(pack.Message.GetTimestamp(), gs.Equals, int64(1399475544000000000))
+			c.Expect(pack.Message.GetType(), gs.Equals, "json")
+
+			var ok bool
+			var value interface{}
+			value, ok = pack.Message.GetFieldValue("bytes")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, int64(1413))
+
+			value, ok = pack.Message.GetFieldValue("ok")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, true)
+
+			value, ok = pack.Message.GetFieldValue("ms")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, float64(12))
+
+			value, ok = pack.Message.GetFieldValue("code")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "404")
+
+			value, ok = pack.Message.GetFieldValue("other")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "value")
+
+			_, ok = pack.Message.GetFieldValue("req-bytes")
+			c.Expect(ok, gs.IsFalse)
+			_, ok = pack.Message.GetFieldValue("level")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("fails to decode a value that can't be converted", func() {
+			conf.Config["mappings"] = "req-bytes:bytes:int"
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload(`{"req":{"bytes":"many"}}`)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("flattens arrays", func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload(`{"tags":["a","b"],"empty":null}`)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			value, ok := pack.Message.GetFieldValue("tags-1")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "a")
+			value, ok = pack.Message.GetFieldValue("tags-2")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, "b")
+			_, ok = pack.Message.GetFieldValue("empty")
+			c.Expect(ok, gs.IsFalse)
+		})
+
+		c.Specify("stringifies arrays w/o flatten_arrays", func() {
+			conf.Config["flatten_arrays"] = false
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload(`{"tags":["a","b"],"req":{"ids":[1,2]}}`)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			value, ok := pack.Message.GetFieldValue("tags")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, `["a","b"]`)
+			value, ok = pack.Message.GetFieldValue("req-ids")
+			c.Expect(ok, gs.IsTrue)
+			c.Expect(value, gs.Equals, `[1,2]`)
+		})
+
+		c.Specify("fails to decode a document w/ too many keys", func() {
+			conf.Config["maximum_keys"] = int64(2)
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+
+			dRunner := pm.NewMockDecoderRunner(ctrl)
+			dRunner.EXPECT().Name().Return("SandboxDecoder")
+			decoder.SetDecoderRunner(dRunner)
+
+			pack.Message.SetPayload(`{"a":1,"b":{"c":2,"d":3}}`)
+			_, err = decoder.Decode(pack)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
+
+		c.Specify("rejects a header mapped as the wrong type", func() {
+			conf.Config["mappings"] = "level:Severity:string"
+			err := decoder.Init(conf)
+			c.Expect(err, gs.Not(gs.IsNil))
+		})
 	})
 
 	c.Specify("Linux Cpu Stats decoder", func() {
@@ -867,6 +1075,52 @@ HugePages_Free:        0
 		})
 	})
 
+	c.Specify("rsyslog decoder with a built-in template", func() {
+		decoder := new(SandboxDecoder)
+		decoder.SetPipelineConfig(pConfig)
+		conf := decoder.ConfigStruct().(*sandbox.SandboxConfig)
+		conf.ScriptFilename = "../lua/decoders/rsyslog.lua"
+		conf.ModuleDirectory = "../lua/modules"
+		conf.MemoryLimit = 8e6
+		conf.Config = make(map[string]interface{})
+		supply := make(chan *pipeline.PipelinePack, 1)
+		pack := pipeline.NewPipelinePack(supply)
+		dRunner := pm.NewMockDecoderRunner(ctrl)
+		dRunner.EXPECT().Name().Return("SandboxDecoder")
+		data := "Feb 10 12:58:58 testhost widget[4322]: test message.\n"
+
+		decode := func() {
+			err := decoder.Init(conf)
+			c.Assume(err, gs.IsNil)
+			decoder.SetDecoderRunner(dRunner)
+			pack.Message.SetPayload(data)
+			_, err = decoder.Decode(pack)
+			c.Assume(err, gs.IsNil)
+			c.Expect(pack.Message.GetHostname(), gs.Equals, "testhost")
+			c.Expect(pack.Message.GetPid(), gs.Equals, int32(4322))
+			c.Expect(pack.Message.GetPayload(), gs.Equals, "test message.")
+			decoder.Shutdown()
+		}
+
+		c.Specify("named by the template setting", func() {
+			conf.Config["template"] = "RSYSLOG_TraditionalFileFormat"
+			decode()
+			c.Expect(pack.Message.GetType(), gs.Equals, "RSYSLOG_TraditionalFileFormat")
+		})
+
+		c.Specify("defined by a legacy template statement", func() {
+			conf.Config["template"] = `$template Traditional,"%TIMESTAMP% %HOSTNAME% %syslogtag%%msg:::sp-if-no-1st-sp%%msg:::drop-last-lf%\n"`
+			conf.Config["type"] = "Traditional"
+			decode()
+			c.Expect(pack.Message.GetType(), gs.Equals, "Traditional")
+		})
+
+		c.Specify("defined by a template object", func() {
+			conf.Config["template"] = `template(name="Traditional" type="string" string="%TIMESTAMP% %HOSTNAME% %syslogtag%%msg:::sp-if-no-1st-sp%%msg:::drop-last-lf%\n")`
+			decode()
+		})
+	})
+
 	c.Specify("mysql decoder", func() {
 		decoder := new(SandboxDecoder)
 		decoder.SetPipelineConfig(pConfig)
@@ -80,6 +80,13 @@ func (this *SandboxFilter) SetName(name string) {
 	this.name = re.ReplaceAllString(name, "_")
 }
 
+// Satisfies the `pipeline.UsesFiles` interface so a config reload replaces
+// the filter when its script changes.
+func (this *SandboxFilter) FilesUsed(config interface{}) []string {
+	filename := config.(*SandboxConfig).ScriptFilename
+	return []string{this.pConfig.Globals.PrependShareDir(filename)}
+}
+
 // Determines the script type and creates interpreter
 func (this *SandboxFilter) Init(config interface{}) (err error) {
 	if this.sb != nil {
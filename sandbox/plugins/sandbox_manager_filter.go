@@ -45,6 +45,7 @@ type SandboxManagerFilter struct {
 	instructionLimit    uint
 	outputLimit         uint
 	pConfig             *pipeline.PipelineConfig
+	acl                 *pipeline.AccessControl
 }
 
 // Config struct for `SandboxManagerFilter`.
@@ -69,6 +70,10 @@ type SandboxManagerFilterConfig struct {
 	OutputLimit uint `toml:"output_limit"`
 	// Default message matcher.
 	MessageMatcher string `toml:"message_matcher"`
+	// Name of an AccessControl resource; if set control messages are only
+	// acted upon if their signer has the "deploy" role (load) or the
+	// "operate" role (unload).
+	AclResource string `toml:"acl_resource"`
 }
 
 func (this *SandboxManagerFilter) ConfigStruct() interface{} {
@@ -104,10 +109,23 @@ func (this *SandboxManagerFilter) Init(config interface{}) (err error) {
 	this.memoryLimit = conf.MemoryLimit
 	this.instructionLimit = conf.InstructionLimit
 	this.outputLimit = conf.OutputLimit
+	if conf.AclResource != "" {
+		if this.acl, err = this.pConfig.AccessControl(conf.AclResource); err != nil {
+			return
+		}
+	}
 	err = os.MkdirAll(this.workingDirectory, 0700)
 	return
 }
 
+// Returns the role a control message's signer needs for its action.
+func requiredRole(action interface{}) pipeline.Role {
+	if action == "load" {
+		return pipeline.RoleDeploy
+	}
+	return pipeline.RoleOperate
+}
+
 // Adds running filters count to the report output.
 func (this *SandboxManagerFilter) ReportMsg(msg *message.Message) error {
 	message.NewIntField(msg, "RunningFilters", int(atomic.LoadInt32(&this.currentFilters)),
@@ -299,6 +317,15 @@ func (this *SandboxManagerFilter) Run(fr pipeline.FilterRunner,
 				break
 			}
 			action, _ := pack.Message.GetFieldValue("action")
+			if this.acl != nil {
+				if required := requiredRole(action); this.acl.SignerRole(pack.Signer) < required {
+					fr.UpdateCursor(pack.QueueCursor)
+					pack.Recycle(fmt.Errorf(
+						"Discarded control message: signer '%s' lacks the '%s' role",
+						pack.Signer, required))
+					break
+				}
+			}
 			switch action {
 			case "load":
 				current := int(atomic.LoadInt32(&this.currentFilters))